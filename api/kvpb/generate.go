@@ -0,0 +1,5 @@
+// Package kvpb contains the protobuf definitions and generated gRPC code of
+// the key-value store API.
+package kvpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative kv.proto
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"codesignal/internal/cluster"
+	"codesignal/internal/repository"
+)
+
+// defaultKeepLogs is the number of log entries kept behind the snapshot by
+// compact, the trailing logs Raft keeps by default.
+const defaultKeepLogs = 10240
+
+// Formats of convert.
+const (
+	formatSnapshot = "snapshot"
+	formatRaft     = "raft"
+	formatJSON     = "json"
+	formatTable    = "table"
+)
+
+// record is a key of a JSON export, in the format of kvctl export.
+type record struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// verify checks a store snapshot file or a Raft data directory. With
+// -repair, the invalid entries of a Raft log are removed and inconsistent
+// expiries dropped from a snapshot file, the original kept as a .bak file.
+func verify(_ context.Context, e *env, args []string) error {
+	flags := e.flagSet("verify")
+	dataFile := flags.String("data-file", os.Getenv("DATA_FILE"), "snapshot file or raft data dir checked (DATA_FILE)")
+	repair := flags.Bool("repair", false, "remove invalid entries so the store can start")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := *dataFile
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+	if path == "" || flags.NArg() > 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		data, err := readSnapshotFile(path)
+		if err != nil {
+			return fmt.Errorf("%w, snapshot files can't be repaired, restore a backup", err)
+		}
+		if *repair {
+			if err := repairData(e, path, data); err != nil {
+				return err
+			}
+		}
+		return verifyData(e, data)
+	}
+
+	if *repair {
+		repaired, err := cluster.RepairLog(path)
+		if err != nil {
+			return err
+		}
+		if repaired.First > 0 {
+			fmt.Fprintf(e.stdout, "repaired: removed raft log entries %d to %d, backup in %s\n", repaired.First, repaired.Last, repaired.Backup)
+		}
+	}
+
+	snapshot, err := cluster.ReadSnapshot(path)
+	if err != nil {
+		return err
+	}
+	log, err := cluster.VerifyLog(path)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "raft log: entries %d to %d, %d bytes\n", log.FirstIndex, log.LastIndex, log.Size)
+
+	// Entries after the snapshot, or all of them without one, must be in
+	// the log for the node to rebuild its state.
+	next := uint64(1)
+	if snapshot != nil {
+		next = snapshot.Meta.Index + 1
+		fmt.Fprintf(e.stdout, "raft snapshot: %s, index %d, term %d, %d nodes\n", snapshot.Meta.ID, snapshot.Meta.Index, snapshot.Meta.Term, len(snapshot.Nodes))
+	} else {
+		fmt.Fprintln(e.stdout, "raft snapshot: none")
+	}
+	if log.LastIndex > 0 && log.FirstIndex > next {
+		return fmt.Errorf("raft log is missing entries %d to %d", next, log.FirstIndex-1)
+	}
+
+	if snapshot == nil {
+		return nil
+	}
+	return verifyData(e, snapshot.Data)
+}
+
+// verifyData checks the consistency of store data and prints its summary.
+func verifyData(e *env, data repository.Data) error {
+	var errs []error
+	for key, expiresAt := range data.Expiry {
+		if _, ok := data.Store[key]; !ok {
+			errs = append(errs, fmt.Errorf("expiry of missing key %q", key))
+		}
+		if expiresAt <= 0 {
+			errs = append(errs, fmt.Errorf("invalid expiry %d of key %q", expiresAt, key))
+		}
+	}
+
+	now := time.Now().UnixNano()
+	var size, expired int
+	for key, value := range data.Store {
+		size += len(key) + len(value)
+		if expiresAt, ok := data.Expiry[key]; ok && expiresAt > 0 && expiresAt <= now {
+			expired++
+		}
+	}
+	fmt.Fprintf(e.stdout, "keys: %d, expiring: %d, expired: %d, size: %d bytes\n", len(data.Store), len(data.Expiry), expired, size)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("snapshot is inconsistent: %w", errors.Join(errs...))
+	}
+	fmt.Fprintln(e.stdout, "ok")
+	return nil
+}
+
+// repairData drops the expiries of missing keys and invalid expiries from
+// data and rewrites the snapshot file at path, keeping the original.
+func repairData(e *env, path string, data repository.Data) error {
+	var dropped int
+	for key, expiresAt := range data.Expiry {
+		if _, ok := data.Store[key]; !ok || expiresAt <= 0 {
+			delete(data.Expiry, key)
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		return nil
+	}
+
+	backup := path + ".bak"
+	if err := os.Rename(path, backup); err != nil {
+		return err
+	}
+	if err := e.writeFile(path, func(w io.Writer) error { return repository.EncodeSnapshot(w, data) }); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "repaired: dropped %d invalid expiries, backup in %s\n", dropped, backup)
+	return nil
+}
+
+func compact(_ context.Context, e *env, args []string) error {
+	flags := e.flagSet("compact")
+	keep := flags.Uint64("keep", defaultKeepLogs, "number of log entries kept before the snapshot index")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	before, after, err := cluster.CompactLog(flags.Arg(0), *keep)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stdout, "raft log: entries %d to %d, %d bytes\n", before.FirstIndex, before.LastIndex, before.Size)
+	fmt.Fprintf(e.stdout, "compacted: entries %d to %d, %d bytes\n", after.FirstIndex, after.LastIndex, after.Size)
+	return nil
+}
+
+// convert migrates data between formats: store snapshot files as written by
+// the repository, the latest snapshot of a Raft data directory, and the JSON
+// array of kvctl export and import.
+func convert(_ context.Context, e *env, args []string) error {
+	flags := e.flagSet("convert")
+	from := flags.String("from", formatSnapshot, "input format: snapshot, raft or json")
+	to := flags.String("to", formatJSON, "output format: snapshot, json or table")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+	input, output := flags.Arg(0), flags.Arg(1)
+
+	var data repository.Data
+	var err error
+	switch *from {
+	case formatSnapshot:
+		data, err = readSnapshotFile(input)
+	case formatRaft:
+		var snapshot *cluster.Snapshot
+		snapshot, err = cluster.ReadSnapshot(input)
+		if err == nil && snapshot == nil {
+			err = fmt.Errorf("no raft snapshot in %s", input)
+		}
+		if err == nil {
+			data = snapshot.Data
+		}
+	case formatJSON:
+		data, err = e.readJSON(input)
+	default:
+		err = fmt.Errorf("unknown input format %q", *from)
+	}
+	if err != nil {
+		return err
+	}
+
+	var write func(io.Writer, repository.Data) error
+	switch *to {
+	case formatSnapshot:
+		write = repository.EncodeSnapshot
+	case formatJSON:
+		write = writeJSON
+	case formatTable:
+		write = repository.EncodeTable
+	default:
+		return fmt.Errorf("unknown output format %q", *to)
+	}
+
+	if err := e.writeFile(output, func(w io.Writer) error { return write(w, data) }); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "converted %d keys\n", len(data.Store))
+	return nil
+}
+
+func readSnapshotFile(path string) (repository.Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return repository.Data{}, err
+	}
+	defer f.Close()
+	return repository.DecodeSnapshot(bufio.NewReader(f))
+}
+
+// readJSON reads a JSON export, - reading stdin. TTLs are relative to now.
+func (e *env) readJSON(path string) (repository.Data, error) {
+	r := e.stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return repository.Data{}, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var records []record
+	if err := json.NewDecoder(bufio.NewReader(r)).Decode(&records); err != nil {
+		return repository.Data{}, fmt.Errorf("invalid export, expected a JSON array of records: %w", err)
+	}
+
+	now := time.Now()
+	data := repository.Data{Store: make(map[string][]byte, len(records)), Expiry: make(map[string]int64)}
+	for _, rec := range records {
+		data.Store[rec.Key] = []byte(rec.Value)
+		if rec.TTL != "" {
+			ttl, err := time.ParseDuration(rec.TTL)
+			if err != nil || ttl <= 0 {
+				return repository.Data{}, fmt.Errorf("invalid ttl %q of %q", rec.TTL, rec.Key)
+			}
+			data.Expiry[rec.Key] = now.Add(ttl).UnixNano()
+		}
+	}
+	return data, nil
+}
+
+// writeJSON writes data as a JSON export sorted by key. Expired keys are
+// skipped, the others keep their remaining TTL.
+func writeJSON(w io.Writer, data repository.Data) error {
+	keys := make([]string, 0, len(data.Store))
+	for key := range data.Store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	records := make([]record, 0, len(keys))
+	for _, key := range keys {
+		rec := record{Key: key, Value: string(data.Store[key])}
+		if expiresAt, ok := data.Expiry[key]; ok {
+			ttl := time.Unix(0, expiresAt).Sub(now).Round(time.Second)
+			if ttl <= 0 {
+				continue
+			}
+			rec.TTL = ttl.String()
+		}
+		records = append(records, rec)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// writeFile writes the output of write to path, - writing stdout. Files are
+// written to a temporary file first so a failed conversion leaves no
+// partial output.
+func (e *env) writeFile(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		w := bufio.NewWriter(e.stdout)
+		if err := write(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
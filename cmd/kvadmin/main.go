@@ -0,0 +1,129 @@
+// Command kvadmin runs offline maintenance tasks on the data of the
+// key-value store. It works on files, nodes must be stopped first, and on
+// running servers for migrations.
+//
+// Usage:
+//
+//	kvadmin <command> [arguments]
+//
+// The commands are:
+//
+//	verify      checks a store snapshot file or a Raft data directory, and repairs it
+//	compact     deletes Raft log entries covered by the latest snapshot
+//	convert     converts between store snapshots, Raft data directories, JSON and tables
+//	migrate     copies the keys of a store to another, servers included
+//	import-rdb  loads the string keys of a Redis RDB dump
+//
+// Run "kvadmin <command> -h" for their flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errUsage reports invalid arguments, the usage was already printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "kvadmin:", err)
+		os.Exit(1)
+	}
+}
+
+// env holds what the commands read and write.
+type env struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// command is a kvadmin subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+// commands is set in init, the commands refer to it for their usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "verify", usage: "verify [-repair] [-data-file path] <snapshot file | raft data dir>", run: verify},
+		{name: "compact", usage: "compact [-keep n] <raft data dir>", run: compact},
+		{name: "convert", usage: "convert -from snapshot|raft|json -to snapshot|json|table <input> <output>", run: convert},
+		{name: "migrate", usage: "migrate [-prefix prefix] [-checkpoint file] <source> <destination>", run: migrate},
+		{name: "import-rdb", usage: "import-rdb [-db n] [-prefix prefix] <dump.rdb> <destination>", run: importRDB},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	usage := func() {
+		fmt.Fprintln(stderr, "Usage: kvadmin <command> [arguments]\n\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintln(stderr, "  "+cmd.usage)
+		}
+	}
+	if len(args) == 0 {
+		usage()
+		return errUsage
+	}
+
+	cmd, ok := lookup(args[0])
+	if !ok {
+		fmt.Fprintf(stderr, "kvadmin: unknown command %q\n", args[0])
+		usage()
+		return errUsage
+	}
+	return cmd.run(ctx, &env{stdin: stdin, stdout: stdout, stderr: stderr}, args[1:])
+}
+
+// flagSet returns the flag set of a command, printing its usage on errors.
+func (e *env) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.Usage = func() {
+		cmd, _ := lookup(name)
+		fmt.Fprintln(e.stderr, "Usage: kvadmin "+cmd.usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses the flags of a command and checks the number of its
+// positional arguments.
+func parse(flags *flag.FlagSet, args []string, nArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != nArgs {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+// kvadmin runs the command with args and returns its output.
+func kvadmin(t *testing.T, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func writeSnapshot(t *testing.T, data repository.Data) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	require.NoError(t, repository.EncodeSnapshot(&buf, data))
+	path := filepath.Join(t.TempDir(), "store.snapshot")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	return path
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+
+	t.Run("consistent snapshot", func(t *testing.T) {
+		path := writeSnapshot(t, repository.Data{
+			Store:  map[string][]byte{"a": []byte("1"), "b": []byte("2"), "c": []byte("3")},
+			Expiry: map[string]int64{"b": now.Add(time.Hour).UnixNano(), "c": now.Add(-time.Hour).UnixNano()},
+		})
+
+		out, err := kvadmin(t, "", "verify", path)
+		require.NoError(t, err)
+		assert.Equal(t, "keys: 3, expiring: 2, expired: 1, size: 6 bytes\nok\n", out)
+	})
+
+	t.Run("expiry of a missing key", func(t *testing.T) {
+		path := writeSnapshot(t, repository.Data{
+			Store:  map[string][]byte{"a": []byte("1")},
+			Expiry: map[string]int64{"b": now.UnixNano()},
+		})
+
+		_, err := kvadmin(t, "", "verify", path)
+		assert.ErrorContains(t, err, `expiry of missing key "b"`)
+	})
+
+	t.Run("repair", func(t *testing.T) {
+		path := writeSnapshot(t, repository.Data{
+			Store:  map[string][]byte{"a": []byte("1")},
+			Expiry: map[string]int64{"a": now.Add(time.Hour).UnixNano(), "b": now.UnixNano()},
+		})
+
+		out, err := kvadmin(t, "", "verify", "-repair", "-data-file", path)
+		require.NoError(t, err)
+		assert.Equal(t, "repaired: dropped 1 invalid expiries, backup in "+path+".bak\nkeys: 1, expiring: 1, expired: 0, size: 2 bytes\nok\n", out)
+		assert.FileExists(t, path+".bak")
+
+		out, err = kvadmin(t, "", "verify", path)
+		require.NoError(t, err)
+		assert.Equal(t, "keys: 1, expiring: 1, expired: 0, size: 2 bytes\nok\n", out)
+	})
+
+	t.Run("not a snapshot", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "garbage")
+		require.NoError(t, os.WriteFile(path, []byte("garbage"), 0o600))
+
+		_, err := kvadmin(t, "", "verify", path)
+		assert.ErrorContains(t, err, "decode snapshot")
+	})
+}
+
+func TestConvert(t *testing.T) {
+	now := time.Now()
+	path := writeSnapshot(t, repository.Data{
+		Store:  map[string][]byte{"user:1": []byte("alice"), "user:2": []byte("bob"), "old": []byte("x")},
+		Expiry: map[string]int64{"user:2": now.Add(time.Hour).UnixNano(), "old": now.Add(-time.Hour).UnixNano()},
+	})
+
+	export, err := kvadmin(t, "", "convert", "-from", "snapshot", "-to", "json", path, "-")
+	require.NoError(t, err)
+	assert.JSONEq(t, `[{"key":"user:1","value":"alice"},{"key":"user:2","value":"bob","ttl":"1h0m0s"}]`, export)
+
+	converted := filepath.Join(t.TempDir(), "converted.snapshot")
+	_, err = kvadmin(t, export, "convert", "-from", "json", "-to", "snapshot", "-", converted)
+	require.NoError(t, err)
+
+	data, err := readSnapshotFile(converted)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"user:1": []byte("alice"), "user:2": []byte("bob")}, data.Store)
+	require.Contains(t, data.Expiry, "user:2")
+	assert.WithinDuration(t, now.Add(time.Hour), time.Unix(0, data.Expiry["user:2"]), 5*time.Second)
+
+	table := filepath.Join(t.TempDir(), "store.table")
+	_, err = kvadmin(t, "", "convert", "-to", "table", path, table)
+	require.NoError(t, err)
+	store, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{MappedFile: table})
+	require.NoError(t, err)
+	defer store.Close()
+	items, err := store.Scan(context.Background(), "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, []byte("bob"), items[1].Value)
+	assert.WithinDuration(t, now.Add(time.Hour), items[1].ExpiresAt, time.Second)
+
+	_, err = kvadmin(t, "", "convert", "-to", "yaml", path, "-")
+	assert.ErrorContains(t, err, `unknown output format "yaml"`)
+}
+
+func TestUsage(t *testing.T) {
+	_, err := kvadmin(t, "")
+	assert.ErrorIs(t, err, errUsage)
+	_, err = kvadmin(t, "", "unknown")
+	assert.ErrorIs(t, err, errUsage)
+	_, err = kvadmin(t, "", "verify")
+	assert.ErrorIs(t, err, errUsage)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/cluster"
+	"codesignal/internal/repository"
+	"codesignal/pkg/client"
+)
+
+// migrate copies the keys of a source to a destination. Sources are store
+// snapshot files, Raft data directories and servers, destinations are
+// snapshot files and servers. Keys already on a destination server are kept,
+// so interrupted migrations can be rerun or resumed from their checkpoint.
+func migrate(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("migrate")
+	prefix := flags.String("prefix", "", "only migrate keys starting with prefix")
+	checkpoint := flags.String("checkpoint", "", "file recording the last key migrated to resume from, server destinations only")
+	batchSize := flags.Int("batch", repository.DefaultMigrateBatchSize, "number of keys copied per batch")
+	token := flags.String("token", os.Getenv("KVADMIN_TOKEN"), "bearer token sent to servers (KVADMIN_TOKEN)")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+	source, destination := flags.Arg(0), flags.Arg(1)
+
+	src, err := openSource(ctx, source, *token)
+	if err != nil {
+		return err
+	}
+
+	if *checkpoint != "" && !isServer(destination) {
+		return errors.New("checkpoints require a server destination, snapshot files are written once complete")
+	}
+	dst, err := openDestination(destination, *token)
+	if err != nil {
+		return err
+	}
+
+	opts := repository.MigrateOpts{
+		Prefix:    *prefix,
+		BatchSize: *batchSize,
+		Progress: func(p repository.MigrateProgress) error {
+			fmt.Fprintf(e.stderr, "migrated %d keys, %d expired, last key %q\n", p.Migrated, p.Expired, p.Last)
+			if *checkpoint == "" {
+				return nil
+			}
+			return e.writeFile(*checkpoint, func(w io.Writer) error {
+				_, err := io.WriteString(w, p.Last)
+				return err
+			})
+		},
+	}
+	if *checkpoint != "" {
+		b, err := os.ReadFile(*checkpoint)
+		switch {
+		case err == nil:
+			opts.After = string(b)
+			fmt.Fprintf(e.stderr, "resuming after key %q\n", opts.After)
+		case !errors.Is(err, os.ErrNotExist):
+			return err
+		}
+	}
+
+	progress, err := repository.Migrate(ctx, src, dst, opts)
+	if err != nil {
+		return err
+	}
+	if err := dst.flush(ctx, e); err != nil {
+		return err
+	}
+	if *checkpoint != "" {
+		if err := os.Remove(*checkpoint); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	fmt.Fprintf(e.stdout, "migrated %d keys, %d expired during the migration\n", progress.Migrated, progress.Expired)
+	return nil
+}
+
+// openSource returns the store of a migration source: a server URL, a Raft
+// data directory or a snapshot file. Files are loaded in memory.
+func openSource(ctx context.Context, location, token string) (repository.Scanner, error) {
+	if isServer(location) {
+		return newServerStore(location, token)
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	var data repository.Data
+	if info.IsDir() {
+		snapshot, err := cluster.ReadSnapshot(location)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot == nil {
+			return nil, fmt.Errorf("no raft snapshot in %s", location)
+		}
+		data = snapshot.Data
+	} else if data, err = readSnapshotFile(location); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := repository.EncodeSnapshot(&buf, data); err != nil {
+		return nil, err
+	}
+	store, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	if err != nil {
+		return nil, err
+	}
+	return store, store.Restore(ctx, &buf)
+}
+
+// destination is where keys are migrated to. Snapshot files are written
+// once complete by flush, servers as keys are copied.
+type destination struct {
+	repository.Writer
+	// snapshot holds the keys of a snapshot file destination.
+	snapshot *repository.KeyValueStore
+	path     string
+}
+
+func openDestination(location, token string) (*destination, error) {
+	if isServer(location) {
+		s, err := newServerStore(location, token)
+		if err != nil {
+			return nil, err
+		}
+		return &destination{Writer: s}, nil
+	}
+
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		return nil, errors.New("raft data directories can't be written, migrate to a server of the cluster instead")
+	}
+	snapshot, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	if err != nil {
+		return nil, err
+	}
+	return &destination{Writer: snapshot, snapshot: snapshot, path: location}, nil
+}
+
+// flush writes the snapshot file of the destination.
+func (d *destination) flush(ctx context.Context, e *env) error {
+	if d.snapshot == nil {
+		return nil
+	}
+	return e.writeFile(d.path, func(w io.Writer) error { return d.snapshot.Snapshot(ctx, w) })
+}
+
+func isServer(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// serverStore migrates keys from and to a server through its HTTP API.
+type serverStore struct {
+	client *client.Client
+}
+
+func newServerStore(addr, token string) (*serverStore, error) {
+	opts := client.Opts{}
+	if token != "" {
+		opts.Header = http.Header{"Authorization": {"Bearer " + token}}
+	}
+	c, err := client.New(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &serverStore{client: c}, nil
+}
+
+// Scan implements repository.Scanner.
+func (s *serverStore) Scan(ctx context.Context, prefix, after string, limit int) ([]repository.Item, error) {
+	page, err := s.client.List(ctx, prefix, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	items := make([]repository.Item, 0, len(page.Items))
+	for _, kv := range page.Items {
+		item := repository.Item{Key: kv.Key, Value: []byte(kv.Value)}
+		if kv.TTL > 0 {
+			item.ExpiresAt = now.Add(kv.TTL)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// SetWithTTL implements repository.Writer. Existing keys are kept, they were
+// migrated by an earlier run or written since.
+func (s *serverStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := s.client.Set(ctx, key, string(value), ttl)
+	if errors.Is(err, client.ErrKeyExists) {
+		return nil
+	}
+	return err
+}
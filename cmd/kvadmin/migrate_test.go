@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+)
+
+func newServer(t *testing.T) (*repository.KeyValueStore, string) {
+	t.Helper()
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	srv := httptest.NewServer(router.New(zerolog.Nop(), repo, &config.Config{}, router.Opts{}))
+	t.Cleanup(srv.Close)
+	return repo, srv.URL
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	path := writeSnapshot(t, repository.Data{
+		Store: map[string][]byte{
+			"user:1": []byte("alice"), "user:2": []byte("bob"), "user:3": []byte("carol"), "order:1": []byte("x"),
+		},
+		Expiry: map[string]int64{"user:2": time.Now().Add(time.Hour).UnixNano()},
+	})
+
+	repo, addr := newServer(t)
+	require.NoError(t, repo.Set(ctx, "user:1", []byte("existing")))
+
+	out, err := kvadmin(t, "", "migrate", "-prefix", "user:", "-batch", "2", path, addr)
+	require.NoError(t, err)
+	assert.Equal(t, "migrated 3 keys, 0 expired during the migration\n", out)
+
+	items, err := repo.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 3)
+	assert.Equal(t, []byte("existing"), items[0].Value, "existing keys are kept")
+	assert.Equal(t, []byte("bob"), items[1].Value)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), items[1].ExpiresAt, 2*time.Second)
+
+	t.Run("resumes from the checkpoint", func(t *testing.T) {
+		repo, addr := newServer(t)
+		checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+		require.NoError(t, os.WriteFile(checkpoint, []byte("user:1"), 0o600))
+
+		_, err := kvadmin(t, "", "migrate", "-prefix", "user:", "-checkpoint", checkpoint, path, addr)
+		require.NoError(t, err)
+
+		items, err := repo.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, items, 2)
+		assert.Equal(t, "user:2", items[0].Key)
+		assert.NoFileExists(t, checkpoint, "the checkpoint is removed once complete")
+	})
+
+	t.Run("server to snapshot", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "store.snapshot")
+		_, err := kvadmin(t, "", "migrate", addr, dst)
+		require.NoError(t, err)
+
+		data, err := readSnapshotFile(dst)
+		require.NoError(t, err)
+		assert.Len(t, data.Store, 3)
+		assert.Contains(t, data.Expiry, "user:2")
+
+		_, err = kvadmin(t, "", "migrate", "-checkpoint", "c", addr, dst)
+		assert.ErrorContains(t, err, "checkpoints require a server destination")
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/rdb"
+	"codesignal/internal/repository"
+)
+
+// importRDB loads the string keys of a Redis RDB dump with their TTLs into
+// a server or a snapshot file. Keys of other types are skipped and reported.
+func importRDB(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("import-rdb")
+	db := flags.Int("db", 0, "Redis database imported")
+	prefix := flags.String("prefix", "", "prefix added to the imported keys")
+	token := flags.String("token", os.Getenv("KVADMIN_TOKEN"), "bearer token sent to servers (KVADMIN_TOKEN)")
+	if err := parse(flags, args, 2); err != nil {
+		return err
+	}
+
+	dst, err := openDestination(flags.Arg(1), *token)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The dump is loaded in memory first, so a corrupted file imports
+	// nothing and the keys are written in order like migrations.
+	keys, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	if err != nil {
+		return err
+	}
+	var expired, otherDBs int
+	now := time.Now()
+	stats, err := rdb.Decode(f, func(entry rdb.Entry) error {
+		var ttl time.Duration
+		switch {
+		case entry.DB != *db:
+			otherDBs++
+			return nil
+		case entry.ExpiresAt.IsZero():
+		case !entry.ExpiresAt.After(now):
+			expired++
+			return nil
+		default:
+			ttl = entry.ExpiresAt.Sub(now)
+		}
+		return keys.SetWithTTL(ctx, *prefix+entry.Key, entry.Value, ttl)
+	})
+	if err != nil {
+		return fmt.Errorf("read rdb file: %w", err)
+	}
+
+	progress, err := repository.Migrate(ctx, keys, dst, repository.MigrateOpts{
+		Progress: func(p repository.MigrateProgress) error {
+			fmt.Fprintf(e.stderr, "imported %d keys\n", p.Migrated)
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := dst.flush(ctx, e); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(e.stdout, "imported %d keys, %d expired, %d in other databases\n", progress.Migrated, expired+progress.Expired, otherDBs)
+	if len(stats.Skipped) > 0 {
+		types := make([]string, 0, len(stats.Skipped))
+		for typ, n := range stats.Skipped {
+			types = append(types, fmt.Sprintf("%d %s", n, typ))
+		}
+		sort.Strings(types)
+		fmt.Fprintf(e.stdout, "skipped keys of unsupported types: %s\n", strings.Join(types, ", "))
+	}
+	return nil
+}
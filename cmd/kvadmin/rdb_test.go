@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportRDB(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	expired := time.Now().Add(-time.Hour)
+
+	// Database 0 holds two live strings, an expired one and a list,
+	// database 1 another string. The checksum is disabled.
+	dump := []byte("REDIS0011")
+	dump = append(dump, 0xFE, 0)
+	dump = append(dump, 0, 6, 'u', 's', 'e', 'r', ':', '1', 5, 'a', 'l', 'i', 'c', 'e')
+	dump = append(dump, 0xFC)
+	dump = binary.LittleEndian.AppendUint64(dump, uint64(expiresAt.UnixMilli()))
+	dump = append(dump, 0, 6, 'u', 's', 'e', 'r', ':', '2', 3, 'b', 'o', 'b')
+	dump = append(dump, 0xFC)
+	dump = binary.LittleEndian.AppendUint64(dump, uint64(expired.UnixMilli()))
+	dump = append(dump, 0, 3, 'o', 'l', 'd', 1, 'x')
+	dump = append(dump, 1, 4, 'l', 'i', 's', 't', 1, 1, 'x')
+	dump = append(dump, 0xFE, 1)
+	dump = append(dump, 0, 5, 'o', 't', 'h', 'e', 'r', 1, 'y')
+	dump = append(dump, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0)
+
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	require.NoError(t, os.WriteFile(path, dump, 0o600))
+
+	repo, addr := newServer(t)
+	out, err := kvadmin(t, "", "import-rdb", "-prefix", "redis:", path, addr)
+	require.NoError(t, err)
+	assert.Equal(t, "imported 2 keys, 1 expired, 1 in other databases\nskipped keys of unsupported types: 1 list\n", out)
+
+	items, err := repo.Scan(context.Background(), "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "redis:user:1", items[0].Key)
+	assert.Equal(t, []byte("alice"), items[0].Value)
+	assert.WithinDuration(t, expiresAt, items[1].ExpiresAt, 2*time.Second)
+
+	snapshot := filepath.Join(t.TempDir(), "store.snapshot")
+	_, err = kvadmin(t, "", "import-rdb", "-db", "1", path, snapshot)
+	require.NoError(t, err)
+	data, err := readSnapshotFile(snapshot)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"other": []byte("y")}, data.Store)
+
+	require.NoError(t, os.WriteFile(path, dump[:20], 0o600))
+	_, err = kvadmin(t, "", "import-rdb", path, snapshot)
+	assert.ErrorContains(t, err, "read rdb file")
+}
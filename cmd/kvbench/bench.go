@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"codesignal/pkg/client"
+)
+
+// Key distributions.
+const (
+	distUniform    = "uniform"
+	distZipf       = "zipf"
+	distSequential = "sequential"
+)
+
+// preloadBatchSize is the number of keys created per batch by preload.
+const preloadBatchSize = 1000
+
+// op is an operation of the benchmark.
+type op int
+
+const (
+	opGet op = iota
+	opSet
+	opDel
+	numOps
+)
+
+var opNames = [numOps]string{"get", "set", "del"}
+
+// workload configures a run.
+type workload struct {
+	Concurrency int
+	Duration    time.Duration
+	// Requests ends the run after that many requests when positive.
+	Requests int
+	// Mix holds the weight of each operation.
+	Mix      [numOps]int
+	Keys     int
+	Prefix   string
+	Dist     string
+	ZipfS    float64
+	MinValue int
+	MaxValue int
+	TTL      time.Duration
+	Preload  bool
+	Timeout  time.Duration
+}
+
+func (c workload) validate() error {
+	switch {
+	case c.Concurrency < 1:
+		return errors.New("concurrency must be at least 1")
+	case c.Duration <= 0 && c.Requests <= 0:
+		return errors.New("either a duration or a number of requests is required")
+	case c.Keys < 1:
+		return errors.New("key space must hold at least 1 key")
+	case c.Dist != distUniform && c.Dist != distZipf && c.Dist != distSequential:
+		return fmt.Errorf("unknown key distribution %q", c.Dist)
+	case c.Dist == distZipf && c.ZipfS <= 1:
+		return errors.New("zipf exponent must be greater than 1")
+	case c.Timeout <= 0:
+		return errors.New("request timeout must be positive")
+	}
+	return nil
+}
+
+// parseMix parses operation weights such as get=80,set=15,del=5. Omitted
+// operations are not run.
+func parseMix(s string) ([numOps]int, error) {
+	var mix [numOps]int
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		w, err := strconv.Atoi(weight)
+		if !ok || err != nil || w < 0 {
+			return mix, fmt.Errorf("invalid mix %q, expected op=weight pairs such as get=80,set=20", s)
+		}
+		found := false
+		for o, opName := range opNames {
+			if name == opName {
+				mix[o] = w
+				found = true
+			}
+		}
+		if !found {
+			return mix, fmt.Errorf("unknown operation %q in mix, expected get, set or del", name)
+		}
+		total += w
+	}
+	if total == 0 {
+		return mix, errors.New("mix must have a positive weight")
+	}
+	return mix, nil
+}
+
+// parseSize parses a value size, either fixed or a min-max range.
+func parseSize(s string) (minSize, maxSize int, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if minSize, err = strconv.Atoi(lo); err == nil {
+		maxSize = minSize
+		if isRange {
+			maxSize, err = strconv.Atoi(hi)
+		}
+	}
+	if err != nil || minSize < 0 || maxSize < minSize {
+		return 0, 0, fmt.Errorf("invalid value size %q, expected a size or a min-max range", s)
+	}
+	return minSize, maxSize, nil
+}
+
+// keyGen draws keys from the key space of a run, one per worker.
+type keyGen struct {
+	cfg  workload
+	rand *rand.Rand
+	zipf *rand.Zipf
+	// seq is shared by the workers, so sequential runs visit every key in
+	// turn.
+	seq *atomic.Uint64
+}
+
+func newKeyGen(cfg workload, seed int64, seq *atomic.Uint64) *keyGen {
+	g := &keyGen{cfg: cfg, rand: rand.New(rand.NewSource(seed)), seq: seq}
+	if cfg.Dist == distZipf {
+		g.zipf = rand.NewZipf(g.rand, cfg.ZipfS, 1, uint64(cfg.Keys-1))
+	}
+	return g
+}
+
+func (g *keyGen) key() string {
+	var n uint64
+	switch g.cfg.Dist {
+	case distZipf:
+		n = g.zipf.Uint64()
+	case distSequential:
+		n = (g.seq.Add(1) - 1) % uint64(g.cfg.Keys)
+	default:
+		n = uint64(g.rand.Intn(g.cfg.Keys))
+	}
+	return g.cfg.Prefix + strconv.FormatUint(n, 10)
+}
+
+func (g *keyGen) op() op {
+	total := 0
+	for _, w := range g.cfg.Mix {
+		total += w
+	}
+	n := g.rand.Intn(total)
+	for o, w := range g.cfg.Mix {
+		if n < w {
+			return op(o)
+		}
+		n -= w
+	}
+	return opGet
+}
+
+// values returns a value source of the configured sizes.
+func values(cfg workload) func(*rand.Rand) string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, cfg.MaxValue)
+	for i := range b {
+		b[i] = letters[i%len(letters)]
+	}
+	s := string(b)
+	return func(r *rand.Rand) string {
+		return s[:cfg.MinValue+r.Intn(cfg.MaxValue-cfg.MinValue+1)]
+	}
+}
+
+// stats holds the outcome of requests.
+type stats struct {
+	elapsed time.Duration
+	// latencies of the requests that got a response, misses included.
+	latencies [numOps][]time.Duration
+	// misses counts gets and deletes of missing keys and sets of existing
+	// ones, expected with a random mix.
+	misses   [numOps]int
+	errors   [numOps]int
+	firstErr error
+}
+
+func (s *stats) record(o op, latency time.Duration, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, client.ErrKeyNotFound), errors.Is(err, client.ErrKeyExists):
+		s.misses[o]++
+	default:
+		s.errors[o]++
+		if s.firstErr == nil {
+			s.firstErr = err
+		}
+		return
+	}
+	s.latencies[o] = append(s.latencies[o], latency)
+}
+
+func (s *stats) merge(other *stats) {
+	for o := op(0); o < numOps; o++ {
+		s.latencies[o] = append(s.latencies[o], other.latencies[o]...)
+		s.misses[o] += other.misses[o]
+		s.errors[o] += other.errors[o]
+	}
+	if s.firstErr == nil {
+		s.firstErr = other.firstErr
+	}
+}
+
+// bench runs the workers until the duration elapses, the number of requests
+// is reached or ctx is done.
+func bench(ctx context.Context, c *client.Client, cfg workload) *stats {
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		total   = &stats{}
+		seq     atomic.Uint64
+		issued  atomic.Int64
+		value   = values(cfg)
+		started = time.Now()
+	)
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			gen := newKeyGen(cfg, seed, &seq)
+			s := &stats{}
+			for ctx.Err() == nil {
+				if cfg.Requests > 0 && issued.Add(1) > int64(cfg.Requests) {
+					break
+				}
+				o, key := gen.op(), gen.key()
+				reqCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+				start := time.Now()
+				var err error
+				switch o {
+				case opGet:
+					_, err = c.Get(reqCtx, key)
+				case opSet:
+					err = c.Set(reqCtx, key, value(gen.rand), cfg.TTL)
+				case opDel:
+					err = c.Delete(reqCtx, key)
+				}
+				latency := time.Since(start)
+				cancel()
+				if ctx.Err() != nil {
+					// Interrupted by the end of the run.
+					break
+				}
+				s.record(o, latency, err)
+			}
+			mu.Lock()
+			total.merge(s)
+			mu.Unlock()
+		}(started.UnixNano() + int64(i))
+	}
+	wg.Wait()
+	total.elapsed = time.Since(started)
+	return total
+}
+
+// preload creates every key of the key space, existing keys are kept.
+func preload(ctx context.Context, c *client.Client, cfg workload) error {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	value := values(cfg)
+	batch := make([]client.Op, 0, preloadBatchSize)
+	for n := 0; n < cfg.Keys; n++ {
+		batch = append(batch, client.Op{Type: client.OpSet, Key: cfg.Prefix + strconv.Itoa(n), Value: value(r), TTL: cfg.TTL})
+		if len(batch) < cap(batch) && n < cfg.Keys-1 {
+			continue
+		}
+		for _, res := range c.Batch(ctx, batch) {
+			if res.Err != nil && !errors.Is(res.Err, client.ErrKeyExists) {
+				return fmt.Errorf("preload %s: %w", res.Key, res.Err)
+			}
+		}
+		batch = batch[:0]
+	}
+	return nil
+}
+
+// report writes the throughput and latency percentiles of each operation.
+func (s *stats) report(w io.Writer) error {
+	var all []time.Duration
+	var requests, misses, errs int
+	for o := op(0); o < numOps; o++ {
+		all = append(all, s.latencies[o]...)
+		requests += len(s.latencies[o]) + s.errors[o]
+		misses += s.misses[o]
+		errs += s.errors[o]
+	}
+	seconds := s.elapsed.Seconds()
+
+	fmt.Fprintf(w, "duration: %v, requests: %d, throughput: %.1f req/s, errors: %d\n\n",
+		s.elapsed.Round(time.Millisecond), requests, float64(requests)/seconds, errs)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\treq/s\tmisses\terrors\tp50\tp90\tp99\tp99.9\tmax\t")
+	row := func(name string, latencies []time.Duration, misses, errs int) {
+		n := len(latencies) + errs
+		if n == 0 {
+			return
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%d\t%d\t%v\t%v\t%v\t%v\t%v\t\n", name, n, float64(n)/seconds, misses, errs,
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99),
+			percentile(latencies, 99.9), percentile(latencies, 100))
+	}
+	for o := op(0); o < numOps; o++ {
+		row(opNames[o], s.latencies[o], s.misses[o], s.errors[o])
+	}
+	row("all", all, misses, errs)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if s.firstErr != nil {
+		fmt.Fprintf(w, "\nfirst error: %v\n", s.firstErr)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted latencies,
+// rounded to the microsecond.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	// The epsilon keeps rounding errors, as in 99.9% of 1000, off the next
+	// rank.
+	rank := int(math.Ceil(p*float64(len(sorted))/100 - 1e-9))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Round(time.Microsecond)
+}
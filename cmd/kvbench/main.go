@@ -0,0 +1,123 @@
+// Command kvbench generates load against a running key-value store server
+// and reports the throughput and latency percentiles of each operation.
+//
+// Usage:
+//
+//	kvbench [flags]
+//
+// Workers send get, set and delete requests in the proportions of -mix on
+// keys drawn from a key space of -keys keys, uniformly, sequentially or
+// following a Zipf distribution. Run "kvbench -h" for the flags.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"codesignal/pkg/client"
+)
+
+const defaultAddr = "http://localhost:8081"
+
+// errUsage reports invalid arguments, the usage was already printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "kvbench:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("kvbench", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", envOr("KVBENCH_ADDR", defaultAddr), "server URL (KVBENCH_ADDR)")
+	token := flags.String("token", os.Getenv("KVBENCH_TOKEN"), "bearer token sent to the server (KVBENCH_TOKEN)")
+	mix := flags.String("mix", "get=80,set=15,del=5", "weights of the operations")
+	dist := flags.String("dist", distUniform, "key distribution: uniform, zipf or sequential")
+	zipfS := flags.Float64("zipf-s", 1.1, "exponent of the zipf distribution, greater than 1")
+	valueSize := flags.String("value-size", "128", "value size in bytes, or a min-max range")
+
+	cfg := workload{}
+	flags.IntVar(&cfg.Concurrency, "c", 16, "number of concurrent workers")
+	flags.DurationVar(&cfg.Duration, "d", 10*time.Second, "duration of the run")
+	flags.IntVar(&cfg.Requests, "n", 0, "total number of requests, ends the run before -d if set")
+	flags.IntVar(&cfg.Keys, "keys", 10000, "size of the key space")
+	flags.StringVar(&cfg.Prefix, "prefix", "bench:", "prefix of the keys")
+	flags.DurationVar(&cfg.TTL, "ttl", 0, "time-to-live of the keys set, 0 never expires")
+	flags.BoolVar(&cfg.Preload, "preload", false, "create every key of the key space before the run")
+	flags.DurationVar(&cfg.Timeout, "timeout", 5*time.Second, "timeout of each request")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: kvbench [flags]\n\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	var err error
+	if cfg.Mix, err = parseMix(*mix); err != nil {
+		return err
+	}
+	if cfg.MinValue, cfg.MaxValue, err = parseSize(*valueSize); err != nil {
+		return err
+	}
+	cfg.Dist, cfg.ZipfS = *dist, *zipfS
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	// Requests are not retried, retries would hide errors in the latencies.
+	opts := client.Opts{
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: cfg.Concurrency,
+		}},
+		MaxRetries:       -1,
+		BatchConcurrency: cfg.Concurrency,
+	}
+	if *token != "" {
+		opts.Header = http.Header{"Authorization": {"Bearer " + *token}}
+	}
+	c, err := client.New(*addr, opts)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Preload {
+		fmt.Fprintf(stderr, "preloading %d keys\n", cfg.Keys)
+		if err := preload(ctx, c, cfg); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stderr, "running %d workers against %s\n", cfg.Concurrency, *addr)
+	return bench(ctx, c, cfg).report(stdout)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+)
+
+func TestKvbench(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	srv := httptest.NewServer(router.New(zerolog.Nop(), repo, &config.Config{}, router.Opts{}))
+	t.Cleanup(srv.Close)
+
+	var stdout, stderr bytes.Buffer
+	err = run(context.Background(), []string{
+		"-addr", srv.URL, "-n", "200", "-c", "4", "-keys", "50", "-preload",
+		"-dist", "zipf", "-mix", "get=50,set=50", "-value-size", "10-20",
+	}, &stdout, &stderr)
+	require.NoError(t, err, stderr.String())
+
+	out := stdout.String()
+	assert.Contains(t, out, "requests: 200,")
+	assert.Contains(t, out, "errors: 0")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var ops []string
+	for _, line := range lines[2:] {
+		ops = append(ops, strings.Fields(line)[0])
+	}
+	assert.Equal(t, []string{"op", "get", "set", "all"}, ops)
+
+	keys, err := repo.Scan(context.Background(), "bench:", "", 100)
+	require.NoError(t, err)
+	assert.Len(t, keys, 50)
+}
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		mix     string
+		want    [numOps]int
+		wantErr string
+	}{
+		{mix: "get=80,set=15,del=5", want: [numOps]int{80, 15, 5}},
+		{mix: "set=1", want: [numOps]int{0, 1, 0}},
+		{mix: "get=0", wantErr: "positive weight"},
+		{mix: "put=1", wantErr: `unknown operation "put"`},
+		{mix: "get", wantErr: "invalid mix"},
+		{mix: "get=-1", wantErr: "invalid mix"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mix, func(t *testing.T) {
+			got, err := parseMix(tt.mix)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	lo, hi, err := parseSize("128")
+	require.NoError(t, err)
+	assert.Equal(t, []int{128, 128}, []int{lo, hi})
+
+	lo, hi, err = parseSize("64-1024")
+	require.NoError(t, err)
+	assert.Equal(t, []int{64, 1024}, []int{lo, hi})
+
+	for _, s := range []string{"", "x", "10-5", "-1", "1-"} {
+		_, _, err := parseSize(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var latencies []time.Duration
+	for i := 1; i <= 1000; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	assert.Equal(t, 500*time.Millisecond, percentile(latencies, 50))
+	assert.Equal(t, 990*time.Millisecond, percentile(latencies, 99))
+	assert.Equal(t, 999*time.Millisecond, percentile(latencies, 99.9))
+	assert.Equal(t, time.Second, percentile(latencies, 100))
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"codesignal/pkg/client"
+)
+
+const (
+	// pageSize is the number of keys fetched per request by list and export.
+	pageSize = 1000
+	// importBatchSize is the number of keys created per batch by import,
+	// each batch runs within the request timeout.
+	importBatchSize = 100
+)
+
+// record is a key of an export, its time-to-live empty when it doesn't
+// expire.
+type record struct {
+	Key, Value, TTL string
+}
+
+func get(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("get")
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.request(ctx)
+	defer cancel()
+	value, err := e.client.Get(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout, value)
+	return err
+}
+
+func set(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("set")
+	ttl := flags.Duration("ttl", 0, "time-to-live of the key, such as 30s")
+	if err := parse(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	value := flags.Arg(1)
+	if flags.NArg() == 1 {
+		b, err := io.ReadAll(e.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read value: %w", err)
+		}
+		value = string(b)
+	}
+
+	ctx, cancel := e.request(ctx)
+	defer cancel()
+	return e.client.Set(ctx, flags.Arg(0), value, *ttl)
+}
+
+func del(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("del")
+	if err := parse(flags, args, 1, -1); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, key := range flags.Args() {
+		ctx, cancel := e.request(ctx)
+		err := e.client.Delete(ctx, key)
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// dump prints the serialized value of a key, base64 encoded, for restore.
+func dump(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("dump")
+	if err := parse(flags, args, 1, 1); err != nil {
+		return err
+	}
+
+	ctx, cancel := e.request(ctx)
+	defer cancel()
+	payload, err := e.client.Dump(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(e.stdout, base64.StdEncoding.EncodeToString(payload))
+	return err
+}
+
+// restore creates a key from a payload printed by dump.
+func restore(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("restore")
+	ttl := flags.Duration("ttl", 0, "time-to-live of the key, such as 30s")
+	replace := flags.Bool("replace", false, "replace the key if it exists")
+	if err := parse(flags, args, 1, 2); err != nil {
+		return err
+	}
+
+	encoded := flags.Arg(1)
+	if flags.NArg() == 1 {
+		b, err := io.ReadAll(e.stdin)
+		if err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
+		}
+		encoded = string(b)
+	}
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return fmt.Errorf("invalid payload, expected base64: %w", err)
+	}
+
+	ctx, cancel := e.request(ctx)
+	defer cancel()
+	return e.client.Restore(ctx, flags.Arg(0), payload, *ttl, *replace)
+}
+
+func list(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("list")
+	prefix := flags.String("prefix", "", "only list keys starting with prefix")
+	limit := flags.Int("limit", 0, "maximum number of keys listed, 0 lists every key")
+	values := flags.Bool("values", false, "print values next to keys, separated by a tab")
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(e.stdout)
+	listed := 0
+	err := e.scan(ctx, *prefix, func(kv client.KeyValue) (bool, error) {
+		var err error
+		if *values {
+			_, err = fmt.Fprintf(out, "%s\t%s\n", kv.Key, kv.Value)
+		} else {
+			_, err = fmt.Fprintln(out, kv.Key)
+		}
+		listed++
+		return *limit <= 0 || listed < *limit, err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// export writes the keys as records, keeping the remaining time-to-live of
+// expiring keys: a JSON array by default, or NDJSON or CSV.
+func export(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("export")
+	prefix := flags.String("prefix", "", "only export keys starting with prefix")
+	output := flags.String("o", "-", "output file, - for stdout")
+	format := flags.String("format", "", "json, ndjson or csv, from the extension of the output file when omitted")
+	mapping := fieldFlags(flags)
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	if err := mapping.validate(); err != nil {
+		return err
+	}
+	fileFormat, err := formatOf(*format, *output)
+	if err != nil {
+		return err
+	}
+
+	w := e.stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	out, err := newRecordWriter(w, fileFormat, mapping)
+	if err != nil {
+		return err
+	}
+	exported := 0
+	err = e.scan(ctx, *prefix, func(kv client.KeyValue) (bool, error) {
+		rec := record{Key: kv.Key, Value: kv.Value}
+		if kv.TTL > 0 {
+			rec.TTL = kv.TTL.String()
+		}
+		exported++
+		return true, out.write(rec)
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.stderr, "exported %d keys\n", exported)
+	return nil
+}
+
+// importKeys creates the keys of an export, or of the records of another
+// tool read through the field flags. Keys that already exist are left
+// unchanged and reported as skipped.
+func importKeys(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("import")
+	input := flags.String("i", "-", "input file, - for stdin")
+	format := flags.String("format", "", "json, ndjson or csv, from the extension of the input file when omitted")
+	mapping := fieldFlags(flags)
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+	if err := mapping.validate(); err != nil {
+		return err
+	}
+	fileFormat, err := formatOf(*format, *input)
+	if err != nil {
+		return err
+	}
+
+	r := e.stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	in, err := newRecordReader(r, fileFormat, mapping)
+	if err != nil {
+		return err
+	}
+
+	var created, skipped int
+	var errs []error
+	batch := make([]client.Op, 0, importBatchSize)
+	flush := func() {
+		ctx, cancel := e.request(ctx)
+		defer cancel()
+		for _, res := range e.client.Batch(ctx, batch) {
+			switch {
+			case res.Err == nil:
+				created++
+			case errors.Is(res.Err, client.ErrKeyExists):
+				skipped++
+			default:
+				errs = append(errs, fmt.Errorf("%s: %w", res.Key, res.Err))
+			}
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		rec, err := in.read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := parseTTL(rec.TTL)
+		if err != nil {
+			return fmt.Errorf("%s: %w", rec.Key, err)
+		}
+		batch = append(batch, client.Op{Type: client.OpSet, Key: rec.Key, Value: rec.Value, TTL: ttl})
+		if len(batch) == cap(batch) {
+			flush()
+		}
+	}
+	if len(batch) > 0 {
+		flush()
+	}
+
+	fmt.Fprintf(e.stderr, "imported %d keys, skipped %d existing, %d failed\n", created, skipped, len(errs))
+	return errors.Join(errs...)
+}
+
+func watch(ctx context.Context, e *env, args []string) error {
+	flags := e.flagSet("watch")
+	prefix := flags.String("prefix", "", "only watch keys starting with prefix")
+	if err := parse(flags, args, 0, 0); err != nil {
+		return err
+	}
+
+	w, err := e.client.Watch(ctx, *prefix)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	for ev := range w.Events() {
+		line := []string{ev.Time.Format(time.RFC3339), string(ev.Type), ev.Key}
+		if ev.Type == client.EventSet {
+			line = append(line, ev.Value)
+		}
+		if _, err := fmt.Fprintln(e.stdout, strings.Join(line, "\t")); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		// Interrupted.
+		return nil
+	}
+	return w.Err()
+}
+
+// scan calls fn with every key starting with prefix, until fn returns
+// false.
+func (e *env) scan(ctx context.Context, prefix string, fn func(client.KeyValue) (bool, error)) error {
+	after := ""
+	for {
+		reqCtx, cancel := e.request(ctx)
+		page, err := e.client.List(reqCtx, prefix, after, pageSize)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.Items {
+			more, err := fn(kv)
+			if err != nil || !more {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		after = page.Next
+	}
+}
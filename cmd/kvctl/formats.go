@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the files written by export and read by import.
+const (
+	// formatJSON is a JSON array of records.
+	formatJSON = "json"
+	// formatNDJSON is a record per line.
+	formatNDJSON = "ndjson"
+	// formatCSV is a header row naming the columns, then a record per row.
+	formatCSV = "csv"
+)
+
+// formatOf returns format, or the format of the extension of file when
+// empty, JSON by default.
+func formatOf(format, file string) (string, error) {
+	switch format {
+	case formatJSON, formatNDJSON, formatCSV:
+		return format, nil
+	case "":
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".ndjson", ".jsonl":
+			return formatNDJSON, nil
+		case ".csv":
+			return formatCSV, nil
+		}
+		return formatJSON, nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected json, ndjson or csv", format)
+}
+
+// fields names the fields of the records in files, the properties of JSON
+// objects or the columns of CSV files, so the files of other tools can be
+// read and written.
+type fields struct {
+	key, value string
+	// ttl is empty to leave the time-to-live out.
+	ttl string
+}
+
+// fieldFlags registers the flags naming the fields of records.
+func fieldFlags(flags *flag.FlagSet) *fields {
+	f := &fields{}
+	flags.StringVar(&f.key, "key-field", "key", "name of the field holding the key")
+	flags.StringVar(&f.value, "value-field", "value", "name of the field holding the value")
+	flags.StringVar(&f.ttl, "ttl-field", "ttl", "name of the field holding the time-to-live, empty to leave it out")
+	return f
+}
+
+func (f *fields) validate() error {
+	if f.key == "" || f.value == "" {
+		return errors.New("the key and value fields must not be empty")
+	}
+	if f.key == f.value || f.key == f.ttl || f.value == f.ttl {
+		return errors.New("the key, value and ttl fields must differ")
+	}
+	return nil
+}
+
+// names returns the names of the fields written.
+func (f *fields) names() []string {
+	if f.ttl == "" {
+		return []string{f.key, f.value}
+	}
+	return []string{f.key, f.value, f.ttl}
+}
+
+// parseTTL parses a time-to-live, a duration such as 1h30m or a number of
+// seconds, empty for none.
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ttl, err := time.ParseDuration(s); err == nil {
+		return ttl, nil
+	}
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q, expected a duration or a number of seconds", s)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// recordWriter writes the records of an export.
+type recordWriter interface {
+	write(rec record) error
+	// close ends the file and flushes it.
+	close() error
+}
+
+// newRecordWriter returns the writer of records to w in format.
+func newRecordWriter(w io.Writer, format string, f *fields) (recordWriter, error) {
+	out := bufio.NewWriter(w)
+	if format == formatCSV {
+		cw := csv.NewWriter(out)
+		if err := cw.Write(f.names()); err != nil {
+			return nil, err
+		}
+		return &csvWriter{out: out, w: cw, f: f}, nil
+	}
+	jw := &jsonWriter{out: out, f: f, array: format == formatJSON}
+	if jw.array {
+		if _, err := out.WriteString("["); err != nil {
+			return nil, err
+		}
+	}
+	return jw, nil
+}
+
+// jsonWriter writes records as JSON objects, in an array or one per line.
+type jsonWriter struct {
+	out   *bufio.Writer
+	f     *fields
+	array bool
+	n     int
+}
+
+func (w *jsonWriter) write(rec record) error {
+	sep := "\n"
+	if w.array && w.n > 0 {
+		sep = ",\n"
+	}
+	if w.array || w.n > 0 {
+		if _, err := w.out.WriteString(sep); err != nil {
+			return err
+		}
+	}
+	w.n++
+
+	// The fields are written in order, the key first.
+	obj := [][2]string{{w.f.key, rec.Key}, {w.f.value, rec.Value}}
+	if w.f.ttl != "" && rec.TTL != "" {
+		obj = append(obj, [2]string{w.f.ttl, rec.TTL})
+	}
+	buf := []byte{'{'}
+	for i, field := range obj {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		// Strings always marshal.
+		name, _ := json.Marshal(field[0])
+		value, _ := json.Marshal(field[1])
+		buf = append(append(append(buf, name...), ':'), value...)
+	}
+	_, err := w.out.Write(append(buf, '}'))
+	return err
+}
+
+func (w *jsonWriter) close() error {
+	end := "\n"
+	if w.array {
+		end = "\n]\n"
+	} else if w.n == 0 {
+		end = ""
+	}
+	if _, err := w.out.WriteString(end); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+// csvWriter writes records as the rows of a CSV file.
+type csvWriter struct {
+	out *bufio.Writer
+	w   *csv.Writer
+	f   *fields
+}
+
+func (w *csvWriter) write(rec record) error {
+	return w.w.Write([]string{rec.Key, rec.Value, rec.TTL}[:len(w.f.names())])
+}
+
+func (w *csvWriter) close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		return err
+	}
+	return w.out.Flush()
+}
+
+// recordReader reads the records of an import, returning io.EOF after the
+// last one.
+type recordReader interface {
+	read() (record, error)
+}
+
+// newRecordReader returns the reader of records from r in format.
+func newRecordReader(r io.Reader, format string, f *fields) (recordReader, error) {
+	in := bufio.NewReader(r)
+	if format == formatCSV {
+		return newCSVReader(in, f)
+	}
+	dec := json.NewDecoder(in)
+	dec.UseNumber()
+	jr := &jsonReader{dec: dec, f: f, array: format == formatJSON}
+	if jr.array {
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return nil, errors.New("invalid export, expected a JSON array of records")
+		}
+	}
+	return jr, nil
+}
+
+// jsonReader reads records from JSON objects, in an array or one per line.
+// Values that aren't strings are read as their JSON text, and numbers of
+// the ttl field as seconds.
+type jsonReader struct {
+	dec   *json.Decoder
+	f     *fields
+	array bool
+	n     int
+}
+
+func (r *jsonReader) read() (record, error) {
+	if r.array && !r.dec.More() {
+		return record{}, io.EOF
+	}
+	r.n++
+	var obj map[string]json.RawMessage
+	if err := r.dec.Decode(&obj); err != nil {
+		if errors.Is(err, io.EOF) {
+			return record{}, io.EOF
+		}
+		return record{}, fmt.Errorf("invalid record %d: %w", r.n, err)
+	}
+
+	// field returns the value of the field name, strings unquoted.
+	field := func(name string) (string, bool) {
+		raw, ok := obj[name]
+		if !ok || name == "" || bytes.Equal(raw, []byte("null")) {
+			return "", false
+		}
+		var s string
+		if err := json.Unmarshal(raw, &s); err == nil {
+			return s, true
+		}
+		// The object was decoded, its values are valid.
+		var buf bytes.Buffer
+		_ = json.Compact(&buf, raw)
+		return buf.String(), true
+	}
+	key, ok := field(r.f.key)
+	if !ok {
+		return record{}, fmt.Errorf("invalid record %d: missing field %q", r.n, r.f.key)
+	}
+	rec := record{Key: key}
+	rec.Value, _ = field(r.f.value)
+	rec.TTL, _ = field(r.f.ttl)
+	return rec, nil
+}
+
+// csvReader reads records from the rows of a CSV file, its columns found
+// by the names of its header row.
+type csvReader struct {
+	r               *csv.Reader
+	key, value, ttl int
+}
+
+func newCSVReader(r io.Reader, f *fields) (*csvReader, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV header: %w", err)
+	}
+	column := func(name string) int {
+		if name == "" {
+			return -1
+		}
+		return slices.Index(header, name)
+	}
+	c := &csvReader{r: cr, key: column(f.key), value: column(f.value), ttl: column(f.ttl)}
+	if c.key < 0 || c.value < 0 {
+		return nil, fmt.Errorf("invalid CSV header, expected the columns %q and %q", f.key, f.value)
+	}
+	return c, nil
+}
+
+func (r *csvReader) read() (record, error) {
+	row, err := r.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return record{}, io.EOF
+		}
+		return record{}, fmt.Errorf("invalid record: %w", err)
+	}
+	rec := record{Key: row[r.key], Value: row[r.value]}
+	if r.ttl >= 0 {
+		rec.TTL = row[r.ttl]
+	}
+	return rec, nil
+}
@@ -0,0 +1,166 @@
+// Command kvctl is the command-line client of the key-value store HTTP API.
+//
+// Usage:
+//
+//	kvctl [-addr URL] [-token TOKEN] [-timeout DURATION] <command> [arguments]
+//
+// The commands are get, set, del, dump, restore, list, export, import and
+// watch, run "kvctl <command> -h" for their flags. The server address and
+// the bearer token default to the KVCTL_ADDR and KVCTL_TOKEN environment
+// variables.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"codesignal/pkg/client"
+)
+
+const defaultAddr = "http://localhost:8081"
+
+// errUsage reports invalid arguments, the usage was already printed.
+var errUsage = errors.New("usage")
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "kvctl:", err)
+		os.Exit(1)
+	}
+}
+
+// env holds what the commands read and write.
+type env struct {
+	client  *client.Client
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// command is a kvctl subcommand.
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, e *env, args []string) error
+}
+
+// commands is set in init, the commands refer to it for their usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "get", usage: "get <key>", run: get},
+		{name: "set", usage: "set [-ttl duration] <key> [value, read from stdin if omitted]", run: set},
+		{name: "del", usage: "del <key>...", run: del},
+		{name: "dump", usage: "dump <key>", run: dump},
+		{name: "restore", usage: "restore [-ttl duration] [-replace] <key> [payload, read from stdin if omitted]", run: restore},
+		{name: "list", usage: "list [-prefix prefix] [-limit n] [-values]", run: list},
+		{name: "export", usage: "export [-prefix prefix] [-format json|ndjson|csv] [-key-field name] [-value-field name] [-ttl-field name] [-o file]", run: export},
+		{name: "import", usage: "import [-format json|ndjson|csv] [-key-field name] [-value-field name] [-ttl-field name] [-i file]", run: importKeys},
+		{name: "watch", usage: "watch [-prefix prefix]", run: watch},
+	}
+}
+
+func lookup(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("kvctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", envOr("KVCTL_ADDR", defaultAddr), "server URL (KVCTL_ADDR)")
+	token := flags.String("token", os.Getenv("KVCTL_TOKEN"), "bearer token sent to the server (KVCTL_TOKEN)")
+	timeout := flags.Duration("timeout", 10*time.Second, "timeout of each request, watch excepted")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: kvctl [flags] <command> [arguments]\n\nCommands:")
+		for _, cmd := range commands {
+			fmt.Fprintln(stderr, "  "+cmd.usage)
+		}
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	cmd, ok := lookup(flags.Arg(0))
+	if !ok {
+		fmt.Fprintf(stderr, "kvctl: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return errUsage
+	}
+
+	opts := client.Opts{}
+	if *token != "" {
+		opts.Header = http.Header{"Authorization": {"Bearer " + *token}}
+	}
+	c, err := client.New(*addr, opts)
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(ctx, &env{client: c, timeout: *timeout, stdin: stdin, stdout: stdout, stderr: stderr}, flags.Args()[1:])
+}
+
+// flagSet returns the flag set of a command, printing its usage on errors.
+func (e *env) flagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(e.stderr)
+	flags.Usage = func() {
+		cmd, _ := lookup(name)
+		fmt.Fprintln(e.stderr, "Usage: kvctl "+cmd.usage)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parse parses the flags of a command and checks the number of its
+// positional arguments.
+func parse(flags *flag.FlagSet, args []string, minArgs, maxArgs int) error {
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		flags.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// request returns the context of a single request.
+func (e *env) request(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, e.timeout)
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+)
+
+func newServer(t *testing.T) string {
+	t.Helper()
+
+	bus := events.NewBus(16)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+	srv := httptest.NewServer(router.New(zerolog.Nop(), repo, &config.Config{}, router.Opts{Events: bus}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// kvctl runs the command with args against addr and returns its output.
+func kvctl(t *testing.T, addr, stdin string, args ...string) (string, error) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+	err := run(context.Background(), append([]string{"-addr", addr}, args...), strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), err
+}
+
+func TestKvctl(t *testing.T) {
+	addr := newServer(t)
+
+	_, err := kvctl(t, addr, "", "set", "user:1", "alice")
+	require.NoError(t, err)
+	_, err = kvctl(t, addr, "bob", "set", "-ttl", "1h", "user:2")
+	require.NoError(t, err)
+	_, err = kvctl(t, addr, "", "set", "order:1", "x")
+	require.NoError(t, err)
+
+	out, err := kvctl(t, addr, "", "get", "user:2")
+	require.NoError(t, err)
+	assert.Equal(t, "bob\n", out)
+
+	out, err = kvctl(t, addr, "", "list", "-prefix", "user:", "-values")
+	require.NoError(t, err)
+	assert.Equal(t, "user:1\talice\nuser:2\tbob\n", out)
+
+	out, err = kvctl(t, addr, "", "list", "-limit", "1")
+	require.NoError(t, err)
+	assert.Equal(t, "order:1\n", out)
+
+	_, err = kvctl(t, addr, "", "del", "order:1", "missing")
+	assert.ErrorContains(t, err, "missing: key not found")
+
+	_, err = kvctl(t, addr, "", "get", "order:1")
+	assert.ErrorContains(t, err, "key not found")
+}
+
+func TestKvctlExportImport(t *testing.T) {
+	src, dst := newServer(t), newServer(t)
+	file := filepath.Join(t.TempDir(), "export.json")
+
+	_, err := kvctl(t, src, "", "set", "a", "1")
+	require.NoError(t, err)
+	_, err = kvctl(t, src, "", "set", "-ttl", "1h", "b", "2")
+	require.NoError(t, err)
+	_, err = kvctl(t, dst, "", "set", "a", "kept")
+	require.NoError(t, err)
+
+	_, err = kvctl(t, src, "", "export", "-o", file)
+	require.NoError(t, err)
+	_, err = kvctl(t, dst, "", "import", "-i", file)
+	require.NoError(t, err)
+
+	out, err := kvctl(t, dst, "", "list", "-values")
+	require.NoError(t, err)
+	assert.Equal(t, "a\tkept\nb\t2\n", out, "existing keys are skipped")
+
+	export, err := kvctl(t, dst, "", "export")
+	require.NoError(t, err)
+	assert.Regexp(t, `^\[\n\{"key":"a","value":"kept"\},\n\{"key":"b","value":"2","ttl":"(1h0m0s|59m59s)"\}\n\]\n$`, export)
+
+	_, err = kvctl(t, dst, "{}", "import")
+	assert.ErrorContains(t, err, "expected a JSON array")
+}
+
+func TestKvctlFormats(t *testing.T) {
+	src, dst := newServer(t), newServer(t)
+	dir := t.TempDir()
+
+	_, err := kvctl(t, src, "", "set", "a", "1")
+	require.NoError(t, err)
+	_, err = kvctl(t, src, "", "set", "-ttl", "1h", "b", "two, \"quoted\"")
+	require.NoError(t, err)
+
+	// The format follows the extension of the file.
+	for _, file := range []string{"export.ndjson", "export.csv"} {
+		_, err = kvctl(t, src, "", "export", "-o", filepath.Join(dir, file))
+		require.NoError(t, err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "export.ndjson"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^\{"key":"a","value":"1"\}\n\{"key":"b","value":"two, \\"quoted\\"","ttl":"(1h0m0s|59m59s)"\}\n$`, string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "export.csv"))
+	require.NoError(t, err)
+	assert.Regexp(t, `^key,value,ttl\na,1,\nb,"two, ""quoted""",(1h0m0s|59m59s)\n$`, string(b))
+
+	_, err = kvctl(t, dst, "", "import", "-i", filepath.Join(dir, "export.csv"))
+	require.NoError(t, err)
+	out, err := kvctl(t, dst, "", "list", "-values")
+	require.NoError(t, err)
+	assert.Equal(t, "a\t1\nb\ttwo, \"quoted\"\n", out)
+
+	// Fields are mapped to the columns of other tools, extra columns are
+	// ignored and time-to-lives may be seconds.
+	_, err = kvctl(t, dst, "id,name,expires_in\nc,carol,60\nd,dave,\n", "import", "-format", "csv",
+		"-key-field", "id", "-value-field", "name", "-ttl-field", "expires_in")
+	require.NoError(t, err)
+	_, err = kvctl(t, dst, `{"sku":"e","stock":42,"extra":true}`+"\n"+`{"sku":"f","stock":{"a": 1}}`, "import", "-format", "ndjson",
+		"-key-field", "sku", "-value-field", "stock")
+	require.NoError(t, err)
+	out, err = kvctl(t, dst, "", "export", "-prefix", "c", "-format", "ndjson", "-key-field", "id", "-value-field", "name", "-ttl-field", "")
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"c","name":"carol"}`+"\n", out)
+	out, err = kvctl(t, dst, "", "list", "-values", "-prefix", "e")
+	require.NoError(t, err)
+	assert.Equal(t, "e\t42\n", out)
+	out, err = kvctl(t, dst, "", "get", "f")
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`+"\n", out)
+
+	_, err = kvctl(t, dst, "name\nx\n", "import", "-format", "csv")
+	assert.ErrorContains(t, err, `expected the columns "key" and "value"`)
+	_, err = kvctl(t, dst, `{"value":"x"}`, "import", "-format", "ndjson")
+	assert.ErrorContains(t, err, `invalid record 1: missing field "key"`)
+	_, err = kvctl(t, dst, "key,value,ttl\ng,x,soon\n", "import", "-format", "csv")
+	assert.ErrorContains(t, err, `g: invalid ttl "soon"`)
+	_, err = kvctl(t, dst, "", "export", "-format", "xml")
+	assert.ErrorContains(t, err, `unknown format "xml"`)
+	_, err = kvctl(t, dst, "", "export", "-value-field", "key")
+	assert.ErrorContains(t, err, "must differ")
+}
+
+func TestKvctlDumpRestore(t *testing.T) {
+	src, dst := newServer(t), newServer(t)
+
+	_, err := kvctl(t, src, "", "set", "a", "hello")
+	require.NoError(t, err)
+	payload, err := kvctl(t, src, "", "dump", "a")
+	require.NoError(t, err)
+
+	_, err = kvctl(t, dst, payload, "restore", "-ttl", "1h", "b")
+	require.NoError(t, err)
+	out, err := kvctl(t, dst, "", "get", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out)
+
+	_, err = kvctl(t, dst, "", "restore", "b", strings.TrimSpace(payload))
+	assert.ErrorContains(t, err, "key already exists")
+	_, err = kvctl(t, dst, "", "restore", "-replace", "b", strings.TrimSpace(payload))
+	require.NoError(t, err)
+
+	_, err = kvctl(t, dst, "", "restore", "c", "not base64")
+	assert.ErrorContains(t, err, "expected base64")
+}
+
+// syncBuffer is a buffer written and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestKvctlWatch(t *testing.T) {
+	addr := newServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout syncBuffer
+	done := make(chan error)
+	go func() {
+		done <- run(ctx, []string{"-addr", addr, "watch", "-prefix", "user:"}, nil, &stdout, &bytes.Buffer{})
+	}()
+
+	// The watch may start after the first set, set until an event shows up.
+	require.Eventually(t, func() bool {
+		_, _ = kvctl(t, addr, "", "del", "user:1")
+		_, _ = kvctl(t, addr, "", "set", "user:1", "alice")
+		return strings.Contains(stdout.String(), "set\tuser:1\talice")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestKvctlUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"rename"}, {"get"}, {"set", "a", "b", "c"}, {"list", "extra"}} {
+		_, err := kvctl(t, "http://localhost", "", args...)
+		assert.ErrorIs(t, err, errUsage, args)
+	}
+}
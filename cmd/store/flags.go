@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+
+	"codesignal/internal/config"
+)
+
+// override is a command-line flag overriding a setting of the environment.
+type override struct {
+	name, usage string
+	// bool flags take no value.
+	bool bool
+	set  func(cfg *config.Config, value string)
+}
+
+var overrides = []override{
+	{name: "address", usage: "comma-separated listen addresses of the HTTP API (SERVER_ADDRESS)", set: func(cfg *config.Config, v string) {
+		cfg.Server.Address = v
+	}},
+	{name: "admin-address", usage: "listen address of the admin listener (ADMIN_ADDRESS)", set: func(cfg *config.Config, v string) {
+		cfg.Admin.Address = v
+	}},
+	{name: "data-file", usage: "deprecated, directory of the storage engine (DATA_FILE, LSM_DIR)", set: func(cfg *config.Config, v string) {
+		cfg.DataFile = v
+		cfg.LSM.Dir = v
+	}},
+	{name: "seed-file", usage: "JSON object or snapshot of the keys loaded at startup (SEED_FILE)", set: func(cfg *config.Config, v string) {
+		cfg.SeedFile = v
+	}},
+	{name: "backup-dir", usage: "directory of the scheduled backups (BACKUP_DIR)", set: func(cfg *config.Config, v string) {
+		cfg.Backup.Dir = v
+	}},
+	{name: "backup-schedule", usage: "cron expression of the backup times (BACKUP_SCHEDULE)", set: func(cfg *config.Config, v string) {
+		cfg.Backup.Schedule = v
+	}},
+	{name: "replica-source", usage: "snapshot file or backup directory served read-only (REPLICA_SOURCE)", set: func(cfg *config.Config, v string) {
+		cfg.Replica.Source = v
+	}},
+	{name: "log-level", usage: "minimum level of the logs (LOG_LEVEL)", set: func(cfg *config.Config, v string) {
+		cfg.LogLevel = v
+	}},
+	{name: "read-only", usage: "start in maintenance mode, rejecting writes (READ_ONLY)", bool: true, set: func(cfg *config.Config, v string) {
+		// Validated when parsed.
+		cfg.ReadOnly, _ = strconv.ParseBool(v)
+	}},
+}
+
+// overrideFlags defines the flags overriding the environment on flags. The
+// returned function applies those set on the command line to a config
+// loaded from the environment, so they take precedence over it and over
+// the .env file on reloads.
+func overrideFlags(flags *flag.FlagSet) func(cfg *config.Config) {
+	values := map[string]string{}
+	for _, o := range overrides {
+		record := func(v string) error {
+			values[o.name] = v
+			return nil
+		}
+		if o.bool {
+			flags.BoolFunc(o.name, o.usage, func(v string) error {
+				if _, err := strconv.ParseBool(v); err != nil {
+					return err
+				}
+				return record(v)
+			})
+			continue
+		}
+		flags.Func(o.name, o.usage, record)
+	}
+
+	return func(cfg *config.Config) {
+		for _, o := range overrides {
+			if v, ok := values[o.name]; ok {
+				o.set(cfg, v)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+)
+
+func TestOverrideFlags(t *testing.T) {
+	flags := flag.NewFlagSet("store", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	applyFlags := overrideFlags(flags)
+	require.NoError(t, flags.Parse([]string{"-address", "127.0.0.1:9000", "--data-file=data/dev", "-read-only"}))
+
+	cfg := &config.Config{DataFile: "data/node1", LogLevel: "info"}
+	cfg.Server.Address = "0.0.0.0:8081"
+	applyFlags(cfg)
+	assert.Equal(t, "127.0.0.1:9000", cfg.Server.Address)
+	assert.Equal(t, "data/dev", cfg.DataFile)
+	assert.Equal(t, "data/dev", cfg.LSM.Dir, "the deprecated flag sets the storage engine")
+	assert.True(t, cfg.ReadOnly)
+	assert.Equal(t, "info", cfg.LogLevel, "flags not set keep the environment")
+
+	flags = flag.NewFlagSet("store", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	overrideFlags(flags)
+	assert.Error(t, flags.Parse([]string{"-read-only=maybe"}))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"codesignal/internal/config"
+	"codesignal/internal/server"
+)
+
+// healthPath is probed by healthcheck. The readiness endpoint answers 503
+// until the node can serve requests, e.g. while a Raft node replays its log.
+const healthPath = "/readyz"
+
+// healthcheck probes the health endpoint of the server configured by the
+// environment and the flags of the server, so orchestrators can run "store healthcheck" as the liveness
+// or readiness probe of the container. It returns the exit code.
+func healthcheck(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	timeout := flags.Duration("timeout", 3*time.Second, "time allowed to the server to answer")
+	path := flags.String("path", healthPath, "path of the health endpoint")
+	applyFlags := overrideFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg, err := config.LoadFromEnv()
+	if err != nil {
+		fmt.Fprintln(stderr, "healthcheck: failed to load env vars:", err)
+		return 1
+	}
+	applyFlags(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	if err := server.Probe(ctx, cfg.Server, *path); err != nil {
+		fmt.Fprintln(stderr, "healthcheck: unhealthy:", err)
+		return 1
+	}
+	return 0
+}
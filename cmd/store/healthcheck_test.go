@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthcheck(t *testing.T) {
+	var status int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, healthPath, r.URL.Path)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"message":"cluster has no leader","status_code":1012}`))
+	}))
+	defer srv.Close()
+	t.Setenv("SERVER_ADDRESS", strings.TrimPrefix(srv.URL, "http://"))
+
+	var stderr bytes.Buffer
+	status = http.StatusOK
+	assert.Equal(t, 0, healthcheck(nil, &stderr))
+	assert.Empty(t, stderr.String())
+
+	status = http.StatusServiceUnavailable
+	assert.Equal(t, 1, healthcheck(nil, &stderr))
+	assert.Equal(t, "healthcheck: unhealthy: 503 Service Unavailable: cluster has no leader\n", stderr.String())
+
+	assert.Equal(t, 2, healthcheck([]string{"-unknown"}, &stderr))
+
+	// The flags of the server override its environment.
+	t.Setenv("SERVER_ADDRESS", "127.0.0.1:1")
+	status = http.StatusOK
+	stderr.Reset()
+	assert.Equal(t, 0, healthcheck([]string{"-address", strings.TrimPrefix(srv.URL, "http://")}, &stderr))
+	assert.Empty(t, stderr.String())
+}
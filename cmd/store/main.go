@@ -1,20 +1,64 @@
+// Command store runs the key-value store server, configured by environment
+// variables, which command-line flags such as -address override.
+// "store healthcheck" instead probes the health of the server running with
+// the same environment, exiting non-zero when it is unhealthy, and
+// "store version" prints the build of the binary.
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
 	"os"
 
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
 
+	"codesignal/internal/admin"
+	"codesignal/internal/auth"
+	"codesignal/internal/backup"
+	"codesignal/internal/binproto"
+	"codesignal/internal/buildinfo"
+	"codesignal/internal/cdc"
+	"codesignal/internal/cluster"
 	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/grpcserver"
+	"codesignal/internal/hotkeys"
+	"codesignal/internal/ipfilter"
+	"codesignal/internal/loadshed"
+	"codesignal/internal/maintenance"
+	"codesignal/internal/memcached"
+	"codesignal/internal/memlimit"
+	"codesignal/internal/mirror"
+	"codesignal/internal/reload"
+	"codesignal/internal/replica"
 	"codesignal/internal/repository"
+	"codesignal/internal/resp"
 	"codesignal/internal/router"
+	"codesignal/internal/schedule"
+	"codesignal/internal/schema"
 	"codesignal/internal/server"
+	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+	"codesignal/internal/webhook"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "healthcheck" {
+		os.Exit(healthcheck(os.Args[2:], os.Stderr))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		info := buildinfo.Get()
+		fmt.Printf("store %s (commit %s, built %s, %s)\n", info.Version, info.Commit, info.BuildTime, info.GoVersion)
+		return
+	}
+
+	flags := flag.NewFlagSet("store", flag.ExitOnError)
+	applyFlags := overrideFlags(flags)
+	_ = flags.Parse(os.Args[1:])
+
 	logger := zerolog.New(os.Stderr).
-		Level(zerolog.DebugLevel).
 		With().
 		Timestamp().
 		Logger()
@@ -23,15 +67,308 @@ func main() {
 	if err != nil {
 		logger.Fatal().Err(err).Msg("failed to load env vars")
 	}
+	applyFlags(appConfig)
+	if err := appConfig.Validate(); err != nil {
+		logger.Fatal().Err(err).Msg("invalid configuration")
+	}
+	level, err := zerolog.ParseLevel(appConfig.LogLevel)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("invalid log level")
+	}
+	zerolog.SetGlobalLevel(level)
+	buildinfo.Get().Log(logger.Info()).Msg("starting key-value store")
+
+	bus := events.NewBus(events.DefaultBufferSize)
+
+	repoOpts := appConfig.RepositoryOpts()
+	repoOpts.Events = bus
+	kvStore, err := repository.NewKeyValueStore(logger, repoOpts)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to create repository")
+	}
+	if appConfig.SeedFile != "" {
+		n, err := kvStore.LoadSeedFile(context.Background(), appConfig.SeedFile)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to load seed file")
+		}
+		logger.Info().Str("file", appConfig.SeedFile).Int("keys", n).Msg("store seeded")
+	}
+
+	tenants, err := tenant.New(logger, appConfig.Tenant, repoOpts)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure tenants")
+	}
+
+	var (
+		repo       repository.Store = kvStore
+		hotKeys                     = hotkeys.New(appConfig.HotKeys)
+		readOnly                    = maintenance.New(appConfig.ReadOnly)
+		memory                      = memlimit.New(logger, appConfig.Memory)
+		routerOpts                  = router.Opts{Events: bus, HotKeys: hotKeys, Maintenance: readOnly, Memory: memory, Tenants: tenants}
+		listeners  []cluster.MembershipListener
+		self       = cluster.Member{Role: cluster.RoleStorage, HTTPAddress: appConfig.Gossip.HTTPAddress}
+	)
+	// A replica serves the snapshots of its source, its writes are
+	// rejected for good.
+	replicaMode := replica.New(logger, appConfig.Replica, kvStore)
+	if replicaMode != nil {
+		if _, err := replicaMode.Refresh(context.Background()); err != nil {
+			logger.Fatal().Err(err).Msg("failed to load the replica snapshot")
+		}
+		readOnly.Pin()
+		routerOpts.Replica = replicaMode
+	}
+	var raftNode *cluster.Node
+	if appConfig.Raft.Enabled {
+		node, err := cluster.NewNode(logger, appConfig.Raft, kvStore)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to start raft node")
+		}
+		defer func() {
+			if err := node.Close(); err != nil {
+				logger.Error().Err(err).Msg("failed to shut down raft node")
+			}
+		}()
+
+		raftNode = node
+		repo = node
+		routerOpts.Cluster = node
+		listeners = append(listeners, node)
+		self.RaftID = appConfig.Raft.NodeID
+		self.RaftAddress = appConfig.Raft.AdvertiseAddress
+		if self.RaftAddress == "" {
+			self.RaftAddress = appConfig.Raft.BindAddress
+		}
+	}
+
+	if appConfig.Shard.Enabled {
+		proxy, err := cluster.NewProxy(logger, appConfig.Shard)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to start sharding coordinator")
+		}
+		defer proxy.Close()
+		proxy.SetKeyNormalizer(appConfig.StoreOpts().KeyNormalizer)
+		routerOpts.Shards = proxy
+		listeners = append(listeners, proxy)
+		self.Role = cluster.RoleCoordinator
+	}
+
+	if appConfig.Gossip.Enabled {
+		membership, err := cluster.NewMembership(logger, appConfig.Gossip, self, listeners...)
+		if err != nil {
+			logger.Fatal().Err(err).Msg("failed to start gossip membership")
+		}
+		defer func() {
+			if err := membership.Close(); err != nil {
+				logger.Error().Err(err).Msg("failed to leave gossip membership")
+			}
+		}()
+		routerOpts.Gossip = membership
+	}
+
+	authenticator, err := auth.New(appConfig.Auth)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure authentication")
+	}
+	routerOpts.Auth = authenticator
+
+	ipFilter, err := ipfilter.New(appConfig.IPFilter)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure the ip filter")
+	}
+	routerOpts.IPFilter = ipFilter
+
+	shedder, err := loadshed.New(appConfig.LoadShed)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure load shedding")
+	}
+	routerOpts.LoadShed = shedder
 
-	repo, err := repository.NewKeyValueStore(logger)
+	changeLog, err := cdc.OpenChangeLog(logger, appConfig.ChangeLog, bus)
 	if err != nil {
-		log.Error().Err(err).Msg("failed to create repository")
+		logger.Fatal().Err(err).Msg("failed to open the change log")
 	}
+	routerOpts.Changes = changeLog
 
-	httpRouter := router.New(logger, repo, appConfig)
+	reloader := reload.New(logger, func() (*config.Config, error) {
+		cfg, err := config.Reload()
+		if err != nil {
+			return nil, err
+		}
+		applyFlags(cfg)
+		// The keys stored under the current normalization would no longer
+		// be found under another one, it only changes on restart.
+		cfg.KeyNormalization = appConfig.KeyNormalization
+		return cfg, cfg.Validate()
+	})
+	routerOpts.Reload = reloader.Handler()
+
+	// The schemas are loaded before serving so that no write escapes
+	// them, replicas don't validate the writes they reject.
+	var schemas *schema.Registry
+	if replicaMode == nil {
+		schemas = schema.New(logger, repo, bus)
+		if err := schemas.Load(context.Background()); err != nil {
+			logger.Fatal().Err(err).Msg("failed to load schemas")
+		}
+		routerOpts.Schemas = schemas
+	}
+
+	// With an admin listener, the admin routes are served on it only.
+	newRouter := func(cfg *config.Config, opts router.Opts) (api, admin http.Handler) {
+		if appConfig.Admin.Address != "" && appConfig.Admin.Routes {
+			return router.NewSplit(logger, repo, cfg, opts)
+		}
+		return router.New(logger, repo, cfg, opts), nil
+	}
+	api, adminAPI := newRouter(appConfig, routerOpts)
+	httpRouter := reload.NewHandler(api)
+	var adminRouter *reload.Handler
+	if adminAPI != nil {
+		adminRouter = reload.NewHandler(adminAPI)
+	}
 
 	httpServer := server.New(logger, appConfig.Server, httpRouter)
+	storeOpts := appConfig.StoreOpts()
+	storeOpts.HotKeys = hotKeys
+	storeOpts.Maintenance = readOnly
+	storeOpts.Memory = memory
+	if schemas != nil {
+		storeOpts.Hooks = []store.Hook{schemas}
+	}
+	storeService := store.NewService(logger, repo, storeOpts)
+
+	// Reloads rebuild the router with the new settings, applied to the
+	// requests received once it replaces the current one.
+	reloader.Register(func(cfg *config.Config) (func(), error) {
+		level, err := zerolog.ParseLevel(cfg.LogLevel)
+		if err != nil {
+			return nil, fmt.Errorf("log level: %w", err)
+		}
+		return func() { zerolog.SetGlobalLevel(level) }, nil
+	})
+	reloader.Register(func(cfg *config.Config) (func(), error) {
+		opts := routerOpts
+		authenticator, err := auth.New(cfg.Auth)
+		if err != nil {
+			return nil, fmt.Errorf("authentication: %w", err)
+		}
+		opts.Auth = authenticator
+		if opts.IPFilter, err = ipfilter.New(cfg.IPFilter); err != nil {
+			return nil, fmt.Errorf("ip filter: %w", err)
+		}
+		if opts.LoadShed, err = loadshed.New(cfg.LoadShed); err != nil {
+			return nil, fmt.Errorf("load shedding: %w", err)
+		}
+		api, adminAPI := newRouter(cfg, opts)
+		return func() {
+			httpRouter.Store(api)
+			if adminRouter != nil {
+				adminRouter.Store(adminAPI)
+			}
+			storeService.SetLimits(cfg.MaxKeyLength, cfg.MaxValueSize)
+			storeService.SetKeyPattern(cfg.StoreOpts().KeyPattern)
+		}, nil
+	})
+	httpServer.Register(reloader)
+	if memory != nil {
+		httpServer.Register(memory)
+	}
+	if schemas != nil {
+		httpServer.Register(schemas)
+	}
+	if replicaMode != nil {
+		httpServer.Register(replicaMode)
+	}
+	if appConfig.GRPC.Enabled {
+		httpServer.Register(grpcserver.New(logger, appConfig.GRPC, storeService, bus))
+	}
+	if appConfig.RESP.Enabled {
+		httpServer.Register(resp.New(logger, appConfig.RESP, storeService))
+	}
+	if appConfig.Memcached.Enabled {
+		httpServer.Register(memcached.New(logger, appConfig.Memcached, storeService))
+	}
+	if appConfig.Binary.Enabled {
+		httpServer.Register(binproto.New(logger, appConfig.Binary, storeService))
+	}
+
+	backups, err := backup.New(logger, appConfig.Backup, kvStore, nil)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure backups")
+	}
+	if backups != nil {
+		httpServer.Register(backups)
+	}
+
+	// In clustered mode every node applies the changes, only the leader
+	// delivers and publishes them.
+	var active func() bool
+	if raftNode != nil {
+		active = raftNode.IsLeader
+	}
+	webhooks, err := webhook.New(logger, appConfig.Webhook, bus, repo, active)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure webhooks")
+	}
+	if webhooks != nil {
+		httpServer.Register(webhooks)
+	}
+	// Replicas are read-only, their keys are deleted with those of their
+	// source.
+	if replicaMode == nil {
+		httpServer.Register(schedule.New(logger, storeService, repo, bus, active))
+	}
+	kafkaSink, err := cdc.NewKafka(appConfig.Kafka)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure kafka publishing")
+	}
+	if kafkaSink != nil {
+		httpServer.Register(cdc.NewPublisher(logger, kafkaSink, bus, active))
+	}
+	natsSink, err := cdc.NewNATS(appConfig.NATS)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure nats publishing")
+	}
+	if natsSink != nil {
+		httpServer.Register(cdc.NewPublisher(logger, natsSink, bus, active))
+	}
+	// Every node logs the changes it applies, consumers read the log of a
+	// single node.
+	if changeLog != nil {
+		httpServer.Register(changeLog)
+	}
+	mirrorWorker, err := mirror.New(logger, appConfig.Mirror, changeLog, active)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("failed to configure mirroring")
+	}
+	if mirrorWorker != nil {
+		httpServer.Register(mirrorWorker)
+	}
+
+	// Once the requests are drained, the last writes are flushed before exit.
+	if backups != nil {
+		httpServer.OnShutdown("backup", backups.Flush)
+	}
+	if raftNode != nil {
+		httpServer.OnShutdown("raft", raftNode.Flush)
+	}
+	httpServer.OnShutdown("repository", func(context.Context) error {
+		return kvStore.Close()
+	})
+	if tenants != nil {
+		httpServer.OnShutdown("tenants", tenants.Close)
+	}
+
+	if appConfig.Admin.Address != "" {
+		var handler http.Handler
+		if adminRouter != nil {
+			handler = adminRouter
+		}
+		httpServer.Register(admin.New(logger, appConfig.Admin, handler))
+	} else if appConfig.Admin.Pprof {
+		logger.Warn().Msg("pprof is enabled without an admin listener, set ADMIN_ADDRESS")
+	}
 
 	if err := httpServer.Run(); err != nil {
 		logger.Fatal().Err(err).Msg("server failure")
@@ -0,0 +1,72 @@
+// Package admin serves the admin listener, a separate HTTP listener for
+// operator endpoints that shouldn't be reachable by clients of the API.
+//
+// With Routes set it serves the routes of the kv:admin scope of the API,
+// such as /metrics and /admin/*, which the API listener then doesn't serve,
+// so they can be firewalled independently from the key API.
+//
+// With pprof enabled it serves the net/http/pprof profiles under
+// /debug/pprof/, so CPU, heap and lock contention profiles can be captured
+// from production:
+//
+//	go tool pprof http://localhost:6060/debug/pprof/profile?seconds=30
+//	go tool pprof http://localhost:6060/debug/pprof/mutex
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/server"
+)
+
+// Config holds the configuration of the admin listener.
+type Config struct {
+	// Address is the address of the admin listener, empty disables it.
+	// Bind it to a private interface: profiles expose the internals of the
+	// server.
+	Address string `envconfig:"ADDRESS"`
+	// Routes serves the kv:admin routes of the API on the admin listener
+	// instead of the API listener.
+	Routes bool `envconfig:"ROUTES" default:"true"`
+	// Pprof serves the net/http/pprof profiles.
+	Pprof bool `envconfig:"PPROF" default:"false"`
+	// MutexProfileFraction samples 1 in n mutex contention events for the
+	// mutex profile, 0 disables it.
+	MutexProfileFraction int `envconfig:"MUTEX_PROFILE_FRACTION" default:"0"`
+	// BlockProfileRate samples blocking events lasting about rate
+	// nanoseconds for the block profile, 0 disables it.
+	BlockProfileRate int `envconfig:"BLOCK_PROFILE_RATE" default:"0"`
+}
+
+// New returns the admin listener configured by cfg, serving api, the admin
+// routes of the API, besides the profiles. Enabling pprof sets the mutex and
+// block profile rates of the process.
+func New(log zerolog.Logger, cfg Config, api http.Handler) *server.HTTPServer {
+	log = log.With().Str("component", "admin").Logger()
+	if cfg.Pprof {
+		runtime.SetMutexProfileFraction(cfg.MutexProfileFraction)
+		runtime.SetBlockProfileRate(cfg.BlockProfileRate)
+	}
+	return server.NewHTTPServer(log, "admin", cfg.Address, Handler(cfg, api))
+}
+
+// Handler returns the handler of the admin endpoints enabled by cfg,
+// serving the other requests with api if set.
+func Handler(cfg Config, api http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	if cfg.Pprof {
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	}
+	if api != nil {
+		mux.Handle("/", api)
+	}
+	return mux
+}
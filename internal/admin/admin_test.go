@@ -0,0 +1,77 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serve(t *testing.T, cfg Config, api http.Handler) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := New(zerolog.Nop(), cfg, api)
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeListener(lis) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Shutdown(context.Background()))
+		require.NoError(t, <-done)
+	})
+	return "http://" + lis.Addr().String()
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestServer(t *testing.T) {
+	t.Run("pprof disabled", func(t *testing.T) {
+		url := serve(t, Config{}, nil)
+
+		code, _ := get(t, url+"/debug/pprof/")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("pprof enabled", func(t *testing.T) {
+		url := serve(t, Config{Pprof: true}, nil)
+
+		code, body := get(t, url+"/debug/pprof/")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "mutex")
+
+		code, body = get(t, url+"/debug/pprof/heap?debug=1")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "heap profile")
+
+		code, _ = get(t, url+"/debug/pprof/cmdline")
+		assert.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("api routes", func(t *testing.T) {
+		url := serve(t, Config{Pprof: true}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.URL.Path)
+		}))
+
+		code, body := get(t, url+"/metrics")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "/metrics", body)
+
+		code, body = get(t, url+"/debug/pprof/")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Contains(t, body, "mutex")
+	})
+}
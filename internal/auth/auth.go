@@ -0,0 +1,166 @@
+// Package auth authenticates the clients of the HTTP API and checks the
+// scopes they were granted.
+//
+// An Authenticator verifies the credentials of a request and returns its
+// Principal, the client with its scopes and roles: JWT verifies bearer
+// tokens issued by an identity provider and Basic the users of the
+// configuration, for small deployments. The router authenticates the
+// requests of protected routes and stores the principal in their context,
+// where the store operations check with Authorize it may access the key.
+// Contexts without principal, of internal operations or with
+// authentication disabled, are authorized.
+//
+// Scopes grant a permission, read, write or admin, on every key or on the
+// keys starting with a prefix, the namespaces of teams sharing the store:
+// kv:read grants reading every key, and kv:write:team-a. writing the keys
+// of the team-a. namespace. Roles name sets of scopes in the configuration.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scopes granted to clients on every key, see ParseScope.
+const (
+	// ScopeRead allows reading keys.
+	ScopeRead = "kv:read"
+	// ScopeWrite allows writing and deleting keys, and reading them.
+	ScopeWrite = "kv:write"
+	// ScopeAdmin allows the admin endpoints, such as metrics and cluster
+	// membership, and writing keys.
+	ScopeAdmin = "kv:admin"
+)
+
+var (
+	// ErrNoCredentials is returned by authenticators for requests without
+	// credentials.
+	ErrNoCredentials = errors.New("missing credentials")
+	// ErrInvalidCredentials is returned by authenticators for credentials
+	// they reject.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	// ErrForbidden is returned by Authorize when the principal lacks a
+	// permission.
+	ErrForbidden = errors.New("forbidden")
+)
+
+// Config holds the configuration of authentication. Authentication is
+// disabled unless a mode is configured, with both modes clients may use
+// either.
+type Config struct {
+	// JWT configures bearer token authentication.
+	JWT JWTConfig `envconfig:"JWT"`
+	// Basic configures HTTP Basic authentication.
+	Basic BasicConfig `envconfig:"BASIC"`
+	// Roles maps role names to space separated scopes, as in
+	// "team-a:kv:write:team-a. kv:read:shared.,ops:kv:admin".
+	Roles map[string]string `envconfig:"ROLES"`
+}
+
+// Enabled reports whether cfg configures an authentication scheme.
+func (c Config) Enabled() bool {
+	return c.JWT.enabled() || c.Basic.enabled()
+}
+
+// Principal is an authenticated client.
+type Principal struct {
+	// Subject identifies the client, such as the sub claim of a token.
+	Subject string
+	Scopes  []string
+	// Roles are expanded to their scopes by the authenticator returned by
+	// New.
+	Roles []string
+}
+
+// Authenticator verifies the credentials of requests.
+type Authenticator interface {
+	// Authenticate returns the principal of r, ErrNoCredentials without
+	// credentials of its scheme or an error wrapping
+	// ErrInvalidCredentials.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge is the WWW-Authenticate header of 401 responses.
+	Challenge() string
+}
+
+// New returns the authenticator configured by cfg, nil if authentication is
+// disabled.
+func New(cfg Config) (Authenticator, error) {
+	roles, err := parseRoles(cfg.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain Chain
+	if cfg.JWT.enabled() {
+		a, err := NewJWT(cfg.JWT)
+		if err != nil {
+			return nil, fmt.Errorf("jwt: %w", err)
+		}
+		chain = append(chain, a)
+	}
+	if cfg.Basic.enabled() {
+		a, err := NewBasic(cfg.Basic)
+		if err != nil {
+			return nil, fmt.Errorf("basic: %w", err)
+		}
+		if err := a.checkRoles(roles); err != nil {
+			return nil, fmt.Errorf("basic: %w", err)
+		}
+		chain = append(chain, a)
+	}
+
+	var a Authenticator
+	switch len(chain) {
+	case 0:
+		return nil, nil
+	case 1:
+		a = chain[0]
+	default:
+		a = chain
+	}
+	if len(roles) > 0 {
+		a = &roleExpander{Authenticator: a, roles: roles}
+	}
+	return a, nil
+}
+
+// Chain authenticates requests with the first of its authenticators
+// finding credentials of its scheme.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if !errors.Is(err, ErrNoCredentials) {
+			return p, err
+		}
+	}
+	return nil, ErrNoCredentials
+}
+
+// Challenge implements Authenticator, listing the challenges of every
+// authenticator.
+func (c Chain) Challenge() string {
+	challenges := make([]string, len(c))
+	for i, a := range c {
+		challenges[i] = a.Challenge()
+	}
+	return strings.Join(challenges, ", ")
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal carried by ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
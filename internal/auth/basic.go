@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicConfig holds the configuration of HTTP Basic authentication, meant
+// for small internal deployments without an identity provider.
+type BasicConfig struct {
+	// Users is a comma separated list of name:password:scopes entries,
+	// scopes separated by spaces, such as "app:s3cret:kv:read kv:write".
+	// Scopes not starting with kv: name roles of the configuration.
+	// Passwords may be bcrypt hashes, such as those written by htpasswd -B.
+	Users []string `envconfig:"USERS"`
+}
+
+func (c BasicConfig) enabled() bool {
+	return len(c.Users) > 0
+}
+
+// Basic authenticates requests with HTTP Basic credentials.
+type Basic struct {
+	users map[string]*basicUser
+}
+
+type basicUser struct {
+	principal *Principal
+	// digest is the SHA-256 of a plain text password, hash the bcrypt
+	// hash of a hashed one.
+	digest [sha256.Size]byte
+	hash   []byte
+
+	// verified is the digest of the password last matching hash, sparing
+	// a bcrypt comparison per request.
+	mu       sync.Mutex
+	verified *[sha256.Size]byte
+}
+
+// dummyDigest is compared with the passwords of unknown users, so they take
+// as long to reject as wrong passwords.
+var dummyDigest = sha256.Sum256(nil)
+
+// NewBasic returns a Basic authenticator configured by cfg.
+func NewBasic(cfg BasicConfig) (*Basic, error) {
+	if !cfg.enabled() {
+		return nil, errors.New("users are required")
+	}
+
+	a := &Basic{users: make(map[string]*basicUser, len(cfg.Users))}
+	for _, entry := range cfg.Users {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 3)
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("invalid user %q, expected name:password:scopes", fields[0])
+		}
+		name, password, scopes := fields[0], fields[1], fields[2]
+		if _, ok := a.users[name]; ok {
+			return nil, fmt.Errorf("duplicate user %q", name)
+		}
+
+		user := &basicUser{principal: &Principal{Subject: name}}
+		for _, scope := range strings.Fields(scopes) {
+			if strings.HasPrefix(scope, "kv:") {
+				user.principal.Scopes = append(user.principal.Scopes, scope)
+			} else {
+				user.principal.Roles = append(user.principal.Roles, scope)
+			}
+		}
+		if _, err := bcrypt.Cost([]byte(password)); err == nil {
+			user.hash = []byte(password)
+		} else {
+			user.digest = sha256.Sum256([]byte(password))
+		}
+		a.users[name] = user
+	}
+	return a, nil
+}
+
+// checkRoles checks the roles of the users are configured.
+func (a *Basic) checkRoles(roles map[string][]string) error {
+	for name, user := range a.users {
+		for _, role := range user.principal.Roles {
+			if _, ok := roles[role]; !ok {
+				return fmt.Errorf("user %q: unknown role %q", name, role)
+			}
+		}
+	}
+	return nil
+}
+
+// Challenge implements Authenticator.
+func (a *Basic) Challenge() string {
+	return `Basic realm="key-value-store", charset="UTF-8"`
+}
+
+// Authenticate implements Authenticator.
+func (a *Basic) Authenticate(r *http.Request) (*Principal, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+
+	digest := sha256.Sum256([]byte(password))
+	user, ok := a.users[name]
+	if !ok {
+		subtle.ConstantTimeCompare(digest[:], dummyDigest[:])
+		return nil, fmt.Errorf("%w: wrong user name or password", ErrInvalidCredentials)
+	}
+	if !user.check(digest, password) {
+		return nil, fmt.Errorf("%w: wrong user name or password", ErrInvalidCredentials)
+	}
+	return user.principal, nil
+}
+
+func (u *basicUser) check(digest [sha256.Size]byte, password string) bool {
+	if u.hash == nil {
+		return subtle.ConstantTimeCompare(digest[:], u.digest[:]) == 1
+	}
+
+	u.mu.Lock()
+	verified := u.verified
+	u.mu.Unlock()
+	if verified != nil && subtle.ConstantTimeCompare(digest[:], verified[:]) == 1 {
+		return true
+	}
+	if bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		return false
+	}
+	u.mu.Lock()
+	u.verified = &digest
+	u.mu.Unlock()
+	return true
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hashed-pw"), bcrypt.MinCost)
+	require.NoError(t, err)
+	a, err := NewBasic(BasicConfig{Users: []string{
+		"app:plain-pw:kv:read kv:write",
+		"ops:" + string(hash) + ":kv:admin",
+	}})
+	require.NoError(t, err)
+
+	authenticate := func(name, password string) (*Principal, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(name, password)
+		return a.Authenticate(r)
+	}
+
+	p, err := authenticate("app", "plain-pw")
+	require.NoError(t, err)
+	assert.Equal(t, &Principal{Subject: "app", Scopes: []string{ScopeRead, ScopeWrite}}, p)
+
+	for i := 0; i < 2; i++ {
+		p, err = authenticate("ops", "hashed-pw")
+		require.NoError(t, err)
+		assert.Equal(t, []string{ScopeAdmin}, p.Scopes)
+	}
+
+	_, err = authenticate("app", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = authenticate("ops", "wrong")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = authenticate("nobody", "plain-pw")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	_, err = a.Authenticate(r)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestNewBasic(t *testing.T) {
+	for _, users := range [][]string{nil, {"app"}, {"app:"}, {":pw:"}, {"app:pw:", "app:other:"}} {
+		_, err := NewBasic(BasicConfig{Users: users})
+		assert.Error(t, err, users)
+	}
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWTConfig holds the configuration of bearer token authentication. Tokens
+// are verified with a shared secret, for HMAC signatures, or with the keys
+// published at a JWKS URL by an identity provider, for RSA, ECDSA and EdDSA
+// signatures.
+type JWTConfig struct {
+	// Secret verifies HS256, HS384 and HS512 tokens.
+	Secret string `envconfig:"SECRET"`
+	// JWKSURL is the URL of the JSON Web Key Set verifying the other
+	// tokens, matched by their kid header.
+	JWKSURL string `envconfig:"JWKS_URL"`
+	// JWKSRefresh is how often the key set is fetched again. Unknown key
+	// IDs also refresh it, at most once a minute.
+	JWKSRefresh time.Duration `envconfig:"JWKS_REFRESH" default:"1h"`
+	// Issuer and Audience, when set, must match the iss and aud claims.
+	Issuer   string `envconfig:"ISSUER"`
+	Audience string `envconfig:"AUDIENCE"`
+	// Leeway tolerates clock skew when validating the time claims.
+	Leeway time.Duration `envconfig:"LEEWAY" default:"30s"`
+}
+
+func (c JWTConfig) enabled() bool {
+	return c.Secret != "" || c.JWKSURL != ""
+}
+
+// JWT authenticates requests with a JWT bearer token. The subject of the
+// principal is the sub claim and its scopes the space separated scope claim
+// or the scp claim, a list or a space separated string, as issued by the
+// common identity providers, and its roles the roles claim. Tokens must
+// expire.
+type JWT struct {
+	secret []byte
+	keys   *keySet
+	parser *jwt.Parser
+}
+
+// NewJWT returns a JWT authenticator configured by cfg.
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	if !cfg.enabled() {
+		return nil, errors.New("a secret or a jwks url is required")
+	}
+
+	a := &JWT{secret: []byte(cfg.Secret)}
+	var methods []string
+	if cfg.Secret != "" {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWKSURL != "" {
+		a.keys = newKeySet(cfg.JWKSURL, cfg.JWKSRefresh)
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA")
+	}
+	a.parser = jwt.NewParser(
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+		jwt.WithLeeway(cfg.Leeway),
+	)
+	return a, nil
+}
+
+// Challenge implements Authenticator.
+func (a *JWT) Challenge() string {
+	return `Bearer realm="key-value-store"`
+}
+
+// Authenticate implements Authenticator.
+func (a *JWT) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(strings.TrimSpace(token), claims, a.key); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCredentials, err)
+	}
+
+	subject, _ := claims.GetSubject()
+	return &Principal{
+		Subject: subject,
+		Scopes:  claimStrings(claims, "scope", "scp"),
+		Roles:   claimStrings(claims, "roles"),
+	}, nil
+}
+
+// key returns the key verifying token, the secret for HMAC signatures and
+// the key of the key set matching its kid otherwise.
+func (a *JWT) key(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return a.secret, nil
+	}
+	kid, _ := token.Header["kid"].(string)
+	return a.keys.key(kid)
+}
+
+// claimStrings returns the strings of the named claims, lists or space
+// separated strings.
+func claimStrings(claims jwt.MapClaims, names ...string) []string {
+	var values []string
+	for _, name := range names {
+		switch v := claims[name].(type) {
+		case string:
+			values = append(values, strings.Fields(v)...)
+		case []any:
+			for _, s := range v {
+				if s, ok := s.(string); ok {
+					values = append(values, s)
+				}
+			}
+		}
+	}
+	return values
+}
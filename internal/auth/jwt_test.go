@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func request(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/key/a", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(key)
+	require.NoError(t, err)
+	return s
+}
+
+func TestJWTSecret(t *testing.T) {
+	secret := []byte("secret")
+	a, err := NewJWT(JWTConfig{Secret: string(secret), Issuer: "idp", Audience: "kv"})
+	require.NoError(t, err)
+	exp := time.Now().Add(time.Hour).Unix()
+
+	t.Run("valid token", func(t *testing.T) {
+		token := sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{
+			"sub": "alice", "iss": "idp", "aud": "kv", "exp": exp, "scope": "kv:read kv:write",
+		})
+		p, err := a.Authenticate(request(token))
+		require.NoError(t, err)
+		assert.Equal(t, &Principal{Subject: "alice", Scopes: []string{ScopeRead, ScopeWrite}}, p)
+	})
+
+	t.Run("scp claim", func(t *testing.T) {
+		token := sign(t, jwt.SigningMethodHS512, secret, "", jwt.MapClaims{
+			"sub": "bob", "iss": "idp", "aud": []string{"kv"}, "exp": exp, "scp": []string{ScopeAdmin}, "roles": []string{"ops"},
+		})
+		p, err := a.Authenticate(request(token))
+		require.NoError(t, err)
+		assert.Equal(t, &Principal{Subject: "bob", Scopes: []string{ScopeAdmin}, Roles: []string{"ops"}}, p)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		_, err := a.Authenticate(request(""))
+		assert.ErrorIs(t, err, ErrNoCredentials)
+	})
+
+	rejected := map[string]string{
+		"wrong secret": sign(t, jwt.SigningMethodHS256, []byte("other"), "", jwt.MapClaims{"iss": "idp", "aud": "kv", "exp": exp}),
+		"expired":      sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "idp", "aud": "kv", "exp": time.Now().Add(-time.Hour).Unix()}),
+		"no expiry":    sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "idp", "aud": "kv"}),
+		"wrong issuer": sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "other", "aud": "kv", "exp": exp}),
+		"wrong aud":    sign(t, jwt.SigningMethodHS256, secret, "", jwt.MapClaims{"iss": "idp", "aud": "other", "exp": exp}),
+		"unsigned":     sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "", jwt.MapClaims{"iss": "idp", "aud": "kv", "exp": exp}),
+		"malformed":    "not-a-token",
+	}
+	for name, token := range rejected {
+		t.Run(name, func(t *testing.T) {
+			_, err := a.Authenticate(request(token))
+			assert.ErrorIs(t, err, ErrInvalidCredentials)
+		})
+	}
+
+	t.Run("basic scheme", func(t *testing.T) {
+		r := request("")
+		r.SetBasicAuth("alice", "secret")
+		_, err := a.Authenticate(r)
+		assert.ErrorIs(t, err, ErrNoCredentials)
+	})
+}
+
+func TestJWTKeySet(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	encode := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.Bytes()), "y": encode(ecKey.Y.Bytes())},
+			{"kty": "RSA", "kid": "enc", "use": "enc", "n": encode(rsaKey.N.Bytes()), "e": "AQAB"},
+		}})
+	}))
+	t.Cleanup(srv.Close)
+
+	a, err := NewJWT(JWTConfig{JWKSURL: srv.URL, JWKSRefresh: time.Hour})
+	require.NoError(t, err)
+	claims := jwt.MapClaims{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix(), "scope": ScopeRead}
+
+	p, err := a.Authenticate(request(sign(t, jwt.SigningMethodRS256, rsaKey, "rsa", claims)))
+	require.NoError(t, err)
+	assert.Equal(t, "svc", p.Subject)
+
+	_, err = a.Authenticate(request(sign(t, jwt.SigningMethodES256, ecKey, "ec", claims)))
+	require.NoError(t, err)
+
+	// Tokens signed with a key of another type or for encryption are
+	// rejected, and unknown key IDs don't refetch the set within a minute.
+	_, err = a.Authenticate(request(sign(t, jwt.SigningMethodRS256, rsaKey, "ec", claims)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(request(sign(t, jwt.SigningMethodRS256, rsaKey, "enc", claims)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = a.Authenticate(request(sign(t, jwt.SigningMethodRS256, rsaKey, "unknown", claims)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	// HMAC tokens are only accepted with a secret.
+	_, err = a.Authenticate(request(sign(t, jwt.SigningMethodHS256, []byte(""), "", claims)))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestNew(t *testing.T) {
+	a, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, a)
+
+	a, err = New(Config{JWT: JWTConfig{Secret: "secret"}})
+	require.NoError(t, err)
+	assert.IsType(t, &JWT{}, a)
+
+	a, err = New(Config{JWT: JWTConfig{Secret: "secret"}, Basic: BasicConfig{Users: []string{"app:pw:"}}})
+	require.NoError(t, err)
+	assert.IsType(t, Chain{}, a)
+	assert.Equal(t, `Bearer realm="key-value-store", Basic realm="key-value-store", charset="UTF-8"`, a.Challenge())
+
+	_, err = New(Config{Basic: BasicConfig{Users: []string{"app"}}})
+	assert.Error(t, err)
+	_, err = New(Config{Basic: BasicConfig{Users: []string{"app:pw:team-a"}}})
+	assert.EqualError(t, err, `basic: user "app": unknown role "team-a"`)
+	_, err = New(Config{JWT: JWTConfig{Secret: "secret"}, Roles: map[string]string{"team-a": "kv:root"}})
+	assert.EqualError(t, err, `role "team-a": invalid scope "kv:root"`)
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Permission is the access granted by a scope, each permission includes the
+// lower ones.
+type Permission int
+
+// Permissions, in increasing order.
+const (
+	Read Permission = iota + 1
+	Write
+	Admin
+)
+
+var permissionNames = map[Permission]string{Read: "read", Write: "write", Admin: "admin"}
+
+func (p Permission) String() string {
+	return permissionNames[p]
+}
+
+// Grant is a permission on the keys starting with Prefix, every key when
+// empty.
+type Grant struct {
+	Permission Permission
+	Prefix     string
+}
+
+// ParseScope parses a kv:<permission> scope, granting the permission on
+// every key, or a kv:<permission>:<prefix> scope, granting it on the keys
+// starting with prefix. Other scopes, such as those of other services,
+// aren't grants.
+func ParseScope(scope string) (Grant, bool) {
+	rest, ok := strings.CutPrefix(scope, "kv:")
+	if !ok {
+		return Grant{}, false
+	}
+	name, prefix, _ := strings.Cut(rest, ":")
+	for p, n := range permissionNames {
+		if n == name {
+			return Grant{Permission: p, Prefix: prefix}, true
+		}
+	}
+	return Grant{}, false
+}
+
+// Can reports whether p was granted perm on key. Listing the keys starting
+// with a prefix checks the prefix as a key.
+func (p *Principal) Can(perm Permission, key string) bool {
+	for _, scope := range p.Scopes {
+		g, ok := ParseScope(scope)
+		if ok && g.Permission >= perm && strings.HasPrefix(key, g.Prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether p may call a route requiring scope: with the
+// permission of the scope on some keys for the key routes, the store
+// operations checking the keys, and on every key for the admin routes.
+func (p *Principal) Allows(scope string) bool {
+	want, ok := ParseScope(scope)
+	if !ok {
+		return false
+	}
+	if want.Permission == Admin {
+		return p.Can(Admin, "")
+	}
+	for _, s := range p.Scopes {
+		g, ok := ParseScope(s)
+		if ok && g.Permission >= want.Permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize checks the principal of ctx was granted perm on key, returning
+// an error wrapping ErrForbidden otherwise. Contexts without principal are
+// authorized.
+func Authorize(ctx context.Context, perm Permission, key string) error {
+	p, ok := FromContext(ctx)
+	if !ok || p.Can(perm, key) {
+		return nil
+	}
+	return fmt.Errorf("%w: no %s permission on key %q", ErrForbidden, perm, key)
+}
+
+// parseRoles parses the scopes of the configured roles.
+func parseRoles(roles map[string]string) (map[string][]string, error) {
+	parsed := make(map[string][]string, len(roles))
+	for name, scopes := range roles {
+		for _, scope := range strings.Fields(scopes) {
+			if _, ok := ParseScope(scope); !ok {
+				return nil, fmt.Errorf("role %q: invalid scope %q", name, scope)
+			}
+		}
+		parsed[name] = strings.Fields(scopes)
+	}
+	return parsed, nil
+}
+
+// roleExpander adds the scopes of the roles of principals to their scopes.
+type roleExpander struct {
+	Authenticator
+	roles map[string][]string
+}
+
+func (e *roleExpander) Authenticate(r *http.Request) (*Principal, error) {
+	p, err := e.Authenticator.Authenticate(r)
+	if err != nil || len(p.Roles) == 0 {
+		return p, err
+	}
+	// Principals of Basic users are shared by their requests.
+	expanded := *p
+	expanded.Scopes = append([]string(nil), p.Scopes...)
+	for _, role := range p.Roles {
+		expanded.Scopes = append(expanded.Scopes, e.roles[role]...)
+	}
+	return &expanded, nil
+}
@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseScope(t *testing.T) {
+	for scope, want := range map[string]Grant{
+		"kv:read":          {Permission: Read},
+		"kv:write:team-a/": {Permission: Write, Prefix: "team-a/"},
+		"kv:admin:a:b":     {Permission: Admin, Prefix: "a:b"},
+	} {
+		g, ok := ParseScope(scope)
+		assert.True(t, ok, scope)
+		assert.Equal(t, want, g, scope)
+	}
+	for _, scope := range []string{"", "kv", "kv:", "kv:root", "openid", "s3:read"} {
+		_, ok := ParseScope(scope)
+		assert.False(t, ok, scope)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	p := &Principal{Scopes: []string{"openid", "kv:read:shared/", "kv:write:team-a/"}}
+
+	assert.True(t, p.Can(Read, "shared/config"))
+	assert.False(t, p.Can(Write, "shared/config"))
+	assert.True(t, p.Can(Read, "team-a/x"))
+	assert.True(t, p.Can(Write, "team-a/x"))
+	assert.False(t, p.Can(Read, "team-b/x"))
+	assert.False(t, p.Can(Read, ""))
+
+	assert.True(t, p.Allows(ScopeRead))
+	assert.True(t, p.Allows(ScopeWrite))
+	assert.False(t, p.Allows(ScopeAdmin))
+
+	// Admin routes require the permission on every key.
+	p = &Principal{Scopes: []string{"kv:admin:team-a/"}}
+	assert.True(t, p.Can(Write, "team-a/x"))
+	assert.False(t, p.Allows(ScopeAdmin))
+	p = &Principal{Scopes: []string{ScopeAdmin}}
+	assert.True(t, p.Allows(ScopeAdmin))
+	assert.True(t, p.Can(Write, "team-b/x"))
+}
+
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	require.NoError(t, Authorize(ctx, Write, "key"))
+
+	ctx = NewContext(ctx, &Principal{Subject: "alice", Scopes: []string{ScopeRead, "kv:write:team-a/"}})
+	require.NoError(t, Authorize(ctx, Read, "team-b/x"))
+	require.NoError(t, Authorize(ctx, Write, "team-a/x"))
+	err := Authorize(ctx, Write, "team-b/x")
+	assert.ErrorIs(t, err, ErrForbidden)
+	assert.EqualError(t, err, `forbidden: no write permission on key "team-b/x"`)
+}
+
+func TestRoles(t *testing.T) {
+	a, err := New(Config{
+		Basic: BasicConfig{Users: []string{"alice:pw:team-a kv:read:shared/", "ops:pw:kv:admin"}},
+		Roles: map[string]string{"team-a": "kv:write:team-a/ kv:read:team-b/"},
+	})
+	require.NoError(t, err)
+
+	authenticate := func(name string) *Principal {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.SetBasicAuth(name, "pw")
+		p, err := a.Authenticate(r)
+		require.NoError(t, err)
+		return p
+	}
+
+	want := &Principal{
+		Subject: "alice",
+		Scopes:  []string{"kv:read:shared/", "kv:write:team-a/", "kv:read:team-b/"},
+		Roles:   []string{"team-a"},
+	}
+	assert.Equal(t, want, authenticate("alice"))
+	// Expanding roles doesn't grow the scopes of the configured user.
+	assert.Equal(t, want, authenticate("alice"))
+	assert.Equal(t, &Principal{Subject: "ops", Scopes: []string{ScopeAdmin}}, authenticate("ops"))
+}
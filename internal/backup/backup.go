@@ -0,0 +1,297 @@
+// Package backup takes scheduled backups of the store.
+//
+// A Scheduler writes a snapshot of the store to a Target at the times of
+// its Schedule, then prunes the backups exceeding the retention count or
+// older than the retention age. Backups are store snapshots, the format of
+// the snapshot files read by kvadmin, named after the time they were taken
+// so targets such as object storage don't need file metadata to order
+// them. The most recent backup is never pruned.
+//
+// The Handler serves on-demand backups and restores over HTTP, so operators
+// recover the store without access to the host.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/metrics"
+)
+
+// Config holds the configuration of scheduled backups.
+type Config struct {
+	// Schedule is the cron expression of the backup times, such as
+	// "0 3 * * *" or "@every 6h". Empty disables scheduled backups.
+	Schedule string `envconfig:"SCHEDULE"`
+	// Dir is the directory the backups are written to.
+	Dir string `envconfig:"DIR"`
+	// S3 writes the backups to an S3 bucket instead of Dir.
+	S3 S3Config `envconfig:"S3"`
+	// Retention is the number of backups kept, 0 keeps every backup.
+	Retention int `envconfig:"RETENTION" default:"7"`
+	// MaxAge prunes the backups older than it, 0 disables it.
+	MaxAge time.Duration `envconfig:"MAX_AGE"`
+	// Timeout bounds the duration of a backup, 0 disables it.
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"10m"`
+	// OnShutdown takes a last backup when the server shuts down, within
+	// its shutdown timeout.
+	OnShutdown bool `envconfig:"ON_SHUTDOWN"`
+}
+
+// Source writes a snapshot of the store, such as a
+// repository.KeyValueStore.
+type Source interface {
+	Snapshot(ctx context.Context, w io.Writer) error
+}
+
+// Target stores backups.
+type Target interface {
+	// Write stores the backup name with the content of r, leaving no backup
+	// if it fails.
+	Write(ctx context.Context, name string, r io.Reader) error
+	// List returns the names of the stored files.
+	List(ctx context.Context) ([]string, error)
+	// Remove deletes the backup name.
+	Remove(ctx context.Context, name string) error
+	// String describes the target in logs.
+	String() string
+}
+
+// Artifact is a backup stored by a target.
+type Artifact struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// Names of backups, backup-20240601T093000.000Z.snap.
+const (
+	namePrefix = "backup-"
+	nameSuffix = ".snap"
+	timeLayout = "20060102T150405.000Z"
+)
+
+// Name returns the name of the backup taken at t.
+func Name(t time.Time) string {
+	return namePrefix + t.UTC().Format(timeLayout) + nameSuffix
+}
+
+// ParseName returns the backup named name, reporting false for the names
+// of other files.
+func ParseName(name string) (Artifact, bool) {
+	ts, ok := strings.CutPrefix(name, namePrefix)
+	if !ok {
+		return Artifact{}, false
+	}
+	if ts, ok = strings.CutSuffix(ts, nameSuffix); !ok {
+		return Artifact{}, false
+	}
+	t, err := time.Parse(timeLayout, ts)
+	if err != nil {
+		return Artifact{}, false
+	}
+	return Artifact{Name: name, Time: t}, true
+}
+
+// List returns the backups stored by target, most recent first.
+func List(ctx context.Context, target Target) ([]Artifact, error) {
+	names, err := target.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list backups: %w", err)
+	}
+	var artifacts []Artifact
+	for _, name := range names {
+		if a, ok := ParseName(name); ok {
+			artifacts = append(artifacts, a)
+		}
+	}
+	sort.Slice(artifacts, func(i, j int) bool { return artifacts[i].Time.After(artifacts[j].Time) })
+	return artifacts, nil
+}
+
+// Scheduler takes the scheduled backups, a server.Service.
+type Scheduler struct {
+	log      zerolog.Logger
+	cfg      Config
+	schedule Schedule
+	source   Source
+	target   Target
+	now      func() time.Time
+
+	// mu serializes backups, so pruning doesn't race with a backup being
+	// written.
+	mu sync.Mutex
+
+	done chan struct{}
+	stop sync.Once
+}
+
+// New returns the scheduler configured by cfg, nil without schedule.
+// Backups are written to the S3 bucket or the directory of cfg unless
+// target is set.
+func New(log zerolog.Logger, cfg Config, source Source, target Target) (*Scheduler, error) {
+	if cfg.Schedule == "" {
+		return nil, nil
+	}
+	schedule, err := ParseSchedule(cfg.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Retention < 0 || cfg.MaxAge < 0 {
+		return nil, errors.New("backup retention must not be negative")
+	}
+	if target == nil {
+		switch {
+		case cfg.S3.Bucket != "" && cfg.Dir != "":
+			return nil, errors.New("backup directory and S3 bucket are exclusive")
+		case cfg.S3.Bucket != "":
+			if target, err = NewS3(cfg.S3); err != nil {
+				return nil, err
+			}
+		case cfg.Dir != "":
+			target = Dir(cfg.Dir)
+		default:
+			return nil, errors.New("backup schedule without backup directory or S3 bucket")
+		}
+	}
+	return &Scheduler{
+		log:      log.With().Str("component", "backup").Logger(),
+		cfg:      cfg,
+		schedule: schedule,
+		source:   source,
+		target:   target,
+		now:      time.Now,
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// Backup writes a backup of the store to the target, then prunes the
+// backups exceeding the retention.
+func (s *Scheduler) Backup(ctx context.Context) (Artifact, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := s.now()
+	a := Artifact{Name: Name(start), Time: start.UTC().Truncate(time.Millisecond)}
+	if err := write(ctx, s.source, s.target, a.Name); err != nil {
+		metrics.BackupsFailed.Add(1)
+		return Artifact{}, err
+	}
+	metrics.BackupsCompleted.Add(1)
+	s.log.Info().Str("backup", a.Name).Stringer("target", s.target).Dur("duration", s.now().Sub(start)).Msg("backup completed")
+
+	if err := s.prune(ctx); err != nil {
+		s.log.Error().Err(err).Msg("failed to prune backups")
+	}
+	return a, nil
+}
+
+// write streams a snapshot of source to the backup name of target.
+func write(ctx context.Context, source Source, target Target, name string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(source.Snapshot(ctx, pw))
+	}()
+	err := target.Write(ctx, name, pr)
+	// Unblocks the snapshot if the target stopped reading.
+	pr.CloseWithError(err)
+	if err != nil {
+		return fmt.Errorf("write backup %s: %w", name, err)
+	}
+	return nil
+}
+
+// prune removes the backups exceeding the retention count or older than
+// the retention age, keeping the most recent one.
+func (s *Scheduler) prune(ctx context.Context) error {
+	artifacts, err := List(ctx, s.target)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	for i, a := range artifacts {
+		if i == 0 {
+			continue
+		}
+		tooMany := s.cfg.Retention > 0 && i >= s.cfg.Retention
+		tooOld := s.cfg.MaxAge > 0 && now.Sub(a.Time) > s.cfg.MaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := s.target.Remove(ctx, a.Name); err != nil {
+			return fmt.Errorf("remove backup %s: %w", a.Name, err)
+		}
+		s.log.Info().Str("backup", a.Name).Msg("backup pruned")
+	}
+	return nil
+}
+
+// Name implements server.Service.
+func (s *Scheduler) Name() string {
+	return "backup"
+}
+
+// Serve implements server.Service, taking backups at the times of the
+// schedule until shut down.
+func (s *Scheduler) Serve() error {
+	for {
+		next := s.schedule.Next(s.now())
+		if next.IsZero() {
+			s.log.Warn().Str("schedule", s.cfg.Schedule).Msg("backup schedule has no next time")
+			<-s.done
+			return nil
+		}
+		s.log.Debug().Time("next", next).Msg("next backup scheduled")
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		ctx, cancel := s.backupContext()
+		go func() {
+			select {
+			case <-s.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if _, err := s.Backup(ctx); err != nil {
+			s.log.Error().Err(err).Msg("backup failed")
+		}
+		cancel()
+	}
+}
+
+func (s *Scheduler) backupContext() (context.Context, context.CancelFunc) {
+	if s.cfg.Timeout > 0 {
+		return context.WithTimeout(context.Background(), s.cfg.Timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// Flush takes the backup on shutdown when configured, once the server
+// stopped serving requests so the backup holds their writes.
+func (s *Scheduler) Flush(ctx context.Context) error {
+	if !s.cfg.OnShutdown {
+		return nil
+	}
+	_, err := s.Backup(ctx)
+	return err
+}
+
+// Shutdown implements server.Service, canceling a backup in progress.
+func (s *Scheduler) Shutdown(context.Context) error {
+	s.stop.Do(func() { close(s.done) })
+	return nil
+}
@@ -0,0 +1,151 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type sourceFunc func(ctx context.Context, w io.Writer) error
+
+func (f sourceFunc) Snapshot(ctx context.Context, w io.Writer) error {
+	return f(ctx, w)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New(zerolog.Nop(), Config{Dir: t.TempDir()}, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	for _, cfg := range []Config{
+		{Schedule: "@daily"},
+		{Schedule: "every day", Dir: "backups"},
+		{Schedule: "@daily", Dir: "backups", Retention: -1},
+	} {
+		_, err := New(zerolog.Nop(), cfg, nil, nil)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestParseName(t *testing.T) {
+	at := time.Date(2024, 6, 1, 9, 30, 0, 123e6, time.UTC)
+	a, ok := ParseName(Name(at))
+	require.True(t, ok)
+	assert.Equal(t, Artifact{Name: "backup-20240601T093000.123Z.snap", Time: at}, a)
+
+	for _, name := range []string{"notes.txt", "backup-today.snap", "backup-20240601T093000.123Z.snap.1234.tmp"} {
+		_, ok := ParseName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600))
+
+	s, err := New(zerolog.Nop(), Config{Schedule: "@hourly", Dir: dir, Retention: 3, MaxAge: 5 * time.Hour},
+		sourceFunc(func(_ context.Context, w io.Writer) error {
+			_, err := w.Write([]byte("snapshot"))
+			return err
+		}), nil)
+	require.NoError(t, err)
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	var taken []string
+	for i := 0; i < 5; i++ {
+		a, err := s.Backup(context.Background())
+		require.NoError(t, err)
+		taken = append(taken, a.Name)
+		now = now.Add(time.Hour)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, taken[4]))
+	require.NoError(t, err)
+	assert.Equal(t, []byte("snapshot"), b)
+
+	// The retention count keeps the 3 most recent backups, and files
+	// other than backups are left alone.
+	artifacts, err := List(context.Background(), Dir(dir))
+	require.NoError(t, err)
+	require.Len(t, artifacts, 3)
+	assert.Equal(t, taken[4], artifacts[0].Name)
+	assert.Equal(t, taken[2], artifacts[2].Name)
+	assert.FileExists(t, filepath.Join(dir, "notes.txt"))
+
+	// Backups older than the retention age are pruned, but the most recent
+	// one is kept.
+	s.cfg.Retention = 0
+	s.source = sourceFunc(func(context.Context, io.Writer) error { return errors.New("snapshot failed") })
+	now = now.Add(10 * time.Hour)
+	_, err = s.Backup(context.Background())
+	assert.EqualError(t, err, "write backup "+Name(now)+": snapshot failed")
+	require.NoError(t, s.prune(context.Background()))
+	artifacts, err = List(context.Background(), Dir(dir))
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	assert.Equal(t, taken[4], artifacts[0].Name)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "failed backups leave no file")
+}
+
+func TestServe(t *testing.T) {
+	done := make(chan string, 1)
+	s, err := New(zerolog.Nop(), Config{Schedule: "@every 1s", Dir: t.TempDir()}, sourceFunc(func(_ context.Context, w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewBufferString("snapshot"))
+		return err
+	}), nil)
+	require.NoError(t, err)
+
+	served := make(chan error)
+	go func() { served <- s.Serve() }()
+	go func() {
+		for {
+			if artifacts, _ := List(context.Background(), s.target); len(artifacts) > 0 {
+				done <- artifacts[0].Name
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no backup taken")
+	}
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.NoError(t, <-served)
+}
+
+func TestFlush(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(zerolog.Nop(), Config{Schedule: "@daily", Dir: dir}, sourceFunc(func(_ context.Context, w io.Writer) error {
+		_, err := w.Write([]byte("snapshot"))
+		return err
+	}), nil)
+	require.NoError(t, err)
+
+	require.NoError(t, s.Flush(context.Background()))
+	artifacts, err := List(context.Background(), s.target)
+	require.NoError(t, err)
+	assert.Empty(t, artifacts, "no backup without ON_SHUTDOWN")
+
+	s.cfg.OnShutdown = true
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.NoError(t, s.Flush(context.Background()))
+	artifacts, err = List(context.Background(), s.target)
+	require.NoError(t, err)
+	assert.Len(t, artifacts, 1)
+}
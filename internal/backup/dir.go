@@ -0,0 +1,74 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Dir is a Target writing backups to a local directory, created if needed.
+type Dir string
+
+// Write implements Target. The backup is written to a temporary file
+// renamed once synced, so an interrupted backup leaves no partial file.
+func (d Dir) Write(ctx context.Context, name string, r io.Reader) error {
+	if err := os.MkdirAll(string(d), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(string(d), name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, ctxReader{ctx: ctx, r: r})
+	if err == nil {
+		err = f.Sync()
+	}
+	err = errors.Join(err, f.Close())
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(string(d), name))
+}
+
+// List implements Target. A missing directory holds no backup.
+func (d Dir) List(context.Context) ([]string, error) {
+	entries, err := os.ReadDir(string(d))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// Remove implements Target.
+func (d Dir) Remove(_ context.Context, name string) error {
+	return os.Remove(filepath.Join(string(d), filepath.Base(name)))
+}
+
+func (d Dir) String() string {
+	return string(d)
+}
+
+// ctxReader fails reads once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,91 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// RestoreResponse reports the keys of a restore, also written before it
+// failed.
+type RestoreResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	RestoreResult
+}
+
+// Handler serves the backup and restore admin endpoints.
+type Handler struct {
+	log  zerolog.Logger
+	repo repository.Store
+}
+
+// NewHandler returns the admin handler backing up and restoring repo.
+func NewHandler(log zerolog.Logger, repo repository.Store) *Handler {
+	return &Handler{log: log, repo: repo}
+}
+
+// Backup streams a backup of the store, in the format of the scheduled
+// backups.
+func (h *Handler) Backup(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	data, err := Export(r.Context(), h.repo)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to export the store")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to back up the store", StatusCode: store.StatusStorageError})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", Name(time.Now())))
+	if err := repository.EncodeSnapshot(w, data); err != nil {
+		log.Error().Err(err).Msg("error writing backup")
+		return
+	}
+	log.Info().Int("keys", len(data.Store)).Msg("backup downloaded")
+}
+
+// Restore writes the keys of the backup of the request body to the store.
+// With the mode query parameter set to replace, the keys missing from the
+// backup are deleted; by default, merge, they are kept.
+func (h *Handler) Restore(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	var replace bool
+	switch mode := r.URL.Query().Get("mode"); mode {
+	case "", "merge":
+	case "replace":
+		replace = true
+	default:
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "invalid mode, expected merge or replace", StatusCode: store.StatusInvalidBackup})
+		return
+	}
+
+	result, err := Restore(r.Context(), r.Body, h.repo, replace)
+	resp := RestoreResponse{Message: "backup restored", StatusCode: store.StatusSuccess, RestoreResult: result}
+	code := http.StatusOK
+	switch {
+	case errors.Is(err, ErrInvalidBackup):
+		code, resp.Message, resp.StatusCode = http.StatusBadRequest, err.Error(), store.StatusInvalidBackup
+	case err != nil:
+		log.Error().Err(err).Int("restored", result.Restored).Int("deleted", result.Deleted).Msg("failed to restore backup")
+		code, resp.Message, resp.StatusCode = http.StatusInternalServerError, "failed to restore backup, it was partially applied", store.StatusStorageError
+	default:
+		log.Warn().Bool("replace", replace).Int("restored", result.Restored).Int("expired", result.Expired).Int("deleted", result.Deleted).Msg("backup restored")
+	}
+	writeJSON(log, w, code, resp)
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
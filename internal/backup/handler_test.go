@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func newRepo(t *testing.T, entries map[string]string) *repository.KeyValueStore {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	for key, value := range entries {
+		require.NoError(t, repo.Set(context.Background(), key, []byte(value)))
+	}
+	return repo
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	src := newRepo(t, map[string]string{"a": "1", "b": "2"})
+	require.NoError(t, src.SetWithTTL(ctx, "session", []byte("s"), time.Hour))
+
+	rec := httptest.NewRecorder()
+	NewHandler(zerolog.Nop(), src).Backup(rec, httptest.NewRequest(http.MethodPost, "/admin/backup", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="backup-\d{8}T\d{6}\.\d{3}Z\.snap"$`, rec.Header().Get("Content-Disposition"))
+	backup := rec.Body.Bytes()
+
+	restore := func(repo repository.Store, query string, body []byte) (int, RestoreResponse) {
+		rec := httptest.NewRecorder()
+		NewHandler(zerolog.Nop(), repo).Restore(rec, httptest.NewRequest(http.MethodPost, "/admin/restore"+query, bytes.NewReader(body)))
+		var resp RestoreResponse
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+		return rec.Code, resp
+	}
+
+	t.Run("merge", func(t *testing.T) {
+		dst := newRepo(t, map[string]string{"a": "old", "c": "3"})
+		code, resp := restore(dst, "", backup)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, RestoreResponse{Message: "backup restored", StatusCode: store.StatusSuccess, RestoreResult: RestoreResult{Restored: 3}}, resp)
+
+		items, err := dst.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		require.Len(t, items, 4)
+		assert.Equal(t, repository.Item{Key: "a", Value: []byte("1")}, items[0])
+		assert.Equal(t, "c", items[2].Key)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), items[3].ExpiresAt, time.Minute)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		dst := newRepo(t, map[string]string{"a": "old", "c": "3"})
+		code, resp := restore(dst, "?mode=replace", backup)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, RestoreResult{Restored: 3, Deleted: 1}, resp.RestoreResult)
+
+		_, exists, err := dst.Get(ctx, "c")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		dst := newRepo(t, nil)
+		code, resp := restore(dst, "?mode=overwrite", backup)
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, store.StatusInvalidBackup, resp.StatusCode)
+
+		code, resp = restore(dst, "", []byte("not a backup"))
+		assert.Equal(t, http.StatusBadRequest, code)
+		assert.Equal(t, store.StatusInvalidBackup, resp.StatusCode)
+		assert.Contains(t, resp.Message, "invalid backup: ")
+	})
+}
+
+func TestRestoreScheduledBackup(t *testing.T) {
+	ctx := context.Background()
+	src := newRepo(t, map[string]string{"a": "1"})
+	dir := t.TempDir()
+	s, err := New(zerolog.Nop(), Config{Schedule: "@daily", Dir: dir}, src, nil)
+	require.NoError(t, err)
+	a, err := s.Backup(ctx)
+	require.NoError(t, err)
+
+	f, err := os.Open(filepath.Join(dir, a.Name))
+	require.NoError(t, err)
+	defer f.Close()
+	dst := newRepo(t, nil)
+	result, err := Restore(ctx, f, dst, false)
+	require.NoError(t, err)
+	assert.Equal(t, RestoreResult{Restored: 1}, result)
+
+	value, _, err := dst.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+}
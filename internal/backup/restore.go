@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"codesignal/internal/repository"
+)
+
+// ErrInvalidBackup is returned when restoring a payload that isn't a backup.
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// exportBatchSize is the number of keys scanned per batch by Export and by
+// the replacing restores.
+const exportBatchSize = 1000
+
+// Export copies the live entries of repo, the way Snapshot copies those of
+// a repository.KeyValueStore, through any repository such as the Raft node
+// of clustered mode. It includes the entries hidden from the API, such as
+// key owners. Repositories implementing repository.Exporter are copied as
+// of a point in time, others page by page, each page seeing the writes
+// made since the previous one.
+func Export(ctx context.Context, repo repository.Scanner) (repository.Data, error) {
+	if exporter, ok := repo.(repository.Exporter); ok {
+		return exporter.Export(ctx)
+	}
+	data := repository.Data{Store: map[string][]byte{}, Expiry: map[string]int64{}}
+	after := ""
+	for {
+		items, err := repo.Scan(ctx, "", after, exportBatchSize)
+		if err != nil {
+			return repository.Data{}, fmt.Errorf("scan after %q: %w", after, err)
+		}
+		for _, item := range items {
+			data.Store[item.Key] = item.Value
+			if !item.ExpiresAt.IsZero() {
+				data.Expiry[item.Key] = item.ExpiresAt.UnixNano()
+			}
+			after = item.Key
+		}
+		if len(items) < exportBatchSize {
+			return data, nil
+		}
+	}
+}
+
+// RestoreResult counts the keys of a restore.
+type RestoreResult struct {
+	// Restored is the number of keys written from the backup.
+	Restored int `json:"restored"`
+	// Expired is the number of keys of the backup that expired since.
+	Expired int `json:"expired"`
+	// Deleted is the number of keys missing from the backup deleted by a
+	// replacing restore.
+	Deleted int `json:"deleted"`
+}
+
+// Restore writes the keys of the backup read from r to repo, with their
+// remaining time-to-live, overwriting the keys already set. Other keys are
+// kept, unless replace is set: they are then deleted, so the repository
+// holds the keys of the backup only. Keys are written through the
+// repository, so they are replicated in clustered mode. When Restore fails,
+// the result counts the keys written before it did.
+func Restore(ctx context.Context, r io.Reader, repo repository.Store, replace bool) (RestoreResult, error) {
+	var result RestoreResult
+	data, err := repository.DecodeSnapshot(r)
+	if err != nil {
+		return result, fmt.Errorf("%w: %w", ErrInvalidBackup, err)
+	}
+
+	if replace {
+		if result.Deleted, err = deleteMissing(ctx, repo, data); err != nil {
+			return result, err
+		}
+	}
+
+	keys := make([]string, 0, len(data.Store))
+	for key := range data.Store {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	now := time.Now()
+	for _, key := range keys {
+		value := data.Store[key]
+		var err error
+		if expiresAt, ok := data.Expiry[key]; ok {
+			ttl := time.Unix(0, expiresAt).Sub(now)
+			if ttl <= 0 {
+				result.Expired++
+				continue
+			}
+			err = repo.SetWithTTL(ctx, key, value, ttl)
+		} else {
+			err = repo.Set(ctx, key, value)
+		}
+		if err != nil {
+			return result, fmt.Errorf("write %q: %w", key, err)
+		}
+		result.Restored++
+	}
+	return result, nil
+}
+
+// deleteMissing deletes the keys of repo missing from data.
+func deleteMissing(ctx context.Context, repo repository.Store, data repository.Data) (int, error) {
+	deleted := 0
+	after := ""
+	for {
+		items, err := repo.Scan(ctx, "", after, exportBatchSize)
+		if err != nil {
+			return deleted, fmt.Errorf("scan after %q: %w", after, err)
+		}
+		for _, item := range items {
+			after = item.Key
+			if _, ok := data.Store[item.Key]; ok {
+				continue
+			}
+			if err := repo.Delete(ctx, item.Key); err != nil {
+				return deleted, fmt.Errorf("delete %q: %w", item.Key, err)
+			}
+			deleted++
+		}
+		if len(items) < exportBatchSize {
+			return deleted, nil
+		}
+	}
+}
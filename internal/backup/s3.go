@@ -0,0 +1,374 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3 is a Target streaming backups to a bucket of an S3-compatible object
+// storage, such as AWS S3 or MinIO, without writing them to local disk.
+//
+// Backups are sent with multipart uploads: only the part being uploaded is
+// held in memory. Every part is sent with its MD5 checksum and its signed
+// SHA-256 digest, which the storage verifies before storing it, and the
+// size of the completed object is checked against the bytes sent. Failed
+// uploads are aborted, so they leave no object behind.
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// S3Config holds the configuration of an S3 backup target.
+type S3Config struct {
+	// Bucket is the bucket backups are written to, empty disables the S3
+	// target.
+	Bucket string `envconfig:"BUCKET"`
+	// Prefix is prepended to the names of the backup objects, such as
+	// "kv/prod/".
+	Prefix string `envconfig:"PREFIX"`
+	// Endpoint is the URL of the storage, such as "http://minio:9000".
+	// Empty uses AWS S3 in Region.
+	Endpoint string `envconfig:"ENDPOINT"`
+	// Region is the region requests are signed for.
+	Region string `envconfig:"REGION" default:"us-east-1"`
+	// PathStyle addresses the bucket in the path of the URL instead of its
+	// host name, as most S3-compatible storages require.
+	PathStyle bool `envconfig:"PATH_STYLE"`
+	// AccessKeyID, SecretAccessKey and the optional SessionToken are the
+	// credentials requests are signed with.
+	AccessKeyID     string `envconfig:"ACCESS_KEY_ID"`
+	SecretAccessKey string `envconfig:"SECRET_ACCESS_KEY"`
+	SessionToken    string `envconfig:"SESSION_TOKEN"`
+	// SSE is the server-side encryption of the backups, AES256 or aws:kms,
+	// empty to use the default encryption of the bucket.
+	SSE string `envconfig:"SSE" default:"AES256"`
+	// SSEKMSKeyID is the KMS key encrypting the backups with aws:kms, empty
+	// for the default key of the account.
+	SSEKMSKeyID string `envconfig:"SSE_KMS_KEY_ID"`
+	// PartSize is the size of the parts of uploads, at least 5MiB. It
+	// bounds the memory used by a backup.
+	PartSize int `envconfig:"PART_SIZE" default:"16777216"`
+}
+
+// minPartSize is the minimum size of the parts of multipart uploads, but
+// the last one.
+const minPartSize = 5 << 20
+
+// NewS3 returns the S3 target configured by cfg.
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: missing bucket")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3: missing credentials")
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("s3: part size under %d bytes", minPartSize)
+	}
+	switch cfg.SSE {
+	case "", "AES256", "aws:kms":
+	default:
+		return nil, fmt.Errorf("s3: unknown server-side encryption %q", cfg.SSE)
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, client: &http.Client{}, now: time.Now}, nil
+}
+
+func (s *S3) String() string {
+	return "s3://" + s.cfg.Bucket + "/" + s.cfg.Prefix
+}
+
+// Write implements Target with a multipart upload.
+func (s *S3) Write(ctx context.Context, name string, r io.Reader) error {
+	key := s.cfg.Prefix + name
+	header := http.Header{}
+	if s.cfg.SSE != "" {
+		header.Set("X-Amz-Server-Side-Encryption", s.cfg.SSE)
+		if s.cfg.SSE == "aws:kms" && s.cfg.SSEKMSKeyID != "" {
+			header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", s.cfg.SSEKMSKeyID)
+		}
+	}
+	var initiated struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, &initiated); err != nil {
+		return fmt.Errorf("create upload: %w", err)
+	}
+
+	size, err := s.upload(ctx, key, initiated.UploadID, r)
+	if err != nil {
+		// The parts uploaded are only deleted once the upload is aborted.
+		abortCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		query := url.Values{"uploadId": {initiated.UploadID}}
+		return errors.Join(err, s.do(abortCtx, http.MethodDelete, key, query, nil, nil, nil))
+	}
+
+	resp, err := s.send(ctx, http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return fmt.Errorf("check upload: %w", err)
+	}
+	resp.Body.Close()
+	if resp.ContentLength != size {
+		return fmt.Errorf("check upload: stored %d bytes, sent %d", resp.ContentLength, size)
+	}
+	return nil
+}
+
+// completeUpload is the body completing a multipart upload.
+type completeUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []uploadedPart `xml:"Part"`
+}
+
+type uploadedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// upload sends the parts read from r and completes the upload, returning
+// the size of the object.
+func (s *S3) upload(ctx context.Context, key, uploadID string, r io.Reader) (int64, error) {
+	var (
+		complete completeUpload
+		size     int64
+		buf      = make([]byte, s.cfg.PartSize)
+	)
+	for part := 1; ; part++ {
+		n, err := io.ReadFull(r, buf)
+		if errors.Is(err, io.EOF) && part > 1 {
+			break
+		}
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return 0, err
+		}
+
+		body := buf[:n]
+		sum := md5.Sum(body)
+		header := http.Header{}
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+		query := url.Values{"partNumber": {strconv.Itoa(part)}, "uploadId": {uploadID}}
+		resp, err := s.send(ctx, http.MethodPut, key, query, header, body)
+		if err != nil {
+			return 0, fmt.Errorf("upload part %d: %w", part, err)
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, uploadedPart{PartNumber: part, ETag: resp.Header.Get("ETag")})
+		size += int64(n)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, nil); err != nil {
+		return 0, fmt.Errorf("complete upload: %w", err)
+	}
+	return size, nil
+}
+
+// List implements Target, listing the objects under the prefix.
+func (s *S3) List(ctx context.Context) ([]string, error) {
+	var (
+		names []string
+		token string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.cfg.Prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var result struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := s.do(ctx, http.MethodGet, "", query, nil, nil, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			if name := strings.TrimPrefix(c.Key, s.cfg.Prefix); !strings.Contains(name, "/") {
+				names = append(names, name)
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return names, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// Remove implements Target.
+func (s *S3) Remove(ctx context.Context, name string) error {
+	return s.do(ctx, http.MethodDelete, s.cfg.Prefix+name, nil, nil, nil, nil)
+}
+
+// S3Error is an error answered by the storage.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// do sends a request, decoding the XML response into out if set.
+func (s *S3) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, out any) error {
+	resp, err := s.send(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// Completing an upload may fail after the response status was sent.
+	if bytes.Contains(b, []byte("<Error>")) {
+		return decodeError(resp.StatusCode, b)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := xml.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("s3: decode response: %w", err)
+	}
+	return nil
+}
+
+// send sends a signed request, failing with an S3Error if it isn't
+// successful.
+func (s *S3) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	path := "/" + key
+	if s.cfg.PathStyle {
+		path = "/" + s.cfg.Bucket + path
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(s.endpoint.Path, "/") + path
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		return nil, decodeError(resp.StatusCode, b)
+	}
+	return resp, nil
+}
+
+func decodeError(status int, body []byte) error {
+	e := &S3Error{StatusCode: status}
+	_ = xml.Unmarshal(body, e)
+	return e
+}
+
+// sign signs req with AWS Signature Version 4.
+func (s *S3) sign(req *http.Request, body []byte) {
+	now := s.now().UTC()
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") || k == "content-md5" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + now.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.cfg.SecretAccessKey)
+	for _, v := range []string{date, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes query sorted by key, with spaces as %20 as
+// signatures require.
+func canonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
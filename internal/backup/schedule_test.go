@@ -0,0 +1,46 @@
+package backup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	// Saturday.
+	from := time.Date(2024, 6, 1, 9, 30, 15, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{spec: "* * * * *", want: time.Date(2024, 6, 1, 9, 31, 0, 0, time.UTC)},
+		{spec: "*/15 * * * *", want: time.Date(2024, 6, 1, 9, 45, 0, 0, time.UTC)},
+		{spec: "0 3 * * *", want: time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC)},
+		{spec: "30 9 * * *", want: time.Date(2024, 6, 2, 9, 30, 0, 0, time.UTC)},
+		{spec: "0 0 * * 1-5", want: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 12 15 * *", want: time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 1", want: time.Date(2024, 6, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 1 1,7 *", want: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@hourly", want: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{spec: "@daily", want: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "@weekly", want: time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "@monthly", want: time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "@every 6h", want: from.Add(6 * time.Hour)},
+		{spec: "0 0 30 2 *", want: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := ParseSchedule(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, s.Next(from))
+		})
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "x * * * *", "@every 1ms", "@every soon"} {
+		_, err := ParseSchedule(spec)
+		assert.Error(t, err, spec)
+	}
+}
@@ -0,0 +1,135 @@
+package binproto
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/cluster"
+	"codesignal/internal/store"
+)
+
+// Request operations.
+const (
+	opGet    = 1
+	opSet    = 2
+	opDelete = 3
+)
+
+// maxFrameSize caps the size of a request, larger values are rejected by
+// the store anyway when it has a value size limit.
+const maxFrameSize = 64 << 20
+
+var (
+	// errFrameTooLarge is returned for frames over maxFrameSize.
+	errFrameTooLarge = fmt.Errorf("frame larger than %d bytes", maxFrameSize)
+	// errMalformed is returned for requests not matching their operation.
+	errMalformed = errors.New("malformed request")
+)
+
+// readFrame reads a frame into buf, grown as needed.
+func readFrame(r io.Reader, buf []byte) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return buf, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > maxFrameSize {
+		return buf, errFrameTooLarge
+	}
+	if cap(buf) < int(size) {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf, err
+	}
+	return buf, nil
+}
+
+// appendReply appends the frame of a reply to b.
+func appendReply(b []byte, code store.StatusCode, payload []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(2+len(payload)))
+	b = binary.BigEndian.AppendUint16(b, uint16(code))
+	return append(b, payload...)
+}
+
+// exec runs the request of frame and appends its reply to b.
+func (s *Server) exec(ctx context.Context, b, frame []byte) []byte {
+	if len(frame) < 3 {
+		return appendReply(b, store.StatusInvalidValue, []byte(errMalformed.Error()))
+	}
+	op, size := frame[0], int(binary.BigEndian.Uint16(frame[1:3]))
+	args := frame[3:]
+	if len(args) < size {
+		return appendReply(b, store.StatusInvalidValue, []byte(errMalformed.Error()))
+	}
+	key, args := string(args[:size]), args[size:]
+
+	switch {
+	case op == opGet && len(args) == 0:
+		value, err := s.svc.Get(ctx, key)
+		if err != nil {
+			return s.appendError(b, err)
+		}
+		return appendReply(b, store.StatusSuccess, value)
+	case op == opSet && len(args) >= 8:
+		ms := binary.BigEndian.Uint64(args)
+		if ms > uint64(math.MaxInt64/time.Millisecond) {
+			return appendReply(b, store.StatusInvalidTTL, []byte("invalid ttl"))
+		}
+		ttl := time.Duration(ms) * time.Millisecond
+		// The store keeps the value, the frame is reused.
+		value := append([]byte{}, args[8:]...)
+		if err := s.svc.Set(ctx, key, value, ttl); err != nil {
+			return s.appendError(b, err)
+		}
+		return appendReply(b, store.StatusSuccess, nil)
+	case op == opDelete && len(args) == 0:
+		if err := s.svc.Delete(ctx, key); err != nil {
+			return s.appendError(b, err)
+		}
+		return appendReply(b, store.StatusSuccess, nil)
+	default:
+		return appendReply(b, store.StatusInvalidValue, []byte(errMalformed.Error()))
+	}
+}
+
+// appendError appends the reply of the error of a store operation to b.
+func (s *Server) appendError(b []byte, err error) []byte {
+	code, message := store.StatusStorageError, err.Error()
+	switch {
+	case errors.Is(err, store.ErrInvalidKey):
+		code, message = store.StatusInvalidKey, "invalid key"
+	case errors.Is(err, store.ErrKeyTooLong):
+		code = store.StatusKeyTooLong
+	case errors.Is(err, store.ErrValueTooLarge):
+		code = store.StatusValueTooLarge
+	case errors.Is(err, store.ErrRejected):
+		code = store.StatusInvalidValue
+	case errors.Is(err, store.ErrKeyNotFound):
+		code, message = store.StatusKeyNotFound, "key not found"
+	case errors.Is(err, auth.ErrForbidden):
+		code = store.StatusForbidden
+	case errors.Is(err, store.ErrReadOnly):
+		code = store.StatusMaintenance
+	case errors.Is(err, store.ErrMemoryLimit):
+		code = store.StatusMemoryLimit
+	case errors.Is(err, context.Canceled):
+		code, message = store.StatusCanceled, "request canceled"
+	case errors.Is(err, cluster.ErrNotLeader), errors.Is(err, cluster.ErrNoLeader):
+		code = store.StatusNoLeader
+	default:
+		s.log.Error().Err(err).Msg("store operation failed")
+		message = "storage error"
+	}
+	return appendReply(b, code, []byte(message))
+}
@@ -0,0 +1,108 @@
+// Package binproto serves a length-prefixed binary protocol over
+// persistent TCP connections, for bulk clients whose throughput is bound by
+// a round trip per request over HTTP/1.1.
+//
+// Every request and reply is a frame: its length as a big-endian uint32,
+// then as many bytes. Clients pipeline requests, writing many of them
+// before reading the replies, which come back in the order of the
+// requests. A request is
+//
+//	op uint8 | key length uint16 | key | arguments
+//
+// where op is 1 for get, 2 for set, whose arguments are the ttl in
+// milliseconds as a uint64, zero never expiring, followed by the value
+// filling the rest of the frame, and 3 for delete. A reply is
+//
+//	status code uint16 | payload
+//
+// where the status code is one of the API, 1000 for success, and the
+// payload the value read by a get, or the message of an error. The
+// operations run through the same store.Service as the HTTP API.
+package binproto
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/server"
+	"codesignal/internal/store"
+)
+
+// Config holds the configuration of the binary protocol listener.
+type Config struct {
+	// Enabled turns on the binary protocol listener.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// Address is the address the listener accepts connections on.
+	Address string `envconfig:"ADDRESS" default:"0.0.0.0:7070"`
+}
+
+// bufferSize is the size of the buffers of a connection, and the largest
+// frame buffer kept between requests.
+const bufferSize = 64 << 10
+
+// Server accepts binary protocol connections.
+type Server struct {
+	*server.TCPServer
+
+	log zerolog.Logger
+	svc *store.Service
+}
+
+// New returns a binary protocol server for svc.
+func New(log zerolog.Logger, cfg Config, svc *store.Service) *Server {
+	s := &Server{
+		log: log.With().Str("component", "binproto").Logger(),
+		svc: svc,
+	}
+	s.TCPServer = server.NewTCPServer(s.log, "binproto", cfg.Address, s.handle)
+	return s
+}
+
+func (s *Server) handle(ctx context.Context, c *server.Conn) {
+	r, w := bufio.NewReaderSize(c, bufferSize), bufio.NewWriterSize(c, bufferSize)
+	var frame, reply []byte
+	for {
+		var err error
+		frame, err = readFrame(r, frame[:0])
+		if err != nil {
+			if errors.Is(err, errFrameTooLarge) {
+				// The frame isn't read, so the stream can't be
+				// resynchronized.
+				_, _ = w.Write(appendReply(nil, store.StatusInvalidValue, []byte(err.Error())))
+				_ = w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Debug().Err(err).Msg("failed to read request")
+			}
+			return
+		}
+
+		if !c.Begin() {
+			return
+		}
+		reply = s.exec(ctx, reply[:0], frame)
+		if _, err := w.Write(reply); err != nil {
+			return
+		}
+		// Replies to pipelined requests are flushed together.
+		if r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if !c.End() {
+			return
+		}
+
+		if cap(frame) > bufferSize {
+			frame = nil
+		}
+		if cap(reply) > bufferSize {
+			reply = nil
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package binproto
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+	"codesignal/pkg/client"
+)
+
+func newTestServer(t testing.TB) (string, *repository.KeyValueStore) {
+	t.Helper()
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+
+	srv := New(zerolog.Nop(), Config{}, store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8, MaxValueSize: 16}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.ServeListener(lis) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+	return lis.Addr().String(), repo
+}
+
+func TestServer(t *testing.T) {
+	addr, repo := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, err := client.DialPipeline(ctx, addr)
+	require.NoError(t, err)
+	defer p.Close()
+
+	results, err := p.Do(ctx, []client.Op{
+		{Type: client.OpSet, Key: "a", Value: "hello"},
+		{Type: client.OpGet, Key: "a"},
+		{Type: client.OpSet, Key: "ttl", Value: "1", TTL: time.Hour},
+		{Type: client.OpSet, Key: "empty"},
+		{Type: client.OpGet, Key: "empty"},
+		{Type: client.OpGet, Key: "missing"},
+		{Type: client.OpSet, Key: "too-long-key", Value: "x"},
+		{Type: client.OpSet, Key: "b", Value: "value too large!!"},
+		{Type: client.OpDelete, Key: "a"},
+		{Type: client.OpDelete, Key: "a"},
+		{Type: client.OpGet, Key: "a"},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 11)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "hello", results[1].Value)
+	assert.NoError(t, results[2].Err)
+	assert.NoError(t, results[4].Err)
+	assert.Empty(t, results[4].Value)
+	assert.ErrorIs(t, results[5].Err, client.ErrKeyNotFound)
+	assert.ErrorIs(t, results[6].Err, client.ErrKeyTooLong)
+	assert.ErrorIs(t, results[7].Err, client.ErrValueTooLarge)
+	assert.NoError(t, results[8].Err)
+	assert.ErrorIs(t, results[9].Err, client.ErrKeyNotFound)
+	assert.ErrorIs(t, results[10].Err, client.ErrKeyNotFound)
+
+	expiry, ok, err := repo.Expiry(ctx, "ttl")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute)
+
+	// Large pipelines don't deadlock on full buffers.
+	ops := make([]client.Op, 10000)
+	for i := range ops {
+		ops[i] = client.Op{Type: client.OpSet, Key: fmt.Sprintf("k%d", i/2%1000), Value: "0123456789abcdef"}
+		if i%2 == 1 {
+			ops[i].Type = client.OpGet
+		}
+	}
+	results, err = p.Do(ctx, ops)
+	require.NoError(t, err)
+	for i, result := range results {
+		require.NoError(t, result.Err, i)
+	}
+}
+
+// roundTrip writes a raw frame of body and returns the status code and the
+// payload of the reply.
+func roundTrip(t *testing.T, conn net.Conn, body []byte) (store.StatusCode, string) {
+	t.Helper()
+	_, err := conn.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body))))
+	require.NoError(t, err)
+	_, err = conn.Write(body)
+	require.NoError(t, err)
+
+	reply, err := readFrame(conn, nil)
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(reply), 2)
+	return store.StatusCode(binary.BigEndian.Uint16(reply)), string(reply[2:])
+}
+
+func TestServerMalformed(t *testing.T) {
+	addr, _ := newTestServer(t)
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	for _, body := range [][]byte{
+		nil,
+		{opGet, 0, 5, 'a'},
+		{opGet, 0, 1, 'a', 'b'},
+		{opSet, 0, 1, 'a', 0, 0},
+		{42, 0, 1, 'a'},
+	} {
+		code, message := roundTrip(t, conn, body)
+		assert.Equal(t, store.StatusInvalidValue, code, "%v", body)
+		assert.Equal(t, "malformed request", message)
+	}
+	code, message := roundTrip(t, conn, append([]byte{opSet, 0, 1, 'a'}, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff))
+	assert.Equal(t, store.StatusInvalidTTL, code, message)
+
+	// The connection stays usable after malformed requests, not after an
+	// oversized frame.
+	code, _ = roundTrip(t, conn, []byte{opGet, 0, 1, 'a'})
+	assert.Equal(t, store.StatusKeyNotFound, code)
+	_, err = conn.Write(binary.BigEndian.AppendUint32(nil, maxFrameSize+1))
+	require.NoError(t, err)
+	reply, err := readFrame(conn, nil)
+	require.NoError(t, err)
+	assert.Equal(t, store.StatusInvalidValue, store.StatusCode(binary.BigEndian.Uint16(reply)))
+	_, err = readFrame(conn, nil)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	addr, _ := newTestServer(b)
+	ctx := context.Background()
+	p, err := client.DialPipeline(ctx, addr)
+	require.NoError(b, err)
+	defer p.Close()
+
+	ops := make([]client.Op, 100)
+	for i := range ops {
+		ops[i] = client.Op{Type: client.OpGet, Key: fmt.Sprintf("k%d", i)}
+		if i%10 == 0 {
+			ops[i] = client.Op{Type: client.OpSet, Key: ops[i].Key, Value: "value"}
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if _, err := p.Do(ctx, ops); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(ops))/b.Elapsed().Seconds(), "ops/s")
+}
@@ -0,0 +1,87 @@
+// Package buildinfo reports the build of the running binary.
+//
+// The version, commit and build time are set at link time:
+//
+//	go build -ldflags "-X codesignal/internal/buildinfo.Version=v1.4.0 \
+//		-X codesignal/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X codesignal/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/store
+//
+// Without them, the commit and build time fall back to the version control
+// information the go command stamps into binaries built from a checkout.
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// Set with -ldflags "-X codesignal/internal/buildinfo.Version=...".
+var (
+	// Version is the release of the build, "dev" for development builds.
+	Version = "dev"
+	// Commit is the revision the binary was built from.
+	Commit = ""
+	// BuildTime is when the binary was built, in RFC 3339 format.
+	BuildTime = ""
+)
+
+// Info describes the build of the running binary.
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Modified reports a build from a checkout with uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Get returns the build of the running binary.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = s.Value
+				}
+			case "vcs.time":
+				if info.BuildTime == "" {
+					info.BuildTime = s.Value
+				}
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// Log adds the build to the fields of e, such as the startup log.
+func (i Info) Log(e *zerolog.Event) *zerolog.Event {
+	return e.Str("version", i.Version).Str("commit", i.Commit).Str("build_time", i.BuildTime).Str("go_version", i.GoVersion)
+}
+
+// Response is the payload of the version endpoint.
+type Response struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Info
+}
+
+// Handler answers the version endpoint.
+func Handler(log zerolog.Logger) http.Handler {
+	resp := Response{Message: "build info", StatusCode: store.StatusSuccess, Info: Get()}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			store.RequestLogger(r, &log).Error().Err(err).Msg("error writing response")
+		}
+	})
+}
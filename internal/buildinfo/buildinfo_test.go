@@ -0,0 +1,34 @@
+package buildinfo
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	defer func(version, commit, buildTime string) {
+		Version, Commit, BuildTime = version, commit, buildTime
+	}(Version, Commit, BuildTime)
+	Version, Commit, BuildTime = "v1.4.0", "4f2a9c1", "2024-06-01T09:30:00Z"
+
+	rec := httptest.NewRecorder()
+	Handler(zerolog.Nop()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, store.StatusSuccess, resp.StatusCode)
+	assert.Equal(t, "v1.4.0", resp.Version)
+	assert.Equal(t, "4f2a9c1", resp.Commit)
+	assert.Equal(t, "2024-06-01T09:30:00Z", resp.BuildTime)
+	assert.Equal(t, runtime.Version(), resp.GoVersion)
+}
@@ -0,0 +1,184 @@
+// Package cdc publishes the changes of the store to message systems, for
+// change data capture pipelines and cache invalidation.
+//
+// A Publisher consumes the change bus and hands the changes, in batches, to
+// a Sink, Kafka or NATS. Changes carry the sequence number of their event,
+// so consumers detect the changes lost when the publisher fell behind the
+// bus, and a hash of the value rather than the value itself. Publishing is
+// best effort: a batch the sink fails to write is dropped.
+package cdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+	"codesignal/internal/store"
+)
+
+// Operations of the changes.
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpExpire = "expire"
+)
+
+// maxBatch bounds the number of changes handed to a sink at once.
+const maxBatch = 500
+
+// Change is a change of a key, as published to the sinks.
+type Change struct {
+	Seq uint64 `json:"seq"`
+	Op  string `json:"op"`
+	Key string `json:"key"`
+	// ValueHash is the hex encoded SHA-256 of the value of created and
+	// updated keys.
+	ValueHash string    `json:"value_hash,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// NewChange returns the change of the event e.
+func NewChange(e events.Event) Change {
+	c := Change{Seq: e.Seq, Key: e.Key, Time: e.Time}
+	switch {
+	case e.Type == events.TypeDelete:
+		c.Op = OpDelete
+	case e.Type == events.TypeExpire:
+		c.Op = OpExpire
+	case e.Created:
+		c.Op = OpCreate
+	default:
+		c.Op = OpUpdate
+	}
+	if e.Type == events.TypeSet {
+		sum := sha256.Sum256(e.Value)
+		c.ValueHash = hex.EncodeToString(sum[:])
+	}
+	return c
+}
+
+// Sink writes changes to a message system.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Publish writes the changes, in order.
+	Publish(ctx context.Context, changes []Change) error
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// Publisher publishes the changes of the bus to a sink, a server.Service.
+type Publisher struct {
+	log    zerolog.Logger
+	sink   Sink
+	bus    *events.Bus
+	active func() bool
+
+	done    chan struct{}
+	stop    sync.Once
+	stopped chan struct{}
+}
+
+// NewPublisher returns the publisher of the changes of bus to sink.
+// Changes are only published while active returns true, so a single node
+// of a cluster publishes them; nil publishes them always.
+func NewPublisher(log zerolog.Logger, sink Sink, bus *events.Bus, active func() bool) *Publisher {
+	return &Publisher{
+		log:     log.With().Str("component", "cdc").Str("sink", sink.Name()).Logger(),
+		sink:    sink,
+		bus:     bus,
+		active:  active,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Name implements server.Service.
+func (p *Publisher) Name() string {
+	return p.sink.Name()
+}
+
+// Serve implements server.Service, publishing changes until shut down.
+func (p *Publisher) Serve() error {
+	defer close(p.stopped)
+
+	for {
+		sub := p.bus.Subscribe("")
+		p.consume(sub)
+		sub.Close()
+
+		select {
+		case <-p.done:
+			return nil
+		default:
+		}
+		// The sequence numbers of the changes report those lost.
+		p.log.Warn().Err(sub.Err()).Msg("change subscription dropped, resubscribing")
+	}
+}
+
+// Shutdown implements server.Service, closing the sink once the batch in
+// progress is published, bounded by the write timeout of the sink.
+func (p *Publisher) Shutdown(ctx context.Context) error {
+	p.stop.Do(func() { close(p.done) })
+	select {
+	case <-p.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return p.sink.Close()
+}
+
+// consume publishes the events of sub, batching those already received,
+// until it is dropped or the publisher shut down.
+func (p *Publisher) consume(sub *events.Subscription) {
+	for {
+		var batch []Change
+		select {
+		case <-p.done:
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			batch = p.append(batch, e)
+		}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					break drain
+				}
+				batch = p.append(batch, e)
+			default:
+				break drain
+			}
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		if err := p.sink.Publish(context.Background(), batch); err != nil {
+			metrics.ChangesFailed.Add(int64(len(batch)))
+			p.log.Error().Err(err).Int("changes", len(batch)).Uint64("seq", batch[0].Seq).Msg("failed to publish changes")
+			continue
+		}
+		metrics.ChangesPublished.Add(int64(len(batch)))
+	}
+}
+
+// append appends the change of e to batch unless it isn't published.
+func (p *Publisher) append(batch []Change, e events.Event) []Change {
+	if store.ReservedKey(e.Key) || (p.active != nil && !p.active()) {
+		return batch
+	}
+	return append(batch, NewChange(e))
+}
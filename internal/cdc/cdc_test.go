@@ -0,0 +1,168 @@
+package cdc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+)
+
+// recorder is a Sink recording the changes published, failing while fail
+// is set.
+type recorder struct {
+	mu      sync.Mutex
+	changes []Change
+	fail    atomic.Bool
+	closed  bool
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Publish(_ context.Context, changes []Change) error {
+	if r.fail.Load() {
+		return errors.New("unavailable")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes = append(r.changes, changes...)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.closed = true
+	return nil
+}
+
+func (r *recorder) published() []Change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Change(nil), r.changes...)
+}
+
+// changes returns the changes published by sink after those of the ready
+// key.
+func changes(sink *recorder) []Change {
+	var changes []Change
+	for _, c := range sink.published() {
+		if c.Key != "ready" {
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+func hash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestNewChange(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		event events.Event
+		want  Change
+	}{
+		{events.Event{Type: events.TypeSet, Key: "a", Value: []byte("1"), Created: true, Time: now, Seq: 1}, Change{Seq: 1, Op: OpCreate, Key: "a", ValueHash: hash("1"), Time: now}},
+		{events.Event{Type: events.TypeSet, Key: "a", Value: []byte("2"), Time: now, Seq: 2}, Change{Seq: 2, Op: OpUpdate, Key: "a", ValueHash: hash("2"), Time: now}},
+		{events.Event{Type: events.TypeDelete, Key: "a", Time: now, Seq: 3}, Change{Seq: 3, Op: OpDelete, Key: "a", Time: now}},
+		{events.Event{Type: events.TypeExpire, Key: "a", Time: now, Seq: 4}, Change{Seq: 4, Op: OpExpire, Key: "a", Time: now}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, NewChange(tt.event))
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	bus := events.NewBus(0)
+	sink := &recorder{}
+	var leader atomic.Bool
+	var checks atomic.Int32
+	leader.Store(true)
+	p := NewPublisher(zerolog.Nop(), sink, bus, func() bool {
+		checks.Add(1)
+		return leader.Load()
+	})
+	served := make(chan error, 1)
+	go func() { served <- p.Serve() }()
+
+	// Serve subscribes asynchronously, events are published until the
+	// first one is received.
+	require.Eventually(t, func() bool {
+		bus.Publish(events.Event{Type: events.TypeSet, Key: "ready"})
+		return len(sink.published()) > 0
+	}, 5*time.Second, time.Millisecond)
+
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "a", Value: []byte("1"), Created: true})
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "\xffacl:a", Value: []byte("owner")})
+	require.Eventually(t, func() bool { return len(changes(sink)) == 1 }, 5*time.Second, time.Millisecond)
+
+	failed := metrics.ChangesFailed.Value()
+	sink.fail.Store(true)
+	bus.Publish(events.Event{Type: events.TypeDelete, Key: "failed"})
+	require.Eventually(t, func() bool { return metrics.ChangesFailed.Value() == failed+1 }, 5*time.Second, time.Millisecond)
+	sink.fail.Store(false)
+
+	leader.Store(false)
+	checked := checks.Load()
+	bus.Publish(events.Event{Type: events.TypeDelete, Key: "follower"})
+	require.Eventually(t, func() bool { return checks.Load() > checked }, 5*time.Second, time.Millisecond)
+	leader.Store(true)
+	bus.Publish(events.Event{Type: events.TypeDelete, Key: "a"})
+
+	require.Eventually(t, func() bool { return len(changes(sink)) == 2 }, 5*time.Second, time.Millisecond)
+	published := changes(sink)
+	seq := published[0].Seq
+	assert.Equal(t, Change{Seq: seq, Op: OpCreate, Key: "a", ValueHash: hash("1")}, published[0])
+	assert.Equal(t, Change{Seq: seq + 4, Op: OpDelete, Key: "a"}, published[1], "the sequence reports the changes not published")
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.NoError(t, <-served)
+	assert.True(t, sink.closed)
+}
+
+func TestKafkaMessages(t *testing.T) {
+	changes := []Change{
+		{Seq: 1, Op: OpCreate, Key: "a", ValueHash: hash("1"), Time: time.Unix(1700000000, 0).UTC()},
+		{Seq: 2, Op: OpDelete, Key: "b", Time: time.Unix(1700000001, 0).UTC()},
+	}
+	msgs, err := kafkaMessages(changes)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	for i, msg := range msgs {
+		assert.Equal(t, changes[i].Key, string(msg.Key), "messages are partitioned by key")
+		assert.Equal(t, changes[i].Op, string(msg.Headers[0].Value))
+		assert.Equal(t, changes[i].Time, msg.Time)
+		var c Change
+		require.NoError(t, json.Unmarshal(msg.Value, &c))
+		assert.Equal(t, changes[i], c)
+	}
+	assert.JSONEq(t, `{"seq":2,"op":"delete","key":"b","time":"2023-11-14T22:13:21Z"}`, string(msgs[1].Value))
+}
+
+func TestNewKafka(t *testing.T) {
+	k, err := NewKafka(KafkaConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, k, "disabled without brokers")
+
+	_, err = NewKafka(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "changes", Acks: "some"})
+	assert.Error(t, err)
+
+	k, err = NewKafka(KafkaConfig{Brokers: []string{"localhost:9092"}, Topic: "changes", Acks: "all"})
+	require.NoError(t, err)
+	assert.Equal(t, "kafka", k.Name())
+	assert.NoError(t, k.Close())
+}
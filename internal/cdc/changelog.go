@@ -0,0 +1,531 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+	"codesignal/internal/store"
+)
+
+// Files of the change log directory.
+const (
+	logFile   = "changes.log"
+	stateFile = "state.json"
+)
+
+// ChangeLogConfig configures the durable change log consumers read at
+// /v1/changes.
+type ChangeLogConfig struct {
+	// Dir is the directory the changes and the cursors of the consumers are
+	// persisted in, created if needed. Empty disables the change log.
+	Dir string `envconfig:"DIR"`
+	// Retention is how long changes are kept. A consumer resuming after
+	// the changes it didn't read were removed is told it lost them.
+	Retention time.Duration `envconfig:"RETENTION" default:"24h"`
+	// CompactAfter is the age past which the changes of a key are
+	// compacted to its last change, zero disables compaction.
+	CompactAfter time.Duration `envconfig:"COMPACT_AFTER" default:"1h"`
+	// CompactInterval is how often retention and compaction are applied.
+	CompactInterval time.Duration `envconfig:"COMPACT_INTERVAL" default:"1m"`
+	// Buffer is the number of changes waiting to be logged, past which the
+	// log falls behind the bus and loses changes.
+	Buffer int `envconfig:"BUFFER" default:"65536"`
+}
+
+var (
+	// ErrExpired is returned when reading changes removed by retention.
+	ErrExpired = errors.New("changes expired")
+	// ErrCursorNotFound is returned for consumers without cursor.
+	ErrCursorNotFound = errors.New("cursor not found")
+	// ErrInvalidCursor is returned when committing a cursor past the last
+	// change.
+	ErrInvalidCursor = errors.New("cursor is past the last change")
+)
+
+// Record is a change of the change log, numbered by the log rather than
+// the bus so its sequence survives restarts, with the value of created
+// and updated keys.
+type Record struct {
+	Change
+	Value *string `json:"value,omitempty"`
+}
+
+// Cursor is the sequence of the last change a consumer processed.
+type Cursor struct {
+	Consumer string    `json:"consumer"`
+	Seq      uint64    `json:"seq"`
+	Updated  time.Time `json:"updated"`
+}
+
+// logState is the persisted state of the change log besides its changes.
+type logState struct {
+	// Expired is the sequence of the last change removed by retention.
+	Expired uint64            `json:"expired"`
+	Cursors map[string]Cursor `json:"cursors"`
+}
+
+// ChangeLog logs the changes of the bus to disk, a server.Service, so
+// consumers read them at their own pace and resume from their cursor after
+// disconnecting or restarts. Changes older than the retention are
+// removed, and those older than the compaction age superseded by a later
+// change of their key.
+type ChangeLog struct {
+	log zerolog.Logger
+	cfg ChangeLogConfig
+	bus *events.Bus
+	now func() time.Time
+
+	mu      sync.RWMutex
+	records []Record
+	next    uint64
+	state   logState
+	file    *os.File
+	// logged is closed and replaced when changes are logged.
+	logged chan struct{}
+
+	done    chan struct{}
+	stop    sync.Once
+	stopped chan struct{}
+}
+
+// OpenChangeLog opens the change log of cfg.Dir logging the changes of
+// bus, nil without directory.
+func OpenChangeLog(log zerolog.Logger, cfg ChangeLogConfig, bus *events.Bus) (*ChangeLog, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if cfg.CompactInterval <= 0 {
+		return nil, errors.New("change log compact interval must be positive")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	l := &ChangeLog{
+		log:     log.With().Str("component", "changelog").Logger(),
+		cfg:     cfg,
+		bus:     bus,
+		now:     time.Now,
+		state:   logState{Cursors: make(map[string]Cursor)},
+		logged:  make(chan struct{}),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := l.loadState(); err != nil {
+		return nil, fmt.Errorf("load change log state: %w", err)
+	}
+	if err := l.load(); err != nil {
+		return nil, fmt.Errorf("load change log: %w", err)
+	}
+	return l, nil
+}
+
+// Name implements server.Service.
+func (l *ChangeLog) Name() string {
+	return "changelog"
+}
+
+// Serve implements server.Service, logging changes and applying retention
+// and compaction until shut down.
+func (l *ChangeLog) Serve() error {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(l.cfg.CompactInterval)
+	defer ticker.Stop()
+	for {
+		sub := l.bus.SubscribeBuffer("", l.cfg.Buffer)
+		l.consume(sub, ticker.C)
+		sub.Close()
+
+		select {
+		case <-l.done:
+			return nil
+		default:
+		}
+		l.log.Error().Err(sub.Err()).Msg("change log fell behind the bus and lost changes, resubscribing")
+	}
+}
+
+// Shutdown implements server.Service, closing the log once the changes
+// received are written.
+func (l *ChangeLog) Shutdown(ctx context.Context) error {
+	l.stop.Do(func() { close(l.done) })
+	select {
+	case <-l.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// consume logs the events of sub, batching those already received, until
+// it is dropped or the log shut down.
+func (l *ChangeLog) consume(sub *events.Subscription, compact <-chan time.Time) {
+	for {
+		var batch []events.Event
+		select {
+		case <-l.done:
+			return
+		case <-compact:
+			if err := l.Compact(); err != nil {
+				l.log.Error().Err(err).Msg("failed to compact change log")
+			}
+			continue
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			batch = append(batch, e)
+		}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case e, ok := <-sub.Events():
+				if !ok {
+					break drain
+				}
+				batch = append(batch, e)
+			default:
+				break drain
+			}
+		}
+		l.append(batch)
+	}
+}
+
+// append logs the changes of batch.
+func (l *ChangeLog) append(batch []events.Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buf bytes.Buffer
+	n := 0
+	for _, e := range batch {
+		if store.ReservedKey(e.Key) {
+			continue
+		}
+		r := Record{Change: NewChange(e)}
+		r.Seq = l.next
+		l.next++
+		if e.Type == events.TypeSet {
+			value := string(e.Value)
+			r.Value = &value
+		}
+		l.records = append(l.records, r)
+		if err := appendRecord(&buf, r); err != nil {
+			l.log.Error().Err(err).Uint64("seq", r.Seq).Msg("failed to encode change")
+		}
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	metrics.ChangesLogged.Add(int64(n))
+	close(l.logged)
+	l.logged = make(chan struct{})
+	if _, err := l.file.Write(buf.Bytes()); err != nil {
+		l.log.Error().Err(err).Int("changes", n).Msg("failed to write changes to the change log")
+	}
+}
+
+// Read returns up to limit changes following the change of sequence
+// after, an error wrapping ErrExpired when changes following it were
+// removed by retention.
+func (l *ChangeLog) Read(after uint64, limit int) ([]Record, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if after < l.state.Expired {
+		return nil, fmt.Errorf("%w: the changes up to %d were removed", ErrExpired, l.state.Expired)
+	}
+	i := sort.Search(len(l.records), func(i int) bool { return l.records[i].Seq > after })
+	end := min(i+limit, len(l.records))
+	return slices.Clone(l.records[i:end]), nil
+}
+
+// Logged returns a channel closed once changes are logged after the call,
+// for consumers tailing the log.
+func (l *ChangeLog) Logged() <-chan struct{} {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.logged
+}
+
+// Last returns the sequence of the last change logged, zero before the
+// first.
+func (l *ChangeLog) Last() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.next - 1
+}
+
+// Expired returns the sequence of the last change removed by retention,
+// from which new consumers start.
+func (l *ChangeLog) Expired() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.state.Expired
+}
+
+// Cursor returns the cursor of consumer, ErrCursorNotFound if it never
+// committed one.
+func (l *ChangeLog) Cursor(consumer string) (Cursor, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	c, ok := l.state.Cursors[consumer]
+	if !ok {
+		return Cursor{}, ErrCursorNotFound
+	}
+	return c, nil
+}
+
+// Cursors returns the cursors of the consumers, sorted by consumer.
+func (l *ChangeLog) Cursors() []Cursor {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	cursors := make([]Cursor, 0, len(l.state.Cursors))
+	for _, c := range l.state.Cursors {
+		cursors = append(cursors, c)
+	}
+	slices.SortFunc(cursors, func(a, b Cursor) int { return strings.Compare(a.Consumer, b.Consumer) })
+	return cursors
+}
+
+// Commit persists seq as the cursor of consumer, the sequence of the last
+// change it processed.
+func (l *ChangeLog) Commit(consumer string, seq uint64) (Cursor, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if seq >= l.next {
+		return Cursor{}, fmt.Errorf("%w: the last change is %d", ErrInvalidCursor, l.next-1)
+	}
+	c := Cursor{Consumer: consumer, Seq: seq, Updated: l.now()}
+	l.state.Cursors[consumer] = c
+	return c, l.saveState()
+}
+
+// DeleteCursor removes the cursor of consumer.
+func (l *ChangeLog) DeleteCursor(consumer string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.state.Cursors[consumer]; !ok {
+		return ErrCursorNotFound
+	}
+	delete(l.state.Cursors, consumer)
+	return l.saveState()
+}
+
+// Compact removes the changes older than the retention, and those older
+// than the compaction age followed by another change of their key, then
+// rewrites the log.
+func (l *ChangeLog) Compact() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	records, expired := l.records, l.state.Expired
+	if l.cfg.Retention > 0 {
+		cutoff := now.Add(-l.cfg.Retention)
+		i := 0
+		for i < len(records) && records[i].Time.Before(cutoff) {
+			i++
+		}
+		if i > 0 {
+			expired = records[i-1].Seq
+			records = records[i:]
+		}
+	}
+	if l.cfg.CompactAfter > 0 {
+		cutoff := now.Add(-l.cfg.CompactAfter)
+		last := make(map[string]int, len(records))
+		for i, r := range records {
+			last[r.Key] = i
+		}
+		compacted := make([]Record, 0, len(records))
+		for i, r := range records {
+			if r.Time.Before(cutoff) && last[r.Key] != i {
+				continue
+			}
+			compacted = append(compacted, r)
+		}
+		records = compacted
+	}
+	if len(records) == len(l.records) {
+		return nil
+	}
+
+	if err := l.rewrite(records); err != nil {
+		return err
+	}
+	l.log.Debug().Int("removed", len(l.records)-len(records)).Uint64("expired", expired).Msg("change log compacted")
+	l.records = slices.Clip(records)
+	if expired != l.state.Expired {
+		l.state.Expired = expired
+		return l.saveState()
+	}
+	return nil
+}
+
+// load reads the logged changes and opens the log for appending. A change
+// cut off by a crash while it was written is discarded.
+func (l *ChangeLog) load() error {
+	path := filepath.Join(l.cfg.Dir, logFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				l.log.Warn().Int64("offset", size).Msg("discarding the incomplete last change of the change log")
+			}
+			break
+		}
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("change at offset %d: %w", size, err)
+		}
+		l.records = append(l.records, rec)
+		size += int64(len(line))
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	l.file = f
+	l.next = l.state.Expired + 1
+	if n := len(l.records); n > 0 {
+		l.next = max(l.next, l.records[n-1].Seq+1)
+	}
+	return nil
+}
+
+// rewrite replaces the log file with records, written to a temporary file
+// renamed once synced.
+func (l *ChangeLog) rewrite(records []Record) error {
+	tmp, err := os.CreateTemp(l.cfg.Dir, logFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	var buf bytes.Buffer
+	for _, r := range records {
+		buf.Reset()
+		if err = appendRecord(&buf, r); err != nil {
+			break
+		}
+		if _, err = w.Write(buf.Bytes()); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errors.Join(err, tmp.Close())
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	path := filepath.Join(l.cfg.Dir, logFile)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	_ = l.file.Close()
+	l.file = f
+	return nil
+}
+
+// loadState reads the persisted state, empty when missing.
+func (l *ChangeLog) loadState() error {
+	b, err := os.ReadFile(filepath.Join(l.cfg.Dir, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, &l.state); err != nil {
+		return err
+	}
+	if l.state.Cursors == nil {
+		l.state.Cursors = make(map[string]Cursor)
+	}
+	return nil
+}
+
+// saveState persists the state, written to a temporary file renamed once
+// synced.
+func (l *ChangeLog) saveState() error {
+	b, err := json.Marshal(l.state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(l.cfg.Dir, stateFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(l.cfg.Dir, stateFile))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// appendRecord appends the JSON line of r to buf.
+func appendRecord(buf *bytes.Buffer, r Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	buf.WriteByte('\n')
+	return nil
+}
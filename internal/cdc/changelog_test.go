@@ -0,0 +1,166 @@
+package cdc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+)
+
+func openChangeLog(t *testing.T, cfg ChangeLogConfig) *ChangeLog {
+	t.Helper()
+	if cfg.CompactInterval == 0 {
+		cfg.CompactInterval = time.Hour
+	}
+	l, err := OpenChangeLog(zerolog.Nop(), cfg, events.NewBus(0))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.file.Close() })
+	return l
+}
+
+// keys returns the keys of records.
+func keys(records []Record) []string {
+	var keys []string
+	for _, r := range records {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+func TestOpenChangeLog(t *testing.T) {
+	l, err := OpenChangeLog(zerolog.Nop(), ChangeLogConfig{}, events.NewBus(0))
+	assert.NoError(t, err)
+	assert.Nil(t, l, "disabled without directory")
+}
+
+func TestChangeLog(t *testing.T) {
+	dir := t.TempDir()
+	bus := events.NewBus(0)
+	l, err := OpenChangeLog(zerolog.Nop(), ChangeLogConfig{Dir: dir, CompactInterval: time.Hour}, bus)
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- l.Serve() }()
+
+	// Serve subscribes asynchronously, events are published until the
+	// first one is logged.
+	require.Eventually(t, func() bool {
+		bus.Publish(events.Event{Type: events.TypeSet, Key: "ready", Value: []byte("1"), Created: true})
+		return l.Last() > 0
+	}, 5*time.Second, time.Millisecond)
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "a", Value: []byte("1"), Created: true})
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "\xffacl:a", Value: []byte("owner")})
+	bus.Publish(events.Event{Type: events.TypeDelete, Key: "a"})
+	var records []Record
+	require.Eventually(t, func() bool {
+		all, err := l.Read(0, 1000)
+		require.NoError(t, err)
+		records = records[:0]
+		for _, r := range all {
+			if r.Key != "ready" {
+				records = append(records, r)
+			}
+		}
+		return len(records) == 2
+	}, 5*time.Second, time.Millisecond)
+
+	ready := records[0].Seq - 1
+	assert.Equal(t, Change{Seq: ready + 1, Op: OpCreate, Key: "a", ValueHash: hash("1")}, records[0].Change)
+	require.NotNil(t, records[0].Value)
+	assert.Equal(t, "1", *records[0].Value)
+	assert.Equal(t, Change{Seq: ready + 2, Op: OpDelete, Key: "a"}, records[1].Change, "reserved keys aren't logged")
+	assert.Nil(t, records[1].Value)
+	assert.Equal(t, ready+2, l.Last())
+
+	records, err = l.Read(0, 1)
+	require.NoError(t, err)
+	assert.Len(t, records, 1, "reads are limited")
+
+	_, err = l.Commit("indexer", ready+3)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, err = l.Commit("indexer", ready+1)
+	require.NoError(t, err)
+	require.NoError(t, l.Shutdown(context.Background()))
+	require.NoError(t, <-served)
+
+	// The changes, their sequence and the cursors survive restarts.
+	l = openChangeLog(t, ChangeLogConfig{Dir: dir})
+	assert.Equal(t, ready+2, l.Last())
+	c, err := l.Cursor("indexer")
+	require.NoError(t, err)
+	assert.Equal(t, ready+1, c.Seq)
+	records, err = l.Read(c.Seq, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, keys(records))
+	assert.Equal(t, OpDelete, records[0].Op)
+
+	require.NoError(t, l.DeleteCursor("indexer"))
+	assert.ErrorIs(t, l.DeleteCursor("indexer"), ErrCursorNotFound)
+	assert.Empty(t, l.Cursors())
+}
+
+func TestChangeLogCompact(t *testing.T) {
+	dir := t.TempDir()
+	l := openChangeLog(t, ChangeLogConfig{Dir: dir, Retention: 24 * time.Hour, CompactAfter: time.Hour})
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	at := func(age time.Duration, typ events.Type, key string) events.Event {
+		return events.Event{Type: typ, Key: key, Value: []byte(key), Time: now.Add(-age)}
+	}
+	l.append([]events.Event{
+		at(48*time.Hour, events.TypeSet, "expired"),
+		at(3*time.Hour, events.TypeSet, "a"),
+		at(3*time.Hour, events.TypeSet, "b"),
+		at(2*time.Hour, events.TypeSet, "a"),
+		at(2*time.Hour, events.TypeDelete, "b"),
+		at(time.Minute, events.TypeSet, "b"),
+		at(time.Minute, events.TypeSet, "b"),
+	})
+	require.NoError(t, l.Compact())
+
+	_, err := l.Read(0, 10)
+	assert.ErrorIs(t, err, ErrExpired, "the changes removed by retention are reported")
+	assert.Equal(t, uint64(1), l.Expired())
+	records, err := l.Read(l.Expired(), 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "b"}, keys(records), "old changes are compacted to the last of their key")
+	assert.Equal(t, []uint64{4, 6, 7}, []uint64{records[0].Seq, records[1].Seq, records[2].Seq})
+
+	// Compaction is persisted, and the sequence continues after it.
+	l = openChangeLog(t, ChangeLogConfig{Dir: dir})
+	assert.Equal(t, uint64(1), l.Expired())
+	assert.Equal(t, uint64(7), l.Last())
+	records, err = l.Read(1, 10)
+	require.NoError(t, err)
+	assert.Len(t, records, 3)
+}
+
+func TestChangeLogIncompleteChange(t *testing.T) {
+	dir := t.TempDir()
+	l := openChangeLog(t, ChangeLogConfig{Dir: dir})
+	l.append([]events.Event{{Type: events.TypeSet, Key: "a", Value: []byte("1")}})
+	require.NoError(t, l.file.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_WRONLY|os.O_APPEND, 0o600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"seq":2,"op":"cre`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	l = openChangeLog(t, ChangeLogConfig{Dir: dir})
+	assert.Equal(t, uint64(1), l.Last(), "a change cut off by a crash is discarded")
+	l.append([]events.Event{{Type: events.TypeSet, Key: "b", Value: []byte("2")}})
+	require.NoError(t, l.file.Close())
+
+	l = openChangeLog(t, ChangeLogConfig{Dir: dir})
+	records, err := l.Read(0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, keys(records))
+}
@@ -0,0 +1,183 @@
+package cdc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/store"
+)
+
+// Limits of the changes returned at once.
+const (
+	defaultReadLimit = 100
+	maxReadLimit     = 1000
+	// maxConsumerLength caps the length of a consumer name, in bytes.
+	maxConsumerLength = 256
+)
+
+// ChangesResponse represents the changes returned by the API.
+type ChangesResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       ChangesPage      `json:"data"`
+}
+
+// ChangesPage is a page of the change log.
+type ChangesPage struct {
+	Changes []Record `json:"changes"`
+	// Cursor is the sequence of the last change of the page, to commit once
+	// the changes are processed and to read the next page after.
+	Cursor uint64 `json:"cursor"`
+	// Last is the sequence of the last change logged.
+	Last uint64 `json:"last"`
+}
+
+// CommitRequest represents the payload committing a cursor.
+type CommitRequest struct {
+	Seq uint64 `json:"seq"`
+}
+
+// CursorResponse represents a cursor returned by the API.
+type CursorResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       Cursor           `json:"data"`
+}
+
+// CursorsResponse represents the cursors returned by the API.
+type CursorsResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       []Cursor         `json:"data"`
+}
+
+// Handler serves the change log endpoints.
+type Handler struct {
+	log     zerolog.Logger
+	changes *ChangeLog
+}
+
+// NewHandler returns the handler serving the changes of changes.
+func NewHandler(log zerolog.Logger, changes *ChangeLog) *Handler {
+	return &Handler{log: log, changes: changes}
+}
+
+// Changes returns the changes following the after query parameter or,
+// without it, the cursor of the consumer query parameter. New consumers
+// start from the oldest change retained. Reading doesn't move the cursor,
+// consumers commit it once they processed the changes. The changes of keys
+// the caller can't read are skipped.
+func (h *Handler) Changes(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	query := r.URL.Query()
+
+	limit := defaultReadLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxReadLimit {
+			writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "limit must be between 1 and 1000", StatusCode: store.StatusInvalidValue})
+			return
+		}
+		limit = n
+	}
+
+	var after uint64
+	switch consumer := query.Get("consumer"); {
+	case query.Has("after"):
+		n, err := strconv.ParseUint(query.Get("after"), 10, 64)
+		if err != nil {
+			writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "after must be a change sequence", StatusCode: store.StatusInvalidValue})
+			return
+		}
+		after = n
+	case consumer != "":
+		c, err := h.changes.Cursor(consumer)
+		if err != nil {
+			after = h.changes.Expired()
+			break
+		}
+		after = c.Seq
+	default:
+		after = h.changes.Expired()
+	}
+
+	records, err := h.changes.Read(after, limit)
+	if errors.Is(err, ErrExpired) {
+		writeJSON(log, w, http.StatusGone, store.Response{Message: err.Error(), StatusCode: store.StatusChangesExpired})
+		return
+	}
+	page := ChangesPage{Changes: make([]Record, 0, len(records)), Cursor: after, Last: h.changes.Last()}
+	for _, rec := range records {
+		page.Cursor = rec.Seq
+		if auth.Authorize(r.Context(), auth.Read, rec.Key) == nil {
+			page.Changes = append(page.Changes, rec)
+		}
+	}
+	writeJSON(log, w, http.StatusOK, ChangesResponse{Message: "changes found", StatusCode: store.StatusSuccess, Data: page})
+}
+
+// Cursors returns the cursors of the consumers.
+func (h *Handler) Cursors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(zerolog.Ctx(r.Context()), w, http.StatusOK, CursorsResponse{Message: "cursors found", StatusCode: store.StatusSuccess, Data: h.changes.Cursors()})
+}
+
+// Commit commits the cursor of the consumer path parameter.
+func (h *Handler) Commit(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	consumer := httprouter.ParamsFromContext(r.Context()).ByName("consumer")
+	if len(consumer) > maxConsumerLength {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "consumer must be at most 256 characters", StatusCode: store.StatusInvalidValue})
+		return
+	}
+
+	var req CommitRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+
+	c, err := h.changes.Commit(consumer, req.Seq)
+	switch {
+	case errors.Is(err, ErrInvalidCursor):
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("consumer", consumer).Msg("failed to commit cursor")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to commit cursor", StatusCode: store.StatusStorageError})
+		return
+	}
+	writeJSON(log, w, http.StatusOK, CursorResponse{Message: "cursor committed", StatusCode: store.StatusSuccess, Data: c})
+}
+
+// DeleteCursor removes the cursor of the consumer path parameter.
+func (h *Handler) DeleteCursor(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	consumer := httprouter.ParamsFromContext(r.Context()).ByName("consumer")
+	err := h.changes.DeleteCursor(consumer)
+	switch {
+	case errors.Is(err, ErrCursorNotFound):
+		writeJSON(log, w, http.StatusNotFound, store.Response{Message: err.Error(), StatusCode: store.StatusCursorNotFound})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("consumer", consumer).Msg("failed to delete cursor")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to delete cursor", StatusCode: store.StatusStorageError})
+		return
+	}
+	writeJSON(log, w, http.StatusOK, store.Response{Message: "cursor deleted", StatusCode: store.StatusSuccess})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,74 @@
+package cdc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	l := openChangeLog(t, ChangeLogConfig{Dir: t.TempDir()})
+	l.append([]events.Event{
+		{Type: events.TypeSet, Key: "a", Value: []byte("1"), Created: true},
+		{Type: events.TypeSet, Key: "b", Value: []byte("2"), Created: true},
+		{Type: events.TypeDelete, Key: "a"},
+	})
+
+	h := NewHandler(zerolog.Nop(), l)
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/changes", h.Changes)
+	router.HandlerFunc(http.MethodGet, "/changes/cursors", h.Cursors)
+	router.HandlerFunc(http.MethodPut, "/changes/cursors/:consumer", h.Commit)
+	router.HandlerFunc(http.MethodDelete, "/changes/cursors/:consumer", h.DeleteCursor)
+
+	do := func(method, path, body string, resp any) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+		return rec.Code
+	}
+
+	var changes ChangesResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/changes?consumer=indexer&limit=2", "", &changes))
+	assert.Equal(t, []string{"a", "b"}, keys(changes.Data.Changes), "new consumers start from the oldest change")
+	assert.Equal(t, uint64(2), changes.Data.Cursor)
+	assert.Equal(t, uint64(3), changes.Data.Last)
+
+	var cursor CursorResponse
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/changes/cursors/indexer", `{"seq":2}`, &cursor))
+	assert.Equal(t, uint64(2), cursor.Data.Seq)
+	var resp store.Response
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/changes/cursors/indexer", `{"seq":4}`, &resp))
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/changes?consumer=indexer", "", &changes))
+	require.Len(t, changes.Data.Changes, 1, "consumers resume after their cursor")
+	assert.Equal(t, OpDelete, changes.Data.Changes[0].Op)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/changes?after=3", "", &changes))
+	assert.Empty(t, changes.Data.Changes)
+	assert.Equal(t, uint64(3), changes.Data.Cursor)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodGet, "/changes?limit=0", "", &resp))
+
+	var cursors CursorsResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/changes/cursors", "", &cursors))
+	require.Len(t, cursors.Data, 1)
+	assert.Equal(t, "indexer", cursors.Data[0].Consumer)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/changes/cursors/indexer", "", &resp))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/changes/cursors/indexer", "", &resp))
+	assert.Equal(t, store.StatusCursorNotFound, resp.StatusCode)
+
+	l.state.Expired = 1
+	assert.Equal(t, http.StatusGone, do(http.MethodGet, "/changes?after=0", "", &resp))
+	assert.Equal(t, store.StatusChangesExpired, resp.StatusCode)
+}
@@ -0,0 +1,102 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaConfig configures the publishing of changes to a Kafka topic.
+type KafkaConfig struct {
+	// Brokers are the host:port addresses of the Kafka brokers, empty
+	// disables publishing.
+	Brokers []string `envconfig:"BROKERS"`
+	// Topic is the topic changes are published to. It must exist.
+	Topic string `envconfig:"TOPIC" default:"kv-changes"`
+	// Acks is the number of acknowledgements of a write, none, one or all
+	// of the in-sync replicas.
+	Acks string `envconfig:"ACKS" default:"all"`
+	// BatchTimeout bounds the time changes wait for a batch to fill up.
+	BatchTimeout time.Duration `envconfig:"BATCH_TIMEOUT" default:"10ms"`
+	// WriteTimeout bounds the writes to the brokers.
+	WriteTimeout time.Duration `envconfig:"WRITE_TIMEOUT" default:"10s"`
+}
+
+// Kafka publishes changes to a Kafka topic, a Sink. Changes are keyed by
+// the key they change, so the changes of a key are kept in order in a
+// partition.
+type Kafka struct {
+	topic  string
+	writer *kafka.Writer
+}
+
+// NewKafka returns the Kafka sink configured by cfg, nil without brokers.
+func NewKafka(cfg KafkaConfig) (*Kafka, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, nil
+	}
+	if cfg.Topic == "" {
+		return nil, errors.New("kafka topic is required")
+	}
+	var acks kafka.RequiredAcks
+	if err := acks.UnmarshalText([]byte(cfg.Acks)); err != nil {
+		return nil, err
+	}
+	return &Kafka{
+		topic: cfg.Topic,
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(cfg.Brokers...),
+			Topic:        cfg.Topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: acks,
+			BatchSize:    maxBatch,
+			BatchTimeout: cfg.BatchTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		},
+	}, nil
+}
+
+// Name implements Sink.
+func (k *Kafka) Name() string {
+	return "kafka"
+}
+
+// Publish implements Sink.
+func (k *Kafka) Publish(ctx context.Context, changes []Change) error {
+	msgs, err := kafkaMessages(changes)
+	if err != nil {
+		return err
+	}
+	if err := k.writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("write to kafka topic %s: %w", k.topic, err)
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
+
+// kafkaMessages returns the messages of changes, keyed by key, with the
+// operation in the op header.
+func kafkaMessages(changes []Change) ([]kafka.Message, error) {
+	msgs := make([]kafka.Message, 0, len(changes))
+	for _, c := range changes {
+		value, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, kafka.Message{
+			Key:     []byte(c.Key),
+			Value:   value,
+			Headers: []kafka.Header{{Key: "op", Value: []byte(c.Op)}},
+			Time:    c.Time,
+		})
+	}
+	return msgs, nil
+}
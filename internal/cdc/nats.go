@@ -0,0 +1,133 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSConfig configures the publishing of changes to NATS.
+type NATSConfig struct {
+	// URL is the comma-separated URLs of the NATS servers, empty disables
+	// publishing.
+	URL string `envconfig:"URL"`
+	// Subject prefixes the subjects changes are published to, followed by
+	// the namespace of their key.
+	Subject string `envconfig:"SUBJECT" default:"kv.changes"`
+	// NamespaceSeparator ends the namespace of a key, such as orders in
+	// orders/42.
+	NamespaceSeparator string `envconfig:"NAMESPACE_SEPARATOR" default:"/"`
+	// Token or CredsFile authenticate the connection.
+	Token     string `envconfig:"TOKEN"`
+	CredsFile string `envconfig:"CREDS_FILE"`
+	// FlushTimeout bounds the wait for the server to receive the changes
+	// published.
+	FlushTimeout time.Duration `envconfig:"FLUSH_TIMEOUT" default:"10s"`
+}
+
+// NATS publishes changes to NATS, a Sink. Each change is published to the
+// subject of the namespace of its key, so consumers subscribe to the
+// namespaces they care about, or to every change with a > wildcard.
+type NATS struct {
+	cfg  NATSConfig
+	conn *nats.Conn
+}
+
+// NewNATS returns the NATS sink configured by cfg, nil without URL. The
+// connection is retried in the background when the servers are
+// unreachable.
+func NewNATS(cfg NATSConfig) (*NATS, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	if !ValidSubject(cfg.Subject) {
+		return nil, fmt.Errorf("invalid nats subject %q", cfg.Subject)
+	}
+	if cfg.NamespaceSeparator == "" {
+		return nil, errors.New("nats namespace separator is required")
+	}
+
+	opts := []nats.Option{
+		nats.Name("key-value-store"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	if cfg.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+	conn, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("connect to nats: %w", err)
+	}
+	return &NATS{cfg: cfg, conn: conn}, nil
+}
+
+// Name implements Sink.
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+// Publish implements Sink, returning once the server received the changes.
+func (n *NATS) Publish(ctx context.Context, changes []Change) error {
+	for _, c := range changes {
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		msg := nats.NewMsg(n.subject(c.Key))
+		msg.Data = data
+		msg.Header.Set("op", c.Op)
+		if err := n.conn.PublishMsg(msg); err != nil {
+			return fmt.Errorf("publish to nats: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.cfg.FlushTimeout)
+	defer cancel()
+	if err := n.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("flush to nats: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (n *NATS) Close() error {
+	n.conn.Close()
+	return nil
+}
+
+// subject returns the subject of the changes of key: the configured
+// subject followed by the namespace of key, or _ for keys without one.
+// Characters not allowed in a subject token are replaced by _.
+func (n *NATS) subject(key string) string {
+	namespace, _, found := strings.Cut(key, n.cfg.NamespaceSeparator)
+	if !found || namespace == "" {
+		namespace = "_"
+	}
+	namespace = strings.Map(func(r rune) rune {
+		if r == '.' || r == '*' || r == '>' || r <= ' ' || r == 0x7f {
+			return '_'
+		}
+		return r
+	}, namespace)
+	return n.cfg.Subject + "." + namespace
+}
+
+// ValidSubject reports whether subject is a NATS subject changes can be
+// published to: dot separated tokens, without wildcards or whitespace.
+func ValidSubject(subject string) bool {
+	for _, token := range strings.Split(subject, ".") {
+		if token == "" || token == "*" || token == ">" || strings.ContainsAny(token, " \t\r\n") {
+			return false
+		}
+	}
+	return true
+}
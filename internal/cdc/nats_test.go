@@ -0,0 +1,52 @@
+package cdc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNATSSubject(t *testing.T) {
+	n := &NATS{cfg: NATSConfig{Subject: "kv.changes", NamespaceSeparator: "/"}}
+	tests := map[string]string{
+		"orders/42":  "kv.changes.orders",
+		"orders/a/b": "kv.changes.orders",
+		"plain":      "kv.changes._",
+		"/leading":   "kv.changes._",
+		"team.a/k":   "kv.changes.team_a",
+		"a b*>/k":    "kv.changes.a_b__",
+		"café/k":     "kv.changes.café",
+	}
+	for key, want := range tests {
+		assert.Equal(t, want, n.subject(key), key)
+	}
+}
+
+func TestValidSubject(t *testing.T) {
+	for _, subject := range []string{"kv", "kv.changes", "a-b.c_d"} {
+		assert.True(t, ValidSubject(subject), subject)
+	}
+	for _, subject := range []string{"", ".", "kv.", ".kv", "kv..changes", "kv.*", "kv.>", "kv changes"} {
+		assert.False(t, ValidSubject(subject), subject)
+	}
+}
+
+func TestNewNATS(t *testing.T) {
+	n, err := NewNATS(NATSConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, n, "disabled without url")
+
+	_, err = NewNATS(NATSConfig{URL: "nats://127.0.0.1:1", Subject: "kv.>", NamespaceSeparator: "/"})
+	assert.Error(t, err)
+
+	// The connection is retried in the background, publishing fails until
+	// it is established.
+	n, err = NewNATS(NATSConfig{URL: "nats://127.0.0.1:1", Subject: "kv.changes", NamespaceSeparator: "/", FlushTimeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+	assert.Equal(t, "nats", n.Name())
+	assert.Error(t, n.Publish(context.Background(), []Change{{Seq: 1, Op: OpDelete, Key: "orders/1"}}))
+	assert.NoError(t, n.Close())
+}
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"codesignal/internal/repository"
+)
+
+// opType identifies the operation carried by a replicated command.
+type opType uint8
+
+const (
+	opSet opType = iota + 1
+	opDelete
+	opUndelete
+	opIncrement
+	opSetNode
+	opRemoveNode
+	opAddMembers
+	opRemoveMembers
+	opSetFields
+	opDeleteFields
+	opAddCounter
+	opResetCounter
+	opSetPath
+	opAddScores
+)
+
+// command is a state machine operation replicated through the Raft log.
+// Expiry is absolute, and the command applied at the time the leader
+// proposed it, so every replica, and every replay of the log, agrees on
+// when a key expires.
+type command struct {
+	Op opType `json:"op"`
+	// Time is the time in unix nanoseconds the command was proposed at.
+	Time      int64                     `json:"time,omitempty"`
+	Key       string                    `json:"key,omitempty"`
+	Value     []byte                    `json:"value,omitempty"`
+	ExpiresAt int64                     `json:"expires_at,omitempty"`
+	Delta     int64                     `json:"delta,omitempty"`
+	Overflow  repository.Overflow       `json:"overflow,omitempty"`
+	Members   []string                  `json:"members,omitempty"`
+	Path      string                    `json:"path,omitempty"`
+	Fields    map[string]string         `json:"fields,omitempty"`
+	Scores    []repository.ScoredMember `json:"scores,omitempty"`
+	MaxSize   int                       `json:"max_size,omitempty"`
+	// Version is the version a conditional write expects the key at.
+	Version uint64    `json:"version,omitempty"`
+	Node    *NodeInfo `json:"node,omitempty"`
+}
+
+// applyResult is the value returned by fsm.Apply for a command.
+type applyResult struct {
+	value    int64
+	version  uint64
+	restored bool
+	members  []string
+	err      error
+}
+
+// NodeInfo describes a cluster member.
+type NodeInfo struct {
+	ID          string `json:"id"`
+	RaftAddress string `json:"raft_address"`
+	HTTPAddress string `json:"http_address"`
+}
+
+// fsm applies committed commands to the local repository.
+type fsm struct {
+	store *repository.KeyValueStore
+	// time is the time in unix nanoseconds the last command was applied
+	// at. Raft calls Apply, Snapshot and Restore one at a time.
+	time int64
+
+	mu    sync.RWMutex
+	nodes map[string]NodeInfo
+}
+
+func newFSM(store *repository.KeyValueStore) *fsm {
+	return &fsm{
+		store: store,
+		nodes: make(map[string]NodeInfo),
+	}
+}
+
+// Apply implements raft.FSM.
+func (f *fsm) Apply(l *raft.Log) any {
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return applyResult{err: fmt.Errorf("decode command: %w", err)}
+	}
+
+	if cmd.Time == 0 {
+		// Commands logged before they carried their time.
+		cmd.Time = time.Now().UnixNano()
+	}
+	// Commands are applied at their time, never before the previous one
+	// should leaders' clocks disagree, and the store removes no expired
+	// key ahead of it.
+	f.time = max(f.time, cmd.Time)
+	now := time.Unix(0, f.time)
+	f.store.SetHorizon(now)
+	ctx := repository.WithTime(context.Background(), now)
+	switch cmd.Op {
+	case opSet:
+		var ttl time.Duration
+		if cmd.ExpiresAt != 0 {
+			ttl = time.Duration(cmd.ExpiresAt - f.time)
+			if ttl <= 0 {
+				// Already expired when replayed, it must not resurrect.
+				return applyResult{err: f.store.Delete(ctx, cmd.Key)}
+			}
+		}
+		version, err := f.store.SetIfVersion(ctx, cmd.Key, cmd.Value, ttl, cmd.Version)
+		return applyResult{version: version, err: err}
+	case opDelete:
+		return applyResult{err: f.store.DeleteIfVersion(ctx, cmd.Key, cmd.Version)}
+	case opUndelete:
+		restored, err := f.store.Undelete(ctx, cmd.Key)
+		return applyResult{restored: restored, err: err}
+	case opIncrement:
+		value, err := f.store.Increment(ctx, cmd.Key, cmd.Delta)
+		return applyResult{value: value, err: err}
+	case opAddMembers:
+		added, err := f.store.AddMembers(ctx, cmd.Key, cmd.Members, cmd.MaxSize)
+		return applyResult{members: added, err: err}
+	case opRemoveMembers:
+		removed, err := f.store.RemoveMembers(ctx, cmd.Key, cmd.Members)
+		return applyResult{members: removed, err: err}
+	case opSetFields:
+		created, err := f.store.SetFields(ctx, cmd.Key, cmd.Fields, cmd.MaxSize)
+		return applyResult{value: int64(created), err: err}
+	case opDeleteFields:
+		// The names of the deleted fields are carried by Members.
+		deleted, err := f.store.DeleteFields(ctx, cmd.Key, cmd.Members)
+		return applyResult{value: int64(deleted), err: err}
+	case opAddCounter:
+		value, err := f.store.AddCounter(ctx, cmd.Key, cmd.Delta, cmd.Overflow)
+		return applyResult{value: value, err: err}
+	case opResetCounter:
+		// The count the counter is reset to is carried by Delta.
+		previous, err := f.store.ResetCounter(ctx, cmd.Key, cmd.Delta)
+		return applyResult{value: previous, err: err}
+	case opSetPath:
+		err := f.store.SetPath(ctx, cmd.Key, cmd.Path, cmd.Value, cmd.MaxSize)
+		return applyResult{err: err}
+	case opAddScores:
+		added, err := f.store.AddScores(ctx, cmd.Key, cmd.Scores, cmd.MaxSize)
+		return applyResult{members: added, err: err}
+	case opSetNode:
+		f.mu.Lock()
+		f.nodes[cmd.Node.ID] = *cmd.Node
+		f.mu.Unlock()
+		return applyResult{}
+	case opRemoveNode:
+		f.mu.Lock()
+		delete(f.nodes, cmd.Node.ID)
+		f.mu.Unlock()
+		return applyResult{}
+	default:
+		return applyResult{err: fmt.Errorf("unknown command op %d", cmd.Op)}
+	}
+}
+
+// node returns the metadata of the node with the given id.
+func (f *fsm) node(id string) (NodeInfo, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	info, ok := f.nodes[id]
+	return info, ok
+}
+
+// fsmState is the serialized form of a snapshot.
+type fsmState struct {
+	KV    []byte
+	Nodes map[string]NodeInfo
+	// Time is the time in unix nanoseconds the last command was applied
+	// at, zero in the snapshots taken before commands carried their time.
+	Time int64
+}
+
+// Snapshot implements raft.FSM. Raft never calls it concurrently with
+// Apply, so the copy is consistent with the log index it is taken at.
+func (f *fsm) Snapshot() (raft.FSMSnapshot, error) {
+	var buf bytes.Buffer
+	if err := f.store.Snapshot(context.Background(), &buf); err != nil {
+		return nil, err
+	}
+
+	f.mu.RLock()
+	nodes := make(map[string]NodeInfo, len(f.nodes))
+	for id, info := range f.nodes {
+		nodes[id] = info
+	}
+	f.mu.RUnlock()
+
+	return &fsmSnapshot{state: fsmState{KV: buf.Bytes(), Nodes: nodes, Time: f.time}}, nil
+}
+
+// Restore implements raft.FSM.
+func (f *fsm) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	var state fsmState
+	if err := gob.NewDecoder(rc).Decode(&state); err != nil {
+		return fmt.Errorf("decode fsm snapshot: %w", err)
+	}
+
+	// The entries expired since are kept until the commands following the
+	// snapshot are applied past their expiry.
+	f.time = state.Time
+	var horizon time.Time
+	if f.time != 0 {
+		horizon = time.Unix(0, f.time)
+	}
+	f.store.SetHorizon(horizon)
+	if err := f.store.Restore(context.Background(), bytes.NewReader(state.KV)); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.nodes = state.Nodes
+	if f.nodes == nil {
+		f.nodes = make(map[string]NodeInfo)
+	}
+	f.mu.Unlock()
+	return nil
+}
+
+type fsmSnapshot struct {
+	state fsmState
+}
+
+// Persist implements raft.FSMSnapshot.
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	if err := gob.NewEncoder(sink).Encode(s.state); err != nil {
+		_ = sink.Cancel()
+		return fmt.Errorf("encode fsm snapshot: %w", err)
+	}
+	return sink.Close()
+}
+
+// Release implements raft.FSMSnapshot.
+func (s *fsmSnapshot) Release() {}
@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func TestFSMAppliesCommandsAtTheirTime(t *testing.T) {
+	ctx := context.Background()
+	proposed := time.Now().Add(-time.Hour)
+	log := []command{
+		{Op: opSet, Key: "k", Value: []byte("5"), Time: proposed.UnixNano(), ExpiresAt: proposed.Add(time.Second).UnixNano()},
+		{Op: opIncrement, Key: "k", Delta: 1, Time: proposed.Add(time.Millisecond).UnixNano()},
+	}
+
+	// Replaying the log an hour later, with the reaper running between the
+	// commands, gives the result the leader got.
+	kv, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{ReapInterval: time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = kv.Close() })
+	f := newFSM(kv)
+	for i, cmd := range log {
+		data, err := json.Marshal(cmd)
+		require.NoError(t, err)
+		result := f.Apply(&raft.Log{Index: uint64(i + 1), Data: data}).(applyResult)
+		require.NoError(t, result.err)
+		if cmd.Op == opIncrement {
+			assert.Equal(t, int64(6), result.value, "the key was alive when the increment was proposed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// The incremented key kept its expiry, long past.
+	_, ok, err := kv.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
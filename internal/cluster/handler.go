@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// forwardedHeader marks a request that was already forwarded to the leader,
+// so a stale leader view can't bounce it between nodes.
+const forwardedHeader = "X-KV-Forwarded"
+
+const (
+	// AllowStaleHeader lets a follower answer a read from its local
+	// replica instead of forwarding it to the leader.
+	AllowStaleHeader = "X-Allow-Stale"
+	// ReplicationLagHeader reports, in milliseconds, how long ago the node
+	// serving a read last heard from the leader. It is 0 on the leader.
+	ReplicationLagHeader = "X-Replication-Lag-Ms"
+	// AppliedIndexHeader reports the last Raft log index applied by the
+	// node serving a read.
+	AppliedIndexHeader = "X-Raft-Applied-Index"
+)
+
+// JoinRequest is the payload of the join admin endpoint.
+type JoinRequest struct {
+	ID          string `json:"id"`
+	RaftAddress string `json:"raft_address"`
+	HTTPAddress string `json:"http_address"`
+}
+
+// LeaveRequest is the payload of the leave admin endpoint.
+type LeaveRequest struct {
+	ID string `json:"id"`
+}
+
+// Handler serves the cluster admin endpoints.
+type Handler struct {
+	node *Node
+	log  zerolog.Logger
+}
+
+// NewHandler returns the admin handler for node.
+func NewHandler(log zerolog.Logger, node *Node) *Handler {
+	return &Handler{node: node, log: log}
+}
+
+// Join adds the node described in the request body to the cluster.
+func (h *Handler) Join(w http.ResponseWriter, r *http.Request) {
+	var req JoinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(h.log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+	if req.ID == "" || req.RaftAddress == "" {
+		writeJSON(h.log, w, http.StatusBadRequest, store.Response{Message: "id and raft_address are required", StatusCode: store.StatusInvalidValue})
+		return
+	}
+
+	info := NodeInfo(req)
+	if err := h.node.Join(r.Context(), info); err != nil {
+		h.writeError(w, r, err, "failed to join node")
+		return
+	}
+
+	store.RequestLogger(r, &h.log).Info().Str("node_id", req.ID).Str("raft_address", req.RaftAddress).Msg("node joined cluster")
+	writeJSON(h.log, w, http.StatusOK, store.Response{Message: "node joined successfully", StatusCode: store.StatusSuccess})
+}
+
+// Leave removes the node named in the request body from the cluster.
+func (h *Handler) Leave(w http.ResponseWriter, r *http.Request) {
+	var req LeaveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(h.log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+	if req.ID == "" {
+		writeJSON(h.log, w, http.StatusBadRequest, store.Response{Message: "id is required", StatusCode: store.StatusInvalidValue})
+		return
+	}
+
+	if err := h.node.Leave(r.Context(), req.ID); err != nil {
+		h.writeError(w, r, err, "failed to remove node")
+		return
+	}
+
+	store.RequestLogger(r, &h.log).Info().Str("node_id", req.ID).Msg("node left cluster")
+	writeJSON(h.log, w, http.StatusOK, store.Response{Message: "node removed successfully", StatusCode: store.StatusSuccess})
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	if errors.Is(err, ErrNotLeader) || errors.Is(err, ErrNoLeader) {
+		writeJSON(h.log, w, http.StatusServiceUnavailable, store.Response{Message: err.Error(), StatusCode: store.StatusNoLeader})
+		return
+	}
+	store.RequestLogger(r, &h.log).Error().Err(err).Msg(msg)
+	writeJSON(h.log, w, http.StatusInternalServerError, store.Response{Message: msg, StatusCode: store.StatusStorageError})
+}
+
+// ForwardToLeader proxies requests received by a follower to the leader, so
+// clients can send any request to any node and get a consistent answer.
+// Key reads carrying the AllowStaleHeader are served from the local replica
+// instead, and every locally served key read reports the replication lag.
+// Pub/sub channels and the change log aren't replicated, they are always
+// served locally.
+func (n *Node) ForwardToLeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keyRead := isReadOnly(r.Method) && isDataPath(r.URL.Path)
+		local := n.IsLeader() || (isReadOnly(r.Method) && !keyRead) || (keyRead && allowStale(r)) ||
+			isLocalPath(r.URL.Path)
+		if local {
+			if keyRead {
+				n.writeReplicationHeaders(w)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leader, err := n.Leader()
+		if err != nil || leader.HTTPAddress == "" || r.Header.Get(forwardedHeader) != "" {
+			writeJSON(n.log, w, http.StatusServiceUnavailable, store.Response{Message: ErrNoLeader.Error(), StatusCode: store.StatusNoLeader})
+			return
+		}
+
+		n.proxyTo(leader).ServeHTTP(w, r)
+	})
+}
+
+// writeReplicationHeaders reports how stale the local replica may be.
+func (n *Node) writeReplicationHeaders(w http.ResponseWriter) {
+	if lag, ok := n.ReplicationLag(); ok {
+		w.Header().Set(ReplicationLagHeader, strconv.FormatInt(lag.Milliseconds(), 10))
+	}
+	w.Header().Set(AppliedIndexHeader, strconv.FormatUint(n.raft.AppliedIndex(), 10))
+}
+
+func allowStale(r *http.Request) bool {
+	stale, _ := strconv.ParseBool(r.Header.Get(AllowStaleHeader))
+	return stale
+}
+
+// proxyTo returns a reverse proxy sending requests to the HTTP API of node.
+// An address advertised with the https:// scheme is reached over TLS.
+func (n *Node) proxyTo(node NodeInfo) http.Handler {
+	target, err := nodeURL(node.HTTPAddress)
+	if err != nil {
+		target = &url.URL{Scheme: "http", Host: node.HTTPAddress}
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Set(forwardedHeader, n.cfg.NodeID)
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		store.RequestLogger(r, &n.log).Error().Err(err).Str("node_id", node.ID).Msg("failed to forward request")
+		writeJSON(n.log, w, http.StatusBadGateway, store.Response{Message: "failed to reach cluster leader", StatusCode: store.StatusNoLeader})
+	}
+	return proxy
+}
+
+func isReadOnly(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func writeJSON(log zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"codesignal/internal/metrics"
+)
+
+// replicaRequest is a request sent to a single replica.
+type replicaRequest struct {
+	method   string
+	path     string
+	rawQuery string
+	header   http.Header
+	body     []byte
+}
+
+func newReplicaRequest(r *http.Request, body []byte) replicaRequest {
+	return replicaRequest{
+		method:   r.Method,
+		path:     r.URL.Path,
+		rawQuery: r.URL.RawQuery,
+		header:   r.Header.Clone(),
+		body:     body,
+	}
+}
+
+// hint is a write buffered for a replica that could not take it.
+type hint struct {
+	req      replicaRequest
+	storedAt time.Time
+}
+
+// hintStore buffers writes per replica, in arrival order, until they can
+// be handed off. It is safe for concurrent use.
+type hintStore struct {
+	max int
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	hints map[string][]hint
+}
+
+func newHintStore(max int, ttl time.Duration) *hintStore {
+	return &hintStore{
+		max:   max,
+		ttl:   ttl,
+		now:   time.Now,
+		hints: make(map[string][]hint),
+	}
+}
+
+// add buffers req for node, dropping the oldest hint when the buffer of
+// node is full.
+func (s *hintStore) add(node string, req replicaRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.hints[node]
+	if s.max > 0 && len(queue) >= s.max {
+		queue = queue[1:]
+		metrics.HintsDropped.Add(1)
+	}
+	s.hints[node] = append(queue, hint{req: req, storedAt: s.now()})
+	metrics.HintsStored.Add(1)
+}
+
+// pending returns the number of hints buffered for node.
+func (s *hintStore) pending(node string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.hints[node])
+}
+
+// nodes returns the nodes with buffered hints.
+func (s *hintStore) nodes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nodes := make([]string, 0, len(s.hints))
+	for node := range s.hints {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// next returns the oldest live hint of node, discarding expired ones.
+func (s *hintStore) next(node string) (hint, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queue := s.hints[node]
+	for len(queue) > 0 && s.ttl > 0 && s.now().Sub(queue[0].storedAt) > s.ttl {
+		queue = queue[1:]
+		metrics.HintsDropped.Add(1)
+	}
+	s.hints[node] = queue
+	if len(queue) == 0 {
+		delete(s.hints, node)
+		return hint{}, false
+	}
+	return queue[0], true
+}
+
+// done removes the oldest hint of node once it was delivered.
+func (s *hintStore) done(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if queue := s.hints[node]; len(queue) > 0 {
+		s.hints[node] = queue[1:]
+		if len(queue) == 1 {
+			delete(s.hints, node)
+		}
+	}
+}
+
+// runHandoff replays buffered hints every interval, or as soon as a
+// replica is reported back by gossip.
+func (p *Proxy) runHandoff(interval time.Duration) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		case <-p.replayNow:
+		}
+		for _, node := range p.hints.nodes() {
+			p.replayHints(node)
+		}
+	}
+}
+
+// replayHints delivers the hints of node in order, stopping at the first
+// failure so the replica never applies writes out of order.
+func (p *Proxy) replayHints(node string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	replayed := 0
+	for {
+		h, ok := p.hints.next(node)
+		if !ok {
+			break
+		}
+		if resp := p.send(ctx, node, h.req); !resp.acked() {
+			p.log.Debug().Err(resp.err).Int("status", resp.status).Str("node", node).Msg("replica still unavailable for handoff")
+			break
+		}
+		p.hints.done(node)
+		metrics.HintsReplayed.Add(1)
+		replayed++
+	}
+
+	if replayed > 0 {
+		p.log.Info().Str("node", node).Int("hints", replayed).Msg("handed off buffered writes")
+	}
+}
+
+// triggerHandoff requests an immediate replay of the buffered hints.
+func (p *Proxy) triggerHandoff() {
+	if p.hints == nil {
+		return
+	}
+	select {
+	case p.replayNow <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func TestHintStore(t *testing.T) {
+	now := time.Unix(1000, 0)
+	hints := newHintStore(2, time.Minute)
+	hints.now = func() time.Time { return now }
+
+	req := func(path string) replicaRequest { return replicaRequest{method: http.MethodPost, path: path} }
+
+	hints.add("a", req("/1"))
+	now = now.Add(30 * time.Second)
+	hints.add("a", req("/2"))
+	hints.add("a", req("/3"))
+	assert.Equal(t, 2, hints.pending("a"), "oldest hint dropped when full")
+	assert.Equal(t, []string{"a"}, hints.nodes())
+
+	h, ok := hints.next("a")
+	require.True(t, ok)
+	assert.Equal(t, "/2", h.req.path)
+	hints.done("a")
+
+	now = now.Add(2 * time.Minute)
+	_, ok = hints.next("a")
+	assert.False(t, ok, "expired hints are discarded")
+	assert.Empty(t, hints.nodes())
+}
+
+func TestProxyHintedHandoff(t *testing.T) {
+	ctx := context.Background()
+
+	up, _ := newTestReplica(t)
+	upAddr := strings.TrimPrefix(up.URL, "http://")
+
+	// Reserve an address for a replica that is down until later.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	proxy, err := NewProxy(zerolog.Nop(), ShardConfig{
+		Nodes:              []string{upAddr, downAddr},
+		ReplicationFactor:  2,
+		MaxBodySize:        1024,
+		HintedHandoff:      true,
+		HintReplayInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(proxy.Close)
+
+	r := httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(`{"key":"user:1","value":"v"}`))
+	r.Header.Set(WriteQuorumHeader, "all")
+	w := httptest.NewRecorder()
+	proxy.Middleware(http.NotFoundHandler()).ServeHTTP(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "1", w.Header().Get(ReplicasHintedHeader))
+	assert.Equal(t, 1, proxy.hints.pending(downAddr))
+
+	kv, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/key", store.NewService(zerolog.Nop(), kv, store.Opts{}).SetKey)
+
+	l, err = net.Listen("tcp", downAddr)
+	require.NoError(t, err)
+	back := &httptest.Server{Listener: l, Config: &http.Server{Handler: router}}
+	back.Start()
+	t.Cleanup(back.Close)
+
+	assert.Eventually(t, func() bool {
+		value, exists, err := kv.Get(ctx, "user:1")
+		return err == nil && exists && string(value) == "v"
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return proxy.hints.pending(downAddr) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
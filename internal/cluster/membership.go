@@ -0,0 +1,281 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/rs/zerolog"
+)
+
+// Member roles advertised through gossip.
+const (
+	// RoleStorage is a node holding data, it is placed on the shard ring.
+	RoleStorage = "storage"
+	// RoleCoordinator is a sharding proxy, it routes but holds no data.
+	RoleCoordinator = "coordinator"
+)
+
+// gossipLeaveTimeout bounds how long a graceful leave is broadcast.
+const gossipLeaveTimeout = 5 * time.Second
+
+// GossipConfig holds the configuration of gossip based node discovery.
+type GossipConfig struct {
+	// Enabled turns on gossip membership.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// NodeName uniquely names this node, defaults to the hostname.
+	NodeName string `envconfig:"NODE_NAME"`
+	// BindAddress and BindPort are where gossip traffic is received.
+	BindAddress string `envconfig:"BIND_ADDRESS" default:"0.0.0.0"`
+	BindPort    int    `envconfig:"BIND_PORT" default:"7946"`
+	// AdvertiseAddress and AdvertisePort are what peers use to reach
+	// this node, detected from the bind address when empty.
+	AdvertiseAddress string `envconfig:"ADVERTISE_ADDRESS"`
+	AdvertisePort    int    `envconfig:"ADVERTISE_PORT"`
+	// Seeds are gossip addresses of existing members to join through.
+	Seeds []string `envconfig:"SEEDS"`
+	// HTTPAddress is the HTTP API address advertised to peers.
+	HTTPAddress string `envconfig:"HTTP_ADDRESS"`
+}
+
+// Member is a node discovered through gossip.
+type Member struct {
+	Name        string `json:"name"`
+	Role        string `json:"role"`
+	HTTPAddress string `json:"http_address,omitempty"`
+	RaftID      string `json:"raft_id,omitempty"`
+	RaftAddress string `json:"raft_address,omitempty"`
+}
+
+// MembershipListener is notified about membership changes. Dead reports
+// whether the member failed rather than leaving gracefully. Listeners are
+// called from the gossip goroutines and must not block.
+type MembershipListener interface {
+	MemberJoined(m Member)
+	MemberLeft(m Member, dead bool)
+}
+
+// Membership discovers cluster nodes and detects their failures through a
+// gossip protocol, so nodes don't need static peer lists.
+type Membership struct {
+	log  zerolog.Logger
+	self Member
+	list *memberlist.Memberlist
+
+	mu        sync.RWMutex
+	listeners []MembershipListener
+}
+
+// NewMembership starts gossiping as self and joins the configured seeds.
+func NewMembership(log zerolog.Logger, cfg GossipConfig, self Member, listeners ...MembershipListener) (*Membership, error) {
+	conf := memberlist.DefaultLANConfig()
+	conf.BindAddr = cfg.BindAddress
+	conf.BindPort = cfg.BindPort
+	conf.AdvertiseAddr = cfg.AdvertiseAddress
+	conf.AdvertisePort = cfg.AdvertisePort
+	if cfg.AdvertisePort == 0 {
+		conf.AdvertisePort = cfg.BindPort
+	}
+
+	return newMembership(log, conf, cfg, self, listeners...)
+}
+
+func newMembership(log zerolog.Logger, conf *memberlist.Config, cfg GossipConfig, self Member, listeners ...MembershipListener) (*Membership, error) {
+	if cfg.NodeName == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("resolve gossip node name: %w", err)
+		}
+		cfg.NodeName = hostname
+	}
+	self.Name = cfg.NodeName
+	if self.HTTPAddress == "" {
+		self.HTTPAddress = cfg.HTTPAddress
+	}
+
+	m := &Membership{
+		log:       log.With().Str("component", "gossip").Logger(),
+		self:      self,
+		listeners: listeners,
+	}
+
+	conf.Name = cfg.NodeName
+	conf.Delegate = m
+	conf.Events = m
+	conf.LogOutput = m.log
+
+	list, err := memberlist.Create(conf)
+	if err != nil {
+		return nil, fmt.Errorf("start gossip: %w", err)
+	}
+	m.list = list
+
+	if len(cfg.Seeds) > 0 {
+		if _, err := list.Join(cfg.Seeds); err != nil {
+			// The seeds may not be up yet, they will find us when they are.
+			m.log.Warn().Err(err).Strs("seeds", cfg.Seeds).Msg("failed to join gossip seeds")
+		}
+	}
+
+	return m, nil
+}
+
+// AddListener registers a listener and replays the current members to it.
+func (m *Membership) AddListener(l MembershipListener) {
+	m.mu.Lock()
+	m.listeners = append(m.listeners, l)
+	m.mu.Unlock()
+
+	for _, member := range m.Members() {
+		l.MemberJoined(member)
+	}
+}
+
+// Members returns the live members, including this node.
+func (m *Membership) Members() []Member {
+	nodes := m.list.Members()
+	members := make([]Member, 0, len(nodes))
+	for _, node := range nodes {
+		member, err := decodeMember(node)
+		if err != nil {
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+// Close leaves the cluster gracefully and stops gossiping.
+func (m *Membership) Close() error {
+	leaveErr := m.list.Leave(gossipLeaveTimeout)
+	return errors.Join(leaveErr, m.list.Shutdown())
+}
+
+// NodeMeta implements memberlist.Delegate.
+func (m *Membership) NodeMeta(limit int) []byte {
+	meta, err := json.Marshal(m.self)
+	if err != nil || len(meta) > limit {
+		m.log.Error().Err(err).Int("limit", limit).Msg("gossip metadata does not fit")
+		return nil
+	}
+	return meta
+}
+
+// NotifyMsg implements memberlist.Delegate.
+func (m *Membership) NotifyMsg([]byte) {}
+
+// GetBroadcasts implements memberlist.Delegate.
+func (m *Membership) GetBroadcasts(int, int) [][]byte { return nil }
+
+// LocalState implements memberlist.Delegate.
+func (m *Membership) LocalState(bool) []byte { return nil }
+
+// MergeRemoteState implements memberlist.Delegate.
+func (m *Membership) MergeRemoteState([]byte, bool) {}
+
+// NotifyJoin implements memberlist.EventDelegate.
+func (m *Membership) NotifyJoin(node *memberlist.Node) {
+	member, err := decodeMember(node)
+	if err != nil {
+		m.log.Warn().Err(err).Str("node", node.Name).Msg("ignoring member with invalid metadata")
+		return
+	}
+
+	m.log.Info().Str("node", member.Name).Str("role", member.Role).Msg("member joined")
+	for _, l := range m.snapshotListeners() {
+		l.MemberJoined(member)
+	}
+}
+
+// NotifyLeave implements memberlist.EventDelegate.
+func (m *Membership) NotifyLeave(node *memberlist.Node) {
+	member, err := decodeMember(node)
+	if err != nil {
+		return
+	}
+
+	dead := node.State == memberlist.StateDead
+	m.log.Info().Str("node", member.Name).Bool("dead", dead).Msg("member left")
+	for _, l := range m.snapshotListeners() {
+		l.MemberLeft(member, dead)
+	}
+}
+
+// NotifyUpdate implements memberlist.EventDelegate.
+func (m *Membership) NotifyUpdate(node *memberlist.Node) {
+	m.NotifyJoin(node)
+}
+
+func (m *Membership) snapshotListeners() []MembershipListener {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]MembershipListener(nil), m.listeners...)
+}
+
+func decodeMember(node *memberlist.Node) (Member, error) {
+	var member Member
+	if err := json.Unmarshal(node.Meta, &member); err != nil {
+		return Member{}, fmt.Errorf("decode member metadata: %w", err)
+	}
+	member.Name = node.Name
+	return member, nil
+}
+
+// MemberJoined places storage nodes discovered through gossip on the ring
+// and hands off the writes buffered while they were away.
+func (p *Proxy) MemberJoined(m Member) {
+	if m.Role == RoleStorage && m.HTTPAddress != "" {
+		p.ring.Add(m.HTTPAddress)
+		p.triggerHandoff()
+	}
+}
+
+// MemberLeft takes storage nodes that left or failed off the ring. With
+// hinted handoff failed nodes stay on the ring, their writes are buffered
+// until they come back.
+func (p *Proxy) MemberLeft(m Member, dead bool) {
+	if dead && p.hints != nil {
+		return
+	}
+	if m.Role == RoleStorage && m.HTTPAddress != "" {
+		p.ring.Remove(m.HTTPAddress)
+	}
+}
+
+// MemberJoined adds storage nodes discovered through gossip as Raft
+// voters. Only the leader acts, other nodes ignore the event.
+func (n *Node) MemberJoined(m Member) {
+	if m.RaftID == "" || m.RaftID == n.cfg.NodeID || !n.IsLeader() {
+		return
+	}
+	if _, known := n.fsm.node(m.RaftID); known {
+		return
+	}
+
+	go func() {
+		info := NodeInfo{ID: m.RaftID, RaftAddress: m.RaftAddress, HTTPAddress: m.HTTPAddress}
+		if err := n.Join(context.Background(), info); err != nil {
+			n.log.Error().Err(err).Str("node_id", m.RaftID).Msg("failed to add discovered node")
+		}
+	}()
+}
+
+// MemberLeft removes nodes that left gracefully from the Raft
+// configuration. Failed nodes are kept, Raft tolerates them and they are
+// expected to come back.
+func (n *Node) MemberLeft(m Member, dead bool) {
+	if dead || m.RaftID == "" || m.RaftID == n.cfg.NodeID || !n.IsLeader() {
+		return
+	}
+
+	go func() {
+		if err := n.Leave(context.Background(), m.RaftID); err != nil {
+			n.log.Error().Err(err).Str("node_id", m.RaftID).Msg("failed to remove departed node")
+		}
+	}()
+}
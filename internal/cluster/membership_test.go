@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestMembership(t *testing.T, name string, self Member, seeds []string, listeners ...MembershipListener) *Membership {
+	t.Helper()
+
+	conf := memberlist.DefaultLocalConfig()
+	conf.BindAddr = "127.0.0.1"
+	conf.BindPort = 0
+	conf.GossipInterval = 10 * time.Millisecond
+	conf.ProbeInterval = 50 * time.Millisecond
+
+	m, err := newMembership(zerolog.Nop(), conf, GossipConfig{NodeName: name, Seeds: seeds}, self, listeners...)
+	require.NoError(t, err)
+	return m
+}
+
+func TestMembership(t *testing.T) {
+	proxy, err := NewProxy(zerolog.Nop(), ShardConfig{})
+	require.NoError(t, err)
+
+	coordinator := newTestMembership(t, "coordinator", Member{Role: RoleCoordinator}, nil, proxy)
+	t.Cleanup(func() { _ = coordinator.Close() })
+	seed := []string{coordinator.list.LocalNode().Address()}
+
+	storage := newTestMembership(t, "storage-1", Member{Role: RoleStorage, HTTPAddress: "127.0.0.1:8081"}, seed)
+
+	t.Run("discovers members", func(t *testing.T) {
+		require.Eventually(t, func() bool {
+			return len(coordinator.Members()) == 2 && len(storage.Members()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, []string{"127.0.0.1:8081"}, proxy.Ring().Nodes())
+	})
+
+	t.Run("replays members to late listeners", func(t *testing.T) {
+		late, err := NewProxy(zerolog.Nop(), ShardConfig{})
+		require.NoError(t, err)
+
+		coordinator.AddListener(late)
+		assert.Equal(t, []string{"127.0.0.1:8081"}, late.Ring().Nodes())
+	})
+
+	t.Run("removes members that leave", func(t *testing.T) {
+		require.NoError(t, storage.Close())
+
+		assert.Eventually(t, func() bool {
+			return len(proxy.Ring().Nodes()) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestNodeMembershipListener(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	leader := nodes[0]
+	member := Member{Name: "n1", Role: RoleStorage, RaftID: "node-1", RaftAddress: "node-1", HTTPAddress: "node-1.http"}
+
+	voters := func() []string {
+		future := leader.raft.GetConfiguration()
+		require.NoError(t, future.Error())
+		var ids []string
+		for _, server := range future.Configuration().Servers {
+			ids = append(ids, string(server.ID))
+		}
+		return ids
+	}
+
+	t.Run("keeps failed members", func(t *testing.T) {
+		leader.MemberLeft(member, true)
+		assert.Never(t, func() bool {
+			return len(voters()) != 2
+		}, 200*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("removes members that leave", func(t *testing.T) {
+		leader.MemberLeft(member, false)
+		assert.Eventually(t, func() bool {
+			_, known := leader.fsm.node("node-1")
+			return len(voters()) == 1 && !known
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("adds discovered members", func(t *testing.T) {
+		leader.MemberJoined(member)
+		assert.Eventually(t, func() bool {
+			info, known := leader.fsm.node("node-1")
+			return len(voters()) == 2 && known && info.HTTPAddress == "node-1.http"
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("followers ignore events", func(t *testing.T) {
+		nodes[1].MemberLeft(Member{RaftID: "node-0"}, false)
+		assert.Never(t, func() bool {
+			return len(voters()) != 2
+		}, 200*time.Millisecond, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,503 @@
+// Package cluster provides the clustered modes of the key-value store.
+//
+// In Raft mode every mutation is committed through a replicated log before
+// it is applied to the local repository of each node, giving linearizable
+// writes and automatic leader failover across three or more nodes. Reads
+// are forwarded to the leader unless the client accepts a stale answer
+// from the local replica.
+//
+// In sharding mode a coordinator routes each key to one of the configured
+// storage nodes by consistent hashing, so the keyspace scales horizontally
+// behind a single endpoint.
+//
+// Either mode can discover its peers through gossip membership, which
+// reports joining, leaving and failed nodes to the Raft leader and the
+// sharding ring.
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/repository"
+)
+
+var (
+	// ErrNotLeader is returned when a write reaches a node that is not the
+	// Raft leader.
+	ErrNotLeader = errors.New("node is not the cluster leader")
+	// ErrNoLeader is returned when the cluster currently has no leader.
+	ErrNoLeader = errors.New("cluster has no leader")
+)
+
+// RaftConfig holds the configuration of the Raft consensus mode.
+type RaftConfig struct {
+	// Enabled turns on Raft mode.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// NodeID uniquely identifies this node in the cluster.
+	NodeID string `envconfig:"NODE_ID"`
+	// BindAddress is the address the Raft transport listens on.
+	BindAddress string `envconfig:"BIND_ADDRESS" default:"127.0.0.1:7000"`
+	// AdvertiseAddress is the Raft address other nodes use to reach this
+	// node, defaults to BindAddress.
+	AdvertiseAddress string `envconfig:"ADVERTISE_ADDRESS"`
+	// HTTPAddress is the address other nodes use to reach this node's HTTP
+	// API, used to forward writes to the leader.
+	HTTPAddress string `envconfig:"HTTP_ADDRESS"`
+	// DataDir holds the Raft log and snapshots.
+	DataDir string `envconfig:"DATA_DIR" default:"raft"`
+	// Bootstrap creates a new single-node cluster when no state exists.
+	Bootstrap bool `envconfig:"BOOTSTRAP" default:"false"`
+	// ApplyTimeout bounds how long a write waits to be committed.
+	ApplyTimeout time.Duration `envconfig:"APPLY_TIMEOUT" default:"5s"`
+}
+
+// raftSnapshotsRetained is the number of Raft snapshots kept on disk.
+const raftSnapshotsRetained = 2
+
+// Node is a member of a Raft cluster. It implements repository.Store,
+// committing mutations through the Raft log and reading from the local
+// replica.
+type Node struct {
+	cfg   RaftConfig
+	log   zerolog.Logger
+	raft  *raft.Raft
+	fsm   *fsm
+	store *repository.KeyValueStore
+
+	closers   []func() error
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+var _ repository.Store = (*Node)(nil)
+
+// NewNode starts a Raft node persisting its log and snapshots in
+// cfg.DataDir and applying committed commands to store.
+func NewNode(log zerolog.Logger, cfg RaftConfig, store *repository.KeyValueStore) (*Node, error) {
+	if cfg.NodeID == "" {
+		return nil, errors.New("raft node id is required")
+	}
+	if cfg.AdvertiseAddress == "" {
+		cfg.AdvertiseAddress = cfg.BindAddress
+	}
+
+	if err := os.MkdirAll(cfg.DataDir, 0o750); err != nil {
+		return nil, fmt.Errorf("create raft data dir: %w", err)
+	}
+
+	raftLog := log.With().Str("component", "raft").Logger()
+
+	boltStore, err := raftboltdb.NewBoltStore(filepath.Join(cfg.DataDir, "raft.db"))
+	if err != nil {
+		return nil, fmt.Errorf("open raft log store: %w", err)
+	}
+
+	snapshots, err := raft.NewFileSnapshotStore(cfg.DataDir, raftSnapshotsRetained, raftLog)
+	if err != nil {
+		_ = boltStore.Close()
+		return nil, fmt.Errorf("open raft snapshot store: %w", err)
+	}
+
+	advertise, err := net.ResolveTCPAddr("tcp", cfg.AdvertiseAddress)
+	if err != nil {
+		_ = boltStore.Close()
+		return nil, fmt.Errorf("resolve raft advertise address: %w", err)
+	}
+
+	transport, err := raft.NewTCPTransport(cfg.BindAddress, advertise, 3, 10*time.Second, raftLog)
+	if err != nil {
+		_ = boltStore.Close()
+		return nil, fmt.Errorf("create raft transport: %w", err)
+	}
+
+	n, err := newNode(log, cfg, raft.DefaultConfig(), store, boltStore, boltStore, snapshots, transport)
+	if err != nil {
+		_ = transport.Close()
+		_ = boltStore.Close()
+		return nil, err
+	}
+	n.closers = append(n.closers, transport.Close, boltStore.Close)
+
+	return n, nil
+}
+
+// newNode wires a Node on top of the given Raft storage and transport.
+func newNode(
+	log zerolog.Logger,
+	cfg RaftConfig,
+	conf *raft.Config,
+	store *repository.KeyValueStore,
+	logs raft.LogStore,
+	stable raft.StableStore,
+	snapshots raft.SnapshotStore,
+	transport raft.Transport,
+) (*Node, error) {
+	conf.LocalID = raft.ServerID(cfg.NodeID)
+	conf.LogOutput = log.With().Str("component", "raft").Logger()
+
+	n := &Node{
+		cfg:   cfg,
+		log:   log,
+		fsm:   newFSM(store),
+		store: store,
+		done:  make(chan struct{}),
+	}
+
+	r, err := raft.NewRaft(conf, n.fsm, logs, stable, snapshots, transport)
+	if err != nil {
+		return nil, fmt.Errorf("start raft: %w", err)
+	}
+	n.raft = r
+
+	if cfg.Bootstrap {
+		hasState, err := raft.HasExistingState(logs, stable, snapshots)
+		if err != nil {
+			_ = r.Shutdown().Error()
+			return nil, fmt.Errorf("check raft state: %w", err)
+		}
+		if !hasState {
+			bootstrap := raft.Configuration{Servers: []raft.Server{{
+				ID:      conf.LocalID,
+				Address: transport.LocalAddr(),
+			}}}
+			if err := r.BootstrapCluster(bootstrap).Error(); err != nil {
+				_ = r.Shutdown().Error()
+				return nil, fmt.Errorf("bootstrap raft cluster: %w", err)
+			}
+		}
+	}
+
+	n.wg.Add(1)
+	go n.watchLeadership(string(transport.LocalAddr()))
+
+	return n, nil
+}
+
+// watchLeadership records this node's metadata whenever it becomes the
+// leader, so a bootstrapped node is discoverable without a join.
+func (n *Node) watchLeadership(raftAddress string) {
+	defer n.wg.Done()
+
+	self := NodeInfo{ID: n.cfg.NodeID, RaftAddress: raftAddress, HTTPAddress: n.cfg.HTTPAddress}
+	for {
+		select {
+		case <-n.done:
+			return
+		case isLeader := <-n.raft.LeaderCh():
+			if !isLeader {
+				continue
+			}
+			n.log.Info().Str("node_id", n.cfg.NodeID).Msg("acquired cluster leadership")
+			if info, ok := n.fsm.node(self.ID); ok && info == self {
+				continue
+			}
+			if _, err := n.apply(context.Background(), command{Op: opSetNode, Node: &self}); err != nil {
+				n.log.Error().Err(err).Msg("failed to record leader metadata")
+			}
+		}
+	}
+}
+
+// Close shuts the Raft node down.
+func (n *Node) Close() error {
+	var errs []error
+	n.closeOnce.Do(func() {
+		close(n.done)
+		n.wg.Wait()
+		if err := n.raft.Shutdown().Error(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, closeFn := range n.closers {
+			if err := closeFn(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Flush takes a snapshot of the node, so it restarts from the snapshot
+// instead of replaying its log. A node without new entries since its last
+// snapshot has nothing to flush.
+func (n *Node) Flush(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() { done <- n.raft.Snapshot().Error() }()
+	select {
+	case err := <-done:
+		if errors.Is(err, raft.ErrNothingNewToSnapshot) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ready reports whether the node serves up-to-date reads: it knows the
+// leader and applied every entry it knows to be committed, so a restarted
+// node isn't ready while it replays its log.
+func (n *Node) Ready() error {
+	if err := n.store.Ready(); err != nil {
+		return err
+	}
+	if n.raft.State() == raft.Shutdown {
+		return raft.ErrRaftShutdown
+	}
+	if _, id := n.raft.LeaderWithID(); id == "" {
+		return ErrNoLeader
+	}
+	commit, _ := strconv.ParseUint(n.raft.Stats()["commit_index"], 10, 64)
+	if applied := n.raft.AppliedIndex(); applied < commit {
+		return fmt.Errorf("replaying raft log: applied %d of %d committed entries", applied, commit)
+	}
+	return nil
+}
+
+// IsLeader reports whether this node is the current leader.
+func (n *Node) IsLeader() bool {
+	return n.raft.State() == raft.Leader
+}
+
+// ReplicationLag returns how long ago this node last heard from the leader,
+// which bounds how stale its local replica is. It is zero on the leader and
+// unknown until a follower first hears from a leader.
+func (n *Node) ReplicationLag() (time.Duration, bool) {
+	if n.IsLeader() {
+		return 0, true
+	}
+	contact := n.raft.LastContact()
+	if contact.IsZero() {
+		return 0, false
+	}
+	return time.Since(contact), true
+}
+
+// Leader returns the metadata of the current leader.
+func (n *Node) Leader() (NodeInfo, error) {
+	addr, id := n.raft.LeaderWithID()
+	if id == "" {
+		return NodeInfo{}, ErrNoLeader
+	}
+	info, ok := n.fsm.node(string(id))
+	if !ok {
+		info = NodeInfo{ID: string(id), RaftAddress: string(addr)}
+	}
+	return info, nil
+}
+
+// Join adds a voting member to the cluster. It must be called on the leader.
+func (n *Node) Join(ctx context.Context, info NodeInfo) error {
+	if !n.IsLeader() {
+		return ErrNotLeader
+	}
+
+	future := n.raft.AddVoter(raft.ServerID(info.ID), raft.ServerAddress(info.RaftAddress), 0, n.timeout(ctx))
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("add voter %s: %w", info.ID, err)
+	}
+
+	_, err := n.apply(ctx, command{Op: opSetNode, Node: &info})
+	return err
+}
+
+// Leave removes a member from the cluster. It must be called on the leader.
+func (n *Node) Leave(ctx context.Context, id string) error {
+	if !n.IsLeader() {
+		return ErrNotLeader
+	}
+
+	future := n.raft.RemoveServer(raft.ServerID(id), 0, n.timeout(ctx))
+	if err := future.Error(); err != nil {
+		return fmt.Errorf("remove server %s: %w", id, err)
+	}
+
+	_, err := n.apply(ctx, command{Op: opRemoveNode, Node: &NodeInfo{ID: id}})
+	return err
+}
+
+// timeout returns the time a Raft operation may take, bounded by both the
+// configured apply timeout and the deadline of ctx.
+func (n *Node) timeout(ctx context.Context) time.Duration {
+	timeout := n.cfg.ApplyTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); timeout <= 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return timeout
+}
+
+// apply commits cmd through the Raft log and returns its result.
+func (n *Node) apply(ctx context.Context, cmd command) (applyResult, error) {
+	if err := ctx.Err(); err != nil {
+		return applyResult{}, err
+	}
+	if !n.IsLeader() {
+		return applyResult{}, ErrNotLeader
+	}
+
+	cmd.Time = time.Now().UnixNano()
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return applyResult{}, fmt.Errorf("encode command: %w", err)
+	}
+
+	future := n.raft.Apply(data, n.timeout(ctx))
+	if err := future.Error(); err != nil {
+		if errors.Is(err, raft.ErrNotLeader) || errors.Is(err, raft.ErrLeadershipLost) {
+			return applyResult{}, ErrNotLeader
+		}
+		if errors.Is(err, raft.ErrEnqueueTimeout) && ctx.Err() != nil {
+			return applyResult{}, ctx.Err()
+		}
+		return applyResult{}, fmt.Errorf("apply command: %w", err)
+	}
+
+	result, ok := future.Response().(applyResult)
+	if !ok {
+		return applyResult{}, fmt.Errorf("unexpected apply response %T", future.Response())
+	}
+	return result, result.err
+}
+
+// Set implements repository.Store.
+func (n *Node) Set(ctx context.Context, key string, value []byte) error {
+	return n.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL implements repository.Store.
+func (n *Node) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := n.SetIfVersion(ctx, key, value, ttl, 0)
+	return err
+}
+
+// SetIfVersion implements repository.Store.
+func (n *Node) SetIfVersion(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	cmd := command{Op: opSet, Key: key, Value: value, Version: version}
+	if ttl > 0 {
+		cmd.ExpiresAt = time.Now().Add(ttl).UnixNano()
+	}
+	result, err := n.apply(ctx, cmd)
+	return result.version, err
+}
+
+// Get implements repository.Store.
+func (n *Node) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return n.store.Get(ctx, key)
+}
+
+// GetWithVersion implements repository.Store.
+func (n *Node) GetWithVersion(ctx context.Context, key string) ([]byte, uint64, bool, error) {
+	return n.store.GetWithVersion(ctx, key)
+}
+
+// Expiry implements repository.Store.
+func (n *Node) Expiry(ctx context.Context, key string) (time.Time, bool, error) {
+	return n.store.Expiry(ctx, key)
+}
+
+// Scan implements repository.Store.
+func (n *Node) Scan(ctx context.Context, prefix, after string, limit int) ([]repository.Item, error) {
+	return n.store.Scan(ctx, prefix, after, limit)
+}
+
+// Export implements repository.Exporter, copying the entries of the local
+// replica.
+func (n *Node) Export(ctx context.Context) (repository.Data, error) {
+	return n.store.Export(ctx)
+}
+
+// History implements repository.Store.
+func (n *Node) History(ctx context.Context, key string) ([]repository.Version, error) {
+	return n.store.History(ctx, key)
+}
+
+// Delete implements repository.Store.
+func (n *Node) Delete(ctx context.Context, key string) error {
+	return n.DeleteIfVersion(ctx, key, 0)
+}
+
+// DeleteIfVersion implements repository.Store.
+func (n *Node) DeleteIfVersion(ctx context.Context, key string, version uint64) error {
+	_, err := n.apply(ctx, command{Op: opDelete, Key: key, Version: version})
+	return err
+}
+
+// Undelete implements repository.Store.
+func (n *Node) Undelete(ctx context.Context, key string) (bool, error) {
+	result, err := n.apply(ctx, command{Op: opUndelete, Key: key})
+	return result.restored, err
+}
+
+// Increment implements repository.Store.
+func (n *Node) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	result, err := n.apply(ctx, command{Op: opIncrement, Key: key, Delta: delta})
+	return result.value, err
+}
+
+// AddMembers implements repository.Store.
+func (n *Node) AddMembers(ctx context.Context, key string, members []string, maxSize int) ([]string, error) {
+	result, err := n.apply(ctx, command{Op: opAddMembers, Key: key, Members: members, MaxSize: maxSize})
+	return result.members, err
+}
+
+// RemoveMembers implements repository.Store.
+func (n *Node) RemoveMembers(ctx context.Context, key string, members []string) ([]string, error) {
+	result, err := n.apply(ctx, command{Op: opRemoveMembers, Key: key, Members: members})
+	return result.members, err
+}
+
+// SetFields implements repository.Store.
+func (n *Node) SetFields(ctx context.Context, key string, fields map[string]string, maxSize int) (int, error) {
+	result, err := n.apply(ctx, command{Op: opSetFields, Key: key, Fields: fields, MaxSize: maxSize})
+	return int(result.value), err
+}
+
+// DeleteFields implements repository.Store.
+func (n *Node) DeleteFields(ctx context.Context, key string, names []string) (int, error) {
+	result, err := n.apply(ctx, command{Op: opDeleteFields, Key: key, Members: names})
+	return int(result.value), err
+}
+
+// AddCounter implements repository.Store.
+func (n *Node) AddCounter(ctx context.Context, key string, delta int64, overflow repository.Overflow) (int64, error) {
+	result, err := n.apply(ctx, command{Op: opAddCounter, Key: key, Delta: delta, Overflow: overflow})
+	return result.value, err
+}
+
+// ResetCounter implements repository.Store.
+func (n *Node) ResetCounter(ctx context.Context, key string, value int64) (int64, error) {
+	result, err := n.apply(ctx, command{Op: opResetCounter, Key: key, Delta: value})
+	return result.value, err
+}
+
+// SetPath implements repository.Store.
+func (n *Node) SetPath(ctx context.Context, key, path string, value []byte, maxSize int) error {
+	_, err := n.apply(ctx, command{Op: opSetPath, Key: key, Path: path, Value: value, MaxSize: maxSize})
+	return err
+}
+
+// AddScores implements repository.Store.
+func (n *Node) AddScores(ctx context.Context, key string, members []repository.ScoredMember, maxSize int) ([]string, error) {
+	result, err := n.apply(ctx, command{Op: opAddScores, Key: key, Scores: members, MaxSize: maxSize})
+	return result.members, err
+}
+
+// Combine implements repository.Store.
+func (n *Node) Combine(ctx context.Context, op repository.SetOp, keys []string) ([]string, error) {
+	return n.store.Combine(ctx, op, keys)
+}
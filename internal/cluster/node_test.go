@@ -0,0 +1,297 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func testRaftConfig() *raft.Config {
+	conf := raft.DefaultConfig()
+	conf.HeartbeatTimeout = 50 * time.Millisecond
+	conf.ElectionTimeout = 50 * time.Millisecond
+	conf.LeaderLeaseTimeout = 50 * time.Millisecond
+	conf.CommitTimeout = 5 * time.Millisecond
+	return conf
+}
+
+// newTestCluster starts size nodes over an in-memory transport, with the
+// first node bootstrapped as leader and the others joined to it.
+func newTestCluster(t *testing.T, size int) []*Node {
+	t.Helper()
+
+	logger := zerolog.Nop()
+	nodes := make([]*Node, size)
+	transports := make([]*raft.InmemTransport, size)
+	for i := range transports {
+		_, transports[i] = raft.NewInmemTransport(raft.ServerAddress(fmt.Sprintf("node-%d", i)))
+	}
+	for i, a := range transports {
+		for j, b := range transports {
+			if i != j {
+				a.Connect(b.LocalAddr(), b)
+			}
+		}
+	}
+
+	for i := range nodes {
+		kv, err := repository.NewKeyValueStore(logger, repository.Opts{})
+		require.NoError(t, err)
+
+		cfg := RaftConfig{
+			NodeID:       fmt.Sprintf("node-%d", i),
+			HTTPAddress:  fmt.Sprintf("node-%d.http", i),
+			Bootstrap:    i == 0,
+			ApplyTimeout: time.Second,
+		}
+		store := raft.NewInmemStore()
+		node, err := newNode(logger, cfg, testRaftConfig(), kv, store, store, raft.NewInmemSnapshotStore(), transports[i])
+		require.NoError(t, err)
+		nodes[i] = node
+		t.Cleanup(func() { _ = node.Close() })
+	}
+
+	require.Eventually(t, nodes[0].IsLeader, 5*time.Second, 10*time.Millisecond)
+	for i, node := range nodes[1:] {
+		err := nodes[0].Join(context.Background(), NodeInfo{
+			ID:          node.cfg.NodeID,
+			RaftAddress: string(transports[i+1].LocalAddr()),
+			HTTPAddress: node.cfg.HTTPAddress,
+		})
+		require.NoError(t, err)
+	}
+
+	return nodes
+}
+
+func TestNodeReplication(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	leader := nodes[0]
+	ctx := context.Background()
+
+	require.NoError(t, leader.Set(ctx, "key", []byte("value")))
+	require.NoError(t, leader.SetWithTTL(ctx, "temp", []byte("value"), time.Hour))
+	got, err := leader.Increment(ctx, "counter", 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), got)
+
+	_, err = leader.Increment(ctx, "key", 1)
+	assert.ErrorIs(t, err, repository.ErrNotInteger)
+
+	added, err := leader.AddMembers(ctx, "tags", []string{"b", "a", "c"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, added)
+	removed, err := leader.RemoveMembers(ctx, "tags", []string{"c"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, removed)
+	created, err := leader.SetFields(ctx, "user", map[string]string{"name": "Alice", "age": "30"}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, created)
+	deleted, err := leader.DeleteFields(ctx, "user", []string{"age"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	count, err := leader.AddCounter(ctx, "hits", math.MaxInt64, repository.OverflowError)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), count)
+	_, err = leader.AddCounter(ctx, "hits", 1, repository.OverflowError)
+	assert.ErrorIs(t, err, repository.ErrOverflow)
+	previous, err := leader.ResetCounter(ctx, "hits", 7)
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), previous)
+	require.NoError(t, leader.SetPath(ctx, "doc", "$", []byte(`{"a":{"b":1}}`), 0))
+	require.NoError(t, leader.SetPath(ctx, "doc", "$.a.b", []byte(`2`), 0))
+	added, err = leader.AddScores(ctx, "board", []repository.ScoredMember{{Member: "a", Score: 2}, {Member: "b", Score: 1}}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, added)
+
+	for _, node := range nodes {
+		assert.Eventually(t, func() bool {
+			value, exists, err := node.Get(ctx, "counter")
+			return err == nil && exists && string(value) == "3"
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+		assert.Eventually(t, func() bool {
+			members, err := node.Combine(ctx, repository.Union, []string{"tags"})
+			return err == nil && assert.ObjectsAreEqual([]string{"a", "b"}, members)
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+		assert.Eventually(t, func() bool {
+			value, exists, err := node.Get(ctx, "user")
+			return err == nil && exists && string(value) == `{"name":"Alice"}`
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+		assert.Eventually(t, func() bool {
+			value, exists, err := node.Get(ctx, "hits")
+			return err == nil && exists && string(value) == `{"counter":7}`
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+		assert.Eventually(t, func() bool {
+			value, exists, err := node.Get(ctx, "doc")
+			return err == nil && exists && string(value) == `{"a":{"b":2}}`
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+		assert.Eventually(t, func() bool {
+			value, exists, err := node.Get(ctx, "board")
+			return err == nil && exists && string(value) == `[{"member":"b","score":1},{"member":"a","score":2}]`
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+
+		expiresAt, exists, err := node.Expiry(ctx, "temp")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.False(t, expiresAt.IsZero())
+	}
+
+	require.NoError(t, leader.Delete(ctx, "key"))
+	for _, node := range nodes {
+		assert.Eventually(t, func() bool {
+			_, exists, err := node.Get(ctx, "key")
+			return err == nil && !exists
+		}, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+	}
+}
+
+func TestNodeReady(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	for _, node := range nodes {
+		assert.Eventually(t, func() bool { return node.Ready() == nil }, 5*time.Second, 10*time.Millisecond, node.cfg.NodeID)
+	}
+
+	require.NoError(t, nodes[1].Close())
+	assert.Error(t, nodes[1].Ready())
+}
+
+func TestNodeFlush(t *testing.T) {
+	node := newTestCluster(t, 1)[0]
+	ctx := context.Background()
+
+	require.NoError(t, node.Set(ctx, "key", []byte("value")))
+	require.NoError(t, node.Flush(ctx))
+	assert.NotEqual(t, "0", node.raft.Stats()["last_snapshot_index"])
+
+	// Flushing again without new entries is a no-op.
+	assert.NoError(t, node.Flush(ctx))
+}
+
+func TestNodeFollowerRejectsWrites(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	follower := nodes[1]
+	ctx := context.Background()
+
+	assert.ErrorIs(t, follower.Set(ctx, "key", []byte("value")), ErrNotLeader)
+	assert.ErrorIs(t, follower.Join(ctx, NodeInfo{ID: "node-9"}), ErrNotLeader)
+
+	require.Eventually(t, func() bool {
+		leader, err := follower.Leader()
+		return err == nil && leader.HTTPAddress == "node-0.http"
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestNodeForwardToLeader(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+
+	var reached string
+	leaderAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = r.Header.Get(forwardedHeader)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer leaderAPI.Close()
+
+	leaderInfo := NodeInfo{ID: "node-0", RaftAddress: "node-0", HTTPAddress: strings.TrimPrefix(leaderAPI.URL, "http://")}
+	_, err := nodes[0].apply(context.Background(), command{Op: opSetNode, Node: &leaderInfo})
+	require.NoError(t, err)
+
+	follower := nodes[1]
+	require.Eventually(t, func() bool {
+		leader, err := follower.Leader()
+		return err == nil && leader.HTTPAddress == leaderInfo.HTTPAddress
+	}, 5*time.Second, 10*time.Millisecond)
+
+	local := follower.ForwardToLeader(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		headers     map[string]string
+		wantCode    int
+		wantReached string
+		wantLag     bool
+	}{
+		{
+			name:        "read forwarded",
+			method:      http.MethodGet,
+			path:        "/key/a",
+			wantCode:    http.StatusCreated,
+			wantReached: "node-1",
+		},
+		{
+			name:     "stale read served locally",
+			method:   http.MethodGet,
+			path:     "/key/a",
+			headers:  map[string]string{AllowStaleHeader: "true"},
+			wantCode: http.StatusOK,
+			wantLag:  true,
+		},
+		{
+			name:        "stale flag disabled",
+			method:      http.MethodGet,
+			path:        "/key/a",
+			headers:     map[string]string{AllowStaleHeader: "false"},
+			wantCode:    http.StatusCreated,
+			wantReached: "node-1",
+		},
+		{
+			name:     "node local read",
+			method:   http.MethodGet,
+			path:     "/metrics",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:        "write forwarded",
+			method:      http.MethodPost,
+			path:        "/key",
+			headers:     map[string]string{AllowStaleHeader: "true"},
+			wantCode:    http.StatusCreated,
+			wantReached: "node-1",
+		},
+		{
+			name:     "forwarding loop",
+			method:   http.MethodPost,
+			path:     "/key",
+			headers:  map[string]string{forwardedHeader: "node-2"},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = ""
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(`{}`))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			local.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantReached, reached)
+			assert.Equal(t, tt.wantLag, w.Header().Get(ReplicationLagHeader) != "")
+			assert.Equal(t, tt.wantLag, w.Header().Get(AppliedIndexHeader) != "")
+		})
+	}
+
+	t.Run("leader reports no lag", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		nodes[0].ForwardToLeader(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/key/a", nil))
+		assert.Equal(t, "0", w.Header().Get(ReplicationLagHeader))
+	})
+}
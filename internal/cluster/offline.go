@@ -0,0 +1,347 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"go.etcd.io/bbolt"
+
+	"codesignal/internal/repository"
+)
+
+// The functions below work on the data directory of a stopped node, for
+// offline maintenance tools.
+
+// ErrNodeRunning is returned when the Raft log is locked by a running node.
+var ErrNodeRunning = errors.New("raft log is in use, stop the node first")
+
+const (
+	// raftLogFile is the Raft log store in the data directory.
+	raftLogFile = "raft.db"
+	// openTimeout bounds the wait for the lock of the Raft log.
+	openTimeout = time.Second
+	// compactTxSize is the size of the transactions copying the Raft log.
+	compactTxSize = 64 << 20
+)
+
+// Snapshot is the latest Raft snapshot of a data directory.
+type Snapshot struct {
+	Meta *raft.SnapshotMeta
+	Data repository.Data
+	// Nodes is the cluster membership recorded in the snapshot.
+	Nodes map[string]NodeInfo
+}
+
+// ReadSnapshot returns the latest snapshot of the data directory of a node,
+// verifying its checksum, or nil if the node has no snapshot yet.
+func ReadSnapshot(dataDir string) (*Snapshot, error) {
+	snapshots, err := raft.NewFileSnapshotStore(dataDir, raftSnapshotsRetained, io.Discard)
+	if err != nil {
+		return nil, fmt.Errorf("open raft snapshot store: %w", err)
+	}
+	metas, err := snapshots.List()
+	if err != nil {
+		return nil, fmt.Errorf("list raft snapshots: %w", err)
+	}
+	if len(metas) == 0 {
+		return nil, nil
+	}
+
+	meta, rc, err := snapshots.Open(metas[0].ID)
+	if err != nil {
+		return nil, fmt.Errorf("open raft snapshot %s: %w", metas[0].ID, err)
+	}
+	defer rc.Close()
+
+	var state fsmState
+	if err := gob.NewDecoder(rc).Decode(&state); err != nil {
+		return nil, fmt.Errorf("decode fsm snapshot %s: %w", meta.ID, err)
+	}
+	data, err := repository.DecodeSnapshot(bytes.NewReader(state.KV))
+	if err != nil {
+		return nil, fmt.Errorf("raft snapshot %s: %w", meta.ID, err)
+	}
+	return &Snapshot{Meta: meta, Data: data, Nodes: state.Nodes}, nil
+}
+
+// LogInfo describes the Raft log of a data directory.
+type LogInfo struct {
+	FirstIndex uint64
+	LastIndex  uint64
+	// Size is the size of the log file in bytes.
+	Size int64
+}
+
+// VerifyLog checks the page structure of the Raft log file of the data
+// directory and reads every entry, checking that replicated commands decode.
+func VerifyLog(dataDir string) (LogInfo, error) {
+	if err := checkFile(filepath.Join(dataDir, raftLogFile)); err != nil {
+		return LogInfo{}, err
+	}
+	logs, err := openLog(dataDir, true)
+	if err != nil {
+		return LogInfo{}, err
+	}
+	defer logs.Close()
+
+	info, err := logInfo(dataDir, logs)
+	if err != nil {
+		return LogInfo{}, err
+	}
+	if info.LastIndex == 0 {
+		return info, nil
+	}
+
+	for index := info.FirstIndex; index <= info.LastIndex; index++ {
+		if err := checkEntry(logs, index); err != nil {
+			return info, err
+		}
+	}
+	return info, nil
+}
+
+// checkEntry reads the Raft log entry at index, checking that replicated
+// commands decode.
+func checkEntry(logs *raftboltdb.BoltStore, index uint64) error {
+	var l raft.Log
+	if err := logs.GetLog(index, &l); err != nil {
+		return fmt.Errorf("read raft log entry %d: %w", index, err)
+	}
+	if l.Type != raft.LogCommand {
+		return nil
+	}
+	var cmd command
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return fmt.Errorf("decode raft log entry %d: %w", index, err)
+	}
+	return nil
+}
+
+// LogRepair reports the entries removed from the Raft log by RepairLog.
+type LogRepair struct {
+	// First and Last are the range of entries removed, both zero when every
+	// entry was valid.
+	First, Last uint64
+	// Backup is the copy of the log file taken before removing them.
+	Backup string
+}
+
+// RepairLog removes the Raft log entries of the data directory that can't
+// be read or decoded, so the node starts again. When the latest snapshot
+// covers every invalid entry, the entries up to the snapshot index are
+// removed. Otherwise the log is truncated before the first invalid entry:
+// followers receive the removed entries again from the leader, a single node
+// loses them. The log file is copied to Backup before it is modified.
+func RepairLog(dataDir string) (LogRepair, error) {
+	path := filepath.Join(dataDir, raftLogFile)
+	if err := checkFile(path); err != nil {
+		return LogRepair{}, err
+	}
+	snapshot, err := ReadSnapshot(dataDir)
+	if err != nil {
+		return LogRepair{}, err
+	}
+
+	logs, err := openLog(dataDir, false)
+	if err != nil {
+		return LogRepair{}, err
+	}
+	info, err := logInfo(dataDir, logs)
+	if err != nil {
+		_ = logs.Close()
+		return LogRepair{}, err
+	}
+
+	var firstBad, lastBad uint64
+	for index := info.FirstIndex; info.LastIndex > 0 && index <= info.LastIndex; index++ {
+		if checkEntry(logs, index) == nil {
+			continue
+		}
+		if firstBad == 0 {
+			firstBad = index
+		}
+		lastBad = index
+	}
+	if firstBad == 0 {
+		return LogRepair{}, logs.Close()
+	}
+
+	repair := LogRepair{First: firstBad, Last: info.LastIndex, Backup: path + ".bak"}
+	if snapshot != nil && lastBad <= snapshot.Meta.Index {
+		repair.First = info.FirstIndex
+		repair.Last = min(snapshot.Meta.Index, info.LastIndex)
+	}
+	if err := copyFile(path, repair.Backup); err != nil {
+		_ = logs.Close()
+		return LogRepair{}, fmt.Errorf("back up raft log: %w", err)
+	}
+	if err := logs.DeleteRange(repair.First, repair.Last); err != nil {
+		_ = logs.Close()
+		return LogRepair{}, fmt.Errorf("delete raft log entries: %w", err)
+	}
+	if err := logs.Close(); err != nil {
+		return LogRepair{}, err
+	}
+	// Rewriting the file drops the pages of the removed entries.
+	return repair, compactFile(path)
+}
+
+// CompactLog deletes the Raft log entries covered by the latest snapshot,
+// keeping the last keep of them so lagging followers can still catch up
+// from the log, then rewrites the log file to reclaim their space.
+func CompactLog(dataDir string, keep uint64) (before, after LogInfo, err error) {
+	snapshot, err := ReadSnapshot(dataDir)
+	if err != nil {
+		return before, after, err
+	}
+	if snapshot == nil {
+		return before, after, errors.New("no raft snapshot, the log can't be compacted")
+	}
+
+	logs, err := openLog(dataDir, false)
+	if err != nil {
+		return before, after, err
+	}
+	before, err = logInfo(dataDir, logs)
+	if err != nil {
+		_ = logs.Close()
+		return before, after, err
+	}
+	if snapshot.Meta.Index > keep && before.FirstIndex <= snapshot.Meta.Index-keep && before.LastIndex > 0 {
+		if err := logs.DeleteRange(before.FirstIndex, snapshot.Meta.Index-keep); err != nil {
+			_ = logs.Close()
+			return before, after, fmt.Errorf("delete raft log entries: %w", err)
+		}
+	}
+	if err := logs.Close(); err != nil {
+		return before, after, err
+	}
+
+	if err := compactFile(filepath.Join(dataDir, raftLogFile)); err != nil {
+		return before, after, err
+	}
+
+	logs, err = openLog(dataDir, true)
+	if err != nil {
+		return before, after, err
+	}
+	defer logs.Close()
+	after, err = logInfo(dataDir, logs)
+	return before, after, err
+}
+
+func openLog(dataDir string, readOnly bool) (*raftboltdb.BoltStore, error) {
+	path := filepath.Join(dataDir, raftLogFile)
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("open raft log: %w", err)
+	}
+
+	logs, err := raftboltdb.New(raftboltdb.Options{
+		Path:        path,
+		BoltOptions: &bbolt.Options{Timeout: openTimeout, ReadOnly: readOnly},
+	})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, ErrNodeRunning
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open raft log: %w", err)
+	}
+	return logs, nil
+}
+
+func logInfo(dataDir string, logs *raftboltdb.BoltStore) (LogInfo, error) {
+	var info LogInfo
+	var err error
+	if info.FirstIndex, err = logs.FirstIndex(); err != nil {
+		return info, fmt.Errorf("read raft log: %w", err)
+	}
+	if info.LastIndex, err = logs.LastIndex(); err != nil {
+		return info, fmt.Errorf("read raft log: %w", err)
+	}
+	stat, err := os.Stat(filepath.Join(dataDir, raftLogFile))
+	if err != nil {
+		return info, err
+	}
+	info.Size = stat.Size()
+	return info, nil
+}
+
+// compactFile rewrites the bolt database at path without its free pages.
+// The copy replaces the original only once complete.
+func compactFile(path string) error {
+	src, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return ErrNodeRunning
+	}
+	if err != nil {
+		return fmt.Errorf("open raft log: %w", err)
+	}
+
+	tmp := path + ".compact"
+	dst, err := bbolt.Open(tmp, 0o600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		_ = src.Close()
+		return fmt.Errorf("create compacted raft log: %w", err)
+	}
+	err = bbolt.Compact(dst, src, compactTxSize)
+	err = errors.Join(err, dst.Close(), src.Close())
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("compact raft log: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// checkFile checks the page structure of the bolt database at path. Its
+// meta pages are checksummed when opened.
+func checkFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("open raft log: %w", err)
+	}
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: openTimeout, ReadOnly: true})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return ErrNodeRunning
+	}
+	if err != nil {
+		return fmt.Errorf("open raft log: %w", err)
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bbolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("raft log file is corrupted, restore a backup or rejoin the node: %w", errors.Join(errs...))
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	return errors.Join(err, out.Close())
+}
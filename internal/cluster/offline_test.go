@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+
+	"codesignal/internal/repository"
+)
+
+// writeDataDir writes a data directory with entries commands in its Raft log
+// and, if snapshotIndex isn't zero, a snapshot of data at that index.
+func writeDataDir(t *testing.T, entries int, snapshotIndex uint64, data repository.Data) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	logs, err := raftboltdb.NewBoltStore(filepath.Join(dir, raftLogFile))
+	require.NoError(t, err)
+	for i := 1; i <= entries; i++ {
+		cmd, err := json.Marshal(command{Op: opSet, Key: "k", Value: bytes.Repeat([]byte("v"), 1024)})
+		require.NoError(t, err)
+		require.NoError(t, logs.StoreLog(&raft.Log{Index: uint64(i), Term: 1, Type: raft.LogCommand, Data: cmd}))
+	}
+	require.NoError(t, logs.Close())
+
+	if snapshotIndex > 0 {
+		snapshots, err := raft.NewFileSnapshotStore(dir, raftSnapshotsRetained, io.Discard)
+		require.NoError(t, err)
+		sink, err := snapshots.Create(1, snapshotIndex, 1, raft.Configuration{}, 1, nil)
+		require.NoError(t, err)
+
+		var kv bytes.Buffer
+		require.NoError(t, repository.EncodeSnapshot(&kv, data))
+		require.NoError(t, gob.NewEncoder(sink).Encode(fsmState{KV: kv.Bytes(), Nodes: map[string]NodeInfo{"n1": {ID: "n1"}}}))
+		require.NoError(t, sink.Close())
+	}
+	return dir
+}
+
+func TestReadSnapshot(t *testing.T) {
+	data := repository.Data{Store: map[string][]byte{"a": []byte("1")}, Expiry: map[string]int64{}}
+
+	t.Run("latest snapshot", func(t *testing.T) {
+		snapshot, err := ReadSnapshot(writeDataDir(t, 0, 10, data))
+		require.NoError(t, err)
+		require.NotNil(t, snapshot)
+		assert.Equal(t, uint64(10), snapshot.Meta.Index)
+		assert.Equal(t, data, snapshot.Data)
+		assert.Contains(t, snapshot.Nodes, "n1")
+	})
+
+	t.Run("no snapshot", func(t *testing.T) {
+		snapshot, err := ReadSnapshot(t.TempDir())
+		require.NoError(t, err)
+		assert.Nil(t, snapshot)
+	})
+
+	t.Run("corrupted snapshot", func(t *testing.T) {
+		dir := writeDataDir(t, 0, 10, data)
+		states, err := filepath.Glob(filepath.Join(dir, "snapshots", "*", "state.bin"))
+		require.NoError(t, err)
+		require.Len(t, states, 1)
+		require.NoError(t, os.WriteFile(states[0], []byte("garbage"), 0o600))
+
+		_, err = ReadSnapshot(dir)
+		assert.ErrorContains(t, err, "CRC mismatch")
+	})
+}
+
+func TestVerifyLog(t *testing.T) {
+	dir := writeDataDir(t, 5, 0, repository.Data{})
+
+	info, err := VerifyLog(dir)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), info.FirstIndex)
+	assert.Equal(t, uint64(5), info.LastIndex)
+
+	logs, err := raftboltdb.NewBoltStore(filepath.Join(dir, raftLogFile))
+	require.NoError(t, err)
+	require.NoError(t, logs.StoreLog(&raft.Log{Index: 6, Term: 1, Type: raft.LogCommand, Data: []byte("{")}))
+
+	_, err = VerifyLog(dir)
+	assert.ErrorIs(t, err, ErrNodeRunning, "the log is locked while open")
+	require.NoError(t, logs.Close())
+
+	_, err = VerifyLog(dir)
+	assert.ErrorContains(t, err, "decode raft log entry 6")
+}
+
+func TestCompactLog(t *testing.T) {
+	t.Run("deletes entries covered by the snapshot", func(t *testing.T) {
+		dir := writeDataDir(t, 1000, 900, repository.Data{})
+
+		before, after, err := CompactLog(dir, 100)
+		require.NoError(t, err)
+		assert.Equal(t, LogInfo{FirstIndex: 1, LastIndex: 1000, Size: before.Size}, before)
+		assert.Equal(t, uint64(801), after.FirstIndex)
+		assert.Equal(t, uint64(1000), after.LastIndex)
+		assert.Less(t, after.Size, before.Size)
+
+		_, err = VerifyLog(dir)
+		assert.NoError(t, err)
+	})
+
+	t.Run("keeps entries within keep", func(t *testing.T) {
+		dir := writeDataDir(t, 10, 5, repository.Data{})
+
+		_, after, err := CompactLog(dir, 10)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), after.FirstIndex)
+	})
+
+	t.Run("requires a snapshot", func(t *testing.T) {
+		_, _, err := CompactLog(writeDataDir(t, 10, 0, repository.Data{}), 0)
+		assert.ErrorContains(t, err, "no raft snapshot")
+	})
+}
+
+func TestRepairLog(t *testing.T) {
+	// corrupt overwrites the Raft log entry at index with bytes that don't
+	// decode.
+	corrupt := func(t *testing.T, dir string, index uint64) {
+		t.Helper()
+		db, err := bbolt.Open(filepath.Join(dir, raftLogFile), 0o600, nil)
+		require.NoError(t, err)
+		require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte("logs")).Put(binary.BigEndian.AppendUint64(nil, index), []byte("garbage"))
+		}))
+		require.NoError(t, db.Close())
+	}
+
+	t.Run("truncates before the first invalid entry", func(t *testing.T) {
+		dir := writeDataDir(t, 10, 0, repository.Data{})
+		corrupt(t, dir, 6)
+
+		_, err := VerifyLog(dir)
+		require.ErrorContains(t, err, "read raft log entry 6")
+
+		repair, err := RepairLog(dir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(6), repair.First)
+		assert.Equal(t, uint64(10), repair.Last)
+		assert.FileExists(t, repair.Backup)
+
+		info, err := VerifyLog(dir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), info.FirstIndex)
+		assert.Equal(t, uint64(5), info.LastIndex)
+	})
+
+	t.Run("removes invalid entries covered by the snapshot", func(t *testing.T) {
+		dir := writeDataDir(t, 10, 8, repository.Data{})
+		corrupt(t, dir, 3)
+
+		repair, err := RepairLog(dir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), repair.First)
+		assert.Equal(t, uint64(8), repair.Last)
+
+		info, err := VerifyLog(dir)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(9), info.FirstIndex)
+		assert.Equal(t, uint64(10), info.LastIndex)
+	})
+
+	t.Run("valid log", func(t *testing.T) {
+		dir := writeDataDir(t, 10, 0, repository.Data{})
+
+		repair, err := RepairLog(dir)
+		require.NoError(t, err)
+		assert.Equal(t, LogRepair{}, repair)
+		assert.NoFileExists(t, filepath.Join(dir, raftLogFile+".bak"))
+	})
+}
@@ -0,0 +1,309 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// ShardConfig holds the configuration of the sharding coordinator mode.
+type ShardConfig struct {
+	// Enabled turns this process into a coordinator routing key requests
+	// to the storage nodes.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// Nodes are the HTTP addresses of the storage nodes. It may be empty
+	// when storage nodes are discovered through gossip.
+	Nodes []string `envconfig:"NODES"`
+	// VirtualNodes is the number of ring points per storage node.
+	VirtualNodes int `envconfig:"VIRTUAL_NODES" default:"128"`
+	// MaxBodySize bounds the request body read to find the key of a write.
+	MaxBodySize int64 `envconfig:"MAX_BODY_SIZE" default:"2097152"`
+	// ReplicationFactor is the number of storage nodes (N) holding each key.
+	ReplicationFactor int `envconfig:"REPLICATION_FACTOR" default:"1"`
+	// WriteQuorum and ReadQuorum are the number of replicas (W and R) that
+	// must answer a write or a read. Zero means a majority of N.
+	WriteQuorum int `envconfig:"WRITE_QUORUM"`
+	ReadQuorum  int `envconfig:"READ_QUORUM"`
+	// NamespaceWriteQuorum and NamespaceReadQuorum override the quorums for
+	// keys starting with a prefix, as in "sessions/:1,accounts/:3".
+	NamespaceWriteQuorum map[string]int `envconfig:"NAMESPACE_WRITE_QUORUM"`
+	NamespaceReadQuorum  map[string]int `envconfig:"NAMESPACE_READ_QUORUM"`
+	// ReplicaTimeout bounds how long a replica may take to answer.
+	ReplicaTimeout time.Duration `envconfig:"REPLICA_TIMEOUT" default:"5s"`
+	// HintedHandoff buffers writes for unavailable replicas and replays
+	// them when the replicas return.
+	HintedHandoff bool `envconfig:"HINTED_HANDOFF" default:"false"`
+	// MaxHints bounds the writes buffered per replica, zero is unbounded.
+	MaxHints int `envconfig:"MAX_HINTS" default:"10000"`
+	// HintTTL is how long a buffered write is kept, zero keeps it forever.
+	HintTTL time.Duration `envconfig:"HINT_TTL" default:"3h"`
+	// HintReplayInterval is how often buffered writes are retried.
+	HintReplayInterval time.Duration `envconfig:"HINT_REPLAY_INTERVAL" default:"10s"`
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// defaultHintReplayInterval is used when hinted handoff is enabled without
+// a replay interval.
+const defaultHintReplayInterval = 10 * time.Second
+
+// Proxy routes key requests to the storage node owning the key on a
+// consistent-hashing ring, so clients can use a single endpoint while the
+// keyspace is spread over many nodes. With a replication factor above one
+// each key is kept on the next nodes of the ring as well, and requests are
+// answered once a read or write quorum of them agrees.
+type Proxy struct {
+	cfg  ShardConfig
+	log  zerolog.Logger
+	ring *Ring
+
+	client *http.Client
+	hints  *hintStore
+	// normalizer normalizes keys as the storage nodes do, so every
+	// spelling of a key is placed on the same nodes.
+	normalizer store.KeyNormalizer
+
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy
+
+	replayNow chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewProxy returns a coordinator for the storage nodes in cfg.
+func NewProxy(log zerolog.Logger, cfg ShardConfig) (*Proxy, error) {
+	for _, node := range cfg.Nodes {
+		if _, err := nodeURL(node); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ReplicationFactor <= 0 {
+		cfg.ReplicationFactor = 1
+	}
+	if err := validateQuorums(cfg); err != nil {
+		return nil, err
+	}
+
+	p := &Proxy{
+		cfg:       cfg,
+		log:       log,
+		ring:      NewRing(cfg.VirtualNodes, cfg.Nodes...),
+		client:    &http.Client{Timeout: cfg.ReplicaTimeout},
+		proxies:   make(map[string]*httputil.ReverseProxy),
+		replayNow: make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	if cfg.HintedHandoff && cfg.ReplicationFactor > 1 {
+		interval := cfg.HintReplayInterval
+		if interval <= 0 {
+			interval = defaultHintReplayInterval
+		}
+		p.hints = newHintStore(cfg.MaxHints, cfg.HintTTL)
+		p.wg.Add(1)
+		go p.runHandoff(interval)
+	}
+
+	return p, nil
+}
+
+// Close stops replaying buffered writes. Writes still buffered are lost.
+func (p *Proxy) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+	})
+}
+
+// Ring returns the ring the proxy routes with.
+func (p *Proxy) Ring() *Ring {
+	return p.ring
+}
+
+// Ready reports whether the coordinator has storage nodes to route to.
+func (p *Proxy) Ready() error {
+	if len(p.ring.Nodes()) == 0 {
+		return errors.New("no storage nodes available")
+	}
+	return nil
+}
+
+// SetKeyNormalizer normalizes the keys of the requests before they are
+// placed on the ring, as set by KEY_NORMALIZATION. It is called before the
+// proxy serves requests.
+func (p *Proxy) SetKeyNormalizer(n store.KeyNormalizer) {
+	p.normalizer = n
+}
+
+// Middleware forwards key requests to their owning node and passes every
+// other request, such as metrics and admin endpoints, to next. The routes
+// spanning several keys, such as listing keys, are rejected rather than
+// answered from the coordinator's own store.
+func (p *Proxy) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok, err := routeKey(r, p.cfg.MaxBodySize)
+		switch {
+		case errors.Is(err, errBodyTooLarge):
+			writeJSON(p.log, w, http.StatusRequestEntityTooLarge, store.Response{Message: err.Error(), StatusCode: store.StatusValueTooLarge})
+			return
+		case err != nil:
+			writeJSON(p.log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+			return
+		case !ok && isUnroutedPath(r.URL.Path):
+			writeJSON(p.log, w, http.StatusNotImplemented, store.Response{Message: "not supported by the sharding coordinator, query the storage nodes", StatusCode: store.StatusNotRouted})
+			return
+		case !ok:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key = p.normalizer.Normalize(key)
+		owners := p.ring.Owners(key, p.cfg.ReplicationFactor)
+		if len(owners) == 0 {
+			writeJSON(p.log, w, http.StatusServiceUnavailable, store.Response{Message: "no storage nodes available", StatusCode: store.StatusShardUnavailable})
+			return
+		}
+
+		if p.cfg.ReplicationFactor == 1 {
+			p.proxyFor(owners[0]).ServeHTTP(w, r)
+			return
+		}
+		p.replicate(w, r, key, owners)
+	})
+}
+
+// proxyFor returns the cached reverse proxy for node.
+func (p *Proxy) proxyFor(node string) *httputil.ReverseProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if proxy, ok := p.proxies[node]; ok {
+		return proxy
+	}
+
+	target, _ := nodeURL(node)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		p.log.Error().Err(err).Str("node", node).Msg("failed to reach storage node")
+		writeJSON(p.log, w, http.StatusBadGateway, store.Response{Message: "storage node unavailable", StatusCode: store.StatusShardUnavailable})
+	}
+	p.proxies[node] = proxy
+	return proxy
+}
+
+// routeKey extracts the key addressed by a request. Keys are taken from
+// the path for /key/:key, /key/b64/:encoded, /hash/:key, /zset/:key and
+// /counter/:key routes and from the JSON body for POST /key, in which case
+// the body is buffered and replaced so it can be forwarded.
+func routeKey(r *http.Request, maxBody int64) (string, bool, error) {
+	path := unversioned(r.URL.Path)
+	if encoded, ok := strings.CutPrefix(path, "/key/b64/"); ok {
+		// Invalid encodings are rejected by the local router.
+		key, err := store.DecodeKey(encoded)
+		return key, err == nil, nil
+	}
+	for _, prefix := range []string{"/key/", "/hash/", "/zset/", "/counter/"} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			key, _, _ := strings.Cut(rest, "/")
+			return key, key != "", nil
+		}
+	}
+
+	if path != "/key" || r.Method != http.MethodPost {
+		return "", false, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+	if err != nil {
+		return "", false, err
+	}
+	if int64(len(body)) > maxBody {
+		return "", false, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var kv store.KeyValue
+	if err := json.Unmarshal(body, &kv); err != nil {
+		return "", false, err
+	}
+	return kv.Key, true, nil
+}
+
+// dataPrefixes prefix the unversioned paths of the routes reading or
+// writing keys.
+var dataPrefixes = []string{"/key", "/hash/", "/zset/", "/counter/", "/sets/"}
+
+// isDataPath reports whether path is the path of a route reading or
+// writing keys.
+func isDataPath(path string) bool {
+	path = unversioned(path)
+	for _, prefix := range dataPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unroutedPaths prefix the unversioned paths of the routes spanning several
+// keys, which the coordinator can't route to a single node.
+var unroutedPaths = []string{"/keys", "/sets/", "/admin/expire"}
+
+// isUnroutedPath reports whether path is the path of a route spanning
+// several keys.
+func isUnroutedPath(path string) bool {
+	path = unversioned(path)
+	for _, prefix := range unroutedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLocalPath reports whether path is the path of a route serving the
+// node's own pub/sub channels or change log.
+func isLocalPath(path string) bool {
+	path = unversioned(path)
+	return strings.HasPrefix(path, "/channels/") || path == "/changes" || strings.HasPrefix(path, "/changes/")
+}
+
+// apiVersions are the prefixes of the versioned API routes.
+var apiVersions = []string{"/v1", "/v2"}
+
+// unversioned strips the API version prefix of a path, such as /v1, so the
+// versioned routes are routed like the unversioned ones.
+func unversioned(path string) string {
+	for _, prefix := range apiVersions {
+		if rest, ok := strings.CutPrefix(path, prefix); ok && strings.HasPrefix(rest, "/") {
+			return rest
+		}
+	}
+	return path
+}
+
+// nodeURL parses a node address, defaulting to the http scheme.
+func nodeURL(node string) (*url.URL, error) {
+	if !strings.Contains(node, "://") {
+		node = "http://" + node
+	}
+	u, err := url.Parse(node)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid node address %q", node)
+	}
+	return u, nil
+}
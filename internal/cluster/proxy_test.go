@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestProxy(t *testing.T) {
+	backends := make(map[string]string)
+	var nodes []string
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("X-Node", name)
+			_, _ = w.Write(body)
+		}))
+		t.Cleanup(srv.Close)
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		backends[addr] = name
+		nodes = append(nodes, addr)
+	}
+
+	proxy, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: nodes, MaxBodySize: 64})
+	require.NoError(t, err)
+
+	local := proxy.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Node", "local")
+	}))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		local.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("path keys go to their owner", func(t *testing.T) {
+		owner, _ := proxy.Ring().Owner("user:1")
+		for _, path := range []string{"/key/user:1", "/key/user:1/increment"} {
+			w := serve(http.MethodGet, path, "")
+			assert.Equal(t, backends[owner], w.Header().Get("X-Node"), path)
+		}
+	})
+
+	t.Run("body keys go to their owner with the body intact", func(t *testing.T) {
+		owner, _ := proxy.Ring().Owner("user:2")
+		body := `{"key":"user:2","value":"v"}`
+
+		w := serve(http.MethodPost, "/key", body)
+		assert.Equal(t, backends[owner], w.Header().Get("X-Node"))
+		assert.Equal(t, body, w.Body.String())
+	})
+
+	t.Run("non key routes are served locally", func(t *testing.T) {
+		w := serve(http.MethodGet, "/metrics", "")
+		assert.Equal(t, "local", w.Header().Get("X-Node"))
+	})
+
+	t.Run("routes spanning several keys are rejected", func(t *testing.T) {
+		for _, path := range []string{"/v1/keys?prefix=user", "/keys", "/v1/sets/union?keys=a,b", "/v1/sets/intersection?keys=a,b"} {
+			w := serve(http.MethodGet, path, "")
+			assert.Equal(t, http.StatusNotImplemented, w.Code, path)
+			assert.Empty(t, w.Header().Get("X-Node"), path)
+		}
+		assert.Equal(t, http.StatusNotImplemented, serve(http.MethodPost, "/admin/expire", `{"prefix":"user"}`).Code)
+	})
+
+	t.Run("invalid bodies are rejected", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, serve(http.MethodPost, "/key", "{").Code)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, serve(http.MethodPost, "/key", strings.Repeat("x", 65)).Code)
+	})
+
+	t.Run("keys are placed by their normalized form", func(t *testing.T) {
+		normalizer, err := store.ParseKeyNormalizer([]string{store.NormalizeLowercase})
+		require.NoError(t, err)
+		proxy.SetKeyNormalizer(normalizer)
+		t.Cleanup(func() { proxy.SetKeyNormalizer(store.KeyNormalizer{}) })
+
+		owner, _ := proxy.Ring().Owner("user:3")
+		for _, key := range []string{"user:3", "USER:3", "User:3"} {
+			w := serve(http.MethodGet, "/key/"+key, "")
+			assert.Equal(t, backends[owner], w.Header().Get("X-Node"), key)
+		}
+	})
+
+	t.Run("unreachable node", func(t *testing.T) {
+		dead, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: []string{"127.0.0.1:1"}, MaxBodySize: 64})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		dead.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/key/a", nil))
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+	})
+
+	t.Run("no nodes", func(t *testing.T) {
+		empty, err := NewProxy(zerolog.Nop(), ShardConfig{})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		empty.Middleware(http.NotFoundHandler()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/key/a", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("invalid node", func(t *testing.T) {
+		_, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: []string{"http://"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestRouteKey(t *testing.T) {
+	for path, want := range map[string]string{
+		"/key/user:1":            "user:1",
+		"/key/user:1/increment":  "user:1",
+		"/key/b64/dXNlcnMvMQ":    "users/1",
+		"/v1/key/user:1":         "user:1",
+		"/v2/key/user:1":         "user:1",
+		"/v1/hash/user:1/name":   "user:1",
+		"/v1/counter/hits/reset": "hits",
+		"/v1/zset/board/range":   "board",
+		"/keys":                  "",
+		"/v2":                    "",
+	} {
+		key, ok, err := routeKey(httptest.NewRequest(http.MethodGet, path, nil), 1024)
+		require.NoError(t, err)
+		assert.Equal(t, want != "", ok, path)
+		assert.Equal(t, want, key, path)
+	}
+
+	key, ok, err := routeKey(httptest.NewRequest(http.MethodPost, "/v2/key", strings.NewReader(`{"key":"user:2"}`)), 1024)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "user:2", key)
+}
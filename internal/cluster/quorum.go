@@ -0,0 +1,263 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"codesignal/internal/store"
+)
+
+const (
+	// WriteQuorumHeader overrides the write quorum of a single request. It
+	// takes a number of replicas or one of "one", "quorum" and "all".
+	WriteQuorumHeader = "X-Write-Quorum"
+	// ReadQuorumHeader overrides the read quorum of a single request.
+	ReadQuorumHeader = "X-Read-Quorum"
+	// ReplicasAckedHeader reports how many replicas agreed on the response.
+	ReplicasAckedHeader = "X-Replicas-Acked"
+	// ReplicasHintedHeader reports how many replicas will receive a write
+	// later through hinted handoff.
+	ReplicasHintedHeader = "X-Replicas-Hinted"
+)
+
+var errInvalidQuorum = errors.New("invalid quorum")
+
+// replicaResponse is the answer of a single replica.
+type replicaResponse struct {
+	node   string
+	index  int
+	status int
+	header http.Header
+	body   []byte
+	err    error
+	// hinted is set when the write was buffered for a later handoff.
+	hinted bool
+}
+
+// acked reports whether the replica processed the request. Client errors
+// such as a missing key are answers too; only failures to reach the node
+// or server errors don't count towards the quorum.
+func (r replicaResponse) acked() bool {
+	return r.err == nil && r.status < http.StatusInternalServerError
+}
+
+// validateQuorums checks the configured quorums fit the replication factor.
+func validateQuorums(cfg ShardConfig) error {
+	quorums := []int{cfg.WriteQuorum, cfg.ReadQuorum}
+	for _, q := range cfg.NamespaceWriteQuorum {
+		quorums = append(quorums, q)
+	}
+	for _, q := range cfg.NamespaceReadQuorum {
+		quorums = append(quorums, q)
+	}
+	for _, q := range quorums {
+		if q < 0 || q > cfg.ReplicationFactor {
+			return fmt.Errorf("%w %d for replication factor %d", errInvalidQuorum, q, cfg.ReplicationFactor)
+		}
+	}
+	return nil
+}
+
+// quorum returns the number of replicas that must answer a request for
+// key, taken from the request header, then the longest matching namespace,
+// then the default. Zero defaults resolve to a majority.
+func (p *Proxy) quorum(r *http.Request, key string, read bool) (int, error) {
+	header, fallback, namespaces := WriteQuorumHeader, p.cfg.WriteQuorum, p.cfg.NamespaceWriteQuorum
+	if read {
+		header, fallback, namespaces = ReadQuorumHeader, p.cfg.ReadQuorum, p.cfg.NamespaceReadQuorum
+	}
+
+	n := p.cfg.ReplicationFactor
+	if value := r.Header.Get(header); value != "" {
+		return parseQuorum(value, n)
+	}
+
+	longest := -1
+	for prefix, q := range namespaces {
+		if strings.HasPrefix(key, prefix) && len(prefix) > longest {
+			longest, fallback = len(prefix), q
+		}
+	}
+	if fallback == 0 {
+		fallback = n/2 + 1
+	}
+	return fallback, nil
+}
+
+func parseQuorum(value string, n int) (int, error) {
+	switch strings.ToLower(value) {
+	case "one":
+		return 1, nil
+	case "quorum":
+		return n/2 + 1, nil
+	case "all":
+		return n, nil
+	}
+
+	q, err := strconv.Atoi(value)
+	if err != nil || q < 1 || q > n {
+		return 0, fmt.Errorf("%w %q, expected 1 to %d, one, quorum or all", errInvalidQuorum, value, n)
+	}
+	return q, nil
+}
+
+// replicate sends the request to every replica of key and answers once
+// the quorum is reached. Writes keep reaching the remaining replicas after
+// the client got its answer. Reads answer with the response most of the
+// replicas agree on, preferring the primary owner on ties.
+func (p *Proxy) replicate(w http.ResponseWriter, r *http.Request, key string, owners []string) {
+	read := isReadOnly(r.Method)
+	need, err := p.quorum(r, key, read)
+	if err != nil {
+		writeJSON(p.log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidQuorum})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, p.cfg.MaxBodySize+1))
+	if err != nil {
+		writeJSON(p.log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+	if int64(len(body)) > p.cfg.MaxBodySize {
+		writeJSON(p.log, w, http.StatusRequestEntityTooLarge, store.Response{Message: errBodyTooLarge.Error(), StatusCode: store.StatusValueTooLarge})
+		return
+	}
+
+	parent := r.Context()
+	if !read {
+		// A write must reach every replica, not only the first W of them.
+		parent = context.WithoutCancel(parent)
+	}
+	ctx, cancel := context.WithCancel(parent)
+
+	req := newReplicaRequest(r, body)
+	responses := make(chan replicaResponse, len(owners))
+	for i, node := range owners {
+		go func() {
+			resp := p.sendOrHint(ctx, node, req, read)
+			resp.index = i
+			responses <- resp
+		}()
+	}
+
+	var (
+		acked    []replicaResponse
+		hinted   int
+		received int
+	)
+	// Writes buffered as hints count towards the quorum, as long as one
+	// replica really holds the write.
+	met := func() bool { return len(acked) > 0 && len(acked)+hinted >= need }
+	for ; received < len(owners) && !met(); received++ {
+		resp := <-responses
+		switch {
+		case resp.hinted:
+			hinted++
+		case resp.acked():
+			acked = append(acked, resp)
+		default:
+			p.log.Warn().Err(resp.err).Int("status", resp.status).Str("node", resp.node).Str("key", key).Msg("replica failed")
+		}
+	}
+
+	if read {
+		cancel()
+	} else {
+		go func() {
+			// Drain the replicas still in flight before releasing them.
+			defer cancel()
+			for ; received < len(owners); received++ {
+				if resp := <-responses; !resp.hinted && !resp.acked() {
+					p.log.Warn().Err(resp.err).Int("status", resp.status).Str("node", resp.node).Str("key", key).Msg("replica failed")
+				}
+			}
+		}()
+	}
+
+	if !met() {
+		msg := fmt.Sprintf("quorum not met: %d of %d replicas answered", len(acked)+hinted, need)
+		writeJSON(p.log, w, http.StatusServiceUnavailable, store.Response{Message: msg, StatusCode: store.StatusQuorumNotMet})
+		return
+	}
+
+	resp, votes := agree(acked)
+	for k, values := range resp.header {
+		w.Header()[k] = values
+	}
+	w.Header().Set(ReplicasAckedHeader, strconv.Itoa(votes))
+	if hinted > 0 {
+		w.Header().Set(ReplicasHintedHeader, strconv.Itoa(hinted))
+	}
+	w.WriteHeader(resp.status)
+	if _, err := w.Write(resp.body); err != nil {
+		p.log.Error().Err(err).Msg("error writing response")
+	}
+}
+
+// sendOrHint sends req to node. With hinted handoff, a write is buffered
+// instead when node can't be reached or still has buffered writes, which
+// must be delivered first.
+func (p *Proxy) sendOrHint(ctx context.Context, node string, req replicaRequest, read bool) replicaResponse {
+	if read || p.hints == nil {
+		return p.send(ctx, node, req)
+	}
+
+	if p.hints.pending(node) == 0 {
+		resp := p.send(ctx, node, req)
+		if resp.err == nil {
+			return resp
+		}
+		p.log.Warn().Err(resp.err).Str("node", node).Msg("replica unavailable, buffering write")
+	}
+	p.hints.add(node, req)
+	return replicaResponse{node: node, hinted: true}
+}
+
+// send forwards req to node.
+func (p *Proxy) send(ctx context.Context, node string, req replicaRequest) replicaResponse {
+	target, _ := nodeURL(node)
+	target.Path = req.path
+	target.RawQuery = req.rawQuery
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, target.String(), bytes.NewReader(req.body))
+	if err != nil {
+		return replicaResponse{node: node, err: err}
+	}
+	httpReq.Header = req.header.Clone()
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return replicaResponse{node: node, err: err}
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return replicaResponse{node: node, err: err}
+	}
+	return replicaResponse{node: node, status: resp.StatusCode, header: resp.Header, body: respBody}
+}
+
+// agree returns the response most replicas gave and how many gave it,
+// breaking ties in favour of the replica earliest in preference order.
+func agree(responses []replicaResponse) (replicaResponse, int) {
+	best, bestVotes := responses[0], 0
+	for _, candidate := range responses {
+		votes := 0
+		for _, other := range responses {
+			if other.status == candidate.status && bytes.Equal(other.body, candidate.body) {
+				votes++
+			}
+		}
+		if votes > bestVotes || (votes == bestVotes && candidate.index < best.index) {
+			best, bestVotes = candidate, votes
+		}
+	}
+	return best, bestVotes
+}
@@ -0,0 +1,163 @@
+package cluster
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// newTestReplica starts a storage node API backed by its own repository.
+func newTestReplica(t *testing.T) (*httptest.Server, *repository.KeyValueStore) {
+	t.Helper()
+
+	kv, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+
+	service := store.NewService(zerolog.Nop(), kv, store.Opts{})
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/key", service.SetKey)
+	router.HandlerFunc(http.MethodGet, "/key/:key", service.GetKey)
+	router.HandlerFunc(http.MethodDelete, "/key/:key", service.DeleteKey)
+
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+	return srv, kv
+}
+
+func TestProxyQuorum(t *testing.T) {
+	p := &Proxy{cfg: ShardConfig{
+		ReplicationFactor:    5,
+		ReadQuorum:           1,
+		NamespaceWriteQuorum: map[string]int{"a/": 2, "a/b/": 5},
+	}}
+
+	tests := []struct {
+		name    string
+		key     string
+		read    bool
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{name: "default majority", key: "k", want: 3},
+		{name: "configured default", key: "k", read: true, want: 1},
+		{name: "namespace", key: "a/k", want: 2},
+		{name: "longest namespace", key: "a/b/k", want: 5},
+		{name: "namespace of other operation", key: "a/k", read: true, want: 1},
+		{name: "header number", key: "a/k", header: "4", want: 4},
+		{name: "header one", key: "k", header: "one", want: 1},
+		{name: "header quorum", key: "k", read: true, header: "QUORUM", want: 3},
+		{name: "header all", key: "k", header: "all", want: 5},
+		{name: "header too large", key: "k", header: "6", wantErr: true},
+		{name: "header zero", key: "k", header: "0", wantErr: true},
+		{name: "header invalid", key: "k", header: "most", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/key/"+tt.key, nil)
+			if tt.header != "" {
+				r.Header.Set(WriteQuorumHeader, tt.header)
+				r.Header.Set(ReadQuorumHeader, tt.header)
+			}
+
+			got, err := p.quorum(r, tt.key, tt.read)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errInvalidQuorum)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("config must fit replication factor", func(t *testing.T) {
+		_, err := NewProxy(zerolog.Nop(), ShardConfig{ReplicationFactor: 2, WriteQuorum: 3})
+		assert.ErrorIs(t, err, errInvalidQuorum)
+	})
+}
+
+func TestProxyReplication(t *testing.T) {
+	ctx := context.Background()
+
+	var (
+		nodes []string
+		repos = make(map[string]*repository.KeyValueStore)
+	)
+	for i := 0; i < 3; i++ {
+		srv, kv := newTestReplica(t)
+		addr := strings.TrimPrefix(srv.URL, "http://")
+		nodes = append(nodes, addr)
+		repos[addr] = kv
+	}
+
+	proxy, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: nodes, ReplicationFactor: 2, MaxBodySize: 1024})
+	require.NoError(t, err)
+	handler := proxy.Middleware(http.NotFoundHandler())
+
+	serve := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("writes reach every replica", func(t *testing.T) {
+		w := serve(http.MethodPost, "/key", `{"key":"user:1","value":"v"}`, map[string]string{WriteQuorumHeader: "all"})
+		require.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "2", w.Header().Get(ReplicasAckedHeader))
+
+		owners := proxy.Ring().Owners("user:1", 2)
+		for _, node := range nodes {
+			_, exists, err := repos[node].Get(ctx, "user:1")
+			require.NoError(t, err)
+			assert.Equal(t, node == owners[0] || node == owners[1], exists, node)
+		}
+	})
+
+	t.Run("reads answer with the majority", func(t *testing.T) {
+		owners := proxy.Ring().Owners("user:1", 2)
+		require.NoError(t, repos[owners[1]].Delete(ctx, "user:1"))
+
+		w := serve(http.MethodGet, "/key/user:1", "", map[string]string{ReadQuorumHeader: "all"})
+		assert.Equal(t, http.StatusOK, w.Code, "primary wins ties")
+		assert.Equal(t, "1", w.Header().Get(ReplicasAckedHeader))
+	})
+
+	t.Run("invalid quorum", func(t *testing.T) {
+		w := serve(http.MethodGet, "/key/user:1", "", map[string]string{ReadQuorumHeader: "3"})
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), `"status_code":1014`)
+	})
+
+	t.Run("quorum not met", func(t *testing.T) {
+		dead, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: []string{nodes[0], "127.0.0.1:1"}, ReplicationFactor: 2, MaxBodySize: 1024})
+		require.NoError(t, err)
+
+		r := httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(`{"key":"user:2","value":"v"}`))
+		r.Header.Set(WriteQuorumHeader, "all")
+		w := httptest.NewRecorder()
+		dead.Middleware(http.NotFoundHandler()).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), `"status_code":1015`)
+
+		r = httptest.NewRequest(http.MethodGet, "/key/user:2", nil)
+		r.Header.Set(ReadQuorumHeader, "one")
+		w = httptest.NewRecorder()
+		dead.Middleware(http.NotFoundHandler()).ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
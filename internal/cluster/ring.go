@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultVirtualNodes is the number of points each node gets on the ring
+// when none is configured. More points give a more even key distribution.
+const DefaultVirtualNodes = 128
+
+// Ring is a consistent-hashing ring mapping keys to nodes. Adding or
+// removing a node only moves the keys adjacent to its points on the ring.
+// It is safe for concurrent use.
+type Ring struct {
+	vnodes int
+
+	mu     sync.RWMutex
+	hashes []uint32
+	owners map[uint32]string
+	nodes  map[string]struct{}
+}
+
+// NewRing returns a ring with vnodes points per node holding nodes.
+func NewRing(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &Ring{
+		vnodes: vnodes,
+		owners: make(map[uint32]string),
+		nodes:  make(map[string]struct{}),
+	}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add places node on the ring. Adding a node twice is a no-op.
+func (r *Ring) Add(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; ok {
+		return
+	}
+	r.nodes[node] = struct{}{}
+	for i := 0; i < r.vnodes; i++ {
+		h := hashKey(node + "#" + strconv.Itoa(i))
+		if _, taken := r.owners[h]; taken {
+			// Collisions are rare; the first owner keeps the point.
+			continue
+		}
+		r.owners[h] = node
+		r.hashes = append(r.hashes, h)
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+}
+
+// Remove takes node off the ring.
+func (r *Ring) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.nodes[node]; !ok {
+		return
+	}
+	delete(r.nodes, node)
+	hashes := r.hashes[:0]
+	for _, h := range r.hashes {
+		if r.owners[h] == node {
+			delete(r.owners, h)
+			continue
+		}
+		hashes = append(hashes, h)
+	}
+	r.hashes = hashes
+}
+
+// Nodes returns the nodes on the ring in sorted order.
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Shares returns the fraction of the keyspace each node owns.
+func (r *Ring) Shares() map[string]float64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	shares := make(map[string]float64, len(r.nodes))
+	if len(r.hashes) == 0 {
+		return shares
+	}
+
+	// Each point owns the arc from the previous point up to itself; the
+	// first point also owns the arc wrapping around from the last one.
+	const space = float64(math.MaxUint32) + 1
+	prev := r.hashes[len(r.hashes)-1]
+	for _, h := range r.hashes {
+		shares[r.owners[h]] += float64(h-prev) / space
+		prev = h
+	}
+	if len(r.hashes) == 1 {
+		shares[r.owners[r.hashes[0]]] = 1
+	}
+	return shares
+}
+
+// Owner returns the node owning key, or false if the ring is empty.
+func (r *Ring) Owner(key string) (string, bool) {
+	owners := r.Owners(key, 1)
+	if len(owners) == 0 {
+		return "", false
+	}
+	return owners[0], true
+}
+
+// Owners returns up to n distinct nodes responsible for key, in preference
+// order: the owner first, followed by the next nodes clockwise on the ring.
+func (r *Ring) Owners(key string, n int) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.hashes) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+
+	h := hashKey(key)
+	start := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+
+	owners := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for i := 0; len(owners) < n && i < len(r.hashes); i++ {
+		node := r.owners[r.hashes[(start+i)%len(r.hashes)]]
+		if _, dup := seen[node]; dup {
+			continue
+		}
+		seen[node] = struct{}{}
+		owners = append(owners, node)
+	}
+	return owners
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
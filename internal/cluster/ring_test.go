@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	t.Run("empty ring", func(t *testing.T) {
+		ring := NewRing(0)
+		_, ok := ring.Owner("key")
+		assert.False(t, ok)
+		assert.Empty(t, ring.Owners("key", 3))
+	})
+
+	t.Run("shares cover the keyspace", func(t *testing.T) {
+		assert.Empty(t, NewRing(0).Shares())
+		assert.Equal(t, map[string]float64{"a": 1}, NewRing(1, "a").Shares())
+
+		shares := NewRing(DefaultVirtualNodes, "a", "b", "c").Shares()
+		require.Len(t, shares, 3)
+		total := 0.0
+		for node, share := range shares {
+			assert.Greater(t, share, 0.2, node)
+			total += share
+		}
+		assert.InDelta(t, 1, total, 1e-9)
+	})
+
+	t.Run("keys spread over all nodes", func(t *testing.T) {
+		ring := NewRing(DefaultVirtualNodes, "a", "b", "c")
+
+		counts := make(map[string]int)
+		for i := 0; i < 3000; i++ {
+			owner, ok := ring.Owner(fmt.Sprintf("key-%d", i))
+			require.True(t, ok)
+			counts[owner]++
+		}
+
+		require.Len(t, counts, 3)
+		for node, count := range counts {
+			assert.Greater(t, count, 500, node)
+		}
+	})
+
+	t.Run("adding a node only moves keys to it", func(t *testing.T) {
+		ring := NewRing(DefaultVirtualNodes, "a", "b", "c")
+
+		before := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			before[key], _ = ring.Owner(key)
+		}
+
+		ring.Add("d")
+		ring.Add("d")
+		assert.Equal(t, []string{"a", "b", "c", "d"}, ring.Nodes())
+
+		for key, owner := range before {
+			now, _ := ring.Owner(key)
+			if now != owner {
+				assert.Equal(t, "d", now, key)
+			}
+		}
+
+		ring.Remove("d")
+		for key, owner := range before {
+			now, _ := ring.Owner(key)
+			assert.Equal(t, owner, now, key)
+		}
+	})
+
+	t.Run("owners are distinct and start with the owner", func(t *testing.T) {
+		ring := NewRing(16, "a", "b", "c")
+
+		owners := ring.Owners("key", 5)
+		require.Len(t, owners, 3)
+		assert.ElementsMatch(t, []string{"a", "b", "c"}, owners)
+
+		owner, _ := ring.Owner("key")
+		assert.Equal(t, owner, owners[0])
+	})
+}
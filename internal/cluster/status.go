@@ -0,0 +1,182 @@
+package cluster
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// roleStandalone is reported by nodes running without any clustering.
+const roleStandalone = "standalone"
+
+// Status describes this node and the cluster it belongs to.
+type Status struct {
+	// Role is "standalone", "coordinator", or the Raft state of the node:
+	// "leader", "follower" or "candidate".
+	Role    string       `json:"role"`
+	Raft    *RaftStatus  `json:"raft,omitempty"`
+	Shards  *ShardStatus `json:"shards,omitempty"`
+	Members []Member     `json:"members,omitempty"`
+}
+
+// RaftStatus describes the Raft state of a node.
+type RaftStatus struct {
+	NodeID       string       `json:"node_id"`
+	State        string       `json:"state"`
+	Term         uint64       `json:"term"`
+	Leader       *NodeInfo    `json:"leader,omitempty"`
+	Peers        []PeerStatus `json:"peers"`
+	CommitIndex  uint64       `json:"commit_index"`
+	AppliedIndex uint64       `json:"applied_index"`
+	// ReplicationLagMs is the time since the node last heard from the
+	// leader, absent while unknown.
+	ReplicationLagMs *int64 `json:"replication_lag_ms,omitempty"`
+}
+
+// PeerStatus describes a member of the Raft configuration.
+type PeerStatus struct {
+	NodeInfo
+	Voter bool `json:"voter"`
+}
+
+// ShardStatus describes the ring of a sharding coordinator.
+type ShardStatus struct {
+	ReplicationFactor int         `json:"replication_factor"`
+	Nodes             []ShardNode `json:"nodes"`
+}
+
+// ShardNode describes a storage node on the ring.
+type ShardNode struct {
+	Address string `json:"address"`
+	// Share is the fraction of the keyspace the node owns as primary.
+	Share float64 `json:"share"`
+	// PendingHints is the number of writes buffered for the node.
+	PendingHints int `json:"pending_hints,omitempty"`
+}
+
+// StatusResponse is the payload of the cluster status endpoint.
+type StatusResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       *Status          `json:"data"`
+}
+
+// StatusOpts holds the clustering subsystems running in the process. All
+// of them are optional.
+type StatusOpts struct {
+	Node       *Node
+	Proxy      *Proxy
+	Membership *Membership
+}
+
+// StatusHandler serves the cluster status endpoint. It answers 503 when
+// the node can't serve requests, so load balancers can use it as a health
+// check.
+type StatusHandler struct {
+	log  zerolog.Logger
+	opts StatusOpts
+}
+
+// NewStatusHandler returns the status handler for the given subsystems.
+func NewStatusHandler(log zerolog.Logger, opts StatusOpts) *StatusHandler {
+	return &StatusHandler{log: log, opts: opts}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := Status{Role: roleStandalone}
+	code, resp := http.StatusOK, StatusResponse{Message: "cluster healthy", StatusCode: store.StatusSuccess}
+
+	if h.opts.Proxy != nil {
+		status.Role = RoleCoordinator
+		status.Shards = h.opts.Proxy.status()
+		if len(status.Shards.Nodes) == 0 {
+			code, resp = http.StatusServiceUnavailable, StatusResponse{Message: "no storage nodes available", StatusCode: store.StatusShardUnavailable}
+		}
+	}
+
+	if h.opts.Node != nil {
+		raftStatus, err := h.opts.Node.status()
+		if err != nil {
+			h.log.Error().Err(err).Msg("failed to read raft configuration")
+		}
+		status.Role = raftStatus.State
+		status.Raft = raftStatus
+		if raftStatus.Leader == nil {
+			code, resp = http.StatusServiceUnavailable, StatusResponse{Message: ErrNoLeader.Error(), StatusCode: store.StatusNoLeader}
+		}
+	}
+
+	if h.opts.Membership != nil {
+		status.Members = h.opts.Membership.Members()
+		sort.Slice(status.Members, func(i, j int) bool { return status.Members[i].Name < status.Members[j].Name })
+	}
+
+	resp.Data = &status
+	writeJSON(h.log, w, code, resp)
+}
+
+// status reports the Raft state of the node.
+func (n *Node) status() (*RaftStatus, error) {
+	term, _ := strconv.ParseUint(n.raft.Stats()["term"], 10, 64)
+	status := &RaftStatus{
+		NodeID:       n.cfg.NodeID,
+		State:        raftRole(n.raft.State()),
+		Term:         term,
+		Peers:        []PeerStatus{},
+		CommitIndex:  n.raft.CommitIndex(),
+		AppliedIndex: n.raft.AppliedIndex(),
+	}
+	if leader, err := n.Leader(); err == nil {
+		status.Leader = &leader
+	}
+	if lag, ok := n.ReplicationLag(); ok {
+		ms := lag.Milliseconds()
+		status.ReplicationLagMs = &ms
+	}
+
+	future := n.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return status, err
+	}
+	for _, server := range future.Configuration().Servers {
+		info, ok := n.fsm.node(string(server.ID))
+		if !ok {
+			info = NodeInfo{ID: string(server.ID), RaftAddress: string(server.Address)}
+		}
+		status.Peers = append(status.Peers, PeerStatus{NodeInfo: info, Voter: server.Suffrage == raft.Voter})
+	}
+	return status, nil
+}
+
+func raftRole(state raft.RaftState) string {
+	switch state {
+	case raft.Leader:
+		return "leader"
+	case raft.Candidate:
+		return "candidate"
+	case raft.Follower:
+		return "follower"
+	default:
+		return "shutdown"
+	}
+}
+
+// status reports the ring of the proxy.
+func (p *Proxy) status() *ShardStatus {
+	shares := p.ring.Shares()
+	status := &ShardStatus{ReplicationFactor: p.cfg.ReplicationFactor, Nodes: []ShardNode{}}
+	for _, node := range p.ring.Nodes() {
+		shard := ShardNode{Address: node, Share: shares[node]}
+		if p.hints != nil {
+			shard.PendingHints = p.hints.pending(node)
+		}
+		status.Nodes = append(status.Nodes, shard)
+	}
+	return status
+}
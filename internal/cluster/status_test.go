@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func getStatus(t *testing.T, opts StatusOpts) (int, StatusResponse) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	NewStatusHandler(zerolog.Nop(), opts).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/cluster", nil))
+
+	var resp StatusResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.NotNil(t, resp.Data)
+	return w.Code, resp
+}
+
+func TestStatusHandler(t *testing.T) {
+	t.Run("standalone", func(t *testing.T) {
+		code, resp := getStatus(t, StatusOpts{})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "standalone", resp.Data.Role)
+		assert.Nil(t, resp.Data.Raft)
+		assert.Nil(t, resp.Data.Shards)
+	})
+
+	t.Run("raft", func(t *testing.T) {
+		nodes := newTestCluster(t, 3)
+
+		code, resp := getStatus(t, StatusOpts{Node: nodes[0]})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, "leader", resp.Data.Role)
+		require.NotNil(t, resp.Data.Raft)
+		assert.Equal(t, "node-0", resp.Data.Raft.NodeID)
+		assert.NotZero(t, resp.Data.Raft.Term)
+		require.NotNil(t, resp.Data.Raft.Leader)
+		assert.Equal(t, "node-0.http", resp.Data.Raft.Leader.HTTPAddress)
+		require.Len(t, resp.Data.Raft.Peers, 3)
+		assert.True(t, resp.Data.Raft.Peers[1].Voter)
+		assert.Equal(t, "node-1.http", resp.Data.Raft.Peers[1].HTTPAddress)
+		require.NotNil(t, resp.Data.Raft.ReplicationLagMs)
+		assert.Zero(t, *resp.Data.Raft.ReplicationLagMs)
+
+		require.Eventually(t, func() bool {
+			_, resp := getStatus(t, StatusOpts{Node: nodes[1]})
+			return resp.Data.Role == "follower" && resp.Data.Raft.ReplicationLagMs != nil && resp.Data.Raft.Leader != nil
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("sharding", func(t *testing.T) {
+		proxy, err := NewProxy(zerolog.Nop(), ShardConfig{Nodes: []string{"a:1", "b:1"}, ReplicationFactor: 2})
+		require.NoError(t, err)
+
+		code, resp := getStatus(t, StatusOpts{Proxy: proxy})
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, RoleCoordinator, resp.Data.Role)
+		require.NotNil(t, resp.Data.Shards)
+		assert.Equal(t, 2, resp.Data.Shards.ReplicationFactor)
+		require.Len(t, resp.Data.Shards.Nodes, 2)
+		assert.Equal(t, "a:1", resp.Data.Shards.Nodes[0].Address)
+		assert.InDelta(t, 1, resp.Data.Shards.Nodes[0].Share+resp.Data.Shards.Nodes[1].Share, 1e-9)
+	})
+
+	t.Run("no storage nodes", func(t *testing.T) {
+		proxy, err := NewProxy(zerolog.Nop(), ShardConfig{})
+		require.NoError(t, err)
+
+		code, resp := getStatus(t, StatusOpts{Proxy: proxy})
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, store.StatusShardUnavailable, resp.StatusCode)
+	})
+}
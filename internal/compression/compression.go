@@ -0,0 +1,221 @@
+// Package compression compresses HTTP responses with the encodings the
+// client accepts.
+//
+// The Middleware negotiates zstd or gzip through the Accept-Encoding header
+// of requests, preferring zstd, and compresses the responses of at least
+// the minimum size, such as key listings and large values. Smaller
+// responses are sent as is: the few bytes saved aren't worth the CPU.
+// Responses are buffered until they reach the minimum size, or until the
+// handler flushes them, so streaming responses are compressed as they are
+// written.
+package compression
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Config holds the settings of response compression.
+type Config struct {
+	// Enabled compresses the responses of clients accepting it.
+	Enabled bool `envconfig:"ENABLED"`
+	// MinSize is the size of the smallest response compressed, in bytes.
+	MinSize int `envconfig:"MIN_SIZE" default:"1024"`
+}
+
+// Encodings supported, in order of preference.
+const (
+	Zstd = "zstd"
+	Gzip = "gzip"
+)
+
+// encoder is implemented by the gzip and zstd writers.
+type encoder interface {
+	io.Writer
+	Flush() error
+	Close() error
+	Reset(w io.Writer)
+}
+
+var encoders = map[string]*sync.Pool{
+	Zstd: {New: func() any {
+		// Responses are small, a single goroutine per encoder spares the
+		// allocations of concurrent encoding.
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		return enc
+	}},
+	Gzip: {New: func() any {
+		enc, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return enc
+	}},
+}
+
+// Middleware compresses the responses of next when cfg enables it.
+// WebSocket upgrades, range requests and responses already encoded by
+// next are left alone.
+func Middleware(cfg Config, next http.Handler) http.Handler {
+	if !cfg.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" ||
+			strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &writer{ResponseWriter: w, encoding: encoding, minSize: cfg.MinSize}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Negotiate returns the preferred encoding accepted by the Accept-Encoding
+// header, empty when the client accepts none.
+func Negotiate(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if name == "*" {
+			name = Gzip
+		}
+		if name != Zstd && name != Gzip || q <= 0 {
+			continue
+		}
+		// zstd wins the ties.
+		if q > bestQ || q == bestQ && name == Zstd {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// writer buffers the start of a response until it knows whether to
+// compress it.
+type writer struct {
+	http.ResponseWriter
+	encoding string
+	minSize  int
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     encoder
+}
+
+func (w *writer) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		w.start(false)
+	}
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush compresses the buffered response and sends it, for streaming
+// handlers.
+func (w *writer) Flush() {
+	if !w.decided {
+		_ = w.start(len(w.buf) > 0)
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start sends the headers and the buffered response, compressed when
+// compress is set and the handler didn't encode it itself.
+func (w *writer) start(compress bool) error {
+	w.decided = true
+	h := w.Header()
+	if compress && h.Get("Content-Encoding") == "" {
+		// The server would sniff the type of the compressed bytes.
+		if _, ok := h["Content-Type"]; !ok {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		w.enc = encoders[w.encoding].Get().(encoder)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close sends the rest of the response once the handler returned.
+func (w *writer) close() {
+	if !w.decided {
+		// The server answers 200 to handlers writing nothing.
+		if len(w.buf) == 0 && w.status == 0 {
+			return
+		}
+		_ = w.start(false)
+	}
+	if w.enc != nil {
+		_ = w.enc.Close()
+		w.enc.Reset(nil)
+		encoders[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
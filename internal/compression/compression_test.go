@@ -0,0 +1,119 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiate(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                            "",
+		"identity":                    "",
+		"gzip":                        Gzip,
+		"gzip, deflate, br, zstd":     Zstd,
+		"zstd;q=0.5, gzip":            Gzip,
+		"zstd;q=0, gzip;q=0.1":        Gzip,
+		"GZIP;q=0.8, br":              Gzip,
+		"*":                           Gzip,
+		"gzip;q=invalid, zstd;q=0.01": Zstd,
+	} {
+		assert.Equal(t, want, Negotiate(header), header)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"key":"user:1","value":"alice"},`, 100)
+	serve := func(cfg Config, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rec := httptest.NewRecorder()
+		Middleware(cfg, handler).ServeHTTP(rec, req)
+		return rec
+	}
+	write := func(s string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", "1")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = io.WriteString(w, s)
+		}
+	}
+	cfg := Config{Enabled: true, MinSize: 1024}
+
+	t.Run("gzip", func(t *testing.T) {
+		rec := serve(cfg, "gzip", write(body))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, Gzip, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+		assert.Empty(t, rec.Header().Get("Content-Length"))
+		assert.Less(t, rec.Body.Len(), len(body))
+
+		r, err := gzip.NewReader(rec.Body)
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(b))
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			rec := serve(cfg, "gzip, zstd", write(body))
+			assert.Equal(t, Zstd, rec.Header().Get("Content-Encoding"))
+			r, err := zstd.NewReader(rec.Body)
+			require.NoError(t, err)
+			b, err := io.ReadAll(r)
+			r.Close()
+			require.NoError(t, err)
+			assert.Equal(t, body, string(b), "pooled encoders are reset")
+		}
+	})
+
+	t.Run("below minimum size", func(t *testing.T) {
+		rec := serve(cfg, "gzip", write(`{"status_code":1000}`))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, `{"status_code":1000}`, rec.Body.String())
+	})
+
+	t.Run("not accepted", func(t *testing.T) {
+		rec := serve(cfg, "br", write(body))
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		rec := serve(Config{}, "gzip", write(body))
+		assert.Empty(t, rec.Header().Get("Content-Encoding"))
+		assert.Empty(t, rec.Header().Get("Vary"))
+	})
+
+	t.Run("streaming", func(t *testing.T) {
+		rec := serve(cfg, "gzip", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "first")
+			require.NoError(t, http.NewResponseController(w).Flush())
+			assert.True(t, rec(w).Flushed)
+			_, _ = io.WriteString(w, "second")
+		})
+		assert.Equal(t, Gzip, rec.Header().Get("Content-Encoding"))
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		r, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "firstsecond", string(b))
+	})
+}
+
+// rec returns the recorder under the compressing writer.
+func rec(w http.ResponseWriter) *httptest.ResponseRecorder {
+	return w.(*writer).ResponseWriter.(*httptest.ResponseRecorder)
+}
@@ -6,30 +6,169 @@
 // are automatically loaded from a .env file using the godotenv package.
 //
 // The LoadFromEnv function is used to load these configurations from
-// the operating system's environment variables.
+// the operating system's environment variables, and Reload to load them
+// again with the changes of the .env file.
 package config
 
 import (
+	"errors"
+	"io/fs"
+	"os"
 	"time"
 
+	"github.com/joho/godotenv"
 	_ "github.com/joho/godotenv/autoload" // Autoload env vars from a .env file.
 	"github.com/kelseyhightower/envconfig"
 
+	"codesignal/internal/admin"
+	"codesignal/internal/auth"
+	"codesignal/internal/backup"
+	"codesignal/internal/binproto"
+	"codesignal/internal/cdc"
+	"codesignal/internal/cluster"
+	"codesignal/internal/compression"
+	"codesignal/internal/grpcserver"
+	"codesignal/internal/hotkeys"
+	"codesignal/internal/ipfilter"
+	"codesignal/internal/loadshed"
+	"codesignal/internal/memcached"
+	"codesignal/internal/memlimit"
+	"codesignal/internal/mirror"
+	"codesignal/internal/replica"
+	"codesignal/internal/repository"
+	"codesignal/internal/resp"
 	"codesignal/internal/server"
+	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+	"codesignal/internal/webhook"
 )
 
 // Config contains all the config
 // parameters that this service uses.
 type Config struct {
 	Server server.Config `envconfig:"SERVER"`
+	// Auth configures the optional authentication of the HTTP API.
+	Auth auth.Config `envconfig:"AUTH"`
+	// IPFilter configures the optional client address lists of the HTTP
+	// API.
+	IPFilter ipfilter.Config `envconfig:"IP_FILTER"`
+	// LoadShed configures the optional concurrency limits of the HTTP API.
+	LoadShed loadshed.Config `envconfig:"LOAD_SHED"`
+	// Memory configures the optional soft memory limit rejecting writes.
+	Memory memlimit.Config `envconfig:"MEMORY"`
+	// Compression configures the optional compression of the HTTP
+	// responses.
+	Compression compression.Config `envconfig:"COMPRESSION"`
+	// GRPC configures the optional gRPC API.
+	GRPC grpcserver.Config `envconfig:"GRPC"`
+	// RESP configures the optional Redis protocol listener.
+	RESP resp.Config `envconfig:"RESP"`
+	// Memcached configures the optional memcached protocol listener.
+	Memcached memcached.Config `envconfig:"MEMCACHED"`
+	// Binary configures the optional binary protocol listener.
+	Binary binproto.Config `envconfig:"BINARY"`
+	// Admin configures the optional admin listener serving pprof.
+	Admin admin.Config `envconfig:"ADMIN"`
+	// Raft configures the optional Raft clustered mode.
+	Raft cluster.RaftConfig `envconfig:"RAFT"`
+	// Shard configures the optional sharding coordinator mode.
+	Shard cluster.ShardConfig `envconfig:"SHARD"`
+	// Gossip configures optional gossip based node discovery.
+	Gossip cluster.GossipConfig `envconfig:"GOSSIP"`
+	// Tenant configures the optional stores isolating the keys of
+	// tenants.
+	Tenant tenant.Config `envconfig:"TENANT"`
+	// Replica configures the optional read-only replica mode, serving the
+	// snapshots of another node.
+	Replica replica.Config `envconfig:"REPLICA"`
 	// MaxKeyLength is the maximum length of a key in characters.
 	MaxKeyLength int `envconfig:"MAX_KEY_LENGTH"`
 	// MaxValueSize is the maximum size of a value in bytes.
 	MaxValueSize int `envconfig:"MAX_VALUE_SIZE"`
+	// AllowEmptyKeys accepts creating the empty key, rejected by default.
+	AllowEmptyKeys bool `envconfig:"ALLOW_EMPTY_KEYS"`
+	// StrictContentType rejects the JSON request bodies sent with another
+	// content type, accepted by lenient clients when disabled.
+	StrictContentType bool `envconfig:"STRICT_CONTENT_TYPE" default:"true"`
+	// KeyPattern is the regular expression written keys must match as a
+	// whole. Empty rejects the keys holding control characters or
+	// whitespace.
+	KeyPattern string `envconfig:"KEY_PATTERN"`
+	// KeyNormalization are the forms keys are normalized to before they are
+	// stored or looked up, lowercase and nfc, such as "lowercase,nfc".
+	KeyNormalization []string `envconfig:"KEY_NORMALIZATION"`
 	// SyncInterval is the interval to sync data to disk.
-	SyncInterval time.Duration `envconfig:"SYNC_INTERVAL" default:"1m"`
+	//
+	// Deprecated: use LSM.SyncInterval, set by SyncInterval unless
+	// LSM_SYNC_INTERVAL is.
+	SyncInterval time.Duration `envconfig:"SYNC_INTERVAL"`
 	// DataFile is the path to the data file.
+	//
+	// Deprecated: use LSM.Dir, set by DataFile unless LSM_DIR is.
 	DataFile string `envconfig:"DATA_FILE"`
+	// SeedFile is the path to a JSON object or snapshot of the keys loaded
+	// into the store at startup.
+	SeedFile string `envconfig:"SEED_FILE"`
+	// MappedFile is the path to a table file whose values are served from
+	// a memory mapping rather than loaded in memory at startup.
+	MappedFile string `envconfig:"MAPPED_FILE"`
+	// ReapInterval is the minimum time between two removals of expired keys
+	// in the background.
+	ReapInterval time.Duration `envconfig:"REAP_INTERVAL" default:"1s"`
+	// ReapBatchSize is the maximum number of expired keys removed per batch.
+	ReapBatchSize int `envconfig:"REAP_BATCH_SIZE" default:"1000"`
+	// TombstoneRetention is how long deleted keys can be restored.
+	// Zero removes keys immediately on delete.
+	TombstoneRetention time.Duration `envconfig:"TOMBSTONE_RETENTION" default:"0"`
+	// LockStripes is the number of per-key lock stripes.
+	LockStripes int `envconfig:"LOCK_STRIPES" default:"256"`
+	// ExpectedKeys is the number of keys the store is sized for at
+	// startup.
+	ExpectedKeys int `envconfig:"EXPECTED_KEYS"`
+	// ResponseCacheSize is the number of responses of GetKey cached for
+	// the hot keys, zero disabling the cache.
+	ResponseCacheSize int `envconfig:"RESPONSE_CACHE_SIZE" default:"1024"`
+	// Tier configures the spilling of the least recently used values to
+	// disk.
+	Tier repository.TierConfig `envconfig:"TIER"`
+	// LSM configures the persistence of the keys to the log-structured
+	// storage engine.
+	LSM repository.LSMConfig `envconfig:"LSM"`
+	// Compaction defers the compactions of the store to windows of low
+	// traffic.
+	Compaction repository.CompactionConfig `envconfig:"COMPACTION"`
+	// HotCache serves the keys read the most from a lock-free cache.
+	HotCache repository.HotCacheConfig `envconfig:"HOT_CACHE"`
+	// HistoryVersions is the number of versions of each key retained for
+	// reads of its past values, zero disabling the history.
+	HistoryVersions int `envconfig:"HISTORY_VERSIONS"`
+	// Faults injects errors and latency into the operations of the store,
+	// for resilience testing.
+	Faults repository.FaultConfig `envconfig:"FAULTS"`
+	// HotKeys configures the sampling of key accesses for /admin/hotkeys.
+	HotKeys hotkeys.Config `envconfig:"HOTKEYS"`
+	// Backup configures the scheduled backups of the store.
+	Backup backup.Config `envconfig:"BACKUP"`
+	// Webhook configures the delivery of the changes of keys to webhooks.
+	Webhook webhook.Config `envconfig:"WEBHOOK"`
+	// Kafka configures the publishing of the changes of keys to Kafka.
+	Kafka cdc.KafkaConfig `envconfig:"KAFKA"`
+	// NATS configures the publishing of the changes of keys to NATS.
+	NATS cdc.NATSConfig `envconfig:"NATS"`
+	// ChangeLog configures the durable change log consumers resume from.
+	ChangeLog cdc.ChangeLogConfig `envconfig:"CHANGELOG"`
+	// Mirror configures the mirroring of the keys to an external system.
+	Mirror mirror.Config `envconfig:"MIRROR"`
+	// SlowRequestThreshold logs HTTP requests taking longer at warn level,
+	// zero disables it.
+	SlowRequestThreshold time.Duration `envconfig:"SLOW_REQUEST_THRESHOLD" default:"1s"`
+	// DocsUI serves a Swagger UI page browsing the OpenAPI document at /docs.
+	DocsUI bool `envconfig:"DOCS_UI"`
+	// ReadOnly starts the store in maintenance, rejecting writes until
+	// disabled at /admin/maintenance.
+	ReadOnly bool `envconfig:"READ_ONLY"`
+	// LogLevel is the minimum level of the logs, such as "info".
+	LogLevel string `envconfig:"LOG_LEVEL" default:"debug"`
 }
 
 func (c *Config) GetMaxKeyLength() int {
@@ -48,9 +187,80 @@ func (c *Config) GetMaxValueSize() int {
 	return c.MaxValueSize
 }
 
+// StoreOpts returns the store service options derived from the config,
+// which must be valid.
+func (c *Config) StoreOpts() store.Opts {
+	opts := store.Opts{
+		MaxKeyLength:      c.GetMaxKeyLength(),
+		MaxValueSize:      c.GetMaxValueSize(),
+		AllowEmptyKeys:    c.AllowEmptyKeys,
+		ResponseCacheSize: c.ResponseCacheSize,
+	}
+	if c.KeyPattern != "" {
+		pattern, err := store.CompileKeyPattern(c.KeyPattern)
+		if err != nil {
+			panic(err)
+		}
+		opts.KeyPattern = pattern
+	}
+	normalizer, err := store.ParseKeyNormalizer(c.KeyNormalization)
+	if err != nil {
+		panic(err)
+	}
+	opts.KeyNormalizer = normalizer
+	return opts
+}
+
+// RepositoryOpts returns the repository options derived from the config.
+func (c *Config) RepositoryOpts() repository.Opts {
+	if c == nil {
+		return repository.Opts{}
+	}
+
+	return repository.Opts{
+		ReapInterval:       c.ReapInterval,
+		ReapBatchSize:      c.ReapBatchSize,
+		TombstoneRetention: c.TombstoneRetention,
+		LockStripes:        c.LockStripes,
+		ExpectedKeys:       c.ExpectedKeys,
+		Tier:               c.Tier,
+		LSM:                c.LSM,
+		MappedFile:         c.MappedFile,
+		Compaction:         c.Compaction,
+		HotCache:           c.HotCache,
+		History:            c.HistoryVersions,
+		Faults:             c.Faults,
+	}
+}
+
 // LoadFromEnv will load the env vars from the OS.
 func LoadFromEnv() (*Config, error) {
 	cfg := &Config{}
-	err := envconfig.Process("", cfg)
-	return cfg, err
+	if err := envconfig.Process("", cfg); err != nil {
+		return cfg, err
+	}
+	cfg.applyDeprecated()
+	return cfg, nil
+}
+
+// applyDeprecated maps the deprecated DATA_FILE and SYNC_INTERVAL onto the
+// storage engine, which persists the keys in their place, unless LSM_DIR
+// and LSM_SYNC_INTERVAL are set.
+func (c *Config) applyDeprecated() {
+	if c.LSM.Dir == "" {
+		c.LSM.Dir = c.DataFile
+	}
+	if _, ok := os.LookupEnv("LSM_SYNC_INTERVAL"); !ok && c.SyncInterval > 0 {
+		c.LSM.SyncInterval = c.SyncInterval
+	}
+}
+
+// Reload reads the .env file again, its variables overriding those of the
+// environment, and loads the config. A missing .env file leaves the
+// environment unchanged.
+func Reload() (*Config, error) {
+	if err := godotenv.Overload(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return LoadFromEnv()
 }
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadFromEnvDeprecated(t *testing.T) {
+	t.Setenv("DATA_FILE", "data/node1")
+	t.Setenv("SYNC_INTERVAL", "1m")
+	cfg, err := LoadFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "data/node1", cfg.LSM.Dir)
+	assert.Equal(t, time.Minute, cfg.LSM.SyncInterval)
+
+	// The settings replacing them win.
+	t.Setenv("LSM_DIR", "data/lsm")
+	t.Setenv("LSM_SYNC_INTERVAL", "0s")
+	cfg, err = LoadFromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "data/lsm", cfg.LSM.Dir)
+	assert.Zero(t, cfg.LSM.SyncInterval)
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/backup"
+	"codesignal/internal/cdc"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// ValidationError lists the problems of a config.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks the values of the config and the constraints between
+// them, such as a TLS certificate requiring its key, reporting every
+// problem at once in a *ValidationError rather than failing at first use.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	nonNegative := func(name string, d time.Duration) {
+		check(d >= 0, "%s must not be negative, got %s", name, d)
+	}
+
+	check(strings.TrimSpace(c.Server.Address) != "", "SERVER_ADDRESS is required")
+	if _, err := strconv.ParseUint(c.Server.UnixSocketMode, 8, 32); err != nil {
+		problems = append(problems, fmt.Sprintf("SERVER_UNIX_SOCKET_MODE must be an octal file mode, got %q", c.Server.UnixSocketMode))
+	}
+	nonNegative("SERVER_READ_TIMEOUT", c.Server.ReadTimeout)
+	nonNegative("SERVER_WRITE_TIMEOUT", c.Server.WriteTimeout)
+	nonNegative("SERVER_READ_HEADER_TIMEOUT", c.Server.ReadHeaderTimeout)
+	nonNegative("SERVER_IDLE_TIMEOUT", c.Server.IdleTimeout)
+	check(c.Server.MaxHeaderBytes >= 0, "SERVER_MAX_HEADER_BYTES must not be negative, got %d", c.Server.MaxHeaderBytes)
+	check(c.Server.ShutdownTimeout > 0, "SERVER_SHUTDOWN_TIMEOUT must be positive, got %s", c.Server.ShutdownTimeout)
+
+	tls := c.Server.TLS
+	check(tls.CertFile == "" || tls.KeyFile != "", "SERVER_TLS_KEY_FILE is required with SERVER_TLS_CERT_FILE")
+	check(tls.KeyFile == "" || tls.CertFile != "", "SERVER_TLS_CERT_FILE is required with SERVER_TLS_KEY_FILE")
+	check(len(tls.AutoDomains) == 0 || tls.CertFile == "" && tls.KeyFile == "",
+		"SERVER_TLS_AUTO_DOMAINS and SERVER_TLS_CERT_FILE are mutually exclusive")
+	check(c.Server.HTTP3Address == "" || tls.CertFile != "" || len(tls.AutoDomains) > 0,
+		"SERVER_HTTP3_ADDRESS requires TLS, set SERVER_TLS_CERT_FILE or SERVER_TLS_AUTO_DOMAINS")
+
+	check(c.MaxKeyLength >= 0, "MAX_KEY_LENGTH must not be negative, got %d", c.MaxKeyLength)
+	check(c.MaxValueSize >= 0, "MAX_VALUE_SIZE must not be negative, got %d", c.MaxValueSize)
+	check(c.ReapBatchSize >= 0, "REAP_BATCH_SIZE must not be negative, got %d", c.ReapBatchSize)
+	check(c.LockStripes >= 0, "LOCK_STRIPES must not be negative, got %d", c.LockStripes)
+	check(c.ExpectedKeys >= 0, "EXPECTED_KEYS must not be negative, got %d", c.ExpectedKeys)
+	check(c.Tier.MaxMemory >= 0, "TIER_MAX_MEMORY must not be negative, got %d", c.Tier.MaxMemory)
+	check(c.LSM.MemtableSize >= 0, "LSM_MEMTABLE_SIZE must not be negative, got %d", c.LSM.MemtableSize)
+	check(c.LSM.MaxTables >= 0, "LSM_MAX_TABLES must not be negative, got %d", c.LSM.MaxTables)
+	nonNegative("LSM_SYNC_INTERVAL", c.LSM.SyncInterval)
+	check(c.LSM.Dir == "" || c.Tier.Dir == "", "TIER_DIR is not used with LSM_DIR, cold values are read from the storage engine")
+	check(c.LSM.Dir == "" || c.MappedFile == "", "MAPPED_FILE and LSM_DIR are mutually exclusive")
+	if _, err := repository.ParseWindows(c.Compaction.Windows); err != nil {
+		problems = append(problems, "COMPACTION_WINDOWS: "+err.Error())
+	}
+	check(c.Compaction.MaxQPS >= 0, "COMPACTION_MAX_QPS must not be negative, got %g", c.Compaction.MaxQPS)
+	nonNegative("COMPACTION_INTERVAL", c.Compaction.Interval)
+	nonNegative("HOT_CACHE_TTL", c.HotCache.TTL)
+	check(c.HotCache.Threshold >= 0, "HOT_CACHE_THRESHOLD must not be negative, got %d", c.HotCache.Threshold)
+	check(c.HotCache.Size >= 0, "HOT_CACHE_SIZE must not be negative, got %d", c.HotCache.Size)
+	check(c.HistoryVersions >= 0, "HISTORY_VERSIONS must not be negative, got %d", c.HistoryVersions)
+	if err := c.Faults.Validate(); err != nil {
+		problems = append(problems, "FAULTS: "+err.Error())
+	}
+	if c.KeyPattern != "" {
+		if _, err := store.CompileKeyPattern(c.KeyPattern); err != nil {
+			problems = append(problems, fmt.Sprintf("KEY_PATTERN %q is not a valid regular expression: %v", c.KeyPattern, errors.Unwrap(err)))
+		}
+	}
+	if _, err := store.ParseKeyNormalizer(c.KeyNormalization); err != nil {
+		problems = append(problems, "KEY_NORMALIZATION: "+err.Error())
+	}
+	nonNegative("REAP_INTERVAL", c.ReapInterval)
+	nonNegative("TOMBSTONE_RETENTION", c.TombstoneRetention)
+	nonNegative("SLOW_REQUEST_THRESHOLD", c.SlowRequestThreshold)
+	if _, err := zerolog.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL must be trace, debug, info, warn or error, got %q", c.LogLevel))
+	}
+
+	check(c.LoadShed.MaxInFlight >= 0, "LOAD_SHED_MAX_IN_FLIGHT must not be negative, got %d", c.LoadShed.MaxInFlight)
+	for route, limit := range c.LoadShed.Routes {
+		check(limit > 0, "LOAD_SHED_ROUTES: limit of %s must be positive, got %d", route, limit)
+	}
+	nonNegative("LOAD_SHED_RETRY_AFTER", c.LoadShed.RetryAfter)
+	check(c.Memory.SoftLimit >= 0, "MEMORY_SOFT_LIMIT must not be negative, got %d", c.Memory.SoftLimit)
+	nonNegative("MEMORY_CHECK_INTERVAL", c.Memory.CheckInterval)
+	nonNegative("MEMORY_RETRY_AFTER", c.Memory.RetryAfter)
+	check(c.Compression.MinSize >= 0, "COMPRESSION_MIN_SIZE must not be negative, got %d", c.Compression.MinSize)
+
+	if c.Auth.Enabled() {
+		// The protocol listeners don't authenticate their clients, who
+		// would bypass the scopes and owners of keys.
+		check(!c.GRPC.Enabled, "GRPC_ENABLED is not supported with authentication, gRPC clients aren't authenticated")
+		check(!c.RESP.Enabled, "RESP_ENABLED is not supported with authentication, Redis clients aren't authenticated")
+		check(!c.Memcached.Enabled, "MEMCACHED_ENABLED is not supported with authentication, memcached clients aren't authenticated")
+		check(!c.Binary.Enabled, "BINARY_ENABLED is not supported with authentication, binary protocol clients aren't authenticated")
+	}
+	if c.IPFilter.Enabled() {
+		// Only the clients of the HTTP API are filtered, the others would
+		// bypass the address lists.
+		check(!c.GRPC.Enabled, "GRPC_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, gRPC clients aren't filtered")
+		check(!c.RESP.Enabled, "RESP_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, Redis clients aren't filtered")
+		check(!c.Memcached.Enabled, "MEMCACHED_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, memcached clients aren't filtered")
+		check(!c.Binary.Enabled, "BINARY_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, binary protocol clients aren't filtered")
+	}
+	if c.Raft.Enabled {
+		check(c.Raft.NodeID != "", "RAFT_NODE_ID is required with RAFT_ENABLED")
+		check(c.Raft.ApplyTimeout > 0, "RAFT_APPLY_TIMEOUT must be positive, got %s", c.Raft.ApplyTimeout)
+		// The state of a Raft node is its log, seeding it would make the
+		// nodes diverge.
+		check(c.SeedFile == "", "SEED_FILE is not supported with RAFT_ENABLED, seed the cluster through the API")
+		// Replaying the log onto the persisted keys would apply its
+		// commands twice.
+		check(c.LSM.Dir == "", "LSM_DIR is not supported with RAFT_ENABLED, the Raft log and snapshots persist the keys")
+		check(c.MappedFile == "", "MAPPED_FILE is not supported with RAFT_ENABLED, the state of the nodes comes from the Raft log")
+		// Faults fail the commands on some nodes only.
+		check(len(c.Faults.ErrorRates) == 0, "FAULTS_ERROR_RATES is not supported with RAFT_ENABLED, the nodes would diverge")
+	}
+	if c.Shard.Enabled {
+		check(len(c.Shard.Nodes) > 0 || c.Gossip.Enabled, "SHARD_ENABLED requires SHARD_NODES or GOSSIP_ENABLED")
+	}
+	if t := c.Tenant; t.Enabled {
+		if err := t.Validate(); err != nil {
+			problems = append(problems, "TENANT: "+err.Error())
+		}
+		// The stores of tenants are local to the node.
+		check(!c.Raft.Enabled, "TENANT_ENABLED is not supported with RAFT_ENABLED, the stores of tenants aren't replicated")
+		check(!c.Shard.Enabled, "TENANT_ENABLED is not supported with SHARD_ENABLED, the stores of tenants aren't sharded")
+		// Request bodies are limited before their tenant is known.
+		for name, size := range t.MaxValueSizes {
+			check(size <= c.GetMaxValueSize(), "TENANT_MAX_VALUE_SIZES: max value size of %s must not exceed MAX_VALUE_SIZE, got %d", name, size)
+		}
+	}
+	if r := c.Replica; r.Source != "" {
+		check(r.RefreshInterval > 0, "REPLICA_REFRESH_INTERVAL must be positive, got %s", r.RefreshInterval)
+		// The keys of a replica are those of the last snapshot loaded.
+		check(!c.Raft.Enabled, "REPLICA_SOURCE is not supported with RAFT_ENABLED, the nodes replicate the Raft log")
+		check(!c.Shard.Enabled, "REPLICA_SOURCE is not supported with SHARD_ENABLED, a coordinator stores no keys")
+		check(!c.Tenant.Enabled, "REPLICA_SOURCE is not supported with TENANT_ENABLED, snapshots hold the keys of the default store")
+		check(c.SeedFile == "", "SEED_FILE is not supported with REPLICA_SOURCE, the keys are loaded from the snapshots")
+		check(c.MappedFile == "", "MAPPED_FILE is not supported with REPLICA_SOURCE, the keys are loaded from the snapshots")
+	}
+
+	b := c.Backup
+	if b.Schedule != "" {
+		if _, err := backup.ParseSchedule(b.Schedule); err != nil {
+			problems = append(problems, "BACKUP_SCHEDULE: "+err.Error())
+		}
+		check(b.Dir != "" || b.S3.Bucket != "", "BACKUP_SCHEDULE requires BACKUP_DIR or BACKUP_S3_BUCKET")
+	}
+	check(b.Dir == "" || b.S3.Bucket == "", "BACKUP_DIR and BACKUP_S3_BUCKET are mutually exclusive")
+	check(b.Retention >= 0, "BACKUP_RETENTION must not be negative, got %d", b.Retention)
+	nonNegative("BACKUP_MAX_AGE", b.MaxAge)
+
+	if wh := c.Webhook; wh.Enabled {
+		check(wh.Timeout > 0, "WEBHOOK_TIMEOUT must be positive, got %s", wh.Timeout)
+		check(wh.MaxAttempts > 0, "WEBHOOK_MAX_ATTEMPTS must be positive, got %d", wh.MaxAttempts)
+		check(wh.Backoff > 0, "WEBHOOK_BACKOFF must be positive, got %s", wh.Backoff)
+		check(wh.MaxBackoff >= wh.Backoff, "WEBHOOK_MAX_BACKOFF must be at least WEBHOOK_BACKOFF, got %s", wh.MaxBackoff)
+		check(wh.QueueSize > 0, "WEBHOOK_QUEUE_SIZE must be positive, got %d", wh.QueueSize)
+	}
+	if k := c.Kafka; len(k.Brokers) > 0 {
+		check(k.Topic != "", "KAFKA_TOPIC is required with KAFKA_BROKERS")
+		check(k.Acks == "none" || k.Acks == "one" || k.Acks == "all", "KAFKA_ACKS must be none, one or all, got %q", k.Acks)
+		nonNegative("KAFKA_BATCH_TIMEOUT", k.BatchTimeout)
+		check(k.WriteTimeout > 0, "KAFKA_WRITE_TIMEOUT must be positive, got %s", k.WriteTimeout)
+	}
+	if n := c.NATS; n.URL != "" {
+		check(cdc.ValidSubject(n.Subject), "NATS_SUBJECT must be dot separated tokens without wildcards, got %q", n.Subject)
+		check(n.NamespaceSeparator != "", "NATS_NAMESPACE_SEPARATOR is required with NATS_URL")
+		check(n.FlushTimeout > 0, "NATS_FLUSH_TIMEOUT must be positive, got %s", n.FlushTimeout)
+	}
+	if cl := c.ChangeLog; cl.Dir != "" {
+		nonNegative("CHANGELOG_RETENTION", cl.Retention)
+		nonNegative("CHANGELOG_COMPACT_AFTER", cl.CompactAfter)
+		check(cl.CompactInterval > 0, "CHANGELOG_COMPACT_INTERVAL must be positive, got %s", cl.CompactInterval)
+		check(cl.Buffer > 0, "CHANGELOG_BUFFER must be positive, got %d", cl.Buffer)
+	}
+	if m := c.Mirror; m.Target != "" {
+		check(m.Target == "http" || m.Target == "redis" || m.Target == "sql", "MIRROR_TARGET must be http, redis or sql, got %q", m.Target)
+		check(m.URL != "", "MIRROR_URL is required with MIRROR_TARGET")
+		check(c.ChangeLog.Dir != "", "MIRROR_TARGET requires CHANGELOG_DIR")
+		check(m.BatchSize > 0, "MIRROR_BATCH_SIZE must be positive, got %d", m.BatchSize)
+		check(m.Timeout > 0, "MIRROR_TIMEOUT must be positive, got %s", m.Timeout)
+		check(m.Backoff > 0, "MIRROR_BACKOFF must be positive, got %s", m.Backoff)
+		check(m.MaxBackoff >= m.Backoff, "MIRROR_MAX_BACKOFF must be at least MIRROR_BACKOFF, got %s", m.MaxBackoff)
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Setenv("SERVER_ADDRESS", "127.0.0.1:8081")
+	cfg, err := LoadFromEnv()
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate(), "the defaults are valid")
+
+	cfg.Server.TLS.CertFile = "cert.pem"
+	cfg.Server.ShutdownTimeout = 0
+	cfg.MaxValueSize = -1
+	cfg.KeyPattern = "[a-z"
+	cfg.KeyNormalization = []string{"lowercase", "upper"}
+	cfg.ExpectedKeys = -1
+	cfg.Tier.MaxMemory = -1
+	cfg.Tier.Dir = "cold"
+	cfg.LSM.Dir = "data"
+	cfg.LSM.MaxTables = -1
+	cfg.MappedFile = "store.table"
+	cfg.Compaction.Windows = []string{"01:00-05:00", "25:00-02:00"}
+	cfg.HotCache.Size = -1
+	cfg.Faults.ErrorRates = map[string]float64{"get": 2}
+	cfg.LogLevel = "verbose"
+	cfg.Memory.SoftLimit = -1
+	cfg.Auth.JWT.Secret = "secret"
+	cfg.GRPC.Enabled = true
+	cfg.Memcached.Enabled = true
+	cfg.IPFilter.Deny = []string{"10.0.0.0/8"}
+	cfg.Raft.Enabled = true
+	cfg.SeedFile = "seed.json"
+	cfg.Tenant.Enabled = true
+	cfg.Tenant.Names = []string{"Acme"}
+	cfg.Replica.Source = "backups"
+	cfg.Replica.RefreshInterval = 0
+	cfg.Backup.Schedule = "every day"
+	cfg.Backup.MaxAge = -time.Hour
+	cfg.Webhook.Enabled = true
+	cfg.Webhook.QueueSize = 0
+	cfg.Kafka.Brokers = []string{"localhost:9092"}
+	cfg.Kafka.Acks = "some"
+	cfg.NATS.URL = "nats://localhost:4222"
+	cfg.NATS.Subject = "kv.>"
+	cfg.ChangeLog.Dir = "changes"
+	cfg.ChangeLog.Retention = -time.Hour
+	cfg.Mirror.Target = "kafka"
+	cfg.Mirror.URL = "localhost:9092"
+
+	err = cfg.Validate()
+	var invalid *ValidationError
+	require.ErrorAs(t, err, &invalid)
+	assert.Equal(t, []string{
+		"SERVER_SHUTDOWN_TIMEOUT must be positive, got 0s",
+		"SERVER_TLS_KEY_FILE is required with SERVER_TLS_CERT_FILE",
+		"MAX_VALUE_SIZE must not be negative, got -1",
+		"EXPECTED_KEYS must not be negative, got -1",
+		"TIER_MAX_MEMORY must not be negative, got -1",
+		"LSM_MAX_TABLES must not be negative, got -1",
+		"TIER_DIR is not used with LSM_DIR, cold values are read from the storage engine",
+		"MAPPED_FILE and LSM_DIR are mutually exclusive",
+		`COMPACTION_WINDOWS: invalid compaction window "25:00-02:00": time "25:00" is not HH:MM`,
+		"HOT_CACHE_SIZE must not be negative, got -1",
+		"FAULTS: error rate of get must be between 0 and 1, got 2",
+		`KEY_PATTERN "[a-z" is not a valid regular expression: error parsing regexp: missing closing ]: ` + "`[a-z)$`",
+		`KEY_NORMALIZATION: unknown key normalization "upper", expected lowercase or nfc`,
+		`LOG_LEVEL must be trace, debug, info, warn or error, got "verbose"`,
+		"MEMORY_SOFT_LIMIT must not be negative, got -1",
+		"GRPC_ENABLED is not supported with authentication, gRPC clients aren't authenticated",
+		"MEMCACHED_ENABLED is not supported with authentication, memcached clients aren't authenticated",
+		"GRPC_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, gRPC clients aren't filtered",
+		"MEMCACHED_ENABLED is not supported with IP_FILTER_ALLOW or IP_FILTER_DENY, memcached clients aren't filtered",
+		"RAFT_NODE_ID is required with RAFT_ENABLED",
+		"SEED_FILE is not supported with RAFT_ENABLED, seed the cluster through the API",
+		"LSM_DIR is not supported with RAFT_ENABLED, the Raft log and snapshots persist the keys",
+		"MAPPED_FILE is not supported with RAFT_ENABLED, the state of the nodes comes from the Raft log",
+		"FAULTS_ERROR_RATES is not supported with RAFT_ENABLED, the nodes would diverge",
+		`TENANT: "Acme": invalid tenant, expected lowercase letters, digits and hyphens`,
+		"TENANT_ENABLED is not supported with RAFT_ENABLED, the stores of tenants aren't replicated",
+		"REPLICA_REFRESH_INTERVAL must be positive, got 0s",
+		"REPLICA_SOURCE is not supported with RAFT_ENABLED, the nodes replicate the Raft log",
+		"REPLICA_SOURCE is not supported with TENANT_ENABLED, snapshots hold the keys of the default store",
+		"SEED_FILE is not supported with REPLICA_SOURCE, the keys are loaded from the snapshots",
+		"MAPPED_FILE is not supported with REPLICA_SOURCE, the keys are loaded from the snapshots",
+		`BACKUP_SCHEDULE: invalid schedule "every day": expected 5 fields`,
+		"BACKUP_SCHEDULE requires BACKUP_DIR or BACKUP_S3_BUCKET",
+		"BACKUP_MAX_AGE must not be negative, got -1h0m0s",
+		"WEBHOOK_QUEUE_SIZE must be positive, got 0",
+		`KAFKA_ACKS must be none, one or all, got "some"`,
+		`NATS_SUBJECT must be dot separated tokens without wildcards, got "kv.>"`,
+		"CHANGELOG_RETENTION must not be negative, got -1h0m0s",
+		`MIRROR_TARGET must be http, redis or sql, got "kafka"`,
+	}, invalid.Problems)
+	assert.Contains(t, err.Error(), "invalid configuration: SERVER_SHUTDOWN_TIMEOUT must be positive, got 0s; ")
+}
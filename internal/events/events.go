@@ -0,0 +1,176 @@
+// Package events provides the change bus of the key-value store.
+//
+// The repository publishes an Event for every change it applies, and
+// streaming consumers such as watch endpoints subscribe to the changes of
+// a key prefix. Publishing never blocks: a subscriber that falls behind by
+// more than its buffer is disconnected rather than slowing writers down.
+package events
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBufferSize is the number of events buffered per subscriber when
+// none is configured.
+const DefaultBufferSize = 256
+
+// ErrSlowConsumer is reported by a subscription that was closed because
+// it could not keep up with the published events.
+var ErrSlowConsumer = errors.New("subscriber too slow, events dropped")
+
+// Type is the kind of change an event describes.
+type Type string
+
+const (
+	// TypeSet is published when a key is created or updated.
+	TypeSet Type = "set"
+	// TypeDelete is published when a key is deleted.
+	TypeDelete Type = "delete"
+	// TypeExpire is published when a key is removed because its TTL elapsed.
+	TypeExpire Type = "expire"
+	// TypeMessage is published on a pub/sub channel, carrying a message
+	// rather than a change of a key.
+	TypeMessage Type = "message"
+)
+
+// Event is a change applied to a key.
+type Event struct {
+	Type Type   `json:"type"`
+	Key  string `json:"key"`
+	// Value is the new value of the key for TypeSet events.
+	Value []byte `json:"value,omitempty"`
+	// Created reports whether a TypeSet event created the key rather than
+	// updating it.
+	Created bool      `json:"created,omitempty"`
+	Time    time.Time `json:"time"`
+	// Seq numbers the events published on the bus from 1, so consumers
+	// detect the events they missed. It restarts with the process.
+	Seq uint64 `json:"seq"`
+}
+
+// Bus fans published events out to subscribers. It is safe for concurrent
+// use, and a nil *Bus discards published events.
+type Bus struct {
+	buffer int
+	seq    atomic.Uint64
+
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// NewBus returns a bus buffering up to buffer events per subscriber.
+func NewBus(buffer int) *Bus {
+	if buffer <= 0 {
+		buffer = DefaultBufferSize
+	}
+	return &Bus{
+		buffer: buffer,
+		subs:   make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish numbers e and delivers it to every subscriber of a prefix of its
+// key. Events are numbered in the order Publish is called, so callers
+// publishing concurrently must serialize their calls.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	e.Seq = b.seq.Add(1)
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		if strings.HasPrefix(e.Key, sub.prefix) {
+			sub.send(e)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events of keys starting with
+// prefix. An empty prefix subscribes to every key. The subscription must
+// be closed when no longer needed.
+func (b *Bus) Subscribe(prefix string) *Subscription {
+	return b.SubscribeBuffer(prefix, b.buffer)
+}
+
+// SubscribeBuffer is Subscribe buffering up to buffer events rather than
+// the buffer of the bus, for consumers that must not be dropped by bursts
+// of changes.
+func (b *Bus) SubscribeBuffer(prefix string, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = b.buffer
+	}
+	sub := &Subscription{
+		bus:    b,
+		prefix: prefix,
+		ch:     make(chan Event, buffer),
+	}
+
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Subscription receives the events of a key prefix.
+type Subscription struct {
+	bus    *Bus
+	prefix string
+	ch     chan Event
+
+	mu     sync.Mutex
+	closed bool
+	err    error
+}
+
+// Events returns the channel events are delivered on. It is closed when
+// the subscription is closed or dropped for being too slow.
+func (s *Subscription) Events() <-chan Event {
+	return s.ch
+}
+
+// Err returns ErrSlowConsumer once the subscription was dropped for falling
+// behind, nil otherwise.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close unsubscribes from the bus and closes the events channel.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	delete(s.bus.subs, s)
+	s.bus.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// send delivers e without blocking, closing the subscription when its
+// buffer is full.
+func (s *Subscription) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- e:
+	default:
+		s.closed = true
+		s.err = ErrSlowConsumer
+		close(s.ch)
+	}
+}
@@ -0,0 +1,60 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	t.Run("delivers events by prefix", func(t *testing.T) {
+		bus := NewBus(4)
+		users := bus.Subscribe("user:")
+		defer users.Close()
+		all := bus.Subscribe("")
+		defer all.Close()
+
+		bus.Publish(Event{Type: TypeSet, Key: "user:1", Value: []byte("a")})
+		bus.Publish(Event{Type: TypeDelete, Key: "order:1"})
+
+		require.Len(t, users.Events(), 1)
+		assert.Equal(t, Event{Type: TypeSet, Key: "user:1", Value: []byte("a"), Seq: 1}, <-users.Events())
+		require.Len(t, all.Events(), 2)
+		assert.EqualValues(t, 1, (<-all.Events()).Seq)
+		assert.EqualValues(t, 2, (<-all.Events()).Seq, "events are numbered in publishing order")
+	})
+
+	t.Run("drops slow consumers", func(t *testing.T) {
+		bus := NewBus(1)
+		sub := bus.Subscribe("")
+		defer sub.Close()
+
+		bus.Publish(Event{Type: TypeSet, Key: "a", Time: time.Now()})
+		bus.Publish(Event{Type: TypeSet, Key: "b", Time: time.Now()})
+
+		_, ok := <-sub.Events()
+		assert.True(t, ok, "buffered event is still delivered")
+		_, ok = <-sub.Events()
+		assert.False(t, ok)
+		assert.ErrorIs(t, sub.Err(), ErrSlowConsumer)
+	})
+
+	t.Run("close stops delivery", func(t *testing.T) {
+		bus := NewBus(1)
+		sub := bus.Subscribe("")
+		sub.Close()
+		sub.Close()
+
+		bus.Publish(Event{Type: TypeSet, Key: "a"})
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		assert.NoError(t, sub.Err())
+	})
+
+	t.Run("nil bus discards events", func(t *testing.T) {
+		var bus *Bus
+		assert.NotPanics(t, func() { bus.Publish(Event{Key: "a"}) })
+	})
+}
@@ -0,0 +1,143 @@
+// Package graphqlapi serves the key-value store as a GraphQL API.
+//
+// The schema has the queries key(key) and keys(prefix, after, limit), and
+// the mutations setKey(key, value, ttl, ifNotExists) and deleteKey(key).
+// Resolvers run through store.Service, so validation matches the REST API,
+// and errors carry the API status code in their "status_code" extension.
+package graphqlapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/cluster"
+	"codesignal/internal/store"
+)
+
+// maxBodySize caps the size of a GraphQL request body.
+const maxBodySize = 16 << 20
+
+// Request is a GraphQL request sent over HTTP.
+type Request struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+// Error is a resolver error, reported with its status code in the
+// "status_code" extension.
+type Error struct {
+	Message    string
+	StatusCode store.StatusCode
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Extensions implements gqlerrors.ExtendedError.
+func (e *Error) Extensions() map[string]any {
+	return map[string]any{"status_code": e.StatusCode}
+}
+
+// Handler serves GraphQL requests.
+type Handler struct {
+	log    zerolog.Logger
+	schema graphql.Schema
+}
+
+// NewHandler returns the GraphQL handler for svc.
+func NewHandler(log zerolog.Logger, svc *store.Service) *Handler {
+	h := &Handler{log: log}
+
+	schema, err := newSchema(svc, h.resolveError)
+	if err != nil {
+		// The schema is static, failing to build it is a programming error.
+		panic("graphqlapi: invalid schema: " + err.Error())
+	}
+	h.schema = schema
+	return h
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&req); err != nil {
+		store.RequestLogger(r, &h.log).Error().Err(err).Msg("failed to decode graphql request")
+		h.writeError(w, &Error{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+	if req.Query == "" {
+		h.writeError(w, &Error{Message: "missing query", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+
+	result := graphql.Do(graphql.Params{
+		Schema:         h.schema,
+		RequestString:  req.Query,
+		VariableValues: req.Variables,
+		OperationName:  req.OperationName,
+		Context:        r.Context(),
+	})
+	h.writeJSON(w, http.StatusOK, result)
+}
+
+// writeError reports a request that couldn't be executed.
+func (h *Handler) writeError(w http.ResponseWriter, err *Error) {
+	h.writeJSON(w, http.StatusBadRequest, &graphql.Result{Errors: []gqlerrors.FormattedError{{
+		Message:    err.Message,
+		Locations:  []location.SourceLocation{},
+		Extensions: err.Extensions(),
+	}}})
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, code int, result *graphql.Result) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		h.log.Error().Err(err).Msg("error writing response")
+	}
+}
+
+// resolveError maps a store error to the error reported to clients.
+func (h *Handler) resolveError(err error) error {
+	var storageErr *store.StorageError
+	switch {
+	case errors.Is(err, store.ErrInvalidKey):
+		return &Error{Message: "invalid key", StatusCode: store.StatusInvalidKey}
+	case errors.Is(err, store.ErrKeyTooLong):
+		return &Error{Message: err.Error(), StatusCode: store.StatusKeyTooLong}
+	case errors.Is(err, store.ErrValueTooLarge):
+		return &Error{Message: err.Error(), StatusCode: store.StatusValueTooLarge}
+	case errors.Is(err, store.ErrRejected):
+		return &Error{Message: err.Error(), StatusCode: store.StatusInvalidValue}
+	case errors.Is(err, store.ErrKeyExists):
+		return &Error{Message: "key already exists", StatusCode: store.StatusKeyExists}
+	case errors.Is(err, auth.ErrForbidden):
+		return &Error{Message: err.Error(), StatusCode: store.StatusForbidden}
+	case errors.Is(err, store.ErrReadOnly):
+		return &Error{Message: err.Error(), StatusCode: store.StatusMaintenance}
+	case errors.Is(err, store.ErrMemoryLimit):
+		return &Error{Message: err.Error(), StatusCode: store.StatusMemoryLimit}
+	case errors.Is(err, context.Canceled):
+		return &Error{Message: "request canceled", StatusCode: store.StatusCanceled}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Message: "request timed out", StatusCode: store.StatusTimeout}
+	case errors.Is(err, cluster.ErrNotLeader), errors.Is(err, cluster.ErrNoLeader):
+		return &Error{Message: err.Error(), StatusCode: store.StatusNoLeader}
+	case errors.As(err, &storageErr):
+		h.log.Error().Err(err).Msg("store operation failed")
+		return &Error{Message: "failed to " + storageErr.Op + " key", StatusCode: store.StatusStorageError}
+	default:
+		h.log.Error().Err(err).Msg("store operation failed")
+		return &Error{Message: "storage error", StatusCode: store.StatusStorageError}
+	}
+}
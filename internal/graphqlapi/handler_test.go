@@ -0,0 +1,143 @@
+package graphqlapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	require.NoError(t, repo.Set(ctx, "user:1", []byte("alice")))
+	require.NoError(t, repo.SetWithTTL(ctx, "user:2", []byte("bob"), time.Hour))
+	require.NoError(t, repo.Set(ctx, "order:1", []byte("book")))
+
+	handler := NewHandler(zerolog.Nop(), store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8}))
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "get key",
+			body:     `{"query": "{ key(key: \"user:1\") { key value expiresAt } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"key":{"key":"user:1","value":"alice","expiresAt":null}}}`,
+		},
+		{
+			name:     "get missing key",
+			body:     `{"query": "query Get($key: String!) { key(key: $key) { value } }", "variables": {"key": "missing"}}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"key":null}}`,
+		},
+		{
+			name:     "list keys",
+			body:     `{"query": "{ keys(prefix: \"user:\") { key value } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"keys":[{"key":"user:1","value":"alice"},{"key":"user:2","value":"bob"}]}}`,
+		},
+		{
+			name:     "list keys page",
+			body:     `{"query": "{ keys(after: \"order:1\", limit: 1) { key } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"keys":[{"key":"user:1"}]}}`,
+		},
+		{
+			name:     "list keys invalid limit",
+			body:     `{"query": "{ keys(limit: 0) { key } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":null,"errors":[{"message":"limit must be between 1 and 1000","locations":[{"line":1,"column":3}],"path":["keys"],"extensions":{"status_code":1004}}]}`,
+		},
+		{
+			name:     "set key",
+			body:     `{"query": "mutation { setKey(key: \"user:3\", value: \"carol\") { key value } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"setKey":{"key":"user:3","value":"carol"}}}`,
+		},
+		{
+			name:     "set existing key if not exists",
+			body:     `{"query": "mutation { setKey(key: \"user:1\", value: \"x\", ifNotExists: true) { key } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":null,"errors":[{"message":"key already exists","locations":[{"line":1,"column":12}],"path":["setKey"],"extensions":{"status_code":1002}}]}`,
+		},
+		{
+			name:     "set key too long",
+			body:     `{"query": "mutation { setKey(key: \"too-long-key\", value: \"x\") { key } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":null,"errors":[{"message":"err: key length exceeds maximum allowed length, max key length: 8","locations":[{"line":1,"column":12}],"path":["setKey"],"extensions":{"status_code":1007}}]}`,
+		},
+		{
+			name:     "set invalid ttl",
+			body:     `{"query": "mutation { setKey(key: \"a\", value: \"x\", ttl: \"soon\") { key } }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":null,"errors":[{"message":"invalid ttl, expected a positive duration such as 30s","locations":[{"line":1,"column":12}],"path":["setKey"],"extensions":{"status_code":1011}}]}`,
+		},
+		{
+			name:     "delete key",
+			body:     `{"query": "mutation { deleteKey(key: \"user:3\") }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"deleteKey":true}}`,
+		},
+		{
+			name:     "delete missing key",
+			body:     `{"query": "mutation { deleteKey(key: \"user:3\") }"}`,
+			wantCode: http.StatusOK,
+			want:     `{"data":{"deleteKey":false}}`,
+		},
+		{
+			name:     "invalid body",
+			body:     `{`,
+			wantCode: http.StatusBadRequest,
+			want:     `{"data":null,"errors":[{"message":"invalid request body","locations":[],"extensions":{"status_code":1006}}]}`,
+		},
+		{
+			name:     "missing query",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+			want:     `{"data":null,"errors":[{"message":"missing query","locations":[],"extensions":{"status_code":1006}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body)))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			assert.JSONEq(t, tt.want, rec.Body.String())
+		})
+	}
+
+	t.Run("set key with ttl", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		body := `{"query": "mutation { setKey(key: \"temp\", value: \"x\", ttl: \"1h\") { expiresAt } }"}`
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(body)))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var resp struct {
+			Data struct {
+				SetKey struct {
+					ExpiresAt time.Time `json:"expiresAt"`
+				} `json:"setKey"`
+			} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.WithinDuration(t, time.Now().Add(time.Hour), resp.Data.SetKey.ExpiresAt, time.Minute)
+	})
+}
@@ -0,0 +1,176 @@
+package graphqlapi
+
+import (
+	"errors"
+	"time"
+
+	"github.com/graphql-go/graphql"
+
+	"codesignal/internal/store"
+)
+
+const (
+	// defaultKeysLimit is the page size of the keys query without limit.
+	defaultKeysLimit = 100
+	// maxKeysLimit caps the page size of the keys query.
+	maxKeysLimit = 1000
+)
+
+// keyValue is the source of the KeyValue type.
+type keyValue struct {
+	key   string
+	value []byte
+	// expiresAt is looked up when requested if nil, it is zero for keys
+	// that never expire.
+	expiresAt *time.Time
+}
+
+// newSchema builds the schema served by the handler, resolving every field
+// through svc.
+func newSchema(svc *store.Service, resolveErr func(error) error) (graphql.Schema, error) {
+	keyValueType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "KeyValue",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return p.Source.(*keyValue).key, nil
+				},
+			},
+			"value": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.String),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return string(p.Source.(*keyValue).value), nil
+				},
+			},
+			"expiresAt": &graphql.Field{
+				Type:        graphql.DateTime,
+				Description: "When the key expires, null if it never does.",
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					kv := p.Source.(*keyValue)
+					if kv.expiresAt == nil {
+						expiresAt, err := svc.Expiry(p.Context, kv.key)
+						if errors.Is(err, store.ErrKeyNotFound) {
+							return nil, nil
+						}
+						if err != nil {
+							return nil, resolveErr(err)
+						}
+						kv.expiresAt = &expiresAt
+					}
+					if kv.expiresAt.IsZero() {
+						return nil, nil
+					}
+					return *kv.expiresAt, nil
+				},
+			},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"key": &graphql.Field{
+				Type:        keyValueType,
+				Description: "Returns a key, or null if it doesn't exist.",
+				Args: graphql.FieldConfigArgument{
+					"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key := p.Args["key"].(string)
+					value, err := svc.Get(p.Context, key)
+					if errors.Is(err, store.ErrKeyNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, resolveErr(err)
+					}
+					return &keyValue{key: key, value: value}, nil
+				},
+			},
+			"keys": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(keyValueType))),
+				Description: "Lists keys starting with prefix in lexical order. Pass the last key of a page as after to get the next one.",
+				Args: graphql.FieldConfigArgument{
+					"prefix": &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: ""},
+					"limit":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultKeysLimit},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					limit := p.Args["limit"].(int)
+					if limit <= 0 || limit > maxKeysLimit {
+						return nil, &Error{Message: "limit must be between 1 and 1000", StatusCode: store.StatusInvalidValue}
+					}
+
+					items, err := svc.Scan(p.Context, p.Args["prefix"].(string), p.Args["after"].(string), limit)
+					if err != nil {
+						return nil, resolveErr(err)
+					}
+					kvs := make([]*keyValue, 0, len(items))
+					for _, item := range items {
+						kvs = append(kvs, &keyValue{key: item.Key, value: item.Value, expiresAt: &item.ExpiresAt})
+					}
+					return kvs, nil
+				},
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"setKey": &graphql.Field{
+				Type:        graphql.NewNonNull(keyValueType),
+				Description: "Sets a key, replacing its value unless ifNotExists is true. The optional ttl is a duration such as \"30s\".",
+				Args: graphql.FieldConfigArgument{
+					"key":         &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"value":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"ttl":         &graphql.ArgumentConfig{Type: graphql.String},
+					"ifNotExists": &graphql.ArgumentConfig{Type: graphql.Boolean, DefaultValue: false},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, value := p.Args["key"].(string), []byte(p.Args["value"].(string))
+
+					var ttl time.Duration
+					if s, ok := p.Args["ttl"].(string); ok {
+						d, err := time.ParseDuration(s)
+						if err != nil || d <= 0 {
+							return nil, &Error{Message: "invalid ttl, expected a positive duration such as 30s", StatusCode: store.StatusInvalidTTL}
+						}
+						ttl = d
+					}
+
+					var err error
+					if p.Args["ifNotExists"].(bool) {
+						err = svc.Create(p.Context, key, value, ttl)
+					} else {
+						err = svc.Set(p.Context, key, value, ttl)
+					}
+					if err != nil {
+						return nil, resolveErr(err)
+					}
+					return &keyValue{key: key, value: value}, nil
+				},
+			},
+			"deleteKey": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes a key, returning false if it didn't exist.",
+				Args: graphql.FieldConfigArgument{
+					"key": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					err := svc.Delete(p.Context, p.Args["key"].(string))
+					if errors.Is(err, store.ErrKeyNotFound) {
+						return false, nil
+					}
+					if err != nil {
+						return nil, resolveErr(err)
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: query, Mutation: mutation})
+}
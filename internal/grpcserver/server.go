@@ -0,0 +1,236 @@
+// Package grpcserver serves the gRPC API of the key-value store.
+//
+// The API is defined in api/kvpb and runs on its own port next to the HTTP
+// API, sharing the same store.Service so both protocols apply the same
+// validation. Watch streams changes from the events bus the repository
+// publishes to.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/rs/zerolog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"codesignal/api/kvpb"
+	"codesignal/internal/cluster"
+	"codesignal/internal/events"
+	"codesignal/internal/store"
+)
+
+// Config holds the configuration of the gRPC API.
+type Config struct {
+	// Enabled turns on the gRPC API.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// Address is the address the gRPC API listens on.
+	Address string `envconfig:"ADDRESS" default:"0.0.0.0:9000"`
+	// ScanLimit is the default and maximum number of items per Scan page.
+	ScanLimit int `envconfig:"SCAN_LIMIT" default:"1000"`
+}
+
+// DefaultScanLimit is used when no scan limit is configured.
+const DefaultScanLimit = 1000
+
+// Server implements the KeyValue gRPC service.
+type Server struct {
+	kvpb.UnimplementedKeyValueServer
+
+	log  zerolog.Logger
+	cfg  Config
+	svc  *store.Service
+	bus  *events.Bus
+	grpc *grpc.Server
+	done chan struct{}
+}
+
+// New returns a gRPC server for svc, streaming watched changes from bus.
+func New(log zerolog.Logger, cfg Config, svc *store.Service, bus *events.Bus) *Server {
+	if cfg.ScanLimit <= 0 {
+		cfg.ScanLimit = DefaultScanLimit
+	}
+
+	s := &Server{
+		log:  log.With().Str("component", "grpc").Logger(),
+		cfg:  cfg,
+		svc:  svc,
+		bus:  bus,
+		grpc: grpc.NewServer(),
+		done: make(chan struct{}),
+	}
+	kvpb.RegisterKeyValueServer(s.grpc, s)
+	return s
+}
+
+// Name implements server.Service.
+func (s *Server) Name() string {
+	return "grpc"
+}
+
+// Serve implements server.Service.
+func (s *Server) Serve() error {
+	lis, err := net.Listen("tcp", s.cfg.Address)
+	if err != nil {
+		return err
+	}
+	return s.serve(lis)
+}
+
+func (s *Server) serve(lis net.Listener) error {
+	s.log.Info().Msgf("grpc server listening on %q", lis.Addr().String())
+	if err := s.grpc.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown implements server.Service. Open watches are ended so they
+// don't hold the graceful stop.
+func (s *Server) Shutdown(ctx context.Context) error {
+	close(s.done)
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		return ctx.Err()
+	}
+}
+
+// Get implements kvpb.KeyValueServer.
+func (s *Server) Get(ctx context.Context, req *kvpb.GetRequest) (*kvpb.GetResponse, error) {
+	value, err := s.svc.Get(ctx, req.GetKey())
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &kvpb.GetResponse{Value: value}, nil
+}
+
+// Set implements kvpb.KeyValueServer.
+func (s *Server) Set(ctx context.Context, req *kvpb.SetRequest) (*kvpb.SetResponse, error) {
+	ttl := req.GetTtl().AsDuration()
+	if ttl < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ttl must not be negative")
+	}
+
+	var err error
+	if req.GetIfNotExists() {
+		if req.GetKey() == "" {
+			return nil, s.toStatus(store.ErrInvalidKey)
+		}
+		err = s.svc.Create(ctx, req.GetKey(), req.GetValue(), ttl)
+	} else {
+		err = s.svc.Set(ctx, req.GetKey(), req.GetValue(), ttl)
+	}
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &kvpb.SetResponse{}, nil
+}
+
+// Delete implements kvpb.KeyValueServer.
+func (s *Server) Delete(ctx context.Context, req *kvpb.DeleteRequest) (*kvpb.DeleteResponse, error) {
+	if err := s.svc.Delete(ctx, req.GetKey()); err != nil {
+		return nil, s.toStatus(err)
+	}
+	return &kvpb.DeleteResponse{}, nil
+}
+
+// Scan implements kvpb.KeyValueServer.
+func (s *Server) Scan(ctx context.Context, req *kvpb.ScanRequest) (*kvpb.ScanResponse, error) {
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > s.cfg.ScanLimit {
+		limit = s.cfg.ScanLimit
+	}
+
+	items, err := s.svc.Scan(ctx, req.GetPrefix(), req.GetStartAfter(), limit)
+	if err != nil {
+		return nil, s.toStatus(err)
+	}
+
+	resp := &kvpb.ScanResponse{Items: make([]*kvpb.Item, 0, len(items))}
+	for _, item := range items {
+		pbItem := &kvpb.Item{Key: item.Key, Value: item.Value}
+		if !item.ExpiresAt.IsZero() {
+			pbItem.ExpiresAt = timestamppb.New(item.ExpiresAt)
+		}
+		resp.Items = append(resp.Items, pbItem)
+	}
+	if len(items) == limit {
+		resp.NextKey = items[len(items)-1].Key
+	}
+	return resp, nil
+}
+
+// Watch implements kvpb.KeyValueServer.
+func (s *Server) Watch(req *kvpb.WatchRequest, stream kvpb.KeyValue_WatchServer) error {
+	sub := s.bus.Subscribe(s.svc.NormalizeKey(req.GetPrefix()))
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.done:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case e, ok := <-sub.Events():
+			if !ok {
+				return status.Error(codes.ResourceExhausted, sub.Err().Error())
+			}
+			if store.ReservedKey(e.Key) {
+				continue
+			}
+			if err := stream.Send(toWatchEvent(e)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toWatchEvent(e events.Event) *kvpb.WatchEvent {
+	typ := kvpb.EventType_EVENT_TYPE_UNSPECIFIED
+	switch e.Type {
+	case events.TypeSet:
+		typ = kvpb.EventType_EVENT_TYPE_SET
+	case events.TypeDelete:
+		typ = kvpb.EventType_EVENT_TYPE_DELETE
+	case events.TypeExpire:
+		typ = kvpb.EventType_EVENT_TYPE_EXPIRE
+	}
+	return &kvpb.WatchEvent{Type: typ, Key: e.Key, Value: e.Value, Time: timestamppb.New(e.Time)}
+}
+
+// toStatus maps a store error to its gRPC status.
+func (s *Server) toStatus(err error) error {
+	switch {
+	case errors.Is(err, store.ErrInvalidKey), errors.Is(err, store.ErrKeyTooLong), errors.Is(err, store.ErrValueTooLarge),
+		errors.Is(err, store.ErrRejected):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, store.ErrKeyNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, store.ErrKeyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, store.ErrReadOnly), errors.Is(err, cluster.ErrNotLeader), errors.Is(err, cluster.ErrNoLeader):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, store.ErrMemoryLimit):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	default:
+		s.log.Error().Err(err).Msg("store operation failed")
+		return status.Error(codes.Internal, "storage error")
+	}
+}
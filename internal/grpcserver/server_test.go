@@ -0,0 +1,185 @@
+package grpcserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"codesignal/api/kvpb"
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func newTestClient(t *testing.T, cfg Config) kvpb.KeyValueClient {
+	t.Helper()
+
+	bus := events.NewBus(16)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+
+	srv := New(zerolog.Nop(), cfg, store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8}), bus)
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = srv.serve(lis) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return kvpb.NewKeyValueClient(conn)
+}
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, Config{ScanLimit: 2})
+
+	t.Run("set and get", func(t *testing.T) {
+		_, err := client.Set(ctx, &kvpb.SetRequest{Key: "a", Value: []byte("1")})
+		require.NoError(t, err)
+		_, err = client.Set(ctx, &kvpb.SetRequest{Key: "a", Value: []byte("2")})
+		require.NoError(t, err, "set overwrites")
+
+		resp, err := client.Get(ctx, &kvpb.GetRequest{Key: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, []byte("2"), resp.GetValue())
+	})
+
+	tests := []struct {
+		name string
+		call func() error
+		want codes.Code
+	}{
+		{
+			name: "get missing key",
+			call: func() error { _, err := client.Get(ctx, &kvpb.GetRequest{Key: "missing"}); return err },
+			want: codes.NotFound,
+		},
+		{
+			name: "get empty key",
+			call: func() error { _, err := client.Get(ctx, &kvpb.GetRequest{}); return err },
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "set key too long",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "too-long-key", Value: []byte("v")})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "set existing key with if_not_exists",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "a", Value: []byte("v"), IfNotExists: true})
+				return err
+			},
+			want: codes.AlreadyExists,
+		},
+		{
+			name: "set negative ttl",
+			call: func() error {
+				_, err := client.Set(ctx, &kvpb.SetRequest{Key: "b", Ttl: durationpb.New(-time.Second)})
+				return err
+			},
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "delete missing key",
+			call: func() error { _, err := client.Delete(ctx, &kvpb.DeleteRequest{Key: "missing"}); return err },
+			want: codes.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, status.Code(tt.call()))
+		})
+	}
+
+	t.Run("scan pages", func(t *testing.T) {
+		for _, key := range []string{"p:1", "p:2", "p:3"} {
+			_, err := client.Set(ctx, &kvpb.SetRequest{Key: key, Value: []byte(key), Ttl: durationpb.New(time.Hour)})
+			require.NoError(t, err)
+		}
+
+		page, err := client.Scan(ctx, &kvpb.ScanRequest{Prefix: "p:", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, page.GetItems(), 2, "limit capped by the server")
+		assert.Equal(t, "p:2", page.GetNextKey())
+		assert.NotNil(t, page.GetItems()[0].GetExpiresAt())
+
+		page, err = client.Scan(ctx, &kvpb.ScanRequest{Prefix: "p:", StartAfter: page.GetNextKey()})
+		require.NoError(t, err)
+		require.Len(t, page.GetItems(), 1)
+		assert.Equal(t, "p:3", page.GetItems()[0].GetKey())
+		assert.Empty(t, page.GetNextKey())
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		_, err := client.Delete(ctx, &kvpb.DeleteRequest{Key: "a"})
+		require.NoError(t, err)
+		_, err = client.Get(ctx, &kvpb.GetRequest{Key: "a"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestServerWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := newTestClient(t, Config{})
+
+	stream, err := client.Watch(ctx, &kvpb.WatchRequest{Prefix: "user:"})
+	require.NoError(t, err)
+
+	// The subscription is registered once the stream is established, retry
+	// writes until the first event arrives.
+	first := make(chan *kvpb.WatchEvent)
+	go func() {
+		e, err := stream.Recv()
+		if err == nil {
+			first <- e
+		}
+	}()
+	var got *kvpb.WatchEvent
+	for got == nil {
+		_, err := client.Set(ctx, &kvpb.SetRequest{Key: "user:1", Value: []byte("v")})
+		require.NoError(t, err)
+		select {
+		case got = <-first:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	assert.Equal(t, kvpb.EventType_EVENT_TYPE_SET, got.GetType())
+	assert.Equal(t, "user:1", got.GetKey())
+	assert.Equal(t, []byte("v"), got.GetValue())
+
+	_, err = client.Set(ctx, &kvpb.SetRequest{Key: "order:1", Value: []byte("v")})
+	require.NoError(t, err)
+	_, err = client.Delete(ctx, &kvpb.DeleteRequest{Key: "user:1"})
+	require.NoError(t, err)
+
+	for {
+		e, err := stream.Recv()
+		require.NoError(t, err)
+		if e.GetType() == kvpb.EventType_EVENT_TYPE_DELETE {
+			assert.Equal(t, "user:1", e.GetKey())
+			break
+		}
+		assert.Equal(t, "user:1", e.GetKey(), "only watched keys are streamed")
+	}
+}
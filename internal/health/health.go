@@ -0,0 +1,75 @@
+// Package health serves the liveness and readiness probes of the server.
+//
+// The liveness probe answers as long as the process serves HTTP requests.
+// The readiness probe runs the checks of the subsystems of the node, such as
+// the repository and the Raft node, and answers 503 until all of them pass,
+// so rolling deploys don't route traffic to a node still replaying its log.
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// Checker is implemented by the subsystems checked by the readiness probe.
+type Checker interface {
+	// Ready returns why the subsystem can't serve requests, nil if it can.
+	Ready() error
+}
+
+// Check is a readiness check of a named subsystem.
+type Check struct {
+	Name    string
+	Checker Checker
+}
+
+// Response is the payload of the probes.
+type Response struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	// Checks maps the checked subsystems to "ok" or the reason they aren't
+	// ready.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// LivenessHandler answers the liveness probe.
+func LivenessHandler(log zerolog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(store.RequestLogger(r, &log), w, http.StatusOK, Response{Message: "alive", StatusCode: store.StatusSuccess})
+	})
+}
+
+// ReadinessHandler answers the readiness probe, running checks in order.
+func ReadinessHandler(log zerolog.Logger, checks ...Check) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code, resp := http.StatusOK, Response{Message: "ready", StatusCode: store.StatusSuccess, Checks: make(map[string]string, len(checks))}
+
+		var failed []string
+		for _, check := range checks {
+			resp.Checks[check.Name] = "ok"
+			if err := check.Checker.Ready(); err != nil {
+				resp.Checks[check.Name] = err.Error()
+				failed = append(failed, check.Name)
+			}
+		}
+		if len(failed) > 0 {
+			code, resp.Message, resp.StatusCode = http.StatusServiceUnavailable, "not ready", store.StatusNotReady
+			store.RequestLogger(r, &log).Warn().Strs("checks", failed).Msg("readiness check failed")
+		}
+		writeJSON(store.RequestLogger(r, &log), w, code, resp)
+	})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, resp Response) {
+	w.Header().Set("Content-Type", "application/json")
+	// Probes must never be served from a cache.
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,56 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+type checkerFunc func() error
+
+func (f checkerFunc) Ready() error { return f() }
+
+func serve(t *testing.T, h http.Handler) (*httptest.ResponseRecorder, Response) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	var resp Response
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	return w, resp
+}
+
+func TestLivenessHandler(t *testing.T) {
+	w, resp := serve(t, LivenessHandler(zerolog.Nop()))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, store.StatusSuccess, resp.StatusCode)
+	assert.Equal(t, "alive", resp.Message)
+}
+
+func TestReadinessHandler(t *testing.T) {
+	var replaying error
+	h := ReadinessHandler(zerolog.Nop(),
+		Check{Name: "repository", Checker: checkerFunc(func() error { return nil })},
+		Check{Name: "raft", Checker: checkerFunc(func() error { return replaying })},
+	)
+
+	w, resp := serve(t, h)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, store.StatusSuccess, resp.StatusCode)
+	assert.Equal(t, map[string]string{"repository": "ok", "raft": "ok"}, resp.Checks)
+
+	replaying = errors.New("replaying raft log")
+	w, resp = serve(t, h)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, store.StatusNotReady, resp.StatusCode)
+	assert.Equal(t, "not ready", resp.Message)
+	assert.Equal(t, map[string]string{"repository": "ok", "raft": "replaying raft log"}, resp.Checks)
+}
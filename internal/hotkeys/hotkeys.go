@@ -0,0 +1,185 @@
+// Package hotkeys finds the most accessed keys of the store.
+//
+// A Tracker samples the reads and writes of keys and counts the sampled
+// accesses with the Space-Saving algorithm: it keeps a fixed number of
+// counters per kind of access and, once they are all used, replaces the key
+// with the lowest count by the newly seen key, which inherits its count.
+// Memory is bounded by the capacity whatever the number of keys, and keys
+// accessed more often than 1 in capacity sampled accesses are always
+// reported. Counts are estimates: they are scaled by the sample rate and may
+// overestimate keys that replaced another.
+package hotkeys
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// DefaultCapacity is the capacity used when Config leaves it unset.
+const DefaultCapacity = 1000
+
+// Config holds the configuration of hot key tracking.
+type Config struct {
+	// SampleRate samples 1 in SampleRate accesses, 0 disables tracking.
+	SampleRate int `envconfig:"SAMPLE_RATE" default:"10"`
+	// Capacity is the number of keys tracked per kind of access.
+	Capacity int `envconfig:"CAPACITY" default:"1000"`
+}
+
+// KeyCount is a key with its estimated number of accesses.
+type KeyCount struct {
+	Key   string `json:"key"`
+	Count int64  `json:"count"`
+}
+
+// Tracker counts the sampled accesses of keys. The methods of a nil Tracker
+// do nothing, so callers don't check whether tracking is enabled.
+type Tracker struct {
+	sampleRate int
+	reads      *counters
+	writes     *counters
+}
+
+// New returns a tracker configured by cfg, nil if tracking is disabled.
+func New(cfg Config) *Tracker {
+	if cfg.SampleRate <= 0 {
+		return nil
+	}
+	if cfg.Capacity <= 0 {
+		cfg.Capacity = DefaultCapacity
+	}
+	return &Tracker{
+		sampleRate: cfg.SampleRate,
+		reads:      newCounters(cfg.Capacity),
+		writes:     newCounters(cfg.Capacity),
+	}
+}
+
+// SampleRate returns the sample rate of the tracker, 0 if it is disabled.
+func (t *Tracker) SampleRate() int {
+	if t == nil {
+		return 0
+	}
+	return t.sampleRate
+}
+
+// Read records a read of key.
+func (t *Tracker) Read(key string) {
+	if t.sample() {
+		t.reads.add(key)
+	}
+}
+
+// Write records a write of key.
+func (t *Tracker) Write(key string) {
+	if t.sample() {
+		t.writes.add(key)
+	}
+}
+
+func (t *Tracker) sample() bool {
+	return t != nil && (t.sampleRate == 1 || rand.IntN(t.sampleRate) == 0)
+}
+
+// Reads returns up to n of the most read keys, most read first.
+func (t *Tracker) Reads(n int) []KeyCount {
+	if t == nil {
+		return nil
+	}
+	return t.reads.top(n, int64(t.sampleRate))
+}
+
+// Writes returns up to n of the most written keys, most written first.
+func (t *Tracker) Writes(n int) []KeyCount {
+	if t == nil {
+		return nil
+	}
+	return t.writes.top(n, int64(t.sampleRate))
+}
+
+// counters are the Space-Saving counters of a kind of access, a min-heap
+// by count indexed by key.
+type counters struct {
+	mu       sync.Mutex
+	capacity int
+	keys     map[string]*counter
+	heap     counterHeap
+}
+
+type counter struct {
+	key   string
+	count int64
+	index int
+}
+
+func newCounters(capacity int) *counters {
+	return &counters{capacity: capacity, keys: make(map[string]*counter, capacity)}
+}
+
+func (c *counters) add(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.keys[key]; ok {
+		e.count++
+		heap.Fix(&c.heap, e.index)
+		return
+	}
+	if len(c.heap) < c.capacity {
+		e := &counter{key: key, count: 1}
+		heap.Push(&c.heap, e)
+		c.keys[key] = e
+		return
+	}
+	// Replace the least accessed key, the new key inherits its count.
+	e := c.heap[0]
+	delete(c.keys, e.key)
+	e.key = key
+	e.count++
+	c.keys[key] = e
+	heap.Fix(&c.heap, 0)
+}
+
+func (c *counters) top(n int, scale int64) []KeyCount {
+	c.mu.Lock()
+	counts := make([]KeyCount, len(c.heap))
+	for i, e := range c.heap {
+		counts[i] = KeyCount{Key: e.key, Count: e.count * scale}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if n >= 0 && n < len(counts) {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+
+func (h *counterHeap) Push(x any) {
+	e := x.(*counter)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *counterHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
@@ -0,0 +1,58 @@
+package hotkeys
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracker(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		tracker := New(Config{})
+		require.Nil(t, tracker)
+
+		tracker.Read("a")
+		tracker.Write("a")
+		assert.Empty(t, tracker.Reads(10))
+		assert.Zero(t, tracker.SampleRate())
+	})
+
+	t.Run("counts reads and writes", func(t *testing.T) {
+		tracker := New(Config{SampleRate: 1, Capacity: 10})
+		for i := 0; i < 3; i++ {
+			tracker.Read("a")
+		}
+		tracker.Read("b")
+		tracker.Write("b")
+
+		assert.Equal(t, []KeyCount{{Key: "a", Count: 3}, {Key: "b", Count: 1}}, tracker.Reads(10))
+		assert.Equal(t, []KeyCount{{Key: "a", Count: 3}}, tracker.Reads(1))
+		assert.Equal(t, []KeyCount{{Key: "b", Count: 1}}, tracker.Writes(10))
+	})
+
+	t.Run("bounds memory", func(t *testing.T) {
+		tracker := New(Config{SampleRate: 1, Capacity: 8})
+		for i := 0; i < 1000; i++ {
+			tracker.Read("hot")
+			tracker.Read(fmt.Sprintf("cold-%d", i))
+		}
+
+		reads := tracker.Reads(-1)
+		assert.Len(t, reads, 8)
+		assert.Equal(t, KeyCount{Key: "hot", Count: 1000}, reads[0])
+	})
+
+	t.Run("scales sampled counts", func(t *testing.T) {
+		tracker := New(Config{SampleRate: 4, Capacity: 10})
+		for i := 0; i < 4000; i++ {
+			tracker.Write("a")
+		}
+
+		writes := tracker.Writes(1)
+		require.Len(t, writes, 1)
+		assert.InDelta(t, 4000, writes[0].Count, 600)
+		assert.Zero(t, writes[0].Count%4)
+	})
+}
@@ -0,0 +1,178 @@
+// Package ipfilter restricts the clients of the HTTP API by address.
+//
+// Clients connected over a unix socket have no address and are always
+// allowed, the permissions of the socket file restricting them instead.
+//
+// A Filter denies the clients matching its deny list and, when it has an
+// allow list, the clients not matching it. Lists hold CIDR prefixes or
+// single addresses. Behind reverse proxies the address of the connection is
+// the one of the proxy: when it is a trusted proxy, the client is instead
+// the last address of the X-Forwarded-For header not of a trusted proxy, so
+// clients can't pick their address by sending the header themselves.
+package ipfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// ForwardedForHeader lists the addresses of the client and of the proxies
+// a request went through, each proxy appending the address it received the
+// request from.
+const ForwardedForHeader = "X-Forwarded-For"
+
+// Config holds the address lists of the filter.
+type Config struct {
+	// Allow only accepts the clients matching one of its comma separated
+	// prefixes, such as "10.0.0.0/8,192.168.1.7". Empty allows every client
+	// not denied.
+	Allow []string `envconfig:"ALLOW"`
+	// Deny rejects the clients matching one of its prefixes, even if
+	// allowed.
+	Deny []string `envconfig:"DENY"`
+	// TrustedProxies are the prefixes of the reverse proxies whose
+	// X-Forwarded-For header is trusted.
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES"`
+}
+
+// Enabled reports whether cfg holds an allow or deny list.
+func (c Config) Enabled() bool {
+	return len(c.Allow) > 0 || len(c.Deny) > 0
+}
+
+// Filter checks the addresses of clients.
+type Filter struct {
+	allow, deny, trusted []netip.Prefix
+}
+
+// New returns the filter configured by cfg, nil without allow or deny list.
+func New(cfg Config) (*Filter, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+
+	var (
+		f   Filter
+		err error
+	)
+	if f.allow, err = parsePrefixes(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	if f.deny, err = parsePrefixes(cfg.Deny); err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+	if f.trusted, err = parsePrefixes(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	return &f, nil
+}
+
+// parsePrefixes parses CIDR prefixes and single addresses.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed reports whether the client at addr may call the API.
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if contains(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, addr)
+}
+
+// ClientAddr returns the address of the client of r, reporting false when
+// it can't be parsed.
+func (f *Filter) ClientAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	addr = addr.Unmap()
+
+	// The header is read from the right, the addresses appended by the
+	// trusted proxies, to the first address added by an untrusted hop.
+	hops := strings.Split(strings.Join(r.Header.Values(ForwardedForHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0 && contains(f.trusted, addr); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		next, err := netip.ParseAddr(hop)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		addr = next.Unmap()
+	}
+	return addr, true
+}
+
+// Middleware rejects the requests of clients not allowed with a 403 before
+// serving them with next, and adds the address of the client to the request
+// logger. A nil filter serves every request.
+func (f *Filter) Middleware(next http.Handler) http.Handler {
+	if f == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		addr, ok := f.ClientAddr(r)
+		if ok {
+			zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("client_ip", addr.String())
+			})
+		}
+		if !ok || !f.Allowed(addr) {
+			zerolog.Ctx(r.Context()).Debug().Msg("client address denied")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			resp := store.Response{Message: "forbidden: client address not allowed", StatusCode: store.StatusForbidden}
+			if err := json.NewEncoder(w).Encode(resp); err != nil {
+				zerolog.Ctx(r.Context()).Error().Err(err).Msg("error writing response")
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
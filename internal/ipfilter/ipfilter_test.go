@@ -0,0 +1,120 @@
+package ipfilter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	f, err := New(Config{TrustedProxies: []string{"10.0.0.1"}})
+	require.NoError(t, err)
+	assert.Nil(t, f)
+
+	for _, cfg := range []Config{
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not-an-ip"}},
+		{Allow: []string{"10.0.0.0/8"}, TrustedProxies: []string{"proxy"}},
+	} {
+		_, err := New(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	f, err := New(Config{Allow: []string{"10.0.0.0/8", "192.168.1.7", "2001:db8::/32"}, Deny: []string{"10.0.0.13"}})
+	require.NoError(t, err)
+
+	for addr, allowed := range map[string]bool{
+		"10.1.2.3":         true,
+		"10.0.0.13":        false,
+		"192.168.1.7":      true,
+		"192.168.1.8":      false,
+		"::ffff:10.1.2.3":  true,
+		"2001:db8::1":      true,
+		"2001:db9::1":      false,
+		"::ffff:10.0.0.13": false,
+	} {
+		assert.Equal(t, allowed, f.Allowed(netip.MustParseAddr(addr)), addr)
+	}
+
+	// Without allow list, every client not denied is allowed.
+	f, err = New(Config{Deny: []string{"203.0.113.0/24"}})
+	require.NoError(t, err)
+	assert.True(t, f.Allowed(netip.MustParseAddr("198.51.100.1")))
+	assert.False(t, f.Allowed(netip.MustParseAddr("203.0.113.9")))
+}
+
+func TestClientAddr(t *testing.T) {
+	f, err := New(Config{Deny: []string{"203.0.113.0/24"}, TrustedProxies: []string{"10.0.0.0/8"}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name, remote string
+		forwarded    []string
+		want         string
+	}{
+		{name: "direct", remote: "198.51.100.1:1234", want: "198.51.100.1"},
+		{name: "ipv6", remote: "[2001:db8::1]:1234", want: "2001:db8::1"},
+		{name: "untrusted proxy", remote: "198.51.100.1:1234", forwarded: []string{"203.0.113.9"}, want: "198.51.100.1"},
+		{name: "trusted proxy", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9"}, want: "203.0.113.9"},
+		{name: "spoofed header", remote: "10.0.0.1:1234", forwarded: []string{"127.0.0.1, 203.0.113.9"}, want: "203.0.113.9"},
+		{name: "proxy chain", remote: "10.0.0.1:1234", forwarded: []string{"203.0.113.9, 10.0.0.2", "10.0.0.3"}, want: "203.0.113.9"},
+		{name: "only proxies", remote: "10.0.0.1:1234", forwarded: []string{"10.0.0.2"}, want: "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.forwarded {
+				r.Header.Add(ForwardedForHeader, v)
+			}
+			addr, ok := f.ClientAddr(r)
+			require.True(t, ok)
+			assert.Equal(t, tt.want, addr.String())
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set(ForwardedForHeader, "garbage")
+	_, ok := f.ClientAddr(r)
+	assert.False(t, ok)
+}
+
+func TestMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.NotNil(t, (*Filter)(nil).Middleware(next))
+
+	f, err := New(Config{Allow: []string{"198.51.100.0/24"}})
+	require.NoError(t, err)
+	handler := f.Middleware(next)
+
+	serve := func(remote string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/key/a", nil)
+		r.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, serve("198.51.100.1:1234").Code)
+	rec := serve("203.0.113.9:1234")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.JSONEq(t, `{"message":"forbidden: client address not allowed","status_code":1019}`, rec.Body.String())
+
+	// The clients of a unix socket have no address.
+	r := httptest.NewRequest(http.MethodGet, "/key/a", nil)
+	r.RemoteAddr = "@"
+	r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, &net.UnixAddr{Name: "/run/kv/kv.sock", Net: "unix"}))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, http.StatusForbidden, serve("@").Code)
+}
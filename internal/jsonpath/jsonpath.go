@@ -0,0 +1,169 @@
+// Package jsonpath addresses a single value of a decoded JSON document with
+// the definite subset of JSONPath: the root $ followed by member names, as
+// .name or ['name'], and array indexes, as [0] or [-1] counting from the
+// end. Wildcards, filters and recursive descent are not supported.
+package jsonpath
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when a path addresses a value the document
+// doesn't hold.
+var ErrNotFound = errors.New("path not found")
+
+// SyntaxError is returned by Parse for an invalid or unsupported path.
+type SyntaxError struct {
+	// Offset is the byte offset of the error in the path.
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid path at offset %d: %s", e.Offset, e.Msg)
+}
+
+// step is a member name or an array index.
+type step struct {
+	name    string
+	index   int
+	isIndex bool
+}
+
+// Path is a parsed JSONPath. The zero value addresses the root.
+type Path struct {
+	steps []step
+}
+
+// Parse parses path.
+func Parse(path string) (Path, error) {
+	if !strings.HasPrefix(path, "$") {
+		return Path{}, &SyntaxError{Offset: 0, Msg: "path must start with $"}
+	}
+
+	var p Path
+	for i := 1; i < len(path); {
+		switch path[i] {
+		case '.':
+			start := i + 1
+			end := start
+			for end < len(path) && path[end] != '.' && path[end] != '[' {
+				end++
+			}
+			name := path[start:end]
+			if name == "" || name == "*" {
+				return Path{}, &SyntaxError{Offset: start, Msg: "expected a member name"}
+			}
+			p.steps = append(p.steps, step{name: name})
+			i = end
+		case '[':
+			end := strings.IndexByte(path[i:], ']')
+			if end < 0 {
+				return Path{}, &SyntaxError{Offset: i, Msg: "unterminated bracket"}
+			}
+			s, err := parseBracket(path[i+1:i+end], i+1)
+			if err != nil {
+				return Path{}, err
+			}
+			p.steps = append(p.steps, s)
+			i += end + 1
+		default:
+			return Path{}, &SyntaxError{Offset: i, Msg: fmt.Sprintf("unexpected %q", path[i])}
+		}
+	}
+	return p, nil
+}
+
+// parseBracket parses the content of brackets, a quoted member name or an
+// array index, found at offset in the path.
+func parseBracket(s string, offset int) (step, error) {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		return step{name: s[1 : len(s)-1]}, nil
+	}
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return step{}, &SyntaxError{Offset: offset, Msg: "expected a quoted member name or an array index"}
+	}
+	return step{index: index, isIndex: true}, nil
+}
+
+// IsRoot reports whether p addresses the whole document.
+func (p Path) IsRoot() bool {
+	return len(p.steps) == 0
+}
+
+// Get returns the value addressed by p in doc, or ErrNotFound.
+func (p Path) Get(doc any) (any, error) {
+	for _, s := range p.steps {
+		var err error
+		if doc, err = s.get(doc); err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+// Set replaces the value addressed by p in doc with value and returns the
+// updated document, doc itself unless p is the root. A missing member of
+// an existing object is created, while arrays are never extended, so other
+// missing values fail with ErrNotFound.
+func (p Path) Set(doc, value any) (any, error) {
+	if p.IsRoot() {
+		return value, nil
+	}
+	parent, err := Path{steps: p.steps[:len(p.steps)-1]}.Get(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	last := p.steps[len(p.steps)-1]
+	switch v := parent.(type) {
+	case map[string]any:
+		if last.isIndex {
+			return nil, ErrNotFound
+		}
+		v[last.name] = value
+	case []any:
+		i, ok := last.position(len(v))
+		if !ok {
+			return nil, ErrNotFound
+		}
+		v[i] = value
+	default:
+		return nil, ErrNotFound
+	}
+	return doc, nil
+}
+
+// get returns the value addressed by s in doc.
+func (s step) get(doc any) (any, error) {
+	switch v := doc.(type) {
+	case map[string]any:
+		if !s.isIndex {
+			if member, ok := v[s.name]; ok {
+				return member, nil
+			}
+		}
+	case []any:
+		if i, ok := s.position(len(v)); ok {
+			return v[i], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// position returns the position in an array of length n of the index of s,
+// counting negative indexes from the end.
+func (s step) position(n int) (int, bool) {
+	if !s.isIndex {
+		return 0, false
+	}
+	i := s.index
+	if i < 0 {
+		i += n
+	}
+	return i, i >= 0 && i < n
+}
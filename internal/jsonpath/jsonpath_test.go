@@ -0,0 +1,97 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, doc string) any {
+	t.Helper()
+	var v any
+	require.NoError(t, json.Unmarshal([]byte(doc), &v))
+	return v
+}
+
+func TestParse(t *testing.T) {
+	for _, path := range []string{"$", "$.a", "$.a.b", "$['a b']", `$["a"][0]`, "$.items[-1].name"} {
+		_, err := Parse(path)
+		assert.NoError(t, err, path)
+	}
+
+	tests := map[string]int{
+		"":        0,
+		"a.b":     0,
+		"$.":      2,
+		"$..a":    2,
+		"$.*":     2,
+		"$[*]":    2,
+		"$[0":     1,
+		"$a":      1,
+		"$.a[?b]": 4,
+	}
+	for path, offset := range tests {
+		_, err := Parse(path)
+		var syntaxErr *SyntaxError
+		if assert.ErrorAs(t, err, &syntaxErr, path) {
+			assert.Equal(t, offset, syntaxErr.Offset, path)
+		}
+	}
+}
+
+func TestGet(t *testing.T) {
+	doc := decode(t, `{"a":{"b":[1,{"c":"x"}]},"d e":true}`)
+
+	tests := map[string]any{
+		"$":          doc,
+		"$.a.b[0]":   float64(1),
+		"$.a.b[-1]":  map[string]any{"c": "x"},
+		"$.a.b[1].c": "x",
+		"$['d e']":   true,
+	}
+	for path, want := range tests {
+		p, err := Parse(path)
+		require.NoError(t, err)
+		got, err := p.Get(doc)
+		require.NoError(t, err, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	for _, path := range []string{"$.x", "$.a.b[2]", "$.a.b[-3]", "$.a[0]", "$.a.b.c", "$['d e'].f"} {
+		p, err := Parse(path)
+		require.NoError(t, err)
+		_, err = p.Get(doc)
+		assert.ErrorIs(t, err, ErrNotFound, path)
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$", `"root"`},
+		{"$.a.b", `{"a":{"b":"root"},"l":[1,2]}`},
+		{"$.a.new", `{"a":{"b":1,"new":"root"},"l":[1,2]}`},
+		{"$.l[-1]", `{"a":{"b":1},"l":[1,"root"]}`},
+	}
+	for _, tt := range tests {
+		p, err := Parse(tt.path)
+		require.NoError(t, err)
+		doc, err := p.Set(decode(t, `{"a":{"b":1},"l":[1,2]}`), "root")
+		require.NoError(t, err, tt.path)
+		got, err := json.Marshal(doc)
+		require.NoError(t, err)
+		assert.JSONEq(t, tt.want, string(got), tt.path)
+	}
+
+	// Only the last member is created, and arrays are never extended.
+	for _, path := range []string{"$.x.y", "$.l[2]", "$.a[0]", "$.l.x", "$.a.b.c"} {
+		p, err := Parse(path)
+		require.NoError(t, err)
+		_, err = p.Set(decode(t, `{"a":{"b":1},"l":[1,2]}`), "root")
+		assert.ErrorIs(t, err, ErrNotFound, path)
+	}
+}
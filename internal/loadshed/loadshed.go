@@ -0,0 +1,136 @@
+// Package loadshed bounds the requests served at once, so the store
+// degrades gracefully under overload.
+//
+// A Shedder holds a global limit on the requests in flight and limits for
+// some routes. A request over a limit is rejected at once with a 503 and a
+// Retry-After header rather than queued, so clients back off or try
+// another node instead of waiting until their request times out.
+package loadshed
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/metrics"
+	"codesignal/internal/store"
+)
+
+// Config holds the limits of the shedder.
+type Config struct {
+	// MaxInFlight bounds the requests served at once, 0 disables the
+	// global limit.
+	MaxInFlight int `envconfig:"MAX_IN_FLIGHT"`
+	// Routes bounds the requests served at once by route, keyed by
+	// operation ID, such as "listKeys:4,graphql:16".
+	Routes map[string]int `envconfig:"ROUTES"`
+	// RetryAfter is the delay shed clients are told to wait, rounded up to
+	// the second.
+	RetryAfter time.Duration `envconfig:"RETRY_AFTER" default:"1s"`
+}
+
+func (c Config) enabled() bool {
+	return c.MaxInFlight != 0 || len(c.Routes) > 0
+}
+
+// Shedder rejects the requests over its limits.
+type Shedder struct {
+	global     chan struct{}
+	routes     map[string]chan struct{}
+	retryAfter string
+}
+
+// New returns the shedder configured by cfg, nil without limits.
+func New(cfg Config) (*Shedder, error) {
+	if !cfg.enabled() {
+		return nil, nil
+	}
+	if cfg.MaxInFlight < 0 {
+		return nil, fmt.Errorf("max in flight must not be negative, got %d", cfg.MaxInFlight)
+	}
+
+	s := &Shedder{
+		routes:     make(map[string]chan struct{}, len(cfg.Routes)),
+		retryAfter: strconv.Itoa(int(math.Ceil(cfg.RetryAfter.Seconds()))),
+	}
+	if cfg.MaxInFlight > 0 {
+		s.global = make(chan struct{}, cfg.MaxInFlight)
+	}
+	for route, limit := range cfg.Routes {
+		if limit <= 0 {
+			return nil, fmt.Errorf("limit of route %s must be positive, got %d", route, limit)
+		}
+		s.routes[route] = make(chan struct{}, limit)
+	}
+	return s, nil
+}
+
+// Routes returns the routes with a limit.
+func (s *Shedder) Routes() []string {
+	if s == nil {
+		return nil
+	}
+	routes := make([]string, 0, len(s.routes))
+	for route := range s.routes {
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// Limit serves the requests of route within its limit and, when global is
+// set, the global limit. Long-lived requests such as WebSocket connections
+// would hold a slot of the global limit for their whole duration, they are
+// limited by route only. A nil Shedder serves every request.
+func (s *Shedder) Limit(route string, global bool, next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	var slots []chan struct{}
+	if sem, ok := s.routes[route]; ok {
+		slots = append(slots, sem)
+	}
+	if global && s.global != nil {
+		slots = append(slots, s.global)
+	}
+	if len(slots) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, sem := range slots {
+			select {
+			case sem <- struct{}{}:
+			default:
+				for _, acquired := range slots[:i] {
+					<-acquired
+				}
+				s.shed(w, r, route)
+				return
+			}
+		}
+		defer func() {
+			for _, sem := range slots {
+				<-sem
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Shedder) shed(w http.ResponseWriter, r *http.Request, route string) {
+	metrics.RequestsShed.Add(1)
+	zerolog.Ctx(r.Context()).Warn().Str("route", route).Msg("request shed, server overloaded")
+
+	w.Header().Set("Retry-After", s.retryAfter)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	resp := store.Response{Message: "server overloaded, retry later", StatusCode: store.StatusOverloaded}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,87 @@
+package loadshed
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New(Config{})
+	require.NoError(t, err)
+	assert.Nil(t, s)
+
+	for _, cfg := range []Config{
+		{MaxInFlight: -1},
+		{Routes: map[string]int{"listKeys": 0}},
+	} {
+		_, err := New(cfg)
+		assert.Error(t, err, cfg)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	s, err := New(Config{MaxInFlight: 2, Routes: map[string]int{"listKeys": 1}, RetryAfter: 1500 * time.Millisecond})
+	require.NoError(t, err)
+
+	// Requests block until released, holding their slots.
+	release := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release })
+	serve := func(h http.Handler) chan *httptest.ResponseRecorder {
+		done := make(chan *httptest.ResponseRecorder, 1)
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			done <- rec
+		}()
+		return done
+	}
+	shed := func(t *testing.T, h http.Handler) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Equal(t, store.StatusOverloaded, resp.StatusCode)
+	}
+
+	listKeys := s.Limit("listKeys", true, blocking)
+	getKey := s.Limit("getKey", true, blocking)
+	websocket := s.Limit("websocket", false, blocking)
+
+	first := serve(listKeys)
+	require.Eventually(t, func() bool { return len(s.routes["listKeys"]) == 1 }, time.Second, time.Millisecond)
+	shed(t, listKeys)
+	assert.Len(t, s.global, 1, "shed requests release the slots they acquired")
+
+	second := serve(getKey)
+	require.Eventually(t, func() bool { return len(s.global) == 2 }, time.Second, time.Millisecond)
+	shed(t, getKey)
+
+	// Routes outside the global limit are still served.
+	third := serve(websocket)
+
+	close(release)
+	for _, done := range []chan *httptest.ResponseRecorder{first, second, third} {
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+	}
+	assert.Empty(t, s.global)
+	assert.Empty(t, s.routes["listKeys"])
+}
+
+func TestNilShedder(t *testing.T) {
+	var s *Shedder
+	rec := httptest.NewRecorder()
+	s.Limit("getKey", true, http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Empty(t, s.Routes())
+}
@@ -0,0 +1,70 @@
+// Package maintenance switches the store to read-only at runtime.
+//
+// While the Switch is enabled, the store operations modifying keys fail
+// through every protocol and reads keep being served, for instance during
+// backend migrations and backups. The switch is local to the process: in
+// clustered mode it is set on the nodes serving writes. A pinned switch
+// stays enabled, for the replicas never serving writes.
+package maintenance
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Switch is the maintenance mode of the store. The methods of a nil Switch
+// report it disabled.
+type Switch struct {
+	// since is the Unix time in nanoseconds maintenance started at, zero
+	// when disabled.
+	since atomic.Int64
+	// pinned keeps maintenance enabled.
+	pinned atomic.Bool
+}
+
+// New returns a switch, enabled if enabled is set.
+func New(enabled bool) *Switch {
+	s := &Switch{}
+	s.Set(enabled)
+	return s
+}
+
+// Enabled reports whether the store is in maintenance.
+func (s *Switch) Enabled() bool {
+	return s != nil && s.since.Load() != 0
+}
+
+// Since returns the time maintenance started at, zero when disabled.
+func (s *Switch) Since() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	if since := s.since.Load(); since != 0 {
+		return time.Unix(0, since)
+	}
+	return time.Time{}
+}
+
+// Pin enables maintenance for good, Set no longer disabling it.
+func (s *Switch) Pin() {
+	s.pinned.Store(true)
+	s.Set(true)
+}
+
+// Pinned reports whether maintenance is pinned.
+func (s *Switch) Pinned() bool {
+	return s != nil && s.pinned.Load()
+}
+
+// Set enables or disables maintenance, keeping the start time of a switch
+// already enabled. A pinned switch isn't disabled.
+func (s *Switch) Set(enabled bool) {
+	if !enabled {
+		if s.pinned.Load() {
+			return
+		}
+		s.since.Store(0)
+		return
+	}
+	s.since.CompareAndSwap(0, time.Now().UnixNano())
+}
@@ -0,0 +1,35 @@
+package maintenance
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSwitch(t *testing.T) {
+	var disabled *Switch
+	assert.False(t, disabled.Enabled())
+	assert.True(t, disabled.Since().IsZero())
+
+	s := New(false)
+	assert.False(t, s.Enabled())
+
+	s.Set(true)
+	assert.True(t, s.Enabled())
+	since := s.Since()
+	assert.False(t, since.IsZero())
+	s.Set(true)
+	assert.Equal(t, since, s.Since())
+
+	s.Set(false)
+	assert.False(t, s.Enabled())
+	assert.True(t, s.Since().IsZero())
+	assert.True(t, New(true).Enabled())
+
+	assert.False(t, disabled.Pinned())
+	s.Pin()
+	assert.True(t, s.Enabled())
+	assert.True(t, s.Pinned())
+	s.Set(false)
+	assert.True(t, s.Enabled(), "a pinned switch stays enabled")
+}
@@ -0,0 +1,231 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"codesignal/internal/cluster"
+	"codesignal/internal/store"
+)
+
+const (
+	// maxRelativeExptime is the largest exptime interpreted as seconds from
+	// now, larger values are unix timestamps.
+	maxRelativeExptime = 30 * 24 * 60 * 60
+	// maxItemSize caps the data block read for set, larger values are
+	// rejected by the store anyway when it has a value size limit.
+	maxItemSize = 64 << 20
+	// flushBatchSize is the number of keys deleted per scan by flush_all.
+	flushBatchSize = 1000
+)
+
+// exec runs the command in args and writes its reply. It reports false
+// when the connection must be closed, either because the client quit or
+// because the stream can't be resynchronized.
+func (s *Server) exec(ctx context.Context, r *bufio.Reader, w *bufio.Writer, args []string) bool {
+	if len(args) == 0 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return true
+	}
+
+	switch args[0] {
+	case "get":
+		s.get(ctx, w, args)
+	case "set":
+		return s.set(ctx, r, w, args)
+	case "delete":
+		s.delete(ctx, w, args)
+	case "flush_all":
+		s.flushAll(ctx, w, args)
+	case "version":
+		_, _ = w.WriteString("VERSION key-value-store\r\n")
+	case "quit":
+		return false
+	default:
+		_, _ = w.WriteString("ERROR\r\n")
+	}
+	return true
+}
+
+// noreply strips the optional trailing noreply argument.
+func noreply(args []string) ([]string, bool) {
+	if len(args) > 0 && args[len(args)-1] == "noreply" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+// get implements get <key>*.
+func (s *Server) get(ctx context.Context, w *bufio.Writer, args []string) {
+	if len(args) < 2 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return
+	}
+
+	for _, key := range args[1:] {
+		value, err := s.svc.Get(ctx, key)
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		_, _ = w.WriteString("VALUE " + key + " 0 " + strconv.Itoa(len(value)) + "\r\n")
+		_, _ = w.Write(value)
+		_, _ = w.WriteString("\r\n")
+	}
+	_, _ = w.WriteString("END\r\n")
+}
+
+// set implements set <key> <flags> <exptime> <bytes> [noreply], followed
+// by the data block. A negative exptime, or a timestamp in the past,
+// stores an already expired item, i.e. removes the key.
+func (s *Server) set(ctx context.Context, r *bufio.Reader, w *bufio.Writer, args []string) bool {
+	args, quiet := noreply(args)
+	if len(args) != 5 {
+		_, _ = w.WriteString("ERROR\r\n")
+		return true
+	}
+
+	_, flagsErr := strconv.ParseUint(args[2], 10, 32)
+	exptime, exptimeErr := strconv.ParseInt(args[3], 10, 64)
+	size, sizeErr := strconv.Atoi(args[4])
+	if flagsErr != nil || exptimeErr != nil || sizeErr != nil || size < 0 || size > maxItemSize {
+		// The size of the data block is unknown, so the connection can't be
+		// resynchronized.
+		_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return false
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return false
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		_, _ = w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return false
+	}
+
+	key, value := args[1], data[:size]
+	var ttl time.Duration
+	switch {
+	case exptime < 0:
+		ttl = -1
+	case exptime > maxRelativeExptime:
+		ttl = time.Until(time.Unix(exptime, 0))
+		if ttl <= 0 {
+			ttl = -1
+		}
+	case exptime > 0:
+		ttl = time.Duration(exptime) * time.Second
+	}
+
+	var err error
+	if ttl < 0 {
+		if err = s.svc.Delete(ctx, key); errors.Is(err, store.ErrKeyNotFound) {
+			err = nil
+		}
+	} else {
+		err = s.svc.Set(ctx, key, value, ttl)
+	}
+
+	switch {
+	case quiet:
+	case err != nil:
+		s.writeError(w, err)
+	default:
+		_, _ = w.WriteString("STORED\r\n")
+	}
+	return true
+}
+
+// delete implements delete <key> [0] [noreply], the 0 being the legacy
+// hold time.
+func (s *Server) delete(ctx context.Context, w *bufio.Writer, args []string) {
+	args, quiet := noreply(args)
+	if len(args) == 3 && args[2] == "0" {
+		args = args[:2]
+	}
+	if len(args) != 2 {
+		if !quiet {
+			_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		}
+		return
+	}
+
+	err := s.svc.Delete(ctx, args[1])
+	switch {
+	case quiet:
+	case errors.Is(err, store.ErrKeyNotFound):
+		_, _ = w.WriteString("NOT_FOUND\r\n")
+	case err != nil:
+		s.writeError(w, err)
+	default:
+		_, _ = w.WriteString("DELETED\r\n")
+	}
+}
+
+// flushAll implements flush_all [0] [noreply]. It removes every key of the
+// store, including keys written through the other APIs. Delayed flushes
+// aren't supported.
+func (s *Server) flushAll(ctx context.Context, w *bufio.Writer, args []string) {
+	args, quiet := noreply(args)
+	if len(args) > 2 || (len(args) == 2 && args[1] != "0") {
+		if !quiet {
+			_, _ = w.WriteString("CLIENT_ERROR delayed flush_all is not supported\r\n")
+		}
+		return
+	}
+
+	err := s.flush(ctx)
+	switch {
+	case quiet:
+	case err != nil:
+		s.writeError(w, err)
+	default:
+		_, _ = w.WriteString("OK\r\n")
+	}
+}
+
+func (s *Server) flush(ctx context.Context) error {
+	for {
+		items, err := s.svc.Scan(ctx, "", "", flushBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err := s.svc.Delete(ctx, item.Key); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+				return err
+			}
+		}
+		if len(items) < flushBatchSize {
+			return nil
+		}
+	}
+}
+
+// writeError replies with the error of a store operation.
+func (s *Server) writeError(w *bufio.Writer, err error) {
+	switch {
+	case errors.Is(err, store.ErrValueTooLarge):
+		_, _ = w.WriteString("SERVER_ERROR object too large for cache\r\n")
+	case errors.Is(err, store.ErrInvalidKey), errors.Is(err, store.ErrKeyTooLong):
+		_, _ = w.WriteString("CLIENT_ERROR bad command line format\r\n")
+	case errors.Is(err, store.ErrRejected):
+		_, _ = w.WriteString("CLIENT_ERROR " + err.Error() + "\r\n")
+	case errors.Is(err, store.ErrReadOnly), errors.Is(err, store.ErrMemoryLimit):
+		_, _ = w.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+	case errors.Is(err, cluster.ErrNotLeader):
+		_, _ = w.WriteString("SERVER_ERROR " + cluster.ErrNotLeader.Error() + "\r\n")
+	case errors.Is(err, cluster.ErrNoLeader):
+		_, _ = w.WriteString("SERVER_ERROR " + cluster.ErrNoLeader.Error() + "\r\n")
+	default:
+		s.log.Error().Err(err).Msg("store operation failed")
+		_, _ = w.WriteString("SERVER_ERROR storage error\r\n")
+	}
+}
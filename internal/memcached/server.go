@@ -0,0 +1,97 @@
+// Package memcached serves a subset of the memcached text protocol, so
+// applications configured for memcached can use the key-value store.
+//
+// The supported commands are get, set, delete and flush_all, plus version
+// and quit. Client flags are accepted on set but not stored, every value is
+// returned with flags 0. The commands run through the same store.Service as
+// the HTTP API.
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/server"
+	"codesignal/internal/store"
+)
+
+// Config holds the configuration of the memcached protocol listener.
+type Config struct {
+	// Enabled turns on the memcached protocol listener.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// Address is the address the listener accepts connections on.
+	Address string `envconfig:"ADDRESS" default:"0.0.0.0:11211"`
+}
+
+// maxLineLength caps the length of a command line, memcached keys are at
+// most 250 bytes.
+const maxLineLength = 8 * 1024
+
+// errLineTooLong is returned for command lines over maxLineLength.
+var errLineTooLong = errors.New("line too long")
+
+// Server accepts memcached protocol connections.
+type Server struct {
+	*server.TCPServer
+
+	log zerolog.Logger
+	svc *store.Service
+}
+
+// New returns a memcached protocol server for svc.
+func New(log zerolog.Logger, cfg Config, svc *store.Service) *Server {
+	s := &Server{
+		log: log.With().Str("component", "memcached").Logger(),
+		svc: svc,
+	}
+	s.TCPServer = server.NewTCPServer(s.log, "memcached", cfg.Address, s.handle)
+	return s
+}
+
+func (s *Server) handle(ctx context.Context, c *server.Conn) {
+	r, w := bufio.NewReaderSize(c, maxLineLength), bufio.NewWriter(c)
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				_, _ = w.WriteString("CLIENT_ERROR line too long\r\n")
+				_ = w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Debug().Err(err).Msg("failed to read command")
+			}
+			return
+		}
+
+		if !c.Begin() {
+			return
+		}
+		ok := s.exec(ctx, r, w, strings.Fields(line))
+		// Replies to pipelined commands are flushed together.
+		if !ok || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if !c.End() || !ok {
+			return
+		}
+	}
+}
+
+// readLine reads a line without its terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
@@ -0,0 +1,137 @@
+package memcached
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestClient(t *testing.T) (*client, *repository.KeyValueStore) {
+	t.Helper()
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+
+	srv := New(zerolog.Nop(), Config{}, store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8, MaxValueSize: 16}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.ServeListener(lis) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}, repo
+}
+
+// do sends raw and returns the next n reply lines joined by '|'.
+func (c *client) do(raw string, n int) string {
+	c.t.Helper()
+
+	_, err := c.conn.Write([]byte(raw))
+	require.NoError(c.t, err)
+
+	lines := make([]string, 0, n)
+	for range n {
+		line, err := c.r.ReadString('\n')
+		require.NoError(c.t, err)
+		lines = append(lines, strings.TrimSuffix(line, "\r\n"))
+	}
+	return strings.Join(lines, "|")
+}
+
+func TestServer(t *testing.T) {
+	c, repo := newTestClient(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		raw   string
+		lines int
+		want  string
+	}{
+		{name: "get missing", raw: "get a\r\n", lines: 1, want: "END"},
+		{name: "set", raw: "set a 5 0 5\r\nhello\r\n", lines: 1, want: "STORED"},
+		{name: "get", raw: "get a\r\n", lines: 3, want: "VALUE a 0 5|hello|END"},
+		{name: "set empty value", raw: "set b 0 0 0\r\n\r\n", lines: 1, want: "STORED"},
+		{name: "get multiple", raw: "get a missing b\r\n", lines: 5, want: "VALUE a 0 5|hello|VALUE b 0 0||END"},
+		{name: "set noreply", raw: "set c 0 0 1 noreply\r\nx\r\nget c\r\n", lines: 3, want: "VALUE c 0 1|x|END"},
+		{name: "set negative exptime removes key", raw: "set c 0 -1 1\r\nx\r\nget c\r\n", lines: 2, want: "STORED|END"},
+		{name: "set past timestamp removes key", raw: "set b 0 3000000 1\r\nx\r\nget b\r\n", lines: 2, want: "STORED|END"},
+		{name: "set key too long", raw: "set too-long-key 0 0 1\r\nx\r\n", lines: 1, want: "CLIENT_ERROR bad command line format"},
+		{name: "set value too large", raw: "set d 0 0 17\r\n01234567890123456\r\n", lines: 1, want: "SERVER_ERROR object too large for cache"},
+		{name: "set missing arguments", raw: "set d 0 0\r\n", lines: 1, want: "ERROR"},
+		{name: "delete", raw: "delete a\r\n", lines: 1, want: "DELETED"},
+		{name: "delete missing", raw: "delete a 0\r\n", lines: 1, want: "NOT_FOUND"},
+		{name: "delete noreply", raw: "set a 0 0 1\r\nx\r\ndelete a noreply\r\nget a\r\n", lines: 2, want: "STORED|END"},
+		{name: "delayed flush", raw: "flush_all 10\r\n", lines: 1, want: "CLIENT_ERROR delayed flush_all is not supported"},
+		{name: "version", raw: "version\r\n", lines: 1, want: "VERSION key-value-store"},
+		{name: "unknown command", raw: "gets a\r\n", lines: 1, want: "ERROR"},
+		{name: "empty line", raw: "\r\n", lines: 1, want: "ERROR"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.do(tt.raw, tt.lines))
+		})
+	}
+
+	t.Run("set exptime", func(t *testing.T) {
+		require.Equal(t, "STORED", c.do("set e 0 100 1\r\nx\r\n", 1))
+		expiresAt, exists, err := repo.Expiry(ctx, "e")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.WithinDuration(t, time.Now().Add(100*time.Second), expiresAt, time.Second)
+
+		timestamp := time.Now().Add(time.Hour).Unix()
+		require.Equal(t, "STORED", c.do("set e 0 "+strconv.FormatInt(timestamp, 10)+" 1\r\nx\r\n", 1))
+		expiresAt, _, err = repo.Expiry(ctx, "e")
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Unix(timestamp, 0), expiresAt, time.Second)
+	})
+
+	t.Run("flush_all", func(t *testing.T) {
+		for i := range 3 {
+			require.NoError(t, repo.Set(ctx, "k"+strconv.Itoa(i), []byte("v")))
+		}
+		assert.Equal(t, "OK", c.do("flush_all\r\n", 1))
+
+		items, err := repo.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+
+	t.Run("bad data chunk closes the connection", func(t *testing.T) {
+		assert.Equal(t, "CLIENT_ERROR bad data chunk", c.do("set a 0 0 1\r\nxyz\r\n", 1))
+		_, err := c.r.ReadByte()
+		assert.Error(t, err)
+	})
+}
+
+func TestServerQuit(t *testing.T) {
+	c, _ := newTestClient(t)
+
+	_, err := c.conn.Write([]byte("quit\r\n"))
+	require.NoError(t, err)
+	_, err = c.r.ReadByte()
+	assert.Error(t, err, "connection is closed")
+}
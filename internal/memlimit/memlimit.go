@@ -0,0 +1,131 @@
+// Package memlimit rejects the writes of the store past a soft memory
+// limit, before the kernel OOM-kills the process.
+//
+// A Guard samples the heap of the process every interval. Once the heap
+// grows past the soft limit, the operations adding keys or values fail
+// through every protocol, with a 503 and a Retry-After header over HTTP,
+// while reads and deletes keep being served so memory can be freed. Writes
+// resume once a sample finds the heap back under the limit.
+package memlimit
+
+import (
+	"context"
+	"math"
+	"runtime/metrics"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// heapMetric is the memory of the heap objects, live and not yet swept.
+const heapMetric = "/memory/classes/heap/objects:bytes"
+
+// Config holds the soft memory limit.
+type Config struct {
+	// SoftLimit is the size of the heap in bytes past which writes are
+	// rejected, 0 disables the limit.
+	SoftLimit int64 `envconfig:"SOFT_LIMIT"`
+	// CheckInterval is the time between two samples of the heap.
+	CheckInterval time.Duration `envconfig:"CHECK_INTERVAL" default:"1s"`
+	// RetryAfter is the delay rejected clients are told to wait, rounded up
+	// to the second.
+	RetryAfter time.Duration `envconfig:"RETRY_AFTER" default:"5s"`
+}
+
+// Guard tracks the heap against the soft limit. The methods of a nil Guard
+// report the limit never exceeded.
+type Guard struct {
+	log        zerolog.Logger
+	limit      uint64
+	interval   time.Duration
+	retryAfter string
+	// heap reads the size of the heap, replaced by tests.
+	heap     func() uint64
+	exceeded atomic.Bool
+
+	stop sync.Once
+	done chan struct{}
+}
+
+// New returns a guard of the limit of cfg, nil when disabled.
+func New(log zerolog.Logger, cfg Config) *Guard {
+	if cfg.SoftLimit <= 0 {
+		return nil
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = time.Second
+	}
+	return &Guard{
+		log:        log,
+		limit:      uint64(cfg.SoftLimit),
+		interval:   cfg.CheckInterval,
+		retryAfter: strconv.Itoa(int(math.Ceil(cfg.RetryAfter.Seconds()))),
+		heap:       readHeap,
+		done:       make(chan struct{}),
+	}
+}
+
+// readHeap returns the size of the heap.
+func readHeap() uint64 {
+	sample := []metrics.Sample{{Name: heapMetric}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// Exceeded reports whether the heap was past the limit when last sampled.
+func (g *Guard) Exceeded() bool {
+	return g != nil && g.exceeded.Load()
+}
+
+// RetryAfter returns the Retry-After header of the rejected writes.
+func (g *Guard) RetryAfter() string {
+	if g == nil {
+		return "0"
+	}
+	return g.retryAfter
+}
+
+// check samples the heap, logging the crossings of the limit.
+func (g *Guard) check() {
+	heap := g.heap()
+	exceeded := heap > g.limit
+	if g.exceeded.Swap(exceeded) == exceeded {
+		return
+	}
+	if exceeded {
+		g.log.Warn().Uint64("heap", heap).Uint64("limit", g.limit).Msg("soft memory limit exceeded, rejecting writes")
+	} else {
+		g.log.Info().Uint64("heap", heap).Uint64("limit", g.limit).Msg("back under the soft memory limit, accepting writes")
+	}
+}
+
+// Name implements server.Service.
+func (g *Guard) Name() string {
+	return "memlimit"
+}
+
+// Serve implements server.Service, sampling the heap every interval until
+// shut down.
+func (g *Guard) Serve() error {
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+
+	g.check()
+	for {
+		select {
+		case <-ticker.C:
+			g.check()
+		case <-g.done:
+			return nil
+		}
+	}
+}
+
+// Shutdown implements server.Service.
+func (g *Guard) Shutdown(context.Context) error {
+	g.stop.Do(func() { close(g.done) })
+	return nil
+}
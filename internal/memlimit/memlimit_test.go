@@ -0,0 +1,40 @@
+package memlimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuard(t *testing.T) {
+	var disabled *Guard
+	assert.False(t, disabled.Exceeded())
+	assert.Nil(t, New(zerolog.Nop(), Config{}))
+
+	g := New(zerolog.Nop(), Config{SoftLimit: 100, RetryAfter: 1500 * time.Millisecond})
+	require.NotNil(t, g)
+	assert.Equal(t, "2", g.RetryAfter())
+
+	heap := uint64(50)
+	g.heap = func() uint64 { return heap }
+	g.check()
+	assert.False(t, g.Exceeded())
+	heap = 101
+	g.check()
+	assert.True(t, g.Exceeded())
+	heap = 100
+	g.check()
+	assert.False(t, g.Exceeded(), "writes resume under the limit")
+
+	// The real heap is sampled until shut down.
+	g = New(zerolog.Nop(), Config{SoftLimit: 1, CheckInterval: time.Millisecond})
+	done := make(chan error)
+	go func() { done <- g.Serve() }()
+	assert.Eventually(t, g.Exceeded, time.Second, time.Millisecond)
+	require.NoError(t, g.Shutdown(context.Background()))
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+)
+
+// Histogram is an expvar.Var counting observations in cumulative buckets,
+// published as {"count": n, "sum": s, "buckets": {"le_16": n, ..., "le_inf": n}}
+// where each bucket counts the observations less than or equal to its bound.
+type Histogram struct {
+	bounds []int64
+	// counts has a count per bound plus one for larger observations.
+	counts []atomic.Int64
+	count  atomic.Int64
+	sum    atomic.Int64
+}
+
+// NewHistogram returns a histogram with buckets bounded by bounds, sorted
+// in increasing order.
+func NewHistogram(bounds ...int64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Int64, len(bounds)+1)}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v int64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(v)
+}
+
+// String implements expvar.Var.
+func (h *Histogram) String() string {
+	buckets := make(map[string]int64, len(h.counts))
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		name := "le_inf"
+		if i < len(h.bounds) {
+			name = "le_" + strconv.FormatInt(h.bounds[i], 10)
+		}
+		buckets[name] = cumulative
+	}
+	b, _ := json.Marshal(struct {
+		Count   int64            `json:"count"`
+		Sum     int64            `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}{h.count.Load(), h.sum.Load(), buckets})
+	return string(b)
+}
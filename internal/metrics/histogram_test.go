@@ -0,0 +1,26 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(10, 100)
+	for _, v := range []int64{0, 10, 11, 100, 1000} {
+		h.Observe(v)
+	}
+
+	var got struct {
+		Count   int64            `json:"count"`
+		Sum     int64            `json:"sum"`
+		Buckets map[string]int64 `json:"buckets"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(h.String()), &got))
+	assert.Equal(t, int64(5), got.Count)
+	assert.Equal(t, int64(1121), got.Sum)
+	assert.Equal(t, map[string]int64{"le_10": 2, "le_100": 4, "le_inf": 5}, got.Buckets)
+}
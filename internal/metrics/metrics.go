@@ -0,0 +1,113 @@
+// Package metrics exposes process-wide counters for the key-value store.
+//
+// Metrics are published through the standard library expvar package, so
+// they can be scraped as JSON from the handler returned by Handler without
+// pulling in an external metrics client. Histograms publish cumulative
+// bucket counts, with the count and sum of the observations.
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var (
+	// ExpiredKeys counts keys removed because their TTL elapsed.
+	ExpiredKeys = expvar.NewInt("kv_expired_keys_total")
+	// PurgedTombstones counts tombstones removed after their retention window.
+	PurgedTombstones = expvar.NewInt("kv_purged_tombstones_total")
+	// HintsStored counts writes buffered for an unavailable replica.
+	HintsStored = expvar.NewInt("kv_hints_stored_total")
+	// HintsReplayed counts buffered writes delivered to their replica.
+	HintsReplayed = expvar.NewInt("kv_hints_replayed_total")
+	// HintsDropped counts buffered writes discarded because they expired
+	// or the buffer of their replica was full.
+	HintsDropped = expvar.NewInt("kv_hints_dropped_total")
+	// BackupsCompleted counts the scheduled backups written.
+	BackupsCompleted = expvar.NewInt("kv_backups_completed_total")
+	// BackupsFailed counts the scheduled backups that failed.
+	BackupsFailed = expvar.NewInt("kv_backups_failed_total")
+	// WebhooksDelivered counts the changes delivered to webhooks.
+	WebhooksDelivered = expvar.NewInt("kv_webhooks_delivered_total")
+	// WebhooksFailed counts the changes whose delivery to a webhook failed
+	// every attempt or was rejected.
+	WebhooksFailed = expvar.NewInt("kv_webhooks_failed_total")
+	// WebhooksDropped counts the changes dropped because the queue of
+	// their webhook was full.
+	WebhooksDropped = expvar.NewInt("kv_webhooks_dropped_total")
+	// ChangesPublished counts the changes written to the change data
+	// capture sinks.
+	ChangesPublished = expvar.NewInt("kv_changes_published_total")
+	// ChangesFailed counts the changes a change data capture sink failed
+	// to write.
+	ChangesFailed = expvar.NewInt("kv_changes_failed_total")
+	// ChangesLogged counts the changes written to the change log.
+	ChangesLogged = expvar.NewInt("kv_changes_logged_total")
+	// ChangesMirrored counts the changes applied to the mirror target.
+	ChangesMirrored = expvar.NewInt("kv_changes_mirrored_total")
+	// MirrorFailures counts the batches of changes the mirror target failed
+	// to apply, retried.
+	MirrorFailures = expvar.NewInt("kv_mirror_failures_total")
+	// TierSpills counts the values spilled to the tier file, or dropped
+	// from memory when read from the storage engine.
+	TierSpills = expvar.NewInt("kv_tier_spills_total")
+	// TierLoads counts the cold values read from the tier file or the
+	// storage engine.
+	TierLoads = expvar.NewInt("kv_tier_loads_total")
+	// TierCompactions counts the rewrites of the tier file reclaiming the
+	// space of the values no longer in it.
+	TierCompactions = expvar.NewInt("kv_tier_compactions_total")
+	// LSMFlushes counts the memtables of the storage engine flushed to a
+	// table.
+	LSMFlushes = expvar.NewInt("kv_lsm_flushes_total")
+	// LSMCompactions counts the merges of the tables of the storage engine.
+	LSMCompactions = expvar.NewInt("kv_lsm_compactions_total")
+	// HotKeysCached counts the keys found hot and served from the hot
+	// cache.
+	HotKeysCached = expvar.NewInt("kv_hot_keys_cached_total")
+	// RequestsShed counts the HTTP requests rejected over a concurrency
+	// limit.
+	RequestsShed = expvar.NewInt("kv_requests_shed_total")
+	// PanicsRecovered counts the HTTP handlers that panicked.
+	PanicsRecovered = expvar.NewInt("kv_panics_recovered_total")
+	// DeprecatedRequests counts the HTTP requests served by the
+	// unversioned aliases of the /v1 routes.
+	DeprecatedRequests = expvar.NewInt("kv_deprecated_requests_total")
+	// ResponseCacheHits counts the reads of keys answered with a cached
+	// response.
+	ResponseCacheHits = expvar.NewInt("kv_response_cache_hits_total")
+	// NotModifiedResponses counts the conditional reads of keys answered
+	// 304 Not Modified.
+	NotModifiedResponses = expvar.NewInt("kv_not_modified_responses_total")
+	// FaultsInjected counts the operations of the store failed by the
+	// fault injection.
+	FaultsInjected = expvar.NewInt("kv_faults_injected_total")
+	// MemoryRejectedWrites counts the writes rejected past the soft memory
+	// limit.
+	MemoryRejectedWrites = expvar.NewInt("kv_memory_rejected_writes_total")
+	// TenantRequestsLimited counts the requests of tenants rejected over
+	// their rate limit.
+	TenantRequestsLimited = expvar.NewInt("kv_tenant_requests_limited_total")
+	// ReplicaRefreshes counts the snapshots loaded by a replica.
+	ReplicaRefreshes = expvar.NewInt("kv_replica_refreshes_total")
+	// ReplicaRefreshFailures counts the checks of the source of a replica
+	// that failed, its previous snapshot kept.
+	ReplicaRefreshFailures = expvar.NewInt("kv_replica_refresh_failures_total")
+	// ScheduledDeletes counts the keys deleted at their delete_at time.
+	ScheduledDeletes = expvar.NewInt("kv_scheduled_deletes_total")
+
+	// KeyLength is the histogram of the length of written keys, in bytes.
+	KeyLength = NewHistogram(8, 16, 32, 64, 128, 256, 512, 1024)
+	// ValueSize is the histogram of the size of written values, in bytes.
+	ValueSize = NewHistogram(64, 256, 1<<10, 4<<10, 16<<10, 64<<10, 256<<10, 1<<20, 4<<20)
+)
+
+func init() {
+	expvar.Publish("kv_key_length_bytes", KeyLength)
+	expvar.Publish("kv_value_size_bytes", ValueSize)
+}
+
+// Handler returns an HTTP handler serving all published metrics as JSON.
+func Handler() http.Handler {
+	return expvar.Handler()
+}
@@ -0,0 +1,117 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"codesignal/internal/cdc"
+	"codesignal/internal/rdb"
+	"codesignal/internal/store"
+)
+
+// restoreRequest is the payload of the restore endpoint.
+type restoreRequest struct {
+	Payload string `json:"payload"`
+	Replace bool   `json:"replace"`
+}
+
+// HTTP mirrors keys to another instance of the store, a Target.
+type HTTP struct {
+	client *http.Client
+	url    string
+	token  string
+}
+
+// NewHTTP returns the target mirroring keys to the instance at cfg.URL.
+func NewHTTP(cfg Config) (*HTTP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("mirror url %q must be an http or https url", cfg.URL)
+	}
+	return &HTTP{client: &http.Client{}, url: strings.TrimSuffix(cfg.URL, "/"), token: cfg.Token}, nil
+}
+
+// Name implements Target.
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+// Apply implements Target. Keys are written by restoring them, which
+// replaces them, and deleting a key missing from the instance succeeds.
+func (h *HTTP) Apply(ctx context.Context, changes []cdc.Record) error {
+	for _, c := range changes {
+		var err error
+		if set(c) {
+			err = h.restore(ctx, c.Key, *c.Value)
+		} else {
+			err = h.delete(ctx, c.Key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", c.Op, c.Key, err)
+		}
+	}
+	return nil
+}
+
+// Close implements Target.
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
+
+func (h *HTTP) restore(ctx context.Context, key, value string) error {
+	body, err := json.Marshal(restoreRequest{Payload: base64.StdEncoding.EncodeToString(rdb.EncodeDump([]byte(value))), Replace: true})
+	if err != nil {
+		return err
+	}
+	_, err = h.do(ctx, http.MethodPost, "/v1/key/"+url.PathEscape(key)+"/restore", body)
+	return err
+}
+
+func (h *HTTP) delete(ctx context.Context, key string) error {
+	// Keys holding slashes are addressed in base64url.
+	path := "/v1/key/" + url.PathEscape(key)
+	if strings.Contains(key, "/") {
+		path = "/v1/key/b64/" + base64.RawURLEncoding.EncodeToString([]byte(key))
+	}
+	code, err := h.do(ctx, http.MethodDelete, path, nil)
+	if code == store.StatusKeyNotFound {
+		return nil
+	}
+	return err
+}
+
+// do sends a request and returns the status code of its response, an error
+// unless it succeeded.
+func (h *HTTP) do(ctx context.Context, method, path string, body []byte) (store.StatusCode, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var r store.Response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return 0, fmt.Errorf("invalid response, status %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return r.StatusCode, fmt.Errorf("%s (status code %d)", r.Message, r.StatusCode)
+	}
+	return r.StatusCode, nil
+}
@@ -0,0 +1,56 @@
+package mirror_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/cdc"
+	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/mirror"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+	"codesignal/pkg/client"
+)
+
+func TestHTTP(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(16)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+	srv := httptest.NewServer(router.New(zerolog.Nop(), repo, &config.Config{}, router.Opts{Events: bus}))
+	t.Cleanup(srv.Close)
+	c, err := client.New(srv.URL, client.Opts{})
+	require.NoError(t, err)
+	require.NoError(t, c.Set(ctx, "b", "stale", 0))
+	require.NoError(t, c.Set(ctx, "c", "stale", 0))
+	require.NoError(t, c.Set(ctx, "dir/c", "stale", 0))
+
+	one, two := "1", "2"
+	changes := []cdc.Record{
+		{Change: cdc.Change{Seq: 1, Op: cdc.OpCreate, Key: "a"}, Value: &one},
+		{Change: cdc.Change{Seq: 2, Op: cdc.OpUpdate, Key: "b"}, Value: &two},
+		{Change: cdc.Change{Seq: 3, Op: cdc.OpDelete, Key: "c"}},
+		{Change: cdc.Change{Seq: 4, Op: cdc.OpExpire, Key: "dir/c"}},
+	}
+	target, err := mirror.NewHTTP(mirror.Config{URL: srv.URL})
+	require.NoError(t, err)
+	require.NoError(t, target.Apply(ctx, changes))
+	require.NoError(t, target.Apply(ctx, changes), "changes are idempotent")
+
+	value, err := c.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "1", value)
+	value, err = c.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", value)
+	_, err = c.Get(ctx, "c")
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+	_, err = c.Get(ctx, "dir/c")
+	assert.ErrorIs(t, err, client.ErrKeyNotFound)
+}
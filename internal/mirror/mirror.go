@@ -0,0 +1,254 @@
+// Package mirror mirrors the keys of the store to an external system:
+// another instance of the store, Redis or a SQL table.
+//
+// A Worker tails the change log as the mirror consumer. It applies the
+// changes to a Target in batches and commits its cursor once a batch is
+// applied, so mirroring resumes where it stopped after failures and
+// restarts. Changes are applied at least once and a batch holds a single
+// change per key, the last. Mirroring is one way: the changes made to the
+// target aren't mirrored back, and mirrored keys don't expire on their own,
+// their expiration is mirrored as a deletion.
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/cdc"
+	"codesignal/internal/metrics"
+)
+
+// Consumer is the change log consumer of the worker.
+const Consumer = "mirror"
+
+// inactivePoll is the interval a worker of a node that doesn't mirror
+// checks whether it became active.
+const inactivePoll = time.Second
+
+// Config configures the mirroring of the keys to an external system.
+type Config struct {
+	// Target is the system keys are mirrored to, http for another instance
+	// of the store, redis or sql. Empty disables mirroring.
+	Target string `envconfig:"TARGET"`
+	// URL locates the target: the base URL of the instance, a redis:// URL
+	// or the data source name of a PostgreSQL database.
+	URL string `envconfig:"URL"`
+	// Token is the bearer token authenticating to the instance.
+	Token string `envconfig:"TOKEN"`
+	// Table is the SQL table keys are mirrored to, created when missing.
+	Table string `envconfig:"TABLE" default:"kv_mirror"`
+	// Prefix limits mirroring to the keys starting with it.
+	Prefix string `envconfig:"PREFIX"`
+	// BatchSize bounds the number of changes applied at once.
+	BatchSize int `envconfig:"BATCH_SIZE" default:"500"`
+	// Timeout bounds the application of a batch.
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"10s"`
+	// Backoff is the delay before a failed batch is retried, doubled on
+	// each failure up to MaxBackoff.
+	Backoff    time.Duration `envconfig:"BACKOFF" default:"1s"`
+	MaxBackoff time.Duration `envconfig:"MAX_BACKOFF" default:"1m"`
+}
+
+// Target is a system keys are mirrored to.
+type Target interface {
+	// Name identifies the target in logs.
+	Name() string
+	// Apply sets the keys of the created and updated changes and deletes
+	// the others. changes hold at most one change per key.
+	Apply(ctx context.Context, changes []cdc.Record) error
+	// Close releases the target.
+	Close() error
+}
+
+// Worker mirrors the change log to a target, a server.Service.
+type Worker struct {
+	log     zerolog.Logger
+	cfg     Config
+	target  Target
+	changes *cdc.ChangeLog
+	active  func() bool
+
+	done    chan struct{}
+	stop    sync.Once
+	stopped chan struct{}
+}
+
+// New returns the worker mirroring changes to the target configured by cfg,
+// nil without target. Changes are only mirrored while active returns true,
+// so a single node of a cluster mirrors them; nil mirrors them always.
+func New(log zerolog.Logger, cfg Config, changes *cdc.ChangeLog, active func() bool) (*Worker, error) {
+	if cfg.Target == "" {
+		return nil, nil
+	}
+	if changes == nil {
+		return nil, errors.New("mirroring requires the change log, set CHANGELOG_DIR")
+	}
+	var (
+		target Target
+		err    error
+	)
+	switch cfg.Target {
+	case "http":
+		target, err = NewHTTP(cfg)
+	case "redis":
+		target, err = NewRedis(cfg)
+	case "sql":
+		target, err = NewSQL(cfg)
+	default:
+		return nil, fmt.Errorf("unknown mirror target %q", cfg.Target)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewWorker(log, cfg, target, changes, active), nil
+}
+
+// NewWorker returns the worker mirroring the changes of changes to target.
+func NewWorker(log zerolog.Logger, cfg Config, target Target, changes *cdc.ChangeLog, active func() bool) *Worker {
+	return &Worker{
+		log:     log.With().Str("component", "mirror").Str("target", target.Name()).Logger(),
+		cfg:     cfg,
+		target:  target,
+		changes: changes,
+		active:  active,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// Name implements server.Service.
+func (w *Worker) Name() string {
+	return "mirror"
+}
+
+// Serve implements server.Service, mirroring changes until shut down.
+func (w *Worker) Serve() error {
+	defer close(w.stopped)
+
+	after := w.changes.Expired()
+	if c, err := w.changes.Cursor(Consumer); err == nil {
+		after = c.Seq
+	}
+	backoff := w.cfg.Backoff
+	for {
+		if w.active != nil && !w.active() {
+			if !w.sleep(inactivePoll) {
+				return nil
+			}
+			continue
+		}
+
+		logged := w.changes.Logged()
+		next, err := w.mirror(after)
+		switch {
+		case errors.Is(err, cdc.ErrExpired):
+			expired := w.changes.Expired()
+			w.log.Error().Uint64("cursor", after).Uint64("expired", expired).Msg("changes removed by retention before they were mirrored, the target may be out of sync")
+			after = expired
+			continue
+		case err != nil:
+			metrics.MirrorFailures.Add(1)
+			w.log.Error().Err(err).Uint64("cursor", after).Dur("backoff", backoff).Msg("failed to mirror changes")
+			if !w.sleep(backoff) {
+				return nil
+			}
+			backoff = min(2*backoff, w.cfg.MaxBackoff)
+			continue
+		}
+		backoff = w.cfg.Backoff
+		if next != after {
+			after = next
+			continue
+		}
+		if !w.wait(logged) {
+			return nil
+		}
+	}
+}
+
+// Shutdown implements server.Service, closing the target once the batch in
+// progress is applied.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.stop.Do(func() { close(w.done) })
+	select {
+	case <-w.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return w.target.Close()
+}
+
+// wait waits for ready, false when the worker is shut down first.
+func (w *Worker) wait(ready <-chan struct{}) bool {
+	select {
+	case <-ready:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// sleep waits for d, false when the worker is shut down first.
+func (w *Worker) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// mirror applies the batch of changes following after and commits it,
+// returning the sequence of the last change mirrored.
+func (w *Worker) mirror(after uint64) (uint64, error) {
+	records, err := w.changes.Read(after, w.cfg.BatchSize)
+	if err != nil || len(records) == 0 {
+		return after, err
+	}
+	last := records[len(records)-1].Seq
+
+	if batch := coalesce(records, w.cfg.Prefix); len(batch) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), w.cfg.Timeout)
+		defer cancel()
+		if err := w.target.Apply(ctx, batch); err != nil {
+			return after, err
+		}
+		metrics.ChangesMirrored.Add(int64(len(batch)))
+	}
+	if _, err := w.changes.Commit(Consumer, last); err != nil {
+		// The changes are mirrored again after a restart, which is harmless.
+		w.log.Warn().Err(err).Uint64("cursor", last).Msg("failed to commit the mirror cursor")
+	}
+	return last, nil
+}
+
+// coalesce returns the last change of each key of records starting with
+// prefix, in the order of those changes.
+func coalesce(records []cdc.Record, prefix string) []cdc.Record {
+	last := make(map[string]int, len(records))
+	for i, r := range records {
+		if strings.HasPrefix(r.Key, prefix) {
+			last[r.Key] = i
+		}
+	}
+	batch := make([]cdc.Record, 0, len(last))
+	for i, r := range records {
+		if j, ok := last[r.Key]; ok && i == j {
+			batch = append(batch, r)
+		}
+	}
+	return batch
+}
+
+// set reports whether r sets its key rather than removing it.
+func set(r cdc.Record) bool {
+	return (r.Op == cdc.OpCreate || r.Op == cdc.OpUpdate) && r.Value != nil
+}
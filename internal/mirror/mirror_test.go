@@ -0,0 +1,129 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/cdc"
+	"codesignal/internal/events"
+)
+
+// memoryTarget mirrors keys to a map, failing the first fail batches.
+type memoryTarget struct {
+	mu     sync.Mutex
+	keys   map[string]string
+	fail   int
+	closed bool
+}
+
+func (m *memoryTarget) Name() string {
+	return "memory"
+}
+
+func (m *memoryTarget) Apply(_ context.Context, changes []cdc.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("unavailable")
+	}
+	for _, c := range changes {
+		if set(c) {
+			m.keys[c.Key] = *c.Value
+		} else {
+			delete(m.keys, c.Key)
+		}
+	}
+	return nil
+}
+
+func (m *memoryTarget) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memoryTarget) snapshot() map[string]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make(map[string]string, len(m.keys))
+	for k, v := range m.keys {
+		keys[k] = v
+	}
+	return keys
+}
+
+func TestNew(t *testing.T) {
+	w, err := New(zerolog.Nop(), Config{}, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, w, "disabled without target")
+
+	_, err = New(zerolog.Nop(), Config{Target: "redis", URL: "redis://localhost:6379"}, nil, nil)
+	assert.ErrorContains(t, err, "CHANGELOG_DIR")
+}
+
+func TestWorker(t *testing.T) {
+	bus := events.NewBus(0)
+	changes, err := cdc.OpenChangeLog(zerolog.Nop(), cdc.ChangeLogConfig{Dir: t.TempDir(), CompactInterval: time.Hour}, bus)
+	require.NoError(t, err)
+	go func() { _ = changes.Serve() }()
+	t.Cleanup(func() { _ = changes.Shutdown(context.Background()) })
+
+	// The change log subscribes asynchronously, events are published until
+	// the first one is logged. They are outside of the mirrored prefix.
+	require.Eventually(t, func() bool {
+		bus.Publish(events.Event{Type: events.TypeSet, Key: "ready", Value: []byte("1")})
+		return changes.Last() > 0
+	}, 5*time.Second, time.Millisecond)
+
+	target := &memoryTarget{keys: map[string]string{"app:b": "stale"}, fail: 1}
+	cfg := Config{Prefix: "app:", BatchSize: 2, Timeout: time.Second, Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+	w := NewWorker(zerolog.Nop(), cfg, target, changes, nil)
+	served := make(chan error, 1)
+	go func() { served <- w.Serve() }()
+
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "app:a", Value: []byte("1"), Created: true})
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "app:a", Value: []byte("2")})
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "app:c", Value: []byte("3"), Created: true})
+	bus.Publish(events.Event{Type: events.TypeDelete, Key: "app:b"})
+	bus.Publish(events.Event{Type: events.TypeSet, Key: "other", Value: []byte("4"), Created: true})
+	bus.Publish(events.Event{Type: events.TypeExpire, Key: "app:c"})
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual(map[string]string{"app:a": "2"}, target.snapshot())
+	}, 5*time.Second, time.Millisecond, "failed batches are retried")
+	require.Eventually(t, func() bool {
+		c, err := changes.Cursor(Consumer)
+		return err == nil && c.Seq == changes.Last()
+	}, 5*time.Second, time.Millisecond, "the cursor is committed once the changes are mirrored")
+
+	require.NoError(t, w.Shutdown(context.Background()))
+	require.NoError(t, <-served)
+	assert.True(t, target.closed)
+}
+
+func TestCoalesce(t *testing.T) {
+	value := func(v string) *string { return &v }
+	records := []cdc.Record{
+		{Change: cdc.Change{Seq: 1, Op: cdc.OpCreate, Key: "a"}, Value: value("1")},
+		{Change: cdc.Change{Seq: 2, Op: cdc.OpCreate, Key: "b"}, Value: value("2")},
+		{Change: cdc.Change{Seq: 3, Op: cdc.OpUpdate, Key: "a"}, Value: value("3")},
+		{Change: cdc.Change{Seq: 4, Op: cdc.OpDelete, Key: "b"}},
+		{Change: cdc.Change{Seq: 5, Op: cdc.OpCreate, Key: "x"}, Value: value("5")},
+	}
+	batch := coalesce(records, "")
+	require.Len(t, batch, 3)
+	assert.Equal(t, []uint64{3, 4, 5}, []uint64{batch[0].Seq, batch[1].Seq, batch[2].Seq})
+
+	batch = coalesce(records, "a")
+	require.Len(t, batch, 1)
+	assert.Equal(t, uint64(3), batch[0].Seq)
+}
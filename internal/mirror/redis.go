@@ -0,0 +1,51 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"codesignal/internal/cdc"
+)
+
+// Redis mirrors keys to a Redis database, a Target.
+type Redis struct {
+	client *redis.Client
+}
+
+// NewRedis returns the target mirroring keys to the database of the
+// redis:// or rediss:// URL cfg.URL.
+func NewRedis(cfg Config) (*Redis, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("mirror url: %w", err)
+	}
+	return &Redis{client: redis.NewClient(opts)}, nil
+}
+
+// Name implements Target.
+func (r *Redis) Name() string {
+	return "redis"
+}
+
+// Apply implements Target, in a single MULTI/EXEC transaction.
+func (r *Redis) Apply(ctx context.Context, changes []cdc.Record) error {
+	tx := r.client.TxPipeline()
+	for _, c := range changes {
+		if set(c) {
+			tx.Set(ctx, c.Key, *c.Value, 0)
+		} else {
+			tx.Del(ctx, c.Key)
+		}
+	}
+	if _, err := tx.Exec(ctx); err != nil {
+		return fmt.Errorf("write to redis: %w", err)
+	}
+	return nil
+}
+
+// Close implements Target.
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
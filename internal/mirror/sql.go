@@ -0,0 +1,90 @@
+package mirror
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	// Registers the postgres driver.
+	_ "github.com/lib/pq"
+
+	"codesignal/internal/cdc"
+)
+
+// tablePattern matches the table names, optionally qualified by a schema,
+// that don't need quoting.
+var tablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQL mirrors keys to a PostgreSQL table, a Target. The table has a key
+// primary key, a value and the time of the change of its row.
+type SQL struct {
+	db      *sql.DB
+	create  string
+	upsert  string
+	delete  string
+	created bool
+}
+
+// NewSQL returns the target mirroring keys to the table cfg.Table of the
+// database of the data source name cfg.URL.
+func NewSQL(cfg Config) (*SQL, error) {
+	return newSQL(cfg, "postgres")
+}
+
+func newSQL(cfg Config, driver string) (*SQL, error) {
+	if !tablePattern.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("invalid mirror table %q", cfg.Table)
+	}
+	db, err := sql.Open(driver, cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("mirror url: %w", err)
+	}
+	return &SQL{
+		db:     db,
+		create: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at TIMESTAMPTZ NOT NULL)", cfg.Table),
+		upsert: fmt.Sprintf("INSERT INTO %s (key, value, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at", cfg.Table),
+		delete: fmt.Sprintf("DELETE FROM %s WHERE key = $1", cfg.Table),
+	}, nil
+}
+
+// Name implements Target.
+func (s *SQL) Name() string {
+	return "sql"
+}
+
+// Apply implements Target, in a single transaction. The table is created
+// by the first batch.
+func (s *SQL) Apply(ctx context.Context, changes []cdc.Record) error {
+	if !s.created {
+		if _, err := s.db.ExecContext(ctx, s.create); err != nil {
+			return fmt.Errorf("create mirror table: %w", err)
+		}
+		s.created = true
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, c := range changes {
+		if set(c) {
+			_, err = tx.ExecContext(ctx, s.upsert, c.Key, *c.Value, c.Time)
+		} else {
+			_, err = tx.ExecContext(ctx, s.delete, c.Key)
+		}
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", c.Op, c.Key, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Close implements Target.
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,70 @@
+package mirror
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/cdc"
+)
+
+// changes returns a batch creating a, updating b and deleting c.
+func changes() []cdc.Record {
+	one, two := "1", "2"
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	return []cdc.Record{
+		{Change: cdc.Change{Seq: 1, Op: cdc.OpCreate, Key: "a", Time: at}, Value: &one},
+		{Change: cdc.Change{Seq: 2, Op: cdc.OpUpdate, Key: "b", Time: at}, Value: &two},
+		{Change: cdc.Change{Seq: 3, Op: cdc.OpDelete, Key: "c", Time: at}},
+	}
+}
+
+func TestRedis(t *testing.T) {
+	ctx := context.Background()
+	srv := miniredis.RunT(t)
+	require.NoError(t, srv.Set("c", "stale"))
+
+	target, err := NewRedis(Config{URL: "redis://" + srv.Addr()})
+	require.NoError(t, err)
+	defer target.Close()
+	require.NoError(t, target.Apply(ctx, changes()))
+
+	srv.CheckGet(t, "a", "1")
+	srv.CheckGet(t, "b", "2")
+	assert.False(t, srv.Exists("c"))
+
+	_, err = NewRedis(Config{URL: "localhost:6379"})
+	assert.Error(t, err)
+}
+
+func TestSQL(t *testing.T) {
+	db, mock, err := sqlmock.NewWithDSN(t.Name(), sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	target, err := newSQL(Config{URL: t.Name(), Table: "public.kv_mirror"}, "sqlmock")
+	require.NoError(t, err)
+	defer target.Close()
+
+	at := changes()[0].Time
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.kv_mirror (key TEXT PRIMARY KEY, value TEXT NOT NULL, updated_at TIMESTAMPTZ NOT NULL)").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for range 2 {
+		mock.ExpectBegin()
+		upsert := "INSERT INTO public.kv_mirror (key, value, updated_at) VALUES ($1, $2, $3) ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at"
+		mock.ExpectExec(upsert).WithArgs("a", "1", at).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(upsert).WithArgs("b", "2", at).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM public.kv_mirror WHERE key = $1").WithArgs("c").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+	require.NoError(t, target.Apply(context.Background(), changes()))
+	require.NoError(t, target.Apply(context.Background(), changes()), "the table is created once")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	_, err = NewSQL(Config{Table: "kv; DROP TABLE users"})
+	assert.ErrorContains(t, err, "invalid mirror table")
+}
@@ -0,0 +1,271 @@
+// Package openapi generates the OpenAPI 3 document of the HTTP API.
+//
+// Routes are described next to their registration, with the Go types of
+// their request and response bodies. New derives the paths of the document
+// from the routes and the schemas of the bodies from their types, so the
+// document follows the code instead of being maintained by hand. Handler
+// serves the document and UIHandler a Swagger UI page browsing it.
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the OpenAPI version of the generated documents.
+const Version = "3.0.3"
+
+const contentTypeJSON = "application/json"
+
+type (
+	// Route describes an operation of the API.
+	Route struct {
+		// Method is the HTTP method and Path the path in the httprouter
+		// syntax, with :name parameters.
+		Method string
+		Path   string
+		// ID is the operationId, used by SDK generators to name methods.
+		ID          string
+		Summary     string
+		Description string
+		// Tag groups the operation with related ones.
+		Tag string
+		// Scope is the scope clients need to call the route when
+		// authentication is enabled, empty for public routes.
+		Scope string
+		// Params are the query and header parameters, path parameters are
+		// derived from Path.
+		Params []Parameter
+		// Request is a value of the type of the JSON request body, nil
+		// without a body. OptionalRequest documents bodies that may be
+		// omitted.
+		Request         any
+		OptionalRequest bool
+		// RequestContentType documents a request body that isn't JSON, as
+		// a string of that content type, with Request nil.
+		RequestContentType string
+		// Responses are indexed by HTTP status code.
+		Responses map[int]Reply
+	}
+
+	// Reply describes a response of a route.
+	Reply struct {
+		Description string
+		// Body is a value of the type of the JSON body, nil without a body.
+		Body any
+		// ContentType overrides the content type of a body that isn't JSON,
+		// documented as a string.
+		ContentType string
+	}
+
+	// Info is the metadata of the API.
+	Info struct {
+		Title       string `json:"title"`
+		Description string `json:"description,omitempty"`
+		Version     string `json:"version"`
+	}
+
+	// Document is an OpenAPI document.
+	Document struct {
+		OpenAPI    string              `json:"openapi"`
+		Info       Info                `json:"info"`
+		Paths      map[string]PathItem `json:"paths"`
+		Components Components          `json:"components"`
+	}
+
+	// PathItem holds the operations of a path by lower case method.
+	PathItem map[string]*Operation
+
+	// Operation is an operation of a path.
+	Operation struct {
+		OperationID string                `json:"operationId,omitempty"`
+		Summary     string                `json:"summary,omitempty"`
+		Description string                `json:"description,omitempty"`
+		Tags        []string              `json:"tags,omitempty"`
+		Parameters  []Parameter           `json:"parameters,omitempty"`
+		RequestBody *RequestBody          `json:"requestBody,omitempty"`
+		Responses   map[string]Response   `json:"responses"`
+		Security    []map[string][]string `json:"security,omitempty"`
+		// Scope is the x-scope extension, the scope required by the
+		// operation.
+		Scope string `json:"x-scope,omitempty"`
+	}
+
+	// Parameter is a path, query or header parameter.
+	Parameter struct {
+		Name        string  `json:"name"`
+		In          string  `json:"in"`
+		Description string  `json:"description,omitempty"`
+		Required    bool    `json:"required,omitempty"`
+		Schema      *Schema `json:"schema"`
+	}
+
+	// RequestBody is the body of an operation.
+	RequestBody struct {
+		Required bool                 `json:"required"`
+		Content  map[string]MediaType `json:"content"`
+	}
+
+	// Response is a response of an operation.
+	Response struct {
+		Description string               `json:"description"`
+		Content     map[string]MediaType `json:"content,omitempty"`
+	}
+
+	// MediaType is the schema of a body.
+	MediaType struct {
+		Schema *Schema `json:"schema"`
+	}
+
+	// Components holds the schemas of the named types of bodies and the
+	// security schemes of protected operations.
+	Components struct {
+		Schemas         map[string]*Schema        `json:"schemas"`
+		SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+	}
+
+	// SecurityScheme is an authentication scheme of the API.
+	SecurityScheme struct {
+		Type         string `json:"type"`
+		Scheme       string `json:"scheme,omitempty"`
+		BearerFormat string `json:"bearerFormat,omitempty"`
+		Description  string `json:"description,omitempty"`
+	}
+)
+
+// Security schemes of routes with a scope, clients use either.
+const (
+	BearerAuth = "bearerAuth"
+	BasicAuth  = "basicAuth"
+)
+
+var securitySchemes = map[string]SecurityScheme{
+	BearerAuth: {
+		Type:         "http",
+		Scheme:       "bearer",
+		BearerFormat: "JWT",
+		Description: "A JWT granting the x-scope of the operation, possibly on a key prefix as in kv:write:team-a., " +
+			"in its scope or scp claim or through the roles of its roles claim.",
+	},
+	BasicAuth: {
+		Type:        "http",
+		Scheme:      "basic",
+		Description: "A user configured with the x-scope of the operation, possibly on a key prefix or through a role.",
+	},
+}
+
+// Query returns an optional query parameter of type typ, such as "string"
+// or "integer".
+func Query(name, typ, description string) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: &Schema{Type: typ}}
+}
+
+// Header returns an optional header parameter of type typ.
+func Header(name, typ, description string) Parameter {
+	return Parameter{Name: name, In: "header", Description: description, Schema: &Schema{Type: typ}}
+}
+
+// New generates the document of routes.
+func New(info Info, routes []Route) *Document {
+	doc := &Document{
+		OpenAPI:    Version,
+		Info:       info,
+		Paths:      make(map[string]PathItem),
+		Components: Components{Schemas: make(map[string]*Schema)},
+	}
+	g := generator{schemas: doc.Components.Schemas}
+
+	for _, route := range routes {
+		path, params := convertPath(route.Path)
+		op := &Operation{
+			OperationID: route.ID,
+			Summary:     route.Summary,
+			Description: route.Description,
+			Parameters:  append(params, route.Params...),
+			Responses:   make(map[string]Response, len(route.Responses)),
+		}
+		if route.Tag != "" {
+			op.Tags = []string{route.Tag}
+		}
+		if route.Scope != "" {
+			op.Security = []map[string][]string{{BearerAuth: {}}, {BasicAuth: {}}}
+			op.Scope = route.Scope
+			doc.Components.SecuritySchemes = securitySchemes
+		}
+		if route.Request != nil {
+			op.RequestBody = &RequestBody{
+				Required: !route.OptionalRequest,
+				Content:  map[string]MediaType{contentTypeJSON: {Schema: g.schema(reflect.TypeOf(route.Request))}},
+			}
+		} else if route.RequestContentType != "" {
+			op.RequestBody = &RequestBody{
+				Required: !route.OptionalRequest,
+				Content:  map[string]MediaType{route.RequestContentType: {Schema: &Schema{Type: "string"}}},
+			}
+		}
+		for status, reply := range route.Responses {
+			resp := Response{Description: reply.Description}
+			switch {
+			case reply.ContentType != "":
+				resp.Content = map[string]MediaType{reply.ContentType: {Schema: &Schema{Type: "string"}}}
+			case reply.Body != nil:
+				resp.Content = map[string]MediaType{contentTypeJSON: {Schema: g.schema(reflect.TypeOf(reply.Body))}}
+			}
+			op.Responses[strconv.Itoa(status)] = resp
+		}
+
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+// Operations returns the "METHOD /path" of the operations of the document,
+// sorted.
+func (d *Document) Operations() []string {
+	var ops []string
+	for path, item := range d.Paths {
+		for method := range item {
+			ops = append(ops, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// convertPath converts the :name parameters of an httprouter path to the
+// {name} of OpenAPI and returns them as path parameters.
+func convertPath(path string) (string, []Parameter) {
+	var params []Parameter
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		name, ok := strings.CutPrefix(segment, ":")
+		if !ok {
+			continue
+		}
+		segments[i] = "{" + name + "}"
+		params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// Handler serves the document as JSON.
+func Handler(doc *Document) http.Handler {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		// Documents only hold strings, maps and slices.
+		panic(err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", contentTypeJSON)
+		_, _ = w.Write(body)
+	})
+}
@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type (
+	node struct {
+		Name     string   `json:"name"`
+		Children []node   `json:"children,omitempty"`
+		Parent   *node    `json:"parent"`
+		Internal string   `json:"-"`
+		Tags     []string `json:"tags"`
+	}
+
+	base struct {
+		ID string `json:"id"`
+	}
+
+	item struct {
+		base
+		Data    []byte            `json:"data,omitempty"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Created time.Time         `json:"created"`
+		Count   int64             `json:"count"`
+		Ratio   float64           `json:"ratio"`
+		Extra   any               `json:"extra,omitempty"`
+		Plain   bool
+		private int
+	}
+)
+
+func TestNew(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"}, []Route{
+		{
+			Method: http.MethodPost, Path: "/items/:id/children", ID: "addChild",
+			Params:    []Parameter{Query("dry", "boolean", "")},
+			Request:   node{},
+			Responses: map[int]Reply{http.StatusCreated: {Description: "created", Body: item{}}},
+		},
+		{
+			Method: http.MethodGet, Path: "/items/:id/children", Scope: "items:read",
+			Responses: map[int]Reply{http.StatusOK: {Description: "listed", Body: []node{}}},
+		},
+		{
+			Method: http.MethodGet, Path: "/metrics",
+			Responses: map[int]Reply{http.StatusOK: {Description: "metrics", ContentType: "text/plain"}},
+		},
+	})
+
+	assert.Equal(t, []string{"GET /items/{id}/children", "GET /metrics", "POST /items/{id}/children"}, doc.Operations())
+
+	op := doc.Paths["/items/{id}/children"]["post"]
+	require.NotNil(t, op)
+	assert.Equal(t, "addChild", op.OperationID)
+	assert.Equal(t, []Parameter{
+		{Name: "id", In: "path", Required: true, Schema: &Schema{Type: "string"}},
+		{Name: "dry", In: "query", Schema: &Schema{Type: "boolean"}},
+	}, op.Parameters)
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/node"}, op.RequestBody.Content["application/json"].Schema)
+	assert.Equal(t, &Schema{Ref: "#/components/schemas/item"}, op.Responses["201"].Content["application/json"].Schema)
+	assert.Equal(t, &Schema{Type: "array", Items: &Schema{Ref: "#/components/schemas/node"}},
+		doc.Paths["/items/{id}/children"]["get"].Responses["200"].Content["application/json"].Schema)
+	assert.Equal(t, &Schema{Type: "string"}, doc.Paths["/metrics"]["get"].Responses["200"].Content["text/plain"].Schema)
+
+	get := doc.Paths["/items/{id}/children"]["get"]
+	assert.Equal(t, []map[string][]string{{BearerAuth: {}}, {BasicAuth: {}}}, get.Security)
+	assert.Equal(t, "items:read", get.Scope)
+	assert.Empty(t, op.Security)
+	assert.Contains(t, doc.Components.SecuritySchemes, BearerAuth)
+	assert.Contains(t, doc.Components.SecuritySchemes, BasicAuth)
+
+	assert.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"name":     {Type: "string"},
+			"children": {Type: "array", Items: &Schema{Ref: "#/components/schemas/node"}},
+			"parent":   {Ref: "#/components/schemas/node"},
+			"tags":     {Type: "array", Items: &Schema{Type: "string"}},
+		},
+		Required: []string{"name", "tags"},
+	}, doc.Components.Schemas["node"])
+	assert.Equal(t, &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"id":      {Type: "string"},
+			"data":    {Type: "string", Format: "byte"},
+			"labels":  {Type: "object", AdditionalProperties: &Schema{Type: "string"}},
+			"created": {Type: "string", Format: "date-time"},
+			"count":   {Type: "integer", Format: "int64"},
+			"ratio":   {Type: "number"},
+			"extra":   {},
+			"Plain":   {Type: "boolean"},
+		},
+		Required: []string{"id", "created", "count", "ratio", "Plain"},
+	}, doc.Components.Schemas["item"])
+}
+
+func TestHandler(t *testing.T) {
+	doc := New(Info{Title: "test", Version: "1"}, []Route{
+		{Method: http.MethodGet, Path: "/ping", Responses: map[int]Reply{http.StatusOK: {Description: "pong"}}},
+	})
+
+	rec := httptest.NewRecorder()
+	Handler(doc).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var served map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &served))
+	assert.Equal(t, Version, served["openapi"])
+	assert.Contains(t, served["paths"], "/ping")
+
+	rec = httptest.NewRecorder()
+	UIHandler("test", "/openapi.json").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Contains(t, rec.Body.String(), `url: "/openapi.json"`)
+}
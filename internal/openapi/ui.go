@@ -0,0 +1,38 @@
+package openapi
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// swaggerUIVersion is the version of the Swagger UI assets, loaded from a
+// CDN by the browser.
+const swaggerUIVersion = "5.17.14"
+
+var uiTemplate = template.Must(template.New("ui").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@{{.Version}}/swagger-ui-bundle.js" crossorigin></script>
+<script>
+window.onload = () => { window.ui = SwaggerUIBundle({ url: {{.SpecURL}}, dom_id: "#swagger-ui" }); };
+</script>
+</body>
+</html>
+`))
+
+// UIHandler serves a Swagger UI page browsing the document served at
+// specURL.
+func UIHandler(title, specURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = uiTemplate.Execute(w, struct {
+			Title, Version, SpecURL string
+		}{title, swaggerUIVersion, specURL})
+	})
+}
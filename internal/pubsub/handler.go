@@ -0,0 +1,148 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/store"
+)
+
+// heartbeatPeriod is how often an idle event stream is sent a comment, so
+// proxies don't close it.
+const heartbeatPeriod = 30 * time.Second
+
+// PublishRequest represents the payload publishing a message.
+type PublishRequest struct {
+	Message string `json:"message"`
+}
+
+// Handler serves the channel endpoints.
+type Handler struct {
+	log    zerolog.Logger
+	broker *Broker
+}
+
+// NewHandler returns the handler publishing and subscribing through broker.
+func NewHandler(log zerolog.Logger, broker *Broker) *Handler {
+	return &Handler{log: log, broker: broker}
+}
+
+// Publish publishes the message of the request body on the channel path
+// parameter.
+func (h *Handler) Publish(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	channel := httprouter.ParamsFromContext(r.Context()).ByName("channel")
+	if err := auth.Authorize(r.Context(), auth.Write, channel); err != nil {
+		writeJSON(log, w, http.StatusForbidden, store.Response{Message: err.Error(), StatusCode: store.StatusForbidden})
+		return
+	}
+
+	var req PublishRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(log, w, http.StatusRequestEntityTooLarge, store.Response{Message: fmt.Sprintf("request body too large, max %d bytes", tooLarge.Limit), StatusCode: store.StatusValueTooLarge})
+			return
+		}
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+
+	if err := h.broker.Publish(channel, []byte(req.Message)); err != nil {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	writeJSON(log, w, http.StatusOK, store.Response{Message: "message published", StatusCode: store.StatusSuccess})
+}
+
+// Subscribe streams the messages of the channel path parameter, or of the
+// channels of a pattern ending with *, as server-sent events until the
+// client disconnects. A subscriber falling behind is sent an error event
+// and disconnected.
+func (h *Handler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	pattern := httprouter.ParamsFromContext(r.Context()).ByName("channel")
+	if err := ValidChannel(pattern, true); err != nil {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if err := auth.Authorize(r.Context(), auth.Read, Prefix(pattern)); err != nil {
+		writeJSON(log, w, http.StatusForbidden, store.Response{Message: err.Error(), StatusCode: store.StatusForbidden})
+		return
+	}
+
+	sub, err := h.broker.Subscribe(pattern)
+	if err != nil {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	defer sub.Close()
+
+	// The stream outlives the write timeout of the server.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Error().Err(err).Msg("event stream not supported by the response writer")
+		return
+	}
+
+	heartbeat := time.NewTicker(heartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-sub.Events():
+			if !ok {
+				resp := store.Response{Message: sub.Err().Error(), StatusCode: store.StatusWatchEnded}
+				if err := writeEvent(w, "error", resp); err == nil {
+					_ = rc.Flush()
+				}
+				return
+			}
+			err = writeEvent(w, "message", Decode(e))
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("failed to write event")
+			return
+		}
+	}
+}
+
+// writeEvent writes a server-sent event of type event with the JSON data
+// obj.
+func writeEvent(w http.ResponseWriter, event string, obj any) error {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,83 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(zerolog.Nop(), NewBroker(16))
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodPost, "/channels/:channel/publish", h.Publish)
+	router.HandlerFunc(http.MethodGet, "/channels/:channel/subscribe", h.Subscribe)
+	srv := httptest.NewServer(router)
+	t.Cleanup(srv.Close)
+
+	publish := func(channel, body string) (int, store.Response) {
+		resp, err := http.Post(srv.URL+"/channels/"+channel+"/publish", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var r store.Response
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&r))
+		return resp.StatusCode, r
+	}
+
+	resp, err := http.Get(srv.URL + "/channels/a*b/subscribe")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + "/channels/news.*/subscribe")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	code, r := publish("news.sports", `{"message":"goal"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, store.Response{Message: "message published", StatusCode: store.StatusSuccess}, r)
+	code, r = publish("news.sports", `{"message":`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidJSON, r.StatusCode)
+	code, r = publish("news*", `{"message":"x"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, r.StatusCode)
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	var event []string
+	for len(event) < 3 {
+		select {
+		case line := <-lines:
+			event = append(event, line)
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event received")
+		}
+	}
+	assert.Equal(t, "event: message", event[0])
+	data, ok := strings.CutPrefix(event[1], "data: ")
+	require.True(t, ok)
+	var m Message
+	require.NoError(t, json.Unmarshal([]byte(data), &m))
+	assert.Equal(t, "news.sports", m.Channel)
+	assert.Equal(t, "goal", m.Message)
+	assert.Empty(t, event[2], "events end with a blank line")
+}
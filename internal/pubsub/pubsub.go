@@ -0,0 +1,114 @@
+// Package pubsub provides publish/subscribe channels independent of the
+// keys of the store.
+//
+// A message published on a channel is delivered to the current subscribers
+// of the channel only: it isn't stored, replicated or replayed, so apps
+// co-located with the store exchange ephemeral signals without a separate
+// message broker. Channels are carried by an events.Bus of their own, kept
+// apart from the change bus so messages never reach watches, webhooks or
+// change data capture. Like watches, a subscriber falling behind is
+// disconnected rather than slowing publishers down.
+package pubsub
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"codesignal/internal/events"
+)
+
+// MaxChannelLength caps the length of a channel name, in bytes.
+const MaxChannelLength = 256
+
+// terminator ends the bus key of a channel, so subscribing to a channel
+// doesn't subscribe to the channels it prefixes. Channel names can't
+// contain it.
+const terminator = "\x00"
+
+// ErrInvalidChannel is returned for channel names that are empty, too
+// long, contain control characters or a * anywhere but at the end of a
+// pattern.
+var ErrInvalidChannel = errors.New("channel must be 1 to 256 characters without control characters, * only ending a subscription pattern")
+
+// Message is a message published on a channel.
+type Message struct {
+	Channel string    `json:"channel"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// Broker publishes messages to the subscribers of their channel. It is
+// safe for concurrent use.
+type Broker struct {
+	// mu serializes publishing, the bus numbering messages in order.
+	mu  sync.Mutex
+	bus *events.Bus
+	now func() time.Time
+}
+
+// NewBroker returns a broker buffering up to buffer messages per
+// subscriber.
+func NewBroker(buffer int) *Broker {
+	return &Broker{bus: events.NewBus(buffer), now: time.Now}
+}
+
+// Publish delivers message to the current subscribers of channel.
+func (b *Broker) Publish(channel string, message []byte) error {
+	if err := ValidChannel(channel, false); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bus.Publish(events.Event{Type: events.TypeMessage, Key: channel + terminator, Value: message, Time: b.now()})
+	return nil
+}
+
+// Subscribe returns a subscription to the messages of channel or, for a
+// pattern ending with *, of every channel starting with the rest of the
+// pattern: news.* subscribes to news.sports and news.weather, * to every
+// channel. The events of the subscription are decoded with Decode, and it
+// must be closed when no longer needed.
+func (b *Broker) Subscribe(pattern string) (*events.Subscription, error) {
+	if err := ValidChannel(pattern, true); err != nil {
+		return nil, err
+	}
+	prefix, ok := strings.CutSuffix(pattern, "*")
+	if !ok {
+		prefix = pattern + terminator
+	}
+	return b.bus.Subscribe(prefix), nil
+}
+
+// Decode returns the message of an event of a subscription.
+func Decode(e events.Event) Message {
+	return Message{Channel: strings.TrimSuffix(e.Key, terminator), Message: string(e.Value), Time: e.Time}
+}
+
+// ValidChannel returns ErrInvalidChannel unless channel is a valid channel
+// name or, if pattern, subscription pattern.
+func ValidChannel(channel string, pattern bool) error {
+	name := channel
+	if pattern {
+		name = strings.TrimSuffix(channel, "*")
+		if name == "" && channel != "" {
+			return nil
+		}
+	}
+	if name == "" || len(channel) > MaxChannelLength {
+		return ErrInvalidChannel
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f || r == '*' {
+			return ErrInvalidChannel
+		}
+	}
+	return nil
+}
+
+// Prefix returns the prefix of the channels of a subscription pattern, the
+// pattern itself for a channel, to authorize the subscription.
+func Prefix(pattern string) string {
+	return strings.TrimSuffix(pattern, "*")
+}
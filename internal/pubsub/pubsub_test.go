@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+)
+
+func TestValidChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		pattern bool
+		valid   bool
+	}{
+		{"news", false, true},
+		{"news.sports", false, true},
+		{"orders:42", false, true},
+		{"", false, false},
+		{"news*", false, false},
+		{"a\nb", false, false},
+		{strings.Repeat("a", MaxChannelLength+1), false, false},
+		{"news.*", true, true},
+		{"*", true, true},
+		{"news", true, true},
+		{"", true, false},
+		{"**", true, false},
+		{"a*b", true, false},
+	}
+	for _, tt := range tests {
+		err := ValidChannel(tt.channel, tt.pattern)
+		if tt.valid {
+			assert.NoError(t, err, "%q", tt.channel)
+		} else {
+			assert.ErrorIs(t, err, ErrInvalidChannel, "%q", tt.channel)
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(16)
+
+	exact, err := b.Subscribe("news")
+	require.NoError(t, err)
+	defer exact.Close()
+	pattern, err := b.Subscribe("news.*")
+	require.NoError(t, err)
+	defer pattern.Close()
+	all, err := b.Subscribe("*")
+	require.NoError(t, err)
+	defer all.Close()
+	_, err = b.Subscribe("news*.")
+	assert.ErrorIs(t, err, ErrInvalidChannel)
+
+	require.NoError(t, b.Publish("news", []byte("1")))
+	require.NoError(t, b.Publish("news.sports", []byte("2")))
+	require.NoError(t, b.Publish("newsletter", []byte("3")))
+	assert.ErrorIs(t, b.Publish("news.*", []byte("4")), ErrInvalidChannel)
+
+	received := func(sub *events.Subscription) []string {
+		var got []string
+		for {
+			select {
+			case e := <-sub.Events():
+				m := Decode(e)
+				assert.False(t, m.Time.IsZero())
+				got = append(got, m.Channel+"="+m.Message)
+			default:
+				return got
+			}
+		}
+	}
+	assert.Equal(t, []string{"news=1"}, received(exact), "a channel doesn't receive the channels it prefixes")
+	assert.Equal(t, []string{"news.sports=2"}, received(pattern))
+	assert.Equal(t, []string{"news=1", "news.sports=2", "newsletter=3"}, received(all))
+}
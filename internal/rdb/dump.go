@@ -0,0 +1,76 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// dumpVersion is the RDB version of the payloads written by EncodeDump,
+// the version of Redis 6 so that every Redis release since restores them.
+const dumpVersion = 9
+
+// ErrInvalidDump is returned for payloads that weren't written by DUMP or
+// were altered since.
+var ErrInvalidDump = errors.New("DUMP payload version or checksum are wrong")
+
+// EncodeDump serializes a string value in the format of the Redis DUMP
+// command: the value in RDB encoding, the RDB version and a CRC-64 of both.
+func EncodeDump(value []byte) []byte {
+	payload := make([]byte, 0, 1+9+len(value)+10)
+	payload = append(payload, typeString)
+	payload = appendLength(payload, uint64(len(value)))
+	payload = append(payload, value...)
+	payload = binary.LittleEndian.AppendUint16(payload, dumpVersion)
+	return binary.LittleEndian.AppendUint64(payload, checksum(0, payload))
+}
+
+// DecodeDump returns the string value of a payload written by EncodeDump or
+// by the DUMP command of Redis.
+func DecodeDump(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, ErrInvalidDump
+	}
+	footer := payload[len(payload)-10:]
+	body := payload[:len(payload)-8]
+	if binary.LittleEndian.Uint16(footer) > maxVersion {
+		return nil, ErrInvalidDump
+	}
+	if want := binary.LittleEndian.Uint64(footer[2:]); want != 0 && want != checksum(0, body) {
+		return nil, ErrInvalidDump
+	}
+
+	d := &decoder{r: bufio.NewReader(bytes.NewReader(body[:len(body)-2]))}
+	typ, err := d.byte()
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+	if typ != typeString {
+		return nil, fmt.Errorf("unsupported %s value, only strings are supported", typeName(typ))
+	}
+	value, err := d.string()
+	if err != nil {
+		return nil, ErrInvalidDump
+	}
+	if _, err := d.r.ReadByte(); !errors.Is(err, io.EOF) {
+		// Trailing bytes.
+		return nil, ErrInvalidDump
+	}
+	return value, nil
+}
+
+func appendLength(b []byte, n uint64) []byte {
+	switch {
+	case n < 1<<6:
+		return append(b, byte(n))
+	case n < 1<<14:
+		return append(b, byte(n>>8)|0x40, byte(n))
+	case n <= 1<<32-1:
+		return binary.BigEndian.AppendUint32(append(b, 0x80), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, 0x81), n)
+	}
+}
@@ -0,0 +1,51 @@
+package rdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		for _, value := range [][]byte{{}, []byte("bar"), bytes.Repeat([]byte("x"), 100), bytes.Repeat([]byte("y"), 20000)} {
+			got, err := DecodeDump(EncodeDump(value))
+			require.NoError(t, err)
+			assert.Equal(t, value, got)
+		}
+	})
+
+	t.Run("redis payload", func(t *testing.T) {
+		// SET mykey 10, DUMP mykey, from the Redis documentation: an int8
+		// encoded string of RDB version 10.
+		got, err := DecodeDump([]byte("\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte("10"), got)
+	})
+
+	t.Run("invalid payloads", func(t *testing.T) {
+		valid := EncodeDump([]byte("bar"))
+		corrupted := bytes.Clone(valid)
+		corrupted[2] ^= 0xFF
+		futureVersion := bytes.Clone(valid)
+		futureVersion[len(futureVersion)-10] = 99
+
+		for name, payload := range map[string][]byte{
+			"empty":          nil,
+			"corrupted":      corrupted,
+			"future version": futureVersion,
+			"truncated":      valid[len(valid)-10:],
+		} {
+			_, err := DecodeDump(payload)
+			assert.ErrorIs(t, err, ErrInvalidDump, name)
+		}
+
+		list := []byte{typeList, 0x01, 0x01, 'x', 0x0b, 0x00}
+		list = append(list, make([]byte, 8)...)
+		_, err := DecodeDump(list)
+		assert.True(t, strings.Contains(err.Error(), "unsupported list value"), err)
+	})
+}
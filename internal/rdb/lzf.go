@@ -0,0 +1,54 @@
+package rdb
+
+import "errors"
+
+var errLZF = errors.New("invalid lzf compressed string")
+
+// lzfDecompress decompresses an LZF compressed string of length n.
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes.
+			end := i + ctrl + 1
+			if end > len(in) || len(out)+ctrl+1 > n {
+				return nil, errLZF
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// Back reference of length+2 bytes, the length continuing in the
+		// next byte when all its bits are set.
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errLZF
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZF
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		length += 2
+		if ref < 0 || len(out)+length > n {
+			return nil, errLZF
+		}
+		// Byte by byte, the reference may overlap the output.
+		for j := 0; j < length; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != n {
+		return nil, errLZF
+	}
+	return out, nil
+}
@@ -0,0 +1,622 @@
+// Package rdb decodes the string keys of Redis RDB dump files, for
+// migrating simple Redis workloads to the store. Keys of other types are
+// skipped and counted.
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Opcodes of the file format.
+const (
+	opSlotInfo     = 0xF4
+	opFunction2    = 0xF5
+	opFunctionPre  = 0xF6
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+)
+
+// Value types of the file format.
+const (
+	typeString            = 0
+	typeList              = 1
+	typeSet               = 2
+	typeZSet              = 3
+	typeHash              = 4
+	typeZSet2             = 5
+	typeModulePre         = 6
+	typeModule2           = 7
+	typeHashZipmap        = 9
+	typeListZiplist       = 10
+	typeSetIntset         = 11
+	typeZSetZiplist       = 12
+	typeHashZiplist       = 13
+	typeListQuicklist     = 14
+	typeStreamListpacks   = 15
+	typeHashListpack      = 16
+	typeZSetListpack      = 17
+	typeListQuicklist2    = 18
+	typeStreamListpacks2  = 19
+	typeSetListpack       = 20
+	typeStreamListpacks3  = 21
+	typeHashMetadataPre   = 22
+	typeHashListpackExPre = 23
+	typeHashMetadata      = 24
+	typeHashListpackEx    = 25
+)
+
+// Special string encodings.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Module value opcodes.
+const (
+	moduleOpEOF    = 0
+	moduleOpSInt   = 1
+	moduleOpUInt   = 2
+	moduleOpFloat  = 3
+	moduleOpDouble = 4
+	moduleOpString = 5
+)
+
+const (
+	// maxVersion is the latest version of the format read.
+	maxVersion = 12
+	// maxStringLength is the largest string Redis stores.
+	maxStringLength = 512 << 20
+	// readChunk bounds the memory allocated ahead of reading a string, so
+	// corrupted lengths fail on EOF rather than on allocation.
+	readChunk = 1 << 20
+)
+
+// ErrChecksum is returned when the checksum of a file doesn't match its
+// contents.
+var ErrChecksum = errors.New("rdb checksum mismatch")
+
+// crcTable is the CRC-64/Jones table of the file checksum.
+var crcTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+// Entry is a string key of a dump.
+type Entry struct {
+	DB    int
+	Key   string
+	Value []byte
+	// ExpiresAt is zero when the key never expires.
+	ExpiresAt time.Time
+}
+
+// Stats counts the keys of a dump.
+type Stats struct {
+	// Strings is the number of string keys decoded.
+	Strings int
+	// Skipped counts the keys of other types by type name.
+	Skipped map[string]int
+}
+
+// Decode reads an RDB file, calling fn with every string key. Keys of other
+// types are skipped. An error from fn stops the decoding.
+func Decode(r io.Reader, fn func(Entry) error) (Stats, error) {
+	d := &decoder{r: bufio.NewReaderSize(r, 64*1024)}
+	stats := Stats{Skipped: map[string]int{}}
+
+	header := make([]byte, 9)
+	if err := d.full(header); err != nil {
+		return stats, fmt.Errorf("read header: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return stats, errors.New("not an rdb file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return stats, fmt.Errorf("unsupported rdb version %q", header[5:])
+	}
+
+	db := 0
+	var expiresAt time.Time
+	for {
+		op, err := d.byte()
+		if err != nil {
+			return stats, err
+		}
+
+		switch op {
+		case opEOF:
+			if version < 5 {
+				return stats, nil
+			}
+			return stats, d.checksum()
+		case opSelectDB:
+			n, err := d.length()
+			if err != nil {
+				return stats, err
+			}
+			db = int(n)
+		case opResizeDB:
+			err = d.skipLengths(2)
+		case opSlotInfo:
+			err = d.skipLengths(3)
+		case opAux:
+			err = d.skipStrings(2)
+		case opExpireTime:
+			var b [4]byte
+			err = d.full(b[:])
+			expiresAt = time.Unix(int64(binary.LittleEndian.Uint32(b[:])), 0)
+		case opExpireTimeMs:
+			var ms int64
+			ms, err = d.millis()
+			expiresAt = time.UnixMilli(ms)
+		case opIdle:
+			_, err = d.length()
+		case opFreq:
+			_, err = d.byte()
+		case opModuleAux:
+			err = d.skipLengths(3)
+			if err == nil {
+				err = d.skipModuleValue()
+			}
+		case opFunction2:
+			err = d.skipStrings(1)
+		case opFunctionPre:
+			return stats, errors.New("pre-release function format unsupported")
+		default:
+			var key, value []byte
+			if key, err = d.string(); err != nil {
+				return stats, err
+			}
+			if op != typeString {
+				stats.Skipped[typeName(op)]++
+				if err := d.skipValue(op); err != nil {
+					return stats, fmt.Errorf("key %q: %w", key, err)
+				}
+				expiresAt = time.Time{}
+				continue
+			}
+			if value, err = d.string(); err != nil {
+				return stats, fmt.Errorf("key %q: %w", key, err)
+			}
+			stats.Strings++
+			if err := fn(Entry{DB: db, Key: string(key), Value: value, ExpiresAt: expiresAt}); err != nil {
+				return stats, err
+			}
+			expiresAt = time.Time{}
+		}
+		if err != nil {
+			return stats, err
+		}
+	}
+}
+
+// decoder reads the primitives of the format, keeping the checksum of the
+// bytes read.
+type decoder struct {
+	r *bufio.Reader
+	// crc is the checksum of the bytes read.
+	crc uint64
+}
+
+func (d *decoder) update(p []byte) {
+	d.crc = checksum(d.crc, p)
+}
+
+// checksum updates the CRC-64/Jones crc of Redis with p. hash/crc64 inverts
+// the CRC before and after the update, Redis doesn't.
+func checksum(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+func (d *decoder) byte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, unexpected(err)
+	}
+	d.update([]byte{b})
+	return b, nil
+}
+
+func (d *decoder) full(p []byte) error {
+	if _, err := io.ReadFull(d.r, p); err != nil {
+		return unexpected(err)
+	}
+	d.update(p)
+	return nil
+}
+
+// checksum checks the trailing checksum, zero when disabled.
+func (d *decoder) checksum() error {
+	want := d.crc
+	var b [8]byte
+	if _, err := io.ReadFull(d.r, b[:]); err != nil {
+		return unexpected(err)
+	}
+	if got := binary.LittleEndian.Uint64(b[:]); got != 0 && got != want {
+		return ErrChecksum
+	}
+	return nil
+}
+
+func (d *decoder) millis() (int64, error) {
+	var b [8]byte
+	if err := d.full(b[:]); err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(b[:])), nil
+}
+
+// lengthOrEncoding reads a length, or the special encoding of a string when
+// encoded is true.
+func (d *decoder) lengthOrEncoding() (n uint64, encoded bool, err error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := d.byte()
+		return uint64(b&0x3F)<<8 | uint64(next), false, err
+	case 2:
+		switch b {
+		case 0x80:
+			var buf [4]byte
+			err := d.full(buf[:])
+			return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+		case 0x81:
+			var buf [8]byte
+			err := d.full(buf[:])
+			return binary.BigEndian.Uint64(buf[:]), false, err
+		}
+		return 0, false, fmt.Errorf("invalid length encoding %#x", b)
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func (d *decoder) length() (uint64, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err == nil && encoded {
+		err = errors.New("unexpected string encoding for a length")
+	}
+	return n, err
+}
+
+func (d *decoder) string() ([]byte, error) {
+	n, encoded, err := d.lengthOrEncoding()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.raw(n)
+	}
+
+	switch n {
+	case encInt8:
+		b, err := d.byte()
+		return strconv.AppendInt(nil, int64(int8(b)), 10), err
+	case encInt16:
+		var b [2]byte
+		err := d.full(b[:])
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b[:]))), 10), err
+	case encInt32:
+		var b [4]byte
+		err := d.full(b[:])
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b[:]))), 10), err
+	case encLZF:
+		clen, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		ulen, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		if ulen > maxStringLength {
+			return nil, fmt.Errorf("string of %d bytes exceeds the limit", ulen)
+		}
+		compressed, err := d.raw(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(ulen))
+	}
+	return nil, fmt.Errorf("invalid string encoding %d", n)
+}
+
+// raw reads n bytes, allocating as they are read.
+func (d *decoder) raw(n uint64) ([]byte, error) {
+	if n > maxStringLength {
+		return nil, fmt.Errorf("string of %d bytes exceeds the limit", n)
+	}
+	if n <= readChunk {
+		b := make([]byte, n)
+		return b, d.full(b)
+	}
+
+	var buf bytes.Buffer
+	for remaining := int64(n); remaining > 0; {
+		chunk := min(remaining, readChunk)
+		if _, err := io.CopyN(&buf, d.r, chunk); err != nil {
+			return nil, unexpected(err)
+		}
+		remaining -= chunk
+	}
+	d.update(buf.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (d *decoder) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := d.length(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *decoder) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if _, err := d.string(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValue reads past a value of type typ.
+func (d *decoder) skipValue(typ byte) error {
+	switch typ {
+	case typeList, typeSet, typeListQuicklist:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		return d.skipStrings(n)
+	case typeHash:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		return d.skipStrings(2 * n)
+	case typeZSet, typeZSet2:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := d.string(); err != nil {
+				return err
+			}
+			if err := d.skipScore(typ == typeZSet2); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeHashZipmap, typeListZiplist, typeSetIntset, typeZSetZiplist, typeHashZiplist,
+		typeHashListpack, typeZSetListpack, typeSetListpack, typeHashListpackExPre:
+		return d.skipStrings(1)
+	case typeHashListpackEx:
+		if _, err := d.millis(); err != nil {
+			return err
+		}
+		return d.skipStrings(1)
+	case typeListQuicklist2:
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Container kind, then the node.
+			if _, err := d.length(); err != nil {
+				return err
+			}
+			if _, err := d.string(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeHashMetadata, typeHashMetadataPre:
+		if typ == typeHashMetadata {
+			if _, err := d.millis(); err != nil {
+				return err
+			}
+		}
+		n, err := d.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Field TTL, field and value.
+			if _, err := d.length(); err != nil {
+				return err
+			}
+			if err := d.skipStrings(2); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return d.skipStream(typ)
+	case typeModule2:
+		// Module ID.
+		if _, err := d.length(); err != nil {
+			return err
+		}
+		return d.skipModuleValue()
+	}
+	return fmt.Errorf("unsupported value type %d", typ)
+}
+
+// skipScore reads past a sorted set score, binary in version 2 of the type.
+func (d *decoder) skipScore(binaryScore bool) error {
+	if binaryScore {
+		var b [8]byte
+		return d.full(b[:])
+	}
+	n, err := d.byte()
+	if err != nil {
+		return err
+	}
+	if n >= 253 {
+		// NaN or infinities.
+		return nil
+	}
+	b := make([]byte, n)
+	return d.full(b)
+}
+
+// skipStream reads past a stream, its entries, consumer groups and pending
+// entries.
+func (d *decoder) skipStream(typ byte) error {
+	n, err := d.length()
+	if err != nil {
+		return err
+	}
+	// Listpacks with their master ID.
+	if err := d.skipStrings(2 * n); err != nil {
+		return err
+	}
+	// Length and last ID, then first ID, max deleted ID and entries added.
+	ids := 3
+	if typ >= typeStreamListpacks2 {
+		ids += 5
+	}
+	if err := d.skipLengths(ids); err != nil {
+		return err
+	}
+
+	groups, err := d.length()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < groups; i++ {
+		if err := d.skipStrings(1); err != nil {
+			return err
+		}
+		// Last delivered ID, then entries read.
+		ids := 2
+		if typ >= typeStreamListpacks2 {
+			ids++
+		}
+		if err := d.skipLengths(ids); err != nil {
+			return err
+		}
+
+		// Pending entries: ID, delivery time and delivery count.
+		pending, err := d.length()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < pending; j++ {
+			var b [24]byte
+			if err := d.full(b[:]); err != nil {
+				return err
+			}
+			if _, err := d.length(); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := d.length()
+		if err != nil {
+			return err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			if err := d.skipStrings(1); err != nil {
+				return err
+			}
+			// Seen time, then active time.
+			times := 8
+			if typ >= typeStreamListpacks3 {
+				times += 8
+			}
+			if err := d.full(make([]byte, times)); err != nil {
+				return err
+			}
+			// Pending entry IDs of the consumer.
+			pending, err := d.length()
+			if err != nil {
+				return err
+			}
+			if pending > math.MaxInt32/16 {
+				return fmt.Errorf("invalid pending entries count %d", pending)
+			}
+			if err := d.full(make([]byte, 16*pending)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// skipModuleValue reads past the opcodes of a module value up to its end.
+func (d *decoder) skipModuleValue() error {
+	for {
+		op, err := d.length()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case moduleOpEOF:
+			return nil
+		case moduleOpSInt, moduleOpUInt:
+			_, err = d.length()
+		case moduleOpFloat:
+			err = d.full(make([]byte, 4))
+		case moduleOpDouble:
+			err = d.full(make([]byte, 8))
+		case moduleOpString:
+			_, err = d.string()
+		default:
+			err = fmt.Errorf("invalid module opcode %d", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func typeName(typ byte) string {
+	switch typ {
+	case typeList, typeListZiplist, typeListQuicklist, typeListQuicklist2:
+		return "list"
+	case typeSet, typeSetIntset, typeSetListpack:
+		return "set"
+	case typeZSet, typeZSet2, typeZSetZiplist, typeZSetListpack:
+		return "zset"
+	case typeHash, typeHashZipmap, typeHashZiplist, typeHashListpack,
+		typeHashMetadataPre, typeHashListpackExPre, typeHashMetadata, typeHashListpackEx:
+		return "hash"
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return "stream"
+	case typeModulePre, typeModule2:
+		return "module"
+	}
+	return "type " + strconv.Itoa(int(typ))
+}
+
+func unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
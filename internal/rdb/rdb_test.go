@@ -0,0 +1,143 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dump builds an RDB file.
+type dump struct {
+	bytes.Buffer
+}
+
+func newDump() *dump {
+	d := &dump{}
+	d.WriteString("REDIS0011")
+	return d
+}
+
+func (d *dump) str(s string) *dump {
+	if len(s) >= 1<<6 {
+		panic("long strings are not needed by the tests")
+	}
+	d.WriteByte(byte(len(s)))
+	d.WriteString(s)
+	return d
+}
+
+func (d *dump) op(b ...byte) *dump {
+	d.Write(b)
+	return d
+}
+
+// end appends the EOF opcode and the checksum of the file.
+func (d *dump) end() []byte {
+	d.WriteByte(opEOF)
+	dec := &decoder{}
+	dec.update(d.Bytes())
+	return binary.LittleEndian.AppendUint64(bytes.Clone(d.Bytes()), dec.crc)
+}
+
+func decode(t *testing.T, file []byte) ([]Entry, Stats, error) {
+	t.Helper()
+	var entries []Entry
+	stats, err := Decode(bytes.NewReader(file), func(e Entry) error {
+		entries = append(entries, e)
+		return nil
+	})
+	return entries, stats, err
+}
+
+func TestDecode(t *testing.T) {
+	expiresAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+
+	d := newDump()
+	d.op(opAux).str("redis-ver").str("7.2.0")
+	d.op(opAux).str("redis-bits").op(0xC0, 64)
+	d.op(opSelectDB, 0, opResizeDB, 6, 1)
+	d.op(typeString).str("plain").str("value")
+	d.op(opExpireTimeMs).op(binary.LittleEndian.AppendUint64(nil, uint64(expiresAt.UnixMilli()))...)
+	d.op(typeString).str("ttl").str("t")
+	d.op(typeString).str("int").op(0xC1, 0x30, 0xF8) // -2000
+	// "a" then a reference to it repeated 20 times, then "bc".
+	d.op(typeString).str("lzf").op(0xC3, 8, 23, 0x00, 'a', 0xE0, 11, 0x00, 0x01, 'b', 'c')
+	d.op(opIdle, 5, opFreq, 3)
+	d.op(typeList).str("list").op(2).str("x").str("y")
+	d.op(typeZSet).str("zset").op(1).str("m").op(3).op([]byte("1.5")...)
+	d.op(typeZSet2).str("zset2").op(1).str("m").op(make([]byte, 8)...)
+	d.op(typeHashListpack).str("hash").str("listpack")
+	d.op(typeModule2).str("module").op(1, moduleOpUInt, 7, moduleOpString).str("s").op(moduleOpEOF)
+	d.op(opSelectDB, 1)
+	d.op(typeString).str("other").str("db1")
+	file := d.end()
+
+	entries, stats, err := decode(t, file)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{DB: 0, Key: "plain", Value: []byte("value")},
+		{DB: 0, Key: "ttl", Value: []byte("t"), ExpiresAt: expiresAt},
+		{DB: 0, Key: "int", Value: []byte("-2000")},
+		{DB: 0, Key: "lzf", Value: []byte(strings.Repeat("a", 21) + "bc")},
+		{DB: 1, Key: "other", Value: []byte("db1")},
+	}, entries)
+	assert.Equal(t, Stats{Strings: 5, Skipped: map[string]int{"list": 1, "zset": 2, "hash": 1, "module": 1}}, stats)
+
+	t.Run("corrupted checksum", func(t *testing.T) {
+		corrupted := bytes.Clone(file)
+		corrupted[len(corrupted)-1] ^= 0xFF
+		_, _, err := decode(t, corrupted)
+		assert.ErrorIs(t, err, ErrChecksum)
+	})
+
+	t.Run("disabled checksum", func(t *testing.T) {
+		disabled := bytes.Clone(file)
+		copy(disabled[len(disabled)-8:], make([]byte, 8))
+		_, _, err := decode(t, disabled)
+		assert.NoError(t, err)
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		_, _, err := decode(t, file[:len(file)-20])
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		stop := errors.New("stop")
+		_, err := Decode(bytes.NewReader(file), func(Entry) error { return stop })
+		assert.ErrorIs(t, err, stop)
+	})
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr string
+	}{
+		{name: "not an rdb file", file: []byte("*1\r\n$4\r\nPING\r\n"), wantErr: "not an rdb file"},
+		{name: "future version", file: []byte("REDIS0099"), wantErr: "unsupported rdb version"},
+		{name: "unknown type", file: newDump().op(42).str("k").end(), wantErr: "unsupported value type 42"},
+		{name: "invalid lzf", file: newDump().op(typeString).str("k").op(0xC3, 2, 5, 0x00, 'a').end(), wantErr: "invalid lzf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decode(t, tt.file)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// Check value of CRC-64/Jones as computed by Redis.
+	d := &decoder{}
+	d.update([]byte("123456789"))
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), d.crc)
+}
@@ -0,0 +1,155 @@
+// Package reload applies a new configuration to the running server without
+// restarting it, on SIGHUP or through the /admin/reload endpoint.
+//
+// The subsystems supporting reloads register an Applier, which prepares
+// the new settings of a configuration and returns the function applying
+// them. A reload runs every applier before applying any setting, so an
+// invalid configuration, such as a malformed allow list, changes nothing
+// and the server keeps running with its current settings.
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/config"
+	"codesignal/internal/store"
+)
+
+// Applier prepares the settings of cfg, returning the function applying
+// them, or why they are invalid.
+type Applier func(cfg *config.Config) (apply func(), err error)
+
+// Reloader loads the configuration again and applies it.
+type Reloader struct {
+	log  zerolog.Logger
+	load func() (*config.Config, error)
+
+	// mu serializes reloads, so settings are applied in the order their
+	// configurations were loaded.
+	mu       sync.Mutex
+	appliers []Applier
+
+	signals chan os.Signal
+	done    chan struct{}
+	stop    sync.Once
+}
+
+// New returns a Reloader loading configurations with load, such as
+// config.Reload.
+func New(log zerolog.Logger, load func() (*config.Config, error)) *Reloader {
+	return &Reloader{
+		log:     log,
+		load:    load,
+		signals: make(chan os.Signal, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Register adds an applier run by the following reloads.
+func (r *Reloader) Register(a Applier) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliers = append(r.appliers, a)
+}
+
+// Reload loads the configuration and applies it, leaving the settings
+// unchanged when it fails.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	applies := make([]func(), 0, len(r.appliers))
+	for _, a := range r.appliers {
+		apply, err := a(cfg)
+		if err != nil {
+			return err
+		}
+		applies = append(applies, apply)
+	}
+	for _, apply := range applies {
+		apply()
+	}
+	r.log.Info().Msg("configuration reloaded")
+	return nil
+}
+
+// Handler reloads the configuration on request.
+func (r *Reloader) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		code, resp := http.StatusOK, store.Response{Message: "configuration reloaded", StatusCode: store.StatusSuccess}
+		if err := r.Reload(); err != nil {
+			zerolog.Ctx(req.Context()).Error().Err(err).Msg("failed to reload configuration")
+			code, resp = http.StatusInternalServerError, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidConfig}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			zerolog.Ctx(req.Context()).Error().Err(err).Msg("error writing response")
+		}
+	})
+}
+
+// Name implements server.Service.
+func (r *Reloader) Name() string {
+	return "reload"
+}
+
+// Serve implements server.Service, reloading the configuration on SIGHUP
+// until shut down.
+func (r *Reloader) Serve() error {
+	signal.Notify(r.signals, syscall.SIGHUP)
+	defer signal.Stop(r.signals)
+
+	for {
+		select {
+		case <-r.signals:
+			if err := r.Reload(); err != nil {
+				r.log.Error().Err(err).Msg("failed to reload configuration")
+			}
+		case <-r.done:
+			return nil
+		}
+	}
+}
+
+// Shutdown implements server.Service.
+func (r *Reloader) Shutdown(context.Context) error {
+	r.stop.Do(func() { close(r.done) })
+	return nil
+}
+
+// Handler serves requests with a handler replaced by reloads. Requests
+// being served when it is replaced complete with the previous handler.
+type Handler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+// NewHandler returns a Handler serving requests with h.
+func NewHandler(h http.Handler) *Handler {
+	var s Handler
+	s.Store(h)
+	return &s
+}
+
+// Store replaces the handler of the following requests.
+func (s *Handler) Store(h http.Handler) {
+	s.h.Store(&h)
+}
+
+func (s *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load()).ServeHTTP(w, r)
+}
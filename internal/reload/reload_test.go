@@ -0,0 +1,91 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+	"codesignal/internal/store"
+)
+
+func TestReload(t *testing.T) {
+	cfg := &config.Config{MaxKeyLength: 8}
+	r := New(zerolog.Nop(), func() (*config.Config, error) { return cfg, nil })
+
+	var applied []int
+	r.Register(func(cfg *config.Config) (func(), error) {
+		return func() { applied = append(applied, cfg.MaxKeyLength) }, nil
+	})
+	r.Register(func(cfg *config.Config) (func(), error) {
+		if cfg.MaxKeyLength < 0 {
+			return nil, errors.New("invalid max key length")
+		}
+		return func() {}, nil
+	})
+
+	require.NoError(t, r.Reload())
+	assert.Equal(t, []int{8}, applied)
+
+	// Settings are only applied once every applier accepted them.
+	cfg = &config.Config{MaxKeyLength: -1}
+	assert.EqualError(t, r.Reload(), "invalid max key length")
+	assert.Equal(t, []int{8}, applied)
+}
+
+func TestHandler(t *testing.T) {
+	loadErr := errors.New("malformed .env")
+	var fail bool
+	r := New(zerolog.Nop(), func() (*config.Config, error) {
+		if fail {
+			return nil, loadErr
+		}
+		return &config.Config{}, nil
+	})
+
+	serve := func() (int, store.Response) {
+		rec := httptest.NewRecorder()
+		r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/reload", nil))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := serve()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, store.Response{Message: "configuration reloaded", StatusCode: store.StatusSuccess}, resp)
+
+	fail = true
+	code, resp = serve()
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, store.Response{Message: "load config: malformed .env", StatusCode: store.StatusInvalidConfig}, resp)
+}
+
+func TestServe(t *testing.T) {
+	r := New(zerolog.Nop(), config.Reload)
+	done := make(chan error)
+	go func() { done <- r.Serve() }()
+
+	require.NoError(t, r.Shutdown(context.Background()))
+	require.NoError(t, r.Shutdown(context.Background()))
+	assert.NoError(t, <-done)
+}
+
+func TestSwapHandler(t *testing.T) {
+	h := NewHandler(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	h.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,52 @@
+package replica
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// StatusResponse represents the state of the replica returned by the API.
+type StatusResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       Status           `json:"data"`
+}
+
+// Handler serves the replica admin endpoints.
+type Handler struct {
+	replica *Replica
+}
+
+// NewHandler returns the admin handler reporting the state of replica.
+func NewHandler(replica *Replica) *Handler {
+	return &Handler{replica: replica}
+}
+
+// Status returns the state of the replica.
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	writeJSON(zerolog.Ctx(r.Context()), w, http.StatusOK, StatusResponse{Message: "replica status", StatusCode: store.StatusSuccess, Data: h.replica.Status()})
+}
+
+// Refresh loads the snapshot of the source if it changed, without waiting
+// for the next refresh interval.
+func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	if _, err := h.replica.Refresh(r.Context()); err != nil {
+		log.Error().Err(err).Msg("failed to refresh the replica snapshot")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to refresh the replica snapshot", StatusCode: store.StatusStorageError})
+		return
+	}
+	writeJSON(log, w, http.StatusOK, StatusResponse{Message: "replica refreshed", StatusCode: store.StatusSuccess, Data: h.replica.Status()})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
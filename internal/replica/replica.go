@@ -0,0 +1,211 @@
+// Package replica serves the keys of another node from its snapshots.
+//
+// A Replica loads a snapshot file written by another node, such as the
+// most recent backup of its backup directory on a shared volume, and
+// reloads it every refresh interval once the file changed. The store of a
+// replica is pinned in maintenance: reads are served through every
+// protocol and writes are rejected, so replicas scale the reads of a
+// deployment, for instance across regions, without joining a cluster. The
+// keys served are as stale as the last snapshot loaded.
+package replica
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/backup"
+	"codesignal/internal/metrics"
+)
+
+// Config holds the source of the snapshots of a replica.
+type Config struct {
+	// Source is the snapshot file loaded, or a directory of backups whose
+	// most recent one is loaded. Empty disables replica mode.
+	Source string `envconfig:"SOURCE"`
+	// RefreshInterval is the time between two checks of the source, the
+	// snapshot being reloaded when it changed.
+	RefreshInterval time.Duration `envconfig:"REFRESH_INTERVAL" default:"30s"`
+}
+
+// Store is the store loading the snapshots, such as a
+// repository.KeyValueStore.
+type Store interface {
+	// Restore replaces the contents of the store with the snapshot read
+	// from r, keeping them if it fails.
+	Restore(ctx context.Context, r io.Reader) error
+}
+
+// Status is the state of a replica.
+type Status struct {
+	Source string `json:"source"`
+	// Snapshot is the path of the snapshot loaded last.
+	Snapshot string `json:"snapshot,omitempty"`
+	// ModifiedAt is the modification time of the snapshot loaded.
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	// LoadedAt is when the snapshot was loaded.
+	LoadedAt *time.Time `json:"loaded_at,omitempty"`
+	// CheckedAt is when the source was last checked.
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+	// Error is the error of the last check, empty when it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Replica keeps a store loaded with the snapshots of its source, a
+// server.Service.
+type Replica struct {
+	log      zerolog.Logger
+	source   string
+	interval time.Duration
+	store    Store
+	now      func() time.Time
+
+	// mu serializes the refreshes and guards the fields below.
+	mu                  sync.Mutex
+	snapshot            string
+	modTime             time.Time
+	size                int64
+	loadedAt, checkedAt time.Time
+	err                 error
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// New returns the replica loading the snapshots of cfg into store, nil when
+// disabled. Refresh loads the first one.
+func New(log zerolog.Logger, cfg Config, store Store) *Replica {
+	if cfg.Source == "" {
+		return nil
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 30 * time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Replica{
+		log:      log.With().Str("component", "replica").Logger(),
+		source:   cfg.Source,
+		interval: cfg.RefreshInterval,
+		store:    store,
+		now:      time.Now,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Refresh loads the snapshot of the source unless it is the one loaded
+// already, reporting whether it did. The keys of the previous snapshot
+// keep being served when it fails.
+func (r *Replica) Refresh(ctx context.Context) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkedAt = r.now()
+	loaded, err := r.refresh(ctx)
+	r.err = err
+	if err != nil {
+		metrics.ReplicaRefreshFailures.Add(1)
+	}
+	return loaded, err
+}
+
+func (r *Replica) refresh(ctx context.Context) (bool, error) {
+	path, err := r.latest(ctx)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	if path == r.snapshot && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+
+	start := r.now()
+	if err := r.store.Restore(ctx, f); err != nil {
+		return false, fmt.Errorf("load snapshot %s: %w", path, err)
+	}
+	r.snapshot, r.modTime, r.size, r.loadedAt = path, info.ModTime(), info.Size(), r.now()
+	metrics.ReplicaRefreshes.Add(1)
+	r.log.Info().Str("snapshot", path).Time("modified_at", r.modTime).Dur("duration", r.loadedAt.Sub(start)).Msg("replica snapshot loaded")
+	return true, nil
+}
+
+// latest returns the path of the snapshot of the source, the most recent
+// backup of a directory.
+func (r *Replica) latest(ctx context.Context) (string, error) {
+	info, err := os.Stat(r.source)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return r.source, nil
+	}
+	backups, err := backup.List(ctx, backup.Dir(r.source))
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backup in %s", r.source)
+	}
+	return filepath.Join(r.source, backups[0].Name), nil
+}
+
+// Status returns the state of the replica.
+func (r *Replica) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Status{Source: r.source, Snapshot: r.snapshot}
+	if !r.loadedAt.IsZero() {
+		modTime, loadedAt := r.modTime, r.loadedAt
+		s.ModifiedAt, s.LoadedAt = &modTime, &loadedAt
+	}
+	if !r.checkedAt.IsZero() {
+		checkedAt := r.checkedAt
+		s.CheckedAt = &checkedAt
+	}
+	if r.err != nil {
+		s.Error = r.err.Error()
+	}
+	return s
+}
+
+// Name implements server.Service.
+func (r *Replica) Name() string {
+	return "replica"
+}
+
+// Serve implements server.Service, refreshing the snapshot every interval
+// until shut down.
+func (r *Replica) Serve() error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.Refresh(r.ctx); err != nil && !errors.Is(err, context.Canceled) {
+				r.log.Error().Err(err).Msg("failed to refresh the replica snapshot, serving the previous one")
+			}
+		case <-r.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// Shutdown implements server.Service, interrupting a refresh in progress.
+func (r *Replica) Shutdown(context.Context) error {
+	r.cancel()
+	return nil
+}
@@ -0,0 +1,136 @@
+package replica
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/backup"
+	"codesignal/internal/repository"
+)
+
+// snapshot returns a snapshot of keys.
+func snapshot(t *testing.T, keys map[string]string) []byte {
+	t.Helper()
+	data := repository.Data{Store: map[string][]byte{}}
+	for k, v := range keys {
+		data.Store[k] = []byte(v)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, repository.EncodeSnapshot(&buf, data))
+	return buf.Bytes()
+}
+
+func newStore(t *testing.T) *repository.KeyValueStore {
+	t.Helper()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func value(t *testing.T, repo *repository.KeyValueStore, key string) string {
+	t.Helper()
+	v, ok, err := repo.Get(context.Background(), key)
+	require.NoError(t, err)
+	if !ok {
+		return ""
+	}
+	return string(v)
+}
+
+func TestRefreshFile(t *testing.T) {
+	ctx := context.Background()
+	file := filepath.Join(t.TempDir(), "store.snap")
+	require.NoError(t, os.WriteFile(file, snapshot(t, map[string]string{"a": "1"}), 0o600))
+	repo := newStore(t)
+	r := New(zerolog.Nop(), Config{Source: file}, repo)
+
+	loaded, err := r.Refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, "1", value(t, repo, "a"))
+	loaded, err = r.Refresh(ctx)
+	require.NoError(t, err)
+	assert.False(t, loaded, "an unchanged snapshot isn't reloaded")
+
+	// The snapshot replaces the keys.
+	require.NoError(t, os.WriteFile(file, snapshot(t, map[string]string{"b": "2"}), 0o600))
+	modTime := time.Now().Add(time.Minute).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(file, modTime, modTime))
+	loaded, err = r.Refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Empty(t, value(t, repo, "a"))
+	assert.Equal(t, "2", value(t, repo, "b"))
+
+	// The keys of the previous snapshot are kept when one can't be loaded.
+	require.NoError(t, os.WriteFile(file, []byte("partial"), 0o600))
+	_, err = r.Refresh(ctx)
+	require.Error(t, err)
+	assert.Equal(t, "2", value(t, repo, "b"))
+	status := r.Status()
+	assert.Equal(t, file, status.Snapshot)
+	require.NotNil(t, status.ModifiedAt)
+	assert.True(t, modTime.Equal(*status.ModifiedAt))
+	assert.Contains(t, status.Error, "decode snapshot")
+}
+
+func TestRefreshDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	repo := newStore(t)
+	r := New(zerolog.Nop(), Config{Source: dir}, repo)
+
+	_, err := r.Refresh(ctx)
+	assert.EqualError(t, err, "no backup in "+dir)
+
+	// The most recent backup is loaded, other files are ignored.
+	start := time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)
+	for i, v := range []string{"old", "new"} {
+		name := backup.Name(start.Add(time.Duration(i) * time.Hour))
+		require.NoError(t, backup.Dir(dir).Write(ctx, name, bytes.NewReader(snapshot(t, map[string]string{"a": v}))))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0o600))
+	loaded, err := r.Refresh(ctx)
+	require.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, "new", value(t, repo, "a"))
+	assert.Equal(t, filepath.Join(dir, backup.Name(start.Add(time.Hour))), r.Status().Snapshot)
+	assert.Empty(t, r.Status().Error)
+}
+
+func TestHandler(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "store.snap")
+	r := New(zerolog.Nop(), Config{Source: file}, newStore(t))
+	h := NewHandler(r)
+
+	rec := httptest.NewRecorder()
+	h.Refresh(rec, httptest.NewRequest(http.MethodPost, "/admin/replica/refresh", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	require.NoError(t, os.WriteFile(file, snapshot(t, map[string]string{"a": "1"}), 0o600))
+	rec = httptest.NewRecorder()
+	h.Refresh(rec, httptest.NewRequest(http.MethodPost, "/admin/replica/refresh", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.Status(rec, httptest.NewRequest(http.MethodGet, "/admin/replica", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp StatusResponse
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, file, resp.Data.Source)
+	assert.Equal(t, file, resp.Data.Snapshot)
+	assert.NotNil(t, resp.Data.LoadedAt)
+	assert.Empty(t, resp.Data.Error)
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The store compacts its files as their thresholds trip by default: the
+// tables of the storage engine past LSMConfig.MaxTables, the tier file once
+// mostly garbage, and the tombstones past their retention, purged by the
+// reaper. With compaction windows, these wait for the daily windows of low
+// traffic instead. Within a window, a compaction step runs every
+// CompactionConfig.Interval while the store serves fewer operations per
+// second than CompactionConfig.MaxQPS, the first of these with work to do:
+//
+//   - purge the stale tombstones,
+//   - fold the log of the storage engine into a table,
+//   - merge the tables of the storage engine into one,
+//   - rewrite the tier file without its garbage.
+//
+// Expired keys are still removed by the reaper, and the memtable of the
+// engine flushed past its size, so that memory stays bounded.
+
+// DefaultCompactionInterval is applied to a zero CompactionConfig.Interval.
+const DefaultCompactionInterval = time.Minute
+
+// CompactionConfig schedules the compactions of the store.
+type CompactionConfig struct {
+	// Windows are the daily windows of low traffic compactions are
+	// deferred to, such as 01:00-05:00 in the local time of the server,
+	// possibly running past midnight. Empty compacts as thresholds trip.
+	Windows []string `envconfig:"WINDOWS"`
+	// MaxQPS is the rate of operations per second above which compaction
+	// pauses within a window, zero never pausing.
+	MaxQPS float64 `envconfig:"MAX_QPS" default:"100"`
+	// Interval is the time between the compaction steps within a window.
+	// DefaultCompactionInterval when zero.
+	Interval time.Duration `envconfig:"INTERVAL" default:"1m"`
+}
+
+// Window is a daily time window, in minutes since midnight.
+type Window struct {
+	start, end int
+}
+
+// ParseWindows parses windows of the form HH:MM-HH:MM.
+func ParseWindows(specs []string) ([]Window, error) {
+	windows := make([]Window, 0, len(specs))
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid compaction window %q: expected HH:MM-HH:MM", spec)
+		}
+		start, err := parseClock(from)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compaction window %q: %w", spec, err)
+		}
+		end, err := parseClock(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid compaction window %q: %w", spec, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("invalid compaction window %q: empty", spec)
+		}
+		windows = append(windows, Window{start: start, end: end})
+	}
+	return windows, nil
+}
+
+// parseClock returns the minutes since midnight of a HH:MM time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("time %q is not HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// contains reports whether t falls within the window.
+func (w Window) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return w.start <= m && m < w.end
+	}
+	return m >= w.start || m < w.end
+}
+
+// compactionDue reports whether a compaction step runs at now, while the
+// store serves qps operations per second.
+func (k *KeyValueStore) compactionDue(now time.Time, qps float64) bool {
+	if maxQPS := k.opts.Compaction.MaxQPS; maxQPS > 0 && qps > maxQPS {
+		return false
+	}
+	for _, w := range k.windows {
+		if w.contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCompactions runs a compaction step every interval when due, until the
+// store is closed.
+func (k *KeyValueStore) runCompactions() {
+	defer k.wg.Done()
+
+	ticker := time.NewTicker(k.opts.Compaction.Interval)
+	defer ticker.Stop()
+
+	last, lastOps := k.now(), k.ops.Load()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-ticker.C:
+			now, ops := k.now(), k.ops.Load()
+			qps := float64(ops-lastOps) / now.Sub(last).Seconds()
+			last, lastOps = now, ops
+			if !k.compactionDue(now, qps) {
+				continue
+			}
+			if step, err := k.compactStep(); err != nil {
+				k.log.Error().Err(err).Str("step", step).Msg("compaction failed")
+			} else if step != "" {
+				k.log.Debug().Str("step", step).Float64("qps", qps).Msg("compacted")
+			}
+		}
+	}
+}
+
+// compactStep runs the first compaction with work to do, and returns its
+// name, empty when there was none.
+func (k *KeyValueStore) compactStep() (string, error) {
+	if n := k.reap(true); n > 0 {
+		return "purge tombstones", nil
+	}
+	if k.engine != nil {
+		if folded, err := k.engine.fold(); folded || err != nil {
+			return "fold log", err
+		}
+		if k.engine.tableCount() > 1 {
+			return "merge tables", k.engine.compactTables()
+		}
+	}
+	if k.tier != nil && k.tier.fragmented() {
+		return "rewrite tier file", k.compactTier()
+	}
+	return "", nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWindows(t *testing.T) {
+	windows, err := ParseWindows([]string{"01:00-05:30", " 22:00 - 02:00 "})
+	require.NoError(t, err)
+	assert.Equal(t, []Window{{start: 60, end: 330}, {start: 1320, end: 120}}, windows)
+
+	at := func(clock string) time.Time {
+		at, err := time.Parse("15:04", clock)
+		require.NoError(t, err)
+		return at
+	}
+	assert.True(t, windows[0].contains(at("01:00")))
+	assert.True(t, windows[0].contains(at("05:29")))
+	assert.False(t, windows[0].contains(at("05:30")))
+	assert.False(t, windows[0].contains(at("00:59")))
+	assert.True(t, windows[1].contains(at("23:59")))
+	assert.True(t, windows[1].contains(at("00:00")))
+	assert.False(t, windows[1].contains(at("02:00")))
+	assert.False(t, windows[1].contains(at("12:00")))
+
+	for _, spec := range []string{"01:00", "1am-2am", "01:00-24:00", "03:00-03:00"} {
+		_, err := ParseWindows([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestCompactionDue(t *testing.T) {
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{Compaction: CompactionConfig{
+		Windows:  []string{"01:00-03:00"},
+		MaxQPS:   10,
+		Interval: time.Hour,
+	}})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	night := time.Date(2024, 1, 1, 2, 0, 0, 0, time.Local)
+	assert.True(t, kvs.compactionDue(night, 5))
+	assert.False(t, kvs.compactionDue(night, 20), "busy")
+	assert.False(t, kvs.compactionDue(night.Add(2*time.Hour), 0), "outside the window")
+}
+
+func TestCompactionSteps(t *testing.T) {
+	ctx := context.Background()
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{
+		TombstoneRetention: time.Nanosecond,
+		LSM:                LSMConfig{Dir: t.TempDir(), MemtableSize: 1 << 10, MaxTables: 1},
+		Compaction:         CompactionConfig{Windows: []string{"01:00-03:00"}, Interval: time.Hour},
+	})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := range 100 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%02d", i), value))
+	}
+	for i := range 10 {
+		require.NoError(t, kvs.Delete(ctx, fmt.Sprintf("k%02d", i)))
+	}
+	assert.Greater(t, kvs.engine.tableCount(), 1)
+	assert.Empty(t, kvs.engine.compact, "the tables are left to the windows")
+
+	// The reaper leaves the stale tombstones to the windows.
+	assert.Zero(t, kvs.reapExpired())
+
+	var steps []string
+	for {
+		step, err := kvs.compactStep()
+		require.NoError(t, err)
+		if step == "" {
+			break
+		}
+		steps = append(steps, step)
+	}
+	assert.Equal(t, []string{"purge tombstones", "fold log", "merge tables"}, steps)
+	assert.Equal(t, 1, kvs.engine.tableCount())
+	kvs.mu.RLock()
+	assert.Len(t, kvs.data, 90)
+	kvs.mu.RUnlock()
+	items, err := kvs.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, items, 90)
+}
+
+func TestCompactionStepsTier(t *testing.T) {
+	ctx := context.Background()
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{
+		Tier:       TierConfig{Dir: t.TempDir(), MaxMemory: 1 << 10},
+		Compaction: CompactionConfig{Windows: []string{"01:00-03:00"}, Interval: time.Hour},
+	})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	value := bytes.Repeat([]byte("v"), 100)
+	for i := range 20 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%02d", i), value))
+	}
+	require.Eventually(t, func() bool {
+		kvs.mu.RLock()
+		defer kvs.mu.RUnlock()
+		return kvs.data["k00"].cold != nil
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, kvs.Set(ctx, "k00", []byte("small")))
+	require.True(t, kvs.tier.fragmented())
+
+	step, err := kvs.compactStep()
+	require.NoError(t, err)
+	assert.Equal(t, "rewrite tier file", step)
+	assert.False(t, kvs.tier.fragmented())
+	got, _, err := kvs.Get(ctx, "k01")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+}
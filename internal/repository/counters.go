@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// Counters are values holding a JSON object with a single int64 "counter"
+// field, so they read like any other value but can't be mistaken for the
+// base-10 strings Increment operates on. Updates past the int64 range
+// follow the Overflow mode of the request.
+
+// ErrNotCounter is returned when a counter operation targets a value that
+// is not a counter.
+var ErrNotCounter = errors.New("value is not a counter")
+
+// Overflow selects how a counter update past the int64 range is resolved.
+type Overflow uint8
+
+const (
+	// OverflowError fails the update with ErrOverflow, leaving the counter
+	// unchanged.
+	OverflowError Overflow = iota
+	// OverflowWrap wraps the counter around, in two's complement.
+	OverflowWrap
+	// OverflowSaturate clamps the counter to math.MaxInt64 or
+	// math.MinInt64.
+	OverflowSaturate
+)
+
+// DecodeCounter returns the count of a counter value.
+func DecodeCounter(value []byte) (int64, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil || len(fields) != 1 {
+		return 0, ErrNotCounter
+	}
+	raw, ok := fields["counter"]
+	if !ok {
+		return 0, ErrNotCounter
+	}
+	n, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil {
+		return 0, ErrNotCounter
+	}
+	return n, nil
+}
+
+// EncodeCounter returns the value of a counter holding n.
+func EncodeCounter(n int64) []byte {
+	return []byte(`{"counter":` + strconv.FormatInt(n, 10) + `}`)
+}
+
+// AddCounter atomically adds delta to the counter stored at key and returns
+// the new count. A missing key is treated as a zero counter, so the first
+// update creates it. The expiry of an existing key is preserved.
+func (k *KeyValueStore) AddCounter(ctx context.Context, key string, delta int64, overflow Overflow) (int64, error) {
+	var current int64
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, current, err = ApplyAddCounter(value, exists, delta, overflow)
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return current, nil
+}
+
+// ApplyAddCounter returns the counter value, missing unless exists, with
+// delta added, and its new count, as AddCounter stores it.
+func ApplyAddCounter(value []byte, exists bool, delta int64, overflow Overflow) ([]byte, int64, error) {
+	var current int64
+	if exists {
+		var err error
+		if current, err = DecodeCounter(value); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	sum := current + delta
+	if (delta > 0 && sum < current) || (delta < 0 && sum > current) {
+		switch overflow {
+		case OverflowWrap:
+		case OverflowSaturate:
+			sum = math.MaxInt64
+			if delta < 0 {
+				sum = math.MinInt64
+			}
+		default:
+			return nil, 0, ErrOverflow
+		}
+	}
+	return EncodeCounter(sum), sum, nil
+}
+
+// ResetCounter atomically sets the counter stored at key to n and returns
+// its previous count, zero if the key is missing. The expiry of an existing
+// key is preserved.
+func (k *KeyValueStore) ResetCounter(ctx context.Context, key string, n int64) (int64, error) {
+	var previous int64
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, previous, err = ApplyResetCounter(value, exists, n)
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return previous, nil
+}
+
+// ApplyResetCounter returns the value of a counter holding n replacing the
+// counter value, missing unless exists, and its previous count, as
+// ResetCounter stores it.
+func ApplyResetCounter(value []byte, exists bool, n int64) ([]byte, int64, error) {
+	var previous int64
+	if exists {
+		var err error
+		if previous, err = DecodeCounter(value); err != nil {
+			return nil, 0, err
+		}
+	}
+	return EncodeCounter(n), previous, nil
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreCounters(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AddCounter creates the counter and returns the new count", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		n, err := store.AddCounter(ctx, "hits", 5, OverflowError)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), n)
+		n, err = store.AddCounter(ctx, "hits", -7, OverflowError)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-2), n)
+
+		value, _, err := store.Get(ctx, "hits")
+		require.NoError(t, err)
+		assert.Equal(t, `{"counter":-2}`, string(value))
+	})
+
+	t.Run("overflow follows the overflow mode", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			start    int64
+			delta    int64
+			overflow Overflow
+			want     int64
+			wantErr  error
+		}{
+			{"error on overflow", math.MaxInt64, 1, OverflowError, math.MaxInt64, ErrOverflow},
+			{"error on underflow", math.MinInt64, -1, OverflowError, math.MinInt64, ErrOverflow},
+			{"wrap on overflow", math.MaxInt64, 2, OverflowWrap, math.MinInt64 + 1, nil},
+			{"wrap on underflow", math.MinInt64, -1, OverflowWrap, math.MaxInt64, nil},
+			{"saturate on overflow", math.MaxInt64 - 1, 5, OverflowSaturate, math.MaxInt64, nil},
+			{"saturate on underflow", math.MinInt64 + 1, math.MinInt64, OverflowSaturate, math.MinInt64, nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+				require.NoError(t, store.Set(ctx, "hits", EncodeCounter(tt.start)))
+
+				_, err := store.AddCounter(ctx, "hits", tt.delta, tt.overflow)
+				assert.ErrorIs(t, err, tt.wantErr)
+
+				value, _, err := store.Get(ctx, "hits")
+				require.NoError(t, err)
+				n, err := DecodeCounter(value)
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, n)
+			})
+		}
+	})
+
+	t.Run("ResetCounter returns the previous count", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.SetWithTTL(ctx, "hits", EncodeCounter(42), time.Hour))
+
+		previous, err := store.ResetCounter(ctx, "hits", 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), previous)
+
+		value, _, err := store.Get(ctx, "hits")
+		require.NoError(t, err)
+		assert.Equal(t, `{"counter":0}`, string(value))
+		// The expiry is preserved.
+		expiresAt, _, err := store.Expiry(ctx, "hits")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+
+		previous, err = store.ResetCounter(ctx, "missing", 3)
+		require.NoError(t, err)
+		assert.Zero(t, previous)
+	})
+
+	t.Run("values other than counters are rejected", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "integer", []byte("1")))
+		require.NoError(t, store.Set(ctx, "object", []byte(`{"counter":1,"other":2}`)))
+		require.NoError(t, store.Set(ctx, "float", []byte(`{"counter":1.5}`)))
+
+		for _, key := range []string{"integer", "object", "float"} {
+			_, err := store.AddCounter(ctx, key, 1, OverflowError)
+			assert.ErrorIs(t, err, ErrNotCounter)
+			_, err = store.ResetCounter(ctx, key, 0)
+			assert.ErrorIs(t, err, ErrNotCounter)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"codesignal/internal/jsonpath"
+)
+
+// Documents are values holding any JSON document. Values addressed by a
+// JSONPath are set atomically by the store, sparing clients the transfer
+// of the whole document, see jsonpath.Path.
+
+// ErrNotDocument is returned when a document operation targets a value
+// that is not JSON.
+var ErrNotDocument = errors.New("value is not a JSON document")
+
+// DecodeDocument returns the decoded JSON document of value. Numbers are
+// decoded as json.Number, so they are encoded back without losing
+// precision.
+func DecodeDocument(value []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(value))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, ErrNotDocument
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, ErrNotDocument
+	}
+	return doc, nil
+}
+
+// EncodeDocument returns the value of the JSON document doc, compacted,
+// with the members of objects sorted by name.
+func EncodeDocument(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// SetPath atomically replaces the value addressed by path in the document
+// stored at key with the JSON value. Only the root path creates a missing
+// key, other missing values fail with jsonpath.ErrNotFound. The expiry of
+// an existing key is preserved. A maxSize over zero bounds the size of the
+// document value.
+func (k *KeyValueStore) SetPath(ctx context.Context, key, path string, value []byte, maxSize int) error {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return err
+	}
+	sub, err := DecodeDocument(value)
+	if err != nil {
+		return err
+	}
+
+	return k.modify(ctx, key, func(current []byte, exists bool) ([]byte, error) {
+		return ApplySetPath(current, exists, p, sub, maxSize)
+	})
+}
+
+// ApplySetPath returns the document value, missing unless exists, with the
+// value addressed by p replaced by the decoded document sub, as SetPath
+// stores it.
+func ApplySetPath(value []byte, exists bool, p jsonpath.Path, sub any, maxSize int) ([]byte, error) {
+	var doc any
+	if exists {
+		var err error
+		if doc, err = DecodeDocument(value); err != nil {
+			return nil, err
+		}
+	} else if !p.IsRoot() {
+		return nil, jsonpath.ErrNotFound
+	}
+
+	doc, err := p.Set(doc, sub)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := EncodeDocument(doc)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && len(updated) > maxSize {
+		return nil, ErrTooLarge
+	}
+	return updated, nil
+}
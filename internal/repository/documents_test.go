@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/jsonpath"
+)
+
+func TestKeyValueStoreDocuments(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SetPath updates the document in place", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.SetWithTTL(ctx, "doc", []byte(`{"b":{"n":12345678901234567890},"a":[1,2]}`), time.Hour))
+
+		require.NoError(t, store.SetPath(ctx, "doc", "$.a[1]", []byte(`{"x":"<y>"}`), 0))
+		require.NoError(t, store.SetPath(ctx, "doc", "$.b.m", []byte(`true`), 0))
+
+		value, _, err := store.Get(ctx, "doc")
+		require.NoError(t, err)
+		// Large numbers keep their precision.
+		assert.Equal(t, `{"a":[1,{"x":"<y>"}],"b":{"m":true,"n":12345678901234567890}}`, string(value))
+		// The expiry is preserved.
+		expiresAt, _, err := store.Expiry(ctx, "doc")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+	})
+
+	t.Run("only the root path creates a missing key", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		err := store.SetPath(ctx, "doc", "$.a", []byte(`1`), 0)
+		assert.ErrorIs(t, err, jsonpath.ErrNotFound)
+		_, exists, err := store.Get(ctx, "doc")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		require.NoError(t, store.SetPath(ctx, "doc", "$", []byte(`{"a":1}`), 0))
+		value, _, err := store.Get(ctx, "doc")
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(value))
+	})
+
+	t.Run("invalid documents, paths and values are rejected", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "text", []byte("plain text")))
+		require.NoError(t, store.Set(ctx, "doc", []byte(`{"a":1}`)))
+
+		assert.ErrorIs(t, store.SetPath(ctx, "text", "$.a", []byte(`1`), 0), ErrNotDocument)
+		assert.ErrorIs(t, store.SetPath(ctx, "doc", "$.a", []byte(`{`), 0), ErrNotDocument)
+		var syntaxErr *jsonpath.SyntaxError
+		assert.ErrorAs(t, store.SetPath(ctx, "doc", "a", []byte(`1`), 0), &syntaxErr)
+		assert.ErrorIs(t, store.SetPath(ctx, "doc", "$.a.b", []byte(`1`), 0), jsonpath.ErrNotFound)
+	})
+
+	t.Run("SetPath bounds the size of the document", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "doc", []byte(`{"a":1}`)))
+
+		err := store.SetPath(ctx, "doc", "$.b", []byte(`"long"`), 10)
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		value, _, err := store.Get(ctx, "doc")
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(value))
+	})
+}
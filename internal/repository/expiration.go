@@ -0,0 +1,318 @@
+package repository
+
+import (
+	"container/heap"
+	"context"
+	"time"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+)
+
+// reapable reports whether the entry can be physically removed at now,
+// either because its TTL elapsed or because it is a tombstone older than
+// the retention window.
+func (e entry) reapable(now int64, retention time.Duration) bool {
+	if e.expired(now) {
+		return true
+	}
+	return e.tombstone() && e.deletedAt+int64(retention) <= now
+}
+
+// timeKey is the context key of the time set by WithTime.
+type timeKey struct{}
+
+// WithTime returns a context evaluating the writes of the store at t rather
+// than at the current time: the keys alive, their expiries and deletion
+// times are those as of t. The replicas of a log apply its commands at the
+// time they were proposed, so they agree on them whatever their clocks.
+func WithTime(ctx context.Context, t time.Time) context.Context {
+	return context.WithValue(ctx, timeKey{}, t)
+}
+
+// clock returns the time the write of ctx is evaluated at.
+func (k *KeyValueStore) clock(ctx context.Context) time.Time {
+	if t, ok := ctx.Value(timeKey{}).(time.Time); ok {
+		return t
+	}
+	return k.now()
+}
+
+// SetHorizon bounds the removals of expired keys and stale tombstones, by
+// the reaper, reads and snapshots, to those due at t, a zero t lifting the
+// bound. A replica sets it to the time of the last command it applied, so
+// that it doesn't remove a key ahead of the commands still to apply.
+func (k *KeyValueStore) SetHorizon(t time.Time) {
+	var horizon int64
+	if !t.IsZero() {
+		horizon = t.UnixNano()
+	}
+	k.horizon.Store(horizon)
+}
+
+// settled returns the time in unix nanoseconds up to which expired keys
+// and stale tombstones are removed.
+func (k *KeyValueStore) settled() int64 {
+	now := k.now().UnixNano()
+	if horizon := k.horizon.Load(); horizon != 0 {
+		return min(now, horizon)
+	}
+	return now
+}
+
+// runReaper removes expired keys and stale tombstones as they are due,
+// waiting ReapInterval at least between two runs, until the store is
+// closed.
+func (k *KeyValueStore) runReaper() {
+	defer k.wg.Done()
+
+	timer := time.NewTimer(k.opts.ReapInterval)
+	defer timer.Stop()
+
+	// last is the time of the last run, none at first.
+	var last time.Time
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-k.wake:
+			// A key is due before the one waited for.
+		case <-timer.C:
+			if n := k.reapExpired(); n > 0 {
+				k.log.Debug().Int("count", n).Msg("reaped expired keys")
+			}
+			last = k.now()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		next, ok := k.nextDue()
+		if !ok {
+			// Nothing is due until a key is written with a deadline.
+			continue
+		}
+		wait := max(time.Until(time.Unix(0, next)), last.Add(k.opts.ReapInterval).Sub(k.now()))
+		timer.Reset(max(wait, 0))
+	}
+}
+
+// reapExpired removes all expired keys and stale tombstones and returns
+// how many entries were removed. With compaction windows, stale tombstones
+// are left to them.
+func (k *KeyValueStore) reapExpired() int {
+	return k.reap(len(k.windows) == 0)
+}
+
+// reap removes all expired keys, and stale tombstones when purge is true,
+// and returns how many entries were removed. The keys due are taken from
+// the deadline heaps in batches of ReapBatchSize under the write lock, so
+// readers and writers get a chance to run between batches.
+func (k *KeyValueStore) reap(purge bool) int {
+	total := 0
+	for {
+		now := k.settled()
+
+		var expired, purged int64
+		failed, n := false, 0
+		k.mu.Lock()
+		for ; n < k.opts.ReapBatchSize; n++ {
+			key, ok := k.due(now, purge)
+			if !ok {
+				break
+			}
+			e := k.data[key]
+			if err := k.remove(key); err != nil {
+				k.log.Error().Err(err).Str("key", key).Msg("failed to reap key")
+				failed = true
+				break
+			}
+			if e.tombstone() {
+				purged++
+			} else {
+				expired++
+				k.publish(events.TypeExpire, key, nil)
+			}
+		}
+		k.mu.Unlock()
+
+		metrics.ExpiredKeys.Add(expired)
+		metrics.PurgedTombstones.Add(purged)
+		total += int(expired + purged)
+
+		// A failed removal is retried by the next run rather than right
+		// away.
+		if failed || n < k.opts.ReapBatchSize {
+			return total
+		}
+	}
+}
+
+// due returns a key removable at now, stale tombstones only when purge is
+// true, with the write lock held.
+func (k *KeyValueStore) due(now int64, purge bool) (string, bool) {
+	if d, ok := k.expiries.next(); ok && d.at <= now {
+		return d.key, true
+	}
+	if d, ok := k.purges.next(); purge && ok && d.at <= now {
+		return d.key, true
+	}
+	return "", false
+}
+
+// nextDue returns the time in unix nanoseconds at which the next key is
+// due for the reaper.
+func (k *KeyValueStore) nextDue() (int64, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	next, ok := k.expiries.next()
+	if d, purge := k.purges.next(); purge && len(k.windows) == 0 && (!ok || d.at < next.at) {
+		next, ok = d, true
+	}
+	return next.at, ok
+}
+
+// track updates the deadlines of key for the entry e replacing it, with the
+// write lock held, and wakes the reaper when it is due first.
+func (k *KeyValueStore) track(key string, e entry) {
+	// Both deadlines are updated whichever is due first.
+	expires, purges := k.expiries.set(key, e.expiresAt), k.purges.set(key, k.purgeAt(e))
+	if expires || purges {
+		select {
+		case k.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// untrack removes the deadlines of key, with the write lock held.
+func (k *KeyValueStore) untrack(key string) {
+	k.expiries.set(key, 0)
+	k.purges.set(key, 0)
+}
+
+// trackAll replaces the deadlines with those of entries, with the write
+// lock held.
+func (k *KeyValueStore) trackAll(entries map[string]entry) {
+	k.expiries.reset(entries, func(e entry) int64 { return e.expiresAt })
+	k.purges.reset(entries, k.purgeAt)
+	select {
+	case k.wake <- struct{}{}:
+	default:
+	}
+}
+
+// purgeAt returns the time in unix nanoseconds at which the tombstone e is
+// purged, zero for live entries.
+func (k *KeyValueStore) purgeAt(e entry) int64 {
+	if !e.tombstone() {
+		return 0
+	}
+	return e.deletedAt + int64(k.opts.TombstoneRetention)
+}
+
+// deleteExpired removes key if it is still expired at now, within the
+// horizon. Reads call it so that an expired key is never observed,
+// regardless of reaper lag.
+func (k *KeyValueStore) deleteExpired(key string, now int64) {
+	now = min(now, k.settled())
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// Re-check under the write lock, the key may have been overwritten
+	// after the read lock was released.
+	if e, ok := k.data[key]; ok && !e.tombstone() && e.expired(now) {
+		if err := k.remove(key); err != nil {
+			k.log.Error().Err(err).Str("key", key).Msg("failed to remove expired key")
+			return
+		}
+		metrics.ExpiredKeys.Add(1)
+		k.publish(events.TypeExpire, key, nil)
+	}
+}
+
+// deadline is the time in unix nanoseconds at which a key is due.
+type deadline struct {
+	key string
+	at  int64
+}
+
+// deadlines is a min-heap of keys by deadline, indexed by key so that the
+// deadline of a key is updated in place.
+type deadlines struct {
+	items []deadline
+	index map[string]int
+}
+
+func newDeadlines() *deadlines {
+	return &deadlines{index: make(map[string]int)}
+}
+
+func (d *deadlines) Len() int           { return len(d.items) }
+func (d *deadlines) Less(i, j int) bool { return d.items[i].at < d.items[j].at }
+
+func (d *deadlines) Swap(i, j int) {
+	d.items[i], d.items[j] = d.items[j], d.items[i]
+	d.index[d.items[i].key] = i
+	d.index[d.items[j].key] = j
+}
+
+func (d *deadlines) Push(x any) {
+	item := x.(deadline)
+	d.index[item.key] = len(d.items)
+	d.items = append(d.items, item)
+}
+
+func (d *deadlines) Pop() any {
+	item := d.items[len(d.items)-1]
+	d.items[len(d.items)-1] = deadline{}
+	d.items = d.items[:len(d.items)-1]
+	delete(d.index, item.key)
+	return item
+}
+
+// set sets the deadline of key, zero removing it, and reports whether the
+// key is now the first due.
+func (d *deadlines) set(key string, at int64) bool {
+	i, ok := d.index[key]
+	switch {
+	case at == 0:
+		if ok {
+			heap.Remove(d, i)
+		}
+		return false
+	case ok:
+		if d.items[i].at == at {
+			return false
+		}
+		d.items[i].at = at
+		heap.Fix(d, i)
+	default:
+		heap.Push(d, deadline{key: key, at: at})
+	}
+	return d.index[key] == 0
+}
+
+// next returns the first key due.
+func (d *deadlines) next() (deadline, bool) {
+	if len(d.items) == 0 {
+		return deadline{}, false
+	}
+	return d.items[0], true
+}
+
+// reset replaces the deadlines with those of entries, computed by at.
+func (d *deadlines) reset(entries map[string]entry, at func(entry) int64) {
+	d.items, d.index = d.items[:0], make(map[string]int)
+	for key, e := range entries {
+		if t := at(e); t != 0 {
+			d.index[key] = len(d.items)
+			d.items = append(d.items, deadline{key: key, at: t})
+		}
+	}
+	heap.Init(d)
+}
@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/metrics"
+)
+
+func TestKeyValueStoreExpiration(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	ctx := context.Background()
+
+	t.Run("SetWithTTL records expiry", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.SetWithTTL(ctx, "session", []byte("v"), time.Minute))
+		require.NoError(t, store.Set(ctx, "config", []byte("v")))
+
+		expiresAt, exists, err := store.Expiry(ctx, "session")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, now.Add(time.Minute).UnixNano(), expiresAt.UnixNano())
+
+		expiresAt, exists, err = store.Expiry(ctx, "config")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.True(t, expiresAt.IsZero())
+
+		_, exists, err = store.Expiry(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("reaper removes expired keys in batches", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{ReapBatchSize: 3})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		for i := 0; i < 10; i++ {
+			require.NoError(t, store.SetWithTTL(ctx, fmt.Sprintf("temp-%d", i), []byte("v"), time.Second))
+		}
+		require.NoError(t, store.SetWithTTL(ctx, "later", []byte("v"), time.Hour))
+		require.NoError(t, store.Set(ctx, "forever", []byte("v")))
+
+		assert.Zero(t, store.reapExpired())
+
+		before := metrics.ExpiredKeys.Value()
+		now = now.Add(2 * time.Second)
+		assert.Equal(t, 10, store.reapExpired())
+		assert.Equal(t, before+10, metrics.ExpiredKeys.Value())
+
+		assert.Len(t, store.data, 2)
+	})
+
+	t.Run("reaper only removes keys still due", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.SetWithTTL(ctx, "renewed", []byte("v"), time.Second))
+		require.NoError(t, store.SetWithTTL(ctx, "persisted", []byte("v"), time.Second))
+		require.NoError(t, store.SetWithTTL(ctx, "deleted", []byte("v"), time.Second))
+		require.NoError(t, store.SetWithTTL(ctx, "renewed", []byte("v"), time.Hour))
+		require.NoError(t, store.Set(ctx, "persisted", []byte("v")))
+		require.NoError(t, store.Delete(ctx, "deleted"))
+
+		now = now.Add(time.Minute)
+		assert.Zero(t, store.reapExpired())
+		assert.Len(t, store.data, 2)
+
+		next, ok := store.nextDue()
+		require.True(t, ok)
+		assert.Equal(t, now.Add(-time.Minute).Add(time.Hour).UnixNano(), next)
+	})
+
+	t.Run("Get treats expired keys as missing", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.SetWithTTL(ctx, "session", []byte("v"), time.Second))
+
+		got, exists, err := store.Get(ctx, "session")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, []byte("v"), got)
+
+		before := metrics.ExpiredKeys.Value()
+		now = now.Add(time.Second)
+
+		_, exists, err = store.Get(ctx, "session")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Equal(t, before+1, metrics.ExpiredKeys.Value())
+		assert.NotContains(t, store.data, "session")
+
+		_, exists, err = store.Expiry(ctx, "session")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("Increment restarts an expired counter", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.SetWithTTL(ctx, "counter", []byte("41"), time.Second))
+		now = now.Add(time.Minute)
+
+		got, err := store.Increment(ctx, "counter", 1)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), got)
+	})
+
+	t.Run("background reaper stops on Close", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{ReapInterval: time.Millisecond})
+
+		require.NoError(t, store.SetWithTTL(ctx, "temp", []byte("v"), time.Millisecond))
+		assert.Eventually(t, func() bool {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			return len(store.data) == 0
+		}, time.Second, time.Millisecond)
+
+		require.NoError(t, store.Close())
+		require.NoError(t, store.Close())
+	})
+
+	t.Run("background reaper wakes when the next key expires", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{ReapInterval: time.Hour})
+		defer store.Close()
+
+		require.NoError(t, store.SetWithTTL(ctx, "later", []byte("v"), time.Hour))
+		require.NoError(t, store.SetWithTTL(ctx, "temp", []byte("v"), 10*time.Millisecond))
+		assert.Eventually(t, func() bool {
+			store.mu.RLock()
+			defer store.mu.RUnlock()
+			return len(store.data) == 1
+		}, time.Second, time.Millisecond)
+	})
+}
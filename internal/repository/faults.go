@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+
+	"codesignal/internal/metrics"
+)
+
+// Fault injection fails and delays the operations of the store on purpose,
+// to check clients retry and time out as they should against a store that
+// misbehaves. Faults are injected as an operation begins, before it has any
+// effect, so a failed operation never applies partially.
+
+// ErrInjected is returned by the operations failed by the fault injection.
+var ErrInjected = errors.New("injected fault")
+
+// The operations faults are injected into, the operation of FaultConfig
+// applying to them all.
+const (
+	opGet       = "get"
+	opSet       = "set"
+	opDelete    = "delete"
+	opUndelete  = "undelete"
+	opIncrement = "increment"
+	opUpdate    = "update"
+	opCombine   = "combine"
+	opScan      = "scan"
+	opHistory   = "history"
+	opAll       = "*"
+)
+
+// faultOps are the operations of FaultConfig.
+var faultOps = []string{opGet, opSet, opDelete, opUndelete, opIncrement, opUpdate, opCombine, opScan, opHistory, opAll}
+
+// FaultConfig configures the faults injected into the operations of the
+// store, keyed by operation: get, set, delete, undelete, increment, update
+// for the writes of sets, hashes, counters and documents, combine, scan,
+// history, or * for the operations without their own.
+type FaultConfig struct {
+	// ErrorRates are the fractions of the operations failing with
+	// ErrInjected, between 0 and 1, such as "get:0.1,*:0.01".
+	ErrorRates map[string]float64 `envconfig:"ERROR_RATES"`
+	// Latencies delay the operations, such as "set:50ms".
+	Latencies map[string]time.Duration `envconfig:"LATENCIES"`
+}
+
+// Validate reports the unknown operations, and the rates and latencies out
+// of range.
+func (c FaultConfig) Validate() error {
+	var problems []string
+	for op, rate := range c.ErrorRates {
+		if !slices.Contains(faultOps, op) {
+			problems = append(problems, fmt.Sprintf("unknown operation %q", op))
+		} else if rate < 0 || rate > 1 {
+			problems = append(problems, fmt.Sprintf("error rate of %s must be between 0 and 1, got %g", op, rate))
+		}
+	}
+	for op, latency := range c.Latencies {
+		if !slices.Contains(faultOps, op) {
+			problems = append(problems, fmt.Sprintf("unknown operation %q", op))
+		} else if latency < 0 {
+			problems = append(problems, fmt.Sprintf("latency of %s must not be negative, got %s", op, latency))
+		}
+	}
+	if len(problems) > 0 {
+		slices.Sort(problems)
+		return errors.New(strings.Join(slices.Compact(problems), "; "))
+	}
+	return nil
+}
+
+// enabled reports whether any fault is injected.
+func (c FaultConfig) enabled() bool {
+	return len(c.ErrorRates) > 0 || len(c.Latencies) > 0
+}
+
+// fault is the fault injected into an operation.
+type fault struct {
+	rate    float64
+	latency time.Duration
+}
+
+// faults are the faults injected by operation, nil when disabled.
+type faults map[string]fault
+
+// newFaults returns the faults configured by cfg, nil when disabled.
+func newFaults(cfg FaultConfig) faults {
+	if !cfg.enabled() {
+		return nil
+	}
+	f := make(faults, len(faultOps))
+	for _, op := range faultOps {
+		rate, ok := cfg.ErrorRates[op]
+		if !ok {
+			rate = cfg.ErrorRates[opAll]
+		}
+		latency, ok := cfg.Latencies[op]
+		if !ok {
+			latency = cfg.Latencies[opAll]
+		}
+		f[op] = fault{rate: rate, latency: latency}
+	}
+	return f
+}
+
+// inject delays op by its latency, or until ctx is done, and fails it at
+// its error rate.
+func (f faults) inject(ctx context.Context, op string) error {
+	if f == nil {
+		return nil
+	}
+	injected := f[op]
+	if injected.latency > 0 {
+		timer := time.NewTimer(injected.latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if injected.rate > 0 && rand.Float64() < injected.rate {
+		metrics.FaultsInjected.Add(1)
+		return fmt.Errorf("%w into %s", ErrInjected, op)
+	}
+	return nil
+}
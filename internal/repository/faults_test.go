@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("error rates", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{Faults: FaultConfig{
+			ErrorRates: map[string]float64{opSet: 1, opAll: 0},
+		}})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, store.Set(ctx, "key", []byte("value")), ErrInjected)
+		_, exists, err := store.Get(ctx, "key")
+		require.NoError(t, err, "the operations without a rate use the one of *")
+		assert.False(t, exists, "failed operations have no effect")
+	})
+
+	t.Run("latencies", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{Faults: FaultConfig{
+			Latencies: map[string]time.Duration{opAll: 20 * time.Millisecond},
+		}})
+		require.NoError(t, err)
+
+		start := time.Now()
+		require.NoError(t, store.Set(ctx, "key", []byte("value")))
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+		canceled, cancel := context.WithTimeout(ctx, time.Millisecond)
+		defer cancel()
+		_, _, err = store.Get(canceled, "key")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := NewKeyValueStore(zerolog.Nop(), Opts{Faults: FaultConfig{
+			ErrorRates: map[string]float64{"read": 0.5, opGet: -1},
+			Latencies:  map[string]time.Duration{"read": time.Second},
+		}})
+		assert.EqualError(t, err, `invalid faults: error rate of get must be between 0 and 1, got -1; unknown operation "read"`)
+	})
+}
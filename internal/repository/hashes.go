@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Hashes are values holding a JSON object of string fields, so they read
+// like any other value. Fields are set and deleted atomically by the store,
+// sparing clients the transfer of the whole object.
+
+// ErrNotHash is returned when a hash operation targets a value that is not
+// a JSON object of strings.
+var ErrNotHash = errors.New("value is not a hash")
+
+// DecodeHash returns the fields of a hash value.
+func DecodeHash(value []byte) (map[string]string, error) {
+	var fields map[string]string
+	if err := json.Unmarshal(value, &fields); err != nil || fields == nil {
+		return nil, ErrNotHash
+	}
+	return fields, nil
+}
+
+// EncodeHash returns the value of the hash of fields, sorted by name.
+func EncodeHash(fields map[string]string) []byte {
+	if fields == nil {
+		fields = map[string]string{}
+	}
+	value, _ := json.Marshal(fields)
+	return value
+}
+
+// SetFields atomically sets fields of the hash stored at key and returns
+// the number of fields it didn't hold. A missing key is treated as an empty
+// hash, so the first update creates it. The expiry of an existing key is
+// preserved. A maxSize over zero bounds the size of the hash value.
+func (k *KeyValueStore) SetFields(ctx context.Context, key string, fields map[string]string, maxSize int) (int, error) {
+	created := 0
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, created, err = ApplySetFields(value, exists, fields, maxSize)
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return created, nil
+}
+
+// ApplySetFields returns the hash value, missing unless exists, with fields
+// set, and the number of fields it didn't hold, as SetFields stores it.
+func ApplySetFields(value []byte, exists bool, fields map[string]string, maxSize int) ([]byte, int, error) {
+	hash := map[string]string{}
+	if exists {
+		var err error
+		if hash, err = DecodeHash(value); err != nil {
+			return nil, 0, err
+		}
+	}
+	created := 0
+	for name, v := range fields {
+		if _, ok := hash[name]; !ok {
+			created++
+		}
+		hash[name] = v
+	}
+	value = EncodeHash(hash)
+	if maxSize > 0 && len(value) > maxSize {
+		return nil, 0, ErrTooLarge
+	}
+	return value, created, nil
+}
+
+// DeleteFields atomically deletes fields of the hash stored at key and
+// returns the number of fields it held. Deleting the last field leaves an
+// empty hash.
+func (k *KeyValueStore) DeleteFields(ctx context.Context, key string, names []string) (int, error) {
+	deleted := 0
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, deleted, err = ApplyDeleteFields(value, exists, names)
+		return value, err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// ApplyDeleteFields returns the hash value, missing unless exists, without
+// the fields of names, nil when it held none, and the number of fields it
+// held, as DeleteFields stores it.
+func ApplyDeleteFields(value []byte, exists bool, names []string) ([]byte, int, error) {
+	if !exists {
+		return nil, 0, nil
+	}
+	hash, err := DecodeHash(value)
+	if err != nil {
+		return nil, 0, err
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := hash[name]; ok {
+			delete(hash, name)
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		return nil, 0, nil
+	}
+	return EncodeHash(hash), deleted, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreHashes(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SetFields creates the hash and counts the new fields", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		created, err := store.SetFields(ctx, "user", map[string]string{"name": "Alice", "email": "a@example.com"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 2, created)
+
+		created, err = store.SetFields(ctx, "user", map[string]string{"name": "Bob", "age": "30"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, created)
+
+		value, _, err := store.Get(ctx, "user")
+		require.NoError(t, err)
+		assert.Equal(t, `{"age":"30","email":"a@example.com","name":"Bob"}`, string(value))
+	})
+
+	t.Run("DeleteFields counts the deleted fields", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.SetWithTTL(ctx, "user", []byte(`{"name":"Alice","age":"30"}`), time.Hour))
+
+		deleted, err := store.DeleteFields(ctx, "user", []string{"age", "email"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+
+		value, _, err := store.Get(ctx, "user")
+		require.NoError(t, err)
+		assert.Equal(t, `{"name":"Alice"}`, string(value))
+		// The expiry is preserved.
+		expiresAt, _, err := store.Expiry(ctx, "user")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+
+		// Deleting from a missing key doesn't create it.
+		deleted, err = store.DeleteFields(ctx, "missing", []string{"name"})
+		require.NoError(t, err)
+		assert.Zero(t, deleted)
+		_, exists, err := store.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("values other than hashes are rejected", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "counter", []byte("1")))
+		require.NoError(t, store.Set(ctx, "numbers", []byte(`{"a":1}`)))
+
+		for _, key := range []string{"counter", "numbers"} {
+			_, err := store.SetFields(ctx, key, map[string]string{"a": "b"}, 0)
+			assert.ErrorIs(t, err, ErrNotHash)
+			_, err = store.DeleteFields(ctx, key, []string{"a"})
+			assert.ErrorIs(t, err, ErrNotHash)
+		}
+	})
+
+	t.Run("SetFields bounds the size of the hash", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		_, err := store.SetFields(ctx, "user", map[string]string{"a": "1"}, 9)
+		require.NoError(t, err)
+		_, err = store.SetFields(ctx, "user", map[string]string{"b": "2"}, 9)
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		value, _, err := store.Get(ctx, "user")
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":"1"}`, string(value))
+	})
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// Version is a write of a key retained by its history.
+type Version struct {
+	// Number is the version of the key the write gave it.
+	Number uint64
+	// Value is nil for deletions.
+	Value []byte
+	// Time is when the version was written.
+	Time time.Time
+	// ExpiresAt is zero when the version never expires.
+	ExpiresAt time.Time
+	// Deleted marks the deletion of the key, kept as a tombstone.
+	Deleted bool
+}
+
+// keyHistory is the history of a key.
+type keyHistory struct {
+	// versions are the retained versions, oldest first.
+	versions []Version
+}
+
+// History returns the last Opts.History versions of key, oldest first, none
+// when the history is disabled. The history of a key is kept in memory as
+// long as the key or its tombstone exists, and starts over on restarts and
+// restores.
+func (k *KeyValueStore) History(ctx context.Context, key string) ([]Version, error) {
+	if err := k.begin(ctx, opHistory); err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if h, ok := k.histories[key]; ok {
+		return slices.Clone(h.versions), nil
+	}
+	return nil, nil
+}
+
+// record adds the entry e of key, holding value, to its history with the
+// write lock held.
+func (k *KeyValueStore) record(key string, e entry, value []byte) {
+	if k.opts.History <= 0 {
+		return
+	}
+	h, ok := k.histories[key]
+	if !ok {
+		h = &keyHistory{}
+		k.histories[key] = h
+	}
+	v := Version{Number: e.version, Value: value, Time: k.now(), Deleted: e.tombstone()}
+	if v.Deleted {
+		v.Value = nil
+	}
+	if e.expiresAt != 0 {
+		v.ExpiresAt = time.Unix(0, e.expiresAt)
+	}
+	if len(h.versions) == k.opts.History {
+		h.versions = append(h.versions[:0], h.versions[1:]...)
+	}
+	h.versions = append(h.versions, v)
+}
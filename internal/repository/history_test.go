@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreHistory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("retains the last versions", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{History: 3, TombstoneRetention: time.Minute})
+		require.NoError(t, err)
+		start := time.Unix(1_700_000_000, 0)
+		now := start
+		store.now = func() time.Time { return now }
+
+		for _, value := range []string{"1", "2", "3"} {
+			require.NoError(t, store.Set(ctx, "key", []byte(value)))
+			now = now.Add(time.Second)
+		}
+		require.NoError(t, store.SetWithTTL(ctx, "key", []byte("4"), time.Hour))
+		now = now.Add(time.Second)
+		require.NoError(t, store.Delete(ctx, "key"))
+		now = now.Add(time.Second)
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, restored)
+
+		versions, err := store.History(ctx, "key")
+		require.NoError(t, err)
+		require.Len(t, versions, 3)
+		assert.Equal(t, Version{Number: 4, Value: []byte("4"), Time: start.Add(3 * time.Second), ExpiresAt: start.Add(3*time.Second + time.Hour)}, versions[0])
+		assert.Equal(t, Version{Number: 5, Time: start.Add(4 * time.Second), ExpiresAt: start.Add(3*time.Second + time.Hour), Deleted: true}, versions[1])
+		assert.Equal(t, Version{Number: 6, Value: []byte("4"), Time: start.Add(5 * time.Second), ExpiresAt: start.Add(3*time.Second + time.Hour)}, versions[2])
+
+		versions, err = store.History(ctx, "missing")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("is dropped with the key", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{History: 3})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Set(ctx, "key", []byte("1")))
+		require.NoError(t, store.Delete(ctx, "key"))
+		require.NoError(t, store.Set(ctx, "key", []byte("2")))
+		versions, err := store.History(ctx, "key")
+		require.NoError(t, err)
+		require.Len(t, versions, 1)
+		assert.Equal(t, uint64(2), versions[0].Number, "versions go on after a deletion")
+
+		store.Seed(map[string][]byte{"key": []byte("3")})
+		versions, err = store.History(ctx, "key")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Set(ctx, "key", []byte("1")))
+		versions, err := store.History(ctx, "key")
+		require.NoError(t, err)
+		assert.Empty(t, versions)
+	})
+}
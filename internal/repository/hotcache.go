@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+	"time"
+
+	"codesignal/internal/metrics"
+)
+
+// Every Get takes the read lock of the store and touches the recency of its
+// key, so a single key read by most requests makes them contend on the same
+// cache lines. The hot cache detects such keys, read Threshold times within
+// the TTL of its HotCacheConfig, and serves them from a lock-free cache for
+// the TTL, renewed while the key stays hot.
+//
+// The cache has a fixed number of slots, each key hashing to one. The
+// writes of a key bump the generation of its slot with the write lock of
+// the store held, and a cached value is only served while the generation it
+// was read at is current, so reads never return a value older than the
+// last write.
+
+// Defaults applied to the zero fields of HotCacheConfig.
+const (
+	DefaultHotCacheThreshold = 100
+	DefaultHotCacheSize      = 64
+)
+
+// HotCacheConfig configures the cache of the hot keys.
+type HotCacheConfig struct {
+	// TTL is how long the value of a hot key is cached, and the window its
+	// reads are counted over. Zero disables the cache.
+	TTL time.Duration `envconfig:"TTL" default:"100ms"`
+	// Threshold is the number of reads of a key within TTL making it hot,
+	// DefaultHotCacheThreshold when zero.
+	Threshold int `envconfig:"THRESHOLD" default:"100"`
+	// Size is the number of slots of the cache, each caching a key,
+	// DefaultHotCacheSize when zero.
+	Size int `envconfig:"SIZE" default:"64"`
+}
+
+// hotCache caches the values of the hot keys, nil when disabled.
+type hotCache struct {
+	seed      maphash.Seed
+	ttl       int64
+	threshold int64
+	slots     []hotSlot
+}
+
+// hotSlot is a slot of the hot cache.
+type hotSlot struct {
+	cached atomic.Pointer[cachedValue]
+	// generation is bumped by the writes of the keys of the slot.
+	generation atomic.Uint64
+	// counted is the hash of the key whose reads are counted since start.
+	counted atomic.Uint64
+	reads   atomic.Int64
+	start   atomic.Int64
+}
+
+// cachedValue is the value of a hot key in the cache.
+type cachedValue struct {
+	key   string
+	value []byte
+	// keyVersion is the version of the key.
+	keyVersion uint64
+	// generation is the generation of the slot the value was read at.
+	generation uint64
+	// expiresAt is the expiry of the key, and until the end of its caching.
+	expiresAt, until int64
+}
+
+// newHotCache returns the cache configured by cfg, nil if disabled.
+func newHotCache(cfg HotCacheConfig) *hotCache {
+	if cfg.TTL <= 0 {
+		return nil
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = DefaultHotCacheThreshold
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = DefaultHotCacheSize
+	}
+	return &hotCache{
+		seed:      maphash.MakeSeed(),
+		ttl:       int64(cfg.TTL),
+		threshold: int64(cfg.Threshold),
+		slots:     make([]hotSlot, cfg.Size),
+	}
+}
+
+// slot returns the slot of key, nil when the cache is disabled.
+func (c *hotCache) slot(key string) *hotSlot {
+	if c == nil {
+		return nil
+	}
+	return &c.slots[maphash.String(c.seed, key)%uint64(len(c.slots))]
+}
+
+// get returns the cached value of key at now.
+func (c *hotCache) get(s *hotSlot, key string, now int64) (*cachedValue, bool) {
+	if s == nil {
+		return nil, false
+	}
+	v := s.cached.Load()
+	if v == nil || v.key != key || v.generation != s.generation.Load() || now >= v.until || (v.expiresAt != 0 && now >= v.expiresAt) {
+		return nil, false
+	}
+	return v, true
+}
+
+// read counts a read of key missing the cache, which returned e with value
+// at generation, and caches the value once the key is hot.
+func (c *hotCache) read(s *hotSlot, key string, e entry, value []byte, generation uint64, now int64) {
+	if s == nil {
+		return
+	}
+	// A key cached until recently is still hot.
+	if v := s.cached.Load(); v == nil || v.key != key || now >= v.until+c.ttl {
+		h := maphash.String(c.seed, key)
+		if s.counted.Load() != h || now-s.start.Load() >= c.ttl {
+			s.counted.Store(h)
+			s.start.Store(now)
+			s.reads.Store(0)
+		}
+		if s.reads.Add(1) < c.threshold {
+			return
+		}
+		metrics.HotKeysCached.Add(1)
+	}
+	s.cached.Store(&cachedValue{key: key, value: value, keyVersion: e.version, generation: generation, expiresAt: e.expiresAt, until: now + c.ttl})
+}
+
+// invalidate drops the cached value of key, with the write lock of the
+// store held.
+func (c *hotCache) invalidate(key string) {
+	if s := c.slot(key); s != nil {
+		s.generation.Add(1)
+	}
+}
+
+// invalidateAll drops the cached values, with the write lock of the store
+// held.
+func (c *hotCache) invalidateAll() {
+	if c == nil {
+		return
+	}
+	for i := range c.slots {
+		c.slots[i].generation.Add(1)
+	}
+}
@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHotCache(t *testing.T) {
+	ctx := context.Background()
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{
+		TombstoneRetention: time.Minute,
+		HotCache:           HotCacheConfig{TTL: time.Hour, Threshold: 3, Size: 1},
+	})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	cached := func(key string) bool {
+		_, ok := kvs.hot.get(kvs.hot.slot(key), key, kvs.now().UnixNano())
+		return ok
+	}
+	get := func(key string) []byte {
+		t.Helper()
+		value, ok, err := kvs.Get(ctx, key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+		return value
+	}
+
+	require.NoError(t, kvs.Set(ctx, "viral", []byte("1")))
+	require.NoError(t, kvs.Set(ctx, "cold", []byte("1")))
+	get("viral")
+	get("viral")
+	assert.False(t, cached("viral"))
+	get("viral")
+	assert.True(t, cached("viral"), "the third read makes the key hot")
+
+	// Writes are never hidden by the cache.
+	require.NoError(t, kvs.Set(ctx, "viral", []byte("2")))
+	assert.False(t, cached("viral"))
+	assert.Equal(t, []byte("2"), get("viral"))
+	assert.True(t, cached("viral"), "the key stays hot")
+	n, err := kvs.Increment(ctx, "viral", 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, n)
+	assert.Equal(t, []byte("3"), get("viral"))
+
+	// Keys sharing the slot only evict it once hot.
+	get("cold")
+	assert.True(t, cached("viral"))
+	get("cold")
+	get("cold")
+	assert.True(t, cached("cold"))
+	assert.False(t, cached("viral"))
+
+	require.NoError(t, kvs.Delete(ctx, "cold"))
+	_, ok, err := kvs.Get(ctx, "cold")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	restored, err := kvs.Undelete(ctx, "cold")
+	require.NoError(t, err)
+	require.True(t, restored)
+	get("cold")
+	assert.True(t, cached("cold"))
+
+	var snapshot bytes.Buffer
+	require.NoError(t, EncodeSnapshot(&snapshot, Data{Store: map[string][]byte{"cold": []byte("restored")}}))
+	require.NoError(t, kvs.Restore(ctx, &snapshot))
+	assert.False(t, cached("cold"))
+	assert.Equal(t, []byte("restored"), get("cold"))
+}
+
+func TestHotCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{HotCache: HotCacheConfig{TTL: time.Hour, Threshold: 1}})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	require.NoError(t, kvs.SetWithTTL(ctx, "ttl", []byte("1"), 50*time.Millisecond))
+	for range 2 {
+		_, ok, err := kvs.Get(ctx, "ttl")
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+	require.Eventually(t, func() bool {
+		_, ok, err := kvs.Get(ctx, "ttl")
+		return err == nil && !ok
+	}, 5*time.Second, time.Millisecond, "the key expires while cached")
+
+	disabled, err := NewKeyValueStore(zerolog.Nop(), Opts{})
+	require.NoError(t, err)
+	defer disabled.Close()
+	assert.Nil(t, disabled.hot)
+}
@@ -0,0 +1,43 @@
+package repository
+
+import "sync"
+
+// DefaultLockStripes is the number of key lock stripes used when Opts
+// doesn't set one.
+const DefaultLockStripes = 256
+
+// keyLocks is a fixed set of mutexes that keys are hashed onto. Holding the
+// stripe of a key serializes read-modify-write operations on that key
+// without blocking operations on keys that hash to other stripes, and
+// without holding the store-wide lock while the new value is computed.
+type keyLocks struct {
+	stripes []sync.Mutex
+}
+
+func newKeyLocks(n int) *keyLocks {
+	if n <= 0 {
+		n = DefaultLockStripes
+	}
+	return &keyLocks{stripes: make([]sync.Mutex, n)}
+}
+
+// lock acquires the stripe of key and returns the function releasing it.
+func (l *keyLocks) lock(key string) func() {
+	mu := &l.stripes[l.stripe(key)]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// stripe maps key onto a stripe index using FNV-1a.
+func (l *keyLocks) stripe(key string) int {
+	const (
+		offset32 = 2166136261
+		prime32  = 16777619
+	)
+	h := uint32(offset32)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime32
+	}
+	return int(h % uint32(len(l.stripes)))
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLocks(t *testing.T) {
+	t.Run("stripe is stable and in range", func(t *testing.T) {
+		locks := newKeyLocks(16)
+		for i := 0; i < 1000; i++ {
+			key := fmt.Sprintf("key-%d", i)
+			stripe := locks.stripe(key)
+			assert.GreaterOrEqual(t, stripe, 0)
+			assert.Less(t, stripe, 16)
+			assert.Equal(t, stripe, locks.stripe(key))
+		}
+	})
+
+	t.Run("defaults stripe count", func(t *testing.T) {
+		assert.Len(t, newKeyLocks(0).stripes, DefaultLockStripes)
+	})
+
+	t.Run("other stripes are not blocked", func(t *testing.T) {
+		locks := newKeyLocks(DefaultLockStripes)
+
+		var a, b string
+		for i := 0; ; i++ {
+			b = fmt.Sprintf("key-%d", i)
+			if a == "" {
+				a = b
+				continue
+			}
+			if locks.stripe(a) != locks.stripe(b) {
+				break
+			}
+		}
+
+		unlock := locks.lock(a)
+		defer unlock()
+
+		done := make(chan struct{})
+		go func() {
+			locks.lock(b)()
+			close(done)
+		}()
+		<-done
+	})
+
+	t.Run("concurrent increments are serialized", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.New(os.Stdout), Opts{LockStripes: 4})
+		require.NoError(t, err)
+
+		const workers, perWorker = 16, 200
+		ctx := context.Background()
+
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < perWorker; j++ {
+					_, err := store.Increment(ctx, "counter", 1)
+					assert.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		got, _, err := store.Get(ctx, "counter")
+		require.NoError(t, err)
+		assert.Equal(t, []byte(fmt.Sprint(workers*perWorker)), got)
+	})
+}
@@ -0,0 +1,654 @@
+package repository
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/metrics"
+)
+
+// The store persists its keys to a log-structured engine when an engine
+// directory is configured. Every change is appended to a write-ahead log
+// and buffered in a memtable, which is flushed to an immutable table file
+// sorted by key once it exceeds LSMConfig.MemtableSize. A lookup reads the
+// memtable and then the tables, newest first. Past LSMConfig.MaxTables
+// tables, they are merged into one in the background, dropping the removed
+// and expired keys.
+//
+// The MANIFEST file lists the live tables and the current log, rewritten
+// atomically on each flush and compaction, so files of a flush or
+// compaction interrupted by a crash are ignored and removed on open.
+//
+// With an engine, the values past the memory limit of the tier are dropped
+// from memory rather than spilled to a file, and read back from the engine,
+// so the keys outlive restarts and their values can exceed the memory.
+
+// Defaults applied to zero LSMConfig fields.
+const (
+	DefaultLSMMemtableSize = 4 << 20
+	DefaultLSMMaxTables    = 8
+
+	lsmManifest = "MANIFEST"
+	lsmTableExt = ".sst"
+	lsmLogExt   = ".wal"
+	// logFrame is the size of the header of a log record, its length and
+	// CRC-32.
+	logFrame = 8
+)
+
+// LSMConfig configures the log-structured storage engine.
+type LSMConfig struct {
+	// Dir is the directory of the engine, created if needed. Empty keeps
+	// the keys in memory alone.
+	Dir string `envconfig:"DIR"`
+	// MemtableSize is the size in bytes of the changes buffered in memory
+	// past which they are flushed to a table. DefaultLSMMemtableSize when
+	// zero.
+	MemtableSize int64 `envconfig:"MEMTABLE_SIZE" default:"4194304"`
+	// MaxTables is the number of tables past which they are compacted into
+	// one. DefaultLSMMaxTables when zero.
+	MaxTables int `envconfig:"MAX_TABLES" default:"8"`
+	// SyncInterval is how often the log is synced to disk. Zero syncs it
+	// on every change.
+	SyncInterval time.Duration `envconfig:"SYNC_INTERVAL" default:"1s"`
+}
+
+// manifest lists the files of the engine.
+type manifest struct {
+	// Tables are the IDs of the live tables, oldest first.
+	Tables []uint64 `json:"tables"`
+	// Log is the ID of the current log.
+	Log uint64 `json:"log"`
+	// Next is the next free file ID.
+	Next uint64 `json:"next"`
+}
+
+// lsm is the log-structured storage engine. Its writes are serialized by
+// the write lock of the store.
+type lsm struct {
+	dir string
+	cfg LSMConfig
+	log zerolog.Logger
+
+	// compactMu serializes compactions and resets, which replace tables
+	// read without mu.
+	compactMu sync.Mutex
+
+	// mu guards the fields below.
+	mu      sync.RWMutex
+	mem     map[string]record
+	memSize int64
+	wal     *os.File
+	walID   uint64
+	dirty   bool
+	tables  []*table
+	nextID  uint64
+
+	// scheduled leaves the compactions to the compaction windows of the
+	// store rather than MaxTables.
+	scheduled bool
+	compact   chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// openLSM opens the engine of cfg, nil without directory, replaying its
+// log. Scheduled engines are compacted by the compaction windows alone.
+func openLSM(log zerolog.Logger, cfg LSMConfig, scheduled bool) (*lsm, error) {
+	if cfg.Dir == "" {
+		return nil, nil
+	}
+	if cfg.MemtableSize <= 0 {
+		cfg.MemtableSize = DefaultLSMMemtableSize
+	}
+	if cfg.MaxTables <= 0 {
+		cfg.MaxTables = DefaultLSMMaxTables
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	l := &lsm{
+		dir:       cfg.Dir,
+		cfg:       cfg,
+		log:       log,
+		mem:       make(map[string]record),
+		scheduled: scheduled,
+		compact:   make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+	if err := l.open(); err != nil {
+		l.closeFiles()
+		return nil, err
+	}
+	l.wg.Add(1)
+	go l.run()
+	if !scheduled && len(l.tables) > cfg.MaxTables {
+		l.compact <- struct{}{}
+	}
+	return l, nil
+}
+
+func (l *lsm) open() error {
+	m, err := l.readManifest()
+	if errors.Is(err, os.ErrNotExist) {
+		m = manifest{Next: 1}
+	} else if err != nil {
+		return err
+	}
+	l.nextID = m.Next
+
+	for _, id := range m.Tables {
+		t, err := openTable(id, l.path(id, lsmTableExt))
+		if err != nil {
+			return err
+		}
+		l.tables = append(l.tables, t)
+	}
+	if m.Log == 0 {
+		if err := l.commit(l.tables, true); err != nil {
+			return err
+		}
+	} else if err := l.replay(m.Log); err != nil {
+		return err
+	}
+	l.removeStale()
+	return nil
+}
+
+func (l *lsm) path(id uint64, ext string) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%06d%s", id, ext))
+}
+
+func (l *lsm) readManifest() (manifest, error) {
+	var m manifest
+	b, err := os.ReadFile(filepath.Join(l.dir, lsmManifest))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("decode %s: %w", lsmManifest, err)
+	}
+	return m, nil
+}
+
+// commit makes tables the live tables, starting a new log when rotate is
+// true, by replacing the manifest, with mu held. Nothing changes when it
+// fails. The previous log is removed, its changes being held by tables.
+func (l *lsm) commit(tables []*table, rotate bool) error {
+	m := manifest{Log: l.walID, Tables: make([]uint64, len(tables))}
+	var wal *os.File
+	if rotate {
+		m.Log = l.nextID
+		l.nextID++
+		f, err := os.OpenFile(l.path(m.Log, lsmLogExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0o600)
+		if err != nil {
+			return err
+		}
+		wal = f
+	}
+	m.Next = l.nextID
+	for i, t := range tables {
+		m.Tables[i] = t.id
+	}
+	if err := l.saveManifest(m); err != nil {
+		if wal != nil {
+			_ = wal.Close()
+			_ = os.Remove(wal.Name())
+		}
+		return err
+	}
+
+	l.tables = tables
+	if wal != nil {
+		if l.wal != nil {
+			_ = l.wal.Close()
+			_ = os.Remove(l.wal.Name())
+		}
+		l.wal, l.walID, l.dirty = wal, m.Log, false
+	}
+	return nil
+}
+
+// saveManifest atomically replaces the manifest with m.
+func (l *lsm) saveManifest(m manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(l.dir, lsmManifest)
+	tmp, err := os.CreateTemp(l.dir, lsmManifest+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(l.dir)
+}
+
+// syncDir syncs the entries of dir, so files created or renamed in it
+// survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// removeStale removes the files of the directory not listed by the
+// manifest, left by interrupted flushes and compactions.
+func (l *lsm) removeStale() {
+	live := map[string]bool{filepath.Base(l.path(l.walID, lsmLogExt)): true}
+	for _, t := range l.tables {
+		live[filepath.Base(t.path)] = true
+	}
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		l.log.Warn().Err(err).Msg("failed to list the engine directory")
+		return
+	}
+	for _, de := range entries {
+		name := de.Name()
+		stale := strings.HasSuffix(name, ".tmp") ||
+			(strings.HasSuffix(name, lsmTableExt) || strings.HasSuffix(name, lsmLogExt)) && !live[name]
+		if stale {
+			if err := os.Remove(filepath.Join(l.dir, name)); err != nil {
+				l.log.Warn().Err(err).Str("file", name).Msg("failed to remove stale engine file")
+			}
+		}
+	}
+}
+
+// replay applies the records of the log id to the memtable and opens it
+// for appending. The log is truncated at its first torn or corrupt record,
+// written by a crash.
+func (l *lsm) replay(id uint64) error {
+	path := l.path(id, lsmLogExt)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	size := int64(len(b))
+	for len(b) >= logFrame {
+		size := binary.BigEndian.Uint32(b)
+		if uint64(len(b)-logFrame) < uint64(size) {
+			break
+		}
+		payload := b[logFrame : logFrame+int(size)]
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(b[4:]) {
+			break
+		}
+		r, _, err := decodeRecord(payload)
+		if err != nil {
+			break
+		}
+		l.apply(r)
+		b = b[logFrame+int(size):]
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	l.wal, l.walID = f, id
+	if len(b) > 0 {
+		l.log.Warn().Int("bytes", len(b)).Str("file", path).Msg("discarded the torn end of the engine log")
+		if err := f.Truncate(size - int64(len(b))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// apply adds r to the memtable.
+func (l *lsm) apply(r record) {
+	if old, ok := l.mem[r.key]; ok {
+		l.memSize -= old.size()
+	}
+	l.mem[r.key] = r
+	l.memSize += r.size()
+}
+
+// appendLog appends records to the log.
+func (l *lsm) appendLog(records ...record) error {
+	var b []byte
+	for _, r := range records {
+		start := len(b)
+		b = append(b, make([]byte, logFrame)...)
+		b = appendRecord(b, r)
+		payload := b[start+logFrame:]
+		binary.BigEndian.PutUint32(b[start:], uint32(len(payload)))
+		binary.BigEndian.PutUint32(b[start+4:], crc32.ChecksumIEEE(payload))
+	}
+	if _, err := l.wal.Write(b); err != nil {
+		return fmt.Errorf("write engine log: %w", err)
+	}
+	return nil
+}
+
+// write applies r, logged before it returns. The memtable is flushed once
+// it exceeds its size, a failed flush being retried by the next write as
+// the changes remain logged.
+func (l *lsm) write(r record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.appendLog(r); err != nil {
+		return err
+	}
+	if l.cfg.SyncInterval <= 0 {
+		if err := l.wal.Sync(); err != nil {
+			return fmt.Errorf("sync engine log: %w", err)
+		}
+	} else {
+		l.dirty = true
+	}
+	l.apply(r)
+	if l.memSize >= l.cfg.MemtableSize {
+		if err := l.flush(); err != nil {
+			l.log.Error().Err(err).Msg("failed to flush the engine memtable")
+		}
+	}
+	return nil
+}
+
+// flush writes the memtable to a new table and starts a new log, with mu
+// held.
+func (l *lsm) flush() error {
+	records := make(sliceIterator, 0, len(l.mem))
+	for _, r := range l.mem {
+		records = append(records, r)
+	}
+	slices.SortFunc(records, func(a, b record) int { return strings.Compare(a.key, b.key) })
+	t, err := l.writeTable(&records)
+	if err != nil {
+		return err
+	}
+	if err := l.commit(append(slices.Clip(l.tables), t), true); err != nil {
+		_ = t.close()
+		_ = os.Remove(t.path)
+		return err
+	}
+	l.mem = make(map[string]record)
+	l.memSize = 0
+	metrics.LSMFlushes.Add(1)
+
+	if !l.scheduled && len(l.tables) > l.cfg.MaxTables {
+		select {
+		case l.compact <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// writeTable writes the records of it to a new table, with a new ID.
+func (l *lsm) writeTable(it iterator) (*table, error) {
+	id := l.nextID
+	l.nextID++
+	path := l.path(id, lsmTableExt)
+	if err := writeTable(path, it); err != nil {
+		return nil, fmt.Errorf("write engine table: %w", err)
+	}
+	t, err := openTable(id, path)
+	if err != nil {
+		_ = os.Remove(path)
+		return nil, err
+	}
+	return t, nil
+}
+
+// fold flushes the memtable to a table, so the log is empty, reporting
+// whether it held changes.
+func (l *lsm) fold() (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.mem) == 0 {
+		return false, nil
+	}
+	return true, l.flush()
+}
+
+// tableCount returns the number of tables.
+func (l *lsm) tableCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.tables)
+}
+
+// get returns the latest record of key, false when the engine never held
+// it.
+func (l *lsm) get(key string) (record, bool, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if r, ok := l.mem[key]; ok {
+		return r, true, nil
+	}
+	for i := len(l.tables) - 1; i >= 0; i-- {
+		r, ok, err := l.tables[i].get(key)
+		if err != nil || ok {
+			return r, ok, err
+		}
+	}
+	return record{}, false, nil
+}
+
+// value returns the value of key, which must be held by the engine.
+func (l *lsm) value(key string) ([]byte, error) {
+	r, ok, err := l.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || r.removed {
+		return nil, fmt.Errorf("key %q missing from the engine", key)
+	}
+	return r.value, nil
+}
+
+// each calls fn with the latest record of every key held, sorted by key.
+func (l *lsm) each(fn func(record) error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	it := liveIterator{l.iterator()}
+	for {
+		r, ok, err := it.next()
+		if err != nil || !ok {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+}
+
+// iterator merges the memtable and the tables, with mu held.
+func (l *lsm) iterator() iterator {
+	mem := make(sliceIterator, 0, len(l.mem))
+	for _, r := range l.mem {
+		mem = append(mem, r)
+	}
+	slices.SortFunc(mem, func(a, b record) int { return strings.Compare(a.key, b.key) })
+	its := []iterator{&mem}
+	for i := len(l.tables) - 1; i >= 0; i-- {
+		its = append(its, &tableIterator{t: l.tables[i]})
+	}
+	return newMergeIterator(its...)
+}
+
+// reset replaces the keys of the engine with records, sorted by key.
+func (l *lsm) reset(records []record) error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	it := sliceIterator(records)
+	t, err := l.writeTable(&it)
+	if err != nil {
+		return err
+	}
+	old := l.tables
+	if err := l.commit([]*table{t}, true); err != nil {
+		_ = t.close()
+		_ = os.Remove(t.path)
+		return err
+	}
+	l.mem = make(map[string]record)
+	l.memSize = 0
+	for _, t := range old {
+		_ = t.close()
+		_ = os.Remove(t.path)
+	}
+	return nil
+}
+
+// run syncs the log every SyncInterval and compacts the tables when
+// signaled, until the engine is closed.
+func (l *lsm) run() {
+	defer l.wg.Done()
+	var tick <-chan time.Time
+	if l.cfg.SyncInterval > 0 {
+		ticker := time.NewTicker(l.cfg.SyncInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-tick:
+			if err := l.sync(); err != nil {
+				l.log.Error().Err(err).Msg("failed to sync the engine log")
+			}
+		case <-l.compact:
+			if err := l.compactTables(); err != nil {
+				l.log.Error().Err(err).Msg("failed to compact the engine tables")
+			}
+		}
+	}
+}
+
+// sync syncs the log if it changed since the last sync.
+func (l *lsm) sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.dirty {
+		return nil
+	}
+	if err := l.wal.Sync(); err != nil {
+		return err
+	}
+	l.dirty = false
+	return nil
+}
+
+// compactTables merges the tables into one, dropping the keys removed or
+// expired. The tables are read without mu, writes flushing new tables
+// meanwhile, which are kept after the merged one.
+func (l *lsm) compactTables() error {
+	l.compactMu.Lock()
+	defer l.compactMu.Unlock()
+
+	l.mu.Lock()
+	inputs := slices.Clone(l.tables)
+	id := l.nextID
+	l.nextID++
+	l.mu.Unlock()
+	if len(inputs) < 2 {
+		return nil
+	}
+
+	its := make([]iterator, len(inputs))
+	for i, t := range inputs {
+		its[len(inputs)-1-i] = &tableIterator{t: t}
+	}
+	now := time.Now().UnixNano()
+	merged := &expiryIterator{it: liveIterator{newMergeIterator(its...)}, now: now}
+	path := l.path(id, lsmTableExt)
+	if err := writeTable(path, merged); err != nil {
+		return fmt.Errorf("write engine table: %w", err)
+	}
+	t, err := openTable(id, path)
+	if err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+
+	l.mu.Lock()
+	err = l.commit(append([]*table{t}, l.tables[len(inputs):]...), false)
+	l.mu.Unlock()
+	if err != nil {
+		_ = t.close()
+		_ = os.Remove(path)
+		return err
+	}
+
+	for _, t := range inputs {
+		_ = t.close()
+		_ = os.Remove(t.path)
+	}
+	metrics.LSMCompactions.Add(1)
+	return nil
+}
+
+// expiryIterator skips the records expired at now.
+type expiryIterator struct {
+	it  iterator
+	now int64
+}
+
+func (e *expiryIterator) next() (record, bool, error) {
+	for {
+		r, ok, err := e.it.next()
+		if err != nil || !ok || r.expiresAt == 0 || r.expiresAt > e.now {
+			return r, ok, err
+		}
+	}
+}
+
+// close stops the background work of the engine and closes its files,
+// syncing the log.
+func (l *lsm) close() error {
+	if l == nil {
+		return nil
+	}
+	close(l.done)
+	l.wg.Wait()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.wal.Sync()
+	l.closeFiles()
+	return err
+}
+
+func (l *lsm) closeFiles() {
+	if l.wal != nil {
+		_ = l.wal.Close()
+	}
+	for _, t := range l.tables {
+		_ = t.close()
+	}
+}
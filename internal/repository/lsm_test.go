@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func openLSMStore(t *testing.T, opts Opts) *KeyValueStore {
+	t.Helper()
+	opts.TombstoneRetention = time.Minute
+	kvs, err := NewKeyValueStore(zerolog.Nop(), opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = kvs.Close() })
+	return kvs
+}
+
+func (l *lsm) stats() (tables, memtable int) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.tables), len(l.mem)
+}
+
+func TestLSM(t *testing.T) {
+	ctx := context.Background()
+	opts := Opts{LSM: LSMConfig{Dir: t.TempDir(), MemtableSize: 4 << 10, MaxTables: 2}}
+	kvs := openLSMStore(t, opts)
+
+	value := func(i int) []byte { return []byte(fmt.Sprintf("value-%03d-", i) + strings.Repeat("v", 100)) }
+	for i := range 300 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%03d", i), value(i)))
+	}
+	for i := range 100 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%03d", i), value(i+1000)))
+	}
+	require.NoError(t, kvs.Delete(ctx, "k000"))
+	require.NoError(t, kvs.SetWithTTL(ctx, "ttl", []byte("1"), time.Hour))
+	require.NoError(t, kvs.SetWithTTL(ctx, "expired", []byte("1"), time.Nanosecond))
+	_, err := kvs.Increment(ctx, "counter", 5)
+	require.NoError(t, err)
+	require.NoError(t, kvs.Delete(ctx, "k299"))
+	_, err = kvs.Undelete(ctx, "k299")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		tables, _ := kvs.engine.stats()
+		return tables <= 2
+	}, 5*time.Second, time.Millisecond, "the tables are compacted")
+	require.NoError(t, kvs.Close())
+
+	kvs = openLSMStore(t, opts)
+	for i := 1; i < 300; i++ {
+		want := value(i)
+		if i < 100 {
+			want = value(i + 1000)
+		}
+		got, ok, err := kvs.Get(ctx, fmt.Sprintf("k%03d", i))
+		require.NoError(t, err)
+		require.True(t, ok, i)
+		assert.Equal(t, want, got, i)
+	}
+	_, ok, err := kvs.Get(ctx, "expired")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	expiry, ok, err := kvs.Expiry(ctx, "ttl")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiry, time.Minute)
+	n, err := kvs.Increment(ctx, "counter", 1)
+	require.NoError(t, err)
+	assert.EqualValues(t, 6, n)
+
+	// The tombstone of the deleted key is kept.
+	restored, err := kvs.Undelete(ctx, "k000")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	got, _, err := kvs.Get(ctx, "k000")
+	require.NoError(t, err)
+	assert.Equal(t, value(1000), got)
+}
+
+func TestLSMTornLog(t *testing.T) {
+	ctx := context.Background()
+	opts := Opts{LSM: LSMConfig{Dir: t.TempDir()}}
+	kvs := openLSMStore(t, opts)
+	require.NoError(t, kvs.Set(ctx, "a", []byte("1")))
+	require.NoError(t, kvs.Set(ctx, "b", []byte("2")))
+	require.NoError(t, kvs.Close())
+
+	// A crash in the middle of a write leaves a partial record.
+	logs, err := filepath.Glob(filepath.Join(opts.LSM.Dir, "*"+lsmLogExt))
+	require.NoError(t, err)
+	require.Len(t, logs, 1)
+	info, err := os.Stat(logs[0])
+	require.NoError(t, err)
+	f, err := os.OpenFile(logs[0], os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	kvs = openLSMStore(t, opts)
+	items, err := kvs.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.NoError(t, kvs.Set(ctx, "c", []byte("3")))
+	require.NoError(t, kvs.Close())
+
+	after, err := os.Stat(logs[0])
+	require.NoError(t, err)
+	assert.Greater(t, after.Size(), info.Size(), "the log is truncated then appended to")
+	kvs = openLSMStore(t, opts)
+	got, ok, err := kvs.Get(ctx, "c")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, []byte("3"), got)
+}
+
+func TestLSMRestore(t *testing.T) {
+	ctx := context.Background()
+	opts := Opts{LSM: LSMConfig{Dir: t.TempDir(), MemtableSize: 1 << 10}}
+	kvs := openLSMStore(t, opts)
+	for i := range 100 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("old%d", i), bytes.Repeat([]byte("x"), 64)))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, EncodeSnapshot(&buf, Data{Store: map[string][]byte{"new": []byte("1")}}))
+	require.NoError(t, kvs.Restore(ctx, &buf))
+	tables, memtable := kvs.engine.stats()
+	assert.Equal(t, 1, tables)
+	assert.Zero(t, memtable)
+	require.NoError(t, kvs.Close())
+
+	kvs = openLSMStore(t, opts)
+	items, err := kvs.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "new", items[0].Key)
+	entries, err := os.ReadDir(opts.LSM.Dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 3, "the manifest, the log and the table alone remain")
+}
+
+func TestLSMTier(t *testing.T) {
+	ctx := context.Background()
+	opts := Opts{
+		LSM:  LSMConfig{Dir: t.TempDir(), MemtableSize: 16 << 10},
+		Tier: TierConfig{MaxMemory: 1 << 10},
+	}
+	kvs := openLSMStore(t, opts)
+	value := func(i int) []byte { return bytes.Repeat([]byte{byte('a' + i%26)}, 256) }
+	for i := range 64 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%02d", i), value(i)))
+	}
+	require.Eventually(t, func() bool {
+		hotBytes, _, _ := kvs.tier.stats()
+		return hotBytes <= 1<<10
+	}, 5*time.Second, time.Millisecond, "the values are dropped from memory")
+	assert.NoFileExists(t, filepath.Join(opts.LSM.Dir, tierFile))
+	require.NoError(t, kvs.Delete(ctx, "k00"))
+	require.NoError(t, kvs.Close())
+
+	// The values are loaded cold and read from the engine.
+	kvs = openLSMStore(t, opts)
+	kvs.mu.RLock()
+	assert.Len(t, kvs.data, 64)
+	assert.NotNil(t, kvs.data["k01"].cold)
+	kvs.mu.RUnlock()
+	items, err := kvs.Scan(ctx, "k", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 63)
+	for _, item := range items {
+		var i int
+		_, err := fmt.Sscanf(item.Key, "k%02d", &i)
+		require.NoError(t, err)
+		assert.Equal(t, value(i), item.Value, item.Key)
+	}
+	restored, err := kvs.Undelete(ctx, "k00")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	got, _, err := kvs.Get(ctx, "k00")
+	require.NoError(t, err)
+	assert.Equal(t, value(0), got)
+}
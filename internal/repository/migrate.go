@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultMigrateBatchSize is the number of entries copied per batch by
+// Migrate.
+const DefaultMigrateBatchSize = 1000
+
+// Scanner is implemented by the stores entries are migrated from.
+type Scanner interface {
+	Scan(ctx context.Context, prefix, after string, limit int) ([]Item, error)
+}
+
+// Writer is implemented by the stores entries are migrated to.
+type Writer interface {
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// MigrateOpts configures Migrate.
+type MigrateOpts struct {
+	// Prefix restricts the migration to keys starting with it.
+	Prefix string
+	// After resumes a migration after that key, the Last key of the
+	// progress reported by an interrupted migration.
+	After string
+	// BatchSize is the number of entries scanned per batch. Defaults to
+	// DefaultMigrateBatchSize.
+	BatchSize int
+	// Progress is called after each batch is written. An error aborts the
+	// migration.
+	Progress func(MigrateProgress) error
+}
+
+// MigrateProgress reports the progress of a migration.
+type MigrateProgress struct {
+	// Migrated is the number of entries written.
+	Migrated int
+	// Expired is the number of entries that expired before being written.
+	Expired int
+	// Last is the last key migrated, every key before it was written.
+	Last string
+}
+
+// Migrate copies the live entries of src to dst in key order, keeping the
+// remaining time-to-live of expiring entries. Migrations are resumed by
+// passing the Last key of their progress as After.
+func Migrate(ctx context.Context, src Scanner, dst Writer, opts MigrateOpts) (MigrateProgress, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultMigrateBatchSize
+	}
+
+	progress := MigrateProgress{Last: opts.After}
+	for {
+		items, err := src.Scan(ctx, opts.Prefix, progress.Last, opts.BatchSize)
+		if err != nil {
+			return progress, fmt.Errorf("scan after %q: %w", progress.Last, err)
+		}
+		if len(items) == 0 {
+			return progress, nil
+		}
+
+		for _, item := range items {
+			var ttl time.Duration
+			if !item.ExpiresAt.IsZero() {
+				if ttl = time.Until(item.ExpiresAt); ttl <= 0 {
+					progress.Expired++
+					progress.Last = item.Key
+					continue
+				}
+			}
+			if err := dst.SetWithTTL(ctx, item.Key, item.Value, ttl); err != nil {
+				return progress, fmt.Errorf("write %q: %w", item.Key, err)
+			}
+			progress.Migrated++
+			progress.Last = item.Key
+		}
+
+		if opts.Progress != nil {
+			if err := opts.Progress(progress); err != nil {
+				return progress, err
+			}
+		}
+		if len(items) < opts.BatchSize {
+			return progress, nil
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scannerFunc is a Scanner returning fixed items.
+type scannerFunc func(prefix, after string, limit int) ([]Item, error)
+
+func (f scannerFunc) Scan(_ context.Context, prefix, after string, limit int) ([]Item, error) {
+	return f(prefix, after, limit)
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+
+	newStore := func(t *testing.T) *KeyValueStore {
+		t.Helper()
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, err)
+		return store
+	}
+
+	t.Run("copies entries with their ttl", func(t *testing.T) {
+		src := newStore(t)
+		for i := 0; i < 25; i++ {
+			require.NoError(t, src.Set(ctx, fmt.Sprintf("user:%02d", i), []byte("v")))
+		}
+		require.NoError(t, src.SetWithTTL(ctx, "user:ttl", []byte("t"), time.Hour))
+		require.NoError(t, src.Set(ctx, "order:1", []byte("o")))
+
+		dst := newStore(t)
+		var batches []MigrateProgress
+		progress, err := Migrate(ctx, src, dst, MigrateOpts{
+			Prefix:    "user:",
+			BatchSize: 10,
+			Progress: func(p MigrateProgress) error {
+				batches = append(batches, p)
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, MigrateProgress{Migrated: 26, Last: "user:ttl"}, progress)
+		assert.Len(t, batches, 3)
+		assert.Equal(t, "user:09", batches[0].Last)
+
+		items, err := dst.Scan(ctx, "", "", 0)
+		require.NoError(t, err)
+		assert.Len(t, items, 26)
+		expiresAt, ok, err := dst.Expiry(ctx, "user:ttl")
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Second)
+	})
+
+	t.Run("resumes after a key", func(t *testing.T) {
+		src := newStore(t)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, src.Set(ctx, key, []byte(key)))
+		}
+
+		dst := newStore(t)
+		progress, err := Migrate(ctx, src, dst, MigrateOpts{After: "a"})
+		require.NoError(t, err)
+		assert.Equal(t, MigrateProgress{Migrated: 2, Last: "c"}, progress)
+
+		_, ok, err := dst.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("skips entries expired in flight", func(t *testing.T) {
+		src := scannerFunc(func(_, after string, _ int) ([]Item, error) {
+			if after != "" {
+				return nil, nil
+			}
+			return []Item{
+				{Key: "gone", Value: []byte("v"), ExpiresAt: time.Now().Add(-time.Second)},
+				{Key: "kept", Value: []byte("v")},
+			}, nil
+		})
+
+		dst := newStore(t)
+		progress, err := Migrate(ctx, src, dst, MigrateOpts{})
+		require.NoError(t, err)
+		assert.Equal(t, MigrateProgress{Migrated: 1, Expired: 1, Last: "kept"}, progress)
+	})
+
+	t.Run("stops on errors", func(t *testing.T) {
+		src := newStore(t)
+		require.NoError(t, src.Set(ctx, "a", []byte("a")))
+		require.NoError(t, src.Set(ctx, "b", []byte("b")))
+
+		checkpoint := errors.New("checkpoint failed")
+		progress, err := Migrate(ctx, src, newStore(t), MigrateOpts{
+			BatchSize: 1,
+			Progress:  func(MigrateProgress) error { return checkpoint },
+		})
+		assert.ErrorIs(t, err, checkpoint)
+		assert.Equal(t, "a", progress.Last)
+
+		failing := scannerFunc(func(string, string, int) ([]Item, error) { return nil, context.Canceled })
+		_, err = Migrate(ctx, failing, newStore(t), MigrateOpts{})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// A mapped file is a table, as written by EncodeTable or the storage
+// engine, whose values are served from a read-only memory mapping rather
+// than loaded in memory: at startup the keys of the file are indexed with
+// the location of their value, which stays in the page cache, so datasets
+// larger than the memory load quickly and are read without the heap
+// growing. Values written replace the mapped ones in memory, the file
+// itself is never modified.
+
+// mappedFile is a table file mapped in memory.
+type mappedFile struct {
+	path string
+	data []byte
+}
+
+// openMappedFile maps the table file at path in memory.
+func openMappedFile(path string) (*mappedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < tableFooter+4 {
+		return nil, fmt.Errorf("table %s: %w", path, errCorruptTable)
+	}
+	data, err := mmap(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("map %s: %w", path, err)
+	}
+	return &mappedFile{path: path, data: data}, nil
+}
+
+// each calls fn with the records of the file and the offset of their
+// value in the file, verifying the checksum of every block.
+func (m *mappedFile) each(fn func(r record, offset int64) error) error {
+	size := int64(len(m.data))
+	indexOffset, err := decodeFooter(m.data[size-tableFooter:], size)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", m.path, err)
+	}
+	index, err := decodeIndex(m.data[indexOffset : size-tableFooter])
+	if err != nil {
+		return fmt.Errorf("table %s: %w", m.path, err)
+	}
+	for _, bi := range index {
+		if bi.offset < 0 || bi.size < 0 || bi.offset+bi.size+4 > indexOffset {
+			return fmt.Errorf("table %s: %w", m.path, errCorruptTable)
+		}
+		block, err := checkBlock(m.data[bi.offset : bi.offset+bi.size+4])
+		if err != nil {
+			return fmt.Errorf("table %s: block at %d: %w", m.path, bi.offset, err)
+		}
+		for len(block) > 0 {
+			r, n, err := decodeRecord(block)
+			if err != nil {
+				return fmt.Errorf("table %s: %w", m.path, err)
+			}
+			// The value is a subslice of the mapping, its capacity running
+			// to the end of the file.
+			if err := fn(r, size-int64(cap(r.value))); err != nil {
+				return err
+			}
+			block = block[n:]
+		}
+	}
+	return nil
+}
+
+// value returns a copy of the value at c, so it outlives the mapping, with
+// the lock of the store held.
+func (m *mappedFile) value(c *coldValue) ([]byte, error) {
+	if m.data == nil {
+		return nil, ErrClosed
+	}
+	return bytes.Clone(m.data[c.offset : c.offset+c.size]), nil
+}
+
+// close unmaps the file, with the write lock of the store held.
+func (m *mappedFile) close() error {
+	if m == nil || m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return munmap(data)
+}
+
+// loadMapped indexes the entries of the mapped file that haven't expired.
+func (k *KeyValueStore) loadMapped() error {
+	now := k.now().UnixNano()
+	return k.mapped.each(func(r record, offset int64) error {
+		e := entry{expiresAt: r.expiresAt, deletedAt: r.deletedAt, version: r.version}
+		if r.removed || e.expired(now) {
+			return nil
+		}
+		e.cold = &coldValue{source: inMappedFile, offset: offset, size: int64(len(r.value))}
+		k.place(r.key, k.versioned(e))
+		return nil
+	})
+}
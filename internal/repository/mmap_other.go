@@ -0,0 +1,21 @@
+//go:build !unix
+
+package repository
+
+import (
+	"io"
+	"os"
+)
+
+// mmap reads the file in memory on platforms without memory mapping.
+func mmap(f *os.File, size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := io.ReadFull(f, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func munmap([]byte) error {
+	return nil
+}
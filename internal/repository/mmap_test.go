@@ -0,0 +1,116 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTableFile(t *testing.T, data Data) string {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, EncodeTable(&buf, data))
+	path := filepath.Join(t.TempDir(), "store.table")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+	return path
+}
+
+func TestMappedFile(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	data := Data{Store: map[string][]byte{}, Expiry: map[string]int64{
+		"ttl":     now.Add(time.Hour).UnixNano(),
+		"expired": now.Add(-time.Hour).UnixNano(),
+	}}
+	value := func(i int) []byte { return bytes.Repeat([]byte(fmt.Sprint(i)), i*10) }
+	for i := range 500 {
+		data.Store[fmt.Sprintf("k%03d", i)] = value(i)
+	}
+	data.Store["ttl"] = []byte("1")
+	data.Store["expired"] = []byte("1")
+	path := writeTableFile(t, data)
+
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{
+		MappedFile:         path,
+		TombstoneRetention: time.Minute,
+		Tier:               TierConfig{Dir: t.TempDir(), MaxMemory: 1 << 10},
+	})
+	require.NoError(t, err)
+	defer kvs.Close()
+
+	kvs.mu.RLock()
+	assert.Len(t, kvs.data, 501, "the expired key is skipped")
+	assert.Equal(t, inMappedFile, kvs.data["k001"].cold.source)
+	kvs.mu.RUnlock()
+
+	for i := range 500 {
+		got, ok, err := kvs.Get(ctx, fmt.Sprintf("k%03d", i))
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, value(i), got, i)
+	}
+	kvs.mu.RLock()
+	assert.NotNil(t, kvs.data["k001"].cold, "mapped values stay out of the heap once read")
+	kvs.mu.RUnlock()
+	expiry, ok, err := kvs.Expiry(ctx, "ttl")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Hour).UnixNano(), expiry.UnixNano())
+
+	// Writes replace the mapped values in memory.
+	for i := range 20 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%03d", i), bytes.Repeat([]byte("new"), 100)))
+	}
+	require.NoError(t, kvs.Delete(ctx, "k100"))
+	restored, err := kvs.Undelete(ctx, "k100")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	n, err := kvs.Increment(ctx, "k001", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+	assert.Zero(t, n)
+
+	// Rewriting the tier file leaves the mapped values alone.
+	require.Eventually(t, func() bool {
+		hotBytes, _, _ := kvs.tier.stats()
+		return hotBytes <= 1<<10
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, kvs.compactTier())
+	items, err := kvs.Scan(ctx, "k", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 500)
+	assert.Equal(t, bytes.Repeat([]byte("new"), 100), items[0].Value)
+	assert.Equal(t, value(100), items[100].Value)
+	assert.Equal(t, value(499), items[499].Value)
+
+	unchanged, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var want bytes.Buffer
+	require.NoError(t, EncodeTable(&want, data))
+	assert.Equal(t, want.Bytes(), unchanged, "the file isn't modified")
+
+	require.NoError(t, kvs.Close())
+	_, _, err = kvs.Get(ctx, "k499")
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestMappedFileCorrupt(t *testing.T) {
+	path := writeTableFile(t, Data{Store: map[string][]byte{"key": []byte("value")}})
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	b[3] ^= 0xff
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+
+	_, err = NewKeyValueStore(zerolog.Nop(), Opts{MappedFile: path})
+	assert.ErrorIs(t, err, errCorruptTable)
+
+	_, err = NewKeyValueStore(zerolog.Nop(), Opts{MappedFile: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -10,8 +10,10 @@
 package mock
 
 import (
+	repository "codesignal/internal/repository"
 	context "context"
 	reflect "reflect"
+	time "time"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -40,18 +42,64 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 	return m.recorder
 }
 
-// Close mocks base method.
-func (m *MockStore) Close() error {
+// AddCounter mocks base method.
+func (m *MockStore) AddCounter(ctx context.Context, key string, delta int64, overflow repository.Overflow) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Close")
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "AddCounter", ctx, key, delta, overflow)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddCounter indicates an expected call of AddCounter.
+func (mr *MockStoreMockRecorder) AddCounter(ctx, key, delta, overflow any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddCounter", reflect.TypeOf((*MockStore)(nil).AddCounter), ctx, key, delta, overflow)
+}
+
+// AddMembers mocks base method.
+func (m *MockStore) AddMembers(ctx context.Context, key string, members []string, maxSize int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddMembers", ctx, key, members, maxSize)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// Close indicates an expected call of Close.
-func (mr *MockStoreMockRecorder) Close() *gomock.Call {
+// AddMembers indicates an expected call of AddMembers.
+func (mr *MockStoreMockRecorder) AddMembers(ctx, key, members, maxSize any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStore)(nil).Close))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddMembers", reflect.TypeOf((*MockStore)(nil).AddMembers), ctx, key, members, maxSize)
+}
+
+// AddScores mocks base method.
+func (m *MockStore) AddScores(ctx context.Context, key string, members []repository.ScoredMember, maxSize int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddScores", ctx, key, members, maxSize)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddScores indicates an expected call of AddScores.
+func (mr *MockStoreMockRecorder) AddScores(ctx, key, members, maxSize any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddScores", reflect.TypeOf((*MockStore)(nil).AddScores), ctx, key, members, maxSize)
+}
+
+// Combine mocks base method.
+func (m *MockStore) Combine(ctx context.Context, op repository.SetOp, keys []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Combine", ctx, op, keys)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Combine indicates an expected call of Combine.
+func (mr *MockStoreMockRecorder) Combine(ctx, op, keys any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Combine", reflect.TypeOf((*MockStore)(nil).Combine), ctx, op, keys)
 }
 
 // Delete mocks base method.
@@ -68,6 +116,51 @@ func (mr *MockStoreMockRecorder) Delete(ctx, key any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStore)(nil).Delete), ctx, key)
 }
 
+// DeleteFields mocks base method.
+func (m *MockStore) DeleteFields(ctx context.Context, key string, names []string) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteFields", ctx, key, names)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteFields indicates an expected call of DeleteFields.
+func (mr *MockStoreMockRecorder) DeleteFields(ctx, key, names any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteFields", reflect.TypeOf((*MockStore)(nil).DeleteFields), ctx, key, names)
+}
+
+// DeleteIfVersion mocks base method.
+func (m *MockStore) DeleteIfVersion(ctx context.Context, key string, version uint64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteIfVersion", ctx, key, version)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteIfVersion indicates an expected call of DeleteIfVersion.
+func (mr *MockStoreMockRecorder) DeleteIfVersion(ctx, key, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteIfVersion", reflect.TypeOf((*MockStore)(nil).DeleteIfVersion), ctx, key, version)
+}
+
+// Expiry mocks base method.
+func (m *MockStore) Expiry(ctx context.Context, key string) (time.Time, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Expiry", ctx, key)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Expiry indicates an expected call of Expiry.
+func (mr *MockStoreMockRecorder) Expiry(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Expiry", reflect.TypeOf((*MockStore)(nil).Expiry), ctx, key)
+}
+
 // Get mocks base method.
 func (m *MockStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
 	m.ctrl.T.Helper()
@@ -84,6 +177,98 @@ func (mr *MockStoreMockRecorder) Get(ctx, key any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStore)(nil).Get), ctx, key)
 }
 
+// GetWithVersion mocks base method.
+func (m *MockStore) GetWithVersion(ctx context.Context, key string) ([]byte, uint64, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetWithVersion", ctx, key)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(uint64)
+	ret2, _ := ret[2].(bool)
+	ret3, _ := ret[3].(error)
+	return ret0, ret1, ret2, ret3
+}
+
+// GetWithVersion indicates an expected call of GetWithVersion.
+func (mr *MockStoreMockRecorder) GetWithVersion(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetWithVersion", reflect.TypeOf((*MockStore)(nil).GetWithVersion), ctx, key)
+}
+
+// History mocks base method.
+func (m *MockStore) History(ctx context.Context, key string) ([]repository.Version, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "History", ctx, key)
+	ret0, _ := ret[0].([]repository.Version)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// History indicates an expected call of History.
+func (mr *MockStoreMockRecorder) History(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "History", reflect.TypeOf((*MockStore)(nil).History), ctx, key)
+}
+
+// Increment mocks base method.
+func (m *MockStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Increment", ctx, key, delta)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Increment indicates an expected call of Increment.
+func (mr *MockStoreMockRecorder) Increment(ctx, key, delta any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Increment", reflect.TypeOf((*MockStore)(nil).Increment), ctx, key, delta)
+}
+
+// RemoveMembers mocks base method.
+func (m *MockStore) RemoveMembers(ctx context.Context, key string, members []string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveMembers", ctx, key, members)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RemoveMembers indicates an expected call of RemoveMembers.
+func (mr *MockStoreMockRecorder) RemoveMembers(ctx, key, members any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveMembers", reflect.TypeOf((*MockStore)(nil).RemoveMembers), ctx, key, members)
+}
+
+// ResetCounter mocks base method.
+func (m *MockStore) ResetCounter(ctx context.Context, key string, n int64) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetCounter", ctx, key, n)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResetCounter indicates an expected call of ResetCounter.
+func (mr *MockStoreMockRecorder) ResetCounter(ctx, key, n any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetCounter", reflect.TypeOf((*MockStore)(nil).ResetCounter), ctx, key, n)
+}
+
+// Scan mocks base method.
+func (m *MockStore) Scan(ctx context.Context, prefix, after string, limit int) ([]repository.Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Scan", ctx, prefix, after, limit)
+	ret0, _ := ret[0].([]repository.Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Scan indicates an expected call of Scan.
+func (mr *MockStoreMockRecorder) Scan(ctx, prefix, after, limit any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Scan", reflect.TypeOf((*MockStore)(nil).Scan), ctx, prefix, after, limit)
+}
+
 // Set mocks base method.
 func (m *MockStore) Set(ctx context.Context, key string, value []byte) error {
 	m.ctrl.T.Helper()
@@ -97,3 +282,76 @@ func (mr *MockStoreMockRecorder) Set(ctx, key, value any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockStore)(nil).Set), ctx, key, value)
 }
+
+// SetFields mocks base method.
+func (m *MockStore) SetFields(ctx context.Context, key string, fields map[string]string, maxSize int) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetFields", ctx, key, fields, maxSize)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetFields indicates an expected call of SetFields.
+func (mr *MockStoreMockRecorder) SetFields(ctx, key, fields, maxSize any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetFields", reflect.TypeOf((*MockStore)(nil).SetFields), ctx, key, fields, maxSize)
+}
+
+// SetIfVersion mocks base method.
+func (m *MockStore) SetIfVersion(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetIfVersion", ctx, key, value, ttl, version)
+	ret0, _ := ret[0].(uint64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SetIfVersion indicates an expected call of SetIfVersion.
+func (mr *MockStoreMockRecorder) SetIfVersion(ctx, key, value, ttl, version any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetIfVersion", reflect.TypeOf((*MockStore)(nil).SetIfVersion), ctx, key, value, ttl, version)
+}
+
+// SetPath mocks base method.
+func (m *MockStore) SetPath(ctx context.Context, key, path string, value []byte, maxSize int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetPath", ctx, key, path, value, maxSize)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetPath indicates an expected call of SetPath.
+func (mr *MockStoreMockRecorder) SetPath(ctx, key, path, value, maxSize any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetPath", reflect.TypeOf((*MockStore)(nil).SetPath), ctx, key, path, value, maxSize)
+}
+
+// SetWithTTL mocks base method.
+func (m *MockStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetWithTTL", ctx, key, value, ttl)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetWithTTL indicates an expected call of SetWithTTL.
+func (mr *MockStoreMockRecorder) SetWithTTL(ctx, key, value, ttl any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetWithTTL", reflect.TypeOf((*MockStore)(nil).SetWithTTL), ctx, key, value, ttl)
+}
+
+// Undelete mocks base method.
+func (m *MockStore) Undelete(ctx context.Context, key string) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Undelete", ctx, key)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Undelete indicates an expected call of Undelete.
+func (mr *MockStoreMockRecorder) Undelete(ctx, key any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Undelete", reflect.TypeOf((*MockStore)(nil).Undelete), ctx, key)
+}
@@ -3,68 +3,669 @@ package repository
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
 )
 
 // Store represents the interface for key-value store operations.
+//
+// Implementations must honor ctx: an operation that observes a canceled or
+// expired context returns ctx.Err() (possibly wrapped) without applying its
+// effect.
 type Store interface {
 	Set(ctx context.Context, key string, value []byte) error
+	SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error
 	Get(ctx context.Context, key string) ([]byte, bool, error)
+	GetWithVersion(ctx context.Context, key string) ([]byte, uint64, bool, error)
+	Expiry(ctx context.Context, key string) (time.Time, bool, error)
+	SetIfVersion(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error)
 	Delete(ctx context.Context, key string) error
+	DeleteIfVersion(ctx context.Context, key string, version uint64) error
+	Undelete(ctx context.Context, key string) (bool, error)
+	Increment(ctx context.Context, key string, delta int64) (int64, error)
+	AddMembers(ctx context.Context, key string, members []string, maxSize int) ([]string, error)
+	RemoveMembers(ctx context.Context, key string, members []string) ([]string, error)
+	Combine(ctx context.Context, op SetOp, keys []string) ([]string, error)
+	SetFields(ctx context.Context, key string, fields map[string]string, maxSize int) (int, error)
+	DeleteFields(ctx context.Context, key string, names []string) (int, error)
+	AddCounter(ctx context.Context, key string, delta int64, overflow Overflow) (int64, error)
+	ResetCounter(ctx context.Context, key string, n int64) (int64, error)
+	SetPath(ctx context.Context, key, path string, value []byte, maxSize int) error
+	AddScores(ctx context.Context, key string, members []ScoredMember, maxSize int) ([]string, error)
+	Scan(ctx context.Context, prefix, after string, limit int) ([]Item, error)
+	History(ctx context.Context, key string) ([]Version, error)
+}
+
+// Item is a key with its value and expiry, as returned by Scan.
+type Item struct {
+	Key   string
+	Value []byte
+	// ExpiresAt is zero when the key never expires.
+	ExpiresAt time.Time
+}
+
+var (
+	// ErrNotInteger is returned when an arithmetic operation targets a value
+	// that is not a base-10 encoded int64.
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned when an arithmetic operation would overflow int64.
+	ErrOverflow = errors.New("increment or decrement would overflow")
+	// ErrClosed is returned by Ready once the store is closed.
+	ErrClosed = errors.New("store is closed")
+)
+
+// Default tuning values for the background reaper.
+const (
+	DefaultReapBatchSize = 1000
+)
+
+// Opts configures a KeyValueStore.
+type Opts struct {
+	// ReapInterval is the minimum time between two runs of the background
+	// reaper, which wakes when the next key expires. Zero disables the
+	// reaper.
+	ReapInterval time.Duration
+	// ReapBatchSize caps the number of keys removed per write-lock acquisition
+	// so a large expiry wave doesn't stall writers.
+	ReapBatchSize int
+	// TombstoneRetention is how long deleted entries are kept as tombstones
+	// so they can be restored with Undelete. Zero removes entries
+	// immediately on Delete.
+	TombstoneRetention time.Duration
+	// LockStripes is the number of per-key lock stripes serializing
+	// mutations of the same key. Defaults to DefaultLockStripes.
+	LockStripes int
+	// ExpectedKeys is the number of keys the store is sized for up front,
+	// so warming it up or importing keys doesn't grow its map over and
+	// over. Zero sizes it as keys are written.
+	ExpectedKeys int
+	// Events receives every change applied to the store, nil disables
+	// change events.
+	Events *events.Bus
+	// Tier spills the least recently used values to disk past a memory
+	// limit, disabled without directory.
+	Tier TierConfig
+	// LSM persists the keys to a log-structured storage engine, disabled
+	// without directory. The tier then reads cold values from the engine.
+	LSM LSMConfig
+	// MappedFile is a table file whose entries are loaded at startup with
+	// their values served from a memory mapping of the file. It can't be
+	// combined with LSM.
+	MappedFile string
+	// Compaction defers the compactions of the store to windows of low
+	// traffic, run as their thresholds trip without window.
+	Compaction CompactionConfig
+	// HotCache serves the keys read the most from a lock-free cache,
+	// disabled with a zero TTL.
+	HotCache HotCacheConfig
+	// History is the number of versions of each key retained for reads of
+	// its past values, zero disabling the history.
+	History int
+	// Faults are injected into the operations of the store, for resilience
+	// testing.
+	Faults FaultConfig
+}
+
+// entry is a stored value together with its metadata.
+type entry struct {
+	value []byte
+	// cold locates the value in the tier file or marks it as read from the
+	// storage engine in place of value once spilled, nil while the value is
+	// in memory.
+	cold *coldValue
+	// expiresAt is the expiry time in unix nanoseconds, zero if the entry
+	// never expires.
+	expiresAt int64
+	// deletedAt is the deletion time in unix nanoseconds for tombstones,
+	// zero for live entries.
+	deletedAt int64
+	// version is the version of the entry, the number of the write that
+	// stored it.
+	version uint64
+}
+
+func (e entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+func (e entry) tombstone() bool {
+	return e.deletedAt != 0
+}
+
+// live reports whether the entry is visible to readers at now.
+func (e entry) live(now int64) bool {
+	return !e.tombstone() && !e.expired(now)
 }
 
 // KeyValueStore implements the Store interface with persistence.
 type KeyValueStore struct {
-	data map[string][]byte
+	data map[string]entry
 	mu   *sync.RWMutex
-	log  zerolog.Logger
+	keys *keyLocks
+	tier *tier
+	// engine persists the entries, nil without storage engine.
+	engine *lsm
+	// mapped holds the values of the entries loaded from the mapped file.
+	mapped *mappedFile
+	log    zerolog.Logger
+	opts   Opts
+	now    func() time.Time
+	// windows are the compaction windows, compactions running as their
+	// thresholds trip when empty.
+	windows []Window
+	// ops counts the operations served, pacing compactions.
+	ops atomic.Int64
+	// hot caches the values of the hot keys, nil when disabled.
+	hot *hotCache
+	// expiries and purges are the deadlines of the keys with a TTL and of
+	// the tombstones, and wake signals the reaper of a key due before the
+	// one it waits for.
+	expiries, purges *deadlines
+	wake             chan struct{}
+	// histories are the histories of the keys, when Opts.History is set.
+	histories map[string]*keyHistory
+	// views are the open point-in-time views of the entries.
+	views []*view
+	// revision is the last version given to an entry.
+	revision uint64
+	// horizon bounds the removals of expired keys and stale tombstones, in
+	// unix nanoseconds, none when zero.
+	horizon atomic.Int64
+	// faults are injected into the operations, nil when disabled.
+	faults faults
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
 }
 
 // Data represents the structure for persistence.
 type Data struct {
 	Store map[string][]byte
+	// Expiry holds the expiry of keys with a TTL in unix nanoseconds.
+	Expiry map[string]int64
+	// Version holds the versions of the keys, and Revision the last version
+	// given by the store, missing from the snapshots taken before versions.
+	Version  map[string]uint64
+	Revision uint64
 }
 
 // NewKeyValueStore creates a new instance of KeyValueStore
-func NewKeyValueStore(log zerolog.Logger) (*KeyValueStore, error) {
+func NewKeyValueStore(log zerolog.Logger, opts Opts) (*KeyValueStore, error) {
+	if opts.ReapBatchSize <= 0 {
+		opts.ReapBatchSize = DefaultReapBatchSize
+	}
+	opts.ExpectedKeys = max(opts.ExpectedKeys, 0)
+	if opts.MappedFile != "" && opts.LSM.Dir != "" {
+		return nil, errors.New("a mapped file can't be combined with a storage engine")
+	}
+	windows, err := ParseWindows(opts.Compaction.Windows)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Compaction.Interval <= 0 {
+		opts.Compaction.Interval = DefaultCompactionInterval
+	}
+	if err := opts.Faults.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid faults: %w", err)
+	}
+	engine, err := openLSM(log, opts.LSM, len(windows) > 0)
+	if err != nil {
+		return nil, fmt.Errorf("open storage engine: %w", err)
+	}
+	tier, err := openTier(opts.Tier, engine)
+	if err != nil {
+		_ = engine.close()
+		return nil, fmt.Errorf("open tier: %w", err)
+	}
+
 	kvs := &KeyValueStore{
-		mu:   &sync.RWMutex{},
-		data: make(map[string][]byte),
-		log:  log,
+		mu:        &sync.RWMutex{},
+		data:      make(map[string]entry, opts.ExpectedKeys),
+		keys:      newKeyLocks(opts.LockStripes),
+		tier:      tier,
+		engine:    engine,
+		log:       log,
+		opts:      opts,
+		now:       time.Now,
+		windows:   windows,
+		hot:       newHotCache(opts.HotCache),
+		expiries:  newDeadlines(),
+		purges:    newDeadlines(),
+		wake:      make(chan struct{}, 1),
+		histories: make(map[string]*keyHistory),
+		faults:    newFaults(opts.Faults),
+		done:      make(chan struct{}),
+	}
+	if kvs.faults != nil {
+		log.Warn().Any("error_rates", opts.Faults.ErrorRates).Any("latencies", opts.Faults.Latencies).Msg("fault injection enabled")
 	}
+	if engine != nil {
+		if err := kvs.loadEngine(); err != nil {
+			_ = tier.close()
+			_ = engine.close()
+			return nil, fmt.Errorf("load storage engine: %w", err)
+		}
+	}
+	if opts.MappedFile != "" {
+		if kvs.mapped, err = openMappedFile(opts.MappedFile); err != nil {
+			_ = tier.close()
+			return nil, err
+		}
+		if err := kvs.loadMapped(); err != nil {
+			_ = tier.close()
+			_ = kvs.mapped.close()
+			return nil, fmt.Errorf("load mapped file: %w", err)
+		}
+	}
+
+	if opts.ReapInterval > 0 {
+		kvs.wg.Add(1)
+		go kvs.runReaper()
+	}
+	if tier != nil {
+		kvs.wg.Add(1)
+		go kvs.runSpiller()
+	}
+	if len(windows) > 0 {
+		kvs.wg.Add(1)
+		go kvs.runCompactions()
+	}
+
 	return kvs, nil
 }
 
-// Seed populates the store with initial data, used in tests.
-// TODO: move this to a persistence layer
+// Close stops the background workers of the store, removes its tier file,
+// closes its storage engine and unmaps its mapped file.
+func (k *KeyValueStore) Close() error {
+	var err error
+	k.closeOnce.Do(func() {
+		close(k.done)
+		k.wg.Wait()
+		k.mu.Lock()
+		mapErr := k.mapped.close()
+		k.mu.Unlock()
+		err = errors.Join(k.tier.close(), k.engine.close(), mapErr)
+	})
+	return err
+}
+
+// loadEngine reads the entries persisted by the storage engine that haven't
+// expired, their values left cold in the engine as the tier reads them.
+func (k *KeyValueStore) loadEngine() error {
+	now := k.now().UnixNano()
+	return k.engine.each(func(r record) error {
+		e := entry{value: r.value, expiresAt: r.expiresAt, deletedAt: r.deletedAt, version: r.version}
+		if e.expired(now) {
+			return nil
+		}
+		if k.tier != nil {
+			e.value, e.cold = nil, &coldValue{source: inEngine, size: int64(len(r.value))}
+		}
+		k.place(r.key, k.versioned(e))
+		return nil
+	})
+}
+
+// newEntries returns a map for n entries, sized for the expected keys at
+// least.
+func (k *KeyValueStore) newEntries(n int) map[string]entry {
+	return make(map[string]entry, max(n, k.opts.ExpectedKeys))
+}
+
+// begin counts the operation op served by the store, failing once ctx is
+// done, and injects its faults.
+func (k *KeyValueStore) begin(ctx context.Context, op string) error {
+	k.ops.Add(1)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return k.faults.inject(ctx, op)
+}
+
+// Ready reports whether the store serves requests, until it is closed.
+func (k *KeyValueStore) Ready() error {
+	select {
+	case <-k.done:
+		return ErrClosed
+	default:
+		return nil
+	}
+}
+
+// Seed replaces the contents of the store with data, keys without expiry.
 func (k *KeyValueStore) Seed(data map[string][]byte) {
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	k.data = data
+	entries := k.newEntries(len(data))
+	for key, value := range data {
+		entries[key] = entry{value: value}
+	}
+	if err := k.replaceAll(entries); err != nil {
+		k.log.Error().Err(err).Msg("failed to seed the store")
+	}
 }
 
 // Set sets a key-value pair in the store.
 func (k *KeyValueStore) Set(ctx context.Context, key string, value []byte) error {
+	return k.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets a key-value pair in the store that expires after ttl.
+// A ttl of zero or less stores the key without expiry.
+func (k *KeyValueStore) SetWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := k.SetIfVersion(ctx, key, value, ttl, 0)
+	return err
+}
+
+// SetIfVersion is SetWithTTL, failing with ErrVersionMismatch unless key is
+// at version, any version when zero. It returns the new version of key.
+func (k *KeyValueStore) SetIfVersion(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	if err := k.begin(ctx, opSet); err != nil {
+		return 0, err
+	}
+
+	now := k.clock(ctx)
+	e := entry{value: value}
+	if ttl > 0 {
+		e.expiresAt = now.Add(ttl).UnixNano()
+	}
+
+	defer k.keys.lock(key)()
+
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	k.data[key] = value
-	return nil
+	old, exists := k.data[key]
+	live := exists && old.live(now.UnixNano())
+	if err := checkVersion(old, live, version); err != nil {
+		return 0, err
+	}
+	if err := k.put(key, e); err != nil {
+		return 0, err
+	}
+	k.publishSet(key, value, !live)
+	return k.revision, nil
+}
+
+// publish emits a change event. It is called with the write lock held so
+// events are published in the order changes are applied.
+func (k *KeyValueStore) publish(typ events.Type, key string, value []byte) {
+	k.opts.Events.Publish(events.Event{Type: typ, Key: key, Value: value, Time: k.now()})
+}
+
+// publishSet emits the TypeSet event of key, created unless it replaced a
+// live entry.
+func (k *KeyValueStore) publishSet(key string, value []byte, created bool) {
+	k.opts.Events.Publish(events.Event{Type: events.TypeSet, Key: key, Value: value, Created: created, Time: k.now()})
 }
 
-// Get retrieves a value from the store by key.
+// Get retrieves a value from the store by key. A key whose TTL has elapsed
+// is reported as missing and removed inline, even if the reaper hasn't
+// reached it yet.
 func (k *KeyValueStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, _, exists, err := k.GetWithVersion(ctx, key)
+	return value, exists, err
+}
+
+// GetWithVersion is Get, also returning the version of key.
+func (k *KeyValueStore) GetWithVersion(ctx context.Context, key string) ([]byte, uint64, bool, error) {
+	if err := k.begin(ctx, opGet); err != nil {
+		return nil, 0, false, err
+	}
+
+	now := k.now().UnixNano()
+	hot := k.hot.slot(key)
+	if v, ok := k.hot.get(hot, key, now); ok {
+		return v.value, v.keyVersion, true, nil
+	}
+
 	k.mu.RLock()
-	defer k.mu.RUnlock()
-	value, exists := k.data[key]
-	return value, exists, nil
+	e, exists := k.data[key]
+	value := e.value
+	var err error
+	if exists && e.live(now) && e.cold != nil {
+		value, err = k.value(key, e)
+	}
+	var generation uint64
+	if hot != nil {
+		generation = hot.generation.Load()
+	}
+	k.mu.RUnlock()
+
+	if !exists || e.tombstone() {
+		return nil, 0, false, nil
+	}
+	if e.expired(now) {
+		k.deleteExpired(key, now)
+		return nil, 0, false, nil
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	switch {
+	case e.cold == nil:
+		k.tier.touch(key)
+	case e.cold.source != inMappedFile:
+		// Mapped values stay in the page cache rather than the heap.
+		k.warm(key, e.cold, value)
+	}
+	k.hot.read(hot, key, e, value, generation, now)
+	return value, e.version, true, nil
+}
+
+// Expiry returns the time at which key expires. The returned time is zero
+// when the key exists but never expires.
+func (k *KeyValueStore) Expiry(ctx context.Context, key string) (time.Time, bool, error) {
+	if err := k.begin(ctx, opGet); err != nil {
+		return time.Time{}, false, err
+	}
+
+	now := k.now().UnixNano()
+
+	k.mu.RLock()
+	e, exists := k.data[key]
+	k.mu.RUnlock()
+
+	if !exists || e.tombstone() {
+		return time.Time{}, false, nil
+	}
+	if e.expired(now) {
+		k.deleteExpired(key, now)
+		return time.Time{}, false, nil
+	}
+	if e.expiresAt == 0 {
+		return time.Time{}, true, nil
+	}
+	return time.Unix(0, e.expiresAt), true, nil
 }
 
-// Delete deletes a key from the store.
+// Delete deletes a key from the store. When tombstones are enabled the
+// entry is kept as a tombstone until the retention window passes.
 func (k *KeyValueStore) Delete(ctx context.Context, key string) error {
+	return k.DeleteIfVersion(ctx, key, 0)
+}
+
+// DeleteIfVersion is Delete, failing with ErrVersionMismatch unless key is
+// at version, any version when zero.
+func (k *KeyValueStore) DeleteIfVersion(ctx context.Context, key string, version uint64) error {
+	if err := k.begin(ctx, opDelete); err != nil {
+		return err
+	}
+
+	now := k.clock(ctx).UnixNano()
+
+	defer k.keys.lock(key)()
+
 	k.mu.Lock()
 	defer k.mu.Unlock()
-	delete(k.data, key)
+
+	e, exists := k.data[key]
+	if err := checkVersion(e, exists && e.live(now), version); err != nil {
+		return err
+	}
+	if !exists || e.tombstone() {
+		return nil
+	}
+	if e.expired(now) {
+		return k.remove(key)
+	}
+	if k.opts.TombstoneRetention <= 0 {
+		if err := k.remove(key); err != nil {
+			return err
+		}
+	} else {
+		e.deletedAt = now
+		if err := k.put(key, e); err != nil {
+			return err
+		}
+	}
+	k.publish(events.TypeDelete, key, nil)
 	return nil
 }
+
+// Undelete restores a deleted key from its tombstone. It reports false when
+// there is no tombstone for key, either because it was never deleted, it
+// has since been recreated, or the retention window has passed.
+func (k *KeyValueStore) Undelete(ctx context.Context, key string) (bool, error) {
+	if err := k.begin(ctx, opUndelete); err != nil {
+		return false, err
+	}
+
+	now := k.clock(ctx).UnixNano()
+
+	defer k.keys.lock(key)()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	e, exists := k.data[key]
+	if !exists || !e.tombstone() || e.reapable(now, k.opts.TombstoneRetention) {
+		return false, nil
+	}
+
+	value, err := k.value(key, e)
+	if err != nil {
+		return false, err
+	}
+	e.deletedAt = 0
+	if err := k.put(key, e); err != nil {
+		return false, err
+	}
+	k.publishSet(key, value, true)
+	return true, nil
+}
+
+// Increment atomically adds delta to the integer stored at key and returns
+// the new value. A missing key is treated as zero, so the first increment
+// creates it. The expiry of an existing key is preserved.
+//
+// The key stripe is held for the whole read-modify-write, the store-wide
+// lock only while reading and writing the entry.
+func (k *KeyValueStore) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := k.begin(ctx, opIncrement); err != nil {
+		return 0, err
+	}
+
+	defer k.keys.lock(key)()
+
+	k.mu.RLock()
+	e, exists := k.data[key]
+	value, err := k.value(key, e)
+	k.mu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+
+	if exists && !e.live(k.clock(ctx).UnixNano()) {
+		e, exists = entry{}, false
+	}
+	value, current, err := ApplyIncrement(value, exists, delta)
+	if err != nil {
+		return 0, err
+	}
+	e.value, e.cold = value, nil
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.put(key, e); err != nil {
+		return 0, err
+	}
+	k.publishSet(key, e.value, !exists)
+	return current, nil
+}
+
+// ApplyIncrement returns the base-10 integer value, zero unless exists,
+// with delta added, and the new integer, as Increment stores it.
+func ApplyIncrement(value []byte, exists bool, delta int64) ([]byte, int64, error) {
+	var current int64
+	if exists {
+		n, err := strconv.ParseInt(string(value), 10, 64)
+		if err != nil {
+			return nil, 0, ErrNotInteger
+		}
+		current = n
+	}
+
+	if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+		return nil, 0, ErrOverflow
+	}
+	current += delta
+	return []byte(strconv.FormatInt(current, 10)), current, nil
+}
+
+// Scan returns up to limit live keys starting with prefix, in lexical
+// order, beginning after the key after. A limit of zero or less returns
+// every matching key. Callers page through the keyspace by passing the last
+// key of a page as after. The scan stops with the error of ctx once it is
+// done.
+func (k *KeyValueStore) Scan(ctx context.Context, prefix, after string, limit int) ([]Item, error) {
+	if err := k.begin(ctx, opScan); err != nil {
+		return nil, err
+	}
+
+	now := k.now().UnixNano()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var items []Item
+	i := 0
+	for key, e := range k.data {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		i++
+
+		if !strings.HasPrefix(key, prefix) || key <= after || !e.live(now) {
+			continue
+		}
+		value, err := k.value(key, e)
+		if err != nil {
+			return nil, err
+		}
+		item := Item{Key: key, Value: value}
+		if e.expiresAt != 0 {
+			item.ExpiresAt = time.Unix(0, e.expiresAt)
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	if limit > 0 && len(items) > limit {
+		items = items[:limit]
+	}
+	return items, nil
+}
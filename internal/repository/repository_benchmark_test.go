@@ -51,7 +51,7 @@ func setupBenchmark(b *testing.B) *BenchmarkSuite {
 
 	logger := zerolog.New(zerolog.NewConsoleWriter())
 
-	store, err := NewKeyValueStore(logger)
+	store, err := NewKeyValueStore(logger, Opts{})
 	if err != nil {
 		b.Fatalf("Failed to create store: %v", err)
 	}
@@ -166,3 +166,54 @@ func BenchmarkHighConcurrencyDirectOperations(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkWarmUp fills an empty store, sized for its keys up front or not
+func BenchmarkWarmUp(b *testing.B) {
+	const keys = 100000
+	value := generateValue(b, 64)
+	for _, expected := range []int{0, keys} {
+		b.Run(fmt.Sprintf("expected=%d", expected), func(b *testing.B) {
+			for range b.N {
+				store, err := NewKeyValueStore(zerolog.Nop(), Opts{ExpectedKeys: expected})
+				if err != nil {
+					b.Fatal(err)
+				}
+				for i := range keys {
+					if err := store.Set(context.Background(), fmt.Sprintf("key-%d", i), value); err != nil {
+						b.Fatal(err)
+					}
+				}
+				_ = store.Close()
+			}
+		})
+	}
+}
+
+// BenchmarkHotKeyReads reads a single key from every goroutine, with and
+// without the hot cache, with a tier tracking the recency of the keys.
+func BenchmarkHotKeyReads(b *testing.B) {
+	for _, ttl := range []time.Duration{0, 100 * time.Millisecond} {
+		b.Run(fmt.Sprintf("ttl=%s", ttl), func(b *testing.B) {
+			store, err := NewKeyValueStore(zerolog.Nop(), Opts{
+				Tier:     TierConfig{Dir: b.TempDir(), MaxMemory: 1 << 30},
+				HotCache: HotCacheConfig{TTL: ttl},
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer store.Close()
+			if err := store.Set(context.Background(), "viral", generateValue(b, 64)); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, _, err := store.Get(context.Background(), "viral"); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -18,7 +18,7 @@ func TestKeyValueStore(t *testing.T) {
 	logger := zerolog.New(os.Stdout)
 
 	t.Run("NewKeyValueStore", func(t *testing.T) {
-		store, err := NewKeyValueStore(logger)
+		store, err := NewKeyValueStore(logger, Opts{})
 		require.NoError(t, err)
 
 		if store.data == nil {
@@ -26,8 +26,17 @@ func TestKeyValueStore(t *testing.T) {
 		}
 	})
 
+	t.Run("Ready", func(t *testing.T) {
+		store, err := NewKeyValueStore(logger, Opts{})
+		require.NoError(t, err)
+
+		require.NoError(t, store.Ready())
+		require.NoError(t, store.Close())
+		require.ErrorIs(t, store.Ready(), ErrClosed)
+	})
+
 	t.Run("Set", func(t *testing.T) {
-		store, _ := NewKeyValueStore(logger)
+		store, _ := NewKeyValueStore(logger, Opts{})
 
 		err := store.Set(context.Background(), key, value)
 		require.NoError(t, err)
@@ -39,9 +48,9 @@ func TestKeyValueStore(t *testing.T) {
 	})
 
 	t.Run("Get", func(t *testing.T) {
-		store, _ := NewKeyValueStore(logger)
-		store.data = map[string][]byte{
-			key: value,
+		store, _ := NewKeyValueStore(logger, Opts{})
+		store.data = map[string]entry{
+			key: {value: value},
 		}
 
 		err := store.Set(context.Background(), "key", []byte("new-value"))
@@ -49,7 +58,7 @@ func TestKeyValueStore(t *testing.T) {
 	})
 
 	t.Run("Set and Get", func(t *testing.T) {
-		store, _ := NewKeyValueStore(logger)
+		store, _ := NewKeyValueStore(logger, Opts{})
 
 		ctx := context.Background()
 
@@ -65,7 +74,7 @@ func TestKeyValueStore(t *testing.T) {
 	})
 
 	t.Run("Delete", func(t *testing.T) {
-		store, _ := NewKeyValueStore(logger)
+		store, _ := NewKeyValueStore(logger, Opts{})
 
 		ctx := context.Background()
 
@@ -77,4 +86,68 @@ func TestKeyValueStore(t *testing.T) {
 		assert.NoError(t, err)
 		assert.False(t, exists)
 	})
+
+	t.Run("Increment", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+
+		ctx := context.Background()
+
+		got, err := store.Increment(ctx, "counter", 5)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), got)
+
+		got, err = store.Increment(ctx, "counter", -7)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-2), got)
+
+		value, exists, err := store.Get(ctx, "counter")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, []byte("-2"), value)
+	})
+
+	t.Run("Increment non-integer value", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, key, value))
+		_, err := store.Increment(ctx, key, 1)
+		assert.ErrorIs(t, err, ErrNotInteger)
+	})
+
+	t.Run("Increment overflow", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+
+		ctx := context.Background()
+
+		require.NoError(t, store.Set(ctx, key, []byte("9223372036854775807")))
+		_, err := store.Increment(ctx, key, 1)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		got, _, err := store.Get(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("9223372036854775807"), got)
+	})
+
+	t.Run("Canceled context", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		assert.ErrorIs(t, store.Set(ctx, key, value), context.Canceled)
+
+		_, _, err := store.Get(ctx, key)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = store.Increment(ctx, key, 1)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		assert.ErrorIs(t, store.Delete(ctx, key), context.Canceled)
+
+		_, exists, err := store.Get(context.Background(), key)
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
 }
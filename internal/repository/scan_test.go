@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+)
+
+func TestKeyValueStoreScan(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	ctx := context.Background()
+
+	store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+	for _, key := range []string{"user:3", "user:1", "order:1", "user:2", "user:4"} {
+		require.NoError(t, store.Set(ctx, key, []byte(key)))
+	}
+	require.NoError(t, store.SetWithTTL(ctx, "user:5", []byte("temp"), time.Hour))
+	require.NoError(t, store.Delete(ctx, "user:4"))
+
+	keys := func(items []Item) []string {
+		var keys []string
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		after  string
+		limit  int
+		want   []string
+	}{
+		{name: "all keys in order", want: []string{"order:1", "user:1", "user:2", "user:3", "user:5"}},
+		{name: "prefix", prefix: "user:", want: []string{"user:1", "user:2", "user:3", "user:5"}},
+		{name: "limit", prefix: "user:", limit: 2, want: []string{"user:1", "user:2"}},
+		{name: "after", prefix: "user:", after: "user:2", limit: 2, want: []string{"user:3", "user:5"}},
+		{name: "no match", prefix: "missing", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := store.Scan(ctx, tt.prefix, tt.after, tt.limit)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, keys(items))
+		})
+	}
+
+	t.Run("items carry value and expiry", func(t *testing.T) {
+		items, err := store.Scan(ctx, "user:", "user:3", 0)
+		require.NoError(t, err)
+		require.Len(t, items, 1)
+		assert.Equal(t, []byte("temp"), items[0].Value)
+		assert.False(t, items[0].ExpiresAt.IsZero())
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := store.Scan(canceled, "", "", 0)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("context canceled during the scan", func(t *testing.T) {
+		for i := range 2 * ctxCheckInterval {
+			require.NoError(t, store.Set(ctx, fmt.Sprintf("item:%d", i), []byte("v")))
+		}
+		// The context is done after the check of begin and the first
+		// check of the scan.
+		_, err := store.Scan(&cancelAfter{Context: ctx, checks: 2}, "", "", 0)
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+// cancelAfter is a context done after its error is checked checks times.
+type cancelAfter struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.checks == 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestKeyValueStoreEvents(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	ctx := context.Background()
+
+	bus := events.NewBus(16)
+	sub := bus.Subscribe("")
+	defer sub.Close()
+
+	store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute, Events: bus})
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	require.NoError(t, store.Set(ctx, "a", []byte("1")))
+	_, err := store.Increment(ctx, "a", 2)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(ctx, "a"))
+	require.NoError(t, store.Delete(ctx, "missing"))
+	_, err = store.Undelete(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, store.SetWithTTL(ctx, "b", []byte("x"), time.Second))
+	now = now.Add(time.Minute)
+	store.reapExpired()
+
+	want := []events.Event{
+		{Type: events.TypeSet, Key: "a", Value: []byte("1"), Created: true, Seq: 1},
+		{Type: events.TypeSet, Key: "a", Value: []byte("3"), Seq: 2},
+		{Type: events.TypeDelete, Key: "a", Seq: 3},
+		{Type: events.TypeSet, Key: "a", Value: []byte("3"), Created: true, Seq: 4},
+		{Type: events.TypeSet, Key: "b", Value: []byte("x"), Created: true, Seq: 5},
+		{Type: events.TypeExpire, Key: "b", Seq: 6},
+	}
+	require.Len(t, sub.Events(), len(want))
+	for _, w := range want {
+		got := <-sub.Events()
+		got.Time = time.Time{}
+		assert.Equal(t, w, got)
+	}
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadSeedFile replaces the contents of the store with the keys of the seed
+// file at path, returning the number of keys loaded. Files with a ".json"
+// extension hold an object of keys to values: string values are stored as
+// is, other values as their JSON encoding. Other files are snapshots, such
+// as backups, whose expired keys are skipped.
+func (k *KeyValueStore) LoadSeedFile(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var data Data
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		data, err = decodeJSONSeed(f)
+	} else {
+		data, err = DecodeSnapshot(f)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read seed file %s: %w", path, err)
+	}
+	if err := k.load(ctx, data); err != nil {
+		return 0, err
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return len(k.data), nil
+}
+
+func decodeJSONSeed(r io.Reader) (Data, error) {
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return Data{}, fmt.Errorf("decode json: %w", err)
+	}
+	data := Data{Store: make(map[string][]byte, len(values))}
+	for key, raw := range values {
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '"' {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return Data{}, fmt.Errorf("value of %q: %w", key, err)
+			}
+			data.Store[key] = []byte(s)
+			continue
+		}
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, raw); err != nil {
+			return Data{}, fmt.Errorf("value of %q: %w", key, err)
+		}
+		data.Store[key] = compact.Bytes()
+	}
+	return data, nil
+}
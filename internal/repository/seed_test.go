@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreLoadSeedFile(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	t.Run("json", func(t *testing.T) {
+		path := filepath.Join(dir, "seed.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{
+			"config:mode": "primary",
+			"config:limits": {"rps": 100, "burst": 20},
+			"config:replicas": 3
+		}`), 0o600))
+
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		n, err := store.LoadSeedFile(ctx, path)
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+
+		for key, want := range map[string]string{
+			"config:mode":     "primary",
+			"config:limits":   `{"rps":100,"burst":20}`,
+			"config:replicas": "3",
+		} {
+			got, exists, err := store.Get(ctx, key)
+			require.NoError(t, err)
+			require.True(t, exists, key)
+			assert.Equal(t, want, string(got), key)
+		}
+	})
+
+	t.Run("snapshot", func(t *testing.T) {
+		src, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, src.Set(ctx, "plain", []byte("v1")))
+		require.NoError(t, src.SetWithTTL(ctx, "temp", []byte("v2"), time.Hour))
+		var buf bytes.Buffer
+		require.NoError(t, src.Snapshot(ctx, &buf))
+		path := filepath.Join(dir, "seed.snap")
+		require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o600))
+
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		n, err := store.LoadSeedFile(ctx, path)
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		_, exists, err := store.Expiry(ctx, "temp")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		require.NoError(t, os.WriteFile(path, []byte(`["not", "an", "object"]`), 0o600))
+
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		_, err := store.LoadSeedFile(ctx, path)
+		assert.ErrorContains(t, err, "read seed file")
+
+		_, err = store.LoadSeedFile(ctx, filepath.Join(dir, "missing.json"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
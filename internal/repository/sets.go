@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// Sets are values holding a JSON array of distinct strings, kept sorted, so
+// they read like any other value. Members are added and removed atomically
+// by the store, sparing clients a read-modify-write of the array.
+
+var (
+	// ErrNotSet is returned when a set operation targets a value that is
+	// not a JSON array of strings.
+	ErrNotSet = errors.New("value is not a set")
+	// ErrTooLarge is returned when an atomic update of a set, hash,
+	// document or sorted set would grow it over the maximum size of values.
+	ErrTooLarge = errors.New("value would exceed the maximum value size")
+)
+
+// SetOp combines the members of several sets.
+type SetOp uint8
+
+const (
+	// Union holds the members of any of the sets.
+	Union SetOp = iota + 1
+	// Intersection holds the members of every set.
+	Intersection
+)
+
+// DecodeSet returns the sorted, distinct members of a set value.
+func DecodeSet(value []byte) ([]string, error) {
+	var members []string
+	if err := json.Unmarshal(value, &members); err != nil || members == nil {
+		return nil, ErrNotSet
+	}
+	return normalize(members), nil
+}
+
+// EncodeSet returns the value of the set of members.
+func EncodeSet(members []string) []byte {
+	if members == nil {
+		members = []string{}
+	}
+	value, _ := json.Marshal(normalize(members))
+	return value
+}
+
+// normalize sorts members and removes their duplicates, in place.
+func normalize(members []string) []string {
+	sort.Strings(members)
+	out := members[:0]
+	for i, m := range members {
+		if i == 0 || m != members[i-1] {
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+// AddMembers atomically adds members to the set stored at key and returns
+// those it didn't hold, sorted. A missing key is treated as an empty set,
+// so the first addition creates it. The expiry of an existing key is
+// preserved. A maxSize over zero bounds the size of the set value.
+func (k *KeyValueStore) AddMembers(ctx context.Context, key string, members []string, maxSize int) ([]string, error) {
+	return k.updateSet(ctx, key, func(value []byte, exists bool) ([]byte, []string, error) {
+		return ApplyAddMembers(value, exists, members, maxSize)
+	})
+}
+
+// ApplyAddMembers returns the set value, missing unless exists, with
+// members added, nil when it held them all, and those it didn't hold,
+// sorted, as AddMembers stores it.
+func ApplyAddMembers(value []byte, exists bool, members []string, maxSize int) ([]byte, []string, error) {
+	return applySet(value, exists, func(set map[string]bool) ([]string, error) {
+		var added []string
+		for _, m := range members {
+			if !set[m] {
+				set[m] = true
+				added = append(added, m)
+			}
+		}
+		if maxSize > 0 && len(EncodeSet(setMembers(set))) > maxSize {
+			return nil, ErrTooLarge
+		}
+		return added, nil
+	})
+}
+
+// RemoveMembers atomically removes members from the set stored at key and
+// returns those it held, sorted. Removing the last member leaves an empty
+// set.
+func (k *KeyValueStore) RemoveMembers(ctx context.Context, key string, members []string) ([]string, error) {
+	return k.updateSet(ctx, key, func(value []byte, exists bool) ([]byte, []string, error) {
+		return ApplyRemoveMembers(value, exists, members)
+	})
+}
+
+// ApplyRemoveMembers returns the set value, missing unless exists, without
+// members, nil when it held none, and those it held, sorted, as
+// RemoveMembers stores it.
+func ApplyRemoveMembers(value []byte, exists bool, members []string) ([]byte, []string, error) {
+	return applySet(value, exists, func(set map[string]bool) ([]string, error) {
+		var removed []string
+		for _, m := range members {
+			if set[m] {
+				delete(set, m)
+				removed = append(removed, m)
+			}
+		}
+		return removed, nil
+	})
+}
+
+// updateSet stores the set value of key returned by apply, given its
+// current value, unless no member changed.
+func (k *KeyValueStore) updateSet(ctx context.Context, key string, apply func(value []byte, exists bool) ([]byte, []string, error)) ([]string, error) {
+	var changed []string
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, changed, err = apply(value, exists)
+		return value, err
+	})
+	if err != nil || len(changed) == 0 {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// applySet applies update to the members of the set value, missing unless
+// exists, and returns its new value, nil unless a member changed, with the
+// members changed, sorted.
+func applySet(value []byte, exists bool, update func(map[string]bool) ([]string, error)) ([]byte, []string, error) {
+	set := map[string]bool{}
+	if exists {
+		members, err := DecodeSet(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range members {
+			set[m] = true
+		}
+	}
+
+	changed, err := update(set)
+	if err != nil || len(changed) == 0 {
+		return nil, nil, err
+	}
+	return EncodeSet(setMembers(set)), normalize(changed), nil
+}
+
+// modify replaces the value of key with the one returned by update, given
+// the current value, unless update returns nil. The expiry of an existing
+// key is preserved.
+//
+// The key stripe is held for the whole read-modify-write, the store-wide
+// lock only while reading and writing the entry.
+func (k *KeyValueStore) modify(ctx context.Context, key string, update func(value []byte, exists bool) ([]byte, error)) error {
+	if err := k.begin(ctx, opUpdate); err != nil {
+		return err
+	}
+
+	defer k.keys.lock(key)()
+
+	k.mu.RLock()
+	e, exists := k.data[key]
+	current, err := k.value(key, e)
+	k.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	if exists && !e.live(k.clock(ctx).UnixNano()) {
+		e, exists, current = entry{}, false, nil
+	}
+	value, err := update(current, exists)
+	if err != nil || value == nil {
+		return err
+	}
+	e.value, e.cold = value, nil
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.put(key, e); err != nil {
+		return err
+	}
+	k.publishSet(key, e.value, !exists)
+	return nil
+}
+
+// Combine returns the sorted union or intersection of the sets stored at
+// keys, read at once so concurrent updates are seen entirely or not at all.
+// Missing keys are empty sets.
+func (k *KeyValueStore) Combine(ctx context.Context, op SetOp, keys []string) ([]string, error) {
+	if err := k.begin(ctx, opCombine); err != nil {
+		return nil, err
+	}
+	if op != Union && op != Intersection {
+		return nil, errors.New("unknown set operation")
+	}
+
+	now := k.now().UnixNano()
+	sets := make([][]string, 0, len(keys))
+	k.mu.RLock()
+	for _, key := range keys {
+		e, exists := k.data[key]
+		if !exists || !e.live(now) {
+			sets = append(sets, nil)
+			continue
+		}
+		value, err := k.value(key, e)
+		if err != nil {
+			k.mu.RUnlock()
+			return nil, err
+		}
+		members, err := DecodeSet(value)
+		if err != nil {
+			k.mu.RUnlock()
+			return nil, err
+		}
+		sets = append(sets, members)
+	}
+	k.mu.RUnlock()
+
+	counts := map[string]int{}
+	for _, members := range sets {
+		for _, m := range members {
+			counts[m]++
+		}
+	}
+	result := []string{}
+	for m, n := range counts {
+		if op == Union || n == len(sets) {
+			result = append(result, m)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// setMembers returns the members of set.
+func setMembers(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for m := range set {
+		members = append(members, m)
+	}
+	return members
+}
@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreSets(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AddMembers creates the set and returns the new members", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		added, err := store.AddMembers(ctx, "tags", []string{"red", "blue", "red"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"blue", "red"}, added)
+
+		added, err = store.AddMembers(ctx, "tags", []string{"green", "blue"}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"green"}, added)
+
+		value, _, err := store.Get(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, `["blue","green","red"]`, string(value))
+	})
+
+	t.Run("RemoveMembers returns the removed members", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.SetWithTTL(ctx, "tags", []byte(`["b","a"]`), time.Hour))
+
+		removed, err := store.RemoveMembers(ctx, "tags", []string{"a", "c"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a"}, removed)
+
+		value, _, err := store.Get(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, `["b"]`, string(value))
+		// The expiry is preserved.
+		expiresAt, _, err := store.Expiry(ctx, "tags")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+
+		removed, err = store.RemoveMembers(ctx, "tags", []string{"b"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, removed)
+		value, _, err = store.Get(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, `[]`, string(value))
+
+		// Removing from a missing key doesn't create it.
+		removed, err = store.RemoveMembers(ctx, "missing", []string{"a"})
+		require.NoError(t, err)
+		assert.Empty(t, removed)
+		_, exists, err := store.Get(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("values other than sets are rejected", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "counter", []byte("1")))
+		require.NoError(t, store.Set(ctx, "numbers", []byte("[1,2]")))
+
+		for _, key := range []string{"counter", "numbers"} {
+			_, err := store.AddMembers(ctx, key, []string{"a"}, 0)
+			assert.ErrorIs(t, err, ErrNotSet)
+			_, err = store.Combine(ctx, Union, []string{key})
+			assert.ErrorIs(t, err, ErrNotSet)
+		}
+	})
+
+	t.Run("AddMembers bounds the size of the set", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		_, err := store.AddMembers(ctx, "tags", []string{"a", "b"}, 9)
+		require.NoError(t, err)
+		_, err = store.AddMembers(ctx, "tags", []string{"c"}, 9)
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		value, _, err := store.Get(ctx, "tags")
+		require.NoError(t, err)
+		assert.Equal(t, `["a","b"]`, string(value))
+	})
+
+	t.Run("Combine unions and intersects the sets", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "a", []byte(`["x","y"]`)))
+		require.NoError(t, store.Set(ctx, "b", []byte(`["y","z"]`)))
+
+		union, err := store.Combine(ctx, Union, []string{"a", "b", "missing"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"x", "y", "z"}, union)
+
+		inter, err := store.Combine(ctx, Intersection, []string{"a", "b"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"y"}, inter)
+
+		inter, err = store.Combine(ctx, Intersection, []string{"a", "missing"})
+		require.NoError(t, err)
+		assert.Empty(t, inter)
+	})
+
+	t.Run("concurrent additions are all applied", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				_, err := store.AddMembers(ctx, "tags", []string{fmt.Sprint(i)}, 0)
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		value, _, err := store.Get(ctx, "tags")
+		require.NoError(t, err)
+		members, err := DecodeSet(value)
+		require.NoError(t, err)
+		assert.Len(t, members, 50)
+	})
+}
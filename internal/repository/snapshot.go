@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+)
+
+// ctxCheckInterval is how many entries are processed between context checks
+// while copying or scanning the store.
+const ctxCheckInterval = 1024
+
+// Exporter is implemented by the stores copying their entries as of a
+// point in time.
+type Exporter interface {
+	Export(ctx context.Context) (Data, error)
+}
+
+// Snapshot writes a point-in-time copy of all live entries to w as a gob
+// encoded Data. The entries are copied as Export does, encoding happens
+// without holding the lock.
+func (k *KeyValueStore) Snapshot(ctx context.Context, w io.Writer) error {
+	data, err := k.Export(ctx)
+	if err != nil {
+		return err
+	}
+	return EncodeSnapshot(ctxWriter{ctx: ctx, w: w}, data)
+}
+
+// Restore replaces the contents of the store with a snapshot written by
+// Snapshot. Entries that expired since the snapshot was taken are skipped.
+func (k *KeyValueStore) Restore(ctx context.Context, r io.Reader) error {
+	data, err := DecodeSnapshot(r)
+	if err != nil {
+		return err
+	}
+	return k.load(ctx, data)
+}
+
+// load replaces the contents of the store with the entries of data that
+// haven't expired.
+func (k *KeyValueStore) load(ctx context.Context, data Data) error {
+	now := k.settled()
+	entries := k.newEntries(len(data.Store))
+	i := 0
+	for key, value := range data.Store {
+		if i%ctxCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		i++
+
+		e := entry{value: value, expiresAt: data.Expiry[key], version: data.Version[key]}
+		if e.expired(now) {
+			continue
+		}
+		entries[key] = e
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	// The versions of the snapshot are kept, for the replicas restoring it
+	// to give the next writes the versions the others do.
+	k.revision = max(k.revision, data.Revision)
+	return k.replaceAll(entries)
+}
+
+// EncodeSnapshot writes data in the snapshot format read by Restore.
+func EncodeSnapshot(w io.Writer, data Data) error {
+	if err := gob.NewEncoder(w).Encode(data); err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	return nil
+}
+
+// DecodeSnapshot reads a snapshot written by Snapshot or EncodeSnapshot,
+// for tools working on snapshot files without a store.
+func DecodeSnapshot(r io.Reader) (Data, error) {
+	var data Data
+	if err := gob.NewDecoder(r).Decode(&data); err != nil {
+		return Data{}, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// Export copies the live entries as of the time it is called into a Data.
+// Writers go on while the entries are copied, the store being read-locked
+// in batches of ctxCheckInterval entries, and the entries they change are
+// copied as they were.
+func (k *KeyValueStore) Export(ctx context.Context) (Data, error) {
+	v := k.openView()
+	defer k.closeView(v)
+	return k.exportView(ctx, v)
+}
+
+// exportView copies the live entries of the view v into a Data.
+func (k *KeyValueStore) exportView(ctx context.Context, v *view) (Data, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	data := Data{
+		Store:    make(map[string][]byte, len(k.data)),
+		Expiry:   make(map[string]int64),
+		Version:  make(map[string]uint64, len(k.data)),
+		Revision: v.revision,
+	}
+	add := func(key string, e entry, value []byte) {
+		data.Store[key] = value
+		if e.expiresAt != 0 {
+			data.Expiry[key] = e.expiresAt
+		}
+		data.Version[key] = e.version
+	}
+	i := 0
+	// The map is iterated across the batches, writers replacing it only
+	// when the whole store is, in which case its entries are all saved.
+	for key, e := range k.data {
+		if i%ctxCheckInterval == 0 {
+			if i > 0 {
+				k.mu.RUnlock()
+				k.mu.RLock()
+			}
+			if err := ctx.Err(); err != nil {
+				return Data{}, err
+			}
+		}
+		i++
+
+		if _, changed := v.saved[key]; changed || !e.live(v.at) {
+			continue
+		}
+		value, err := k.value(key, e)
+		if err != nil {
+			return Data{}, err
+		}
+		add(key, e, value)
+	}
+	for key, saved := range v.saved {
+		if saved.exists && saved.e.live(v.at) {
+			add(key, saved.e, saved.value)
+		}
+	}
+	return data, nil
+}
+
+// ctxWriter fails writes once its context is done, so a long snapshot write
+// is abandoned when the caller gives up.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c ctxWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreSnapshot(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	ctx := context.Background()
+
+	t.Run("round trip", func(t *testing.T) {
+		src, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+		require.NoError(t, src.Set(ctx, "plain", []byte("v1")))
+		require.NoError(t, src.SetWithTTL(ctx, "temp", []byte("v2"), time.Hour))
+		require.NoError(t, src.Set(ctx, "deleted", []byte("v3")))
+		require.NoError(t, src.Delete(ctx, "deleted"))
+
+		var buf bytes.Buffer
+		require.NoError(t, src.Snapshot(ctx, &buf))
+
+		dst, _ := NewKeyValueStore(logger, Opts{})
+		require.NoError(t, dst.Set(ctx, "stale", []byte("v")))
+		require.NoError(t, dst.Restore(ctx, &buf))
+
+		got, exists, err := dst.Get(ctx, "plain")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, []byte("v1"), got)
+
+		wantExpiry, _, err := src.Expiry(ctx, "temp")
+		require.NoError(t, err)
+		gotExpiry, exists, err := dst.Expiry(ctx, "temp")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.True(t, wantExpiry.Equal(gotExpiry))
+
+		for _, key := range []string{"deleted", "stale"} {
+			_, exists, err = dst.Get(ctx, key)
+			require.NoError(t, err)
+			assert.False(t, exists, key)
+		}
+	})
+
+	t.Run("canceled context", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		require.NoError(t, store.Set(ctx, "key", []byte("value")))
+
+		canceled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		var buf bytes.Buffer
+		assert.ErrorIs(t, store.Snapshot(canceled, &buf), context.Canceled)
+		assert.Zero(t, buf.Len())
+	})
+	t.Run("export sees the entries as of its start", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute, Tier: TierConfig{Dir: t.TempDir(), MaxMemory: 1}})
+		defer store.Close()
+		for i := range 2 * ctxCheckInterval {
+			require.NoError(t, store.Set(ctx, fmt.Sprintf("key-%d", i), []byte("old")))
+		}
+		require.NoError(t, store.SetWithTTL(ctx, "temp", []byte("old"), time.Hour))
+		want, err := store.Export(ctx)
+		require.NoError(t, err)
+
+		v := store.openView()
+		defer store.closeView(v)
+		require.NoError(t, store.Set(ctx, "key-1", []byte("new")))
+		require.NoError(t, store.Set(ctx, "key-1", []byte("newer")))
+		require.NoError(t, store.Delete(ctx, "key-2"))
+		require.NoError(t, store.Delete(ctx, "temp"))
+		require.NoError(t, store.Set(ctx, "created", []byte("new")))
+		store.Seed(map[string][]byte{"key-3": []byte("new"), "seeded": []byte("new")})
+
+		got, err := store.exportView(ctx, v)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("export while writing", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+		require.NoError(t, store.Set(ctx, "a", []byte("0")))
+		require.NoError(t, store.Set(ctx, "b", []byte("0")))
+		for i := range 4 * ctxCheckInterval {
+			require.NoError(t, store.Set(ctx, fmt.Sprintf("key-%d", i), []byte("v")))
+		}
+
+		// a is written before b, so no point in time has b ahead of a.
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 1; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				n := []byte(strconv.Itoa(i))
+				assert.NoError(t, store.Set(ctx, "a", n))
+				assert.NoError(t, store.Set(ctx, "b", n))
+			}
+		}()
+		defer wg.Wait()
+		defer close(stop)
+
+		for range 20 {
+			data, err := store.Export(ctx)
+			require.NoError(t, err)
+			a, _ := strconv.Atoi(string(data.Store["a"]))
+			b, _ := strconv.Atoi(string(data.Store["b"]))
+			assert.Contains(t, []int{a - 1, a}, b)
+		}
+	})
+}
@@ -0,0 +1,492 @@
+package repository
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A table is an immutable file of records sorted by key, written when the
+// memtable of the engine is flushed or tables are compacted. Records are
+// grouped in blocks of about tableBlockSize bytes, each followed by its
+// CRC-32, and the index of the first key of every block is written after
+// them, so a lookup reads a single block:
+//
+//	block...  index  crc32  index offset (8 bytes)  magic (8 bytes)
+const (
+	tableBlockSize = 4 << 10
+	tableMagic     = 0x6b766c736d746231 // "kvlsmtb1"
+	tableFooter    = 16
+)
+
+var errCorruptTable = errors.New("corrupt table")
+
+// record is a change of a key in the engine, a value with its metadata or
+// the removal of the key.
+type record struct {
+	key       string
+	value     []byte
+	expiresAt int64
+	deletedAt int64
+	// version is the version of the entry, zero for the records written
+	// before versions.
+	version uint64
+	removed bool
+}
+
+// Kinds of the records, their first byte.
+const (
+	recordValue = iota
+	recordRemoved
+	recordVersioned
+)
+
+// size is the approximate size of the record in memory.
+func (r record) size() int64 {
+	return int64(len(r.key) + len(r.value) + 32)
+}
+
+// appendRecord appends the encoding of r to dst.
+func appendRecord(dst []byte, r record) []byte {
+	switch {
+	case r.removed:
+		dst = append(dst, recordRemoved)
+	case r.version != 0:
+		dst = append(dst, recordVersioned)
+	default:
+		dst = append(dst, recordValue)
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(r.key)))
+	dst = append(dst, r.key...)
+	if r.removed {
+		return dst
+	}
+	dst = binary.AppendUvarint(dst, uint64(len(r.value)))
+	dst = append(dst, r.value...)
+	dst = binary.AppendVarint(dst, r.expiresAt)
+	dst = binary.AppendVarint(dst, r.deletedAt)
+	if r.version != 0 {
+		dst = binary.AppendUvarint(dst, r.version)
+	}
+	return dst
+}
+
+// decodeRecord decodes the record at the start of b and returns its size.
+// The value of the record references b.
+func decodeRecord(b []byte) (record, int, error) {
+	var r record
+	if len(b) == 0 {
+		return r, 0, errCorruptTable
+	}
+	kind := b[0]
+	if kind > recordVersioned {
+		return r, 0, errCorruptTable
+	}
+	r.removed = kind == recordRemoved
+	n := 1
+	key, m := decodeBytes(b[n:])
+	if m <= 0 {
+		return r, 0, errCorruptTable
+	}
+	r.key = string(key)
+	n += m
+	if r.removed {
+		return r, n, nil
+	}
+	if r.value, m = decodeBytes(b[n:]); m <= 0 {
+		return r, 0, errCorruptTable
+	}
+	n += m
+	if r.expiresAt, m = binary.Varint(b[n:]); m <= 0 {
+		return r, 0, errCorruptTable
+	}
+	n += m
+	if r.deletedAt, m = binary.Varint(b[n:]); m <= 0 {
+		return r, 0, errCorruptTable
+	}
+	n += m
+	if kind == recordVersioned {
+		if r.version, m = binary.Uvarint(b[n:]); m <= 0 {
+			return r, 0, errCorruptTable
+		}
+		n += m
+	}
+	return r, n, nil
+}
+
+// decodeBytes decodes a length prefixed byte string, returning a size of
+// zero or less when b is too short.
+func decodeBytes(b []byte) ([]byte, int) {
+	size, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < size {
+		return nil, 0
+	}
+	return b[n : n+int(size)], n + int(size)
+}
+
+// blockIndex locates a block of a table by its first key.
+type blockIndex struct {
+	key    string
+	offset int64
+	size   int64
+}
+
+// table is an open table file.
+type table struct {
+	id    uint64
+	path  string
+	file  *os.File
+	index []blockIndex
+}
+
+// writeTable writes the records of it, sorted by key, to a new table file
+// at path, synced to disk.
+func writeTable(path string, it iterator) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(path)
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err := encodeTable(w, it); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// EncodeTable writes the entries of data that haven't expired as a table,
+// the format of the files of the storage engine, which servers can map in
+// memory with Opts.MappedFile.
+func EncodeTable(w io.Writer, data Data) error {
+	now := time.Now().UnixNano()
+	records := make(sliceIterator, 0, len(data.Store))
+	for key, value := range data.Store {
+		r := record{key: key, value: value, expiresAt: data.Expiry[key], version: data.Version[key]}
+		if r.expiresAt == 0 || r.expiresAt > now {
+			records = append(records, r)
+		}
+	}
+	slices.SortFunc(records, func(a, b record) int { return strings.Compare(a.key, b.key) })
+	return encodeTable(w, &records)
+}
+
+// encodeTable writes the records of it, sorted by key, as a table.
+func encodeTable(w io.Writer, it iterator) error {
+	var (
+		offset int64
+		index  []blockIndex
+		block  []byte
+		first  string
+	)
+	flush := func() error {
+		if len(block) == 0 {
+			return nil
+		}
+		index = append(index, blockIndex{key: first, offset: offset, size: int64(len(block))})
+		block = binary.BigEndian.AppendUint32(block, crc32.ChecksumIEEE(block))
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+		offset += int64(len(block))
+		block = block[:0]
+		return nil
+	}
+	for {
+		r, ok, err := it.next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		if len(block) == 0 {
+			first = r.key
+		}
+		block = appendRecord(block, r)
+		if len(block) >= tableBlockSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	b := binary.AppendUvarint(nil, uint64(len(index)))
+	for _, bi := range index {
+		b = binary.AppendUvarint(b, uint64(len(bi.key)))
+		b = append(b, bi.key...)
+		b = binary.AppendUvarint(b, uint64(bi.offset))
+		b = binary.AppendUvarint(b, uint64(bi.size))
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	b = binary.BigEndian.AppendUint64(b, uint64(offset))
+	b = binary.BigEndian.AppendUint64(b, tableMagic)
+	_, err := w.Write(b)
+	return err
+}
+
+// openTable opens the table file at path and reads its index.
+func openTable(id uint64, path string) (*table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{id: id, path: path, file: f}
+	if err := t.readIndex(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("table %s: %w", path, err)
+	}
+	return t, nil
+}
+
+func (t *table) readIndex() error {
+	info, err := t.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < tableFooter+4 {
+		return errCorruptTable
+	}
+	footer := make([]byte, tableFooter)
+	if _, err := t.file.ReadAt(footer, info.Size()-tableFooter); err != nil {
+		return err
+	}
+	indexOffset, err := decodeFooter(footer, info.Size())
+	if err != nil {
+		return err
+	}
+	b := make([]byte, info.Size()-tableFooter-indexOffset)
+	if _, err := t.file.ReadAt(b, indexOffset); err != nil {
+		return err
+	}
+	t.index, err = decodeIndex(b)
+	return err
+}
+
+// decodeFooter returns the offset of the index of a table of size bytes
+// ending with footer.
+func decodeFooter(footer []byte, size int64) (int64, error) {
+	indexOffset := int64(binary.BigEndian.Uint64(footer))
+	if binary.BigEndian.Uint64(footer[8:]) != tableMagic || indexOffset < 0 || indexOffset > size-tableFooter-4 {
+		return 0, errCorruptTable
+	}
+	return indexOffset, nil
+}
+
+// decodeIndex decodes the index of a table, followed by its CRC-32.
+func decodeIndex(b []byte) ([]blockIndex, error) {
+	b, err := checkBlock(b)
+	if err != nil {
+		return nil, err
+	}
+	count, n := binary.Uvarint(b)
+	if n <= 0 {
+		return nil, errCorruptTable
+	}
+	b = b[n:]
+	var index []blockIndex
+	for range count {
+		key, m := decodeBytes(b)
+		if m <= 0 {
+			return nil, errCorruptTable
+		}
+		b = b[m:]
+		offset, m := binary.Uvarint(b)
+		if m <= 0 {
+			return nil, errCorruptTable
+		}
+		b = b[m:]
+		size, m := binary.Uvarint(b)
+		if m <= 0 {
+			return nil, errCorruptTable
+		}
+		b = b[m:]
+		index = append(index, blockIndex{key: string(key), offset: int64(offset), size: int64(size)})
+	}
+	return index, nil
+}
+
+// checkBlock verifies the CRC-32 ending b and returns the bytes it covers.
+func checkBlock(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errCorruptTable
+	}
+	data := b[:len(b)-4]
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+		return nil, errCorruptTable
+	}
+	return data, nil
+}
+
+// readBlock reads the records of the i-th block.
+func (t *table) readBlock(i int) ([]byte, error) {
+	bi := t.index[i]
+	b := make([]byte, bi.size+4)
+	if _, err := t.file.ReadAt(b, bi.offset); err != nil {
+		return nil, fmt.Errorf("table %s: %w", t.path, err)
+	}
+	b, err := checkBlock(b)
+	if err != nil {
+		return nil, fmt.Errorf("table %s: block at %d: %w", t.path, bi.offset, err)
+	}
+	return b, nil
+}
+
+// get returns the record of key in the table.
+func (t *table) get(key string) (record, bool, error) {
+	// The block of key is the last one starting at or before it.
+	i := sort.Search(len(t.index), func(i int) bool { return t.index[i].key > key }) - 1
+	if i < 0 {
+		return record{}, false, nil
+	}
+	b, err := t.readBlock(i)
+	if err != nil {
+		return record{}, false, err
+	}
+	for len(b) > 0 {
+		r, n, err := decodeRecord(b)
+		if err != nil {
+			return record{}, false, fmt.Errorf("table %s: %w", t.path, err)
+		}
+		if r.key == key {
+			return r, true, nil
+		}
+		if r.key > key {
+			break
+		}
+		b = b[n:]
+	}
+	return record{}, false, nil
+}
+
+func (t *table) close() error {
+	return t.file.Close()
+}
+
+// iterator returns records sorted by key, and false once there are none.
+type iterator interface {
+	next() (record, bool, error)
+}
+
+// tableIterator iterates over the records of a table, a block at a time.
+type tableIterator struct {
+	t     *table
+	i     int
+	block []byte
+}
+
+func (it *tableIterator) next() (record, bool, error) {
+	for len(it.block) == 0 {
+		if it.i == len(it.t.index) {
+			return record{}, false, nil
+		}
+		b, err := it.t.readBlock(it.i)
+		if err != nil {
+			return record{}, false, err
+		}
+		it.block = b
+		it.i++
+	}
+	r, n, err := decodeRecord(it.block)
+	if err != nil {
+		return record{}, false, fmt.Errorf("table %s: %w", it.t.path, err)
+	}
+	it.block = it.block[n:]
+	return r, true, nil
+}
+
+// sliceIterator iterates over records sorted by key.
+type sliceIterator []record
+
+func (it *sliceIterator) next() (record, bool, error) {
+	if len(*it) == 0 {
+		return record{}, false, nil
+	}
+	r := (*it)[0]
+	*it = (*it)[1:]
+	return r, true, nil
+}
+
+// mergeIterator merges iterators sorted by key, the record of the first
+// iterator holding a key shadowing those of the following ones.
+type mergeIterator struct {
+	its   []iterator
+	heads []*record
+	err   error
+}
+
+func newMergeIterator(its ...iterator) *mergeIterator {
+	return &mergeIterator{its: its, heads: make([]*record, len(its))}
+}
+
+func (m *mergeIterator) next() (record, bool, error) {
+	if m.err != nil {
+		return record{}, false, m.err
+	}
+	least := -1
+	for i, it := range m.its {
+		if it == nil {
+			continue
+		}
+		if m.heads[i] == nil {
+			r, ok, err := it.next()
+			if err != nil {
+				m.err = err
+				return record{}, false, err
+			}
+			if !ok {
+				m.its[i] = nil
+				continue
+			}
+			m.heads[i] = &r
+		}
+		if least < 0 || m.heads[i].key < m.heads[least].key {
+			least = i
+		}
+	}
+	if least < 0 {
+		return record{}, false, nil
+	}
+	r := *m.heads[least]
+	for i, head := range m.heads {
+		if head != nil && head.key == r.key {
+			m.heads[i] = nil
+		}
+	}
+	return r, true, nil
+}
+
+// liveIterator skips the removals of keys.
+type liveIterator struct {
+	it iterator
+}
+
+func (l liveIterator) next() (record, bool, error) {
+	for {
+		r, ok, err := l.it.next()
+		if err != nil || !ok || !r.removed {
+			return r, ok, err
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package repository
+
+import "context"
+
+// Stats is the size of the live keys of a store.
+type Stats struct {
+	// Keys is the number of live keys.
+	Keys int
+	// Bytes is the size of the live keys and their values, in memory or
+	// cold.
+	Bytes int64
+}
+
+// Stats returns the size of the live keys, counted through every entry
+// with the read lock held.
+func (k *KeyValueStore) Stats(ctx context.Context) (Stats, error) {
+	if err := k.begin(ctx, opScan); err != nil {
+		return Stats{}, err
+	}
+
+	now := k.now().UnixNano()
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	var s Stats
+	for key, e := range k.data {
+		if !e.live(now) {
+			continue
+		}
+		s.Keys++
+		s.Bytes += int64(len(key))
+		if e.cold != nil {
+			s.Bytes += e.cold.size
+		} else {
+			s.Bytes += int64(len(e.value))
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreStats(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewKeyValueStore(zerolog.Nop(), Opts{TombstoneRetention: time.Minute, Tier: TierConfig{Dir: t.TempDir(), MaxMemory: 8}})
+	require.NoError(t, err)
+	defer store.Close()
+
+	stats, err := store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{}, stats)
+
+	require.NoError(t, store.Set(ctx, "a", []byte("value")))
+	require.NoError(t, store.Set(ctx, "bb", []byte("spilled value")))
+	require.NoError(t, store.Set(ctx, "deleted", []byte("value")))
+	require.NoError(t, store.Delete(ctx, "deleted"))
+
+	// Tombstones aren't counted, cold values are.
+	stats, err = store.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Keys: 2, Bytes: int64(len("a") + len("value") + len("bb") + len("spilled value"))}, stats)
+}
@@ -0,0 +1,538 @@
+package repository
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"codesignal/internal/metrics"
+)
+
+// Values are tiered when a tier directory is configured: the values of the
+// keys recently read or written stay in memory, up to TierConfig.MaxMemory
+// bytes, and the least recently used ones are spilled to a file of the
+// directory past it. Keys and their metadata always stay in memory, a cold
+// value is read back from the file and moved to memory when its key is
+// read, so working sets larger than the memory remain usable.
+//
+// The file is scratch space, not persistence: it is truncated when the
+// store starts and removed when it closes, snapshots holding the values of
+// both tiers. With a storage engine, there is no file: spilled values are
+// dropped from memory and read back from the engine holding them.
+
+// Defaults applied to zero TierConfig fields.
+const (
+	DefaultTierMaxMemory = 256 << 20
+	// tierFile is the file of the tier directory holding the cold values.
+	tierFile = "values.cold"
+	// minTierCompaction is the size of the tier file under which its space
+	// isn't reclaimed.
+	minTierCompaction = 1 << 20
+)
+
+// TierConfig configures the spilling of cold values to disk.
+type TierConfig struct {
+	// Dir is the directory of the file cold values are spilled to, created
+	// if needed. Empty keeps every value in memory.
+	Dir string `envconfig:"DIR"`
+	// MaxMemory is the size in bytes of the values kept in memory, past
+	// which the least recently used ones are spilled until a tenth of it
+	// is free. DefaultTierMaxMemory when zero.
+	MaxMemory int64 `envconfig:"MAX_MEMORY" default:"268435456"`
+}
+
+// coldValue locates a value out of memory.
+type coldValue struct {
+	source coldSource
+	// offset is the offset of the value in the tier or mapped file.
+	offset int64
+	size   int64
+}
+
+// coldSource is where a cold value is read from.
+type coldSource uint8
+
+const (
+	inTierFile coldSource = iota
+	inEngine
+	inMappedFile
+)
+
+// spilled reports whether the value takes space in the tier file.
+func (c *coldValue) spilled() bool {
+	return c != nil && c.source == inTierFile
+}
+
+// hotValue is a key with its value in memory, in recency order.
+type hotValue struct {
+	key  string
+	size int64
+}
+
+// tier tracks the values in memory by recency and the space of the tier
+// file, nil with a storage engine. A nil tier keeps every value in memory.
+//
+// The file is appended to by the spiller alone, without the lock of the
+// store, and only rewritten with the write lock of the store held, so cold
+// values are read with the read lock held. Spilling and rewriting both hold
+// fileMu, so a rewrite never swaps the file under a spill.
+type tier struct {
+	maxMemory int64
+	path      string
+	// fileMu is held by a spill from the write of its values to their
+	// placement, and by a rewrite of the file. It is taken before the lock
+	// of the store.
+	fileMu sync.Mutex
+	// file is replaced with fileMu, the write lock of the store and mu
+	// held, so it is read with any of them.
+	file *os.File
+	// spill wakes the spiller up once the values in memory exceed
+	// maxMemory.
+	spill chan struct{}
+
+	// mu guards the fields below. It is taken with the lock of the store
+	// held, or alone.
+	mu sync.Mutex
+	// recency lists the values in memory, the most recently used first.
+	recency  *list.List
+	hot      map[string]*list.Element
+	hotBytes int64
+	// end is the size of the file and garbage the bytes of it no longer
+	// referenced by an entry.
+	end     int64
+	garbage int64
+}
+
+// openTier opens the tier of cfg, nil without directory or storage
+// engine, whose values the tier then drops instead of writing a file.
+func openTier(cfg TierConfig, engine *lsm) (*tier, error) {
+	if cfg.Dir == "" && engine == nil {
+		return nil, nil
+	}
+	if cfg.MaxMemory <= 0 {
+		cfg.MaxMemory = DefaultTierMaxMemory
+	}
+	t := &tier{
+		maxMemory: cfg.MaxMemory,
+		spill:     make(chan struct{}, 1),
+		recency:   list.New(),
+		hot:       make(map[string]*list.Element),
+	}
+	if engine != nil {
+		return t, nil
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, err
+	}
+	t.path = filepath.Join(cfg.Dir, tierFile)
+	file, err := os.OpenFile(t.path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	t.file = file
+	return t, nil
+}
+
+// close closes and removes the tier file.
+func (t *tier) close() error {
+	if t == nil || t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	if rmErr := os.Remove(t.path); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// replace accounts for the entry of key replacing old, absent when
+// existed is false.
+func (t *tier) replace(key string, old entry, existed bool, e entry) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existed {
+		t.forget(key, old)
+		if old.cold.spilled() && old.cold != e.cold {
+			t.garbage += old.cold.size
+		}
+	}
+	if e.cold == nil {
+		t.hotBytes += int64(len(e.value))
+		t.hot[key] = t.recency.PushFront(hotValue{key: key, size: int64(len(e.value))})
+		if t.hotBytes > t.maxMemory {
+			select {
+			case t.spill <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// remove accounts for the removal of the entry of key.
+func (t *tier) remove(key string, e entry) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.forget(key, e)
+	if e.cold.spilled() {
+		t.garbage += e.cold.size
+	}
+}
+
+// forget stops tracking the value of key in memory.
+func (t *tier) forget(key string, e entry) {
+	if e.cold != nil {
+		return
+	}
+	if elem, ok := t.hot[key]; ok {
+		t.hotBytes -= elem.Value.(hotValue).size
+		t.recency.Remove(elem)
+		delete(t.hot, key)
+	}
+}
+
+// reset accounts for the entries replacing all of them.
+func (t *tier) reset(entries map[string]entry) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.recency.Init()
+	t.hot = make(map[string]*list.Element, len(entries))
+	t.hotBytes = 0
+	t.garbage = t.end
+	t.mu.Unlock()
+	for key, e := range entries {
+		t.replace(key, entry{}, false, e)
+	}
+}
+
+// touch marks the value of key as used.
+func (t *tier) touch(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if elem, ok := t.hot[key]; ok {
+		t.recency.MoveToFront(elem)
+	}
+	t.mu.Unlock()
+}
+
+// coldest returns the least recently used keys with values in memory to
+// spill for a tenth of maxMemory to be free.
+func (t *tier) coldest() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	excess := t.hotBytes - t.maxMemory + t.maxMemory/10
+	var keys []string
+	for elem := t.recency.Back(); elem != nil && excess > 0; elem = elem.Prev() {
+		v := elem.Value.(hotValue)
+		keys = append(keys, v.key)
+		excess -= v.size
+	}
+	return keys
+}
+
+// write appends values to the file and returns their location, or only
+// marks them as read from the storage engine without file.
+func (t *tier) write(values [][]byte) ([]*coldValue, error) {
+	cold := make([]*coldValue, len(values))
+	if t.file == nil {
+		for i, v := range values {
+			cold[i] = &coldValue{source: inEngine, size: int64(len(v))}
+		}
+		return cold, nil
+	}
+
+	t.mu.Lock()
+	offset := t.end
+	t.mu.Unlock()
+
+	var buf []byte
+	for i, v := range values {
+		cold[i] = &coldValue{offset: offset + int64(len(buf)), size: int64(len(v))}
+		buf = append(buf, v...)
+	}
+	if _, err := t.file.WriteAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("write tier file: %w", err)
+	}
+
+	t.mu.Lock()
+	t.end += int64(len(buf))
+	t.mu.Unlock()
+	return cold, nil
+}
+
+// read returns a value of the file.
+func (t *tier) read(c *coldValue) ([]byte, error) {
+	value := make([]byte, c.size)
+	if _, err := t.file.ReadAt(value, c.offset); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("read tier file: %w", err)
+	}
+	return value, nil
+}
+
+// fragmented reports whether the file holds garbage.
+func (t *tier) fragmented() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file != nil && t.garbage > 0
+}
+
+// wasteful reports whether most of the file is garbage.
+func (t *tier) wasteful() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.file != nil && t.end >= minTierCompaction && 2*t.garbage > t.end
+}
+
+// value returns the value of the entry e of key, read from the tier file,
+// the storage engine or the mapped file when cold. It is called with the
+// lock of the store held.
+func (k *KeyValueStore) value(key string, e entry) ([]byte, error) {
+	if e.cold == nil {
+		return e.value, nil
+	}
+	switch e.cold.source {
+	case inEngine:
+		metrics.TierLoads.Add(1)
+		return k.engine.value(key)
+	case inMappedFile:
+		return k.mapped.value(e.cold)
+	default:
+		metrics.TierLoads.Add(1)
+		return k.tier.read(e.cold)
+	}
+}
+
+// put stores the entry of key with the next version, persisted to the
+// storage engine first, and adds it to the history of the key, with the
+// write lock held.
+func (k *KeyValueStore) put(key string, e entry) error {
+	if err := k.preserve(key); err != nil {
+		return err
+	}
+	value := e.value
+	if e.cold != nil && (k.engine != nil || k.opts.History > 0) {
+		var err error
+		if value, err = k.value(key, e); err != nil {
+			return err
+		}
+	}
+	e.version = k.revision + 1
+	if k.engine != nil {
+		if err := k.engine.write(record{key: key, value: value, expiresAt: e.expiresAt, deletedAt: e.deletedAt, version: e.version}); err != nil {
+			return err
+		}
+	}
+	k.place(key, e)
+	k.revision = e.version
+	k.record(key, e, value)
+	return nil
+}
+
+// place stores the entry of key in memory alone, for the moves of its
+// value between the tiers, with the write lock held.
+func (k *KeyValueStore) place(key string, e entry) {
+	old, exists := k.data[key]
+	k.data[key] = e
+	k.tier.replace(key, old, exists, e)
+	k.hot.invalidate(key)
+	k.track(key, e)
+}
+
+// remove deletes the entry of key, persisted to the storage engine first,
+// with the write lock held.
+func (k *KeyValueStore) remove(key string) error {
+	e, ok := k.data[key]
+	if !ok {
+		return nil
+	}
+	if err := k.preserve(key); err != nil {
+		return err
+	}
+	if k.engine != nil {
+		if err := k.engine.write(record{key: key, removed: true}); err != nil {
+			return err
+		}
+	}
+	delete(k.data, key)
+	k.tier.remove(key, e)
+	k.hot.invalidate(key)
+	k.untrack(key)
+	delete(k.histories, key)
+	return nil
+}
+
+// replaceAll replaces the entries, persisted to the storage engine first,
+// with the write lock held. The entries hold their values in memory.
+func (k *KeyValueStore) replaceAll(entries map[string]entry) error {
+	if err := k.preserveAll(entries); err != nil {
+		return err
+	}
+	for key, e := range entries {
+		entries[key] = k.versioned(e)
+	}
+	if k.engine != nil {
+		records := make([]record, 0, len(entries))
+		for key, e := range entries {
+			records = append(records, record{key: key, value: e.value, expiresAt: e.expiresAt, deletedAt: e.deletedAt, version: e.version})
+		}
+		slices.SortFunc(records, func(a, b record) int { return strings.Compare(a.key, b.key) })
+		if err := k.engine.reset(records); err != nil {
+			return err
+		}
+	}
+	k.data = entries
+	k.tier.reset(entries)
+	k.hot.invalidateAll()
+	k.trackAll(entries)
+	clear(k.histories)
+	return nil
+}
+
+// warm moves the cold value of key read by Get back to memory, unless the
+// entry changed since.
+func (k *KeyValueStore) warm(key string, cold *coldValue, value []byte) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if e, ok := k.data[key]; ok && e.cold == cold {
+		e.value, e.cold = value, nil
+		k.place(key, e)
+	}
+}
+
+// runSpiller spills the least recently used values to the tier file while
+// the values in memory exceed the limit, until the store is closed.
+func (k *KeyValueStore) runSpiller() {
+	defer k.wg.Done()
+	for {
+		select {
+		case <-k.done:
+			return
+		case <-k.tier.spill:
+			if err := k.spill(); err != nil {
+				k.log.Error().Err(err).Msg("failed to spill cold values")
+			}
+			if len(k.windows) == 0 && k.tier.wasteful() {
+				if err := k.compactTier(); err != nil {
+					k.log.Error().Err(err).Msg("failed to compact the tier file")
+				}
+			}
+		}
+	}
+}
+
+// spill moves the least recently used values to the tier file, or drops
+// them from memory with a storage engine. The values
+// are written without the lock of the store, and only replaced by their
+// location in the file if their entry didn't change meanwhile.
+func (k *KeyValueStore) spill() error {
+	keys := k.tier.coldest()
+	if len(keys) == 0 {
+		return nil
+	}
+	// The offsets of the values must point into the file they were
+	// written to.
+	k.tier.fileMu.Lock()
+	defer k.tier.fileMu.Unlock()
+
+	spilled := keys[:0]
+	var values [][]byte
+	k.mu.RLock()
+	for _, key := range keys {
+		if e, ok := k.data[key]; ok && e.cold == nil && len(e.value) > 0 {
+			spilled = append(spilled, key)
+			values = append(values, e.value)
+		}
+	}
+	k.mu.RUnlock()
+
+	cold, err := k.tier.write(values)
+	if err != nil {
+		return err
+	}
+
+	var n int64
+	k.mu.Lock()
+	for i, key := range spilled {
+		e, ok := k.data[key]
+		if !ok || e.cold != nil || !sameValue(e.value, values[i]) {
+			k.tier.remove(key, entry{cold: cold[i]})
+			continue
+		}
+		e.value, e.cold = nil, cold[i]
+		k.place(key, e)
+		n++
+	}
+	k.mu.Unlock()
+	metrics.TierSpills.Add(n)
+	return nil
+}
+
+// sameValue reports whether a and b are the same slice, rather than equal.
+func sameValue(a, b []byte) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// compactTier rewrites the tier file with the cold values alone, holding
+// the write lock of the store meanwhile, and excluding spills.
+func (k *KeyValueStore) compactTier() error {
+	t := k.tier
+	t.fileMu.Lock()
+	defer t.fileMu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), tierFile+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	moved := make(map[string]*coldValue)
+	var end int64
+	for key, e := range k.data {
+		if !e.cold.spilled() {
+			continue
+		}
+		value, err := t.read(e.cold)
+		if err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if _, err := tmp.Write(value); err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("write tier file: %w", err)
+		}
+		moved[key] = &coldValue{offset: end, size: e.cold.size}
+		end += e.cold.size
+	}
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	for key, cold := range moved {
+		e := k.data[key]
+		e.cold = cold
+		k.data[key] = e
+	}
+	_ = t.file.Close()
+
+	t.mu.Lock()
+	t.file = tmp
+	t.end, t.garbage = end, 0
+	t.mu.Unlock()
+	metrics.TierCompactions.Add(1)
+	return nil
+}
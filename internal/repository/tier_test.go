@@ -0,0 +1,199 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTieredStore(t *testing.T, maxMemory int64) *KeyValueStore {
+	t.Helper()
+	kvs, err := NewKeyValueStore(zerolog.Nop(), Opts{
+		TombstoneRetention: time.Minute,
+		Tier:               TierConfig{Dir: t.TempDir(), MaxMemory: maxMemory},
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = kvs.Close() })
+	return kvs
+}
+
+func (t *tier) stats() (hotBytes, end, garbage int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hotBytes, t.end, t.garbage
+}
+
+func TestTier(t *testing.T) {
+	ctx := context.Background()
+	kvs := newTieredStore(t, 100)
+
+	value := func(i int) []byte { return []byte(fmt.Sprintf("%02d", i) + strings.Repeat("v", 18)) }
+	for i := range 10 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%d", i), value(i)))
+	}
+	require.Eventually(t, func() bool {
+		hotBytes, _, _ := kvs.tier.stats()
+		return hotBytes <= 90
+	}, 5*time.Second, time.Millisecond, "the least recently used values are spilled")
+
+	kvs.mu.RLock()
+	assert.NotNil(t, kvs.data["k0"].cold, "the first key written is cold")
+	assert.Nil(t, kvs.data["k9"].cold, "the last key written is hot")
+	kvs.mu.RUnlock()
+
+	// Reading a cold value moves it back to memory.
+	got, ok, err := kvs.Get(ctx, "k0")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, value(0), got)
+	kvs.mu.RLock()
+	assert.Nil(t, kvs.data["k0"].cold)
+	kvs.mu.RUnlock()
+
+	// Every operation reads the values of both tiers.
+	items, err := kvs.Scan(ctx, "k", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 10)
+	for i, item := range items {
+		assert.Equal(t, value(i), item.Value, item.Key)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, kvs.Snapshot(ctx, &buf))
+	data, err := DecodeSnapshot(&buf)
+	require.NoError(t, err)
+	assert.Len(t, data.Store, 10)
+	assert.Equal(t, value(1), data.Store["k1"])
+
+	require.Eventually(t, func() bool {
+		kvs.mu.RLock()
+		defer kvs.mu.RUnlock()
+		return kvs.data["k2"].cold != nil
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, kvs.Delete(ctx, "k2"))
+	restored, err := kvs.Undelete(ctx, "k2")
+	require.NoError(t, err)
+	assert.True(t, restored)
+	got, _, err = kvs.Get(ctx, "k2")
+	require.NoError(t, err)
+	assert.Equal(t, value(2), got)
+
+	path := kvs.tier.path
+	require.FileExists(t, path)
+	require.NoError(t, kvs.Close())
+	assert.NoFileExists(t, path, "the tier file is removed on close")
+}
+
+func TestTierCompaction(t *testing.T) {
+	ctx := context.Background()
+	kvs := newTieredStore(t, 1<<20)
+
+	value := bytes.Repeat([]byte("x"), 64<<10)
+	for i := range 32 {
+		require.NoError(t, kvs.Set(ctx, fmt.Sprintf("k%02d", i), value))
+	}
+	require.Eventually(t, func() bool {
+		hotBytes, _, _ := kvs.tier.stats()
+		return hotBytes <= 1<<20
+	}, 5*time.Second, time.Millisecond)
+
+	// Overwriting the cold values leaves their space in the file unused.
+	kvs.mu.RLock()
+	var cold []string
+	for key, e := range kvs.data {
+		if e.cold != nil {
+			cold = append(cold, key)
+		}
+	}
+	kvs.mu.RUnlock()
+	for _, key := range cold[1:] {
+		require.NoError(t, kvs.Set(ctx, key, []byte("small")))
+	}
+	_, end, garbage := kvs.tier.stats()
+	require.Greater(t, 2*garbage, end)
+
+	require.NoError(t, kvs.compactTier())
+	_, end, garbage = kvs.tier.stats()
+	assert.Equal(t, int64(len(value)), end)
+	assert.Zero(t, garbage)
+	info, err := os.Stat(filepath.Join(filepath.Dir(kvs.tier.path), tierFile))
+	require.NoError(t, err)
+	assert.Equal(t, end, info.Size())
+
+	got, _, err := kvs.Get(ctx, cold[0])
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+}
+
+func TestTierConcurrent(t *testing.T) {
+	ctx := context.Background()
+	kvs := newTieredStore(t, 1<<10)
+
+	var wg sync.WaitGroup
+	for w := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				key := fmt.Sprintf("k%d", (i*7+w)%64)
+				want := []byte(strings.Repeat(key, 8))
+				_ = kvs.Set(ctx, key, want)
+				got, ok, err := kvs.Get(ctx, key)
+				if assert.NoError(t, err) && ok {
+					assert.Equal(t, want, got)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestTierSpillDuringCompaction(t *testing.T) {
+	ctx := context.Background()
+	kvs := newTieredStore(t, 1<<10)
+
+	value := func(key string) []byte { return []byte(strings.Repeat(key, 16)) }
+	var writers sync.WaitGroup
+	for w := range 4 {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := range 2000 {
+				key := fmt.Sprintf("k%02d", (i*7+w)%64)
+				assert.NoError(t, kvs.Set(ctx, key, value(key)))
+				assert.NoError(t, kvs.spill())
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		writers.Wait()
+		close(done)
+	}()
+	for compacting := true; compacting; {
+		select {
+		case <-done:
+			compacting = false
+		default:
+			require.NoError(t, kvs.compactTier())
+		}
+	}
+
+	// The values spilled while the file was rewritten are read back intact.
+	for i := range 64 {
+		key := fmt.Sprintf("k%02d", i)
+		got, ok, err := kvs.Get(ctx, key)
+		require.NoError(t, err)
+		require.True(t, ok, key)
+		assert.Equal(t, value(key), got, key)
+	}
+}
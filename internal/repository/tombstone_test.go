@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/metrics"
+)
+
+func TestKeyValueStoreTombstones(t *testing.T) {
+	logger := zerolog.New(os.Stdout)
+	ctx := context.Background()
+
+	t.Run("Delete keeps a tombstone that Undelete restores", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+
+		require.NoError(t, store.SetWithTTL(ctx, "key", []byte("value"), time.Hour))
+		require.NoError(t, store.Delete(ctx, "key"))
+
+		_, exists, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, restored)
+
+		got, exists, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, []byte("value"), got)
+
+		expiresAt, _, err := store.Expiry(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+	})
+
+	t.Run("Undelete without tombstone", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+
+		restored, err := store.Undelete(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, restored)
+
+		require.NoError(t, store.Set(ctx, "live", []byte("value")))
+		restored, err = store.Undelete(ctx, "live")
+		require.NoError(t, err)
+		assert.False(t, restored)
+	})
+
+	t.Run("Set replaces a tombstone", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+
+		require.NoError(t, store.Set(ctx, "key", []byte("old")))
+		require.NoError(t, store.Delete(ctx, "key"))
+		require.NoError(t, store.Set(ctx, "key", []byte("new")))
+
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, restored)
+
+		got, _, err := store.Get(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("new"), got)
+	})
+
+	t.Run("tombstones are purged after retention", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{TombstoneRetention: time.Minute})
+		now := time.Now()
+		store.now = func() time.Time { return now }
+
+		require.NoError(t, store.Set(ctx, "key", []byte("value")))
+		require.NoError(t, store.Delete(ctx, "key"))
+
+		assert.Zero(t, store.reapExpired())
+
+		before := metrics.PurgedTombstones.Value()
+		now = now.Add(time.Minute)
+
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, restored)
+
+		assert.Equal(t, 1, store.reapExpired())
+		assert.Equal(t, before+1, metrics.PurgedTombstones.Value())
+		assert.Empty(t, store.data)
+	})
+
+	t.Run("zero retention deletes immediately", func(t *testing.T) {
+		store, _ := NewKeyValueStore(logger, Opts{})
+
+		require.NoError(t, store.Set(ctx, "key", []byte("value")))
+		require.NoError(t, store.Delete(ctx, "key"))
+		assert.Empty(t, store.data)
+
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, restored)
+	})
+}
@@ -0,0 +1,31 @@
+package repository
+
+import "errors"
+
+// Every write of a key gives it a new version, the next of a counter of the
+// store, so the versions of a key only increase, even once deleted and
+// written again. Conditional writes compare the version of the key with the
+// one the client read, as an optimistic lock.
+
+// ErrVersionMismatch is returned by the conditional writes of a key that
+// isn't at the expected version.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// checkVersion checks the entry e, live or not, is at version, any version
+// when zero.
+func checkVersion(e entry, live bool, version uint64) error {
+	if version != 0 && (!live || e.version != version) {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// versioned returns e given the next version when it has none, and counts
+// its version as given, with the write lock held or while loading.
+func (k *KeyValueStore) versioned(e entry) entry {
+	if e.version == 0 {
+		e.version = k.revision + 1
+	}
+	k.revision = max(k.revision, e.version)
+	return e
+}
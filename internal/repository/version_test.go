@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreVersions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("conditional writes", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{TombstoneRetention: time.Minute})
+		require.NoError(t, err)
+
+		_, err = store.SetIfVersion(ctx, "key", []byte("v"), 0, 1)
+		assert.ErrorIs(t, err, ErrVersionMismatch, "a missing key has no version")
+		first, err := store.SetIfVersion(ctx, "key", []byte("1"), 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Set(ctx, "other", []byte("v")))
+
+		value, version, exists, err := store.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, []byte("1"), value)
+		assert.Equal(t, first, version)
+
+		second, err := store.SetIfVersion(ctx, "key", []byte("2"), time.Hour, first)
+		require.NoError(t, err)
+		assert.Greater(t, second, first)
+		_, err = store.SetIfVersion(ctx, "key", []byte("3"), 0, first)
+		assert.ErrorIs(t, err, ErrVersionMismatch)
+
+		assert.ErrorIs(t, store.DeleteIfVersion(ctx, "key", first), ErrVersionMismatch)
+		require.NoError(t, store.DeleteIfVersion(ctx, "key", second))
+		assert.ErrorIs(t, store.DeleteIfVersion(ctx, "key", second), ErrVersionMismatch, "a deleted key has no version")
+
+		// The versions of a key keep increasing once deleted and restored.
+		restored, err := store.Undelete(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, restored)
+		_, third, _, err := store.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		assert.Greater(t, third, second+1)
+	})
+
+	t.Run("hot keys", func(t *testing.T) {
+		store, err := NewKeyValueStore(zerolog.Nop(), Opts{HotCache: HotCacheConfig{TTL: time.Minute, Threshold: 1}})
+		require.NoError(t, err)
+
+		version, err := store.SetIfVersion(ctx, "key", []byte("v"), 0, 0)
+		require.NoError(t, err)
+		for range 3 {
+			_, got, _, err := store.GetWithVersion(ctx, "key")
+			require.NoError(t, err)
+			assert.Equal(t, version, got)
+		}
+	})
+
+	t.Run("persisted", func(t *testing.T) {
+		opts := Opts{LSM: LSMConfig{Dir: t.TempDir()}}
+		store := openLSMStore(t, opts)
+		version, err := store.SetIfVersion(ctx, "key", []byte("v"), 0, 0)
+		require.NoError(t, err)
+		require.NoError(t, store.Set(ctx, "other", []byte("v")))
+		require.NoError(t, store.Close())
+
+		store = openLSMStore(t, opts)
+		_, got, exists, err := store.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		require.True(t, exists)
+		assert.Equal(t, version, got)
+		next, err := store.SetIfVersion(ctx, "key", []byte("v"), 0, version)
+		require.NoError(t, err)
+		assert.Greater(t, next, version+1)
+
+		var buf bytes.Buffer
+		require.NoError(t, store.Snapshot(ctx, &buf))
+		restored, err := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, err)
+		require.NoError(t, restored.Restore(ctx, &buf))
+		_, got, _, err = restored.GetWithVersion(ctx, "key")
+		require.NoError(t, err)
+		assert.Equal(t, next, got)
+		latest, err := restored.SetIfVersion(ctx, "new", []byte("v"), 0, 0)
+		require.NoError(t, err)
+		assert.Greater(t, latest, next)
+	})
+}
+
+func TestRecordEncoding(t *testing.T) {
+	for _, r := range []record{
+		{key: "key", value: []byte("value"), expiresAt: 1, deletedAt: 2},
+		{key: "key", value: []byte("value"), version: 42},
+		{key: "key", removed: true},
+	} {
+		b := appendRecord(nil, r)
+		got, n, err := decodeRecord(b)
+		require.NoError(t, err)
+		assert.Equal(t, len(b), n)
+		if r.removed {
+			r.value = nil
+		}
+		assert.Equal(t, r, got)
+	}
+	_, _, err := decodeRecord([]byte{recordVersioned + 1, 0})
+	assert.ErrorIs(t, err, errCorruptTable)
+}
@@ -0,0 +1,99 @@
+package repository
+
+// A view is a point-in-time view of the entries, read without holding the
+// lock of the store throughout so writers go on meanwhile. Writers keep what
+// the open views are to see: the first change of a key after a view is
+// opened saves the entry it replaces, with its value, into the view.
+// Readers of a view skip the keys it saved while iterating the entries,
+// then read the saved ones.
+
+// view is a point-in-time view of the entries.
+type view struct {
+	// at is the time of the view in unix nanoseconds.
+	at int64
+	// revision is the last version given when the view was opened.
+	revision uint64
+	// saved holds the entries as of the view of the keys changed since it
+	// was opened.
+	saved map[string]savedEntry
+}
+
+// savedEntry is the entry of a key as of a view.
+type savedEntry struct {
+	e     entry
+	value []byte
+	// exists is false for the keys created since the view was opened.
+	exists bool
+}
+
+// openView opens a view of the entries at now, within the horizon, to be
+// closed with closeView.
+func (k *KeyValueStore) openView() *view {
+	v := &view{at: k.settled(), saved: make(map[string]savedEntry)}
+	k.mu.Lock()
+	v.revision = k.revision
+	k.views = append(k.views, v)
+	k.mu.Unlock()
+	return v
+}
+
+// closeView stops saving the changes of the entries for v.
+func (k *KeyValueStore) closeView(v *view) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for i := range k.views {
+		if k.views[i] == v {
+			k.views = append(k.views[:i], k.views[i+1:]...)
+			return
+		}
+	}
+}
+
+// preserve saves the entry of key into the open views that haven't saved
+// it yet, before it changes, with the write lock held.
+func (k *KeyValueStore) preserve(key string) error {
+	if len(k.views) == 0 {
+		return nil
+	}
+	e, exists := k.data[key]
+	var saved savedEntry
+	loaded := false
+	for _, v := range k.views {
+		if _, ok := v.saved[key]; ok {
+			continue
+		}
+		if !loaded {
+			saved = savedEntry{e: e, exists: exists}
+			if exists && !e.tombstone() {
+				// Cold values are moved or overwritten by the change.
+				value, err := k.value(key, e)
+				if err != nil {
+					return err
+				}
+				saved.value = value
+			}
+			loaded = true
+		}
+		v.saved[key] = saved
+	}
+	return nil
+}
+
+// preserveAll saves the entries replaced by entries into the open views,
+// with the write lock held.
+func (k *KeyValueStore) preserveAll(entries map[string]entry) error {
+	if len(k.views) == 0 {
+		return nil
+	}
+	for key := range k.data {
+		if err := k.preserve(key); err != nil {
+			return err
+		}
+	}
+	for key := range entries {
+		if err := k.preserve(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
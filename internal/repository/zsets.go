@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+)
+
+// Sorted sets are values holding a JSON array of distinct members with a
+// score, kept sorted by score then member, so they read like any other
+// value and ranges are served without sorting.
+
+var (
+	// ErrNotSortedSet is returned when a sorted set operation targets a
+	// value that is not a JSON array of scored members.
+	ErrNotSortedSet = errors.New("value is not a sorted set")
+	// ErrInvalidScore is returned for a NaN or infinite score.
+	ErrInvalidScore = errors.New("score must be a finite number")
+)
+
+// ScoredMember is a member of a sorted set with its score.
+type ScoredMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// DecodeSortedSet returns the members of a sorted set value, sorted by
+// score then member.
+func DecodeSortedSet(value []byte) ([]ScoredMember, error) {
+	var members []ScoredMember
+	if err := json.Unmarshal(value, &members); err != nil || members == nil {
+		return nil, ErrNotSortedSet
+	}
+	seen := make(map[string]bool, len(members))
+	for _, m := range members {
+		if seen[m.Member] {
+			return nil, ErrNotSortedSet
+		}
+		seen[m.Member] = true
+	}
+	sortScored(members)
+	return members, nil
+}
+
+// EncodeSortedSet returns the value of the sorted set of members, which
+// must be distinct.
+func EncodeSortedSet(members []ScoredMember) []byte {
+	if members == nil {
+		members = []ScoredMember{}
+	}
+	sortScored(members)
+	value, _ := json.Marshal(members)
+	return value
+}
+
+// sortScored sorts members by score then member, in place.
+func sortScored(members []ScoredMember) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+}
+
+// AddScores atomically adds members to the sorted set stored at key, or
+// updates the score of those it holds, and returns the members it didn't
+// hold, sorted. A missing key is treated as an empty sorted set, so the
+// first addition creates it. The expiry of an existing key is preserved. A
+// maxSize over zero bounds the size of the sorted set value.
+func (k *KeyValueStore) AddScores(ctx context.Context, key string, members []ScoredMember, maxSize int) ([]string, error) {
+	var added []string
+	err := k.modify(ctx, key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, added, err = ApplyAddScores(value, exists, members, maxSize)
+		return value, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// ApplyAddScores returns the sorted set value, missing unless exists, with
+// members added or their scores updated, nil when none changed, and the
+// members it didn't hold, sorted, as AddScores stores it.
+func ApplyAddScores(value []byte, exists bool, members []ScoredMember, maxSize int) ([]byte, []string, error) {
+	for _, m := range members {
+		if math.IsNaN(m.Score) || math.IsInf(m.Score, 0) {
+			return nil, nil, ErrInvalidScore
+		}
+	}
+	scores := map[string]float64{}
+	if exists {
+		current, err := DecodeSortedSet(value)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, m := range current {
+			scores[m.Member] = m.Score
+		}
+	}
+
+	var added []string
+	changed := false
+	for _, m := range members {
+		score, ok := scores[m.Member]
+		if !ok {
+			added = append(added, m.Member)
+		}
+		if !ok || score != m.Score {
+			scores[m.Member] = m.Score
+			changed = true
+		}
+	}
+	if !changed {
+		return nil, normalize(added), nil
+	}
+
+	updated := make([]ScoredMember, 0, len(scores))
+	for member, score := range scores {
+		updated = append(updated, ScoredMember{Member: member, Score: score})
+	}
+	value = EncodeSortedSet(updated)
+	if maxSize > 0 && len(value) > maxSize {
+		return nil, nil, ErrTooLarge
+	}
+	return value, normalize(added), nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyValueStoreSortedSets(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("AddScores creates the sorted set and returns the new members", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		added, err := store.AddScores(ctx, "board", []ScoredMember{{"carol", 3}, {"alice", 1}, {"bob", 1}}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice", "bob", "carol"}, added)
+
+		added, err = store.AddScores(ctx, "board", []ScoredMember{{"alice", 5}, {"dave", 0.5}}, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"dave"}, added)
+
+		value, _, err := store.Get(ctx, "board")
+		require.NoError(t, err)
+		assert.Equal(t, `[{"member":"dave","score":0.5},{"member":"bob","score":1},{"member":"carol","score":3},{"member":"alice","score":5}]`, string(value))
+	})
+
+	t.Run("AddScores preserves the expiry", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.SetWithTTL(ctx, "board", []byte(`[{"member":"a","score":1}]`), time.Hour))
+
+		_, err := store.AddScores(ctx, "board", []ScoredMember{{"b", 2}}, 0)
+		require.NoError(t, err)
+		expiresAt, _, err := store.Expiry(ctx, "board")
+		require.NoError(t, err)
+		assert.False(t, expiresAt.IsZero())
+	})
+
+	t.Run("invalid values and scores are rejected", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+		require.NoError(t, store.Set(ctx, "set", []byte(`["a"]`)))
+		require.NoError(t, store.Set(ctx, "duplicates", []byte(`[{"member":"a","score":1},{"member":"a","score":2}]`)))
+
+		for _, key := range []string{"set", "duplicates"} {
+			_, err := store.AddScores(ctx, key, []ScoredMember{{"b", 1}}, 0)
+			assert.ErrorIs(t, err, ErrNotSortedSet)
+		}
+		_, err := store.AddScores(ctx, "board", []ScoredMember{{"a", math.Inf(1)}}, 0)
+		assert.ErrorIs(t, err, ErrInvalidScore)
+	})
+
+	t.Run("AddScores bounds the size of the sorted set", func(t *testing.T) {
+		store, _ := NewKeyValueStore(zerolog.Nop(), Opts{})
+
+		_, err := store.AddScores(ctx, "board", []ScoredMember{{"a", 1}}, 30)
+		require.NoError(t, err)
+		_, err = store.AddScores(ctx, "board", []ScoredMember{{"b", 2}}, 30)
+		assert.ErrorIs(t, err, ErrTooLarge)
+
+		value, _, err := store.Get(ctx, "board")
+		require.NoError(t, err)
+		assert.Equal(t, `[{"member":"a","score":1}]`, string(value))
+	})
+}
@@ -0,0 +1,392 @@
+package resp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"codesignal/internal/cluster"
+	"codesignal/internal/rdb"
+	"codesignal/internal/store"
+)
+
+// defaultScanCount is the page size of SCAN without COUNT, as in Redis.
+const defaultScanCount = 10
+
+// exec runs the command in args and writes its reply. It reports whether
+// the client asked to close the connection.
+func (s *Server) exec(ctx context.Context, w *writer, args [][]byte) bool {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "ping":
+		s.ping(w, args)
+	case "quit":
+		w.simple("OK")
+		return true
+	case "get":
+		s.get(ctx, w, args)
+	case "set":
+		s.set(ctx, w, args)
+	case "del":
+		s.del(ctx, w, args)
+	case "exists":
+		s.exists(ctx, w, args)
+	case "ttl":
+		s.ttl(ctx, w, args)
+	case "scan":
+		s.scan(ctx, w, args)
+	case "dump":
+		s.dump(ctx, w, args)
+	case "restore":
+		s.restore(ctx, w, args)
+	default:
+		w.error("ERR unknown command '" + string(args[0]) + "'")
+	}
+	return false
+}
+
+// arity checks the number of arguments of a command, including its name.
+// A negative arity is a minimum.
+func arity(w *writer, args [][]byte, n int) bool {
+	if (n >= 0 && len(args) == n) || (n < 0 && len(args) >= -n) {
+		return true
+	}
+	w.error("ERR wrong number of arguments for '" + strings.ToLower(string(args[0])) + "' command")
+	return false
+}
+
+func (s *Server) ping(w *writer, args [][]byte) {
+	switch len(args) {
+	case 1:
+		w.simple("PONG")
+	case 2:
+		w.bulk(args[1])
+	default:
+		arity(w, args, 2)
+	}
+}
+
+func (s *Server) get(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, 2) {
+		return
+	}
+
+	value, err := s.svc.Get(ctx, string(args[1]))
+	if errors.Is(err, store.ErrKeyNotFound) {
+		w.bulk(nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.bulk(value)
+}
+
+// set implements SET key value [EX seconds | PX milliseconds] [NX].
+func (s *Server) set(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, -3) {
+		return
+	}
+
+	var (
+		ttl time.Duration
+		nx  bool
+	)
+	for i := 3; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "NX" && !nx:
+			nx = true
+		case (opt == "EX" || opt == "PX") && ttl == 0 && i+1 < len(args):
+			i++
+			n, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > int64(math.MaxInt64/unit) {
+				w.error("ERR invalid expire time in 'set' command")
+				return
+			}
+			ttl = time.Duration(n) * unit
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	key, value := string(args[1]), args[2]
+	if nx {
+		err := s.svc.Create(ctx, key, value, ttl)
+		if errors.Is(err, store.ErrKeyExists) {
+			w.bulk(nil)
+			return
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.simple("OK")
+		return
+	}
+
+	if err := s.svc.Set(ctx, key, value, ttl); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.simple("OK")
+}
+
+func (s *Server) del(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, -2) {
+		return
+	}
+
+	var deleted int64
+	for _, key := range args[1:] {
+		err := s.svc.Delete(ctx, string(key))
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		deleted++
+	}
+	w.integer(deleted)
+}
+
+func (s *Server) exists(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, -2) {
+		return
+	}
+
+	var found int64
+	for _, key := range args[1:] {
+		_, err := s.svc.Get(ctx, string(key))
+		if errors.Is(err, store.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			s.writeError(w, err)
+			return
+		}
+		found++
+	}
+	w.integer(found)
+}
+
+// ttl replies with the remaining time to live of a key in seconds, -1 if
+// it never expires and -2 if it doesn't exist.
+func (s *Server) ttl(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, 2) {
+		return
+	}
+
+	expiresAt, err := s.svc.Expiry(ctx, string(args[1]))
+	switch {
+	case errors.Is(err, store.ErrKeyNotFound):
+		w.integer(-2)
+	case err != nil:
+		s.writeError(w, err)
+	case expiresAt.IsZero():
+		w.integer(-1)
+	default:
+		remaining := time.Until(expiresAt).Round(time.Second)
+		w.integer(max(int64(remaining/time.Second), 0))
+	}
+}
+
+// scan implements SCAN cursor [MATCH pattern] [COUNT count]. Keys are
+// visited in lexical order, COUNT keys per call, and the returned cursor
+// refers to the last key visited. As in Redis, a call may return fewer keys
+// than COUNT, or none, when they don't match the pattern.
+func (s *Server) scan(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, -2) {
+		return
+	}
+
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.error("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "*", defaultScanCount
+	for i := 2; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			w.error("ERR syntax error")
+			return
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "MATCH":
+			// Patterns match the keys as stored.
+			pattern = s.svc.NormalizeKey(string(args[i+1]))
+		case "COUNT":
+			count, err = strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				w.error("ERR value is not an integer or out of range")
+				return
+			}
+			if count < 1 {
+				w.error("ERR syntax error")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	var after string
+	if cursor != 0 {
+		var ok bool
+		if after, ok = s.cursors.load(cursor); !ok {
+			w.error("ERR invalid cursor")
+			return
+		}
+	}
+
+	items, err := s.svc.Scan(ctx, literalPrefix(pattern), after, count)
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+
+	var next uint64
+	if len(items) == count {
+		next = s.cursors.save(items[len(items)-1].Key)
+	}
+
+	keys := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if match(pattern, item.Key) {
+			keys = append(keys, []byte(item.Key))
+		}
+	}
+
+	w.array(2)
+	w.bulk([]byte(strconv.FormatUint(next, 10)))
+	w.array(len(keys))
+	for _, key := range keys {
+		w.bulk(key)
+	}
+}
+
+func (s *Server) dump(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, 2) {
+		return
+	}
+
+	payload, err := s.svc.Dump(ctx, string(args[1]))
+	if errors.Is(err, store.ErrKeyNotFound) {
+		w.bulk(nil)
+		return
+	}
+	if err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.bulk(payload)
+}
+
+// restore implements RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME
+// seconds] [FREQ frequency]. The ttl is in milliseconds, zero for no
+// expiry, or a unix time in milliseconds with ABSTTL. IDLETIME and FREQ
+// are accepted for compatibility and ignored, the store has no eviction.
+func (s *Server) restore(ctx context.Context, w *writer, args [][]byte) {
+	if !arity(w, args, -4) {
+		return
+	}
+
+	ms, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil || ms < 0 {
+		w.error("ERR Invalid TTL value, must be >= 0")
+		return
+	}
+
+	var replace, absTTL bool
+	for i := 4; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "ABSTTL":
+			absTTL = true
+		case (opt == "IDLETIME" || opt == "FREQ") && i+1 < len(args):
+			i++
+			if n, err := strconv.ParseInt(string(args[i]), 10, 64); err != nil || n < 0 {
+				w.error("ERR Invalid " + opt + " value, must be >= 0")
+				return
+			}
+		default:
+			w.error("ERR syntax error")
+			return
+		}
+	}
+
+	key := string(args[1])
+	var ttl time.Duration
+	switch {
+	case ms == 0:
+	case absTTL:
+		ttl = time.Until(time.UnixMilli(ms))
+	case ms > int64(math.MaxInt64/time.Millisecond):
+		w.error("ERR Invalid TTL value, must be >= 0")
+		return
+	default:
+		ttl = time.Duration(ms) * time.Millisecond
+	}
+	if ms != 0 && ttl <= 0 {
+		// Already expired: as in Redis, the key is not created and a
+		// replaced key is deleted.
+		if replace {
+			if err := s.svc.Delete(ctx, key); err != nil && !errors.Is(err, store.ErrKeyNotFound) {
+				s.writeError(w, err)
+				return
+			}
+		}
+		w.simple("OK")
+		return
+	}
+
+	err = s.svc.Restore(ctx, key, args[3], ttl, replace)
+	switch {
+	case errors.Is(err, store.ErrKeyExists):
+		w.error("BUSYKEY Target key name already exists.")
+	case errors.Is(err, rdb.ErrInvalidDump):
+		w.error("ERR " + rdb.ErrInvalidDump.Error())
+	case err != nil:
+		s.writeError(w, err)
+	default:
+		w.simple("OK")
+	}
+}
+
+// writeError replies with the error of a store operation.
+func (s *Server) writeError(w *writer, err error) {
+	switch {
+	case errors.Is(err, store.ErrInvalidKey), errors.Is(err, store.ErrKeyTooLong), errors.Is(err, store.ErrValueTooLarge),
+		errors.Is(err, store.ErrInvalidDump), errors.Is(err, store.ErrRejected):
+		w.error("ERR " + err.Error())
+	case errors.Is(err, store.ErrReadOnly):
+		w.error("READONLY " + err.Error())
+	case errors.Is(err, store.ErrMemoryLimit):
+		// The error of Redis past its maxmemory.
+		w.error("OOM " + err.Error())
+	case errors.Is(err, cluster.ErrNotLeader):
+		w.error("ERR " + cluster.ErrNotLeader.Error())
+	case errors.Is(err, cluster.ErrNoLeader):
+		w.error("ERR " + cluster.ErrNoLeader.Error())
+	default:
+		s.log.Error().Err(err).Msg("store operation failed")
+		w.error("ERR storage error")
+	}
+}
@@ -0,0 +1,52 @@
+package resp
+
+import "sync"
+
+// maxCursors is the number of SCAN cursors remembered before the oldest
+// ones are forgotten.
+const maxCursors = 16 * 1024
+
+// cursors maps the numeric SCAN cursors handed to clients to the key the
+// scan resumes after. Redis clients expect numeric cursors, while the store
+// pages by key. Cursors are shared by all connections, since client
+// libraries may continue a scan on another pooled connection.
+type cursors struct {
+	mu    sync.Mutex
+	max   int
+	next  uint64
+	keys  map[uint64]string
+	order []uint64
+}
+
+func newCursors(max int) *cursors {
+	return &cursors{max: max, keys: make(map[uint64]string)}
+}
+
+// save returns a new cursor resuming after key.
+func (c *cursors) save(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.next++
+	if c.next == 0 {
+		// Zero starts a new scan.
+		c.next++
+	}
+	c.keys[c.next] = key
+	c.order = append(c.order, c.next)
+
+	if len(c.order) > c.max {
+		delete(c.keys, c.order[0])
+		c.order = c.order[1:]
+	}
+	return c.next
+}
+
+// load returns the key the cursor resumes after.
+func (c *cursors) load(cursor uint64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.keys[cursor]
+	return key, ok
+}
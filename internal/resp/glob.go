@@ -0,0 +1,105 @@
+package resp
+
+import "strings"
+
+// match reports whether s matches the Redis glob-style pattern, which
+// supports '*', '?', character classes like "[a-z]" or "[^abc]", and '\'
+// escapes.
+func match(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if match(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			if matched, rest, ok := matchClass(pattern[1:], s[0]); ok {
+				if !matched {
+					return false
+				}
+				pattern, s = rest, s[1:]
+				continue
+			}
+			// An unterminated class matches a literal '['.
+			if s[0] != '[' {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of p,
+// just after its '['. It returns the pattern following the class, and false
+// if the class isn't terminated.
+func matchClass(p string, c byte) (matched bool, rest string, ok bool) {
+	negate := len(p) > 0 && p[0] == '^'
+	if negate {
+		p = p[1:]
+	}
+
+	for len(p) > 0 {
+		switch {
+		case p[0] == ']':
+			return matched != negate, p[1:], true
+		case p[0] == '\\' && len(p) > 1:
+			matched = matched || p[1] == c
+			p = p[2:]
+		case len(p) > 2 && p[1] == '-' && p[2] != ']':
+			lo, hi := p[0], p[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (c >= lo && c <= hi)
+			p = p[3:]
+		default:
+			matched = matched || p[0] == c
+			p = p[1:]
+		}
+	}
+	return false, "", false
+}
+
+// literalPrefix returns the prefix every key matching pattern starts with,
+// so scans only visit candidate keys.
+func literalPrefix(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return b.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		}
+		b.WriteByte(pattern[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,57 @@
+package resp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "*", key: "", want: true},
+		{pattern: "*", key: "anything", want: true},
+		{pattern: "user:*", key: "user:1", want: true},
+		{pattern: "user:*", key: "order:1", want: false},
+		{pattern: "*:1", key: "user:1", want: true},
+		{pattern: "u*r:*1", key: "user:21", want: true},
+		{pattern: "h?llo", key: "hello", want: true},
+		{pattern: "h?llo", key: "hllo", want: false},
+		{pattern: "h[ae]llo", key: "hallo", want: true},
+		{pattern: "h[ae]llo", key: "hillo", want: false},
+		{pattern: "h[^e]llo", key: "hallo", want: true},
+		{pattern: "h[^e]llo", key: "hello", want: false},
+		{pattern: "h[a-b]llo", key: "hbllo", want: true},
+		{pattern: "h[b-a]llo", key: "hallo", want: true},
+		{pattern: `h\*llo`, key: "h*llo", want: true},
+		{pattern: `h\*llo`, key: "hello", want: false},
+		{pattern: "h[allo", key: "h[allo", want: true},
+		{pattern: "a/*", key: "a/b/c", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.want, match(tt.pattern, tt.key))
+		})
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := map[string]string{
+		"*":         "",
+		"user:*":    "user:",
+		"user:?":    "user:",
+		"user:[ab]": "user:",
+		`a\*b*`:     "a*b",
+		"exact":     "exact",
+	}
+
+	for pattern, want := range tests {
+		t.Run(pattern, func(t *testing.T) {
+			assert.Equal(t, want, literalPrefix(pattern))
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Limits protecting the server from oversized requests.
+const (
+	maxArgs       = 1024 * 1024
+	maxBulkLength = 64 << 20
+)
+
+// protocolError is a malformed request. The connection is closed after it
+// is reported, since the stream can't be resynchronized.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// reader parses commands sent by clients, either as RESP arrays of bulk
+// strings or as inline commands typed by hand, e.g. over telnet.
+type reader struct {
+	*bufio.Reader
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{Reader: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// readCommand returns the arguments of the next command. It returns no
+// arguments for empty inline lines.
+func (r *reader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		// Copy the fields, line is overwritten by the next read.
+		return bytes.Fields(bytes.Clone(line)), nil
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	args := make([][]byte, 0, n)
+	for range n {
+		arg, err := r.readBulk()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+func (r *reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, protocolError("expected '$'")
+	}
+
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLength {
+		return nil, protocolError("invalid bulk length")
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, protocolError("expected CRLF after bulk string")
+	}
+	return buf[:n], nil
+}
+
+// readLine reads a line without its terminator. The returned slice is only
+// valid until the next read.
+func (r *reader) readLine() ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, protocolError("too big request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+var lineBreaks = strings.NewReplacer("\r", " ", "\n", " ")
+
+// writer encodes RESP2 replies. Write errors are sticky and reported by
+// Flush.
+type writer struct {
+	*bufio.Writer
+}
+
+func newWriter(w io.Writer) *writer {
+	return &writer{Writer: bufio.NewWriter(w)}
+}
+
+func (w *writer) simple(s string) {
+	_, _ = w.WriteString("+" + s + "\r\n")
+}
+
+// error writes an error reply. Line breaks, e.g. from echoed arguments, are
+// replaced so they can't end the reply early.
+func (w *writer) error(msg string) {
+	_, _ = w.WriteString("-" + lineBreaks.Replace(msg) + "\r\n")
+}
+
+func (w *writer) integer(n int64) {
+	_, _ = w.WriteString(":" + strconv.FormatInt(n, 10) + "\r\n")
+}
+
+// bulk writes b as a bulk string, or the null bulk string if b is nil.
+func (w *writer) bulk(b []byte) {
+	if b == nil {
+		_, _ = w.WriteString("$-1\r\n")
+		return
+	}
+	_, _ = w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n")
+	_, _ = w.Write(b)
+	_, _ = w.WriteString("\r\n")
+}
+
+func (w *writer) array(n int) {
+	_, _ = w.WriteString("*" + strconv.Itoa(n) + "\r\n")
+}
@@ -0,0 +1,84 @@
+// Package resp serves a subset of the Redis protocol (RESP2), so redis-cli
+// and Redis client libraries can use the key-value store.
+//
+// The supported commands are GET, SET (with EX, PX and NX), DEL, EXISTS,
+// TTL, SCAN (with MATCH and COUNT), DUMP and RESTORE, plus PING and QUIT.
+// DUMP payloads are compatible with Redis for string values. They run through
+// the same store.Service as the HTTP API, so keys are validated the same
+// way regardless of the protocol.
+package resp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/server"
+	"codesignal/internal/store"
+)
+
+// Config holds the configuration of the Redis protocol listener.
+type Config struct {
+	// Enabled turns on the Redis protocol listener.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// Address is the address the listener accepts connections on.
+	Address string `envconfig:"ADDRESS" default:"0.0.0.0:6379"`
+}
+
+// Server accepts Redis protocol connections.
+type Server struct {
+	*server.TCPServer
+
+	log     zerolog.Logger
+	svc     *store.Service
+	cursors *cursors
+}
+
+// New returns a Redis protocol server for svc.
+func New(log zerolog.Logger, cfg Config, svc *store.Service) *Server {
+	s := &Server{
+		log:     log.With().Str("component", "resp").Logger(),
+		svc:     svc,
+		cursors: newCursors(maxCursors),
+	}
+	s.TCPServer = server.NewTCPServer(s.log, "resp", cfg.Address, s.handle)
+	return s
+}
+
+func (s *Server) handle(ctx context.Context, c *server.Conn) {
+	r, w := newReader(c), newWriter(c)
+	for {
+		args, err := r.readCommand()
+		if err != nil {
+			var perr protocolError
+			if errors.As(err, &perr) {
+				w.error("ERR " + perr.Error())
+				_ = w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				s.log.Debug().Err(err).Msg("failed to read command")
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		if !c.Begin() {
+			return
+		}
+		quit := s.exec(ctx, w, args)
+		// Replies to pipelined commands are flushed together.
+		if quit || r.Buffered() == 0 {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if !c.End() || quit {
+			_ = w.Flush()
+			return
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package resp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// client sends commands as RESP arrays and returns raw replies, with
+// nested arrays flattened into one line per element.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+
+	srv := New(zerolog.Nop(), Config{}, store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8}))
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.ServeListener(lis) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+
+	return srv, lis.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *client) do(args ...string) string {
+	c.t.Helper()
+
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := c.conn.Write([]byte(cmd))
+	require.NoError(c.t, err)
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	c.t.Helper()
+
+	line, err := c.r.ReadString('\n')
+	require.NoError(c.t, err)
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '$':
+		if line == "$-1" {
+			return "(nil)"
+		}
+		var n int
+		_, err := fmt.Sscanf(line, "$%d", &n)
+		require.NoError(c.t, err)
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		require.NoError(c.t, err)
+		return string(buf[:n])
+	case '*':
+		var n int
+		_, err := fmt.Sscanf(line, "*%d", &n)
+		require.NoError(c.t, err)
+		elems := make([]string, 0, n)
+		for range n {
+			elems = append(elems, c.reply())
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	default:
+		return line
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	_, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{name: "ping", args: []string{"PING"}, want: "+PONG"},
+		{name: "ping message", args: []string{"ping", "hi"}, want: "hi"},
+		{name: "get missing", args: []string{"GET", "a"}, want: "(nil)"},
+		{name: "set", args: []string{"SET", "a", "1"}, want: "+OK"},
+		{name: "get", args: []string{"GET", "a"}, want: "1"},
+		{name: "set nx existing", args: []string{"SET", "a", "2", "NX"}, want: "(nil)"},
+		{name: "set nx new", args: []string{"SET", "b", "2", "nx"}, want: "+OK"},
+		{name: "ttl no expiry", args: []string{"TTL", "a"}, want: ":-1"},
+		{name: "ttl missing", args: []string{"TTL", "missing"}, want: ":-2"},
+		{name: "set ex", args: []string{"SET", "c", "3", "EX", "100"}, want: "+OK"},
+		{name: "ttl", args: []string{"TTL", "c"}, want: ":100"},
+		{name: "set px", args: []string{"SET", "d", "4", "PX", "5000"}, want: "+OK"},
+		{name: "ttl px", args: []string{"TTL", "d"}, want: ":5"},
+		{name: "set invalid expire", args: []string{"SET", "e", "5", "EX", "0"}, want: "-ERR invalid expire time in 'set' command"},
+		{name: "set non integer expire", args: []string{"SET", "e", "5", "EX", "x"}, want: "-ERR value is not an integer or out of range"},
+		{name: "set unsupported option", args: []string{"SET", "e", "5", "XX"}, want: "-ERR syntax error"},
+		{name: "set key too long", args: []string{"SET", "too-long-key", "5"}, want: "-ERR err: key length exceeds maximum allowed length, max key length: 8"},
+		{name: "exists", args: []string{"EXISTS", "a", "b", "missing", "a"}, want: ":3"},
+		{name: "del", args: []string{"DEL", "a", "missing", "b"}, want: ":2"},
+		{name: "get deleted", args: []string{"GET", "a"}, want: "(nil)"},
+		{name: "wrong arity", args: []string{"GET"}, want: "-ERR wrong number of arguments for 'get' command"},
+		{name: "unknown command", args: []string{"HELLO", "3"}, want: "-ERR unknown command 'HELLO'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, c.do(tt.args...))
+		})
+	}
+
+	t.Run("quit", func(t *testing.T) {
+		assert.Equal(t, "+OK", c.do("QUIT"))
+		_, err := c.r.ReadByte()
+		assert.Error(t, err, "connection is closed")
+	})
+}
+
+func TestServerScan(t *testing.T) {
+	_, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	for _, key := range []string{"user:1", "user:2", "user:3", "order:1"} {
+		require.Equal(t, "+OK", c.do("SET", key, "v"))
+	}
+
+	assert.Equal(t, "[0 [order:1 user:1 user:2 user:3]]", c.do("SCAN", "0"))
+	assert.Equal(t, "[0 [user:1 user:2 user:3]]", c.do("SCAN", "0", "MATCH", "user:*"))
+	assert.Equal(t, "[0 [order:1 user:1]]", c.do("SCAN", "0", "MATCH", "*:1"))
+
+	// Page through the keyspace, a cursor can be continued on another
+	// connection.
+	reply := c.do("SCAN", "0", "MATCH", "user:*", "COUNT", "2")
+	var cursor string
+	_, err := fmt.Sscanf(reply, "[%s", &cursor)
+	require.NoError(t, err)
+	assert.NotEqual(t, "0", cursor)
+	assert.Equal(t, "["+cursor+" [user:1 user:2]]", reply)
+	assert.Equal(t, "[0 [user:3]]", dial(t, addr).do("SCAN", cursor, "MATCH", "user:*", "COUNT", "2"))
+
+	assert.Equal(t, "-ERR invalid cursor", c.do("SCAN", "42"))
+	assert.Equal(t, "-ERR invalid cursor", c.do("SCAN", "abc"))
+	assert.Equal(t, "-ERR syntax error", c.do("SCAN", "0", "COUNT", "0"))
+	assert.Equal(t, "-ERR syntax error", c.do("SCAN", "0", "MATCH"))
+}
+
+func TestServerDumpRestore(t *testing.T) {
+	_, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	require.Equal(t, "+OK", c.do("SET", "a", "hello"))
+	payload := c.do("DUMP", "a")
+	assert.Equal(t, "(nil)", c.do("DUMP", "missing"))
+
+	assert.Equal(t, "+OK", c.do("RESTORE", "b", "0", payload))
+	assert.Equal(t, "hello", c.do("GET", "b"))
+	assert.Equal(t, ":-1", c.do("TTL", "b"))
+	assert.Equal(t, "-BUSYKEY Target key name already exists.", c.do("RESTORE", "b", "0", payload))
+
+	require.Equal(t, "+OK", c.do("SET", "a", "world"))
+	assert.Equal(t, "+OK", c.do("RESTORE", "b", "10000", c.do("DUMP", "a"), "REPLACE", "IDLETIME", "5"))
+	assert.Equal(t, "world", c.do("GET", "b"))
+	assert.Equal(t, ":10", c.do("TTL", "b"))
+
+	// A payload of Redis, from its documentation.
+	assert.Equal(t, "+OK", c.do("RESTORE", "c", "0", "\x00\xc0\n\n\x00n\x9fWE\x0e\xaec\xbb"))
+	assert.Equal(t, "10", c.do("GET", "c"))
+
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute).UnixMilli(), 10)
+	assert.Equal(t, "+OK", c.do("RESTORE", "b", expired, payload, "ABSTTL", "REPLACE"))
+	assert.Equal(t, "(nil)", c.do("GET", "b"), "keys restored expired are deleted")
+
+	assert.Equal(t, "-ERR DUMP payload version or checksum are wrong", c.do("RESTORE", "d", "0", "garbage"))
+	assert.Equal(t, "-ERR Invalid TTL value, must be >= 0", c.do("RESTORE", "d", "-1", payload))
+	assert.Equal(t, "-ERR syntax error", c.do("RESTORE", "d", "0", payload, "KEEPTTL"))
+}
+
+func TestServerInlineAndPipelined(t *testing.T) {
+	_, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	_, err := c.conn.Write([]byte("SET a 1\r\n\r\nGET a\r\n*1\r\n$4\r\nPING\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "+OK", c.reply())
+	assert.Equal(t, "1", c.reply())
+	assert.Equal(t, "+PONG", c.reply())
+
+	_, err = c.conn.Write([]byte("*1\r\n:1\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "-ERR Protocol error: expected '$'", c.reply())
+	_, err = c.r.ReadByte()
+	assert.Error(t, err, "connection is closed after a protocol error")
+}
+
+func TestServerShutdown(t *testing.T) {
+	srv, addr := newTestServer(t)
+	c := dial(t, addr)
+	require.Equal(t, "+PONG", c.do("PING"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx))
+
+	_, err := c.r.ReadByte()
+	assert.Error(t, err, "idle connections are closed")
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err, "listener is closed")
+}
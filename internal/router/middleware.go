@@ -0,0 +1,315 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/metrics"
+	"codesignal/internal/store"
+)
+
+// RequestIDHeader carries the ID of a request. IDs sent by clients or
+// proxies are kept, others are generated, and the ID is returned in the
+// response and forwarded to the other nodes of a cluster.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the IDs accepted from clients.
+const maxRequestIDLength = 128
+
+// accessLog tags requests with their ID and logs them once served. The
+// logger carrying the ID is stored in the request context for handlers,
+// retrieved with zerolog.Ctx, which add the route and key of the request to
+// it. Requests taking slowThreshold or longer are logged at warn level,
+// zero disables it.
+func accessLog(log zerolog.Logger, slowThreshold time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+			r.Header.Set(RequestIDHeader, id)
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		logger := log.With().Str("request_id", id).Logger()
+		r = r.WithContext(logger.WithContext(r.Context()))
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		latency := time.Since(start)
+		// WebSocket connections last as long as the client keeps them open.
+		event, msg := zerolog.Ctx(r.Context()).Info(), "request"
+		if slowThreshold > 0 && latency >= slowThreshold && rec.status != http.StatusSwitchingProtocols {
+			event, msg = zerolog.Ctx(r.Context()).Warn().Dur("threshold", slowThreshold), "slow request"
+		}
+		event.
+			Str("method", r.Method).
+			Str("path", r.URL.Path).
+			Int("status", rec.status).
+			Dur("latency", latency).
+			Int64("size", rec.size).
+			Str("remote_addr", r.RemoteAddr).
+			Msg(msg)
+	})
+}
+
+// recoverPanic serves a 500 to the requests whose handler panicked, logging
+// the panic with its stack and the request context, instead of leaving the
+// client with a dropped connection. A panic after the response started
+// can't be answered anymore: the response is aborted, as net/http does.
+func recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			metrics.PanicsRecovered.Add(1)
+			zerolog.Ctx(r.Context()).Error().
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Interface("panic", v).
+				Bytes("stack", debug.Stack()).
+				Msg("handler panicked")
+			if rec.wroteHeader {
+				panic(http.ErrAbortHandler)
+			}
+			writeJSON(rec, r, http.StatusInternalServerError, store.Response{Message: "internal server error", StatusCode: store.StatusStorageError})
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// withRoute adds the route pattern, and the key for key routes, to the
+// request logger.
+func withRoute(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+		zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			c = c.Str("route", route)
+			if key != "" {
+				c = c.Str("key", key)
+			}
+			return c
+		})
+		next.ServeHTTP(w, r)
+	})
+}
+
+// v1Prefix prefixes the paths of the key routes.
+const v1Prefix = "/v1"
+
+// legacyRoutes are the routes preceding versioning, also served without
+// v1Prefix as deprecated aliases. Routes added since have no alias.
+var legacyRoutes = map[string]bool{
+	"/key":                true,
+	"/key/:key":           true,
+	"/key/:key/increment": true,
+	"/key/:key/undelete":  true,
+	"/key/:key/dump":      true,
+	"/key/:key/restore":   true,
+	"/key/b64/:encoded":   true,
+	"/keys":               true,
+}
+
+// deprecatedAlias serves an unversioned alias of a /v1 route, warning
+// clients of the route replacing it.
+func deprecatedAlias(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metrics.DeprecatedRequests.Add(1)
+		w.Header().Add("Warning", fmt.Sprintf(`299 - "deprecated route, use %s%s"`, v1Prefix, r.URL.EscapedPath()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// base64KeyPrefix prefixes the paths of the key routes addressing keys
+// encoded in base64url, after the version prefix.
+const base64KeyPrefix = "/key/b64/"
+
+// isBase64KeyPath reports whether path is the path of a base64 encoded key
+// route, or of its unversioned alias.
+func isBase64KeyPath(path string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(path, v1Prefix), base64KeyPrefix)
+}
+
+// base64Key serves the key routes of base64 encoded keys, decoding the
+// encoded parameter into the key parameter read by the store handlers.
+func base64Key(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := store.DecodeKey(httprouter.ParamsFromContext(r.Context()).ByName("encoded"))
+		if err != nil {
+			writeJSON(w, r, http.StatusBadRequest, store.Response{Message: "invalid key: expected base64url", StatusCode: store.StatusInvalidKey})
+			return
+		}
+		zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("key", key)
+		})
+		params := httprouter.Params{{Key: "key", Value: key}}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, params)))
+	})
+}
+
+// apiVersion serves the routes of an API version, answered by the store
+// handlers with the envelope of the version.
+func apiVersion(version int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(store.NewVersionContext(r.Context(), version)))
+	})
+}
+
+// requireJSON rejects with a 415 the request bodies not sent as JSON,
+// application/json or a +json type, rather than decoding any payload as
+// JSON. Empty bodies are accepted when optional.
+func requireJSON(optional bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if optional && r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		contentType := r.Header.Get("Content-Type")
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			writeJSON(w, r, http.StatusUnsupportedMediaType, store.Response{
+				Message:    fmt.Sprintf("unsupported content type %q, expected application/json", contentType),
+				StatusCode: store.StatusUnsupportedMedia,
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate serves the requests of a route with a scope once their
+// client is authenticated and allowed the scope, storing its principal in
+// the request context for the store operations to check the permissions on
+// keys. Routes are public without authenticator or scope.
+func authenticate(authn auth.Authenticator, scope string, next http.Handler) http.Handler {
+	if authn == nil || scope == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := authn.Authenticate(r)
+		if err != nil {
+			zerolog.Ctx(r.Context()).Debug().Err(err).Msg("authentication failed")
+			w.Header().Set("WWW-Authenticate", authn.Challenge())
+			writeJSON(w, r, http.StatusUnauthorized, store.Response{Message: err.Error(), StatusCode: store.StatusUnauthorized})
+			return
+		}
+
+		zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("subject", principal.Subject)
+		})
+		if !principal.Allows(scope) {
+			writeJSON(w, r, http.StatusForbidden, store.Response{Message: "forbidden: missing scope " + scope, StatusCode: store.StatusForbidden})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), principal)))
+	})
+}
+
+// limitBody cuts off the request bodies larger than maxSize, so a client
+// streaming a huge body is rejected once maxSize bytes were read rather
+// than after the decoder buffered the whole body.
+func limitBody(maxSize int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, code int, resp store.Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("error writing response")
+	}
+}
+
+// validRequestID reports whether a client supplied ID is safe to log: short
+// and made of printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [12]byte
+	// crypto/rand doesn't fail on supported platforms.
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// responseRecorder records the status and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = code, true
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher for streaming handlers.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker for the WebSocket upgrade. Hijacked
+// connections are logged with status 101.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	r.status, r.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+// Unwrap returns the underlying writer for http.ResponseController.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
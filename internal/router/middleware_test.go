@@ -0,0 +1,352 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/config"
+	"codesignal/internal/ipfilter"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func TestAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.New(&logs), repo, &config.Config{}, Opts{})
+
+	entries := func() []map[string]any {
+		var entries []map[string]any
+		scanner := bufio.NewScanner(&logs)
+		for scanner.Scan() {
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	t.Run("generates request ids", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(`{"key":"a","value":"1"}`)))
+		require.Equal(t, http.StatusCreated, rec.Code)
+		id := rec.Header().Get(RequestIDHeader)
+		assert.Len(t, id, 24)
+
+		logged := entries()
+		require.Len(t, logged, 1)
+		assert.Equal(t, "request", logged[0]["message"])
+		assert.Equal(t, id, logged[0]["request_id"])
+		assert.Equal(t, "POST", logged[0]["method"])
+		assert.Equal(t, "/key", logged[0]["path"])
+		assert.Equal(t, float64(http.StatusCreated), logged[0]["status"])
+		assert.Equal(t, float64(rec.Body.Len()), logged[0]["size"])
+		assert.Contains(t, logged[0], "latency")
+		assert.Equal(t, "/key", logged[0]["route"])
+		assert.Equal(t, "a", logged[0]["key"])
+	})
+
+	t.Run("propagates request ids to handler logs", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/key", strings.NewReader("{"))
+		req.Header.Set(RequestIDHeader, "client-id-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "client-id-1", rec.Header().Get(RequestIDHeader))
+
+		logged := entries()
+		require.Len(t, logged, 2)
+		assert.Equal(t, "failed to decode request body", logged[0]["message"])
+		for _, entry := range logged {
+			assert.Equal(t, "client-id-1", entry["request_id"])
+		}
+	})
+
+	t.Run("replaces invalid request ids", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/key/missing", nil)
+		req.Header.Set(RequestIDHeader, "bad\nid")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Len(t, rec.Header().Get(RequestIDHeader), 24)
+
+		logged := entries()
+		require.Len(t, logged, 1)
+		assert.Equal(t, "/key/:key", logged[0]["route"])
+		assert.Equal(t, "missing", logged[0]["key"])
+	})
+}
+
+func TestSlowRequestLog(t *testing.T) {
+	var logs bytes.Buffer
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.New(&logs), repo, &config.Config{SlowRequestThreshold: time.Nanosecond}, Opts{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/key/missing", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "slow request", entry["message"])
+	assert.Equal(t, "/key/:key", entry["route"])
+	assert.Equal(t, "missing", entry["key"])
+	assert.Contains(t, entry, "threshold")
+	assert.Contains(t, entry, "latency")
+}
+
+func TestAuthentication(t *testing.T) {
+	authn, err := auth.NewJWT(auth.JWTConfig{Secret: "secret"})
+	require.NoError(t, err)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.Nop(), repo, &config.Config{}, Opts{Auth: authn})
+
+	token := func(scopes string) string {
+		s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub": "alice", "scope": scopes, "exp": time.Now().Add(time.Hour).Unix(),
+		}).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return s
+	}
+	serve := func(method, path, token, body string) (*httptest.ResponseRecorder, store.Response) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp store.Response
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec, resp
+	}
+
+	t.Run("public routes", func(t *testing.T) {
+		rec, _ := serve(http.MethodGet, "/healthz", "", "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("missing credentials", func(t *testing.T) {
+		rec, resp := serve(http.MethodGet, "/key/a", "", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, store.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, authn.Challenge(), rec.Header().Get("WWW-Authenticate"))
+	})
+
+	t.Run("invalid credentials", func(t *testing.T) {
+		rec, resp := serve(http.MethodGet, "/key/a", "not-a-token", "")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, store.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("scopes", func(t *testing.T) {
+		read, write := token(auth.ScopeRead), token(auth.ScopeRead+" "+auth.ScopeWrite)
+
+		rec, resp := serve(http.MethodPost, "/key", read, `{"key":"a","value":"1"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, store.Response{Message: "forbidden: missing scope kv:write", StatusCode: store.StatusForbidden}, resp)
+
+		rec, _ = serve(http.MethodPost, "/key", write, `{"key":"a","value":"1"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		rec, _ = serve(http.MethodGet, "/key/a", read, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec, _ = serve(http.MethodGet, "/metrics", read, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+
+	t.Run("graphql mutations need the write scope", func(t *testing.T) {
+		rec, _ := serve(http.MethodPost, "/graphql", token(auth.ScopeRead), `{"query":"mutation { deleteKey(key: \"a\") }"}`)
+		assert.Contains(t, rec.Body.String(), `forbidden: no write permission on key \"a\"`)
+
+		rec, _ = serve(http.MethodPost, "/graphql", token(auth.ScopeRead), `{"query":"{ key(key: \"a\") { value } }"}`)
+		assert.Contains(t, rec.Body.String(), `"value":"1"`)
+	})
+
+	t.Run("namespaces", func(t *testing.T) {
+		teamA := token("kv:write:team-a. kv:read:shared.")
+
+		rec, _ := serve(http.MethodPost, "/key", teamA, `{"key":"team-a.x","value":"1"}`)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		rec, resp := serve(http.MethodPost, "/key", teamA, `{"key":"team-b.x","value":"1"}`)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		assert.Equal(t, store.Response{Message: `forbidden: no write permission on key "team-b.x"`, StatusCode: store.StatusForbidden}, resp)
+		rec, _ = serve(http.MethodGet, "/key/team-a.x", teamA, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec, _ = serve(http.MethodGet, "/key/a", teamA, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec, _ = serve(http.MethodPost, "/key/team-a.x/increment", teamA, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		rec, _ = serve(http.MethodGet, "/keys?prefix=team-a.", teamA, "")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec, _ = serve(http.MethodGet, "/keys", teamA, "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec, _ = serve(http.MethodGet, "/metrics", token("kv:admin:team-a."), "")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	})
+}
+
+func TestBasicAuthentication(t *testing.T) {
+	authn, err := auth.New(auth.Config{
+		JWT:   auth.JWTConfig{Secret: "secret"},
+		Basic: auth.BasicConfig{Users: []string{"app:pw:kv:read"}},
+	})
+	require.NoError(t, err)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.Nop(), repo, &config.Config{}, Opts{Auth: authn})
+
+	serve := func(method, path, password string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"key":"a","value":"1"}`))
+		req.SetBasicAuth("app", password)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusNotFound, serve(http.MethodGet, "/key/a", "pw").Code)
+	assert.Equal(t, http.StatusForbidden, serve(http.MethodPost, "/key", "pw").Code)
+
+	rec := serve(http.MethodGet, "/key/a", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, authn.Challenge(), rec.Header().Get("WWW-Authenticate"))
+	var resp store.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, store.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestIPFilter(t *testing.T) {
+	filter, err := ipfilter.New(ipfilter.Config{Allow: []string{"192.0.2.0/24"}, TrustedProxies: []string{"10.0.0.1"}})
+	require.NoError(t, err)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.Nop(), repo, &config.Config{}, Opts{IPFilter: filter})
+
+	serve := func(path, remote, forwarded string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = remote
+		if forwarded != "" {
+			req.Header.Set(ipfilter.ForwardedForHeader, forwarded)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/healthz", "192.0.2.1:1234", ""))
+	assert.Equal(t, http.StatusOK, serve("/healthz", "10.0.0.1:1234", "192.0.2.1"))
+	// Clients are filtered before routing.
+	assert.Equal(t, http.StatusForbidden, serve("/missing", "198.51.100.1:1234", ""))
+	assert.Equal(t, http.StatusForbidden, serve("/healthz", "198.51.100.1:1234", "192.0.2.1"))
+}
+
+func TestBodyLimit(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	cfg := &config.Config{MaxKeyLength: 8, MaxValueSize: 16}
+	handler := New(zerolog.Nop(), repo, cfg, Opts{})
+	maxSize := cfg.StoreOpts().MaxBodySize()
+
+	serve := func(body string) (int, store.Response) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(body)))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	// A value of escaped characters fits.
+	code, _ := serve(`{"key":"a","value":"` + strings.Repeat(`<`, 16) + `"}`)
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, resp := serve(`{"key":"b","value":"` + strings.Repeat("v", int(maxSize)) + `"}`)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, store.StatusValueTooLarge, resp.StatusCode)
+	assert.Contains(t, resp.Message, "request body too large")
+}
+
+func TestRecoverPanic(t *testing.T) {
+	var logs bytes.Buffer
+	handler := accessLog(zerolog.New(&logs), 0, recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/streaming" {
+			_, _ = w.Write([]byte("partial"))
+		}
+		var m map[string]int
+		m["nil map"]++
+	})))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/key/a", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var resp store.Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, store.Response{Message: "internal server error", StatusCode: store.StatusStorageError}, resp)
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2)
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "handler panicked", entry["message"])
+	assert.Equal(t, "/key/a", entry["path"])
+	assert.Equal(t, rec.Header().Get(RequestIDHeader), entry["request_id"])
+	assert.Contains(t, entry["panic"], "nil map")
+	assert.Contains(t, entry["stack"], "TestRecoverPanic")
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, float64(http.StatusInternalServerError), entry["status"])
+
+	// A started response is aborted.
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/streaming", nil))
+	})
+}
+
+func TestStrictContentType(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	strict := New(zerolog.Nop(), repo, &config.Config{StrictContentType: true}, Opts{})
+	lenient := New(zerolog.Nop(), repo, &config.Config{}, Opts{})
+
+	serve := func(handler http.Handler, path, contentType, body string) (int, store.Response) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := serve(strict, "/key", "text/plain", `{"key":"a","value":"1"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+	assert.Equal(t, store.Response{Message: `unsupported content type "text/plain", expected application/json`, StatusCode: store.StatusUnsupportedMedia}, resp)
+	code, _ = serve(strict, "/key", "", `{"key":"a","value":"1"}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	code, _ = serve(strict, "/key", "application/json; charset=utf-8", `{"key":"a","value":"1"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = serve(strict, "/key", "application/merge-patch+json", `{"key":"b","value":"1"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	// The body of increments is optional.
+	code, _ = serve(strict, "/key/a/increment", "", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = serve(lenient, "/key", "text/plain", `{"key":"c","value":"1"}`)
+	assert.Equal(t, http.StatusCreated, code)
+}
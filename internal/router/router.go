@@ -1,39 +1,369 @@
 // Package router provides the routing configuration for the HTTP server.
 //
-// This package defines a function to instantiate and configure a new HTTP router
-// using the httprouter package. It sets up the necessary endpoints for the key-value
-// store service and binds the HTTP methods to the corresponding handler functions.
+// This package defines a function to instantiate and configure a new HTTP
+// router using the httprouter package. It sets up the necessary endpoints
+// for the key-value store service and binds the HTTP methods to the
+// corresponding handler functions.
 //
-// The New function initializes a new httprouter instance, creates a new store service
-// using the provided logger, and configures the routes for setting, getting, and deleting
-// keys in the key-value store.
+// The New function initializes a new httprouter instance, creates a new
+// store service using the provided logger, and configures the routes for
+// setting, getting, and deleting keys in the key-value store.
 package router
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
 	"github.com/rs/zerolog"
 
+	"codesignal/internal/auth"
+	"codesignal/internal/backup"
+	"codesignal/internal/buildinfo"
+	"codesignal/internal/cdc"
+	"codesignal/internal/cluster"
+	"codesignal/internal/compression"
 	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/graphqlapi"
+	"codesignal/internal/health"
+	"codesignal/internal/hotkeys"
+	"codesignal/internal/ipfilter"
+	"codesignal/internal/loadshed"
+	"codesignal/internal/maintenance"
+	"codesignal/internal/memlimit"
+	"codesignal/internal/metrics"
+	"codesignal/internal/openapi"
+	"codesignal/internal/pubsub"
+	"codesignal/internal/replica"
 	"codesignal/internal/repository"
+	"codesignal/internal/schema"
 	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+	"codesignal/internal/webhook"
+	"codesignal/internal/wsapi"
 )
 
+// Opts holds the optional subsystems the router exposes endpoints for.
+type Opts struct {
+	// Cluster is the Raft node when running in clustered mode.
+	Cluster *cluster.Node
+	// Shards is the coordinator proxy when running in sharding mode.
+	Shards *cluster.Proxy
+	// Gossip is the membership when node discovery is enabled.
+	Gossip *cluster.Membership
+	// Events is the change bus streamed to WebSocket watches.
+	Events *events.Bus
+	// Channels is the broker of the pub/sub channels, nil creates one.
+	Channels *pubsub.Broker
+	// Changes is the durable change log served at /v1/changes, nil
+	// disables the endpoints.
+	Changes *cdc.ChangeLog
+	// HotKeys tracks the accesses of keys listed at /admin/hotkeys.
+	HotKeys *hotkeys.Tracker
+	// Auth authenticates the requests of routes with a scope, nil serves
+	// every route publicly.
+	Auth auth.Authenticator
+	// IPFilter rejects the requests of clients by address before routing
+	// them, nil serves every client.
+	IPFilter *ipfilter.Filter
+	// LoadShed bounds the requests of the key, channel and protocol routes
+	// served at once, nil doesn't limit them.
+	LoadShed *loadshed.Shedder
+	// Maintenance makes the store read-only, toggled at /admin/maintenance.
+	// Nil creates a switch enabled by the READ_ONLY setting.
+	Maintenance *maintenance.Switch
+	// Memory rejects the writes past the soft memory limit, nil never
+	// rejects them.
+	Memory *memlimit.Guard
+	// Hooks extend the operations of the store service on keys.
+	Hooks []store.Hook
+	// Schemas validates the values of the stores against the JSON
+	// Schemas of their prefix, managed at /admin/schemas. Nil disables the
+	// validation and the endpoints.
+	Schemas *schema.Registry
+	// Tenants serves the keys of each tenant from its own store, nil
+	// serves every request from the default store.
+	Tenants *tenant.Registry
+	// Replica reports the snapshot served at /admin/replica, nil disables
+	// the endpoints.
+	Replica *replica.Replica
+	// Reload reloads the configuration at /admin/reload, nil disables the
+	// endpoint.
+	Reload http.Handler
+}
+
 // New instantiates a new http router and
 // configures the endpoints of the service.
-func New(log zerolog.Logger, repo repository.Store, cfg *config.Config) http.Handler {
-	router := httprouter.New()
+func New(log zerolog.Logger, repo repository.Store, cfg *config.Config, opts Opts) http.Handler {
+	api, _ := build(log, repo, cfg, opts, false)
+	return api
+}
+
+// NewSplit is New serving the routes of the kv:admin scope, such as
+// /metrics and /admin/*, on the admin handler instead of the API handler,
+// so they can be served on a listener firewalled from clients. Both
+// handlers serve an OpenAPI document of their routes.
+func NewSplit(log zerolog.Logger, repo repository.Store, cfg *config.Config, opts Opts) (api, admin http.Handler) {
+	return build(log, repo, cfg, opts, true)
+}
+
+// build registers the routes with their documentation, served as an
+// OpenAPI document at /openapi.json and, with DOCS_UI, browsed with Swagger
+// UI at /docs. Requests to the key, channel and protocol routes over the
+// load shedding limits are rejected before being authenticated, and with
+// tenants these routes are served from the store of the tenant of each
+// request. With split, the routes of the kv:admin scope are registered on
+// the admin handler.
+func build(log zerolog.Logger, repo repository.Store, cfg *config.Config, opts Opts, split bool) (http.Handler, http.Handler) {
+	router, adminRouter := newHTTPRouter(), newHTTPRouter()
+	// The routes of base64 encoded keys conflict with /key/:key in a
+	// single tree.
+	base64Router := newHTTPRouter()
+
+	storeOpts := cfg.StoreOpts()
+	storeOpts.HotKeys = opts.HotKeys
+	storeOpts.Maintenance = opts.Maintenance
+	storeOpts.Memory = opts.Memory
+	storeOpts.Hooks = opts.Hooks
+	if opts.Schemas != nil {
+		// The schemas of the default store validate the keys of tenants
+		// as well.
+		storeOpts.Hooks = append(slices.Clone(opts.Hooks), opts.Schemas)
+	}
+	// The scheduler of the process deletes the keys of the default store
+	// at their delete_at time, not those of tenants.
+	storeOpts.ScheduleDeletes = true
+	if storeOpts.Maintenance == nil {
+		storeOpts.Maintenance = maintenance.New(cfg.ReadOnly)
+	}
+	storeService := store.NewService(log, repo, storeOpts)
+	channels := opts.Channels
+	if channels == nil {
+		channels = pubsub.NewBroker(events.DefaultBufferSize)
+	}
+	maxBodySize := storeOpts.MaxBodySize()
+	newAPI := func(service *store.Service, bus *events.Bus, channels *pubsub.Broker) *keyAPI {
+		return &keyAPI{
+			service:   service,
+			graphql:   graphqlapi.NewHandler(log, service),
+			websocket: wsapi.NewHandler(log, service, bus, channels),
+			channels:  pubsub.NewHandler(log, channels),
+		}
+	}
+	apis := &keyAPIs{
+		tenants: opts.Tenants,
+		def:     newAPI(storeService, opts.Events, channels),
+		apis:    make(map[string]*keyAPI),
+		// The keys of tenants aren't tracked with those of the default
+		// store.
+		build: func(t *tenant.Tenant) *keyAPI {
+			tenantOpts := storeOpts
+			tenantOpts.HotKeys = nil
+			tenantOpts.ScheduleDeletes = false
+			if size := opts.Tenants.MaxValueSize(t.Name); size > 0 {
+				tenantOpts.MaxValueSize = size
+			}
+			tenantLog := log.With().Str("tenant", t.Name).Logger()
+			return newAPI(store.NewService(tenantLog, t.Store, tenantOpts), t.Events, t.Channels)
+		},
+	}
+	key := apis.method
+
+	// Routes are registered with their documentation, the OpenAPI document
+	// describes the routes served in the configured mode.
+	var documented, adminDocumented []openapi.Route
+	handle := func(method, path string, handler http.Handler) {
+		op, ok := lookupOperation(method, path)
+		if !ok {
+			panic(fmt.Sprintf("router: route %s %s is missing from the operations", method, path))
+		}
+		handler = authenticate(opts.Auth, op.Scope, handler)
+		if op.Tag == "keys" || op.Tag == "channels" {
+			handler = limitBody(maxBodySize, handler)
+		}
+		if op.Request != nil && cfg.StrictContentType {
+			handler = requireJSON(op.OptionalRequest, handler)
+		}
+		if shed(op.Tag) {
+			handler = opts.LoadShed.Limit(op.ID, !longLived[op.ID], handler)
+		}
+		if split && op.Scope == auth.ScopeAdmin {
+			adminDocumented = append(adminDocumented, op)
+			adminRouter.Handler(method, path, withRoute(path, handler))
+			return
+		}
+		documented = append(documented, op)
+		register := func(path string, handler http.Handler) {
+			if isBase64KeyPath(path) {
+				base64Router.Handler(method, path, withRoute(path, handler))
+				return
+			}
+			router.Handler(method, path, withRoute(path, handler))
+		}
+		register(path, handler)
+		// The routes preceding versioning are still served, undocumented.
+		if legacy, ok := strings.CutPrefix(path, v1Prefix); ok && legacyRoutes[legacy] {
+			register(legacy, deprecatedAlias(handler))
+		}
+	}
+
+	handle(http.MethodPost, "/v1/key", key((*store.Service).SetKey))
+	handle(http.MethodGet, "/v1/key/:key", key((*store.Service).GetKey))
+	handle(http.MethodDelete, "/v1/key/:key", key((*store.Service).DeleteKey))
+	handle(http.MethodPost, "/v1/key/:key/increment", key((*store.Service).IncrementKey))
+	handle(http.MethodPost, "/v1/key/:key/undelete", key((*store.Service).UndeleteKey))
+	handle(http.MethodGet, "/v1/key/:key/dump", key((*store.Service).DumpKey))
+	handle(http.MethodPost, "/v1/key/:key/restore", key((*store.Service).RestoreKey))
+	handle(http.MethodGet, "/v1/key/:key/value", key((*store.Service).GetValue))
+	handle(http.MethodPut, "/v1/key/:key/value", key((*store.Service).PutValue))
+	handle(http.MethodGet, v1Prefix+base64KeyPrefix+":encoded", base64Key(key((*store.Service).GetKey)))
+	handle(http.MethodDelete, v1Prefix+base64KeyPrefix+":encoded", base64Key(key((*store.Service).DeleteKey)))
+	handle(http.MethodGet, "/v1/key/:key/members", key((*store.Service).MembersKey))
+	handle(http.MethodGet, "/v1/key/:key/members/:member", key((*store.Service).IsMemberKey))
+	handle(http.MethodPost, "/v1/key/:key/members/add", key((*store.Service).AddMembersKey))
+	handle(http.MethodPost, "/v1/key/:key/members/remove", key((*store.Service).RemoveMembersKey))
+	handle(http.MethodGet, "/v1/key/:key/path", key((*store.Service).GetPath))
+	handle(http.MethodPut, "/v1/key/:key/path", key((*store.Service).PutPath))
+	handle(http.MethodGet, "/v1/hash/:key", key((*store.Service).GetHash))
+	handle(http.MethodGet, "/v1/hash/:key/:field", key((*store.Service).GetField))
+	handle(http.MethodPut, "/v1/hash/:key/:field", key((*store.Service).PutField))
+	handle(http.MethodDelete, "/v1/hash/:key/:field", key((*store.Service).DeleteFieldKey))
+	handle(http.MethodPost, "/v1/zset/:key/add", key((*store.Service).AddScoresKey))
+	handle(http.MethodGet, "/v1/zset/:key/range", key((*store.Service).RangeKey))
+	handle(http.MethodGet, "/v1/zset/:key/rangebyscore", key((*store.Service).RangeByScoreKey))
+	handle(http.MethodGet, "/v1/counter/:key", key((*store.Service).GetCounter))
+	handle(http.MethodPost, "/v1/counter/:key", key((*store.Service).UpdateCounter))
+	handle(http.MethodPost, "/v1/counter/:key/reset", key((*store.Service).ResetCounterKey))
+	handle(http.MethodGet, "/v1/sets/union", key((*store.Service).UnionKeys))
+	handle(http.MethodGet, "/v1/sets/intersection", key((*store.Service).IntersectKeys))
+	handle(http.MethodGet, "/v1/keys", key((*store.Service).ListKeys))
+	handle(http.MethodPost, "/v2/key", apiVersion(2, key((*store.Service).SetKey)))
+	handle(http.MethodGet, "/v2/key/:key", apiVersion(2, key((*store.Service).GetKey)))
+	handle(http.MethodDelete, "/v2/key/:key", apiVersion(2, key((*store.Service).DeleteKey)))
+	handle(http.MethodPost, "/graphql", apis.serve((*keyAPI).serveGraphQL))
+	handle(http.MethodGet, "/ws", apis.serve((*keyAPI).serveWebSocket))
+	handle(http.MethodPost, "/v1/channels/:channel/publish", apis.serve((*keyAPI).publish))
+	handle(http.MethodGet, "/v1/channels/:channel/subscribe", apis.serve((*keyAPI).subscribe))
+	if opts.Changes != nil {
+		changeHandler := cdc.NewHandler(log, opts.Changes)
+		handle(http.MethodGet, "/v1/changes", http.HandlerFunc(changeHandler.Changes))
+		handle(http.MethodGet, "/v1/changes/cursors", http.HandlerFunc(changeHandler.Cursors))
+		handle(http.MethodPut, "/v1/changes/cursors/:consumer", http.HandlerFunc(changeHandler.Commit))
+		handle(http.MethodDelete, "/v1/changes/cursors/:consumer", http.HandlerFunc(changeHandler.DeleteCursor))
+	}
+
+	handle(http.MethodGet, "/metrics", metrics.Handler())
+	handle(http.MethodGet, "/admin/hotkeys", http.HandlerFunc(storeService.HotKeys))
+	handle(http.MethodGet, "/admin/maintenance", http.HandlerFunc(storeService.Maintenance))
+	handle(http.MethodPut, "/admin/maintenance", http.HandlerFunc(storeService.SetMaintenance))
+	handle(http.MethodPost, "/admin/expire", key((*store.Service).ExpireKeys))
+	backupHandler := backup.NewHandler(log, repo)
+	handle(http.MethodPost, "/admin/backup", http.HandlerFunc(backupHandler.Backup))
+	handle(http.MethodPost, "/admin/restore", http.HandlerFunc(backupHandler.Restore))
+	webhookHandler := webhook.NewHandler(log, repo)
+	handle(http.MethodGet, "/admin/webhooks", http.HandlerFunc(webhookHandler.List))
+	handle(http.MethodPost, "/admin/webhooks", http.HandlerFunc(webhookHandler.Register))
+	handle(http.MethodDelete, "/admin/webhooks/:id", http.HandlerFunc(webhookHandler.Unregister))
+	if opts.Schemas != nil {
+		schemaHandler := schema.NewHandler(opts.Schemas)
+		handle(http.MethodGet, "/admin/schemas", http.HandlerFunc(schemaHandler.List))
+		handle(http.MethodPut, "/admin/schemas", http.HandlerFunc(schemaHandler.Register))
+		handle(http.MethodDelete, "/admin/schemas", http.HandlerFunc(schemaHandler.Unregister))
+	}
+	if opts.Tenants != nil {
+		tenantHandler := tenant.NewHandler(opts.Tenants)
+		handle(http.MethodGet, "/admin/tenants", http.HandlerFunc(tenantHandler.Usage))
+	}
+	if opts.Replica != nil {
+		replicaHandler := replica.NewHandler(opts.Replica)
+		handle(http.MethodGet, "/admin/replica", http.HandlerFunc(replicaHandler.Status))
+		handle(http.MethodPost, "/admin/replica/refresh", http.HandlerFunc(replicaHandler.Refresh))
+	}
+	handle(http.MethodGet, "/admin/cluster", cluster.NewStatusHandler(log, cluster.StatusOpts{
+		Node:       opts.Cluster,
+		Proxy:      opts.Shards,
+		Membership: opts.Gossip,
+	}))
 
-	storeService := store.NewService(log, repo, store.Opts{
-		MaxKeyLength: cfg.GetMaxKeyLength(),
-		MaxValueSize: cfg.GetMaxValueSize(),
+	if opts.Reload != nil {
+		handle(http.MethodPost, "/admin/reload", opts.Reload)
+	}
+
+	// The repository is checked by the Raft node in clustered mode.
+	var checks []health.Check
+	if opts.Cluster != nil {
+		checks = append(checks, health.Check{Name: "raft", Checker: opts.Cluster})
+	} else if checker, ok := repo.(health.Checker); ok {
+		checks = append(checks, health.Check{Name: "repository", Checker: checker})
+	}
+	if opts.Shards != nil {
+		checks = append(checks, health.Check{Name: "shards", Checker: opts.Shards})
+	}
+	handle(http.MethodGet, "/healthz", health.LivenessHandler(log))
+	handle(http.MethodGet, "/readyz", health.ReadinessHandler(log, checks...))
+	handle(http.MethodGet, "/version", buildinfo.Handler(log))
+
+	for _, route := range opts.LoadShed.Routes() {
+		if op, ok := lookupOperationID(route); !ok || !shed(op.Tag) {
+			log.Warn().Str("route", route).Msg("load shedding limit of a route that isn't shed")
+		}
+	}
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isBase64KeyPath(r.URL.Path) {
+			base64Router.ServeHTTP(w, r)
+			return
+		}
+		router.ServeHTTP(w, r)
 	})
+	if opts.Cluster != nil {
+		clusterHandler := cluster.NewHandler(log, opts.Cluster)
+		handle(http.MethodPost, "/admin/cluster/join", http.HandlerFunc(clusterHandler.Join))
+		handle(http.MethodPost, "/admin/cluster/leave", http.HandlerFunc(clusterHandler.Leave))
+
+		handler = opts.Cluster.ForwardToLeader(handler)
+	}
+	if opts.Shards != nil {
+		handler = opts.Shards.Middleware(handler)
+	}
+
+	var spec http.Handler
+	handle(http.MethodGet, "/openapi.json", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		spec.ServeHTTP(w, r)
+	}))
+	spec = openapi.Handler(openapi.New(apiInfo, documented))
+	if cfg.DocsUI {
+		router.Handler(http.MethodGet, "/docs", openapi.UIHandler(apiInfo.Title, "/openapi.json"))
+	}
 
-	router.HandlerFunc(http.MethodPost, "/key", storeService.SetKey)
-	router.HandlerFunc(http.MethodGet, "/key/:key", storeService.GetKey)
-	router.HandlerFunc(http.MethodDelete, "/key/:key", storeService.DeleteKey)
+	api := accessLog(log, cfg.SlowRequestThreshold, recoverPanic(opts.IPFilter.Middleware(cors.Default().Handler(compression.Middleware(cfg.Compression, handler)))))
+	if !split {
+		return api, nil
+	}
 
-	return cors.Default().Handler(router)
+	// Admin routes aren't forwarded to the leader in clustered mode: the
+	// leader is reached at its API address.
+	adminRouter.Handler(http.MethodGet, "/openapi.json", withRoute("/openapi.json", openapi.Handler(openapi.New(apiInfo, adminDocumented))))
+	return api, accessLog(log, cfg.SlowRequestThreshold, recoverPanic(opts.IPFilter.Middleware(compression.Middleware(cfg.Compression, adminRouter))))
+}
+
+// newHTTPRouter returns a router answering the requests matching no route,
+// or none with their method, with the JSON envelope of the API.
+func newHTTPRouter() *httprouter.Router {
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusNotFound, store.Response{Message: "route not found", StatusCode: store.StatusRouteNotFound})
+	})
+	// The Allow header is set by the router.
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusMethodNotAllowed, store.Response{
+			Message:    fmt.Sprintf("method %s not allowed, expected %s", r.Method, w.Header().Get("Allow")),
+			StatusCode: store.StatusMethodNotAllowed,
+		})
+	})
+	return router
 }
@@ -0,0 +1,757 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"codesignal/internal/config"
+	"codesignal/internal/maintenance"
+	"codesignal/internal/openapi"
+	"codesignal/internal/replica"
+	"codesignal/internal/repository"
+	"codesignal/internal/schema"
+	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+)
+
+func newRouter(t *testing.T, cfg *config.Config) http.Handler {
+	t.Helper()
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	return New(zerolog.Nop(), repo, cfg, Opts{})
+}
+
+// pathParam matches the parameters of router paths, such as :key.
+var pathParam = regexp.MustCompile(`:(\w+)`)
+
+func TestOpenAPI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newRouter(t, &config.Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var doc openapi.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+
+	// Every documented operation is served, the join and leave of Raft
+	// clustered mode, the configuration reloads of the server, the usage
+	// of the tenants, the schemas and the change log aside.
+	var want []string
+	for _, op := range operations {
+		if strings.HasPrefix(op.Path, "/admin/cluster/") || op.Path == "/admin/reload" || op.Path == "/admin/tenants" ||
+			strings.HasPrefix(op.Path, "/admin/replica") || op.Path == "/admin/schemas" || op.Tag == "changes" {
+			continue
+		}
+		want = append(want, op.Method+" "+pathParam.ReplaceAllString(op.Path, "{$1}"))
+	}
+	sort.Strings(want)
+	assert.Equal(t, want, doc.Operations())
+
+	getKey := doc.Paths["/v1/key/{key}"]["get"]
+	require.NotNil(t, getKey)
+	assert.Equal(t, "getKey", getKey.OperationID)
+	assert.Contains(t, doc.Components.Schemas, "Response")
+	assert.Contains(t, doc.Components.Schemas["Response"].Properties, "status_code")
+}
+
+// TestOpenAPIFile checks that openapi.yaml, the hand written reference with
+// examples, documents the operations of the router.
+func TestOpenAPIFile(t *testing.T) {
+	b, err := os.ReadFile("../../openapi.yaml")
+	require.NoError(t, err)
+	var file struct {
+		Paths map[string]map[string]any `yaml:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(b, &file))
+
+	var documented []string
+	for path, item := range file.Paths {
+		for method := range item {
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(documented)
+
+	assert.Equal(t, openapi.New(apiInfo, operations).Operations(), documented)
+}
+
+func TestDocsUI(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newRouter(t, &config.Config{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	newRouter(t, &config.Config{DocsUI: true}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "swagger-ui")
+}
+
+func TestNewSplit(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	api, admin := NewSplit(zerolog.Nop(), repo, &config.Config{}, Opts{})
+
+	serve := func(h http.Handler, method, path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusNotFound, serve(api, http.MethodGet, "/metrics"))
+	assert.Equal(t, http.StatusNotFound, serve(api, http.MethodGet, "/admin/maintenance"))
+	assert.Equal(t, http.StatusNotFound, serve(api, http.MethodGet, "/admin/hotkeys"))
+	assert.Equal(t, http.StatusOK, serve(api, http.MethodGet, "/healthz"))
+	assert.Equal(t, http.StatusOK, serve(api, http.MethodGet, "/keys"))
+
+	assert.Equal(t, http.StatusOK, serve(admin, http.MethodGet, "/metrics"))
+	assert.Equal(t, http.StatusOK, serve(admin, http.MethodGet, "/admin/maintenance"))
+	assert.Equal(t, http.StatusNotFound, serve(admin, http.MethodGet, "/keys"))
+	assert.Equal(t, http.StatusNotFound, serve(admin, http.MethodGet, "/healthz"))
+
+	// Each handler documents its own routes.
+	for _, tt := range []struct {
+		handler   http.Handler
+		want, not string
+	}{
+		{handler: api, want: "/v1/key", not: "/metrics"},
+		{handler: admin, want: "/metrics", not: "/v1/key"},
+	} {
+		rec := httptest.NewRecorder()
+		tt.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		var doc openapi.Document
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		assert.Contains(t, doc.Paths, tt.want)
+		assert.NotContains(t, doc.Paths, tt.not)
+	}
+}
+
+func TestBase64Keys(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.Response) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, _ := serve(http.MethodPost, "/key", `{"key":"users/1","value":"alice"}`)
+	require.Equal(t, http.StatusCreated, code)
+	// The slash splits the path of the key routes.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/key/users%2F1", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	for _, encoded := range []string{"dXNlcnMvMQ", "dXNlcnMvMQ=="} {
+		code, resp := serve(http.MethodGet, "/key/b64/"+encoded, "")
+		assert.Equal(t, http.StatusOK, code)
+		require.NotNil(t, resp.Data)
+		assert.Equal(t, "users/1", resp.Data.Key)
+		assert.Equal(t, "alice", resp.Data.Value)
+	}
+
+	code, resp := serve(http.MethodGet, "/key/b64/not%20base64!", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidKey, resp.StatusCode)
+
+	code, _ = serve(http.MethodDelete, "/key/b64/dXNlcnMvMQ", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(http.MethodGet, "/key/b64/dXNlcnMvMQ", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Other key routes are still served.
+	code, _ = serve(http.MethodPost, "/key", `{"key":"b64","value":"1"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, _ = serve(http.MethodGet, "/key/b64", "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestNotFound(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path string) (*httptest.ResponseRecorder, store.Response) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec, resp
+	}
+
+	rec, resp := serve(http.MethodGet, "/missing")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, store.Response{Message: "route not found", StatusCode: store.StatusRouteNotFound}, resp)
+
+	rec, resp = serve(http.MethodPut, "/key/a")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "DELETE, GET, OPTIONS", rec.Header().Get("Allow"))
+	assert.Equal(t, store.Response{Message: "method PUT not allowed, expected DELETE, GET, OPTIONS", StatusCode: store.StatusMethodNotAllowed}, resp)
+
+	rec, _ = serve(http.MethodPost, "/key/b64/YQ")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestV2Envelope(t *testing.T) {
+	handler := newRouter(t, &config.Config{MaxKeyLength: 8})
+	serve := func(method, path, body string) (int, store.ResponseV2, map[string]any) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.ResponseV2
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		var raw map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &raw))
+		return rec.Code, resp, raw
+	}
+
+	code, resp, _ := serve(http.MethodPost, "/v2/key", `{"key":"too-long-key","value":"v"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusKeyTooLong, resp.StatusCode)
+	assert.Equal(t, &store.ErrorDetail{Field: "key", Limit: 8, Actual: 12}, resp.Error)
+
+	code, resp, _ = serve(http.MethodPost, "/v2/key", `{"key":"a","value":"v","expires":"1h"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	require.NotNil(t, resp.Error)
+	assert.Equal(t, "expires", resp.Error.Field)
+
+	code, resp, _ = serve(http.MethodPost, "/v2/key", `{"key":"a","value":"v"}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Nil(t, resp.Error)
+
+	code, resp, _ = serve(http.MethodGet, "/v2/key/a", "")
+	assert.Equal(t, http.StatusOK, code)
+	require.NotNil(t, resp.Data)
+	assert.Equal(t, "v", resp.Data.Value)
+
+	code, resp, _ = serve(http.MethodDelete, "/v2/key/a", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, resp, _ = serve(http.MethodGet, "/v2/key/a", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Nil(t, resp.Error)
+
+	// The unversioned routes keep the original envelope.
+	code, _, raw := serve(http.MethodPost, "/key", `{"key":"too-long-key","value":"v"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.NotContains(t, raw, "error")
+}
+
+func TestLegacyRoutes(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/v1/key", `{"key":"a","value":"1"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	assert.Empty(t, rec.Header().Get("Warning"))
+
+	// The routes preceding versioning serve the same keys with a warning.
+	rec = serve(http.MethodGet, "/key/a", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `299 - "deprecated route, use /v1/key/a"`, rec.Header().Get("Warning"))
+	rec = serve(http.MethodGet, "/key/b64/YQ", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `299 - "deprecated route, use /v1/key/b64/YQ"`, rec.Header().Get("Warning"))
+
+	rec = serve(http.MethodGet, "/v1/key/b64/YQ", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Warning"))
+	rec = serve(http.MethodGet, "/v1/keys", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Routes other than the key routes have no alias.
+	rec = serve(http.MethodGet, "/v1/healthz", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestSets(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.MembersResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.MembersResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := serve(http.MethodPost, "/v1/key/colors/members/add", `{"members":["red","blue"]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"blue", "red"}, resp.Data)
+	code, _ = serve(http.MethodPost, "/v1/key/warm/members/add", `{"members":["red","orange"]}`)
+	require.Equal(t, http.StatusOK, code)
+
+	code, resp = serve(http.MethodGet, "/v1/key/colors/members", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"blue", "red"}, resp.Data)
+
+	code, _ = serve(http.MethodGet, "/v1/key/colors/members/red", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, resp = serve(http.MethodGet, "/v1/key/colors/members/green", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusMemberNotFound, resp.StatusCode)
+	code, resp = serve(http.MethodGet, "/v1/key/missing/members/red", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusKeyNotFound, resp.StatusCode)
+
+	code, resp = serve(http.MethodGet, "/v1/sets/union?key=colors&key=warm", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"blue", "orange", "red"}, resp.Data)
+	code, resp = serve(http.MethodGet, "/v1/sets/intersection?key=colors&key=warm", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"red"}, resp.Data)
+	code, _ = serve(http.MethodGet, "/v1/sets/union", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, resp = serve(http.MethodPost, "/v1/key/colors/members/remove", `{"members":["red","green"]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"red"}, resp.Data)
+
+	// Values other than sets are rejected.
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"plain","value":"text"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, resp = serve(http.MethodPost, "/v1/key/plain/members/add", `{"members":["a"]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+
+	// The routes added since versioning have no unversioned alias.
+	code, _ = serve(http.MethodGet, "/key/colors/members", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHashes(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.HashResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.HashResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, _ := serve(http.MethodPut, "/v1/hash/user/name", `{"value":"Alice"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, _ = serve(http.MethodPut, "/v1/hash/user/name", `{"value":"Bob"}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(http.MethodPut, "/v1/hash/user/email", `{"value":"bob@example.com"}`)
+	require.Equal(t, http.StatusCreated, code)
+
+	code, resp := serve(http.MethodGet, "/v1/hash/user", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"name": "Bob", "email": "bob@example.com"}, resp.Data)
+
+	code, resp = serve(http.MethodGet, "/v1/hash/user/name", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"name": "Bob"}, resp.Data)
+	code, resp = serve(http.MethodGet, "/v1/hash/user/age", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusFieldNotFound, resp.StatusCode)
+	code, resp = serve(http.MethodGet, "/v1/hash/missing/name", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusKeyNotFound, resp.StatusCode)
+
+	code, _ = serve(http.MethodDelete, "/v1/hash/user/email", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, resp = serve(http.MethodDelete, "/v1/hash/user/email", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusFieldNotFound, resp.StatusCode)
+
+	// Values other than hashes are rejected.
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"plain","value":"text"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, resp = serve(http.MethodPut, "/v1/hash/plain/name", `{"value":"a"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+}
+
+func TestValues(t *testing.T) {
+	handler := newRouter(t, &config.Config{MaxValueSize: 16})
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		return rec
+	}
+	statusCode := func(rec *httptest.ResponseRecorder) store.StatusCode {
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return resp.StatusCode
+	}
+
+	rec := serve(httptest.NewRequest(http.MethodPut, "/v1/key/blob/value?ttl=1h", strings.NewReader("<b>raw value</b>")))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "/v1/key/blob/value", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<b>raw value</b>", rec.Body.String())
+	assert.Equal(t, "16", rec.Header().Get("Content-Length"))
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/key/blob/value", nil)
+	r.Header.Set("Range", "bytes=3-11")
+	rec = serve(r)
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, "raw value", rec.Body.String())
+
+	// Bodies of an unknown size are read until the limit.
+	r = httptest.NewRequest(http.MethodPut, "/v1/key/blob/value", strings.NewReader("chunked"))
+	r.ContentLength = -1
+	rec = serve(r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(httptest.NewRequest(http.MethodGet, "/v1/key/blob/value", nil))
+	assert.Equal(t, "chunked", rec.Body.String())
+
+	r = httptest.NewRequest(http.MethodPut, "/v1/key/blob/value", strings.NewReader(strings.Repeat("x", 17)))
+	r.ContentLength = -1
+	rec = serve(r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+	assert.Equal(t, store.StatusValueTooLarge, statusCode(rec))
+
+	// Bodies are read whole, whatever their announced size.
+	r = httptest.NewRequest(http.MethodPut, "/v1/key/blob/value", strings.NewReader("short"))
+	r.ContentLength = 12
+	rec = serve(r)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Bodies announcing a size over the limit are rejected unread.
+	r = httptest.NewRequest(http.MethodPut, "/v1/key/blob/value", strings.NewReader(""))
+	r.ContentLength = 1 << 30
+	rec = serve(r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	rec = serve(httptest.NewRequest(http.MethodPut, "/v1/key/blob/value?ttl=soon", strings.NewReader("x")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, store.StatusInvalidTTL, statusCode(rec))
+
+	rec = serve(httptest.NewRequest(http.MethodGet, "/v1/key/missing/value", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, store.StatusKeyNotFound, statusCode(rec))
+
+	// Values larger than the buffer allocated up front are read whole.
+	handler = newRouter(t, &config.Config{})
+	large := strings.Repeat("0123456789abcdef", 1<<13)
+	rec = serve(httptest.NewRequest(http.MethodPut, "/v1/key/large/value", strings.NewReader(large)))
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(httptest.NewRequest(http.MethodGet, "/v1/key/large/value", nil))
+	assert.Equal(t, large, rec.Body.String())
+}
+
+func TestCounters(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.CounterResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.CounterResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, resp := serve(http.MethodPost, "/v1/counter/hits", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(1), resp.Data.Value)
+	code, resp = serve(http.MethodPost, "/v1/counter/hits", `{"delta":9223372036854775807,"overflow":"saturate"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(math.MaxInt64), resp.Data.Value)
+	code, resp = serve(http.MethodPost, "/v1/counter/hits", `{"delta":1}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	code, resp = serve(http.MethodPost, "/v1/counter/hits", `{"overflow":"clamp"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+
+	code, resp = serve(http.MethodGet, "/v1/counter/hits", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(math.MaxInt64), resp.Data.Value)
+
+	code, resp = serve(http.MethodPost, "/v1/counter/hits/reset", `{"value":10}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, int64(10), resp.Data.Value)
+	require.NotNil(t, resp.Data.Previous)
+	assert.Equal(t, int64(math.MaxInt64), *resp.Data.Previous)
+
+	code, resp = serve(http.MethodGet, "/v1/counter/missing", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusKeyNotFound, resp.StatusCode)
+
+	// The values of the increment route aren't counters.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/key/views/increment", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	code, resp = serve(http.MethodPost, "/v1/counter/views", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+}
+
+func TestDocuments(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.PathResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.PathResponse
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, _ := serve(http.MethodPut, "/v1/key/user/path", `{"value":{"name":"Alice","address":{"city":"Paris"}}}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = serve(http.MethodPut, "/v1/key/user/path?path=$.address.city", `{"value":"Lyon"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	code, resp := serve(http.MethodGet, "/v1/key/user/path?path=$.address", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"city":"Lyon"}`, string(resp.Data))
+	code, resp = serve(http.MethodGet, "/v1/key/user/path", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"name":"Alice","address":{"city":"Lyon"}}`, string(resp.Data))
+
+	code, resp = serve(http.MethodGet, "/v1/key/user/path?path=$.age", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusPathNotFound, resp.StatusCode)
+	code, resp = serve(http.MethodPut, "/v1/key/user/path?path=$.a.b", `{"value":1}`)
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusPathNotFound, resp.StatusCode)
+	code, resp = serve(http.MethodGet, "/v1/key/user/path?path=name", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	code, resp = serve(http.MethodPut, "/v1/key/user/path?path=$.name", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidJSON, resp.StatusCode)
+
+	// Values other than JSON documents are rejected.
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"plain","value":"text"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, resp = serve(http.MethodGet, "/v1/key/plain/path?path=$.a", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+}
+
+func TestSortedSets(t *testing.T) {
+	handler := newRouter(t, &config.Config{})
+	serve := func(method, path, body string) (int, store.ScoresResponse) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		var resp store.ScoresResponse
+		if method == http.MethodGet {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		}
+		return rec.Code, resp
+	}
+	members := func(resp store.ScoresResponse) []string {
+		var names []string
+		for _, m := range resp.Data {
+			names = append(names, m.Member)
+		}
+		return names
+	}
+
+	code, _ := serve(http.MethodPost, "/v1/zset/board/add", `{"members":[{"member":"a","score":1},{"member":"b","score":2},{"member":"c","score":3},{"member":"d","score":4}]}`)
+	require.Equal(t, http.StatusOK, code)
+
+	code, resp := serve(http.MethodGet, "/v1/zset/board/range", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"a", "b", "c", "d"}, members(resp))
+	_, resp = serve(http.MethodGet, "/v1/zset/board/range?start=1&stop=-2", "")
+	assert.Equal(t, []string{"b", "c"}, members(resp))
+	_, resp = serve(http.MethodGet, "/v1/zset/board/range?start=-2&stop=10", "")
+	assert.Equal(t, []string{"c", "d"}, members(resp))
+	_, resp = serve(http.MethodGet, "/v1/zset/board/range?start=5", "")
+	assert.Empty(t, resp.Data)
+
+	_, resp = serve(http.MethodGet, "/v1/zset/board/rangebyscore?min=2&max=3", "")
+	assert.Equal(t, []string{"b", "c"}, members(resp))
+	_, resp = serve(http.MethodGet, "/v1/zset/board/rangebyscore?min=(2&max=%2Binf", "")
+	assert.Equal(t, []string{"c", "d"}, members(resp))
+	_, resp = serve(http.MethodGet, "/v1/zset/board/rangebyscore?max=(3&limit=1", "")
+	assert.Equal(t, []string{"a"}, members(resp))
+
+	code, resp = serve(http.MethodGet, "/v1/zset/board/rangebyscore?min=low", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	code, resp = serve(http.MethodGet, "/v1/zset/board/range?stop=last", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	code, resp = serve(http.MethodGet, "/v1/zset/missing/range", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusKeyNotFound, resp.StatusCode)
+
+	// Values other than sorted sets are rejected.
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"plain","value":"text"}`)
+	require.Equal(t, http.StatusCreated, code)
+	code, _ = serve(http.MethodPost, "/v1/zset/plain/add", `{"members":[{"member":"a","score":1}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestTenants(t *testing.T) {
+	tenants, err := tenant.New(zerolog.Nop(), tenant.Config{
+		Enabled:       true,
+		Names:         []string{"acme", "globex", "hooli"},
+		Header:        tenant.DefaultHeader,
+		Domain:        "kv.example.com",
+		MaxValueSizes: map[string]int{"globex": 4},
+		RateLimits:    map[string]float64{"hooli": 0.5},
+	}, repository.Opts{})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = tenants.Close(context.Background()) })
+
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.Nop(), repo, &config.Config{}, Opts{Tenants: tenants})
+	serve := func(method, target, name, body string) (int, store.StatusCode) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		if name != "" {
+			r.Header.Set(tenant.DefaultHeader, name)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp.StatusCode
+	}
+
+	code, _ := serve(http.MethodPost, "/v1/key", "acme", `{"key":"a","value":"acme"}`)
+	require.Equal(t, http.StatusCreated, code)
+
+	// The keys of a tenant are only seen by its requests.
+	code, _ = serve(http.MethodGet, "/v1/key/a", "acme", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = serve(http.MethodGet, "/v1/key/a", "globex", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	_, ok, err := repo.Get(context.Background(), "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Tenants are also identified by the subdomain of the host.
+	r := httptest.NewRequest(http.MethodGet, "/v1/key/a", nil)
+	r.Host = "acme.kv.example.com:8080"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Tenants have their own limits.
+	code, status := serve(http.MethodPost, "/v1/key", "globex", `{"key":"a","value":"globex"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusValueTooLarge, status)
+
+	code, status = serve(http.MethodGet, "/v1/key/a", "", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidTenant, status)
+	code, status = serve(http.MethodGet, "/v1/key/a", "Acme!", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidTenant, status)
+	code, status = serve(http.MethodGet, "/v1/key/a", "initech", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, store.StatusTenantNotFound, status)
+
+	// The routes not serving keys don't need a tenant.
+	code, _ = serve(http.MethodGet, "/healthz", "", "")
+	assert.Equal(t, http.StatusOK, code)
+
+	// Tenants have their own rate limits.
+	code, _ = serve(http.MethodGet, "/v1/key/a", "hooli", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	r = httptest.NewRequest(http.MethodGet, "/v1/key/a", nil)
+	r.Header.Set(tenant.DefaultHeader, "hooli")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/tenants", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var usage tenant.UsageResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &usage))
+	require.Len(t, usage.Data, 3)
+	acme := usage.Data[0]
+	assert.Equal(t, "acme", acme.Tenant)
+	assert.Equal(t, int64(3), acme.Requests)
+	assert.Equal(t, int64(len(`{"key":"a","value":"acme"}`)), acme.BytesIn)
+	assert.Positive(t, acme.BytesOut)
+	assert.Equal(t, 1, acme.Keys)
+	assert.Equal(t, int64(len("a")+len("acme")), acme.BytesStored)
+	hooli := usage.Data[2]
+	assert.Equal(t, int64(2), hooli.Requests)
+	assert.Equal(t, int64(1), hooli.Limited)
+}
+
+func TestSchemas(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	handler := New(zerolog.Nop(), repo, &config.Config{}, Opts{Schemas: schema.New(zerolog.Nop(), repo, nil)})
+	serve := func(method, target, body string) (int, store.Response) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var resp store.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec.Code, resp
+	}
+
+	code, _ := serve(http.MethodPut, "/admin/schemas?prefix=config/", `{"type":"object","required":["port"]}`)
+	require.Equal(t, http.StatusOK, code)
+	code, resp := serve(http.MethodPost, "/v1/key", `{"key":"config/api","value":"{\"host\":\"api\"}"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	assert.Contains(t, resp.Message, `$: missing property "port"`)
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"config/api","value":"{\"port\":80}"}`)
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, _ = serve(http.MethodPost, "/v1/key", `{"key":"other","value":"{}"}`)
+	assert.Equal(t, http.StatusCreated, code)
+
+	// Partial updates are validated on the document they produce.
+	code, _ = serve(http.MethodPut, "/admin/schemas?prefix=app-", `{"type":"object","properties":{"port":{"type":"integer"}}}`)
+	require.Equal(t, http.StatusOK, code)
+	code, _ = serve(http.MethodPut, "/v1/key/app-api/path", `{"value":{"port":80}}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, resp = serve(http.MethodPut, "/v1/key/app-api/path?path=$.port", `{"value":"80"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidValue, resp.StatusCode)
+	value, _, err := repo.Get(context.Background(), "app-api")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"port":80}`, string(value))
+}
+
+func TestReplica(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	require.NoError(t, repo.Set(context.Background(), "a", []byte("primary")))
+	file := filepath.Join(t.TempDir(), "store.snap")
+	f, err := os.Create(file)
+	require.NoError(t, err)
+	require.NoError(t, repo.Snapshot(context.Background(), f))
+	require.NoError(t, f.Close())
+
+	replicaRepo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	r := replica.New(zerolog.Nop(), replica.Config{Source: file}, replicaRepo)
+	_, err = r.Refresh(context.Background())
+	require.NoError(t, err)
+	readOnly := maintenance.New(false)
+	readOnly.Pin()
+	handler := New(zerolog.Nop(), replicaRepo, &config.Config{}, Opts{Maintenance: readOnly, Replica: r})
+	serve := func(method, target, body string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	// Reads are served from the snapshot, writes are rejected.
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/v1/key/a", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodPost, "/v1/key", `{"key":"b","value":"replica"}`))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(http.MethodDelete, "/v1/key/a", ""))
+	assert.Equal(t, http.StatusConflict, serve(http.MethodPut, "/admin/maintenance", `{"enabled":false}`))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "/admin/replica", ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "/admin/replica/refresh", ""))
+}
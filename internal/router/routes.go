@@ -0,0 +1,932 @@
+package router
+
+import (
+	"net/http"
+	"strings"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/backup"
+	"codesignal/internal/buildinfo"
+	"codesignal/internal/cdc"
+	"codesignal/internal/cluster"
+	"codesignal/internal/graphqlapi"
+	"codesignal/internal/health"
+	"codesignal/internal/openapi"
+	"codesignal/internal/pubsub"
+	"codesignal/internal/replica"
+	"codesignal/internal/schema"
+	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+	"codesignal/internal/webhook"
+)
+
+// apiInfo describes the API in the OpenAPI document.
+var apiInfo = openapi.Info{
+	Title: "Key-Value Store API",
+	Description: "An in-memory key-value store. Every JSON response carries a message and an API " +
+		"status_code, 1000 on success, alongside the HTTP status, and the X-Request-ID header of the request. " +
+		"The key operations are also served without their /v1 prefix, as deprecated aliases answering with a Warning header.",
+	Version: "1.0.0",
+}
+
+// operations documents the routes of the API. Registering a route missing
+// from it panics, so the OpenAPI document served at /openapi.json can't
+// fall behind the router.
+var operations = withV2Envelope(withMediaTypeErrors(withOverloadErrors(withMaintenanceErrors(withAuthErrors([]openapi.Route{
+	{
+		Method: http.MethodPost, Path: "/v1/key", ID: "createKey", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Create a key",
+		Description: "Creates a key with an optional ttl, a Go duration such as 30s, or delete_at, a time at which " +
+			"the key is deleted, and an optional owner, the only subject then allowed to modify it besides admins. " +
+			"Existing keys are left unchanged. The X-Key-Version response header holds the version of the key.",
+		Request: store.KeyValue{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusCreated:               reply("Key created"),
+			http.StatusBadRequest:            reply("Invalid body, key, value, ttl or delete_at"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+			http.StatusConflict:              reply("Key already exists"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key", ID: "getKey", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Get a key",
+		Description: "Returns the value of a key and the remaining ttl of expiring keys. In Raft clustered mode " +
+			"the X-Replication-Lag-Ms and X-Raft-Applied-Index response headers describe the serving node. " +
+			"With format=raw, or an Accept header preferring application/octet-stream or text/plain, the value " +
+			"alone is returned as the body, with its detected content type. The ETag response header, a hash of " +
+			"the value, revalidates it with If-None-Match. The X-Key-Version response header holds the version of " +
+			"the key, increasing with its writes. With HISTORY_VERSIONS set, version or at read a past value of " +
+			"the key. With select, only the value at that JSONPath of a JSON document is returned.",
+		Params: []openapi.Parameter{
+			openapi.Query("format", "string", "json, the default, or raw for the value alone without the JSON envelope."),
+			openapi.Query("version", "integer", "Version of the key to read, as returned by X-Key-Version."),
+			openapi.Query("at", "string", "RFC 3339 time whose value to read, exclusive with version."),
+			openapi.Query("select", "string", "JSONPath of the value to return from a JSON document, such as $.user.name."),
+			openapi.Header("X-Allow-Stale", "boolean", "In Raft clustered mode, lets a follower answer from its local replica."),
+			openapi.Header("If-None-Match", "string", "ETags of the value already held, answered 304 while unchanged."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:          {Description: "Key found", Body: store.Response{}},
+			http.StatusNotModified: {Description: "Value unchanged since the ETag of If-None-Match"},
+			http.StatusBadRequest:  reply("Invalid key, version, at or select, or value not a JSON document"),
+			http.StatusNotFound:    reply("Key, version or selected value not found"),
+		}),
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/key/:key", ID: "deleteKey", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Delete a key",
+		Description: "Deletes a key, with version only while the key is at that version.",
+		Params: []openapi.Parameter{
+			openapi.Query("version", "integer", "Version the key is expected at, rejecting the write with a 409 when it changed since."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key deleted"),
+			http.StatusBadRequest: reply("Invalid key or version"),
+			http.StatusNotFound:   reply("Key not found"),
+			http.StatusConflict:   reply("Key not at the expected version"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/b64/:encoded", ID: "getKeyBase64", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Get a base64 encoded key",
+		Description: "Returns the value of a key encoded in base64url, with or without padding, to read the keys " +
+			"a path can't hold, such as keys containing slashes or arbitrary bytes.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Key found", Body: store.Response{}},
+			http.StatusBadRequest: reply("Invalid key encoding"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/key/b64/:encoded", ID: "deleteKeyBase64", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Delete a base64 encoded key",
+		Description: "Deletes a key encoded in base64url, with or without padding.",
+		Params: []openapi.Parameter{
+			openapi.Query("version", "integer", "Version the key is expected at, rejecting the write with a 409 when it changed since."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key deleted"),
+			http.StatusBadRequest: reply("Invalid key encoding or version"),
+			http.StatusNotFound:   reply("Key not found"),
+			http.StatusConflict:   reply("Key not at the expected version"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/key/:key/increment", ID: "incrementKey", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Increment an integer value",
+		Description: "Adds delta, 1 when omitted, to the base-10 integer stored at key and returns the new value. " +
+			"A missing key counts as zero.",
+		Request:         store.IncrementRequest{},
+		OptionalRequest: true,
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    reply("Key incremented"),
+			http.StatusBadRequest:            reply("Invalid key or body, value not an integer or overflow"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/key/:key/undelete", ID: "undeleteKey", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Restore a deleted key",
+		Description: "Restores a key deleted within the tombstone retention, with its value and remaining ttl.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key restored"),
+			http.StatusBadRequest: reply("Invalid key"),
+			http.StatusNotFound:   reply("No deleted key to restore"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key/dump", ID: "dumpKey", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "Serialize a key",
+		Description: "Returns the value of a key in the format of the Redis DUMP command, base64 encoded.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key dumped"),
+			http.StatusBadRequest: reply("Invalid key"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/key/:key/restore", ID: "restoreKey", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Restore a serialized key",
+		Description: "Creates a key from a payload of the dump endpoint or the Redis DUMP command.",
+		Request:     store.RestoreRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusCreated:               reply("Key restored"),
+			http.StatusBadRequest:            reply("Invalid key, payload or ttl"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+			http.StatusConflict:              reply("Key already exists and replace is not set"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key/value", ID: "getValue", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Download the raw value of a key",
+		Description: "Returns the value of a key alone as the body, with its detected content type and its " +
+			"Content-Length. Range requests download a part of the value, to resume the download of large values.",
+		Params: []openapi.Parameter{
+			openapi.Header("Range", "string", "A bytes range of the value, such as bytes=0-1023."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                           {Description: "Value found", ContentType: "application/octet-stream"},
+			http.StatusPartialContent:               {Description: "Range of the value", ContentType: "application/octet-stream"},
+			http.StatusBadRequest:                   reply("Invalid key"),
+			http.StatusNotFound:                     reply("Key not found"),
+			http.StatusRequestedRangeNotSatisfiable: {Description: "Range outside of the value", ContentType: "text/plain"},
+		}),
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/key/:key/value", ID: "putValue", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Upload the raw value of a key",
+		Description: "Sets a key to the request body, replacing its value, with an optional ttl query parameter. " +
+			"The body, sent with a Content-Length or in chunks, is buffered whole, without the JSON escaping of " +
+			"the create route, and bodies announcing a size over the value size limit are rejected unread. The " +
+			"X-Key-Version response header holds the new version of the key.",
+		Params: []openapi.Parameter{
+			openapi.Query("ttl", "string", "Time-to-live of the key, a Go duration such as 30s."),
+			openapi.Query("version", "integer", "Version the key is expected at, rejecting the write with a 409 when it changed since."),
+		},
+		RequestContentType: "application/octet-stream",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    reply("Value stored"),
+			http.StatusBadRequest:            reply("Invalid key, body, ttl or version"),
+			http.StatusRequestEntityTooLarge: reply("Value larger than the value size limit"),
+			http.StatusConflict:              reply("Key not at the expected version"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key/members", ID: "listMembers", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "List the members of a set",
+		Description: "Returns the sorted members of the set stored at key, a JSON array of strings.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Members listed", Body: store.MembersResponse{}},
+			http.StatusBadRequest: reply("Invalid key or value not a set"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key/members/:member", ID: "getMember", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Check the membership of a set",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Member found"),
+			http.StatusBadRequest: reply("Invalid key or value not a set"),
+			http.StatusNotFound:   reply("Key or member not found"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/key/:key/members/add", ID: "addMembers", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Add members to a set",
+		Description: "Atomically adds members to the set stored at key and returns those it didn't hold. " +
+			"A missing key is an empty set, the expiry of an existing key is preserved.",
+		Request: store.MembersRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    {Description: "Members added", Body: store.MembersResponse{}},
+			http.StatusBadRequest:            reply("Invalid key or body, value not a set or set over the value size limit"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/key/:key/members/remove", ID: "removeMembers", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Remove members from a set",
+		Description: "Atomically removes members from the set stored at key and returns those it held.",
+		Request:     store.MembersRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    {Description: "Members removed", Body: store.MembersResponse{}},
+			http.StatusBadRequest:            reply("Invalid key or body or value not a set"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/key/:key/path", ID: "getPath", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "Get a value of a JSON document",
+		Description: "Returns the value at a JSONPath of the JSON document stored at key.",
+		Params: []openapi.Parameter{
+			openapi.Query("path", "string", "A definite JSONPath, such as $.a.b or $.items[0], $ when omitted."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Path found", Body: store.PathResponse{}},
+			http.StatusBadRequest: reply("Invalid key or path, or value not a JSON document"),
+			http.StatusNotFound:   reply("Key or path not found"),
+		}),
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/key/:key/path", ID: "putPath", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Set a value of a JSON document",
+		Description: "Atomically sets the value at a JSONPath of the JSON document stored at key. " +
+			"A missing member of an existing object is created, only the root path creates a missing key. " +
+			"The expiry of an existing key is preserved.",
+		Params: []openapi.Parameter{
+			openapi.Query("path", "string", "A definite JSONPath, such as $.a.b or $.items[0], $ when omitted."),
+		},
+		Request: store.PathRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    reply("Path set"),
+			http.StatusBadRequest:            reply("Invalid key, path or body, value not a JSON document or document over the value size limit"),
+			http.StatusNotFound:              reply("Path not found"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/hash/:key", ID: "getHash", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "Get the fields of a hash",
+		Description: "Returns every field of the hash stored at key, a JSON object of strings.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Fields found", Body: store.HashResponse{}},
+			http.StatusBadRequest: reply("Invalid key or value not a hash"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/hash/:key/:field", ID: "getField", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Get a field of a hash",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Field found", Body: store.HashResponse{}},
+			http.StatusBadRequest: reply("Invalid key or value not a hash"),
+			http.StatusNotFound:   reply("Key or field not found"),
+		}),
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/hash/:key/:field", ID: "putField", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Set a field of a hash",
+		Description: "Atomically sets a field of the hash stored at key. A missing key is an empty hash, " +
+			"the expiry of an existing key is preserved.",
+		Request: store.FieldRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusCreated:               reply("Field created"),
+			http.StatusOK:                    reply("Field updated"),
+			http.StatusBadRequest:            reply("Invalid key or body, value not a hash or hash over the value size limit"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/hash/:key/:field", ID: "deleteField", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Delete a field of a hash",
+		Description: "Atomically deletes a field of the hash stored at key. Deleting the last field leaves an empty hash.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Field deleted"),
+			http.StatusBadRequest: reply("Invalid key or value not a hash"),
+			http.StatusNotFound:   reply("Field not found"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/zset/:key/add", ID: "addScores", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Add members to a sorted set",
+		Description: "Atomically adds members to the sorted set stored at key, or updates their score, " +
+			"and returns those it didn't hold. A missing key is an empty sorted set, " +
+			"the expiry of an existing key is preserved.",
+		Request: store.ScoresRequest{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    {Description: "Members added", Body: store.MembersResponse{}},
+			http.StatusBadRequest:            reply("Invalid key, body or score, value not a sorted set or sorted set over the value size limit"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/zset/:key/range", ID: "rangeScores", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Range of a sorted set by rank",
+		Description: "Returns the members of the sorted set stored at key ranked from start to stop, both included, " +
+			"sorted by score then member.",
+		Params: []openapi.Parameter{
+			openapi.Query("start", "integer", "The rank of the first member, 0 when omitted. Negative ranks count from the end."),
+			openapi.Query("stop", "integer", "The rank of the last member, -1 for the last one when omitted."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Range found", Body: store.ScoresResponse{}},
+			http.StatusBadRequest: reply("Invalid key or rank, or value not a sorted set"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/zset/:key/rangebyscore", ID: "rangeScoresByScore", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Range of a sorted set by score",
+		Description: "Returns up to limit members of the sorted set stored at key scored between min and max, " +
+			"sorted by score then member.",
+		Params: []openapi.Parameter{
+			openapi.Query("min", "string", "The lowest score, -inf when omitted. Prefixed with ( to exclude it."),
+			openapi.Query("max", "string", "The highest score, +inf when omitted. Prefixed with ( to exclude it."),
+			openapi.Query("limit", "integer", "The maximum number of members, every member when omitted."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Range found", Body: store.ScoresResponse{}},
+			http.StatusBadRequest: reply("Invalid key, bound or limit, or value not a sorted set"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/counter/:key", ID: "getCounter", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "Get a counter",
+		Description: "Returns the count of the counter stored at key.",
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Counter found", Body: store.CounterResponse{}},
+			http.StatusBadRequest: reply("Invalid key or value not a counter"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/counter/:key", ID: "updateCounter", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Update a counter",
+		Description: "Atomically adds delta, 1 when omitted, to the counter stored at key and returns the new count. " +
+			"A missing key is a zero counter, the expiry of an existing key is preserved. " +
+			"Overflow selects whether an update past the int64 range fails, wraps around or saturates.",
+		Request:         store.CounterRequest{},
+		OptionalRequest: true,
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    {Description: "Counter updated", Body: store.CounterResponse{}},
+			http.StatusBadRequest:            reply("Invalid key or body, value not a counter or overflow"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/counter/:key/reset", ID: "resetCounter", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Reset a counter",
+		Description: "Atomically sets the counter stored at key to value, 0 when omitted, and returns its previous count. " +
+			"A missing key is a zero counter, the expiry of an existing key is preserved.",
+		Request:         store.ResetCounterRequest{},
+		OptionalRequest: true,
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                    {Description: "Counter reset", Body: store.CounterResponse{}},
+			http.StatusBadRequest:            reply("Invalid key or body, or value not a counter"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/sets/union", ID: "unionSets", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Union of sets",
+		Description: "Returns the sorted members of any of the sets stored at the keys, read at once. " +
+			"Missing keys are empty sets.",
+		Params: []openapi.Parameter{
+			openapi.Query("key", "string", "A key of a set, repeated for each set."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Sets combined", Body: store.MembersResponse{}},
+			http.StatusBadRequest: reply("No or invalid key, or value not a set"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/sets/intersection", ID: "intersectSets", Tag: "keys", Scope: auth.ScopeRead,
+		Summary: "Intersection of sets",
+		Description: "Returns the sorted members of every set stored at the keys, read at once. " +
+			"Missing keys are empty sets.",
+		Params: []openapi.Parameter{
+			openapi.Query("key", "string", "A key of a set, repeated for each set."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Sets combined", Body: store.MembersResponse{}},
+			http.StatusBadRequest: reply("No or invalid key, or value not a set"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/keys", ID: "listKeys", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "List keys",
+		Description: "Lists the keys starting with prefix in lexical order. Pass next as after to get the following page.",
+		Params: []openapi.Parameter{
+			openapi.Query("prefix", "string", "Only list keys starting with prefix."),
+			openapi.Query("after", "string", "List the keys after this one, the next of the previous page."),
+			openapi.Query("limit", "integer", "The maximum number of keys listed, 100 by default and at most 1000."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         {Description: "A page of keys", Body: store.KeysResponse{}},
+			http.StatusBadRequest: {Description: "Invalid limit", Body: store.KeysResponse{}},
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/v2/key", ID: "createKeyV2", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary: "Create a key, v2 envelope",
+		Description: "Creates a key like createKey, answering with the v2 envelope, whose error details the invalid " +
+			"field of a failed request and the limit it exceeds.",
+		Request: store.KeyValue{},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusCreated:               reply("Key created"),
+			http.StatusBadRequest:            reply("Invalid body, key, value or ttl"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+			http.StatusConflict:              reply("Key already exists"),
+		}),
+	},
+	{
+		Method: http.MethodGet, Path: "/v2/key/:key", ID: "getKeyV2", Tag: "keys", Scope: auth.ScopeRead,
+		Summary:     "Get a key, v2 envelope",
+		Description: "Returns the value of a key like getKey, answering with the v2 envelope.",
+		Params: []openapi.Parameter{
+			openapi.Header("X-Allow-Stale", "boolean", "In Raft clustered mode, lets a follower answer from its local replica."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key found"),
+			http.StatusBadRequest: reply("Invalid key"),
+			http.StatusNotFound:   reply("Key not found"),
+		}),
+	},
+	{
+		Method: http.MethodDelete, Path: "/v2/key/:key", ID: "deleteKeyV2", Tag: "keys", Scope: auth.ScopeWrite,
+		Summary:     "Delete a key, v2 envelope",
+		Description: "Deletes a key like deleteKey, answering with the v2 envelope.",
+		Params: []openapi.Parameter{
+			openapi.Query("version", "integer", "Version the key is expected at, rejecting the write with a 409 when it changed since."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:         reply("Key deleted"),
+			http.StatusBadRequest: reply("Invalid key or version"),
+			http.StatusNotFound:   reply("Key not found"),
+			http.StatusConflict:   reply("Key not at the expected version"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/graphql", ID: "graphql", Tag: "protocols", Scope: auth.ScopeRead,
+		Summary:     "Execute a GraphQL request",
+		Description: "Executes a query or mutation of the GraphQL schema of the store.",
+		Request:     graphqlapi.Request{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:         {Description: "Request executed, with its data and errors"},
+			http.StatusBadRequest: {Description: "Invalid body or missing query"},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/ws", ID: "websocket", Tag: "protocols", Scope: auth.ScopeRead,
+		Summary: "Open a WebSocket connection",
+		Description: "Upgrades to a WebSocket connection exchanging JSON requests, responses, watch events and " +
+			"channel messages.",
+		Responses: map[int]openapi.Reply{
+			http.StatusSwitchingProtocols: {Description: "Switching to the WebSocket protocol"},
+			http.StatusBadRequest:         {Description: "Not a WebSocket handshake"},
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/v1/channels/:channel/publish", ID: "publishMessage", Tag: "channels", Scope: auth.ScopeWrite,
+		Summary: "Publish a message",
+		Description: "Delivers a message to the current subscribers of a channel, over server-sent events or " +
+			"WebSocket. Messages aren't stored: a channel without subscribers drops them. Channels are local to " +
+			"the node, independent of the keys but authorized like keys of the same name.",
+		Request: pubsub.PublishRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                    reply("Message published"),
+			http.StatusBadRequest:            reply("Invalid body or channel"),
+			http.StatusRequestEntityTooLarge: reply("Request body larger than the value size limit allows"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/channels/:channel/subscribe", ID: "subscribeChannel", Tag: "channels", Scope: auth.ScopeRead,
+		Summary: "Subscribe to a channel",
+		Description: "Streams the messages published on a channel, or on the channels starting with a pattern " +
+			"ending with *, as text/event-stream message events with {channel, message, time} data, until the " +
+			"client disconnects. A subscriber falling behind is sent an error event and disconnected.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:         {Description: "The event stream of the messages"},
+			http.StatusBadRequest: reply("Invalid channel"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/changes", ID: "listChanges", Tag: "changes", Scope: auth.ScopeRead,
+		Summary: "Read the change log",
+		Description: "Returns the changes logged after the after sequence or, without it, after the committed cursor " +
+			"of consumer, from the oldest retained change for new consumers. Reading doesn't move the cursor: " +
+			"consumers commit the cursor of the page once they processed its changes, and resume from it after " +
+			"disconnecting. Enabled by CHANGELOG_DIR, the log is local to the node.",
+		Params: []openapi.Parameter{
+			openapi.Query("after", "integer", "Sequence of the change to read after."),
+			openapi.Query("consumer", "string", "Consumer to read after the cursor of, when after is omitted."),
+			openapi.Query("limit", "integer", "Maximum number of changes returned, 100 by default, at most 1000."),
+		},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:         {Description: "The changes, with the cursor to commit", Body: cdc.ChangesResponse{}},
+			http.StatusBadRequest: reply("Invalid after or limit"),
+			http.StatusGone:       reply("Changes following the cursor were removed by retention"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/v1/changes/cursors", ID: "listCursors", Tag: "changes", Scope: auth.ScopeRead,
+		Summary:     "List the consumer cursors",
+		Description: "Lists the committed cursors of the change log consumers.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The cursors", Body: cdc.CursorsResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPut, Path: "/v1/changes/cursors/:consumer", ID: "commitCursor", Tag: "changes", Scope: auth.ScopeRead,
+		Summary:     "Commit a consumer cursor",
+		Description: "Persists the sequence of the last change a consumer processed, the changes it reads next follow.",
+		Request:     cdc.CommitRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "Cursor committed", Body: cdc.CursorResponse{}},
+			http.StatusBadRequest:          reply("Invalid body or consumer, or sequence past the last change"),
+			http.StatusInternalServerError: reply("Failed to persist the cursor"),
+		},
+	},
+	{
+		Method: http.MethodDelete, Path: "/v1/changes/cursors/:consumer", ID: "deleteCursor", Tag: "changes", Scope: auth.ScopeRead,
+		Summary:     "Delete a consumer cursor",
+		Description: "Removes the cursor of a consumer, which starts over from the oldest retained change.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Cursor deleted"),
+			http.StatusNotFound:            reply("Cursor not found"),
+			http.StatusInternalServerError: reply("Failed to persist the cursors"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/metrics", ID: "metrics", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Process metrics",
+		Description: "The kv_* counters, and the kv_key_length_bytes and kv_value_size_bytes histograms of " +
+			"written keys and values with cumulative bucket counts.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The expvar metrics of the process, as a JSON object", Body: map[string]any{}},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/hotkeys", ID: "hotKeys", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "List hot keys",
+		Description: "Lists the most read and most written keys of the node, from sampled accesses through every " +
+			"protocol. Counts are estimates scaled by the sample rate, with bounded memory whatever the number of keys.",
+		Params: []openapi.Parameter{
+			openapi.Query("limit", "integer", "The maximum number of keys listed per kind of access, 10 by default and at most 100."),
+		},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:         {Description: "The hot keys", Body: store.HotKeysResponse{}},
+			http.StatusBadRequest: {Description: "Invalid limit", Body: store.HotKeysResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/reload", ID: "reloadConfig", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Reload the configuration",
+		Description: "Reads the .env file again and applies the reloadable settings, such as the limits, log level, " +
+			"authentication and client address lists, as SIGHUP does. An invalid configuration changes nothing.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Configuration reloaded"),
+			http.StatusInternalServerError: reply("Invalid configuration, the settings are unchanged"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/maintenance", ID: "getMaintenance", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Get the maintenance mode",
+		Description: "Reports whether the store is in maintenance, rejecting writes, and since when.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The maintenance mode", Body: store.MaintenanceResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPut, Path: "/admin/maintenance", ID: "setMaintenance", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Enable or disable the maintenance mode",
+		Description: "While enabled, the operations modifying keys fail with a 503 through every protocol and reads " +
+			"keep being served. The mode starts as set by READ_ONLY and isn't persisted across restarts. " +
+			"The mode of a replica is pinned and can't be disabled.",
+		Request: store.MaintenanceRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:         {Description: "The maintenance mode", Body: store.MaintenanceResponse{}},
+			http.StatusBadRequest: reply("Invalid body"),
+			http.StatusConflict:   reply("The store is a read-only replica"),
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/expire", ID: "expireKeys", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Set the expiry of keys by prefix",
+		Description: "Sets the time-to-live of every live key starting with prefix, or removes their expiry with a ttl " +
+			"of 0, keeping their values and owners. Each key updated gets a new version and a change event, and a " +
+			"key written meanwhile keeps the ttl of that write. With tenants, the keys of the tenant of the request.",
+		Params: []openapi.Parameter{
+			openapi.Query("prefix", "string", "Required, the prefix of the keys updated, empty for every key."),
+			openapi.Query("ttl", "string", "Required, the time-to-live of the keys such as 1h, or 0 to remove their expiry."),
+		},
+		Responses: withStorageErrors(map[int]openapi.Reply{
+			http.StatusOK:                 {Description: "The number of keys updated", Body: store.ExpireResponse{}},
+			http.StatusBadRequest:         reply("Missing prefix, or missing or invalid ttl"),
+			http.StatusServiceUnavailable: reply("Maintenance mode, writes are rejected"),
+		}),
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/backup", ID: "backup", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Back up the store",
+		Description: "Streams a backup of every key of the store, in the format of the scheduled backups, " +
+			"to be restored by /admin/restore or loaded by kvadmin.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("The backup, as application/octet-stream"),
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/restore", ID: "restore", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Restore a backup",
+		Description: "Writes the keys of the backup of the request body, overwriting those already set. With mode " +
+			"replace the keys missing from the backup are deleted, with merge, the default, they are kept.",
+		Params: []openapi.Parameter{
+			openapi.Query("mode", "string", "merge or replace."),
+		},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "Backup restored", Body: backup.RestoreResponse{}},
+			http.StatusBadRequest:          {Description: "Invalid backup or mode", Body: backup.RestoreResponse{}},
+			http.StatusInternalServerError: {Description: "Storage error, the backup was partially restored", Body: backup.RestoreResponse{}},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/webhooks", ID: "listWebhooks", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "List the webhooks",
+		Description: "Lists the registered webhooks, without their secret.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "The registered webhooks", Body: webhook.ListResponse{}},
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/webhooks", ID: "registerWebhook", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Register a webhook",
+		Description: "Registers a URL receiving a signed POST for every key created, updated, deleted or " +
+			"expired under the prefix. The secret signing the deliveries is generated when omitted, and " +
+			"only returned here.",
+		Request: webhook.RegisterRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusCreated:             {Description: "Webhook registered", Body: webhook.Response{}},
+			http.StatusBadRequest:          reply("Invalid body or URL"),
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodDelete, Path: "/admin/webhooks/:id", ID: "unregisterWebhook", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Unregister a webhook",
+		Description: "Stops the deliveries of the webhook, dropping those queued.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Webhook unregistered"),
+			http.StatusNotFound:            reply("Webhook not found"),
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/schemas", ID: "listSchemas", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "List the schemas",
+		Description: "Lists the JSON Schemas registered for key prefixes.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The registered schemas", Body: schema.ListResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPut, Path: "/admin/schemas", ID: "registerSchema", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Register a schema",
+		Description: "Registers the JSON Schema of the request body for the keys under the prefix, replacing " +
+			"the previous one. The values created, set or restored under the prefix must then match it, the " +
+			"longest registered prefix of a key choosing its schema. The values already stored aren't validated.",
+		Params: []openapi.Parameter{
+			openapi.Query("prefix", "string", "Prefix of the keys, empty for every key."),
+		},
+		Request: schema.Schema{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "Schema registered", Body: schema.Response{}},
+			http.StatusBadRequest:          reply("Missing prefix or invalid schema"),
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodDelete, Path: "/admin/schemas", ID: "unregisterSchema", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Unregister a schema",
+		Description: "Stops validating the values written under the prefix against its schema.",
+		Params: []openapi.Parameter{
+			openapi.Query("prefix", "string", "Prefix of the keys, empty for every key."),
+		},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Schema unregistered"),
+			http.StatusBadRequest:          reply("Missing prefix"),
+			http.StatusNotFound:            reply("Schema not found"),
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/tenants", ID: "tenantUsage", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Report the usage of the tenants",
+		Description: "Returns the requests, rate limited requests and body bytes of each tenant since the server " +
+			"started, and the number and size of the keys of the tenants whose store is open. Served with " +
+			"TENANT_ENABLED.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "The usage of the tenants", Body: tenant.UsageResponse{}},
+			http.StatusInternalServerError: reply("Storage error"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/replica", ID: "replicaStatus", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Get the state of the replica",
+		Description: "Reports the snapshot served by the replica, when it was written and loaded, and the error " +
+			"of the last check of its source. Served with REPLICA_SOURCE.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The state of the replica", Body: replica.StatusResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/replica/refresh", ID: "refreshReplica", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary: "Refresh the snapshot of the replica",
+		Description: "Loads the snapshot of the source of the replica if it changed, without waiting for " +
+			"REPLICA_REFRESH_INTERVAL. The previous snapshot keeps being served when it fails. Served with " +
+			"REPLICA_SOURCE.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  {Description: "The state of the replica", Body: replica.StatusResponse{}},
+			http.StatusInternalServerError: reply("The snapshot couldn't be loaded"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/healthz", ID: "liveness", Tag: "admin",
+		Summary:     "Liveness probe",
+		Description: "Answers as long as the process serves HTTP requests.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The process is alive", Body: health.Response{}},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/readyz", ID: "readiness", Tag: "admin",
+		Summary: "Readiness probe",
+		Description: "Checks that the repository serves requests and, in Raft clustered mode, that the node knows " +
+			"the leader and applied its log, or in sharding mode that storage nodes are available.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                 {Description: "The node is ready", Body: health.Response{}},
+			http.StatusServiceUnavailable: {Description: "The node isn't ready, with the failed checks", Body: health.Response{}},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/version", ID: "version", Tag: "admin",
+		Summary:     "Build of the server",
+		Description: "Reports the version, commit and build time of the running binary, and its Go version.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The build info", Body: buildinfo.Response{}},
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/admin/cluster", ID: "clusterStatus", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Cluster status",
+		Description: "Describes the Raft, sharding and gossip state of the node.",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "Cluster status", Body: cluster.StatusResponse{}},
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/cluster/join", ID: "clusterJoin", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Add a node to the Raft cluster",
+		Description: "Only served in Raft clustered mode, by the leader.",
+		Request:     cluster.JoinRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Node joined"),
+			http.StatusBadRequest:          reply("Invalid body"),
+			http.StatusInternalServerError: reply("Storage error"),
+			http.StatusServiceUnavailable:  reply("The cluster has no leader"),
+		},
+	},
+	{
+		Method: http.MethodPost, Path: "/admin/cluster/leave", ID: "clusterLeave", Tag: "admin", Scope: auth.ScopeAdmin,
+		Summary:     "Remove a node from the Raft cluster",
+		Description: "Only served in Raft clustered mode, by the leader.",
+		Request:     cluster.LeaveRequest{},
+		Responses: map[int]openapi.Reply{
+			http.StatusOK:                  reply("Node removed"),
+			http.StatusBadRequest:          reply("Invalid body"),
+			http.StatusInternalServerError: reply("Storage error"),
+			http.StatusServiceUnavailable:  reply("The cluster has no leader"),
+		},
+	},
+	{
+		Method: http.MethodGet, Path: "/openapi.json", ID: "openapi", Tag: "admin",
+		Summary: "This OpenAPI document",
+		Responses: map[int]openapi.Reply{
+			http.StatusOK: {Description: "The OpenAPI document of the API"},
+		},
+	},
+})))))
+
+// lookupOperation returns the documentation of a route.
+func lookupOperation(method, path string) (openapi.Route, bool) {
+	for _, op := range operations {
+		if op.Method == method && op.Path == path {
+			return op, true
+		}
+	}
+	return openapi.Route{}, false
+}
+
+// lookupOperationID returns the documentation of the route of operation id.
+func lookupOperationID(id string) (openapi.Route, bool) {
+	for _, op := range operations {
+		if op.ID == id {
+			return op, true
+		}
+	}
+	return openapi.Route{}, false
+}
+
+// reply documents a response with a store.Response body.
+func reply(description string) openapi.Reply {
+	return openapi.Reply{Description: description, Body: store.Response{}}
+}
+
+// withStorageErrors adds the responses of failed storage operations.
+func withStorageErrors(replies map[int]openapi.Reply) map[int]openapi.Reply {
+	replies[store.StatusClientClosedRequest] = reply("Request canceled by the client")
+	replies[http.StatusInternalServerError] = reply("Storage error")
+	replies[http.StatusGatewayTimeout] = reply("Request timed out")
+	return replies
+}
+
+// withMaintenanceErrors adds the response of writes rejected in maintenance
+// or past the soft memory limit to the routes modifying keys.
+func withMaintenanceErrors(routes []openapi.Route) []openapi.Route {
+	for _, route := range routes {
+		if route.Scope == auth.ScopeWrite && route.Tag == "keys" {
+			route.Responses[http.StatusServiceUnavailable] = reply("Maintenance mode, or soft memory limit exceeded, writes are rejected")
+		}
+	}
+	return routes
+}
+
+// shed reports whether the routes of tag are subject to load shedding.
+// Admin and health routes aren't, so an overloaded node can still be
+// observed and operated.
+func shed(tag string) bool {
+	return tag == "keys" || tag == "channels" || tag == "changes" || tag == "protocols"
+}
+
+// longLived are the operations holding their connection open, only limited
+// by their route limit rather than taking a slot of the global limit.
+var longLived = map[string]bool{"websocket": true, "subscribeChannel": true}
+
+// withOverloadErrors adds the response of requests shed over the load
+// shedding limits to the key, channel, change and protocol routes.
+func withOverloadErrors(routes []openapi.Route) []openapi.Route {
+	for _, route := range routes {
+		if !shed(route.Tag) {
+			continue
+		}
+		if r, ok := route.Responses[http.StatusServiceUnavailable]; ok {
+			r.Description += ", or server overloaded with load shedding enabled"
+			route.Responses[http.StatusServiceUnavailable] = r
+			continue
+		}
+		route.Responses[http.StatusServiceUnavailable] = reply("Server overloaded with load shedding enabled, retry after the Retry-After delay")
+	}
+	return routes
+}
+
+// withV2Envelope documents the responses of the /v2 routes with the v2
+// envelope, the store handlers writing it.
+func withV2Envelope(routes []openapi.Route) []openapi.Route {
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/v2/") {
+			continue
+		}
+		for code, r := range route.Responses {
+			if _, ok := r.Body.(store.Response); ok {
+				r.Body = store.ResponseV2{}
+				route.Responses[code] = r
+			}
+		}
+	}
+	return routes
+}
+
+// withMediaTypeErrors adds the response of request bodies sent with
+// another content type than JSON to the routes decoding JSON bodies.
+func withMediaTypeErrors(routes []openapi.Route) []openapi.Route {
+	for _, route := range routes {
+		if route.Request != nil {
+			route.Responses[http.StatusUnsupportedMediaType] = reply("Request body not sent as application/json, with STRICT_CONTENT_TYPE enabled")
+		}
+	}
+	return routes
+}
+
+// withAuthErrors adds the responses of rejected credentials to the routes
+// with a scope.
+func withAuthErrors(routes []openapi.Route) []openapi.Route {
+	for _, route := range routes {
+		if route.Scope == "" {
+			continue
+		}
+		route.Responses[http.StatusUnauthorized] = reply("Missing or invalid credentials, with authentication enabled")
+		route.Responses[http.StatusForbidden] = reply("The credentials aren't granted the scope of the route or the permission on the key")
+	}
+	return routes
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/pubsub"
+	"codesignal/internal/store"
+	"codesignal/internal/tenant"
+)
+
+// keyAPI serves the key, protocol and channel routes of a store.
+type keyAPI struct {
+	service   *store.Service
+	graphql   http.Handler
+	websocket http.Handler
+	channels  *pubsub.Handler
+}
+
+func (api *keyAPI) serveGraphQL(w http.ResponseWriter, r *http.Request) {
+	api.graphql.ServeHTTP(w, r)
+}
+
+func (api *keyAPI) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	api.websocket.ServeHTTP(w, r)
+}
+
+func (api *keyAPI) publish(w http.ResponseWriter, r *http.Request) {
+	api.channels.Publish(w, r)
+}
+
+func (api *keyAPI) subscribe(w http.ResponseWriter, r *http.Request) {
+	api.channels.Subscribe(w, r)
+}
+
+// keyAPIs serve the routes of the keys from the store of the tenant of
+// each request, or from the default store without tenants.
+type keyAPIs struct {
+	tenants *tenant.Registry
+	// build returns the API of a tenant, built once for the router.
+	build func(t *tenant.Tenant) *keyAPI
+	def   *keyAPI
+
+	mu   sync.Mutex
+	apis map[string]*keyAPI
+}
+
+// serve returns the handler calling h with the API of the request.
+func (a *keyAPIs) serve(h func(api *keyAPI, w http.ResponseWriter, r *http.Request)) http.Handler {
+	if a.tenants == nil {
+		api := a.def
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { h(api, w, r) })
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := a.tenants.Resolve(r)
+		if err != nil {
+			writeTenantError(w, r, err)
+			return
+		}
+		zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+			return c.Str("tenant", name)
+		})
+		if wait, ok := a.tenants.Allow(name); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeJSON(w, r, http.StatusTooManyRequests, store.Response{Message: "tenant rate limit exceeded", StatusCode: store.StatusRateLimited})
+			return
+		}
+		api, err := a.get(name)
+		if err != nil {
+			writeTenantError(w, r, err)
+			return
+		}
+
+		// The bodies are counted as read and written, WebSocket messages
+		// aren't.
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(api, rec, r)
+		a.tenants.Transferred(name, body.n, rec.size)
+	})
+}
+
+// method returns the handler calling the method m of the store service of
+// the request, such as (*store.Service).GetKey.
+func (a *keyAPIs) method(m func(*store.Service, http.ResponseWriter, *http.Request)) http.Handler {
+	return a.serve(func(api *keyAPI, w http.ResponseWriter, r *http.Request) { m(api.service, w, r) })
+}
+
+// get returns the API of the tenant named name.
+func (a *keyAPIs) get(name string) (*keyAPI, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if api, ok := a.apis[name]; ok {
+		return api, nil
+	}
+	t, err := a.tenants.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	api := a.build(t)
+	a.apis[name] = api
+	return api, nil
+}
+
+// writeTenantError replies to the requests whose tenant can't be served.
+func writeTenantError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrTenantRequired), errors.Is(err, tenant.ErrInvalidTenant):
+		writeJSON(w, r, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidTenant})
+	case errors.Is(err, tenant.ErrTenantNotFound):
+		writeJSON(w, r, http.StatusNotFound, store.Response{Message: err.Error(), StatusCode: store.StatusTenantNotFound})
+	default:
+		zerolog.Ctx(r.Context()).Error().Err(err).Msg("failed to open the store of the tenant")
+		writeJSON(w, r, http.StatusInternalServerError, store.Response{Message: "storage error", StatusCode: store.StatusStorageError})
+	}
+}
+
+// countingReader counts the bytes read from a request body.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
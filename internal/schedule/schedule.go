@@ -0,0 +1,295 @@
+// Package schedule deletes the keys created with a delete_at time when it
+// is reached.
+//
+// The Scheduler loads the deletion times stored beside the keys, follows
+// their changes on the event bus, and deletes each key through the store
+// service at its time, so the deletion is replicated and published like
+// any other. The deletion time of a key deleted or expired before it is
+// removed with the key.
+package schedule
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// DefaultRetryInterval is the time waited before deleting a key again when
+// its deletion failed, such as in maintenance, or while the node isn't
+// active.
+const DefaultRetryInterval = 5 * time.Second
+
+// loadBatchSize is the number of deletion times scanned at once.
+const loadBatchSize = 1000
+
+// Scheduler deletes the keys at their deletion time, a server.Service.
+type Scheduler struct {
+	log     zerolog.Logger
+	service *store.Service
+	repo    repository.Store
+	bus     *events.Bus
+	active  func() bool
+	retry   time.Duration
+	now     func() time.Time
+
+	// due holds the deletion times by key, only used by Serve.
+	due *deadlines
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// New returns the scheduler deleting the keys of service, whose deletion
+// times are read from repo and followed on bus. Keys are only deleted while
+// active returns true, so a single node of a cluster deletes them; nil
+// deletes them always.
+func New(log zerolog.Logger, service *store.Service, repo repository.Store, bus *events.Bus, active func() bool) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		log:     log.With().Str("component", "schedule").Logger(),
+		service: service,
+		repo:    repo,
+		bus:     bus,
+		active:  active,
+		retry:   DefaultRetryInterval,
+		now:     time.Now,
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Name implements server.Service.
+func (s *Scheduler) Name() string {
+	return "schedule"
+}
+
+// Serve implements server.Service, deleting the keys due until shut down.
+func (s *Scheduler) Serve() error {
+	defer close(s.stopped)
+	for {
+		// Subscribing first, no deletion time is missed between loading
+		// them and receiving their changes.
+		sub := s.bus.Subscribe("")
+		if err := s.load(); err != nil {
+			s.log.Error().Err(err).Msg("failed to load the deletion times")
+		}
+		dropped := s.run(sub)
+		sub.Close()
+		if !dropped {
+			return nil
+		}
+		s.log.Warn().Err(sub.Err()).Msg("schedule subscription dropped, reloading the deletion times")
+	}
+}
+
+// Shutdown implements server.Service, waiting for the deletion in progress.
+func (s *Scheduler) Shutdown(ctx context.Context) error {
+	s.cancel()
+	select {
+	case <-s.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load replaces the deletion times with those stored in the repository.
+func (s *Scheduler) load() error {
+	s.due = newDeadlines()
+	after := ""
+	for {
+		items, err := s.repo.Scan(s.ctx, store.DeleteAtPrefix, after, loadBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			s.track(strings.TrimPrefix(item.Key, store.DeleteAtPrefix), item.Value)
+		}
+		if len(items) < loadBatchSize {
+			return nil
+		}
+		after = items[len(items)-1].Key
+	}
+}
+
+// run applies the events of sub and deletes the keys as they are due,
+// until shut down or, reporting true, until sub is dropped.
+func (s *Scheduler) run(sub *events.Subscription) bool {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var wait <-chan time.Time
+		if next, ok := s.due.next(); ok {
+			timer.Reset(max(next.at.Sub(s.now()), 0))
+			wait = timer.C
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return false
+		case e, ok := <-sub.Events():
+			if !ok {
+				return true
+			}
+			s.apply(e)
+		case <-wait:
+			s.deleteDue()
+		}
+	}
+}
+
+// apply updates the deletion times on the changes of e.
+func (s *Scheduler) apply(e events.Event) {
+	if key, ok := strings.CutPrefix(e.Key, store.DeleteAtPrefix); ok {
+		if e.Type == events.TypeSet {
+			s.track(key, e.Value)
+		} else {
+			s.due.remove(key)
+		}
+		return
+	}
+	if e.Type == events.TypeSet || !s.due.has(e.Key) || !s.isActive() {
+		return
+	}
+	// The key was deleted or expired before its time.
+	s.unschedule(e.Key)
+}
+
+// track sets the deletion time of key to the stored value.
+func (s *Scheduler) track(key string, value []byte) {
+	at, err := store.ParseDeleteAt(value)
+	if err != nil {
+		s.log.Error().Err(err).Str("key", key).Msg("invalid deletion time")
+		return
+	}
+	s.due.set(key, at)
+}
+
+// deleteDue deletes the keys whose deletion time is reached, retrying
+// later those that failed.
+func (s *Scheduler) deleteDue() {
+	now := s.now()
+	for {
+		d, ok := s.due.next()
+		if !ok || d.at.After(now) {
+			return
+		}
+		if !s.isActive() {
+			s.due.set(d.key, now.Add(s.retry))
+			continue
+		}
+
+		err := s.service.Delete(s.ctx, d.key)
+		switch {
+		case err == nil:
+			metrics.ScheduledDeletes.Add(1)
+			s.log.Debug().Str("key", d.key).Msg("key deleted at its deletion time")
+			s.unschedule(d.key)
+		case errors.Is(err, store.ErrKeyNotFound):
+			s.unschedule(d.key)
+		case s.ctx.Err() != nil:
+			return
+		default:
+			s.log.Warn().Err(err).Str("key", d.key).Msg("failed to delete key at its deletion time, retrying")
+			s.due.set(d.key, now.Add(s.retry))
+		}
+	}
+}
+
+// unschedule removes the deletion time of key.
+func (s *Scheduler) unschedule(key string) {
+	s.due.remove(key)
+	if err := s.repo.Delete(s.ctx, store.DeleteAtKey(key)); err != nil {
+		s.log.Error().Err(err).Str("key", key).Msg("failed to remove deletion time")
+	}
+}
+
+func (s *Scheduler) isActive() bool {
+	return s.active == nil || s.active()
+}
+
+// deadline is the time at which a key is deleted.
+type deadline struct {
+	key string
+	at  time.Time
+}
+
+// deadlines is a min-heap of keys by deletion time, indexed by key so that
+// the time of a key is updated in place.
+type deadlines struct {
+	items []deadline
+	index map[string]int
+}
+
+func newDeadlines() *deadlines {
+	return &deadlines{index: make(map[string]int)}
+}
+
+func (d *deadlines) Len() int           { return len(d.items) }
+func (d *deadlines) Less(i, j int) bool { return d.items[i].at.Before(d.items[j].at) }
+
+func (d *deadlines) Swap(i, j int) {
+	d.items[i], d.items[j] = d.items[j], d.items[i]
+	d.index[d.items[i].key] = i
+	d.index[d.items[j].key] = j
+}
+
+func (d *deadlines) Push(x any) {
+	item := x.(deadline)
+	d.index[item.key] = len(d.items)
+	d.items = append(d.items, item)
+}
+
+func (d *deadlines) Pop() any {
+	item := d.items[len(d.items)-1]
+	d.items = d.items[:len(d.items)-1]
+	delete(d.index, item.key)
+	return item
+}
+
+// next returns the key deleted first.
+func (d *deadlines) next() (deadline, bool) {
+	if len(d.items) == 0 {
+		return deadline{}, false
+	}
+	return d.items[0], true
+}
+
+func (d *deadlines) has(key string) bool {
+	_, ok := d.index[key]
+	return ok
+}
+
+// set sets the deletion time of key.
+func (d *deadlines) set(key string, at time.Time) {
+	if i, ok := d.index[key]; ok {
+		d.items[i].at = at
+		heap.Fix(d, i)
+		return
+	}
+	heap.Push(d, deadline{key: key, at: at})
+}
+
+// remove removes the deletion time of key.
+func (d *deadlines) remove(key string) {
+	if i, ok := d.index[key]; ok {
+		heap.Remove(d, i)
+	}
+}
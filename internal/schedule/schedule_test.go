@@ -0,0 +1,77 @@
+package schedule
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func TestScheduler(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(events.DefaultBufferSize)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{ScheduleDeletes: true})
+
+	// Loaded at startup: a key overdue and one due later.
+	require.NoError(t, repo.Set(ctx, "overdue", []byte("1")))
+	require.NoError(t, repo.Set(ctx, store.DeleteAtKey("overdue"), []byte(time.Now().Add(-time.Minute).Format(time.RFC3339Nano))))
+	require.NoError(t, service.CreateUntil(ctx, "later", []byte("2"), time.Now().Add(200*time.Millisecond), ""))
+
+	var active atomic.Bool
+	active.Store(true)
+	s := New(zerolog.Nop(), service, repo, bus, active.Load)
+	s.retry = 10 * time.Millisecond
+	sub := bus.Subscribe("")
+	defer sub.Close()
+	done := make(chan error)
+	go func() { done <- s.Serve() }()
+	defer func() {
+		require.NoError(t, s.Shutdown(ctx))
+		require.NoError(t, <-done)
+	}()
+
+	exists := func(key string) bool {
+		_, ok, err := repo.Get(ctx, key)
+		require.NoError(t, err)
+		return ok
+	}
+	require.Eventually(t, func() bool { return !exists("overdue") }, time.Second, 5*time.Millisecond)
+	assert.True(t, exists("later"))
+	require.Eventually(t, func() bool { return !exists("later") }, 2*time.Second, 5*time.Millisecond)
+	for _, key := range []string{"overdue", "later"} {
+		require.Eventually(t, func() bool { return !exists(store.DeleteAtKey(key)) }, time.Second, 5*time.Millisecond)
+	}
+	// The keys are deleted, not expired.
+	var deleted []string
+	for len(deleted) < 2 {
+		e := <-sub.Events()
+		if e.Type == events.TypeDelete && !store.ReservedKey(e.Key) {
+			deleted = append(deleted, e.Key)
+		}
+	}
+	assert.Equal(t, []string{"overdue", "later"}, deleted)
+
+	// Followed once started, and removed with the keys deleted before
+	// their time.
+	require.NoError(t, service.CreateUntil(ctx, "deleted", []byte("3"), time.Now().Add(time.Hour), ""))
+	require.NoError(t, service.Delete(ctx, "deleted"))
+	require.Eventually(t, func() bool { return !exists(store.DeleteAtKey("deleted")) }, time.Second, 5*time.Millisecond)
+
+	// Only the active node deletes the keys.
+	active.Store(false)
+	require.NoError(t, service.CreateUntil(ctx, "inactive", []byte("4"), time.Now().Add(50*time.Millisecond), ""))
+	time.Sleep(150 * time.Millisecond)
+	assert.True(t, exists("inactive"))
+	active.Store(true)
+	require.Eventually(t, func() bool { return !exists("inactive") }, time.Second, 5*time.Millisecond)
+}
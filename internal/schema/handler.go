@@ -0,0 +1,106 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// maxSchemaSize bounds the request body of a schema.
+const maxSchemaSize = 1 << 20
+
+// Response represents a schema returned by the API.
+type Response struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       Registration     `json:"data"`
+}
+
+// ListResponse represents the registered schemas returned by the API.
+type ListResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       []Registration   `json:"data"`
+}
+
+// Handler serves the schema admin endpoints.
+type Handler struct {
+	registry *Registry
+}
+
+// NewHandler returns the admin handler of the schemas of registry.
+func NewHandler(registry *Registry) *Handler {
+	return &Handler{registry: registry}
+}
+
+// List returns the registered schemas.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	writeJSON(log, w, http.StatusOK, ListResponse{Message: "schemas found", StatusCode: store.StatusSuccess, Data: h.registry.List()})
+}
+
+// Register registers the schema of the request body for the prefix query
+// parameter, replacing the previous one.
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	query := r.URL.Query()
+	if !query.Has("prefix") {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "prefix is required, empty for every key", StatusCode: store.StatusInvalidKey})
+		return
+	}
+	prefix := query.Get("prefix")
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxSchemaSize+1))
+	if err != nil || len(raw) > maxSchemaSize {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+
+	s, err := h.registry.Register(r.Context(), prefix, raw)
+	switch {
+	case errors.Is(err, ErrInvalidSchema):
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("failed to register schema")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to register schema", StatusCode: store.StatusStorageError})
+		return
+	}
+	log.Info().Str("prefix", prefix).Msg("schema registered")
+	writeJSON(log, w, http.StatusOK, Response{Message: "schema registered", StatusCode: store.StatusSuccess, Data: Registration{Prefix: prefix, Schema: s}})
+}
+
+// Unregister removes the schema of the prefix query parameter.
+func (h *Handler) Unregister(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	query := r.URL.Query()
+	if !query.Has("prefix") {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "prefix is required, empty for every key", StatusCode: store.StatusInvalidKey})
+		return
+	}
+	prefix := query.Get("prefix")
+	err := h.registry.Unregister(r.Context(), prefix)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeJSON(log, w, http.StatusNotFound, store.Response{Message: err.Error(), StatusCode: store.StatusSchemaNotFound})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("prefix", prefix).Msg("failed to unregister schema")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to unregister schema", StatusCode: store.StatusStorageError})
+		return
+	}
+	log.Info().Str("prefix", prefix).Msg("schema unregistered")
+	writeJSON(log, w, http.StatusOK, store.Response{Message: "schema unregistered", StatusCode: store.StatusSuccess})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
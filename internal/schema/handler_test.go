@@ -0,0 +1,59 @@
+package schema
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(New(zerolog.Nop(), newRepo(t, nil), nil))
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/admin/schemas", h.List)
+	router.HandlerFunc(http.MethodPut, "/admin/schemas", h.Register)
+	router.HandlerFunc(http.MethodDelete, "/admin/schemas", h.Unregister)
+
+	do := func(method, path, body string, resp any) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+		return rec.Code
+	}
+
+	var errResp store.Response
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/schemas", `{}`, &errResp))
+	assert.Equal(t, store.StatusInvalidKey, errResp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPut, "/admin/schemas?prefix=a", `{"$ref":"#"}`, &errResp))
+	assert.Equal(t, store.StatusInvalidValue, errResp.StatusCode)
+	assert.Equal(t, `invalid schema: #/$ref: unsupported keyword`, errResp.Message)
+
+	var registered Response
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/admin/schemas?prefix=config/", `{"type": "object"}`, &registered))
+	assert.Equal(t, "config/", registered.Data.Prefix)
+	require.NoError(t, registered.Data.Schema.Validate([]byte(`{}`)))
+	assert.Error(t, registered.Data.Schema.Validate([]byte(`[]`)))
+	require.Equal(t, http.StatusOK, do(http.MethodPut, "/admin/schemas?prefix=", `true`, &registered))
+
+	var list ListResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/schemas", "", &list))
+	require.Len(t, list.Data, 2)
+	assert.Equal(t, "", list.Data[0].Prefix)
+	assert.Equal(t, "config/", list.Data[1].Prefix)
+
+	var resp store.Response
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodDelete, "/admin/schemas", "", &resp))
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/admin/schemas?prefix=config/", "", &resp))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/schemas?prefix=config/", "", &resp))
+	assert.Equal(t, store.StatusSchemaNotFound, resp.StatusCode)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/schemas", "", &list))
+	assert.Len(t, list.Data, 1)
+}
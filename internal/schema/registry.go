@@ -0,0 +1,236 @@
+package schema
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// ErrNotFound is returned for a prefix without schema.
+var ErrNotFound = errors.New("schema not found")
+
+// Registration is the schema of the keys starting with Prefix, every key
+// when empty.
+type Registration struct {
+	Prefix string  `json:"prefix"`
+	Schema *Schema `json:"schema"`
+}
+
+// Registry holds the schemas of the prefixes and validates the values
+// written under them, the longest prefix of a key choosing its schema. It
+// is a store.Hook, and a server.Service keeping the schemas in sync with
+// the repository.
+type Registry struct {
+	store.NopHook
+	log  zerolog.Logger
+	repo repository.Store
+	bus  *events.Bus
+
+	mu      sync.RWMutex
+	schemas map[string]*Schema
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped chan struct{}
+}
+
+// New returns the registry of the schemas stored in repo, following their
+// changes on bus once served.
+func New(log zerolog.Logger, repo repository.Store, bus *events.Bus) *Registry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		log:     log.With().Str("component", "schema").Logger(),
+		repo:    repo,
+		bus:     bus,
+		schemas: make(map[string]*Schema),
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan struct{}),
+	}
+}
+
+// Name implements server.Service.
+func (r *Registry) Name() string {
+	return "schema"
+}
+
+// Serve implements server.Service, following the changes of the schemas
+// until shut down.
+func (r *Registry) Serve() error {
+	defer close(r.stopped)
+	for {
+		// Subscribing first, no change is missed between loading the
+		// schemas and receiving their changes.
+		sub := r.bus.Subscribe(store.SchemaPrefix)
+		if err := r.Load(r.ctx); err != nil {
+			r.log.Error().Err(err).Msg("failed to load schemas")
+		}
+		dropped := r.consume(sub)
+		sub.Close()
+		if !dropped {
+			return nil
+		}
+		r.log.Warn().Err(sub.Err()).Msg("schema subscription dropped, reloading schemas")
+	}
+}
+
+// Shutdown implements server.Service.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.cancel()
+	select {
+	case <-r.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// consume applies the changes of sub until shut down or, reporting true,
+// until sub is dropped.
+func (r *Registry) consume(sub *events.Subscription) bool {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return false
+		case e, ok := <-sub.Events():
+			if !ok {
+				return true
+			}
+			prefix := strings.TrimPrefix(e.Key, store.SchemaPrefix)
+			if e.Type != events.TypeSet {
+				r.remove(prefix)
+				continue
+			}
+			s, err := Compile(e.Value)
+			if err != nil {
+				r.log.Error().Err(err).Str("prefix", prefix).Msg("invalid stored schema")
+				continue
+			}
+			r.set(prefix, s)
+		}
+	}
+}
+
+// Load replaces the schemas with those stored in the repository.
+func (r *Registry) Load(ctx context.Context) error {
+	items, err := r.repo.Scan(ctx, store.SchemaPrefix, "", 0)
+	if err != nil {
+		return fmt.Errorf("list schemas: %w", err)
+	}
+	schemas := make(map[string]*Schema, len(items))
+	for _, item := range items {
+		prefix := strings.TrimPrefix(item.Key, store.SchemaPrefix)
+		if schemas[prefix], err = Compile(item.Value); err != nil {
+			return fmt.Errorf("schema of %q: %w", prefix, err)
+		}
+	}
+	r.mu.Lock()
+	r.schemas = schemas
+	r.mu.Unlock()
+	return nil
+}
+
+// Register compiles raw and stores it as the schema of prefix, replacing
+// the previous one. The values already stored aren't validated.
+func (r *Registry) Register(ctx context.Context, prefix string, raw []byte) (*Schema, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	s, err := Compile(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	if err := r.repo.Set(ctx, store.SchemaPrefix+prefix, buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("store schema: %w", err)
+	}
+	// The change event updates the other nodes, this one validates the
+	// writes following the registration right away.
+	r.set(prefix, s)
+	return s, nil
+}
+
+// Unregister removes the schema of prefix, or returns ErrNotFound.
+func (r *Registry) Unregister(ctx context.Context, prefix string) error {
+	_, found, err := r.repo.Get(ctx, store.SchemaPrefix+prefix)
+	if err != nil {
+		return fmt.Errorf("get schema: %w", err)
+	}
+	if !found {
+		return ErrNotFound
+	}
+	if err := r.repo.Delete(ctx, store.SchemaPrefix+prefix); err != nil {
+		return fmt.Errorf("delete schema: %w", err)
+	}
+	r.remove(prefix)
+	return nil
+}
+
+// List returns the schemas, sorted by prefix.
+func (r *Registry) List() []Registration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	regs := make([]Registration, 0, len(r.schemas))
+	for prefix, s := range r.schemas {
+		regs = append(regs, Registration{Prefix: prefix, Schema: s})
+	}
+	slices.SortFunc(regs, func(a, b Registration) int { return cmp.Compare(a.Prefix, b.Prefix) })
+	return regs
+}
+
+// BeforeSet implements store.Hook, rejecting with a *ValidationError the
+// values that don't match the schema of their key.
+func (r *Registry) BeforeSet(_ context.Context, key string, value []byte) ([]byte, error) {
+	prefix, s, ok := r.match(key)
+	if !ok {
+		return value, nil
+	}
+	if err := s.Validate(value); err != nil {
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			verr.Prefix = prefix
+		}
+		return nil, err
+	}
+	return value, nil
+}
+
+// match returns the schema of the longest prefix of key.
+func (r *Registry) match(key string) (string, *Schema, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var (
+		best  string
+		found *Schema
+	)
+	for prefix, s := range r.schemas {
+		if strings.HasPrefix(key, prefix) && (found == nil || len(prefix) > len(best)) {
+			best, found = prefix, s
+		}
+	}
+	return best, found, found != nil
+}
+
+func (r *Registry) set(prefix string, s *Schema) {
+	r.mu.Lock()
+	r.schemas[prefix] = s
+	r.mu.Unlock()
+}
+
+func (r *Registry) remove(prefix string) {
+	r.mu.Lock()
+	delete(r.schemas, prefix)
+	r.mu.Unlock()
+}
@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func newRepo(t *testing.T, bus *events.Bus) *repository.KeyValueStore {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepo(t, nil)
+	registry := New(zerolog.Nop(), repo, events.NewBus(events.DefaultBufferSize))
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{Hooks: []store.Hook{registry}})
+
+	_, err := registry.Register(ctx, "config/", []byte(`{"type":"object","required":["port"]}`))
+	require.NoError(t, err)
+	_, err = registry.Register(ctx, "config/db/", []byte(`{
+		"type": "object",
+		"properties": {"host": {"type": "string"}}
+	}`))
+	require.NoError(t, err)
+	_, err = registry.Register(ctx, "bad/", []byte(`{"type":"list"}`))
+	assert.ErrorIs(t, err, ErrInvalidSchema)
+	_, err = registry.Register(ctx, "bad/", []byte(`{`))
+	assert.ErrorIs(t, err, ErrInvalidSchema)
+
+	// The longest prefix chooses the schema, keys under none aren't
+	// validated.
+	require.NoError(t, service.Create(ctx, "config/api", []byte(`{"port":80}`), 0))
+	require.NoError(t, service.Create(ctx, "config/db/main", []byte(`{"host":"db"}`), 0))
+	require.NoError(t, service.Create(ctx, "other", []byte(`not json`), 0))
+
+	err = service.Set(ctx, "config/api", []byte(`{"host":"api"}`), 0)
+	require.ErrorIs(t, err, store.ErrRejected)
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "config/", verr.Prefix)
+	assert.Equal(t, []Violation{{Path: "$", Message: `missing property "port"`}}, verr.Violations)
+	err = service.Create(ctx, "config/db/replica", []byte(`{"host":1}`), 0)
+	require.True(t, errors.As(err, &verr))
+	assert.Equal(t, "config/db/", verr.Prefix)
+	value, err := service.Get(ctx, "config/api")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"port":80}`, string(value))
+
+	regs := registry.List()
+	require.Len(t, regs, 2)
+	assert.Equal(t, "config/", regs[0].Prefix)
+	assert.Equal(t, "config/db/", regs[1].Prefix)
+	b, err := json.Marshal(regs[1].Schema)
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"object","properties":{"host":{"type":"string"}}}`, string(b), "stored compacted")
+
+	// Stored in the repository, hidden from the keys.
+	loaded := New(zerolog.Nop(), repo, nil)
+	require.NoError(t, loaded.Load(ctx))
+	assert.Equal(t, regs, loaded.List())
+	keys, err := service.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, keys, 3)
+
+	require.NoError(t, registry.Unregister(ctx, "config/"))
+	assert.ErrorIs(t, registry.Unregister(ctx, "config/"), ErrNotFound)
+	require.NoError(t, service.Set(ctx, "config/api", []byte(`{"host":"api"}`), 0))
+}
+
+func TestRegistryServe(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(events.DefaultBufferSize)
+	repo := newRepo(t, bus)
+	// Registered by another node, before and after serving.
+	other := New(zerolog.Nop(), repo, bus)
+	_, err := other.Register(ctx, "a/", []byte(`{"type":"string"}`))
+	require.NoError(t, err)
+
+	registry := New(zerolog.Nop(), repo, bus)
+	done := make(chan error)
+	go func() { done <- registry.Serve() }()
+	defer func() {
+		require.NoError(t, registry.Shutdown(ctx))
+		require.NoError(t, <-done)
+	}()
+
+	prefixes := func() []string {
+		var prefixes []string
+		for _, reg := range registry.List() {
+			prefixes = append(prefixes, reg.Prefix)
+		}
+		return prefixes
+	}
+	require.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"a/"}, prefixes()) }, time.Second, 5*time.Millisecond)
+	_, err = other.Register(ctx, "b/", []byte(`{"type":"number"}`))
+	require.NoError(t, err)
+	require.NoError(t, other.Unregister(ctx, "a/"))
+	require.Eventually(t, func() bool { return assert.ObjectsAreEqual([]string{"b/"}, prefixes()) }, time.Second, 5*time.Millisecond)
+
+	_, err = registry.BeforeSet(ctx, "b/1", []byte(`"1"`))
+	assert.Error(t, err)
+	_, err = registry.BeforeSet(ctx, "a/1", []byte(`1`))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,468 @@
+// Package schema validates the values written under a prefix against the
+// JSON Schema registered for it.
+//
+// Schemas support the validation keywords of JSON Schema draft 2020-12 most
+// configuration documents need: type, enum, const, the numeric, string,
+// array and object constraints, and the allOf, anyOf, oneOf and not
+// combinations. References, conditionals and the other keywords are
+// rejected when the schema is compiled rather than ignored, so a schema
+// never validates less than it says. Patterns are RE2 regular expressions.
+//
+// A Registry stores the schemas in the repository under store.SchemaPrefix,
+// so they are replicated and persisted like any key, and every node keeps
+// them in sync from the change bus.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidSchema is returned by Compile for the documents that aren't
+// supported schemas.
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// maxViolations caps the violations reported for a value.
+const maxViolations = 20
+
+// annotations are the keywords that don't constrain values, ignored.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true, "default": true,
+	"examples": true, "format": true, "readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+// types are the values of the type keyword.
+var types = []string{"null", "boolean", "object", "array", "number", "string", "integer"}
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	// raw is the document the schema was compiled from.
+	raw json.RawMessage
+	// always is the result of the boolean schemas, true and false.
+	always *bool
+
+	types                      []string
+	enum                       []string
+	constant                   *string
+	minimum, maximum           *float64
+	exclusiveMin, exclusiveMax *float64
+	minLength, maxLength       *int
+	pattern                    *regexp.Regexp
+	items                      *Schema
+	minItems, maxItems         *int
+	uniqueItems                bool
+	properties                 map[string]*Schema
+	required                   []string
+	additional                 *Schema
+	minProps, maxProps         *int
+	allOf, anyOf, oneOf        []*Schema
+	not                        *Schema
+}
+
+// Compile compiles the JSON Schema document raw.
+func Compile(raw []byte) (*Schema, error) {
+	return compile(raw, "#")
+}
+
+// MarshalJSON returns the document the schema was compiled from.
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return s.raw, nil
+}
+
+// UnmarshalJSON compiles the document of data.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	compiled, err := Compile(bytes.Clone(data))
+	if err != nil {
+		return err
+	}
+	*s = *compiled
+	return nil
+}
+
+func compile(raw json.RawMessage, loc string) (*Schema, error) {
+	s := &Schema{raw: raw}
+	var always bool
+	if err := json.Unmarshal(raw, &always); err == nil {
+		s.always = &always
+		return s, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("%w: %s: expected an object or a boolean", ErrInvalidSchema, loc)
+	}
+
+	// Keywords are compiled in order, so the errors are deterministic.
+	keywords := make([]string, 0, len(doc))
+	for k := range doc {
+		keywords = append(keywords, k)
+	}
+	sort.Strings(keywords)
+	for _, k := range keywords {
+		if err := s.keyword(k, doc[k], loc+"/"+k); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// keyword compiles the keyword k of value v, at loc in the document.
+func (s *Schema) keyword(k string, v json.RawMessage, loc string) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s: %s", ErrInvalidSchema, loc, fmt.Sprintf(format, args...))
+	}
+	var err error
+	switch k {
+	case "type":
+		var one string
+		if json.Unmarshal(v, &one) == nil {
+			s.types = []string{one}
+		} else if json.Unmarshal(v, &s.types) != nil || len(s.types) == 0 {
+			return invalid("expected a type or an array of types")
+		}
+		for _, t := range s.types {
+			if !slices.Contains(types, t) {
+				return invalid("unknown type %q", t)
+			}
+		}
+	case "enum":
+		var values []any
+		if json.Unmarshal(v, &values) != nil || len(values) == 0 {
+			return invalid("expected a non-empty array")
+		}
+		for _, value := range values {
+			s.enum = append(s.enum, canonical(value))
+		}
+	case "const":
+		var value any
+		if err := json.Unmarshal(v, &value); err != nil {
+			return invalid("expected a value")
+		}
+		c := canonical(value)
+		s.constant = &c
+	case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+		n := new(float64)
+		if json.Unmarshal(v, n) != nil {
+			return invalid("expected a number")
+		}
+		switch k {
+		case "minimum":
+			s.minimum = n
+		case "maximum":
+			s.maximum = n
+		case "exclusiveMinimum":
+			s.exclusiveMin = n
+		default:
+			s.exclusiveMax = n
+		}
+	case "minLength", "maxLength", "minItems", "maxItems", "minProperties", "maxProperties":
+		n := new(int)
+		if json.Unmarshal(v, n) != nil || *n < 0 {
+			return invalid("expected a non-negative integer")
+		}
+		switch k {
+		case "minLength":
+			s.minLength = n
+		case "maxLength":
+			s.maxLength = n
+		case "minItems":
+			s.minItems = n
+		case "maxItems":
+			s.maxItems = n
+		case "minProperties":
+			s.minProps = n
+		default:
+			s.maxProps = n
+		}
+	case "pattern":
+		var pattern string
+		if json.Unmarshal(v, &pattern) != nil {
+			return invalid("expected a string")
+		}
+		if s.pattern, err = regexp.Compile(pattern); err != nil {
+			return invalid("%v", err)
+		}
+	case "uniqueItems":
+		if json.Unmarshal(v, &s.uniqueItems) != nil {
+			return invalid("expected a boolean")
+		}
+	case "required":
+		if json.Unmarshal(v, &s.required) != nil {
+			return invalid("expected an array of strings")
+		}
+	case "items":
+		s.items, err = compile(v, loc)
+	case "additionalProperties":
+		s.additional, err = compile(v, loc)
+	case "not":
+		s.not, err = compile(v, loc)
+	case "properties":
+		var props map[string]json.RawMessage
+		if json.Unmarshal(v, &props) != nil {
+			return invalid("expected an object")
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, prop := range props {
+			if s.properties[name], err = compile(prop, loc+"/"+name); err != nil {
+				return err
+			}
+		}
+	case "allOf", "anyOf", "oneOf":
+		var subs []json.RawMessage
+		if json.Unmarshal(v, &subs) != nil || len(subs) == 0 {
+			return invalid("expected a non-empty array of schemas")
+		}
+		compiled := make([]*Schema, len(subs))
+		for i, sub := range subs {
+			if compiled[i], err = compile(sub, loc+"/"+strconv.Itoa(i)); err != nil {
+				return err
+			}
+		}
+		switch k {
+		case "allOf":
+			s.allOf = compiled
+		case "anyOf":
+			s.anyOf = compiled
+		default:
+			s.oneOf = compiled
+		}
+	default:
+		if !annotations[k] {
+			return invalid("unsupported keyword")
+		}
+	}
+	return err
+}
+
+// Violation is a part of a value that doesn't match its schema.
+type Violation struct {
+	// Path is the JSONPath of the part, $ for the whole value.
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// ValidationError is returned for the values that don't match their
+// schema.
+type ValidationError struct {
+	// Prefix is the prefix the schema is registered for.
+	Prefix     string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.String()
+	}
+	return fmt.Sprintf("value doesn't match the schema of prefix %q: %s", e.Prefix, strings.Join(msgs, "; "))
+}
+
+// Validate reports with a *ValidationError the parts of the JSON value
+// that don't match the schema.
+func (s *Schema) Validate(value []byte) error {
+	var v any
+	if err := json.Unmarshal(value, &v); err != nil {
+		return &ValidationError{Violations: []Violation{{Path: "$", Message: "invalid JSON"}}}
+	}
+	var violations []Violation
+	s.validate(v, "$", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: violations}
+}
+
+// valid reports whether v matches the schema.
+func (s *Schema) valid(v any) bool {
+	var violations []Violation
+	s.validate(v, "$", &violations)
+	return len(violations) == 0
+}
+
+// validate appends to out the violations of v, at path.
+func (s *Schema) validate(v any, path string, out *[]Violation) {
+	add := func(format string, args ...any) {
+		if len(*out) < maxViolations {
+			*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	if s.always != nil {
+		if !*s.always {
+			add("no value is allowed")
+		}
+		return
+	}
+	if len(s.types) > 0 && !slices.ContainsFunc(s.types, func(t string) bool { return hasType(v, t) }) {
+		add("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		return
+	}
+	if s.enum != nil && !slices.Contains(s.enum, canonical(v)) {
+		add("expected one of %s", strings.Join(s.enum, ", "))
+	}
+	if s.constant != nil && canonical(v) != *s.constant {
+		add("expected %s", *s.constant)
+	}
+
+	switch v := v.(type) {
+	case float64:
+		switch {
+		case s.minimum != nil && v < *s.minimum:
+			add("%s is less than the minimum %s", number(v), number(*s.minimum))
+		case s.exclusiveMin != nil && v <= *s.exclusiveMin:
+			add("%s is not greater than %s", number(v), number(*s.exclusiveMin))
+		}
+		switch {
+		case s.maximum != nil && v > *s.maximum:
+			add("%s is greater than the maximum %s", number(v), number(*s.maximum))
+		case s.exclusiveMax != nil && v >= *s.exclusiveMax:
+			add("%s is not less than %s", number(v), number(*s.exclusiveMax))
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			add("length %d is less than %d", n, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			add("length %d is greater than %d", n, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			add("doesn't match the pattern %q", s.pattern)
+		}
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			add("%d items, less than %d", len(v), *s.minItems)
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			add("%d items, more than %d", len(v), *s.maxItems)
+		}
+		if s.uniqueItems {
+			seen := make(map[string]bool, len(v))
+			for _, item := range v {
+				c := canonical(item)
+				if seen[c] {
+					add("duplicate item %s", c)
+					break
+				}
+				seen[c] = true
+			}
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, fmt.Sprintf("%s[%d]", path, i), out)
+			}
+		}
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				add("missing property %q", name)
+			}
+		}
+		if s.minProps != nil && len(v) < *s.minProps {
+			add("%d properties, less than %d", len(v), *s.minProps)
+		}
+		if s.maxProps != nil && len(v) > *s.maxProps {
+			add("%d properties, more than %d", len(v), *s.maxProps)
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if prop, ok := s.properties[name]; ok {
+				prop.validate(v[name], member(path, name), out)
+			} else if s.additional != nil {
+				s.additional.validate(v[name], member(path, name), out)
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, out)
+	}
+	if s.anyOf != nil && !slices.ContainsFunc(s.anyOf, func(sub *Schema) bool { return sub.valid(v) }) {
+		add("doesn't match any of the anyOf schemas")
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.valid(v) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			add("matches %d of the oneOf schemas, expected 1", matched)
+		}
+	}
+	if s.not != nil && s.not.valid(v) {
+		add("matches the not schema")
+	}
+}
+
+// hasType reports whether v is of the JSON Schema type t.
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == math.Trunc(f)
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	}
+	return typeOf(v) == t
+}
+
+// typeOf returns the JSON Schema type of v, integer for the whole numbers.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	}
+	return "object"
+}
+
+// canonical returns the JSON encoding of v with sorted object members, for
+// equality.
+func canonical(v any) string {
+	// Decoded values always marshal.
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// identRE matches the member names written as .name in paths.
+var identRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// member returns the path of the member name of the object at path.
+func member(path, name string) string {
+	if identRE.MatchString(name) {
+		return path + "." + name
+	}
+	return path + "['" + strings.ReplaceAll(strings.ReplaceAll(name, `\`, `\\`), "'", `\'`) + "']"
+}
@@ -0,0 +1,94 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompile(t *testing.T) {
+	for _, raw := range []string{
+		`true`,
+		`{}`,
+		`{"$schema":"https://json-schema.org/draft/2020-12/schema","title":"config","type":"object"}`,
+		`{"type":["string","null"],"maxLength":3}`,
+		`{"properties":{"a":{"items":{"enum":[1,2]}}},"additionalProperties":false}`,
+	} {
+		_, err := Compile([]byte(raw))
+		assert.NoError(t, err, raw)
+	}
+
+	for raw, want := range map[string]string{
+		`[]`:                             "invalid schema: #: expected an object or a boolean",
+		`{"$ref":"#/defs/a"}`:            "invalid schema: #/$ref: unsupported keyword",
+		`{"type":"str"}`:                 "invalid schema: #/type: unknown type \"str\"",
+		`{"minLength":-1}`:               "invalid schema: #/minLength: expected a non-negative integer",
+		`{"pattern":"("}`:                "invalid schema: #/pattern: error parsing regexp: missing closing ): `(`",
+		`{"properties":{"a":{"if":{}}}}`: "invalid schema: #/properties/a/if: unsupported keyword",
+		`{"anyOf":[]}`:                   "invalid schema: #/anyOf: expected a non-empty array of schemas",
+		`{"required":"a"}`:               "invalid schema: #/required: expected an array of strings",
+	} {
+		_, err := Compile([]byte(raw))
+		require.ErrorIs(t, err, ErrInvalidSchema, raw)
+		assert.EqualError(t, err, want, raw)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(`{
+		"type": "object",
+		"required": ["name", "port"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"port": {"type": "integer", "minimum": 1, "maximum": 65535},
+			"tags": {"type": "array", "items": {"type": "string"}, "uniqueItems": true},
+			"mode": {"enum": ["a", "b"]},
+			"limit": {"oneOf": [{"type": "null"}, {"type": "number", "exclusiveMinimum": 0}]}
+		}
+	}`))
+	require.NoError(t, err)
+
+	assert.NoError(t, s.Validate([]byte(`{"name":"api","port":8080,"tags":["x","y"],"mode":"a","limit":null}`)))
+	assert.NoError(t, s.Validate([]byte(`{"name":"api","port":8080.0,"limit":1.5}`)))
+
+	for value, want := range map[string][]Violation{
+		`{"name":"api"`: {{Path: "$", Message: "invalid JSON"}},
+		`[]`:            {{Path: "$", Message: "expected object, got array"}},
+		`{"name":"API","port":0}`: {
+			{Path: "$.name", Message: "doesn't match the pattern \"^[a-z]+$\""},
+			{Path: "$.port", Message: "0 is less than the minimum 1"},
+		},
+		`{"name":"api","port":1.5,"tags":["x","x"],"extra":1}`: {
+			{Path: "$.extra", Message: "no value is allowed"},
+			{Path: "$.port", Message: "expected integer, got number"},
+			{Path: "$.tags", Message: "duplicate item \"x\""},
+		},
+		`{"port":1,"tags":[1],"mode":"c","limit":0}`: {
+			{Path: "$", Message: "missing property \"name\""},
+			{Path: "$.limit", Message: "matches 0 of the oneOf schemas, expected 1"},
+			{Path: "$.mode", Message: "expected one of \"a\", \"b\""},
+			{Path: "$.tags[0]", Message: "expected string, got integer"},
+		},
+	} {
+		err := s.Validate([]byte(value))
+		var verr *ValidationError
+		require.True(t, errors.As(err, &verr), value)
+		assert.Equal(t, want, verr.Violations, value)
+	}
+}
+
+func TestSchemaJSON(t *testing.T) {
+	raw := `{"type":"object","required":["a"]}`
+	var s Schema
+	require.NoError(t, json.Unmarshal([]byte(raw), &s))
+	b, err := json.Marshal(&s)
+	require.NoError(t, err)
+	assert.JSONEq(t, raw, string(b))
+	assert.Error(t, s.Validate([]byte(`{}`)))
+
+	assert.ErrorIs(t, json.Unmarshal([]byte(`{"type":1}`), &s), ErrInvalidSchema)
+}
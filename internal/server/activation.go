@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket
+// activation.
+const listenFDsStart = 3
+
+// activatedListeners returns the listeners passed by systemd socket
+// activation, nil when the process wasn't socket activated. The sockets
+// are opened by systemd, which keeps them open across restarts and may
+// bind privileged ports for an unprivileged service. The environment is
+// cleared so child processes don't take over the sockets.
+func activatedListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		_ = os.Unsetenv(name)
+	}
+	return fileListeners(listenFDsStart, n)
+}
+
+// fileListeners returns the listeners of the n file descriptors from
+// first. Listeners opened before a failure are closed.
+func fileListeners(first, n int) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for fd := first; fd < first+n; fd++ {
+		f := os.NewFile(uintptr(fd), "listen-fd-"+strconv.Itoa(fd))
+		// FileListener duplicates the descriptor, close-on-exec.
+		lis, err := net.FileListener(f)
+		_ = f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("socket activation fd %d: %w", fd, err)
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// HTTPServer is a Service serving a handler on its own address, for HTTP
+// listeners firewalled independently from the API, such as the admin
+// listener. It has no write timeout, so responses such as CPU profiles or
+// backups may stream for as long as they need.
+type HTTPServer struct {
+	logger  zerolog.Logger
+	name    string
+	address string
+	srv     *http.Server
+
+	mu       sync.Mutex
+	shutdown bool
+}
+
+// NewHTTPServer returns an HTTPServer serving handler on address.
+func NewHTTPServer(log zerolog.Logger, name, address string, handler http.Handler) *HTTPServer {
+	return &HTTPServer{
+		logger:  log,
+		name:    name,
+		address: address,
+		srv: &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// Name implements Service.
+func (s *HTTPServer) Name() string {
+	return s.name
+}
+
+// Serve implements Service.
+func (s *HTTPServer) Serve() error {
+	lis, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(lis)
+}
+
+// ServeListener serves requests on lis until the server is shut down.
+func (s *HTTPServer) ServeListener(lis net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return lis.Close()
+	}
+	s.mu.Unlock()
+
+	s.logger.Info().Msgf("%s server listening on %q", s.name, lis.Addr().String())
+	if err := s.srv.Serve(lis); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown implements Service.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	s.mu.Unlock()
+
+	if err := s.srv.Shutdown(ctx); err != nil {
+		_ = s.srv.Close()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// newHTTP3Server returns an HTTP/3 server sharing the handler and the
+// certificates of the HTTPS server.
+func (s *Server) newHTTP3Server(tlsConfig *tls.Config) *http3.Server {
+	return &http3.Server{
+		Handler:        s.handler,
+		TLSConfig:      http3.ConfigureTLSConfig(tlsConfig),
+		IdleTimeout:    s.config.HTTP3IdleTimeout,
+		MaxHeaderBytes: s.config.MaxHeaderBytes,
+	}
+}
+
+// advertiseHTTP3 sets the Alt-Svc header on responses of next, so clients
+// reaching the server over TCP can switch to HTTP/3.
+func advertiseHTTP3(h3 *http3.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The header is only missing before the listener is up.
+		_ = h3.SetQUICHeaders(w.Header())
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP3(t *testing.T) {
+	certFile, keyFile, pool := writeCert(t, t.TempDir())
+	tlsConfig, err := TLSConfig{CertFile: certFile, KeyFile: keyFile}.build()
+	require.NoError(t, err)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+	s := New(zerolog.Nop(), Config{}, handler)
+	h3 := s.newHTTP3Server(tlsConfig)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() { _ = h3.Serve(conn) }()
+	t.Cleanup(func() { _ = h3.Close() })
+
+	t.Run("serves http/3", func(t *testing.T) {
+		transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		t.Cleanup(func() { _ = transport.Close() })
+
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + conn.LocalAddr().String())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, 3, resp.ProtoMajor)
+	})
+
+	t.Run("advertises http/3", func(t *testing.T) {
+		_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		advertiseHTTP3(h3, handler).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Contains(t, rec.Header().Get("Alt-Svc"), `h3=":`+port+`"`)
+	})
+}
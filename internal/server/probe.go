@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Probe requests path from the server configured by config, on its first
+// listen address, and fails unless it answers with a 2xx status. It is run
+// next to the server, so unspecified hosts are reached on the loopback
+// interface and HTTPS certificates aren't verified: they are issued for the
+// public names of the server.
+func Probe(ctx context.Context, config Config, path string) error {
+	address := strings.TrimSpace(strings.Split(config.Address, ",")[0])
+
+	transport := &http.Transport{}
+	host := address
+	if socket, ok := strings.CutPrefix(address, unixScheme); ok {
+		host = "localhost"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		}
+	} else if h, port, err := net.SplitHostPort(address); err == nil {
+		if ip := net.ParseIP(h); h == "" || ip != nil && ip.IsUnspecified() {
+			host = net.JoinHostPort("localhost", port)
+		}
+	}
+
+	scheme := "http"
+	if config.TLS.enabled() {
+		scheme = "https"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+path, nil)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	// Responses of the API carry a message explaining the status.
+	var body struct {
+		Message string `json:"message"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err == nil && body.Message != "" {
+		return fmt.Errorf("%s: %s", resp.Status, body.Message)
+	}
+	return errors.New(resp.Status)
+}
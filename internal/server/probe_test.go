@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbe(t *testing.T) {
+	handler := http.NewServeMux()
+	handler.HandleFunc("/healthy", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler.HandleFunc("/unhealthy", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"message":"no leader","status_code":1012}`))
+	})
+	ctx := context.Background()
+
+	t.Run("unspecified host", func(t *testing.T) {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+		_, port, err := net.SplitHostPort(srv.Listener.Addr().String())
+		require.NoError(t, err)
+		cfg := Config{Address: "0.0.0.0:" + port + ",unix:///unused.sock"}
+
+		assert.NoError(t, Probe(ctx, cfg, "/healthy"))
+		assert.EqualError(t, Probe(ctx, cfg, "/unhealthy"), "503 Service Unavailable: no leader")
+		assert.EqualError(t, Probe(ctx, cfg, "/missing"), "404 Not Found")
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "kv.sock")
+		lis, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		srv := httptest.NewUnstartedServer(handler)
+		srv.Listener = lis
+		srv.Start()
+		defer srv.Close()
+
+		assert.NoError(t, Probe(ctx, Config{Address: "unix://" + socket}, "/healthy"))
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		srv := httptest.NewTLSServer(handler)
+		defer srv.Close()
+		cfg := Config{Address: srv.Listener.Addr().String(), TLS: TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"}}
+
+		assert.NoError(t, Probe(ctx, cfg, "/healthy"))
+	})
+
+	t.Run("not listening", func(t *testing.T) {
+		assert.Error(t, Probe(ctx, Config{Address: "unix://" + filepath.Join(t.TempDir(), "none.sock")}, "/healthy"))
+	})
+}
@@ -5,39 +5,86 @@
 // customizable configuration through environment variables.
 //
 // The Config struct holds the configuration settings for the HTTP server, such as the address, read
-// and write timeouts, and shutdown timeout, all of which can be set through environment variables.
+// and write timeouts, shutdown timeout, and TLS certificates, all of which can be set through
+// environment variables. With TLS configured the server serves HTTPS and negotiates HTTP/2, and
+// can additionally serve HTTP/3 over QUIC through the same handler.
 //
 // The New function initializes and returns a new instance of the Server with the provided logger,
 // configuration, and HTTP handler. The Run method starts the HTTP server and handles graceful shutdowns
-// in response to system signals.
+// in response to system signals: it stops accepting requests and drains those in flight, then runs
+// the flush hooks persisting the data before exit, both within the shutdown timeout. Additional listeners, such as the gRPC API, can be registered as
+// Services to be started and shut down together with the HTTP server. TCPServer implements a Service
+// for the plain TCP protocols, such as the Redis and memcached listeners, and HTTPServer one serving
+// another handler on its own address, such as the admin listener.
 package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/quic-go/quic-go/http3"
 	"github.com/rs/zerolog"
 )
 
 type (
 	// Server encapsulates the details of an HTTP server.
 	Server struct {
-		logger  zerolog.Logger
-		config  Config
-		handler http.Handler
+		logger   zerolog.Logger
+		config   Config
+		handler  http.Handler
+		services []Service
+		flushes  []flush
+	}
+
+	// flush is a hook run once the server stopped serving requests.
+	flush struct {
+		name string
+		fn   func(ctx context.Context) error
+	}
+
+	// Service is a listener served alongside the HTTP server.
+	Service interface {
+		// Name identifies the service in logs and errors.
+		Name() string
+		// Serve blocks serving requests until the service is shut down.
+		Serve() error
+		// Shutdown stops the service gracefully, or forcibly once ctx is done.
+		Shutdown(ctx context.Context) error
 	}
 
 	// Config holds the configuration settings for the HTTP Server.
 	Config struct {
-		Address         string        `envconfig:"ADDRESS" default:"0.0.0.0:8000"`
+		// Address is a comma-separated list of addresses to listen on, each
+		// either a TCP host:port or a unix:///path/to/socket domain socket.
+		Address string `envconfig:"ADDRESS" default:"0.0.0.0:8000"`
+		// UnixSocketMode is the octal file mode of domain sockets.
+		UnixSocketMode  string        `envconfig:"UNIX_SOCKET_MODE" default:"0660"`
 		ReadTimeout     time.Duration `envconfig:"READ_TIMEOUT" default:"5s"`
 		WriteTimeout    time.Duration `envconfig:"WRITE_TIMEOUT" default:"5s"`
 		ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"5s"`
+		// ReadHeaderTimeout bounds the time to read the headers of a
+		// request, so clients sending them slowly can't hold connections.
+		ReadHeaderTimeout time.Duration `envconfig:"READ_HEADER_TIMEOUT" default:"2s"`
+		// IdleTimeout closes keep-alive connections idle for longer.
+		IdleTimeout time.Duration `envconfig:"IDLE_TIMEOUT" default:"60s"`
+		// MaxHeaderBytes bounds the size of the headers of a request.
+		MaxHeaderBytes int `envconfig:"MAX_HEADER_BYTES" default:"65536"`
+		// TLS enables HTTPS, with HTTP/2, on every listen address.
+		TLS TLSConfig `envconfig:"TLS"`
+		// HTTP3Address is the UDP address of the HTTP/3 (QUIC) listener,
+		// empty disables it. HTTP/3 requires TLS.
+		HTTP3Address     string        `envconfig:"HTTP3_ADDRESS"`
+		HTTP3IdleTimeout time.Duration `envconfig:"HTTP3_IDLE_TIMEOUT" default:"30s"`
 	}
 )
 
@@ -50,25 +97,94 @@ func New(log zerolog.Logger, config Config, handler http.Handler) *Server {
 	}
 }
 
+// Register adds services to be started by Run and shut down with the server.
+func (s *Server) Register(services ...Service) {
+	s.services = append(s.services, services...)
+}
+
+// OnShutdown registers a hook run on shutdown once the server and its
+// services drained their requests, such as flushing the repository to disk.
+// Hooks run in registration order, every hook running even if a previous
+// one failed.
+func (s *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	s.flushes = append(s.flushes, flush{name: name, fn: fn})
+}
+
 // Run will start the HTTP Server and will handle shutdowns gracefully.
-// TODO: Add shutdown hook for repository store Close().
 func (s *Server) Run() error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(shutdown)
+	return s.run(shutdown)
+}
 
+// run serves until an error or a signal received from shutdown.
+func (s *Server) run(shutdown <-chan os.Signal) error {
 	api := &http.Server{
-		Addr:         s.config.Address,
-		Handler:      s.handler,
-		ReadTimeout:  s.config.ReadTimeout,
-		WriteTimeout: s.config.WriteTimeout,
+		Handler:           s.handler,
+		ReadTimeout:       s.config.ReadTimeout,
+		ReadHeaderTimeout: s.config.ReadHeaderTimeout,
+		WriteTimeout:      s.config.WriteTimeout,
+		IdleTimeout:       s.config.IdleTimeout,
+		MaxHeaderBytes:    s.config.MaxHeaderBytes,
+	}
+
+	if s.config.TLS.enabled() {
+		tlsConfig, err := s.config.TLS.build()
+		if err != nil {
+			return fmt.Errorf("server failed to configure tls: %w", err)
+		}
+		api.TLSConfig = tlsConfig
 	}
 
-	serverErrors := make(chan error, 1)
+	var h3 *http3.Server
+	var h3Conn net.PacketConn
+	if s.config.HTTP3Address != "" {
+		if api.TLSConfig == nil {
+			return errors.New("server failed to configure http/3: tls is required")
+		}
+		conn, err := net.ListenPacket("udp", s.config.HTTP3Address)
+		if err != nil {
+			return fmt.Errorf("server failed to listen for http/3: %w", err)
+		}
+		h3, h3Conn = s.newHTTP3Server(api.TLSConfig), conn
+		defer h3Conn.Close()
+		api.Handler = advertiseHTTP3(h3, api.Handler)
+	}
+
+	listeners, err := s.listen()
+	if err != nil {
+		return fmt.Errorf("server failed to listen: %w", err)
+	}
+
+	serverErrors := make(chan error, len(listeners)+len(s.services)+1)
 
-	go func() {
-		s.logger.Info().Msgf("server listening on port %q", api.Addr)
-		serverErrors <- api.ListenAndServe()
-	}()
+	if h3 != nil {
+		go func() {
+			s.logger.Info().Msgf("server listening for http/3 on udp %q", h3Conn.LocalAddr().String())
+			serverErrors <- fmt.Errorf("http/3: %w", h3.Serve(h3Conn))
+		}()
+	}
+
+	for _, lis := range listeners {
+		go func() {
+			if api.TLSConfig != nil {
+				s.logger.Info().Msgf("server listening with tls on %s %q", lis.Addr().Network(), lis.Addr().String())
+				serverErrors <- api.ServeTLS(lis, "", "")
+				return
+			}
+			s.logger.Info().Msgf("server listening on %s %q", lis.Addr().Network(), lis.Addr().String())
+			serverErrors <- api.Serve(lis)
+		}()
+	}
+
+	for _, svc := range s.services {
+		go func() {
+			if err := svc.Serve(); err != nil {
+				serverErrors <- fmt.Errorf("%s: %w", svc.Name(), err)
+			}
+		}()
+	}
 
 	select {
 	case err := <-serverErrors:
@@ -76,14 +192,116 @@ func (s *Server) Run() error {
 	case sig := <-shutdown:
 		s.logger.Info().Msgf("server shutting down after receiving %+v", sig)
 
+		// The timeout covers draining the requests and flushing the data.
 		ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 		defer cancel()
 
+		start := time.Now()
+		errs := s.drain(ctx, api, h3)
+		s.logger.Info().Dur("duration", time.Since(start)).Msg("server drained requests")
+		for _, f := range s.flushes {
+			if err := f.fn(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s failed to flush: %w", f.name, err))
+			}
+		}
+		s.logger.Info().Dur("duration", time.Since(start)).Msg("server shut down")
+		return errors.Join(errs...)
+	}
+}
+
+// drain stops the HTTP servers and the services from accepting requests
+// and waits for those in flight, all at once so a slow one doesn't use up
+// the time of the others.
+func (s *Server) drain(ctx context.Context, api *http.Server, h3 *http3.Server) []error {
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	shutdown := func(name string, fn func(ctx context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(ctx); err != nil {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s failed to shutdown gracefully: %w", name, err))
+				mu.Unlock()
+			}
+		}()
+	}
+
+	shutdown("server", func(ctx context.Context) error {
 		if err := api.Shutdown(ctx); err != nil {
 			_ = api.Close()
-			return fmt.Errorf("server failed to shutdown gracefully: %w", err)
+			return err
+		}
+		return nil
+	})
+	if h3 != nil {
+		shutdown("http/3 server", h3.Shutdown)
+	}
+	for _, svc := range s.services {
+		shutdown(svc.Name(), svc.Shutdown)
+	}
+	wg.Wait()
+	return errs
+}
+
+// unixScheme prefixes the addresses of domain sockets.
+const unixScheme = "unix://"
+
+// listen opens a listener for every configured address, unless the process
+// was socket activated: the sockets passed by systemd are served instead.
+// Listeners opened before a failure are closed.
+func (s *Server) listen() ([]net.Listener, error) {
+	listeners, err := activatedListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) > 0 {
+		s.logger.Info().Int("sockets", len(listeners)).Msg("server socket activated, ignoring the listen addresses")
+		return listeners, nil
+	}
+
+	for _, address := range strings.Split(s.config.Address, ",") {
+		lis, err := s.listenOn(strings.TrimSpace(address))
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, lis)
+	}
+	return listeners, nil
+}
+
+func (s *Server) listenOn(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, unixScheme)
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	mode, err := strconv.ParseUint(s.config.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unix socket mode %q: %w", s.config.UnixSocketMode, err)
+	}
+
+	// A socket left behind by a previous run that didn't shut down cleanly
+	// would make the listen fail, other files are left alone.
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	lis, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, os.FileMode(mode)); err != nil {
+		_ = lis.Close()
+		return nil, err
+	}
+	return lis, nil
 }
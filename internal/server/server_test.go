@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerListen(t *testing.T) {
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "kv.sock")
+
+	t.Run("tcp and unix", func(t *testing.T) {
+		s := New(zerolog.Nop(), Config{Address: "127.0.0.1:0, unix://" + socket, UnixSocketMode: "0600"}, http.NotFoundHandler())
+		listeners, err := s.listen()
+		require.NoError(t, err)
+		require.Len(t, listeners, 2)
+		defer func() {
+			for _, lis := range listeners {
+				_ = lis.Close()
+			}
+		}()
+
+		assert.Equal(t, "tcp", listeners[0].Addr().Network())
+		assert.Equal(t, "unix", listeners[1].Addr().Network())
+
+		info, err := os.Stat(socket)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+		conn, err := net.Dial("unix", socket)
+		require.NoError(t, err)
+		_ = conn.Close()
+	})
+
+	t.Run("replaces stale socket", func(t *testing.T) {
+		stale, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		// Keep the file around as if the process had crashed.
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, stale.Close())
+
+		s := New(zerolog.Nop(), Config{Address: "unix://" + socket, UnixSocketMode: "0660"}, http.NotFoundHandler())
+		listeners, err := s.listen()
+		require.NoError(t, err)
+		_ = listeners[0].Close()
+	})
+
+	t.Run("keeps regular files", func(t *testing.T) {
+		file := filepath.Join(dir, "file")
+		require.NoError(t, os.WriteFile(file, []byte("data"), 0o600))
+
+		s := New(zerolog.Nop(), Config{Address: "unix://" + file, UnixSocketMode: "0660"}, http.NotFoundHandler())
+		_, err := s.listen()
+		assert.Error(t, err)
+
+		data, err := os.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+	})
+
+	t.Run("invalid mode closes opened listeners", func(t *testing.T) {
+		s := New(zerolog.Nop(), Config{Address: "127.0.0.1:0,unix://" + socket, UnixSocketMode: "rw"}, http.NotFoundHandler())
+		_, err := s.listen()
+		assert.ErrorContains(t, err, `invalid unix socket mode "rw"`)
+	})
+}
+
+func TestSocketActivation(t *testing.T) {
+	t.Run("not activated", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+		t.Setenv("LISTEN_FDS", "1")
+		listeners, err := activatedListeners()
+		require.NoError(t, err)
+		assert.Nil(t, listeners)
+		assert.Equal(t, "1", os.Getenv("LISTEN_FDS"), "the environment of other processes is kept")
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "many")
+		_, err := activatedListeners()
+		assert.ErrorContains(t, err, `invalid LISTEN_FDS "many"`)
+	})
+
+	t.Run("file listeners", func(t *testing.T) {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer lis.Close()
+		f, err := lis.(*net.TCPListener).File()
+		require.NoError(t, err)
+
+		listeners, err := fileListeners(int(f.Fd()), 1)
+		require.NoError(t, err)
+		require.Len(t, listeners, 1)
+		defer listeners[0].Close()
+		assert.Equal(t, lis.Addr().String(), listeners[0].Addr().String())
+
+		conn, err := net.Dial("tcp", listeners[0].Addr().String())
+		require.NoError(t, err)
+		_ = conn.Close()
+	})
+}
+
+func TestServerShutdown(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "kv.sock")
+	entered, release := make(chan struct{}), make(chan struct{})
+	var served atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		served.Store(true)
+		_, _ = w.Write([]byte("done"))
+	})
+	s := New(zerolog.Nop(), Config{Address: "unix://" + socket, UnixSocketMode: "0600", ShutdownTimeout: 5 * time.Second}, handler)
+
+	var flushed []string
+	for _, name := range []string{"raft", "repository"} {
+		s.OnShutdown(name, func(ctx context.Context) error {
+			_, hasDeadline := ctx.Deadline()
+			assert.True(t, hasDeadline)
+			assert.True(t, served.Load(), "flushed before the request was drained")
+			flushed = append(flushed, name)
+			return nil
+		})
+	}
+
+	shutdown := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- s.run(shutdown) }()
+
+	client := unixClient(t, socket)
+	body := make(chan string, 1)
+	go func() {
+		resp, err := client.Get("http://kv/")
+		if !assert.NoError(t, err) {
+			close(entered)
+			body <- ""
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+
+	<-entered
+	shutdown <- syscall.SIGTERM
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, "done", <-body, "in-flight requests are drained")
+	require.NoError(t, <-done)
+	assert.Equal(t, []string{"raft", "repository"}, flushed)
+}
+
+func TestServerLimits(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "kv.sock")
+	s := New(zerolog.Nop(), Config{
+		Address:         "unix://" + socket,
+		UnixSocketMode:  "0600",
+		ShutdownTimeout: time.Second,
+		MaxHeaderBytes:  1024,
+	}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	shutdown := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() { done <- s.run(shutdown) }()
+	defer func() {
+		shutdown <- syscall.SIGTERM
+		assert.NoError(t, <-done)
+	}()
+
+	client := unixClient(t, socket)
+	req, err := http.NewRequest(http.MethodGet, "http://kv/", nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Padding", strings.Repeat("a", 8192))
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+}
+
+// unixClient returns a client of the server listening on socket, once it
+// is.
+func unixClient(t *testing.T, socket string) *http.Client {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socket)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+// TCPServer is a Service accepting connections for a request/reply
+// protocol, such as the Redis and memcached listeners. Each connection is
+// served by its own goroutine running the handler.
+type TCPServer struct {
+	logger  zerolog.Logger
+	name    string
+	address string
+	handle  func(ctx context.Context, c *Conn)
+
+	// ctx is canceled when the server is forcibly shut down, aborting
+	// in-flight requests.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	lis      net.Listener
+	conns    map[*Conn]struct{}
+	shutdown bool
+	wg       sync.WaitGroup
+}
+
+// Conn is a client connection of a TCPServer. Handlers mark the requests
+// they execute with Begin and End, so shutdown closes idle connections
+// right away and busy ones once their reply is written.
+type Conn struct {
+	net.Conn
+	srv  *TCPServer
+	busy bool
+}
+
+// NewTCPServer returns a TCP server listening on address, calling handle
+// for every accepted connection. The connection is closed when handle
+// returns.
+func NewTCPServer(log zerolog.Logger, name, address string, handle func(ctx context.Context, c *Conn)) *TCPServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &TCPServer{
+		logger:  log,
+		name:    name,
+		address: address,
+		handle:  handle,
+		ctx:     ctx,
+		cancel:  cancel,
+		conns:   make(map[*Conn]struct{}),
+	}
+}
+
+// Name implements Service.
+func (s *TCPServer) Name() string {
+	return s.name
+}
+
+// Serve implements Service.
+func (s *TCPServer) Serve() error {
+	lis, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(lis)
+}
+
+// ServeListener accepts connections on lis until the server is shut down.
+func (s *TCPServer) ServeListener(lis net.Listener) error {
+	s.mu.Lock()
+	if s.shutdown {
+		s.mu.Unlock()
+		return lis.Close()
+	}
+	s.lis = lis
+	s.mu.Unlock()
+
+	s.logger.Info().Msgf("%s server listening on %q", s.name, lis.Addr().String())
+	for {
+		nc, err := lis.Accept()
+		if err != nil {
+			if s.closing() {
+				return nil
+			}
+			return err
+		}
+
+		c := &Conn{Conn: nc, srv: s}
+		if !s.track(c) {
+			_ = nc.Close()
+			return nil
+		}
+		go s.serveConn(c)
+	}
+}
+
+// Shutdown implements Service.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	if s.lis != nil {
+		_ = s.lis.Close()
+	}
+	for c := range s.conns {
+		if !c.busy {
+			_ = c.Close()
+		}
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		s.mu.Lock()
+		for c := range s.conns {
+			_ = c.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (s *TCPServer) closing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+func (s *TCPServer) track(c *Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *TCPServer) serveConn(c *Conn) {
+	defer func() {
+		_ = c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	s.handle(s.ctx, c)
+}
+
+// Begin marks the connection as executing a request. It reports false when
+// the server is shutting down, in which case the handler must return
+// without executing it.
+func (c *Conn) Begin() bool {
+	return c.setBusy(true)
+}
+
+// End marks the connection as idle after the reply to a request is
+// written. It reports false when the server is shutting down, in which
+// case the handler must return.
+func (c *Conn) End() bool {
+	return c.setBusy(false)
+}
+
+func (c *Conn) setBusy(busy bool) bool {
+	c.srv.mu.Lock()
+	defer c.srv.mu.Unlock()
+	c.busy = busy
+	return !c.srv.shutdown
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig holds the HTTPS settings of the server. Either a certificate
+// and key pair or auto-TLS domains may be configured, not both.
+type TLSConfig struct {
+	// CertFile and KeyFile are the PEM encoded certificate chain and
+	// private key served over HTTPS.
+	CertFile string `envconfig:"CERT_FILE"`
+	KeyFile  string `envconfig:"KEY_FILE"`
+	// AutoDomains are the domains to obtain certificates for from Let's
+	// Encrypt, through the TLS-ALPN-01 challenge, so the server must be
+	// reachable on port 443 of these domains.
+	AutoDomains []string `envconfig:"AUTO_DOMAINS"`
+	// AutoCacheDir is where obtained certificates are stored across
+	// restarts.
+	AutoCacheDir string `envconfig:"AUTO_CACHE_DIR" default:"certs"`
+	// AutoEmail is the optional contact address of the ACME account.
+	AutoEmail string `envconfig:"AUTO_EMAIL"`
+}
+
+// enabled reports whether the server serves HTTPS.
+func (c TLSConfig) enabled() bool {
+	return c.CertFile != "" || c.KeyFile != "" || len(c.AutoDomains) > 0
+}
+
+// build returns the TLS configuration of the server. HTTP/2 is negotiated
+// through ALPN by the HTTP server.
+func (c TLSConfig) build() (*tls.Config, error) {
+	static := c.CertFile != "" || c.KeyFile != ""
+	switch {
+	case static && len(c.AutoDomains) > 0:
+		return nil, errors.New("certificate files and auto-TLS domains are mutually exclusive")
+	case static && (c.CertFile == "" || c.KeyFile == ""):
+		return nil, errors.New("both a certificate and a key file are required")
+	case static:
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	default:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.AutoDomains...),
+			Cache:      autocert.DirCache(c.AutoCacheDir),
+			Email:      c.AutoEmail,
+		}
+		cfg := manager.TLSConfig()
+		cfg.MinVersion = tls.VersionTLS12
+		return cfg, nil
+	}
+}
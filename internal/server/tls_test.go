@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCert writes the certificate and key of a test server to dir.
+func writeCert(t *testing.T, dir string) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	cert := srv.TLS.Certificates[0]
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600))
+
+	pool = x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return certFile, keyFile, pool
+}
+
+func TestTLSConfigBuild(t *testing.T) {
+	certFile, keyFile, _ := writeCert(t, t.TempDir())
+
+	tests := []struct {
+		name    string
+		config  TLSConfig
+		wantErr string
+	}{
+		{name: "cert and key", config: TLSConfig{CertFile: certFile, KeyFile: keyFile}},
+		{name: "auto tls", config: TLSConfig{AutoDomains: []string{"kv.example.com"}, AutoCacheDir: t.TempDir()}},
+		{name: "missing key", config: TLSConfig{CertFile: certFile}, wantErr: "both a certificate and a key file are required"},
+		{name: "missing cert", config: TLSConfig{KeyFile: keyFile}, wantErr: "both a certificate and a key file are required"},
+		{
+			name:    "cert and auto tls",
+			config:  TLSConfig{CertFile: certFile, KeyFile: keyFile, AutoDomains: []string{"kv.example.com"}},
+			wantErr: "mutually exclusive",
+		},
+		{name: "unreadable cert", config: TLSConfig{CertFile: keyFile, KeyFile: keyFile}, wantErr: "failed to load certificate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.True(t, tt.config.enabled())
+			cfg, err := tt.config.build()
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+		})
+	}
+
+	assert.False(t, TLSConfig{AutoCacheDir: "certs"}.enabled())
+}
+
+func TestServeTLS(t *testing.T) {
+	certFile, keyFile, pool := writeCert(t, t.TempDir())
+
+	cfg, err := TLSConfig{CertFile: certFile, KeyFile: keyFile}.build()
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	api := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto))
+		}),
+		TLSConfig: cfg,
+	}
+	go func() { _ = api.ServeTLS(lis, "", "") }()
+	t.Cleanup(func() { _ = api.Close() })
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{RootCAs: pool},
+		ForceAttemptHTTP2: true,
+	}}
+	resp, err := client.Get("https://" + lis.Addr().String())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, 2, resp.ProtoMajor, "HTTP/2 is negotiated")
+}
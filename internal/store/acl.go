@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"codesignal/internal/auth"
+)
+
+// Keys may be owned by the subject of the principal creating them, so only
+// their owner, or principals granted admin on them, may modify or delete
+// them. The owner of a key is stored in the repository beside it, under
+// aclPrefix, so it is replicated, persisted and migrated with the key and
+// expires with it.
+
+// aclPrefix prefixes the keys storing owners. It sorts after every valid
+// UTF-8 key, so owners are listed last by a scan, and keys starting with it
+// are invalid.
+const aclPrefix = "\xffacl:"
+
+// WebhookPrefix prefixes the keys storing the registrations of webhooks,
+// so they are replicated and persisted like owners.
+const WebhookPrefix = "\xffhook:"
+
+// SchemaPrefix prefixes the keys storing the JSON Schemas of prefixes,
+// replicated and persisted like webhooks.
+const SchemaPrefix = "\xffschema:"
+
+func aclKey(key string) string {
+	return aclPrefix + key
+}
+
+// ReservedKey reports whether key stores the owner or deletion time of
+// another key, a webhook or a schema, hidden from scans and watches.
+func ReservedKey(key string) bool {
+	return strings.HasPrefix(key, aclPrefix) || strings.HasPrefix(key, WebhookPrefix) ||
+		strings.HasPrefix(key, SchemaPrefix) || strings.HasPrefix(key, DeleteAtPrefix)
+}
+
+// Owner returns the owner of key, empty when the key isn't owned.
+func (s *Service) Owner(ctx context.Context, key string) (string, error) {
+	key = s.NormalizeKey(key)
+	owner, exists, err := s.store.Get(ctx, aclKey(key))
+	if err != nil {
+		return "", &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return "", nil
+	}
+	return string(owner), nil
+}
+
+// checkOwner returns the owner of key, failing with an error wrapping
+// auth.ErrForbidden if the principal of ctx may not modify the key.
+func (s *Service) checkOwner(ctx context.Context, key string) (string, error) {
+	owner, err := s.Owner(ctx, key)
+	if err != nil || owner == "" {
+		return owner, err
+	}
+	if p, ok := auth.FromContext(ctx); ok && p.Subject != owner && !p.Can(auth.Admin, key) {
+		return owner, fmt.Errorf("%w: key %q is owned by %s", auth.ErrForbidden, key, owner)
+	}
+	return owner, nil
+}
+
+// putOwner stores the owner of key, expiring after ttl like the key.
+func (s *Service) putOwner(ctx context.Context, key, owner string, ttl time.Duration) error {
+	var err error
+	if ttl > 0 {
+		err = s.store.SetWithTTL(ctx, aclKey(key), []byte(owner), ttl)
+	} else {
+		err = s.store.Set(ctx, aclKey(key), []byte(owner))
+	}
+	if err != nil {
+		return &StorageError{Op: "set", Err: err}
+	}
+	return nil
+}
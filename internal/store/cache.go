@@ -0,0 +1,136 @@
+package store
+
+import (
+	"bytes"
+	"hash/maphash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"codesignal/internal/metrics"
+)
+
+// Reads of a hot key encode the same response over and over. The response
+// cache keeps the marshaled responses of GetKey in a fixed number of slots,
+// each key hashing to one, and serves them as long as the value read is the
+// one they were encoded from, at the same version. Comparing the values and
+// versions read, rather than tracking the
+// writes, invalidates the responses on writes through any protocol, the
+// replication of a cluster or a restore alike. A key is cached on its second
+// consecutive miss in its slot, so the keys read once neither evict the hot
+// ones nor allocate.
+//
+// Responses carry an ETag, a hash of the value, and the requests whose
+// If-None-Match holds it are answered 304 Not Modified without a body.
+
+// maxCachedBody is the largest response cached, bounding the memory of the
+// cache to its size times maxCachedBody.
+const maxCachedBody = 16 << 10
+
+// keyResponse is the response of GetKey for a key and its value.
+type keyResponse struct {
+	key   string
+	value []byte
+	// keyVersion is the version of the key, and version its header, nil when
+	// zero.
+	keyVersion uint64
+	version    []string
+	// etag and rawETag are the ETag headers of the JSON and raw
+	// representations.
+	etag, rawETag []string
+	// body is the marshaled JSON response, nil when not cached.
+	body []byte
+}
+
+// newKeyResponse returns the response of key with value at version, its
+// body encoded when cached.
+func newKeyResponse(key string, value []byte, version uint64, cached bool) *keyResponse {
+	h := uint64(14695981039346656037)
+	for _, b := range value {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	tag := make([]byte, 0, 16)
+	for shift := 60; shift >= 0; shift -= 4 {
+		tag = append(tag, hex[h>>shift&0xf])
+	}
+
+	resp := &keyResponse{
+		key:     key,
+		value:   value,
+		etag:    []string{`"` + string(tag) + `"`},
+		rawETag: []string{`"raw-` + string(tag) + `"`},
+	}
+	if version != 0 {
+		resp.keyVersion = version
+		resp.version = []string{strconv.FormatUint(version, 10)}
+	}
+	if cached {
+		b := append([]byte(nil), keyFoundPrefix...)
+		b = appendJSONString(b, key)
+		b = append(b, keyFoundValue...)
+		b = appendJSONString(b, value)
+		resp.body = append(b, keyFoundSuffix...)
+	}
+	return resp
+}
+
+// responseCache caches the responses of GetKey, safe for concurrent use. A
+// nil cache caches nothing.
+type responseCache struct {
+	seed  maphash.Seed
+	slots []atomic.Pointer[keyResponse]
+	// missed holds the hash of the key that last missed each slot.
+	missed []atomic.Uint64
+}
+
+// newResponseCache returns a cache of size slots, nil when size is zero or
+// less.
+func newResponseCache(size int) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return &responseCache{
+		seed:   maphash.MakeSeed(),
+		slots:  make([]atomic.Pointer[keyResponse], size),
+		missed: make([]atomic.Uint64, size),
+	}
+}
+
+// get returns the response of key read with value at version, from the
+// cache when it holds it.
+func (c *responseCache) get(key string, value []byte, version uint64) *keyResponse {
+	if c == nil {
+		return newKeyResponse(key, value, version, false)
+	}
+	h := maphash.String(c.seed, key)
+	slot := h % uint64(len(c.slots))
+	if resp := c.slots[slot].Load(); resp != nil && resp.key == key && resp.keyVersion == version && bytes.Equal(resp.value, value) {
+		metrics.ResponseCacheHits.Add(1)
+		return resp
+	}
+	if c.missed[slot].Swap(h) != h {
+		return newKeyResponse(key, value, version, false)
+	}
+	resp := newKeyResponse(key, value, version, true)
+	if len(resp.body) <= maxCachedBody {
+		c.slots[slot].Store(resp)
+	}
+	return resp
+}
+
+// notModified reports whether the If-None-Match header of r holds etag, or
+// is *, comparing the tags weakly.
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	for header != "" {
+		var tag string
+		tag, header, _ = strings.Cut(header, ",")
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
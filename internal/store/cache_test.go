@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache(t *testing.T) {
+	c := newResponseCache(4)
+	value := []byte("value")
+
+	first := c.get("key", value, 1)
+	assert.Nil(t, first.body, "keys read once aren't cached")
+	second := c.get("key", value, 1)
+	require.NotNil(t, second.body)
+	assert.Same(t, second, c.get("key", []byte("value"), 1), "an equal value hits")
+	assert.Equal(t, first.etag, second.etag)
+	assert.NotEqual(t, second.etag, second.rawETag)
+
+	// A write changes the value read, which misses.
+	changed := c.get("key", []byte("changed"), 2)
+	assert.NotSame(t, second, changed)
+	assert.NotEqual(t, second.etag, changed.etag)
+	assert.Contains(t, string(changed.body), `"changed"`)
+	assert.Same(t, changed, c.get("key", []byte("changed"), 2))
+
+	// So does a write of the same value, giving the key a new version.
+	rewritten := c.get("key", []byte("changed"), 3)
+	assert.NotSame(t, changed, rewritten)
+	assert.Equal(t, []string{"3"}, rewritten.version)
+	assert.Equal(t, changed.etag, rewritten.etag)
+
+	// Large responses are encoded without being cached.
+	large := make([]byte, maxCachedBody)
+	c.get("large", large, 1)
+	assert.NotNil(t, c.get("large", large, 1).body)
+	assert.NotSame(t, c.get("large", large, 1), c.get("large", large, 1))
+
+	var disabled *responseCache
+	assert.Nil(t, disabled.get("key", value, 1).body)
+	assert.Equal(t, second.etag, disabled.get("key", value, 1).etag)
+}
+
+func TestGetKeyETag(t *testing.T) {
+	s := newGetKeyService(t)
+	get := func(ifNoneMatch string, raw bool) *httptest.ResponseRecorder {
+		r := getKeyRequest(1)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		if raw {
+			r.Header.Set("Accept", "application/octet-stream")
+		}
+		w := httptest.NewRecorder()
+		s.GetKey(w, r)
+		return w
+	}
+
+	w := get("", false)
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	w = get("", true)
+	require.Equal(t, http.StatusOK, w.Code)
+	rawETag := w.Header().Get("ETag")
+	assert.NotEqual(t, etag, rawETag, "the representations have their own tag")
+
+	for _, header := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		w = get(header, false)
+		assert.Equal(t, http.StatusNotModified, w.Code, header)
+		assert.Empty(t, w.Body.String())
+		assert.Equal(t, etag, w.Header().Get("ETag"))
+	}
+	assert.Equal(t, http.StatusNotModified, get(rawETag, true).Code)
+	assert.Equal(t, http.StatusOK, get(rawETag, false).Code)
+	assert.Equal(t, http.StatusOK, get(`"other"`, false).Code)
+
+	// A write changes the tag.
+	require.NoError(t, s.Set(context.Background(), "key<1>", []byte("changed"), 0))
+	w = get(etag, false)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEqual(t, etag, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"value":"changed"`)
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"codesignal/internal/repository"
+)
+
+// Counters are values holding an int64 count, updated atomically by the
+// repository with a chosen overflow behavior, see repository.AddCounter.
+// Unlike IncrementKey, they never operate on plain string values.
+
+// errInvalidOverflow is returned for an overflow mode other than error,
+// wrap and saturate.
+var errInvalidOverflow = errors.New("overflow must be error, wrap or saturate")
+
+// overflowModes are the overflow modes of CounterRequest by name.
+var overflowModes = map[string]repository.Overflow{
+	"":         repository.OverflowError,
+	"error":    repository.OverflowError,
+	"wrap":     repository.OverflowWrap,
+	"saturate": repository.OverflowSaturate,
+}
+
+// CounterRequest represents the payload updating a counter. Delta defaults
+// to 1 when omitted, and Overflow to error.
+type CounterRequest struct {
+	Delta *int64 `json:"delta"`
+	// Overflow is the behavior of an update past the int64 range: error
+	// rejects it, wrap wraps around and saturate clamps to the bound.
+	Overflow string `json:"overflow"`
+}
+
+// ResetCounterRequest represents the payload resetting a counter. Value
+// defaults to 0 when omitted.
+type ResetCounterRequest struct {
+	Value int64 `json:"value"`
+}
+
+// Counter represents a counter returned by the API.
+type Counter struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+	// Previous is the count before a reset.
+	Previous *int64 `json:"previous,omitempty"`
+}
+
+// CounterResponse represents a counter response of the API.
+type CounterResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Data       *Counter   `json:"data,omitempty"`
+}
+
+// AddCounter adds delta to the counter stored at key, creating it if
+// missing, and returns the new count.
+func (s *Service) AddCounter(ctx context.Context, key string, delta int64, overflow repository.Overflow) (int64, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return 0, err
+	}
+	if err := s.checkMemory(); err != nil {
+		return 0, err
+	}
+
+	s.hotKeys.Write(key)
+	var count int64
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, count, err = repository.ApplyAddCounter(value, exists, delta, overflow)
+		return value, err
+	}, func() error {
+		var err error
+		count, err = s.store.AddCounter(ctx, key, delta, overflow)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// ResetCounter sets the counter stored at key to n, creating it if missing,
+// and returns its previous count.
+func (s *Service) ResetCounter(ctx context.Context, key string, n int64) (int64, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return 0, err
+	}
+	if err := s.checkMemory(); err != nil {
+		return 0, err
+	}
+
+	s.hotKeys.Write(key)
+	var previous int64
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, previous, err = repository.ApplyResetCounter(value, exists, n)
+		return value, err
+	}, func() error {
+		var err error
+		previous, err = s.store.ResetCounter(ctx, key, n)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return previous, nil
+}
+
+// Counter returns the count of the counter stored at key, or
+// ErrKeyNotFound.
+func (s *Service) Counter(ctx context.Context, key string) (int64, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return repository.DecodeCounter(value)
+}
+
+// GetCounter returns the count of the counter of a key.
+func (s *Service) GetCounter(w http.ResponseWriter, r *http.Request) {
+	key := s.NormalizeKey(httprouter.ParamsFromContext(r.Context()).ByName("key"))
+
+	value, err := s.Counter(r.Context(), key)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get counter")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, CounterResponse{Message: "counter found", StatusCode: StatusSuccess, Data: &Counter{Key: key, Value: value}})
+}
+
+// UpdateCounter adds the delta of the request to the counter of a key,
+// answering with the new count.
+func (s *Service) UpdateCounter(w http.ResponseWriter, r *http.Request) {
+	key := s.NormalizeKey(httprouter.ParamsFromContext(r.Context()).ByName("key"))
+
+	var req CounterRequest
+	if err := decodeBody(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+	overflow, ok := overflowModes[req.Overflow]
+	if !ok {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: errInvalidOverflow.Error(), StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "overflow"}})
+		return
+	}
+
+	delta := int64(1)
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+
+	value, err := s.AddCounter(r.Context(), key, delta, overflow)
+	if err != nil {
+		s.writeError(w, r, err, "failed to update counter")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, CounterResponse{Message: "counter updated", StatusCode: StatusSuccess, Data: &Counter{Key: key, Value: value}})
+}
+
+// ResetCounterKey resets the counter of a key, answering with its previous
+// count.
+func (s *Service) ResetCounterKey(w http.ResponseWriter, r *http.Request) {
+	key := s.NormalizeKey(httprouter.ParamsFromContext(r.Context()).ByName("key"))
+
+	var req ResetCounterRequest
+	if err := decodeBody(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	previous, err := s.ResetCounter(r.Context(), key, req.Value)
+	if err != nil {
+		s.writeError(w, r, err, "failed to reset counter")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, CounterResponse{Message: "counter reset", StatusCode: StatusSuccess, Data: &Counter{Key: key, Value: req.Value, Previous: &previous}})
+}
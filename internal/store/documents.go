@@ -0,0 +1,148 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"codesignal/internal/jsonpath"
+	"codesignal/internal/repository"
+)
+
+// Documents are values holding any JSON document, whose values addressed by
+// a JSONPath are read here and set atomically by the repository, see
+// repository.SetPath.
+
+// PathRequest represents the payload setting the value at a path of a
+// document.
+type PathRequest struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// PathResponse represents the value at a path of a document returned by
+// the API.
+type PathResponse struct {
+	Message    string          `json:"message"`
+	StatusCode StatusCode      `json:"status_code"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Path returns the JSON value at path of the document stored at key, or
+// ErrKeyNotFound or jsonpath.ErrNotFound.
+func (s *Service) Path(ctx context.Context, key, path string) (json.RawMessage, error) {
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return project(value, p)
+}
+
+// project returns the JSON value at p of the document value, or
+// repository.ErrNotDocument or jsonpath.ErrNotFound.
+func project(value []byte, p jsonpath.Path) ([]byte, error) {
+	doc, err := repository.DecodeDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := p.Get(doc)
+	if err != nil {
+		return nil, err
+	}
+	return repository.EncodeDocument(sub)
+}
+
+// SetPath sets the value at path of the document stored at key to the JSON
+// value. Only the root path creates a missing key.
+func (s *Service) SetPath(ctx context.Context, key, path string, value json.RawMessage) error {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return err
+	}
+	if err := s.checkMemory(); err != nil {
+		return err
+	}
+	// The path is parsed again by the repository, it is checked here so
+	// invalid paths aren't replicated.
+	p, err := jsonpath.Parse(path)
+	if err != nil {
+		return err
+	}
+	sub, err := repository.DecodeDocument(value)
+	if err != nil {
+		return setError("update", err)
+	}
+
+	s.hotKeys.Write(key)
+	return s.update(ctx, "update", key, func(current []byte, exists bool) ([]byte, error) {
+		return repository.ApplySetPath(current, exists, p, sub, s.getMaxValueSize())
+	}, func() error {
+		return s.store.SetPath(ctx, key, path, value, s.getMaxValueSize())
+	})
+}
+
+// pathParam returns the path query parameter of r, the root when omitted.
+func pathParam(r *http.Request) string {
+	if path := r.URL.Query().Get("path"); path != "" {
+		return path
+	}
+	return "$"
+}
+
+// selectParam returns the JSONPath of the select query parameter of r,
+// reporting false when omitted.
+func selectParam(r *http.Request) (jsonpath.Path, bool, error) {
+	// Parsing the query allocates, most requests have none.
+	if r.URL.RawQuery == "" {
+		return jsonpath.Path{}, false, nil
+	}
+	sel := r.URL.Query().Get("select")
+	if sel == "" {
+		return jsonpath.Path{}, false, nil
+	}
+	p, err := jsonpath.Parse(sel)
+	if err != nil {
+		return jsonpath.Path{}, false, err
+	}
+	return p, true, nil
+}
+
+// GetPath returns the value at the path query parameter of the document of
+// a key.
+func (s *Service) GetPath(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	value, err := s.Path(r.Context(), key, pathParam(r))
+	if err != nil {
+		s.writeError(w, r, err, "failed to get path")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, PathResponse{Message: "path found", StatusCode: StatusSuccess, Data: value})
+}
+
+// PutPath sets the value at the path query parameter of the document of a
+// key.
+func (s *Service) PutPath(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	var req PathRequest
+	if err := decodeBody(r.Body, &req); err != nil {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+	if req.Value == nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid request body: value is required", StatusCode: StatusInvalidJSON, detail: &ErrorDetail{Field: "value"}})
+		return
+	}
+
+	if err := s.SetPath(r.Context(), key, pathParam(r), req.Value); err != nil {
+		s.writeError(w, r, err, "failed to set path")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "path set", StatusCode: StatusSuccess})
+}
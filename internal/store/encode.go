@@ -0,0 +1,152 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+// GetKey is the hottest route. Its response is written from pre-marshaled
+// fragments into a pooled buffer rather than by encoding a Response, which
+// allocates the encoder, the envelope and the string of the value on every
+// request. The bytes written are those of encoding/json.
+
+// keyFoundPrefix and keyFoundValue precede the key and the value of the
+// response of GetKey, the same with and without the v2 envelope.
+var (
+	keyFoundPrefix = []byte(`{"message":"key found","status_code":` + strconv.Itoa(int(StatusSuccess)) + `,"data":{"key":`)
+	keyFoundValue  = []byte(`,"value":`)
+	keyFoundSuffix = []byte("}}\n")
+)
+
+// jsonContentType is shared by the responses, sparing the slice of
+// Header.Set.
+var jsonContentType = []string{"application/json"}
+
+// maxPooledBuffer caps the capacity of the buffers returned to the pool, so
+// a few large values don't pin their memory.
+const maxPooledBuffer = 64 << 10
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
+}
+
+// writeKeyFound writes the response of GetKey, encoding it unless cached.
+func (s *Service) writeKeyFound(w http.ResponseWriter, r *http.Request, resp *keyResponse) {
+	w.Header()["Content-Type"] = jsonContentType
+	if resp.body != nil {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(resp.body); err != nil {
+			s.logger(r).Error().Err(err).Msg("error writing response")
+		}
+		return
+	}
+
+	bp := bufferPool.Get().(*[]byte)
+	b := append((*bp)[:0], keyFoundPrefix...)
+	b = appendJSONString(b, resp.key)
+	b = append(b, keyFoundValue...)
+	b = appendJSONString(b, resp.value)
+	b = append(b, keyFoundSuffix...)
+
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(b); err != nil {
+		s.logger(r).Error().Err(err).Msg("error writing response")
+	}
+
+	if cap(b) <= maxPooledBuffer {
+		*bp = b
+		bufferPool.Put(bp)
+	}
+}
+
+const hex = "0123456789abcdef"
+
+// appendJSONString appends s to dst as a JSON string, escaped as
+// encoding/json does: HTML characters, control characters, U+2028 and
+// U+2029 are escaped and each invalid byte is replaced with U+FFFD.
+func appendJSONString[T string | []byte](dst []byte, s T) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '\\', '"':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		// At most utf8.UTFMax bytes are converted, on the stack.
+		c, size := utf8.DecodeRuneInString(string(s[i:min(i+utf8.UTFMax, len(s))]))
+		if c == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = utf8.AppendRune(dst, utf8.RuneError)
+			i += size
+			start = i
+			continue
+		}
+		if c == '\u2028' || c == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hex[c&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// encoder is a pooled JSON encoder with the buffer it encodes to.
+type encoder struct {
+	buf bytes.Buffer
+	enc *json.Encoder
+}
+
+var encoderPool = sync.Pool{
+	New: func() any {
+		e := &encoder{}
+		e.enc = json.NewEncoder(&e.buf)
+		return e
+	},
+}
+
+func getEncoder() *encoder {
+	return encoderPool.Get().(*encoder)
+}
+
+// putEncoder returns e to the pool, unless its buffer grew past
+// maxPooledBuffer.
+func putEncoder(e *encoder) {
+	if e.buf.Cap() > maxPooledBuffer {
+		return
+	}
+	e.buf.Reset()
+	encoderPool.Put(e)
+}
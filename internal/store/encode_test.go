@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func TestAppendJSONString(t *testing.T) {
+	values := []string{
+		"", "value", `quote " and \ backslash`, "<script>&</script>",
+		"\b\f\n\r\t\x00\x1f\x7f", "héllo wörld 日本語 🎉", "  ",
+		"\xff\xfe invalid", "truncated \xe6\x97", "\xed\xa0\x80 surrogate",
+	}
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 1000 {
+		b := make([]byte, rng.IntN(16))
+		for i := range b {
+			b[i] = byte(rng.IntN(256))
+		}
+		values = append(values, string(b))
+	}
+	for _, v := range values {
+		want, err := json.Marshal(v)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(appendJSONString(nil, v)), "%q", v)
+		assert.Equal(t, string(want), string(appendJSONString(nil, []byte(v))), "%q", v)
+	}
+}
+
+// discardWriter is a ResponseWriter discarding the body, reusing its
+// header.
+type discardWriter struct {
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Write(p []byte) (int, error) { return w.body.Write(p) }
+
+func newGetKeyService(t testing.TB) *Service {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	s := NewService(zerolog.Nop(), repo, Opts{ResponseCacheSize: 16})
+	require.NoError(t, s.Set(context.Background(), "key<1>", []byte("value \"1\"\n"), 0))
+	return s
+}
+
+func getKeyRequest(version int) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/v1/key/key%3C1%3E", nil)
+	ctx := context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "key", Value: "key<1>"}})
+	if version > 1 {
+		ctx = NewVersionContext(ctx, version)
+	}
+	return r.WithContext(ctx)
+}
+
+func TestGetKeyResponse(t *testing.T) {
+	s := newGetKeyService(t)
+	// The second read of the key caches its response, the third is served
+	// from the cache.
+	for _, version := range []int{1, 2, 1, 2} {
+		w := httptest.NewRecorder()
+		s.GetKey(w, getKeyRequest(version))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+		// The response is the one encoding/json writes.
+		var want bytes.Buffer
+		var resp any = Response{Message: "key found", StatusCode: StatusSuccess, Data: &KeyValue{Key: "key<1>", Value: "value \"1\"\n"}}
+		if version > 1 {
+			resp = resp.(Response).v2()
+		}
+		require.NoError(t, json.NewEncoder(&want).Encode(resp))
+		assert.Equal(t, want.String(), w.Body.String())
+	}
+}
+
+func TestGetKeyAllocs(t *testing.T) {
+	s := newGetKeyService(t)
+	r := getKeyRequest(1)
+	w := &discardWriter{header: make(http.Header)}
+	allocs := testing.AllocsPerRun(100, func() {
+		w.body.Reset()
+		s.GetKey(w, r)
+	})
+	assert.Zero(t, allocs)
+}
+
+func BenchmarkGetKey(b *testing.B) {
+	s := newGetKeyService(b)
+	r := getKeyRequest(1)
+	w := &discardWriter{header: make(http.Header)}
+	b.ReportAllocs()
+	for range b.N {
+		w.body.Reset()
+		s.GetKey(w, r)
+	}
+}
+
+func TestDoJSONWrite(t *testing.T) {
+	s := newGetKeyService(t)
+	for _, version := range []int{1, 2} {
+		w := httptest.NewRecorder()
+		s.doJSONWrite(w, getKeyRequest(version), http.StatusCreated, Response{Message: "key created", StatusCode: StatusSuccess})
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"message":"key created","status_code":1000}`, w.Body.String())
+
+		// An encoding failure answers 500 with a single body.
+		w = httptest.NewRecorder()
+		s.doJSONWrite(w, getKeyRequest(version), http.StatusOK, map[string]float64{"nan": math.NaN()})
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.JSONEq(t, `{"message":"failed to encode response","status_code":1005}`, w.Body.String())
+	}
+}
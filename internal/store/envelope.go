@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+// The /v2 routes answer with ResponseV2, which adds to Response the details
+// of the part of a request that failed validation, so clients don't parse
+// messages to find the offending field or limit. The handlers are shared
+// with the unversioned routes: the version is carried by the request
+// context.
+
+// ResponseV2 represents the API response of the /v2 routes.
+type ResponseV2 struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Data       *KeyValue  `json:"data,omitempty"`
+	// Error details the invalid part of a failed request, when known.
+	Error *ErrorDetail `json:"error,omitempty"`
+}
+
+// ErrorDetail describes the invalid part of a request.
+type ErrorDetail struct {
+	// Field is the invalid field, such as key, value or ttl, or body for
+	// the request body as a whole.
+	Field string `json:"field"`
+	// Limit is the limit the field exceeds, in bytes.
+	Limit int64 `json:"limit,omitempty"`
+	// Actual is the size of the field, in bytes, when known.
+	Actual int64 `json:"actual,omitempty"`
+	// Offset is the position in bytes of a decoding error in the body.
+	Offset *int64 `json:"offset,omitempty"`
+}
+
+// LimitError is a key or value exceeding its size limit. It wraps
+// ErrKeyTooLong or ErrValueTooLarge.
+type LimitError struct {
+	// Field is key or value.
+	Field  string
+	Limit  int
+	Actual int
+}
+
+func (e *LimitError) Error() string {
+	if e.Field == "key" {
+		return fmt.Sprintf("err: %s, max key length: %d", ErrKeyTooLong, e.Limit)
+	}
+	return fmt.Sprintf("err: %s, max value size: %d", ErrValueTooLarge, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	if e.Field == "key" {
+		return ErrKeyTooLong
+	}
+	return ErrValueTooLarge
+}
+
+func (e *LimitError) detail() *ErrorDetail {
+	return &ErrorDetail{Field: e.Field, Limit: int64(e.Limit), Actual: int64(e.Actual)}
+}
+
+type versionKey struct{}
+
+// NewVersionContext returns a context carrying the API version of a
+// request, answered with ResponseV2 from version 2.
+func NewVersionContext(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, versionKey{}, version)
+}
+
+// versionFromContext returns the API version of a request, 1 by default.
+func versionFromContext(ctx context.Context) int {
+	if v, ok := ctx.Value(versionKey{}).(int); ok {
+		return v
+	}
+	return 1
+}
+
+// v2 returns the ResponseV2 of r.
+func (r Response) v2() ResponseV2 {
+	return ResponseV2{Message: r.Message, StatusCode: r.StatusCode, Data: r.Data, Error: r.detail}
+}
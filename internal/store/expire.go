@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/repository"
+)
+
+// expireBatchSize is the number of keys scanned at once by Expire.
+const expireBatchSize = 1000
+
+// ExpireResponse reports the keys whose expiry was updated by prefix.
+type ExpireResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	// Keys is the number of keys updated.
+	Keys int `json:"keys"`
+}
+
+// Expire sets the ttl of the live keys starting with prefix, or removes
+// their expiry when ttl is zero, and returns the number of keys updated.
+// Each key is written again with its value at its version, so its owner
+// is kept and a key written meanwhile keeps the ttl of that write.
+func (s *Service) Expire(ctx context.Context, prefix string, ttl time.Duration) (int, error) {
+	prefix = s.NormalizeKey(prefix)
+	if err := auth.Authorize(ctx, auth.Admin, prefix); err != nil {
+		return 0, err
+	}
+	if s.maintenance.Enabled() {
+		return 0, ErrReadOnly
+	}
+
+	n := 0
+	after := ""
+	for {
+		items, err := s.store.Scan(ctx, prefix, after, expireBatchSize)
+		if err != nil {
+			return n, &StorageError{Op: "scan", Err: err}
+		}
+		for _, item := range items {
+			// Keys without expiry are left as they are when clearing it.
+			if ReservedKey(item.Key) || (ttl == 0 && item.ExpiresAt.IsZero()) {
+				continue
+			}
+			updated, err := s.expire(ctx, item.Key, ttl)
+			if err != nil {
+				return n, err
+			}
+			if updated {
+				n++
+			}
+		}
+		if len(items) < expireBatchSize {
+			return n, nil
+		}
+		after = items[len(items)-1].Key
+	}
+}
+
+// expire writes key again with ttl, reporting false when the key was
+// deleted or written since it was read.
+func (s *Service) expire(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	value, version, exists, err := s.store.GetWithVersion(ctx, key)
+	if err != nil {
+		return false, &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return false, nil
+	}
+	if _, err := s.store.SetIfVersion(ctx, key, value, ttl, version); err != nil {
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			return false, nil
+		}
+		return false, &StorageError{Op: "set", Err: err}
+	}
+	s.hotKeys.Write(key)
+
+	// The owner expires with the key.
+	owner, err := s.Owner(ctx, key)
+	if err != nil || owner == "" {
+		return true, err
+	}
+	return true, s.putOwner(ctx, key, owner, ttl)
+}
+
+// ExpireKeys sets the expiry of the keys starting with the prefix query
+// parameter to the ttl query parameter, removing it when ttl is 0.
+func (s *Service) ExpireKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if !query.Has("prefix") {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "prefix is required, empty for every key", StatusCode: StatusInvalidKey})
+		return
+	}
+	var ttl time.Duration
+	if v := query.Get("ttl"); v != "0" {
+		var ok bool
+		if ttl, ok = s.parseTTL(w, r, v); !ok {
+			return
+		}
+		if ttl == 0 {
+			s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "ttl is required, 0 to remove the expiry", StatusCode: StatusInvalidTTL})
+			return
+		}
+	}
+
+	prefix := query.Get("prefix")
+	n, err := s.Expire(r.Context(), prefix, ttl)
+	if err != nil {
+		s.writeError(w, r, err, "failed to expire keys")
+		return
+	}
+	s.logger(r).Warn().Str("prefix", prefix).Dur("ttl", ttl).Int("keys", n).Msg("expiry of keys updated")
+	msg := "expiry of keys updated"
+	if ttl == 0 {
+		msg = "expiry of keys removed"
+	}
+	s.doJSONWrite(w, r, http.StatusOK, ExpireResponse{Message: msg, StatusCode: StatusSuccess, Keys: n})
+}
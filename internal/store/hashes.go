@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+
+	"codesignal/internal/repository"
+)
+
+// Hashes are values holding a JSON object of string fields, whose fields
+// are set and deleted atomically by the repository, see
+// repository.SetFields.
+
+// ErrFieldNotFound is returned for a field a hash doesn't hold.
+var ErrFieldNotFound = errors.New("field not found")
+
+// FieldRequest represents the payload setting a field of a hash.
+type FieldRequest struct {
+	Value string `json:"value"`
+}
+
+// HashResponse represents fields of a hash returned by the API.
+type HashResponse struct {
+	Message    string            `json:"message"`
+	StatusCode StatusCode        `json:"status_code"`
+	Data       map[string]string `json:"data"`
+}
+
+// SetField sets field of the hash stored at key to value, creating the
+// hash if missing, and reports whether the field is new.
+func (s *Service) SetField(ctx context.Context, key, field, value string) (bool, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return false, err
+	}
+	if err := s.checkMemory(); err != nil {
+		return false, err
+	}
+
+	s.hotKeys.Write(key)
+	fields := map[string]string{field: value}
+	var created int
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, created, err = repository.ApplySetFields(value, exists, fields, s.getMaxValueSize())
+		return value, err
+	}, func() error {
+		var err error
+		created, err = s.store.SetFields(ctx, key, fields, s.getMaxValueSize())
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return created > 0, nil
+}
+
+// DeleteField deletes field of the hash stored at key, or returns
+// ErrFieldNotFound.
+func (s *Service) DeleteField(ctx context.Context, key, field string) error {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return err
+	}
+
+	s.hotKeys.Write(key)
+	names := []string{field}
+	var deleted int
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, deleted, err = repository.ApplyDeleteFields(value, exists, names)
+		return value, err
+	}, func() error {
+		var err error
+		deleted, err = s.store.DeleteFields(ctx, key, names)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrFieldNotFound
+	}
+	return nil
+}
+
+// Fields returns the fields of the hash stored at key, or ErrKeyNotFound.
+func (s *Service) Fields(ctx context.Context, key string) (map[string]string, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return repository.DecodeHash(value)
+}
+
+// Field returns the value of field of the hash stored at key, or
+// ErrFieldNotFound or ErrKeyNotFound.
+func (s *Service) Field(ctx context.Context, key, field string) (string, error) {
+	fields, err := s.Fields(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	value, ok := fields[field]
+	if !ok {
+		return "", ErrFieldNotFound
+	}
+	return value, nil
+}
+
+// GetHash returns every field of the hash of a key.
+func (s *Service) GetHash(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	fields, err := s.Fields(r.Context(), key)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get fields")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, HashResponse{Message: "fields found", StatusCode: StatusSuccess, Data: fields})
+}
+
+// GetField returns a field of the hash of a key.
+func (s *Service) GetField(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	field := params.ByName("field")
+
+	value, err := s.Field(r.Context(), params.ByName("key"), field)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get field")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, HashResponse{Message: "field found", StatusCode: StatusSuccess, Data: map[string]string{field: value}})
+}
+
+// PutField sets a field of the hash of a key, answering 201 for a new
+// field.
+func (s *Service) PutField(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	var req FieldRequest
+	if err := decodeBody(r.Body, &req); err != nil {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	created, err := s.SetField(r.Context(), params.ByName("key"), params.ByName("field"), req.Value)
+	if err != nil {
+		s.writeError(w, r, err, "failed to set field")
+		return
+	}
+	if created {
+		s.doJSONWrite(w, r, http.StatusCreated, Response{Message: "field created", StatusCode: StatusSuccess})
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "field updated", StatusCode: StatusSuccess})
+}
+
+// DeleteFieldKey deletes a field of the hash of a key.
+func (s *Service) DeleteFieldKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	if err := s.DeleteField(r.Context(), params.ByName("key"), params.ByName("field")); err != nil {
+		s.writeError(w, r, err, "failed to delete field")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "field deleted", StatusCode: StatusSuccess})
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/repository"
+)
+
+// ErrVersionNotFound is returned for the versions of a key its history
+// doesn't retain.
+var ErrVersionNotFound = errors.New("version not found")
+
+var (
+	errInvalidVersion = errors.New("invalid version, expected a positive integer")
+	errInvalidAt      = errors.New("invalid at, expected an RFC 3339 timestamp")
+	errVersionAndAt   = errors.New("version and at are mutually exclusive")
+)
+
+// GetVersion returns the value key held at version, or ErrVersionNotFound
+// when its history doesn't retain it or it deleted the key.
+func (s *Service) GetVersion(ctx context.Context, key string, version uint64) ([]byte, error) {
+	versions, err := s.history(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Number == version && !v.Deleted {
+			return v.Value, nil
+		}
+	}
+	return nil, ErrVersionNotFound
+}
+
+// GetAt returns the value key held at t, or ErrVersionNotFound when its
+// history doesn't retain the version written last before t, or when the key
+// was deleted or expired at t.
+func (s *Service) GetAt(ctx context.Context, key string, t time.Time) ([]byte, error) {
+	v, err := s.versionAt(ctx, key, t)
+	return v.Value, err
+}
+
+// versionAt returns the version of key GetAt reads at t.
+func (s *Service) versionAt(ctx context.Context, key string, t time.Time) (repository.Version, error) {
+	versions, err := s.history(ctx, key)
+	if err != nil {
+		return repository.Version{}, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v.Time.After(t) {
+			continue
+		}
+		if v.Deleted || (!v.ExpiresAt.IsZero() && !t.Before(v.ExpiresAt)) {
+			break
+		}
+		return v, nil
+	}
+	return repository.Version{}, ErrVersionNotFound
+}
+
+// history returns the versions of key retained by its history.
+func (s *Service) history(ctx context.Context, key string) ([]repository.Version, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Read, key); err != nil {
+		return nil, err
+	}
+	if key == "" || ReservedKey(key) {
+		return nil, ErrInvalidKey
+	}
+	if err := s.beforeGet(ctx, key); err != nil {
+		return nil, err
+	}
+
+	versions, err := s.store.History(ctx, key)
+	if err != nil {
+		return nil, &StorageError{Op: "get", Err: err}
+	}
+	return versions, nil
+}
+
+// versionQuery selects a past version of a key, by number or by time.
+type versionQuery struct {
+	number uint64
+	at     time.Time
+}
+
+// parseVersionQuery parses the version and at query parameters of r,
+// reporting whether either selects a past version.
+func parseVersionQuery(r *http.Request) (versionQuery, bool, error) {
+	// Parsing the query allocates, most requests have none.
+	if r.URL.RawQuery == "" {
+		return versionQuery{}, false, nil
+	}
+	query := r.URL.Query()
+	version, at := query.Get("version"), query.Get("at")
+	var q versionQuery
+	switch {
+	case version != "" && at != "":
+		return q, false, errVersionAndAt
+	case version != "":
+		n, err := strconv.ParseUint(version, 10, 64)
+		if err != nil || n == 0 {
+			return q, false, errInvalidVersion
+		}
+		q.number = n
+	case at != "":
+		t, err := time.Parse(time.RFC3339Nano, at)
+		if err != nil {
+			return q, false, errInvalidAt
+		}
+		q.at = t
+	default:
+		return q, false, nil
+	}
+	return q, true, nil
+}
+
+// getVersion returns the value and the number of the version of key
+// selected by q.
+func (s *Service) getVersion(ctx context.Context, key string, q versionQuery) ([]byte, uint64, error) {
+	if q.number != 0 {
+		value, err := s.GetVersion(ctx, key, q.number)
+		return value, q.number, err
+	}
+	v, err := s.versionAt(ctx, key, q.at)
+	return v.Value, v.Number, err
+}
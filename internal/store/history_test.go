@@ -0,0 +1,74 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func TestGetKeyHistory(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{History: 2})
+	require.NoError(t, err)
+	s := NewService(zerolog.Nop(), repo, Opts{})
+	ctx := context.Background()
+
+	before := time.Now()
+	time.Sleep(time.Millisecond)
+	for _, value := range []string{"1", "2"} {
+		require.NoError(t, s.Set(ctx, "key", []byte(value), 0))
+	}
+	time.Sleep(time.Millisecond)
+	between := time.Now()
+	time.Sleep(time.Millisecond)
+	require.NoError(t, s.Set(ctx, "key", []byte("3"), 0))
+
+	get := func(query string) (int, Response) {
+		r := httptest.NewRequest(http.MethodGet, "/v1/key/key?"+query, nil)
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "key", Value: "key"}}))
+		w := httptest.NewRecorder()
+		s.GetKey(w, r)
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+	at := func(t time.Time) string {
+		return "at=" + url.QueryEscape(t.Format(time.RFC3339Nano))
+	}
+
+	for query, value := range map[string]string{
+		"":             "3",
+		"version=2":    "2",
+		"version=3":    "3",
+		at(between):    "2",
+		at(time.Now()): "3",
+	} {
+		code, resp := get(query)
+		require.Equal(t, http.StatusOK, code, query)
+		require.NotNil(t, resp.Data, query)
+		assert.Equal(t, value, resp.Data.Value, query)
+	}
+
+	for query, code := range map[string]StatusCode{
+		"version=1":               StatusVersionNotFound,
+		"version=4":               StatusVersionNotFound,
+		at(before):                StatusVersionNotFound,
+		"version=0":               StatusInvalidValue,
+		"version=x":               StatusInvalidValue,
+		"at=yesterday":            StatusInvalidValue,
+		"version=2&" + at(before): StatusInvalidValue,
+	} {
+		_, resp := get(query)
+		assert.Equal(t, code, resp.StatusCode, query)
+	}
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"codesignal/internal/repository"
+)
+
+// ErrRejected wraps the errors of hooks rejecting an operation.
+var ErrRejected = errors.New("rejected")
+
+// Hook extends the operations of a Service on string keys, such as
+// validation, transformation or custom metrics, without changing the
+// handlers of the protocols. Hooks run on every write, including the
+// updates of structures such as SetPath, SetField or AddMembers and
+// Undelete, on Get and on Delete, after the permission checks and the
+// validation of the Service, in the order they are registered. Embed
+// NopHook to implement a subset of the methods.
+type Hook interface {
+	// BeforeSet runs before key is stored and returns the value stored in
+	// place of value. An error rejects the write.
+	BeforeSet(ctx context.Context, key string, value []byte) ([]byte, error)
+	// AfterSet runs once key is stored with value.
+	AfterSet(ctx context.Context, key string, value []byte)
+	// BeforeGet runs before key is read. An error rejects the read.
+	BeforeGet(ctx context.Context, key string) error
+	// AfterDelete runs once key is deleted.
+	AfterDelete(ctx context.Context, key string)
+}
+
+// NopHook is a Hook doing nothing, embedded by the hooks implementing a
+// subset of its methods.
+type NopHook struct{}
+
+// BeforeSet implements Hook.
+func (NopHook) BeforeSet(_ context.Context, _ string, value []byte) ([]byte, error) {
+	return value, nil
+}
+
+// AfterSet implements Hook.
+func (NopHook) AfterSet(context.Context, string, []byte) {}
+
+// BeforeGet implements Hook.
+func (NopHook) BeforeGet(context.Context, string) error {
+	return nil
+}
+
+// AfterDelete implements Hook.
+func (NopHook) AfterDelete(context.Context, string) {}
+
+// beforeSet runs the BeforeSet hooks, each receiving the value returned by
+// the previous one, and validates the value they return.
+func (s *Service) beforeSet(ctx context.Context, key string, value []byte) ([]byte, error) {
+	if len(s.hooks) == 0 {
+		return value, nil
+	}
+	for _, h := range s.hooks {
+		var err error
+		if value, err = h.BeforeSet(ctx, key, value); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	if err := s.validate(key, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// maxUpdateAttempts bounds the attempts of an update through the hooks to
+// store its value before the key changes again.
+const maxUpdateAttempts = 8
+
+// update applies the update of the structure stored at key that apply
+// computes from its current value, nil leaving it unchanged. Without hooks,
+// write applies it atomically in the repository instead. With hooks, the
+// value computed goes through the BeforeSet hooks, is stored with the
+// expiry of the key unless the key changed meanwhile, the update being
+// computed again then, and goes through the AfterSet hooks. A missing key
+// is created as by Create. The repository errors of apply and write are
+// classified by updateError.
+func (s *Service) update(ctx context.Context, op, key string, apply func(value []byte, exists bool) ([]byte, error), write func() error) error {
+	if len(s.hooks) == 0 {
+		return s.updateError(op, write())
+	}
+	for range maxUpdateAttempts {
+		current, version, exists, err := s.store.GetWithVersion(ctx, key)
+		if err != nil {
+			return &StorageError{Op: "get", Err: err}
+		}
+		ttl, live, err := s.remaining(ctx, key, exists)
+		if err != nil {
+			return err
+		}
+		if !live {
+			continue
+		}
+		value, err := apply(current, exists)
+		if err != nil || value == nil {
+			return s.updateError(op, err)
+		}
+		if value, err = s.beforeSet(ctx, key, value); err != nil {
+			return err
+		}
+		if _, err := s.store.SetIfVersion(ctx, key, value, ttl, version); err != nil {
+			if errors.Is(err, repository.ErrVersionMismatch) {
+				continue
+			}
+			return &StorageError{Op: "set", Err: err}
+		}
+		s.afterSet(ctx, key, value)
+		return nil
+	}
+	return ErrVersionMismatch
+}
+
+// updateError classifies the repository error of the update op of a
+// structure, answering a value past the size limit with a LimitError.
+func (s *Service) updateError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, repository.ErrTooLarge) {
+		return &LimitError{Field: "value", Limit: s.getMaxValueSize()}
+	}
+	return setError(op, err)
+}
+
+// remaining returns the time left before key expires, zero when it
+// doesn't, reporting false if it expired since it was read as existing.
+func (s *Service) remaining(ctx context.Context, key string, exists bool) (time.Duration, bool, error) {
+	if !exists {
+		return 0, true, nil
+	}
+	expiresAt, ok, err := s.store.Expiry(ctx, key)
+	if err != nil {
+		return 0, false, &StorageError{Op: "get", Err: err}
+	}
+	if !ok {
+		return 0, false, nil
+	}
+	if expiresAt.IsZero() {
+		return 0, true, nil
+	}
+	ttl := time.Until(expiresAt)
+	return ttl, ttl > 0, nil
+}
+
+// checkRestored runs the hooks on the value of key restored by Undelete,
+// storing the value the BeforeSet hooks return in its place.
+func (s *Service) checkRestored(ctx context.Context, key string) error {
+	current, version, exists, err := s.store.GetWithVersion(ctx, key)
+	if err != nil {
+		return &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return nil
+	}
+	value, err := s.beforeSet(ctx, key, current)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(value, current) {
+		ttl, live, err := s.remaining(ctx, key, exists)
+		if err != nil || !live {
+			return err
+		}
+		if _, err := s.store.SetIfVersion(ctx, key, value, ttl, version); err != nil {
+			return versionError("set", err)
+		}
+	}
+	s.afterSet(ctx, key, value)
+	return nil
+}
+
+func (s *Service) afterSet(ctx context.Context, key string, value []byte) {
+	for _, h := range s.hooks {
+		h.AfterSet(ctx, key, value)
+	}
+}
+
+func (s *Service) beforeGet(ctx context.Context, key string) error {
+	for _, h := range s.hooks {
+		if err := h.BeforeGet(ctx, key); err != nil {
+			return fmt.Errorf("%w: %w", ErrRejected, err)
+		}
+	}
+	return nil
+}
+
+func (s *Service) afterDelete(ctx context.Context, key string) {
+	for _, h := range s.hooks {
+		h.AfterDelete(ctx, key)
+	}
+}
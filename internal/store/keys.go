@@ -0,0 +1,117 @@
+package store
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Keys are addressed by the path of the key routes, so by default they may
+// not hold control characters or whitespace, which clients can't send
+// unescaped and are mangled by logs and terminals. A key pattern replaces
+// this rule.
+
+// CompileKeyPattern compiles the pattern keys must match as a whole, such
+// as `[a-z0-9:_-]+`.
+func CompileKeyPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key pattern: %w", err)
+	}
+	return re, nil
+}
+
+// SetKeyPattern replaces the pattern keys must match, nil restoring the
+// default rule.
+func (s *Service) SetKeyPattern(pattern *regexp.Regexp) {
+	s.keyPattern.Store(pattern)
+}
+
+// checkKey reports the keys that can't be written under the key rule.
+// Reads and deletes don't check it, so the keys written before the rule
+// changed can still be removed.
+func (s *Service) checkKey(key string) error {
+	if pattern := s.keyPattern.Load(); pattern != nil {
+		if !pattern.MatchString(key) {
+			return fmt.Errorf("%w: must match %s", ErrInvalidKey, pattern)
+		}
+		return nil
+	}
+	if strings.IndexFunc(key, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0 {
+		return fmt.Errorf("%w: control characters and whitespace are not allowed", ErrInvalidKey)
+	}
+	return nil
+}
+
+// Forms of key normalization.
+const (
+	// NormalizeLowercase lowercases keys, so clients spelling them with
+	// another case address the same key.
+	NormalizeLowercase = "lowercase"
+	// NormalizeNFC composes keys in Unicode normalization form C, so the
+	// precomposed and decomposed spellings of a character address the same
+	// key.
+	NormalizeNFC = "nfc"
+)
+
+// KeyNormalizer rewrites keys to a canonical form before they are stored
+// or looked up. The zero KeyNormalizer leaves keys as they are.
+type KeyNormalizer struct {
+	lowercase, nfc bool
+}
+
+// ParseKeyNormalizer returns the normalizer applying forms, such as
+// lowercase and nfc.
+func ParseKeyNormalizer(forms []string) (KeyNormalizer, error) {
+	var n KeyNormalizer
+	for _, form := range forms {
+		switch strings.ToLower(strings.TrimSpace(form)) {
+		case NormalizeLowercase:
+			n.lowercase = true
+		case NormalizeNFC:
+			n.nfc = true
+		default:
+			return KeyNormalizer{}, fmt.Errorf("unknown key normalization %q, expected %s or %s", form, NormalizeLowercase, NormalizeNFC)
+		}
+	}
+	return n, nil
+}
+
+// Normalize returns the canonical form of key. Keys that aren't valid UTF-8,
+// such as the binary keys of the base64 routes, are left as they are.
+func (n KeyNormalizer) Normalize(key string) string {
+	if n == (KeyNormalizer{}) || !utf8.ValidString(key) {
+		return key
+	}
+	if n.lowercase {
+		key = strings.ToLower(key)
+	}
+	// Lowercasing may decompose characters, so keys are composed last.
+	if n.nfc && !norm.NFC.IsNormalString(key) {
+		key = norm.NFC.String(key)
+	}
+	return key
+}
+
+// NormalizeKey returns the canonical form of key, as stored. The operations
+// of the Service normalize the keys they are given, the protocols only
+// normalize the keys they use otherwise, such as the prefixes of watches.
+func (s *Service) NormalizeKey(key string) string {
+	return s.normalizer.Normalize(key)
+}
+
+// DecodeKey decodes a key encoded in base64url, with or without padding,
+// addressing the keys the path of the key routes can't hold, such as keys
+// containing slashes or arbitrary bytes.
+func DecodeKey(encoded string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidKey
+	}
+	return string(key), nil
+}
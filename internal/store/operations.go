@@ -0,0 +1,404 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/metrics"
+	"codesignal/internal/rdb"
+	"codesignal/internal/repository"
+)
+
+var (
+	ErrInvalidKey  = errors.New("invalid key")
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyExists   = errors.New("key already exists")
+	ErrInvalidDump = errors.New("invalid dump payload")
+	// ErrReadOnly is returned by the operations modifying keys while the
+	// store is in maintenance.
+	ErrReadOnly = errors.New("store is in maintenance mode, writes are disabled")
+	// ErrMemoryLimit is returned by the operations adding keys or values
+	// while the process is past its soft memory limit.
+	ErrMemoryLimit = errors.New("soft memory limit exceeded, writes are rejected")
+)
+
+// StorageError is returned when the repository fails an operation. Op is
+// the failed repository call, such as "get" or "set".
+type StorageError struct {
+	Op  string
+	Err error
+}
+
+func (e *StorageError) Error() string {
+	return "failed to " + e.Op + " key: " + e.Err.Error()
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// The methods below implement the store operations independently of the
+// HTTP API, so every protocol served by the process shares the same
+// validation and semantics. They check the permissions of the authenticated
+// principal of the context on the key, and its owner, failing with an error
+// wrapping auth.ErrForbidden.
+
+// Create stores a new key, failing with ErrKeyExists if it is already set.
+// A ttl of zero or less stores the key without expiry.
+func (s *Service) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.CreateOwned(ctx, key, value, ttl, "")
+}
+
+// CreateOwned is Create, with owner as the only subject allowed to modify
+// the key besides admins when not empty. Principals may only create the
+// keys they own, unless granted admin on them.
+func (s *Service) CreateOwned(ctx context.Context, key string, value []byte, ttl time.Duration, owner string) error {
+	_, err := s.createScheduled(ctx, key, value, ttl, owner, time.Time{})
+	return err
+}
+
+// createScheduled is CreateOwned, deleting the key at deleteAt unless zero,
+// and returning the version of the key.
+func (s *Service) createScheduled(ctx context.Context, key string, value []byte, ttl time.Duration, owner string, deleteAt time.Time) (uint64, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return 0, err
+	}
+	if s.maintenance.Enabled() {
+		return 0, ErrReadOnly
+	}
+	if err := s.checkMemory(); err != nil {
+		return 0, err
+	}
+	if p, ok := auth.FromContext(ctx); ok && owner != "" && owner != p.Subject && !p.Can(auth.Admin, key) {
+		return 0, fmt.Errorf("%w: can't create a key owned by %s", auth.ErrForbidden, owner)
+	}
+	if err := s.validate(key, value); err != nil {
+		return 0, err
+	}
+	if err := s.checkDeleteAt(deleteAt, ttl); err != nil {
+		return 0, err
+	}
+
+	_, exists, err := s.store.Get(ctx, key)
+	if err != nil {
+		return 0, &StorageError{Op: "get", Err: err}
+	}
+	if exists {
+		return 0, ErrKeyExists
+	}
+	if value, err = s.beforeSet(ctx, key, value); err != nil {
+		return 0, err
+	}
+
+	// The owner is stored first, so the key is never unprotected, and the
+	// deletion time, so the key is never kept past it.
+	if owner != "" {
+		if err := s.putOwner(ctx, key, owner, ttl); err != nil {
+			return 0, err
+		}
+	}
+	if !deleteAt.IsZero() {
+		if err := s.putDeleteAt(ctx, key, deleteAt); err != nil {
+			return 0, err
+		}
+	}
+	return s.put(ctx, key, value, ttl, 0)
+}
+
+// Set stores key, replacing any existing value.
+// A ttl of zero or less stores the key without expiry.
+func (s *Service) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.SetIfVersion(ctx, key, value, ttl, 0)
+	return err
+}
+
+// SetIfVersion is Set, failing with ErrVersionMismatch unless key is at
+// version, any version when zero. It returns the new version of key.
+func (s *Service) SetIfVersion(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return 0, err
+	}
+	if s.maintenance.Enabled() {
+		return 0, ErrReadOnly
+	}
+	if err := s.checkMemory(); err != nil {
+		return 0, err
+	}
+	if key == "" || ReservedKey(key) {
+		return 0, ErrInvalidKey
+	}
+	if err := s.validate(key, value); err != nil {
+		return 0, err
+	}
+	owner, err := s.checkOwner(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if value, err = s.beforeSet(ctx, key, value); err != nil {
+		return 0, err
+	}
+
+	newVersion, err := s.put(ctx, key, value, ttl, version)
+	if err != nil {
+		return 0, err
+	}
+	// The owner expires with the new ttl of the key.
+	if owner != "" {
+		if err := s.putOwner(ctx, key, owner, ttl); err != nil {
+			return 0, err
+		}
+	}
+	return newVersion, nil
+}
+
+// Get returns the value of key, or ErrKeyNotFound.
+func (s *Service) Get(ctx context.Context, key string) ([]byte, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Read, key); err != nil {
+		return nil, err
+	}
+	if key == "" || ReservedKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	if err := s.beforeGet(ctx, key); err != nil {
+		return nil, err
+	}
+
+	s.hotKeys.Read(key)
+	value, exists, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// Expiry returns the time at which key expires, which is zero when the key
+// never expires, or ErrKeyNotFound.
+func (s *Service) Expiry(ctx context.Context, key string) (time.Time, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Read, key); err != nil {
+		return time.Time{}, err
+	}
+	if key == "" || ReservedKey(key) {
+		return time.Time{}, ErrInvalidKey
+	}
+
+	s.hotKeys.Read(key)
+	expiresAt, exists, err := s.store.Expiry(ctx, key)
+	if err != nil {
+		return time.Time{}, &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return time.Time{}, ErrKeyNotFound
+	}
+	return expiresAt, nil
+}
+
+// Delete removes key, or returns ErrKeyNotFound if it isn't set.
+func (s *Service) Delete(ctx context.Context, key string) error {
+	return s.DeleteIfVersion(ctx, key, 0)
+}
+
+// DeleteIfVersion is Delete, failing with ErrVersionMismatch unless key is
+// at version, any version when zero.
+func (s *Service) DeleteIfVersion(ctx context.Context, key string, version uint64) error {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return err
+	}
+	if s.maintenance.Enabled() {
+		return ErrReadOnly
+	}
+	if key == "" || ReservedKey(key) {
+		return ErrInvalidKey
+	}
+
+	_, exists, err := s.store.Get(ctx, key)
+	if err != nil {
+		return &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return ErrKeyNotFound
+	}
+	owner, err := s.checkOwner(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	s.hotKeys.Write(key)
+	if err := s.store.DeleteIfVersion(ctx, key, version); err != nil {
+		return versionError("delete", err)
+	}
+	// The owner is kept in a tombstone too, for Undelete to restore it.
+	if owner != "" {
+		if err := s.store.Delete(ctx, aclKey(key)); err != nil {
+			return &StorageError{Op: "delete", Err: err}
+		}
+	}
+	s.afterDelete(ctx, key)
+	return nil
+}
+
+// Undelete restores key from its tombstone, with its owner, reporting false
+// when there is none.
+func (s *Service) Undelete(ctx context.Context, key string) (bool, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return false, err
+	}
+	if s.maintenance.Enabled() {
+		return false, ErrReadOnly
+	}
+	if key == "" || ReservedKey(key) {
+		return false, ErrInvalidKey
+	}
+
+	// The owner is restored first, to check the principal may restore the
+	// key, and deleted again if not.
+	owned, err := s.store.Undelete(ctx, aclKey(key))
+	if err != nil {
+		return false, &StorageError{Op: "undelete", Err: err}
+	}
+	restored := false
+	if _, err = s.checkOwner(ctx, key); err == nil {
+		s.hotKeys.Write(key)
+		restored, err = s.store.Undelete(ctx, key)
+		if err != nil {
+			err = &StorageError{Op: "undelete", Err: err}
+		} else if restored && len(s.hooks) > 0 {
+			// The restored value goes through the hooks as a write, and
+			// is deleted again if they reject it.
+			if err = s.checkRestored(ctx, key); err != nil {
+				restored = false
+				if delErr := s.store.Delete(ctx, key); delErr != nil {
+					err = &StorageError{Op: "delete", Err: delErr}
+				}
+			}
+		}
+	}
+	if owned && !restored {
+		if delErr := s.store.Delete(ctx, aclKey(key)); delErr != nil && err == nil {
+			err = &StorageError{Op: "delete", Err: delErr}
+		}
+	}
+	return restored, err
+}
+
+// Increment adds delta to the base-10 integer stored at key and returns the
+// new value. A missing key counts as zero.
+func (s *Service) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return 0, err
+	}
+	if s.maintenance.Enabled() {
+		return 0, ErrReadOnly
+	}
+	if err := s.checkMemory(); err != nil {
+		return 0, err
+	}
+	if key == "" || ReservedKey(key) {
+		return 0, ErrInvalidKey
+	}
+	if len(key) > s.getMaxKeyLength() {
+		return 0, &LimitError{Field: "key", Limit: s.getMaxKeyLength(), Actual: len(key)}
+	}
+	if err := s.checkKey(key); err != nil {
+		return 0, err
+	}
+	if _, err := s.checkOwner(ctx, key); err != nil {
+		return 0, err
+	}
+
+	s.hotKeys.Write(key)
+	var n int64
+	err := s.update(ctx, "increment", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, n, err = repository.ApplyIncrement(value, exists, delta)
+		return value, err
+	}, func() error {
+		var err error
+		n, err = s.store.Increment(ctx, key, delta)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Dump returns the value of key serialized in the format of the Redis DUMP
+// command, with its checksum, or ErrKeyNotFound.
+func (s *Service) Dump(ctx context.Context, key string) ([]byte, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return rdb.EncodeDump(value), nil
+}
+
+// Restore stores key with the value of a payload written by Dump or by
+// Redis, failing with ErrInvalidDump if the payload is corrupted. Unless
+// replace is set, it fails with ErrKeyExists if key is already set. A ttl
+// of zero or less stores the key without expiry.
+func (s *Service) Restore(ctx context.Context, key string, payload []byte, ttl time.Duration, replace bool) error {
+	if key == "" || ReservedKey(key) {
+		return ErrInvalidKey
+	}
+	value, err := rdb.DecodeDump(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidDump, err)
+	}
+	if replace {
+		return s.Set(ctx, key, value, ttl)
+	}
+	return s.Create(ctx, key, value, ttl)
+}
+
+// Scan returns up to limit keys starting with prefix after the key after,
+// in lexical order. A limit of zero or less returns every matching key.
+func (s *Service) Scan(ctx context.Context, prefix, after string, limit int) ([]repository.Item, error) {
+	prefix, after = s.NormalizeKey(prefix), s.NormalizeKey(after)
+	if err := auth.Authorize(ctx, auth.Read, prefix); err != nil {
+		return nil, err
+	}
+	items, err := s.store.Scan(ctx, prefix, after, limit)
+	if err != nil {
+		return nil, &StorageError{Op: "scan", Err: err}
+	}
+	// Owners sort last, so the page only ends early on the last keys.
+	return slices.DeleteFunc(items, func(item repository.Item) bool { return ReservedKey(item.Key) }), nil
+}
+
+// checkMemory fails the operations adding keys or values past the soft
+// memory limit. Deletes free memory and are always served.
+func (s *Service) checkMemory() error {
+	if s.memory.Exceeded() {
+		metrics.MemoryRejectedWrites.Add(1)
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
+func (s *Service) put(ctx context.Context, key string, value []byte, ttl time.Duration, version uint64) (uint64, error) {
+	s.hotKeys.Write(key)
+	metrics.KeyLength.Observe(int64(len(key)))
+	metrics.ValueSize.Observe(int64(len(value)))
+
+	newVersion, err := s.store.SetIfVersion(ctx, key, value, ttl, version)
+	if err != nil {
+		return 0, versionError("set", err)
+	}
+	s.afterSet(ctx, key, value)
+	return newVersion, nil
+}
@@ -0,0 +1,176 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Values can be read without the JSON envelope, which roughly doubles the
+// size of the response of large values once escaped, with ?format=raw or
+// an Accept header preferring a raw media type over JSON. Errors are still
+// answered with the envelope.
+//
+// The value routes, /v1/key/:key/value, transfer the raw value alone in
+// both directions, sparing large values the escaping and the copies of the
+// JSON body: PUT buffers the body as the value, sent with a Content-Length
+// or in chunks, and GET serves it with its Content-Length and byte ranges,
+// so clients can resume it. The store holds values in memory, so both
+// buffer the whole value, bounded by the value size limit; clients stream
+// it, not the server.
+
+// errInvalidFormat is returned for a format query parameter other than
+// json and raw.
+var errInvalidFormat = errors.New("format must be json or raw")
+
+// rawMediaTypes are the media types of the Accept header answered with the
+// raw value.
+var rawMediaTypes = map[string]bool{
+	"application/octet-stream": true,
+	"text/plain":               true,
+}
+
+// wantsRaw reports whether r asks for the raw value, with its format query
+// parameter or else its Accept header.
+func wantsRaw(r *http.Request) (bool, error) {
+	// Parsing the query and the Accept header allocates, most requests
+	// have neither or accept JSON.
+	if r.URL.RawQuery != "" {
+		switch r.URL.Query().Get("format") {
+		case "raw":
+			return true, nil
+		case "json":
+			return false, nil
+		case "":
+		default:
+			return false, errInvalidFormat
+		}
+	}
+	accept := r.Header.Get("Accept")
+	if accept == "" || accept == "*/*" || accept == "application/json" {
+		return false, nil
+	}
+
+	// The first media type of the highest quality wins, wildcards
+	// preferring JSON.
+	raw, best := false, 0.0
+	for _, accepted := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accepted))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > best {
+			raw, best = rawMediaTypes[mediaType], q
+		}
+	}
+	return raw, nil
+}
+
+// writeRaw writes value as the body of the response, with the content type
+// it is detected as.
+func writeRaw(w http.ResponseWriter, value []byte) {
+	writeRawType(w, http.DetectContentType(value), value)
+}
+
+// writeRawType writes value as the body of the response, of contentType.
+func writeRawType(w http.ResponseWriter, contentType string, value []byte) {
+	w.Header().Set("Content-Type", contentType)
+	// The value is written by clients, browsers mustn't guess another type.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(value)
+}
+
+// PutValue sets a key to the raw request body, with the ttl and version
+// query parameters.
+func (s *Service) PutValue(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	ttl, ok := s.parseTTL(w, r, r.URL.Query().Get("ttl"))
+	if !ok {
+		return
+	}
+	version, err := parseExpectedVersion(r)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "version"}})
+		return
+	}
+	// Bodies over the limit are rejected before being read when their
+	// size is announced.
+	maxValueSize := int64(s.getMaxValueSize())
+	if r.ContentLength > maxValueSize {
+		s.writeDecodeError(w, r, &http.MaxBytesError{Limit: maxValueSize})
+		return
+	}
+	value, err := readValue(http.MaxBytesReader(w, r.Body, maxValueSize), r.ContentLength)
+	if err != nil {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	version, err = s.SetIfVersion(r.Context(), key, value, ttl, version)
+	if err != nil {
+		s.writeError(w, r, err, "failed to set key")
+		return
+	}
+	setVersionHeader(w, version)
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "value stored", StatusCode: StatusSuccess})
+}
+
+// maxValuePrealloc bounds the buffer allocated for a value before its
+// bytes arrive, whatever the Content-Length announces.
+const maxValuePrealloc = 64 << 10
+
+// readValue reads the value of a request body of size bytes, or of an
+// unknown size when negative, growing the buffer as the bytes arrive.
+func readValue(body io.Reader, size int64) ([]byte, error) {
+	value := make([]byte, 0, min(max(size, 512), maxValuePrealloc))
+	for int64(len(value)) != size {
+		if len(value) == cap(value) {
+			// Grown by append, as io.ReadAll does.
+			value = append(value, 0)[:len(value)]
+		}
+		n, err := body.Read(value[len(value):cap(value)])
+		value = value[:len(value)+n]
+		if errors.Is(err, io.EOF) {
+			if size >= 0 && int64(len(value)) != size {
+				return nil, fmt.Errorf("body shorter than its Content-Length: %w", io.ErrUnexpectedEOF)
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// GetValue serves the raw value of a key, with its detected content type
+// and support for Range requests.
+func (s *Service) GetValue(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	value, err := s.Get(r.Context(), key)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get key")
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(value))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(value))
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Keys may be created with a deletion time, unlike a ttl an absolute time
+// at which a schedule.Scheduler deletes them as Delete does, leaving a
+// tombstone and publishing a delete event rather than an expire one. The
+// time is stored in the repository beside the key, under DeleteAtPrefix, so
+// it is replicated, persisted and migrated with the key, and removed once
+// the key is deleted.
+
+// DeleteAtPrefix prefixes the keys storing the deletion times of keys.
+const DeleteAtPrefix = "\xffdelete:"
+
+// ErrInvalidDeleteAt is returned for the deletion times that can't be
+// scheduled.
+var ErrInvalidDeleteAt = errors.New("invalid delete_at")
+
+// DeleteAtKey returns the key storing the deletion time of key.
+func DeleteAtKey(key string) string {
+	return DeleteAtPrefix + key
+}
+
+// ParseDeleteAt parses a deletion time stored under DeleteAtPrefix.
+func ParseDeleteAt(value []byte) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, string(value))
+}
+
+// CreateUntil is CreateOwned, scheduling the deletion of the key at
+// deleteAt, which must be in the future.
+func (s *Service) CreateUntil(ctx context.Context, key string, value []byte, deleteAt time.Time, owner string) error {
+	_, err := s.createScheduled(ctx, key, value, 0, owner, deleteAt)
+	return err
+}
+
+// checkDeleteAt reports the deletion times that can't be scheduled, zero
+// for none.
+func (s *Service) checkDeleteAt(deleteAt time.Time, ttl time.Duration) error {
+	switch {
+	case deleteAt.IsZero():
+		return nil
+	case !s.scheduleDeletes:
+		return fmt.Errorf("%w: scheduled deletion isn't supported by this store", ErrInvalidDeleteAt)
+	case ttl > 0:
+		return fmt.Errorf("%w: a key can't have both a ttl and a delete_at", ErrInvalidDeleteAt)
+	case !deleteAt.After(time.Now()):
+		return fmt.Errorf("%w: %s isn't in the future", ErrInvalidDeleteAt, deleteAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// putDeleteAt stores the deletion time of key.
+func (s *Service) putDeleteAt(ctx context.Context, key string, deleteAt time.Time) error {
+	if err := s.store.Set(ctx, DeleteAtKey(key), []byte(deleteAt.UTC().Format(time.RFC3339Nano))); err != nil {
+		return &StorageError{Op: "set", Err: err}
+	}
+	return nil
+}
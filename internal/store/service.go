@@ -6,14 +6,28 @@
 package store
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog"
 
+	"codesignal/internal/auth"
+	"codesignal/internal/hotkeys"
+	"codesignal/internal/jsonpath"
+	"codesignal/internal/maintenance"
+	"codesignal/internal/memlimit"
+	"codesignal/internal/metrics"
 	"codesignal/internal/repository"
 )
 
@@ -32,6 +46,31 @@ const (
 type KeyValue struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// TTL is an optional time-to-live such as "30s" or "1h", after
+	// which the key expires.
+	TTL string `json:"ttl,omitempty"`
+	// Owner is the subject allowed to modify a created key besides admins,
+	// anyone granted write when empty.
+	Owner string `json:"owner,omitempty"`
+	// DeleteAt is the time a created key is deleted at, as an alternative
+	// to its TTL.
+	DeleteAt *time.Time `json:"delete_at,omitempty"`
+}
+
+// IncrementRequest represents the payload for incrementing a counter key.
+// Delta defaults to 1 when omitted.
+type IncrementRequest struct {
+	Delta *int64 `json:"delta"`
+}
+
+// RestoreRequest represents the payload for restoring a dumped key.
+type RestoreRequest struct {
+	// Payload is a value serialized by the dump endpoint or the Redis DUMP
+	// command, base64 encoded.
+	Payload string `json:"payload"`
+	TTL     string `json:"ttl,omitempty"`
+	// Replace overwrites the key if it already exists.
+	Replace bool `json:"replace,omitempty"`
 }
 
 // StatusCode represents custom application status code for the API response.
@@ -39,183 +78,828 @@ type StatusCode int
 
 // Status codes for the key-value store operations
 const (
-	StatusSuccess       StatusCode = 1000
-	StatusKeyNotFound   StatusCode = 1001
-	StatusKeyExists     StatusCode = 1002
-	StatusInvalidKey    StatusCode = 1003
-	StatusInvalidValue  StatusCode = 1004
-	StatusStorageError  StatusCode = 1005
-	StatusInvalidJSON   StatusCode = 1006
-	StatusKeyTooLong    StatusCode = 1007
-	StatusValueTooLarge StatusCode = 1008
+	StatusSuccess          StatusCode = 1000
+	StatusKeyNotFound      StatusCode = 1001
+	StatusKeyExists        StatusCode = 1002
+	StatusInvalidKey       StatusCode = 1003
+	StatusInvalidValue     StatusCode = 1004
+	StatusStorageError     StatusCode = 1005
+	StatusInvalidJSON      StatusCode = 1006
+	StatusKeyTooLong       StatusCode = 1007
+	StatusValueTooLarge    StatusCode = 1008
+	StatusCanceled         StatusCode = 1009
+	StatusTimeout          StatusCode = 1010
+	StatusInvalidTTL       StatusCode = 1011
+	StatusNoLeader         StatusCode = 1012
+	StatusShardUnavailable StatusCode = 1013
+	StatusInvalidQuorum    StatusCode = 1014
+	StatusQuorumNotMet     StatusCode = 1015
+	StatusWatchEnded       StatusCode = 1016
+	StatusNotReady         StatusCode = 1017
+	StatusUnauthorized     StatusCode = 1018
+	StatusForbidden        StatusCode = 1019
+	StatusInvalidConfig    StatusCode = 1020
+	StatusMaintenance      StatusCode = 1021
+	StatusInvalidBackup    StatusCode = 1022
+	StatusOverloaded       StatusCode = 1023
+	StatusUnsupportedMedia StatusCode = 1024
+	StatusRouteNotFound    StatusCode = 1025
+	StatusMethodNotAllowed StatusCode = 1026
+	StatusMemberNotFound   StatusCode = 1027
+	StatusFieldNotFound    StatusCode = 1028
+	StatusPathNotFound     StatusCode = 1029
+	StatusWebhookNotFound  StatusCode = 1030
+	StatusChangesExpired   StatusCode = 1031
+	StatusCursorNotFound   StatusCode = 1032
+	StatusVersionNotFound  StatusCode = 1033
+	StatusVersionMismatch  StatusCode = 1034
+	StatusMemoryLimit      StatusCode = 1035
+	StatusInvalidTenant    StatusCode = 1036
+	StatusTenantNotFound   StatusCode = 1037
+	StatusRateLimited      StatusCode = 1038
+	StatusSchemaNotFound   StatusCode = 1039
+	StatusNotRouted        StatusCode = 1040
 )
 
+// StatusClientClosedRequest is the non-standard HTTP status used when the
+// client went away before the request completed.
+const StatusClientClosedRequest = 499
+
 // Response represents the API response
 type Response struct {
 	Message    string     `json:"message"`
 	StatusCode StatusCode `json:"status_code"`
 	Data       *KeyValue  `json:"data,omitempty"`
+	// detail is the error detail of the ResponseV2 of the response.
+	detail *ErrorDetail
+}
+
+// KeysResponse represents a page of keys listed by the API.
+type KeysResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Data       []KeyValue `json:"data"`
+	// Next is the after parameter of the next page, empty on the last page.
+	Next string `json:"next,omitempty"`
+}
+
+// HotKeysResponse lists the most accessed keys.
+type HotKeysResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	// SampleRate is the rate accesses are sampled at, counts are estimates.
+	SampleRate int                `json:"sample_rate"`
+	Reads      []hotkeys.KeyCount `json:"reads"`
+	Writes     []hotkeys.KeyCount `json:"writes"`
 }
 
+// MaintenanceResponse reports whether the store is in maintenance.
+type MaintenanceResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Enabled    bool       `json:"enabled"`
+	// Since is when maintenance started, while enabled.
+	Since *time.Time `json:"since,omitempty"`
+	// Pinned reports that maintenance can't be disabled, on the replicas.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// MaintenanceRequest enables or disables maintenance.
+type MaintenanceRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// Number of keys listed by HotKeys.
+const (
+	DefaultHotKeysLimit = 10
+	MaxHotKeysLimit     = 100
+)
+
+// Page sizes of ListKeys.
+const (
+	DefaultKeysLimit = 100
+	MaxKeysLimit     = 1000
+)
+
 // Service for managing a key value store.
 type Service struct {
-	maxKeyLength int
-	MaxValueSize int
-	log          zerolog.Logger
-	store        repository.Store
+	// Limits are atomic, so they can be reloaded while serving requests.
+	maxKeyLength atomic.Int64
+	maxValueSize atomic.Int64
+	keyPattern   atomic.Pointer[regexp.Regexp]
+	// normalizer isn't reloaded: the keys stored would no longer be found.
+	normalizer KeyNormalizer
+	// allowEmptyKeys keeps accepting the creation of the empty key, which
+	// can't be read back through the key routes.
+	allowEmptyKeys bool
+	// scheduleDeletes accepts the deletion times of created keys.
+	scheduleDeletes bool
+	log             zerolog.Logger
+	store           repository.Store
+	hotKeys         *hotkeys.Tracker
+	maintenance     *maintenance.Switch
+	memory          *memlimit.Guard
+	hooks           []Hook
+	responses       *responseCache
 }
 
 type Opts struct {
 	MaxKeyLength int
 	MaxValueSize int
+	// AllowEmptyKeys accepts creating the empty key, as earlier versions
+	// did, for clients relying on it.
+	AllowEmptyKeys bool
+	// ScheduleDeletes accepts creating keys with a deletion time, for the
+	// stores whose keys are deleted by a schedule.Scheduler.
+	ScheduleDeletes bool
+	// KeyPattern is the pattern written keys must match, compiled by
+	// CompileKeyPattern. Nil rejects the keys holding control characters
+	// or whitespace.
+	KeyPattern *regexp.Regexp
+	// KeyNormalizer rewrites the keys of every operation to a canonical
+	// form, such as lowercase, before they are stored or looked up.
+	KeyNormalizer KeyNormalizer
+	// HotKeys tracks the accesses of keys, shared by the services of every
+	// protocol. Nil disables tracking.
+	HotKeys *hotkeys.Tracker
+	// Maintenance makes the store read-only while enabled, shared by the
+	// services of every protocol. Nil never enables it.
+	Maintenance *maintenance.Switch
+	// Memory rejects the writes adding keys or values past the soft memory
+	// limit. Nil never rejects them.
+	Memory *memlimit.Guard
+	// Hooks extend the operations on keys, run in order.
+	Hooks []Hook
+	// ResponseCacheSize is the number of responses of GetKey cached for
+	// the hot keys, zero disabling the cache.
+	ResponseCacheSize int
+}
+
+// bodyOverhead is the room left in request bodies for the fields other
+// than the key and the value.
+const bodyOverhead = 64 << 10
+
+// MaxBodySize returns the largest request body of the key routes holding
+// a key and a value within the limits: JSON escapes, such as \u003c, take
+// at most 6 bytes per byte of the key or value.
+func (o Opts) MaxBodySize() int64 {
+	maxKeyLength, maxValueSize := o.MaxKeyLength, o.MaxValueSize
+	if maxKeyLength <= 0 {
+		maxKeyLength = DefaultMaxKeyLength
+	}
+	if maxValueSize <= 0 {
+		maxValueSize = DefaultMaxValueSize
+	}
+	return 6*int64(maxKeyLength+maxValueSize) + bodyOverhead
 }
 
 // NewService returns a new instance of Service.
 func NewService(log zerolog.Logger, store repository.Store, opts Opts) *Service {
-	return &Service{
-		maxKeyLength: opts.MaxKeyLength,
-		MaxValueSize: opts.MaxValueSize,
-		log:          log,
-		store:        store,
+	s := &Service{
+		log:             log,
+		store:           store,
+		hotKeys:         opts.HotKeys,
+		maintenance:     opts.Maintenance,
+		memory:          opts.Memory,
+		allowEmptyKeys:  opts.AllowEmptyKeys,
+		scheduleDeletes: opts.ScheduleDeletes,
+		normalizer:      opts.KeyNormalizer,
+		hooks:           opts.Hooks,
+		responses:       newResponseCache(opts.ResponseCacheSize),
 	}
+	s.SetLimits(opts.MaxKeyLength, opts.MaxValueSize)
+	s.SetKeyPattern(opts.KeyPattern)
+	return s
+}
+
+// SetLimits replaces the maximum key length and value size, zero or less
+// restoring the defaults.
+func (s *Service) SetLimits(maxKeyLength, maxValueSize int) {
+	s.maxKeyLength.Store(int64(maxKeyLength))
+	s.maxValueSize.Store(int64(maxValueSize))
 }
 
 func (s *Service) getMaxKeyLength() int {
-	if s.maxKeyLength <= 0 {
-		return DefaultMaxKeyLength
+	if n := s.maxKeyLength.Load(); n > 0 {
+		return int(n)
 	}
 
-	return s.maxKeyLength
+	return DefaultMaxKeyLength
 }
 
 func (s *Service) getMaxValueSize() int {
-	if s.MaxValueSize <= 0 {
-		return DefaultMaxValueSize
+	if n := s.maxValueSize.Load(); n > 0 {
+		return int(n)
 	}
 
-	return s.MaxValueSize
+	return DefaultMaxValueSize
 }
 
-// validateKeyValue checks if the key-value pair meets the size requirements
-func (s *Service) validateKeyValue(kv KeyValue) error {
-	if len(kv.Key) > s.getMaxKeyLength() {
-		return fmt.Errorf("err: %w, max key length: %d", ErrKeyTooLong, s.getMaxKeyLength())
+// validate checks if the key-value pair meets the size requirements
+func (s *Service) validate(key string, value []byte) error {
+	if ReservedKey(key) || (key == "" && !s.allowEmptyKeys) {
+		return ErrInvalidKey
 	}
-	if len(kv.Value) > s.getMaxValueSize() {
-		return fmt.Errorf("err: %w, max value size: %d", ErrValueTooLarge, s.getMaxValueSize())
+	if len(key) > s.getMaxKeyLength() {
+		return &LimitError{Field: "key", Limit: s.getMaxKeyLength(), Actual: len(key)}
+	}
+	// The empty key, when allowed, matches no rule.
+	if key != "" {
+		if err := s.checkKey(key); err != nil {
+			return err
+		}
+	}
+	if len(value) > s.getMaxValueSize() {
+		return &LimitError{Field: "value", Limit: s.getMaxValueSize(), Actual: len(value)}
 	}
 	return nil
 }
 
 func (s *Service) SetKey(w http.ResponseWriter, r *http.Request) {
 	var kv KeyValue
-	if err := json.NewDecoder(r.Body).Decode(&kv); err != nil {
-		s.log.Error().Err(err).Msg("failed to decode request body")
-		s.doJSONWrite(w, http.StatusBadRequest, Response{Message: "invalid request body", StatusCode: StatusInvalidJSON})
+	if err := decodeBody(r.Body, &kv); err != nil {
+		s.writeDecodeError(w, r, err)
 		return
 	}
 
-	if err := s.validateKeyValue(kv); err != nil {
-		s.log.Error().Err(err).Msg("invalid key-value pair")
-		var statusCode StatusCode
-		if errors.Is(err, ErrKeyTooLong) {
-			statusCode = StatusKeyTooLong
-		} else {
-			statusCode = StatusValueTooLarge
-		}
-		s.doJSONWrite(w, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: statusCode})
+	logKey(r, kv.Key)
+
+	ttl, ok := s.parseTTL(w, r, kv.TTL)
+	if !ok {
+		return
+	}
+
+	var deleteAt time.Time
+	if kv.DeleteAt != nil {
+		deleteAt = *kv.DeleteAt
+	}
+	version, err := s.createScheduled(r.Context(), kv.Key, []byte(kv.Value), ttl, kv.Owner, deleteAt)
+	if err != nil {
+		s.writeError(w, r, err, "failed to set key")
+		return
+	}
+
+	setVersionHeader(w, version)
+	s.doJSONWrite(w, r, http.StatusCreated, Response{Message: "key created successfully", StatusCode: StatusSuccess})
+}
+
+func (s *Service) GetKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// The key is normalized up front, so responses, cached ones included,
+	// hold the key as stored.
+	key := s.NormalizeKey(params.ByName("key"))
+	if key == "" {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
+		return
+	}
+	raw, err := wantsRaw(r)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue})
+		return
+	}
+	query, past, err := parseVersionQuery(r)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue})
+		return
+	}
+	sel, selected, err := selectParam(r)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "select"}})
 		return
 	}
 
-	_, exists, err := s.store.Get(r.Context(), kv.Key)
+	var value []byte
+	var version uint64
+	if past {
+		value, version, err = s.getVersion(r.Context(), key, query)
+	} else {
+		value, version, err = s.GetWithVersion(r.Context(), key)
+	}
 	if err != nil {
-		s.log.Error().Err(err).Msg("failed to get key")
-		s.doJSONWrite(w, http.StatusInternalServerError, Response{Message: "failed to get key", StatusCode: StatusStorageError})
+		s.writeError(w, r, err, "failed to get key")
+		return
+	}
+	if selected {
+		// Only the selected value is sent, so clients reading a field of
+		// a large document don't transfer all of it.
+		if value, err = project(value, sel); err != nil {
+			s.writeError(w, r, err, "failed to select value")
+			return
+		}
+	}
+
+	var resp *keyResponse
+	if past || selected {
+		// Past versions and projections would evict the responses of the
+		// current values.
+		resp = newKeyResponse(key, value, version, false)
+	} else {
+		resp = s.responses.get(key, value, version)
+	}
+	etag := resp.etag
+	if raw {
+		etag = resp.rawETag
+	}
+	w.Header()["Etag"] = etag
+	if resp.version != nil {
+		w.Header()[VersionHeader] = resp.version
+	}
+	if notModified(r, etag[0]) {
+		metrics.NotModifiedResponses.Add(1)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	switch {
+	case raw && selected:
+		writeRawType(w, "application/json", value)
+	case raw:
+		writeRaw(w, value)
+	default:
+		s.writeKeyFound(w, r, resp)
+	}
+}
+
+func (s *Service) DeleteKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	key := params.ByName("key")
+	if key == "" {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
 		return
 	}
 
-	if exists {
-		s.doJSONWrite(w, http.StatusConflict, Response{Message: "key already exists", StatusCode: StatusKeyExists})
+	version, err := parseExpectedVersion(r)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "version"}})
 		return
 	}
 
-	if err := s.store.Set(r.Context(), kv.Key, []byte(kv.Value)); err != nil {
-		s.log.Error().Err(err).Msg("failed to set key")
-		s.doJSONWrite(w, http.StatusInternalServerError, Response{Message: "failed to set key", StatusCode: StatusStorageError})
+	if err := s.DeleteIfVersion(r.Context(), key, version); err != nil {
+		s.writeError(w, r, err, "failed to delete key")
 		return
 	}
 
-	s.doJSONWrite(w, http.StatusCreated, Response{Message: "key created successfully", StatusCode: StatusSuccess})
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "key deleted successfully", StatusCode: StatusSuccess})
 }
 
-func (s *Service) GetKey(w http.ResponseWriter, r *http.Request) {
+func (s *Service) UndeleteKey(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
 
 	key := params.ByName("key")
 	if key == "" {
-		s.doJSONWrite(w, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
 		return
 	}
 
-	kv, exists, err := s.store.Get(r.Context(), key)
+	restored, err := s.Undelete(r.Context(), key)
 	if err != nil {
-		s.log.Error().Err(err).Msg("failed to get key")
-		s.doJSONWrite(w, http.StatusInternalServerError, Response{Message: "failed to get key", StatusCode: StatusStorageError})
+		s.writeError(w, r, err, "failed to undelete key")
+		return
+	}
+
+	if !restored {
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: "deleted key not found", StatusCode: StatusKeyNotFound})
+		return
+	}
+
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "key restored successfully", StatusCode: StatusSuccess})
+}
+
+func (s *Service) IncrementKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	// The key is normalized up front, so its length is checked and it is
+	// answered as stored.
+	key := s.NormalizeKey(params.ByName("key"))
+	if key == "" {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
 		return
 	}
 
-	if !exists {
-		s.doJSONWrite(w, http.StatusNotFound, Response{Message: "key not found", StatusCode: StatusKeyNotFound})
+	if len(key) > s.getMaxKeyLength() {
+		err := &LimitError{Field: "key", Limit: s.getMaxKeyLength(), Actual: len(key)}
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusKeyTooLong, detail: err.detail()})
 		return
 	}
 
-	s.doJSONWrite(w, http.StatusOK, Response{
-		Message:    "key found",
+	var req IncrementRequest
+	if err := decodeBody(r.Body, &req); err != nil && !errors.Is(err, io.EOF) {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	delta := int64(1)
+	if req.Delta != nil {
+		delta = *req.Delta
+	}
+
+	value, err := s.Increment(r.Context(), key, delta)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotInteger) || errors.Is(err, repository.ErrOverflow) {
+			s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue})
+			return
+		}
+		s.writeError(w, r, err, "failed to increment key")
+		return
+	}
+
+	s.doJSONWrite(w, r, http.StatusOK, Response{
+		Message:    "key incremented successfully",
 		StatusCode: StatusSuccess,
 		Data: &KeyValue{
 			Key:   key,
-			Value: string(kv),
+			Value: strconv.FormatInt(value, 10),
 		},
 	})
 }
 
-func (s *Service) DeleteKey(w http.ResponseWriter, req *http.Request) {
-	params := httprouter.ParamsFromContext(req.Context())
+// DumpKey returns the value of a key serialized in the format of the Redis
+// DUMP command, base64 encoded, for RestoreKey on another instance.
+func (s *Service) DumpKey(w http.ResponseWriter, r *http.Request) {
+	key := s.NormalizeKey(httprouter.ParamsFromContext(r.Context()).ByName("key"))
 
-	key := params.ByName("key")
-	if key == "" {
-		s.doJSONWrite(w, http.StatusBadRequest, Response{Message: "invalid key", StatusCode: StatusInvalidKey})
+	payload, err := s.Dump(r.Context(), key)
+	if err != nil {
+		s.writeError(w, r, err, "failed to dump key")
 		return
 	}
 
-	_, exists, err := s.store.Get(req.Context(), key)
-	if err != nil {
-		s.log.Error().Err(err).Msg("failed to get key")
-		s.doJSONWrite(w, http.StatusInternalServerError, Response{Message: "failed to get key", StatusCode: StatusStorageError})
+	s.doJSONWrite(w, r, http.StatusOK, Response{
+		Message:    "key dumped",
+		StatusCode: StatusSuccess,
+		Data: &KeyValue{
+			Key:   key,
+			Value: base64.StdEncoding.EncodeToString(payload),
+		},
+	})
+}
+
+// RestoreKey creates a key from a payload returned by DumpKey or by the
+// Redis DUMP command. Existing keys are only replaced when asked to.
+func (s *Service) RestoreKey(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	var req RestoreRequest
+	if err := decodeBody(r.Body, &req); err != nil {
+		s.writeDecodeError(w, r, err)
 		return
 	}
 
-	if !exists {
-		s.doJSONWrite(w, http.StatusNotFound, Response{Message: "key not found", StatusCode: StatusKeyNotFound})
+	payload, err := base64.StdEncoding.DecodeString(req.Payload)
+	if err != nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid payload, expected base64", StatusCode: StatusInvalidValue})
+		return
+	}
+	ttl, ok := s.parseTTL(w, r, req.TTL)
+	if !ok {
 		return
 	}
 
-	if err := s.store.Delete(req.Context(), key); err != nil {
-		s.log.Error().Err(err).Msg("failed to delete key")
-		s.doJSONWrite(w, http.StatusInternalServerError, Response{Message: "failed to delete key", StatusCode: StatusStorageError})
+	if err := s.Restore(r.Context(), key, payload, ttl, req.Replace); err != nil {
+		s.writeError(w, r, err, "failed to restore key")
 		return
 	}
 
-	s.doJSONWrite(w, http.StatusOK, Response{Message: "key deleted successfully", StatusCode: StatusSuccess})
+	s.doJSONWrite(w, r, http.StatusCreated, Response{Message: "key restored successfully", StatusCode: StatusSuccess})
 }
 
-func (s *Service) doJSONWrite(w http.ResponseWriter, code int, obj any) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	err := json.NewEncoder(w).Encode(obj)
+// parseTTL parses an optional ttl of a request, reporting invalid ones.
+func (s *Service) parseTTL(w http.ResponseWriter, r *http.Request, v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, true
+	}
+	ttl, err := time.ParseDuration(v)
+	if err != nil || ttl <= 0 {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{
+			Message:    "invalid ttl, expected a positive duration such as 30s",
+			StatusCode: StatusInvalidTTL,
+			detail:     &ErrorDetail{Field: "ttl"},
+		})
+		return 0, false
+	}
+	return ttl, true
+}
+
+// ListKeys lists the keys starting with the prefix query parameter in lexical
+// order, a page of up to limit keys after the key after. Keys expiring report
+// their remaining time-to-live.
+func (s *Service) ListKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	limit := DefaultKeysLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxKeysLimit {
+			s.doJSONWrite(w, r, http.StatusBadRequest, KeysResponse{Message: "limit must be between 1 and 1000", StatusCode: StatusInvalidValue})
+			return
+		}
+		limit = n
+	}
+
+	items, err := s.Scan(r.Context(), query.Get("prefix"), query.Get("after"), limit)
 	if err != nil {
-		s.log.Error().Err(err).Msg("error writing response")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		s.writeError(w, r, err, "failed to list keys")
+		return
+	}
+
+	resp := KeysResponse{Message: "keys listed", StatusCode: StatusSuccess, Data: make([]KeyValue, 0, len(items))}
+	for _, item := range items {
+		kv := KeyValue{Key: item.Key, Value: string(item.Value)}
+		if !item.ExpiresAt.IsZero() {
+			kv.TTL = max(time.Until(item.ExpiresAt).Round(time.Second), time.Second).String()
+		}
+		resp.Data = append(resp.Data, kv)
 	}
+	if len(items) == limit {
+		resp.Next = items[len(items)-1].Key
+	}
+	s.doJSONWrite(w, r, http.StatusOK, resp)
+}
+
+// HotKeys lists the most read and most written keys, up to the limit query
+// parameter of each.
+func (s *Service) HotKeys(w http.ResponseWriter, r *http.Request) {
+	limit := DefaultHotKeysLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > MaxHotKeysLimit {
+			s.doJSONWrite(w, r, http.StatusBadRequest, HotKeysResponse{Message: "limit must be between 1 and 100", StatusCode: StatusInvalidValue})
+			return
+		}
+		limit = n
+	}
+
+	resp := HotKeysResponse{
+		Message:    "hot keys listed",
+		StatusCode: StatusSuccess,
+		SampleRate: s.hotKeys.SampleRate(),
+		Reads:      s.hotKeys.Reads(limit),
+		Writes:     s.hotKeys.Writes(limit),
+	}
+	if resp.SampleRate == 0 {
+		resp.Message = "hot key tracking is disabled"
+	}
+	// Lists are empty rather than null before any access is sampled.
+	if resp.Reads == nil {
+		resp.Reads = []hotkeys.KeyCount{}
+	}
+	if resp.Writes == nil {
+		resp.Writes = []hotkeys.KeyCount{}
+	}
+	s.doJSONWrite(w, r, http.StatusOK, resp)
+}
+
+// Maintenance reports whether the store is in maintenance.
+func (s *Service) Maintenance(w http.ResponseWriter, r *http.Request) {
+	s.doJSONWrite(w, r, http.StatusOK, s.maintenanceResponse())
+}
+
+// SetMaintenance enables or disables maintenance, as set by the enabled
+// field of the body.
+func (s *Service) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req MaintenanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "invalid request body, expected enabled", StatusCode: StatusInvalidJSON})
+		return
+	}
+
+	if !*req.Enabled && s.maintenance.Pinned() {
+		s.doJSONWrite(w, r, http.StatusConflict, Response{Message: "maintenance mode is pinned, the store is a read-only replica", StatusCode: StatusMaintenance})
+		return
+	}
+
+	s.maintenance.Set(*req.Enabled)
+	s.logger(r).Warn().Bool("enabled", *req.Enabled).Msg("maintenance mode switched")
+	s.doJSONWrite(w, r, http.StatusOK, s.maintenanceResponse())
+}
+
+func (s *Service) maintenanceResponse() MaintenanceResponse {
+	if !s.maintenance.Enabled() {
+		return MaintenanceResponse{Message: "maintenance mode disabled", StatusCode: StatusSuccess}
+	}
+	since := s.maintenance.Since()
+	return MaintenanceResponse{
+		Message: "maintenance mode enabled, writes are disabled", StatusCode: StatusSuccess,
+		Enabled: true, Since: &since, Pinned: s.maintenance.Pinned(),
+	}
+}
 
+// writeError reports a failed store operation, mapping domain errors to
+// their status codes and anything else to a storage error.
+func (s *Service) writeError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	var storageErr *StorageError
+	var syntaxErr *jsonpath.SyntaxError
+	switch {
+	case errors.As(err, &storageErr):
+		s.writeStorageError(w, r, storageErr.Err, "failed to "+storageErr.Op+" key")
+	case errors.Is(err, ErrKeyTooLong), errors.Is(err, ErrValueTooLarge):
+		s.logger(r).Error().Err(err).Msg("invalid key-value pair")
+		statusCode := StatusValueTooLarge
+		if errors.Is(err, ErrKeyTooLong) {
+			statusCode = StatusKeyTooLong
+		}
+		resp := Response{Message: err.Error(), StatusCode: statusCode}
+		var limitErr *LimitError
+		if errors.As(err, &limitErr) {
+			resp.detail = limitErr.detail()
+		}
+		s.doJSONWrite(w, r, http.StatusBadRequest, resp)
+	case errors.Is(err, auth.ErrForbidden):
+		s.doJSONWrite(w, r, http.StatusForbidden, Response{Message: err.Error(), StatusCode: StatusForbidden})
+	case errors.Is(err, ErrReadOnly):
+		s.doJSONWrite(w, r, http.StatusServiceUnavailable, Response{Message: err.Error(), StatusCode: StatusMaintenance})
+	case errors.Is(err, ErrMemoryLimit):
+		w.Header().Set("Retry-After", s.memory.RetryAfter())
+		s.doJSONWrite(w, r, http.StatusServiceUnavailable, Response{Message: err.Error(), StatusCode: StatusMemoryLimit})
+	case errors.Is(err, ErrInvalidKey):
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidKey, detail: &ErrorDetail{Field: "key"}})
+	case errors.Is(err, ErrInvalidDeleteAt):
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidTTL, detail: &ErrorDetail{Field: "delete_at"}})
+	case errors.Is(err, ErrKeyNotFound):
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: "key not found", StatusCode: StatusKeyNotFound})
+	case errors.Is(err, ErrKeyExists):
+		s.doJSONWrite(w, r, http.StatusConflict, Response{Message: "key already exists", StatusCode: StatusKeyExists})
+	case errors.Is(err, ErrInvalidDump), errors.Is(err, ErrRejected), isValueError(err):
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue})
+	case errors.As(err, &syntaxErr):
+		s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: err.Error(), StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "path"}})
+	case errors.Is(err, ErrMemberNotFound):
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: err.Error(), StatusCode: StatusMemberNotFound})
+	case errors.Is(err, ErrFieldNotFound):
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: err.Error(), StatusCode: StatusFieldNotFound})
+	case errors.Is(err, ErrVersionMismatch):
+		s.doJSONWrite(w, r, http.StatusConflict, Response{Message: err.Error(), StatusCode: StatusVersionMismatch})
+	case errors.Is(err, ErrVersionNotFound):
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: err.Error(), StatusCode: StatusVersionNotFound})
+	case errors.Is(err, jsonpath.ErrNotFound):
+		s.doJSONWrite(w, r, http.StatusNotFound, Response{Message: err.Error(), StatusCode: StatusPathNotFound})
+	default:
+		s.writeStorageError(w, r, err, msg)
+	}
+}
+
+// writeDecodeError reports a request body that failed to decode, a 413
+// for bodies cut off over their size limit.
+func (s *Service) writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		s.logger(r).Warn().Err(err).Msg("request body too large")
+		s.doJSONWrite(w, r, http.StatusRequestEntityTooLarge, Response{
+			Message:    fmt.Sprintf("request body too large, max %d bytes", tooLarge.Limit),
+			StatusCode: StatusValueTooLarge,
+			detail:     &ErrorDetail{Field: "body", Limit: tooLarge.Limit},
+		})
+		return
+	}
+	s.logger(r).Error().Err(err).Msg("failed to decode request body")
+	resp := Response{Message: "invalid request body: " + err.Error(), StatusCode: StatusInvalidJSON, detail: &ErrorDetail{Field: "body"}}
+	if errors.Is(err, io.EOF) {
+		resp.Message = "invalid request body: empty body"
+	}
+	var decodeErr *decodeError
+	if errors.As(err, &decodeErr) {
+		resp.detail = decodeErr.detail()
+	}
+	s.doJSONWrite(w, r, http.StatusBadRequest, resp)
+}
+
+// decodeBody decodes the JSON request body r into v, rejecting unknown
+// fields and data after the JSON value, so misspelled fields aren't
+// silently dropped. An empty body fails with io.EOF.
+func decodeBody(r io.Reader, v any) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		if errors.Is(err, io.EOF) {
+			return err
+		}
+		return newDecodeError(err)
+	}
+	offset := dec.InputOffset()
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return &decodeError{offset: offset, err: errors.New("unexpected data after the JSON value")}
+	}
+	return nil
+}
+
+// decodeError is a request body failing to decode, at offset bytes into
+// the body when known, -1 otherwise.
+type decodeError struct {
+	offset int64
+	// field is the JSON field failing to decode, when known.
+	field string
+	err   error
+}
+
+func newDecodeError(err error) error {
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &decodeError{offset: syntaxErr.Offset, err: err}
+	case errors.As(err, &typeErr):
+		return &decodeError{offset: typeErr.Offset, field: typeErr.Field, err: err}
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	// The decoder reports unknown fields with their name only.
+	field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+	return &decodeError{offset: -1, field: field, err: err}
+}
+
+func (e *decodeError) Error() string {
+	msg := strings.TrimPrefix(e.err.Error(), "json: ")
+	if e.offset < 0 {
+		return msg
+	}
+	return fmt.Sprintf("%s at offset %d", msg, e.offset)
+}
+
+func (e *decodeError) Unwrap() error {
+	return e.err
+}
+
+func (e *decodeError) detail() *ErrorDetail {
+	d := &ErrorDetail{Field: e.field}
+	if d.Field == "" {
+		d.Field = "body"
+	}
+	if e.offset >= 0 {
+		d.Offset = &e.offset
+	}
+	return d
+}
+
+// writeStorageError reports a failed repository call. Context cancellation and
+// deadline errors are not storage failures, so they map to 499 and 504
+// instead of 500.
+func (s *Service) writeStorageError(w http.ResponseWriter, r *http.Request, err error, msg string) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		s.logger(r).Warn().Err(err).Msg(msg)
+		s.doJSONWrite(w, r, StatusClientClosedRequest, Response{Message: "request canceled", StatusCode: StatusCanceled})
+	case errors.Is(err, context.DeadlineExceeded):
+		s.logger(r).Warn().Err(err).Msg(msg)
+		s.doJSONWrite(w, r, http.StatusGatewayTimeout, Response{Message: "request timed out", StatusCode: StatusTimeout})
+	default:
+		s.logger(r).Error().Err(err).Msg(msg)
+		s.doJSONWrite(w, r, http.StatusInternalServerError, Response{Message: msg, StatusCode: StatusStorageError})
+	}
+}
+
+func (s *Service) logger(r *http.Request) *zerolog.Logger {
+	return RequestLogger(r, &s.log)
+}
+
+// RequestLogger returns the logger stored in the context of the request by
+// the router, carrying the request ID, or fallback when there is none.
+func RequestLogger(r *http.Request, fallback *zerolog.Logger) *zerolog.Logger {
+	if l := zerolog.Ctx(r.Context()); l.GetLevel() != zerolog.Disabled {
+		return l
+	}
+	return fallback
+}
+
+// logKey adds the key of a request that isn't a route parameter to the
+// request logger.
+func logKey(r *http.Request, key string) {
+	zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("key", key)
+	})
+}
+
+// doJSONWrite writes obj as the JSON body of a response with code. The body
+// is encoded before the header is written, so an encoding failure answers
+// 500 rather than a truncated body with code.
+func (s *Service) doJSONWrite(w http.ResponseWriter, r *http.Request, code int, obj any) {
+	v2 := versionFromContext(r.Context()) >= 2
+	if resp, ok := obj.(Response); ok && v2 {
+		obj = resp.v2()
+	}
+	e := getEncoder()
+	defer putEncoder(e)
+	if err := e.enc.Encode(obj); err != nil {
+		s.logger(r).Error().Err(err).Msg("error encoding response")
+		code = http.StatusInternalServerError
+		var resp any = Response{Message: "failed to encode response", StatusCode: StatusStorageError}
+		if v2 {
+			resp = resp.(Response).v2()
+		}
+		e.buf.Reset()
+		_ = e.enc.Encode(resp)
+	}
+	w.Header()["Content-Type"] = jsonContentType
+	w.WriteHeader(code)
+	if _, err := w.Write(e.buf.Bytes()); err != nil {
+		s.logger(r).Error().Err(err).Msg("error writing response")
+	}
 }
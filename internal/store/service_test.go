@@ -3,11 +3,15 @@ package store_test
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/rs/zerolog"
@@ -15,6 +19,12 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
 
+	"codesignal/internal/auth"
+	"codesignal/internal/hotkeys"
+	"codesignal/internal/maintenance"
+	"codesignal/internal/memlimit"
+	"codesignal/internal/rdb"
+	"codesignal/internal/repository"
 	repomock "codesignal/internal/repository/mock"
 	"codesignal/internal/store"
 )
@@ -22,6 +32,8 @@ import (
 const (
 	testKey   = "test-key"
 	testValue = "test-value"
+	// ownerKey stores the owner of testKey.
+	ownerKey = "\xffacl:" + testKey
 )
 
 func setupTest(t *testing.T, opts store.Opts) (*store.Service, *repomock.MockStore) {
@@ -86,8 +98,8 @@ func TestServiceSet(t *testing.T) {
 					Get(gomock.Any(), testKey).
 					Return(nil, false, nil)
 				m.EXPECT().
-					Set(gomock.Any(), testKey, []byte(testValue)).
-					Return(assert.AnError)
+					SetIfVersion(gomock.Any(), testKey, []byte(testValue), time.Duration(0), uint64(0)).
+					Return(uint64(0), assert.AnError)
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: store.Response{
@@ -106,8 +118,113 @@ func TestServiceSet(t *testing.T) {
 					Get(gomock.Any(), testKey).
 					Return(nil, false, nil)
 				m.EXPECT().
-					Set(gomock.Any(), testKey, []byte(testValue)).
-					Return(nil)
+					SetIfVersion(gomock.Any(), testKey, []byte(testValue), time.Duration(0), uint64(0)).
+					Return(uint64(1), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody: store.Response{
+				Message:    "key created successfully",
+				StatusCode: store.StatusSuccess,
+			},
+		},
+		{
+			name: "invalid ttl",
+			input: store.KeyValue{
+				Key:   testKey,
+				Value: testValue,
+				TTL:   "soon",
+			},
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid ttl, expected a positive duration such as 30s",
+				StatusCode: store.StatusInvalidTTL,
+			},
+		},
+		{
+			name:           "empty key",
+			input:          store.KeyValue{Value: testValue},
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid key",
+				StatusCode: store.StatusInvalidKey,
+			},
+		},
+		{
+			name:  "empty key allowed",
+			input: store.KeyValue{Value: testValue},
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), "").
+					Return(nil, false, nil)
+				m.EXPECT().
+					SetIfVersion(gomock.Any(), "", []byte(testValue), time.Duration(0), uint64(0)).
+					Return(uint64(1), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody: store.Response{
+				Message:    "key created successfully",
+				StatusCode: store.StatusSuccess,
+			},
+			opts: store.Opts{AllowEmptyKeys: true},
+		},
+		{
+			name:           "key with whitespace",
+			input:          store.KeyValue{Key: "user 1\n", Value: testValue},
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid key: control characters and whitespace are not allowed",
+				StatusCode: store.StatusInvalidKey,
+			},
+		},
+		{
+			name:           "key not matching the pattern",
+			input:          store.KeyValue{Key: "User:1", Value: testValue},
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid key: must match ^(?:[a-z0-9:]+)$",
+				StatusCode: store.StatusInvalidKey,
+			},
+			opts: store.Opts{KeyPattern: mustCompileKeyPattern(t, "[a-z0-9:]+")},
+		},
+		{
+			name: "key matching the pattern",
+			input: store.KeyValue{
+				Key:   "user 1",
+				Value: testValue,
+			},
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), "user 1").
+					Return(nil, false, nil)
+				m.EXPECT().
+					SetIfVersion(gomock.Any(), "user 1", []byte(testValue), time.Duration(0), uint64(0)).
+					Return(uint64(1), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody: store.Response{
+				Message:    "key created successfully",
+				StatusCode: store.StatusSuccess,
+			},
+			opts: store.Opts{KeyPattern: mustCompileKeyPattern(t, "[a-z ]+[0-9]")},
+		},
+		{
+			name: "success with ttl",
+			input: store.KeyValue{
+				Key:   testKey,
+				Value: testValue,
+				TTL:   "1m30s",
+			},
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), testKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					SetIfVersion(gomock.Any(), testKey, []byte(testValue), 90*time.Second, uint64(0)).
+					Return(uint64(1), nil)
 			},
 			expectedStatus: http.StatusCreated,
 			expectedBody: store.Response{
@@ -198,6 +315,33 @@ func TestServiceSet(t *testing.T) {
 	}
 }
 
+func TestServiceSetInvalidBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		message string
+	}{
+		{name: "empty", body: "", message: "invalid request body: empty body"},
+		{name: "syntax error", body: `{"key" "a"}`, message: `invalid request body: invalid character '"' after object key at offset 8`},
+		{name: "wrong type", body: `{"key":1}`, message: "invalid request body: cannot unmarshal number into Go struct field KeyValue.key of type string at offset 8"},
+		{name: "unknown field", body: `{"key":"a","vlaue":"b"}`, message: `invalid request body: unknown field "vlaue"`},
+		{name: "trailing data", body: `{"key":"a","value":"b"} {}`, message: "invalid request body: unexpected data after the JSON value at offset 23"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, _ := setupTest(t, store.Opts{})
+			w := httptest.NewRecorder()
+			service.SetKey(w, httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(tt.body)))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			var response store.Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			assert.Equal(t, store.Response{Message: tt.message, StatusCode: store.StatusInvalidJSON}, response)
+		})
+	}
+}
+
 func TestServiceGet(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -221,8 +365,8 @@ func TestServiceGet(t *testing.T) {
 			key:  testKey,
 			setupMock: func(m *repomock.MockStore) {
 				m.EXPECT().
-					Get(gomock.Any(), testKey).
-					Return(nil, false, assert.AnError)
+					GetWithVersion(gomock.Any(), testKey).
+					Return(nil, uint64(0), false, assert.AnError)
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody: store.Response{
@@ -230,13 +374,41 @@ func TestServiceGet(t *testing.T) {
 				StatusCode: store.StatusStorageError,
 			},
 		},
+		{
+			name: "request canceled",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					GetWithVersion(gomock.Any(), testKey).
+					Return(nil, uint64(0), false, context.Canceled)
+			},
+			expectedStatus: store.StatusClientClosedRequest,
+			expectedBody: store.Response{
+				Message:    "request canceled",
+				StatusCode: store.StatusCanceled,
+			},
+		},
+		{
+			name: "deadline exceeded",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					GetWithVersion(gomock.Any(), testKey).
+					Return(nil, uint64(0), false, fmt.Errorf("scan: %w", context.DeadlineExceeded))
+			},
+			expectedStatus: http.StatusGatewayTimeout,
+			expectedBody: store.Response{
+				Message:    "request timed out",
+				StatusCode: store.StatusTimeout,
+			},
+		},
 		{
 			name: "key not found",
 			key:  "non-existent-key",
 			setupMock: func(m *repomock.MockStore) {
 				m.EXPECT().
-					Get(gomock.Any(), "non-existent-key").
-					Return(nil, false, nil)
+					GetWithVersion(gomock.Any(), "non-existent-key").
+					Return(nil, uint64(0), false, nil)
 			},
 			expectedStatus: http.StatusNotFound,
 			expectedBody: store.Response{
@@ -249,8 +421,8 @@ func TestServiceGet(t *testing.T) {
 			key:  testKey,
 			setupMock: func(m *repomock.MockStore) {
 				m.EXPECT().
-					Get(gomock.Any(), testKey).
-					Return([]byte(testValue), true, nil)
+					GetWithVersion(gomock.Any(), testKey).
+					Return([]byte(testValue), uint64(1), true, nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: store.Response{
@@ -286,6 +458,128 @@ func TestServiceGet(t *testing.T) {
 	}
 }
 
+func TestServiceGetRaw(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		raw         bool
+		contentType string
+	}{
+		{name: "format query", query: "?format=raw", raw: true, contentType: "text/plain; charset=utf-8"},
+		{name: "json format query", query: "?format=json", accept: "application/octet-stream", contentType: "application/json"},
+		{name: "accept octet-stream", accept: "application/octet-stream", raw: true, contentType: "text/plain; charset=utf-8"},
+		{name: "accept preferring json", accept: "application/octet-stream;q=0.5, application/json", contentType: "application/json"},
+		{name: "accept anything", accept: "*/*", contentType: "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			mockStore.EXPECT().GetWithVersion(gomock.Any(), testKey).Return([]byte(testValue), uint64(1), true, nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/key/"+testKey+tt.query, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			params := httprouter.Params{{Key: "key", Value: testKey}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+			w := httptest.NewRecorder()
+			service.GetKey(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			if tt.raw {
+				assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+				assert.Equal(t, testValue, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("invalid format", func(t *testing.T) {
+		service, _ := setupTest(t, store.Opts{})
+		req := httptest.NewRequest(http.MethodGet, "/v1/key/"+testKey+"?format=xml", nil)
+		params := httprouter.Params{{Key: "key", Value: testKey}}
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+		w := httptest.NewRecorder()
+		service.GetKey(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response store.Response
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, store.Response{Message: "format must be json or raw", StatusCode: store.StatusInvalidValue}, response)
+	})
+}
+
+func TestServiceGetSelect(t *testing.T) {
+	const doc = `{"user":{"name":"Alice","roles":["admin","dev"]},"avatar":"iVBORw0KGgo..."}`
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		value  string
+		code   store.StatusCode
+	}{
+		{name: "member", query: "?select=$.user.name", status: http.StatusOK, value: `"Alice"`},
+		{name: "object", query: "?select=$.user", status: http.StatusOK, value: `{"name":"Alice","roles":["admin","dev"]}`},
+		{name: "index", query: "?select=$.user.roles[-1]", status: http.StatusOK, value: `"dev"`},
+		{name: "missing", query: "?select=$.user.age", status: http.StatusNotFound, code: store.StatusPathNotFound},
+		{name: "invalid", query: "?select=user", status: http.StatusBadRequest, code: store.StatusInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			mockStore.EXPECT().GetWithVersion(gomock.Any(), testKey).Return([]byte(doc), uint64(1), true, nil).AnyTimes()
+
+			req := httptest.NewRequest(http.MethodGet, "/v1/key/"+testKey+tt.query, nil)
+			params := httprouter.Params{{Key: "key", Value: testKey}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+			w := httptest.NewRecorder()
+			service.GetKey(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			var response store.Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+			if tt.status != http.StatusOK {
+				assert.Equal(t, tt.code, response.StatusCode)
+				return
+			}
+			require.NotNil(t, response.Data)
+			assert.Equal(t, tt.value, response.Data.Value)
+		})
+	}
+
+	t.Run("raw", func(t *testing.T) {
+		service, mockStore := setupTest(t, store.Opts{})
+		mockStore.EXPECT().GetWithVersion(gomock.Any(), testKey).Return([]byte(doc), uint64(1), true, nil).Times(2)
+		get := func(query string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "/v1/key/"+testKey+query, nil)
+			params := httprouter.Params{{Key: "key", Value: testKey}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+			w := httptest.NewRecorder()
+			service.GetKey(w, req)
+			return w
+		}
+
+		w := get("?select=$.user.roles&format=raw")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.Equal(t, `["admin","dev"]`, w.Body.String())
+		// The ETag is that of the selected value.
+		assert.NotEqual(t, get("?format=raw").Header().Get("ETag"), w.Header().Get("ETag"))
+	})
+
+	t.Run("not a document", func(t *testing.T) {
+		service, mockStore := setupTest(t, store.Opts{})
+		mockStore.EXPECT().GetWithVersion(gomock.Any(), testKey).Return([]byte(testValue), uint64(1), true, nil)
+		req := httptest.NewRequest(http.MethodGet, "/v1/key/"+testKey+"?select=$.a", nil)
+		params := httprouter.Params{{Key: "key", Value: testKey}}
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+		w := httptest.NewRecorder()
+		service.GetKey(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
 func TestServiceDelete(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -330,7 +624,10 @@ func TestServiceDelete(t *testing.T) {
 					Get(gomock.Any(), testKey).
 					Return([]byte(testValue), true, nil)
 				m.EXPECT().
-					Delete(gomock.Any(), testKey).
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					DeleteIfVersion(gomock.Any(), testKey, uint64(0)).
 					Return(nil)
 			},
 			expectedStatus: http.StatusOK,
@@ -362,3 +659,956 @@ func TestServiceDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestServiceIncrement(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            string
+		body           string
+		setupMock      func(*repomock.MockStore)
+		expectedStatus int
+		expectedBody   store.Response
+	}{
+		{
+			name:           "empty key",
+			key:            "",
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid key",
+				StatusCode: store.StatusInvalidKey,
+			},
+		},
+		{
+			name:           "invalid body",
+			key:            testKey,
+			body:           "{",
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid request body: unexpected EOF",
+				StatusCode: store.StatusInvalidJSON,
+			},
+		},
+		{
+			name: "value is not an integer",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Increment(gomock.Any(), testKey, int64(1)).
+					Return(int64(0), repository.ErrNotInteger)
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    repository.ErrNotInteger.Error(),
+				StatusCode: store.StatusInvalidValue,
+			},
+		},
+		{
+			name: "storage error",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Increment(gomock.Any(), testKey, int64(1)).
+					Return(int64(0), assert.AnError)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: store.Response{
+				Message:    "failed to increment key",
+				StatusCode: store.StatusStorageError,
+			},
+		},
+		{
+			name: "default delta",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Increment(gomock.Any(), testKey, int64(1)).
+					Return(int64(1), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.Response{
+				Message:    "key incremented successfully",
+				StatusCode: store.StatusSuccess,
+				Data: &store.KeyValue{
+					Key:   testKey,
+					Value: "1",
+				},
+			},
+		},
+		{
+			name: "explicit delta",
+			key:  testKey,
+			body: `{"delta": -3}`,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Increment(gomock.Any(), testKey, int64(-3)).
+					Return(int64(7), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.Response{
+				Message:    "key incremented successfully",
+				StatusCode: store.StatusSuccess,
+				Data: &store.KeyValue{
+					Key:   testKey,
+					Value: "7",
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			tt.setupMock(mockStore)
+
+			req := httptest.NewRequest(http.MethodPost, "/key/"+tt.key+"/increment", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+			params := httprouter.Params{{Key: "key", Value: tt.key}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+
+			service.IncrementKey(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response store.Response
+			err := json.NewDecoder(w.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
+
+func TestServiceUndelete(t *testing.T) {
+	tests := []struct {
+		name           string
+		key            string
+		setupMock      func(*repomock.MockStore)
+		expectedStatus int
+		expectedBody   store.Response
+	}{
+		{
+			name:           "empty key",
+			key:            "",
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid key",
+				StatusCode: store.StatusInvalidKey,
+			},
+		},
+		{
+			name: "storage error",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Undelete(gomock.Any(), ownerKey).
+					Return(false, nil)
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Undelete(gomock.Any(), testKey).
+					Return(false, assert.AnError)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: store.Response{
+				Message:    "failed to undelete key",
+				StatusCode: store.StatusStorageError,
+			},
+		},
+		{
+			name: "no tombstone",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Undelete(gomock.Any(), ownerKey).
+					Return(false, nil)
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Undelete(gomock.Any(), testKey).
+					Return(false, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody: store.Response{
+				Message:    "deleted key not found",
+				StatusCode: store.StatusKeyNotFound,
+			},
+		},
+		{
+			name: "success",
+			key:  testKey,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Undelete(gomock.Any(), ownerKey).
+					Return(false, nil)
+				m.EXPECT().
+					Get(gomock.Any(), ownerKey).
+					Return(nil, false, nil)
+				m.EXPECT().
+					Undelete(gomock.Any(), testKey).
+					Return(true, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.Response{
+				Message:    "key restored successfully",
+				StatusCode: store.StatusSuccess,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			tt.setupMock(mockStore)
+
+			req := httptest.NewRequest(http.MethodPost, "/key/"+tt.key+"/undelete", nil)
+			w := httptest.NewRecorder()
+			params := httprouter.Params{{Key: "key", Value: tt.key}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+
+			service.UndeleteKey(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response store.Response
+			err := json.NewDecoder(w.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
+
+func TestServiceListKeys(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		setupMock      func(*repomock.MockStore)
+		expectedStatus int
+		expectedBody   store.KeysResponse
+	}{
+		{
+			name:           "invalid limit",
+			query:          "?limit=0",
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.KeysResponse{
+				Message:    "limit must be between 1 and 1000",
+				StatusCode: store.StatusInvalidValue,
+			},
+		},
+		{
+			name:  "scan error",
+			query: "",
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Scan(gomock.Any(), "", "", store.DefaultKeysLimit).
+					Return(nil, assert.AnError)
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: store.KeysResponse{
+				Message:    "failed to scan key",
+				StatusCode: store.StatusStorageError,
+			},
+		},
+		{
+			name:  "last page",
+			query: "?prefix=user:&after=user:1",
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Scan(gomock.Any(), "user:", "user:1", store.DefaultKeysLimit).
+					Return([]repository.Item{
+						{Key: "user:2", Value: []byte("bob")},
+						{Key: "user:3", Value: []byte("carol"), ExpiresAt: time.Now().Add(time.Hour)},
+					}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.KeysResponse{
+				Message:    "keys listed",
+				StatusCode: store.StatusSuccess,
+				Data: []store.KeyValue{
+					{Key: "user:2", Value: "bob"},
+					{Key: "user:3", Value: "carol", TTL: "1h0m0s"},
+				},
+			},
+		},
+		{
+			name:  "full page",
+			query: "?limit=1",
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().
+					Scan(gomock.Any(), "", "", 1).
+					Return([]repository.Item{{Key: "a", Value: []byte("1")}}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.KeysResponse{
+				Message:    "keys listed",
+				StatusCode: store.StatusSuccess,
+				Data:       []store.KeyValue{{Key: "a", Value: "1"}},
+				Next:       "a",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			tt.setupMock(mockStore)
+
+			req := httptest.NewRequest(http.MethodGet, "/keys"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			service.ListKeys(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response store.KeysResponse
+			err := json.NewDecoder(w.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
+
+func TestServiceHotKeys(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("disabled", func(t *testing.T) {
+		service, _ := setupTest(t, store.Opts{})
+
+		w := httptest.NewRecorder()
+		service.HotKeys(w, httptest.NewRequest(http.MethodGet, "/admin/hotkeys", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response store.HotKeysResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, store.HotKeysResponse{
+			Message:    "hot key tracking is disabled",
+			StatusCode: store.StatusSuccess,
+			Reads:      []hotkeys.KeyCount{},
+			Writes:     []hotkeys.KeyCount{},
+		}, response)
+	})
+
+	t.Run("invalid limit", func(t *testing.T) {
+		service, _ := setupTest(t, store.Opts{})
+
+		w := httptest.NewRecorder()
+		service.HotKeys(w, httptest.NewRequest(http.MethodGet, "/admin/hotkeys?limit=101", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("tracks reads and writes", func(t *testing.T) {
+		service, mockStore := setupTest(t, store.Opts{HotKeys: hotkeys.New(hotkeys.Config{SampleRate: 1})})
+		mockStore.EXPECT().Get(gomock.Any(), "a").Return([]byte("1"), true, nil).Times(2)
+		mockStore.EXPECT().Get(gomock.Any(), "b").Return(nil, false, nil)
+		mockStore.EXPECT().SetIfVersion(gomock.Any(), "b", []byte("2"), time.Duration(0), uint64(0)).Return(uint64(1), nil)
+
+		_, err := service.Get(ctx, "a")
+		require.NoError(t, err)
+		_, err = service.Get(ctx, "a")
+		require.NoError(t, err)
+		require.NoError(t, service.Create(ctx, "b", []byte("2"), 0))
+
+		w := httptest.NewRecorder()
+		service.HotKeys(w, httptest.NewRequest(http.MethodGet, "/admin/hotkeys?limit=5", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response store.HotKeysResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		assert.Equal(t, store.HotKeysResponse{
+			Message:    "hot keys listed",
+			StatusCode: store.StatusSuccess,
+			SampleRate: 1,
+			Reads:      []hotkeys.KeyCount{{Key: "a", Count: 2}},
+			Writes:     []hotkeys.KeyCount{{Key: "b", Count: 1}},
+		}, response)
+	})
+}
+
+func TestServiceDump(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupMock      func(*repomock.MockStore)
+		expectedStatus int
+		expectedBody   store.Response
+	}{
+		{
+			name: "key not found",
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().Get(gomock.Any(), testKey).Return(nil, false, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody:   store.Response{Message: "key not found", StatusCode: store.StatusKeyNotFound},
+		},
+		{
+			name: "success",
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().Get(gomock.Any(), testKey).Return([]byte(testValue), true, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: store.Response{
+				Message:    "key dumped",
+				StatusCode: store.StatusSuccess,
+				Data:       &store.KeyValue{Key: testKey, Value: base64.StdEncoding.EncodeToString(rdb.EncodeDump([]byte(testValue)))},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			tt.setupMock(mockStore)
+
+			req := httptest.NewRequest(http.MethodGet, "/key/"+testKey+"/dump", nil)
+			w := httptest.NewRecorder()
+			params := httprouter.Params{{Key: "key", Value: testKey}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+
+			service.DumpKey(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response store.Response
+			err := json.NewDecoder(w.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
+
+func TestServiceRestore(t *testing.T) {
+	payload := base64.StdEncoding.EncodeToString(rdb.EncodeDump([]byte(testValue)))
+
+	tests := []struct {
+		name           string
+		body           string
+		setupMock      func(*repomock.MockStore)
+		expectedStatus int
+		expectedBody   store.Response
+	}{
+		{
+			name:           "invalid json",
+			body:           "{",
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   store.Response{Message: "invalid request body: unexpected EOF", StatusCode: store.StatusInvalidJSON},
+		},
+		{
+			name:           "invalid base64",
+			body:           `{"payload": "%%%"}`,
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   store.Response{Message: "invalid payload, expected base64", StatusCode: store.StatusInvalidValue},
+		},
+		{
+			name:           "corrupted payload",
+			body:           `{"payload": "` + base64.StdEncoding.EncodeToString([]byte("garbage payload")) + `"}`,
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: store.Response{
+				Message:    "invalid dump payload: DUMP payload version or checksum are wrong",
+				StatusCode: store.StatusInvalidValue,
+			},
+		},
+		{
+			name:           "invalid ttl",
+			body:           `{"payload": "` + payload + `", "ttl": "soon"}`,
+			setupMock:      func(m *repomock.MockStore) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   store.Response{Message: "invalid ttl, expected a positive duration such as 30s", StatusCode: store.StatusInvalidTTL},
+		},
+		{
+			name: "key exists",
+			body: `{"payload": "` + payload + `"}`,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().Get(gomock.Any(), testKey).Return([]byte("old"), true, nil)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody:   store.Response{Message: "key already exists", StatusCode: store.StatusKeyExists},
+		},
+		{
+			name: "replace with ttl",
+			body: `{"payload": "` + payload + `", "ttl": "1m", "replace": true}`,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().Get(gomock.Any(), ownerKey).Return(nil, false, nil)
+				m.EXPECT().SetIfVersion(gomock.Any(), testKey, []byte(testValue), time.Minute, uint64(0)).Return(uint64(1), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   store.Response{Message: "key restored successfully", StatusCode: store.StatusSuccess},
+		},
+		{
+			name: "success",
+			body: `{"payload": "` + payload + `"}`,
+			setupMock: func(m *repomock.MockStore) {
+				m.EXPECT().Get(gomock.Any(), testKey).Return(nil, false, nil)
+				m.EXPECT().SetIfVersion(gomock.Any(), testKey, []byte(testValue), time.Duration(0), uint64(0)).Return(uint64(1), nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedBody:   store.Response{Message: "key restored successfully", StatusCode: store.StatusSuccess},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mockStore := setupTest(t, store.Opts{})
+			tt.setupMock(mockStore)
+
+			req := httptest.NewRequest(http.MethodPost, "/key/"+testKey+"/restore", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+			params := httprouter.Params{{Key: "key", Value: testKey}}
+			req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, params))
+
+			service.RestoreKey(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+
+			var response store.Response
+			err := json.NewDecoder(w.Body).Decode(&response)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedBody, response)
+		})
+	}
+}
+
+func TestServiceOwner(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{TombstoneRetention: time.Hour})
+	require.NoError(t, err)
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{})
+
+	principal := func(subject string, scopes ...string) context.Context {
+		return auth.NewContext(context.Background(), &auth.Principal{Subject: subject, Scopes: scopes})
+	}
+	alice, bob, admin := principal("alice", auth.ScopeWrite), principal("bob", auth.ScopeWrite), principal("ops", auth.ScopeAdmin)
+
+	err = service.CreateOwned(bob, testKey, []byte(testValue), 0, "alice")
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+	require.NoError(t, service.CreateOwned(alice, testKey, []byte(testValue), time.Hour, "alice"))
+
+	err = service.Set(bob, testKey, []byte("other"), 0)
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+	assert.EqualError(t, err, `forbidden: key "test-key" is owned by alice`)
+	assert.ErrorIs(t, service.Delete(bob, testKey), auth.ErrForbidden)
+	_, err = service.Increment(bob, testKey, 1)
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+
+	// Owners are kept, with the ttl of the key, when admins replace it.
+	require.NoError(t, service.Set(admin, testKey, []byte("1"), 0))
+	owner, err := service.Owner(context.Background(), testKey)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", owner)
+	_, err = service.Increment(alice, testKey, 1)
+	require.NoError(t, err)
+
+	items, err := service.Scan(context.Background(), "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, testKey, items[0].Key)
+	_, err = service.Get(context.Background(), ownerKey)
+	assert.ErrorIs(t, err, store.ErrInvalidKey)
+
+	// Deleted keys are restored with their owner, by their owner only.
+	require.NoError(t, service.Delete(alice, testKey))
+	_, err = service.Undelete(bob, testKey)
+	assert.ErrorIs(t, err, auth.ErrForbidden)
+	owner, err = service.Owner(context.Background(), testKey)
+	require.NoError(t, err)
+	assert.Empty(t, owner)
+
+	restored, err := service.Undelete(alice, testKey)
+	require.NoError(t, err)
+	assert.True(t, restored)
+	owner, err = service.Owner(context.Background(), testKey)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", owner)
+}
+
+func TestServiceMaintenance(t *testing.T) {
+	ctx := context.Background()
+	service, mockStore := setupTest(t, store.Opts{Maintenance: maintenance.New(false)})
+	mockStore.EXPECT().Get(gomock.Any(), testKey).Return([]byte(testValue), true, nil)
+
+	setMaintenance := func(body string) (int, store.MaintenanceResponse) {
+		w := httptest.NewRecorder()
+		service.SetMaintenance(w, httptest.NewRequest(http.MethodPut, "/admin/maintenance", bytes.NewBufferString(body)))
+		var response store.MaintenanceResponse
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return w.Code, response
+	}
+
+	code, response := setMaintenance(`{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, store.StatusInvalidJSON, response.StatusCode)
+
+	code, response = setMaintenance(`{"enabled":true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, response.Enabled)
+	require.NotNil(t, response.Since)
+
+	// Writes fail before reaching the repository, reads are served.
+	assert.ErrorIs(t, service.Set(ctx, testKey, []byte(testValue), 0), store.ErrReadOnly)
+	assert.ErrorIs(t, service.Delete(ctx, testKey), store.ErrReadOnly)
+	_, err := service.Increment(ctx, testKey, 1)
+	assert.ErrorIs(t, err, store.ErrReadOnly)
+	value, err := service.Get(ctx, testKey)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(testValue), value)
+
+	w := httptest.NewRecorder()
+	service.SetKey(w, httptest.NewRequest(http.MethodPost, "/key", bytes.NewBufferString(`{"key":"test-key","value":"test-value"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.JSONEq(t, `{"message":"store is in maintenance mode, writes are disabled","status_code":1021}`, w.Body.String())
+
+	code, response = setMaintenance(`{"enabled":false}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, store.MaintenanceResponse{Message: "maintenance mode disabled", StatusCode: store.StatusSuccess}, response)
+
+	mockStore.EXPECT().Get(gomock.Any(), ownerKey).Return(nil, false, nil)
+	mockStore.EXPECT().SetIfVersion(gomock.Any(), testKey, []byte(testValue), time.Duration(0), uint64(0)).Return(uint64(1), nil)
+	assert.NoError(t, service.Set(ctx, testKey, []byte(testValue), 0))
+}
+
+func TestServicePinnedMaintenance(t *testing.T) {
+	readOnly := maintenance.New(false)
+	readOnly.Pin()
+	service, _ := setupTest(t, store.Opts{Maintenance: readOnly})
+
+	w := httptest.NewRecorder()
+	service.SetMaintenance(w, httptest.NewRequest(http.MethodPut, "/admin/maintenance", bytes.NewBufferString(`{"enabled":false}`)))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"message":"maintenance mode is pinned, the store is a read-only replica","status_code":1021}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	service.Maintenance(w, httptest.NewRequest(http.MethodGet, "/admin/maintenance", nil))
+	var response store.MaintenanceResponse
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.True(t, response.Enabled)
+	assert.True(t, response.Pinned)
+	assert.ErrorIs(t, service.Set(context.Background(), testKey, []byte(testValue), 0), store.ErrReadOnly)
+}
+
+func TestServiceMemoryLimit(t *testing.T) {
+	ctx := context.Background()
+	memory := memlimit.New(zerolog.Nop(), memlimit.Config{SoftLimit: 1, CheckInterval: time.Hour, RetryAfter: 5 * time.Second})
+	done := make(chan error)
+	go func() { done <- memory.Serve() }()
+	require.Eventually(t, memory.Exceeded, time.Second, time.Millisecond)
+	require.NoError(t, memory.Shutdown(ctx))
+	require.NoError(t, <-done)
+	service, mockStore := setupTest(t, store.Opts{Memory: memory})
+
+	// Writes fail before reaching the repository, reads and deletes are
+	// served.
+	assert.ErrorIs(t, service.Set(ctx, testKey, []byte(testValue), 0), store.ErrMemoryLimit)
+	_, err := service.Increment(ctx, testKey, 1)
+	assert.ErrorIs(t, err, store.ErrMemoryLimit)
+	mockStore.EXPECT().Get(gomock.Any(), ownerKey).Return(nil, false, nil)
+	_, err = service.AddMembers(ctx, testKey, []string{"a"})
+	assert.ErrorIs(t, err, store.ErrMemoryLimit)
+
+	mockStore.EXPECT().Get(gomock.Any(), testKey).Return([]byte(testValue), true, nil).Times(2)
+	value, err := service.Get(ctx, testKey)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(testValue), value)
+	mockStore.EXPECT().Get(gomock.Any(), ownerKey).Return(nil, false, nil)
+	mockStore.EXPECT().DeleteIfVersion(gomock.Any(), testKey, uint64(0)).Return(nil)
+	require.NoError(t, service.Delete(ctx, testKey))
+
+	w := httptest.NewRecorder()
+	service.SetKey(w, httptest.NewRequest(http.MethodPost, "/key", bytes.NewBufferString(`{"key":"test-key","value":"test-value"}`)))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "5", w.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"message":"soft memory limit exceeded, writes are rejected","status_code":1035}`, w.Body.String())
+}
+
+// recordingHook upper-cases values, rejects the keys starting with
+// "secret" and records the operations it observes.
+type recordingHook struct {
+	store.NopHook
+	calls []string
+}
+
+func (h *recordingHook) BeforeSet(_ context.Context, key string, value []byte) ([]byte, error) {
+	if strings.HasPrefix(key, "secret") {
+		return nil, fmt.Errorf("key %s is read-only", key)
+	}
+	return bytes.ToUpper(value), nil
+}
+
+func (h *recordingHook) AfterSet(_ context.Context, key string, value []byte) {
+	h.calls = append(h.calls, "set "+key+"="+string(value))
+}
+
+func (h *recordingHook) BeforeGet(_ context.Context, key string) error {
+	if strings.HasPrefix(key, "secret") {
+		return fmt.Errorf("key %s is hidden", key)
+	}
+	return nil
+}
+
+func (h *recordingHook) AfterDelete(_ context.Context, key string) {
+	h.calls = append(h.calls, "delete "+key)
+}
+
+func TestServiceHooks(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	hook := &recordingHook{}
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{Hooks: []store.Hook{hook}})
+
+	require.NoError(t, service.Create(ctx, "a", []byte("abc"), 0))
+	value, err := service.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("ABC"), value, "hooks transform values")
+	require.NoError(t, service.Restore(ctx, "a", rdb.EncodeDump([]byte("d")), 0, true))
+	require.NoError(t, service.Delete(ctx, "a"))
+	assert.Equal(t, []string{"set a=ABC", "set a=D", "delete a"}, hook.calls)
+
+	err = service.Set(ctx, "secret", []byte("x"), 0)
+	assert.ErrorIs(t, err, store.ErrRejected)
+	assert.EqualError(t, err, "rejected: key secret is read-only")
+	_, err = service.Get(ctx, "secret")
+	assert.ErrorIs(t, err, store.ErrRejected)
+
+	w := httptest.NewRecorder()
+	service.SetKey(w, httptest.NewRequest(http.MethodPost, "/key", bytes.NewBufferString(`{"key":"secret","value":"x"}`)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"rejected: key secret is read-only","status_code":1004}`, w.Body.String())
+	_, exists, err := repo.Get(ctx, "secret")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestServiceHooksOnUpdates(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{TombstoneRetention: time.Hour})
+	require.NoError(t, err)
+	hook := &recordingHook{}
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{Hooks: []store.Hook{hook}})
+
+	_, err = service.SetField(ctx, "h", "f", "v")
+	require.NoError(t, err)
+	fields, err := service.Fields(ctx, "h")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"F": "V"}, fields, "hooks transform updated values")
+	require.NoError(t, service.SetPath(ctx, "d", "$", json.RawMessage(`{"a":"b"}`)))
+	_, err = service.AddMembers(ctx, "s", []string{"m"})
+	require.NoError(t, err)
+	_, err = service.Increment(ctx, "n", 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`set h={"F":"V"}`, `set d={"A":"B"}`, `set s=["M"]`, "set n=3"}, hook.calls)
+
+	_, err = service.SetField(ctx, "secret", "f", "v")
+	assert.ErrorIs(t, err, store.ErrRejected)
+	_, err = service.AddScores(ctx, "secret", []repository.ScoredMember{{Member: "m", Score: 1}})
+	assert.ErrorIs(t, err, store.ErrRejected)
+	_, err = service.AddCounter(ctx, "secret", 2, repository.OverflowError)
+	assert.ErrorIs(t, err, store.ErrRejected)
+	_, exists, err := repo.Get(ctx, "secret")
+	require.NoError(t, err)
+	assert.False(t, exists, "rejected updates aren't stored")
+
+	require.NoError(t, repo.Set(ctx, "secret", []byte("x")))
+	require.NoError(t, repo.Delete(ctx, "secret"))
+	restored, err := service.Undelete(ctx, "secret")
+	assert.ErrorIs(t, err, store.ErrRejected)
+	assert.False(t, restored)
+	_, exists, err = repo.Get(ctx, "secret")
+	require.NoError(t, err)
+	assert.False(t, exists, "rejected restores are deleted again")
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	none, err := store.ParseKeyNormalizer(nil)
+	require.NoError(t, err)
+	both, err := store.ParseKeyNormalizer([]string{"lowercase", " NFC"})
+	require.NoError(t, err)
+	nfc, err := store.ParseKeyNormalizer([]string{store.NormalizeNFC})
+	require.NoError(t, err)
+	_, err = store.ParseKeyNormalizer([]string{"nfkc"})
+	assert.EqualError(t, err, `unknown key normalization "nfkc", expected lowercase or nfc`)
+
+	for _, tt := range []struct {
+		n         store.KeyNormalizer
+		key, want string
+	}{
+		{none, "User:Café", "User:Café"},
+		{both, "User:1", "user:1"},
+		{both, "CAFE\u0301", "caf\u00e9"},
+		{nfc, "Cafe\u0301", "Caf\u00e9"},
+		{both, "user:1", "user:1"},
+		// Binary keys are kept as they are.
+		{both, "A\xff", "A\xff"},
+	} {
+		assert.Equal(t, tt.want, tt.n.Normalize(tt.key), tt.key)
+	}
+}
+
+func TestServiceKeyNormalization(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	normalizer, err := store.ParseKeyNormalizer([]string{store.NormalizeLowercase, store.NormalizeNFC})
+	require.NoError(t, err)
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{KeyNormalizer: normalizer})
+
+	require.NoError(t, service.Create(ctx, "User:Cafe\u0301", []byte("1"), 0))
+	assert.ErrorIs(t, service.Create(ctx, "user:caf\u00e9", []byte("2"), 0), store.ErrKeyExists)
+	value, err := service.Get(ctx, "USER:CAF\u00c9")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	_, err = service.Increment(ctx, "Counter", 2)
+	require.NoError(t, err)
+	_, err = service.AddMembers(ctx, "Set:A", []string{"x"})
+	require.NoError(t, err)
+	members, err := service.Combine(ctx, repository.Union, []string{"SET:A", "set:a"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x"}, members)
+
+	items, err := service.Scan(ctx, "USER:", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 1)
+	assert.Equal(t, "user:caf\u00e9", items[0].Key)
+	_, exists, err := repo.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Responses hold the keys as stored.
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/key/User:Caf%C3%A9", nil)
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "key", Value: "User:Café"}}))
+	service.GetKey(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message":"key found","status_code":1000,"data":{"key":"user:café","value":"1"}}`, w.Body.String())
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodPost, "/key/Counter/increment", nil)
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "key", Value: "Counter"}}))
+	service.IncrementKey(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message":"key incremented successfully","status_code":1000,"data":{"key":"counter","value":"3"}}`, w.Body.String())
+
+	require.NoError(t, service.Delete(ctx, "USER:CAFÉ"))
+	_, err = service.Get(ctx, "user:café")
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+}
+
+func TestServiceExpire(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	readOnly := maintenance.New(false)
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{Maintenance: readOnly})
+
+	require.NoError(t, service.Set(ctx, "session:a", []byte("1"), 0))
+	require.NoError(t, service.Set(ctx, "session:b", []byte("2"), time.Minute))
+	require.NoError(t, service.CreateOwned(ctx, "session:c", []byte("3"), 0, "alice"))
+	require.NoError(t, service.Set(ctx, "user:a", []byte("4"), 0))
+
+	expire := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		service.ExpireKeys(w, httptest.NewRequest(http.MethodPost, "/admin/expire?"+query, nil))
+		return w
+	}
+	w := expire("prefix=session:&ttl=1h")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message":"expiry of keys updated","status_code":1000,"keys":3}`, w.Body.String())
+	for _, key := range []string{"session:a", "session:b", "session:c", "\xffacl:session:c"} {
+		expiresAt, exists, err := repo.Expiry(ctx, key)
+		require.NoError(t, err)
+		require.True(t, exists, key)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute, key)
+	}
+	value, err := service.Get(ctx, "session:b")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+	expiresAt, err := service.Expiry(ctx, "user:a")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.IsZero())
+
+	// Only the keys expiring are updated when removing the expiry.
+	require.NoError(t, service.Set(ctx, "session:d", []byte("5"), 0))
+	w = expire("prefix=session:&ttl=0")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"message":"expiry of keys removed","status_code":1000,"keys":3}`, w.Body.String())
+	expiresAt, err = service.Expiry(ctx, "session:c")
+	require.NoError(t, err)
+	assert.True(t, expiresAt.IsZero())
+	owner, err := service.Owner(ctx, "session:c")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", owner)
+
+	w = expire("ttl=1h")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"prefix is required, empty for every key","status_code":1003}`, w.Body.String())
+	w = expire("prefix=session:")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"ttl is required, 0 to remove the expiry","status_code":1011}`, w.Body.String())
+	w = expire("prefix=session:&ttl=-1h")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	readOnly.Set(true)
+	w = expire("prefix=&ttl=1h")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	_, err = service.Expire(ctx, "", time.Hour)
+	assert.ErrorIs(t, err, store.ErrReadOnly)
+}
+
+func TestServiceDeleteAt(t *testing.T) {
+	ctx := context.Background()
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	service := store.NewService(zerolog.Nop(), repo, store.Opts{ScheduleDeletes: true})
+
+	create := func(service *store.Service, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		service.SetKey(w, httptest.NewRequest(http.MethodPost, "/key", strings.NewReader(body)))
+		return w
+	}
+	deleteAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := create(service, fmt.Sprintf(`{"key":"embargo","value":"1","delete_at":%q}`, deleteAt.Format(time.RFC3339)))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	value, exists, err := repo.Get(ctx, store.DeleteAtKey("embargo"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	stored, err := store.ParseDeleteAt(value)
+	require.NoError(t, err)
+	assert.True(t, deleteAt.Equal(stored))
+	// The deletion time is hidden from scans.
+	items, err := service.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+
+	w = create(service, `{"key":"past","value":"1","delete_at":"2020-01-01T00:00:00Z"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"invalid delete_at: 2020-01-01T00:00:00Z isn't in the future","status_code":1011}`, w.Body.String())
+	w = create(service, fmt.Sprintf(`{"key":"both","value":"1","ttl":"1h","delete_at":%q}`, deleteAt.Format(time.RFC3339)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"invalid delete_at: a key can't have both a ttl and a delete_at","status_code":1011}`, w.Body.String())
+	w = create(store.NewService(zerolog.Nop(), repo, store.Opts{}), fmt.Sprintf(`{"key":"unsupported","value":"1","delete_at":%q}`, deleteAt.Format(time.RFC3339)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.JSONEq(t, `{"message":"invalid delete_at: scheduled deletion isn't supported by this store","status_code":1011}`, w.Body.String())
+	_, err = service.Get(ctx, "past")
+	assert.ErrorIs(t, err, store.ErrKeyNotFound)
+}
+
+func mustCompileKeyPattern(t *testing.T, pattern string) *regexp.Regexp {
+	t.Helper()
+	re, err := store.CompileKeyPattern(pattern)
+	require.NoError(t, err)
+	return re
+}
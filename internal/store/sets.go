@@ -0,0 +1,271 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+
+	"github.com/julienschmidt/httprouter"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/jsonpath"
+	"codesignal/internal/repository"
+)
+
+// Sets are values holding a JSON array of distinct strings, whose members
+// are added and removed atomically by the repository, see
+// repository.AddMembers.
+
+// ErrMemberNotFound is returned by IsMember for a member a set doesn't
+// hold.
+var ErrMemberNotFound = errors.New("member not found")
+
+// MembersRequest represents the payload adding or removing members of a
+// set.
+type MembersRequest struct {
+	Members []string `json:"members"`
+}
+
+// MembersResponse represents the members of a set, or of a combination of
+// sets, returned by the API, sorted.
+type MembersResponse struct {
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Data       []string   `json:"data"`
+}
+
+// AddMembers adds members to the set stored at key, creating it if missing,
+// and returns those it didn't hold.
+func (s *Service) AddMembers(ctx context.Context, key string, members []string) ([]string, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return nil, err
+	}
+	if err := s.checkMemory(); err != nil {
+		return nil, err
+	}
+
+	s.hotKeys.Write(key)
+	var added []string
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, added, err = repository.ApplyAddMembers(value, exists, members, s.getMaxValueSize())
+		return value, err
+	}, func() error {
+		var err error
+		added, err = s.store.AddMembers(ctx, key, members, s.getMaxValueSize())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// RemoveMembers removes members from the set stored at key and returns
+// those it held.
+func (s *Service) RemoveMembers(ctx context.Context, key string, members []string) ([]string, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return nil, err
+	}
+
+	s.hotKeys.Write(key)
+	var removed []string
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, removed, err = repository.ApplyRemoveMembers(value, exists, members)
+		return value, err
+	}, func() error {
+		var err error
+		removed, err = s.store.RemoveMembers(ctx, key, members)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
+
+// checkUpdate checks that the principal of ctx may update the set, hash,
+// counter, document or sorted set stored at key.
+func (s *Service) checkUpdate(ctx context.Context, key string) error {
+	if err := auth.Authorize(ctx, auth.Write, key); err != nil {
+		return err
+	}
+	if s.maintenance.Enabled() {
+		return ErrReadOnly
+	}
+	if key == "" || ReservedKey(key) {
+		return ErrInvalidKey
+	}
+	if len(key) > s.getMaxKeyLength() {
+		return &LimitError{Field: "key", Limit: s.getMaxKeyLength(), Actual: len(key)}
+	}
+	if err := s.checkKey(key); err != nil {
+		return err
+	}
+	_, err := s.checkOwner(ctx, key)
+	return err
+}
+
+// Members returns the members of the set stored at key, or ErrKeyNotFound.
+func (s *Service) Members(ctx context.Context, key string) ([]string, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return repository.DecodeSet(value)
+}
+
+// IsMember returns nil if the set stored at key holds member,
+// ErrMemberNotFound if it doesn't, or ErrKeyNotFound.
+func (s *Service) IsMember(ctx context.Context, key, member string) error {
+	members, err := s.Members(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m == member {
+			return nil
+		}
+	}
+	return ErrMemberNotFound
+}
+
+// Combine returns the union or intersection of the sets stored at keys,
+// read at once. Missing keys are empty sets.
+func (s *Service) Combine(ctx context.Context, op repository.SetOp, keys []string) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, ErrInvalidKey
+	}
+	keys = slices.Clone(keys)
+	for i, key := range keys {
+		key = s.NormalizeKey(key)
+		keys[i] = key
+		if err := auth.Authorize(ctx, auth.Read, key); err != nil {
+			return nil, err
+		}
+		if key == "" || ReservedKey(key) {
+			return nil, ErrInvalidKey
+		}
+		s.hotKeys.Read(key)
+	}
+
+	members, err := s.store.Combine(ctx, op, keys)
+	if err != nil {
+		return nil, setError("read", err)
+	}
+	return members, nil
+}
+
+// valueErrors are the repository errors of integer, set, hash, counter,
+// document and sorted set operations rejecting the value or the update, answered with a
+// 400.
+var valueErrors = []error{
+	repository.ErrNotInteger,
+	repository.ErrNotSet,
+	repository.ErrNotHash,
+	repository.ErrNotCounter,
+	repository.ErrOverflow,
+	repository.ErrNotDocument,
+	repository.ErrNotSortedSet,
+	repository.ErrInvalidScore,
+}
+
+// isValueError reports whether err is one of valueErrors.
+func isValueError(err error) bool {
+	for _, target := range valueErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// setError wraps the repository errors of set, hash, counter, document and
+// sorted set operations in a StorageError, except valueErrors and missing
+// paths.
+func setError(op string, err error) error {
+	if isValueError(err) || errors.Is(err, jsonpath.ErrNotFound) {
+		return err
+	}
+	return &StorageError{Op: op, Err: err}
+}
+
+// MembersKey lists the members of the set of a key.
+func (s *Service) MembersKey(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	members, err := s.Members(r.Context(), key)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get members")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, MembersResponse{Message: "members listed", StatusCode: StatusSuccess, Data: members})
+}
+
+// IsMemberKey reports with 200 or 404 whether the set of a key holds a
+// member.
+func (s *Service) IsMemberKey(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	if err := s.IsMember(r.Context(), params.ByName("key"), params.ByName("member")); err != nil {
+		s.writeError(w, r, err, "failed to get members")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, Response{Message: "member found", StatusCode: StatusSuccess})
+}
+
+// AddMembersKey adds members to the set of a key, answering with the
+// members it didn't hold.
+func (s *Service) AddMembersKey(w http.ResponseWriter, r *http.Request) {
+	s.updateMembers(w, r, s.AddMembers, "members added")
+}
+
+// RemoveMembersKey removes members from the set of a key, answering with
+// the members it held.
+func (s *Service) RemoveMembersKey(w http.ResponseWriter, r *http.Request) {
+	s.updateMembers(w, r, s.RemoveMembers, "members removed")
+}
+
+func (s *Service) updateMembers(w http.ResponseWriter, r *http.Request, update func(context.Context, string, []string) ([]string, error), msg string) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	var req MembersRequest
+	if err := decodeBody(r.Body, &req); err != nil {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	changed, err := update(r.Context(), key, req.Members)
+	if err != nil {
+		s.writeError(w, r, err, "failed to update members")
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	s.doJSONWrite(w, r, http.StatusOK, MembersResponse{Message: msg, StatusCode: StatusSuccess, Data: changed})
+}
+
+// UnionKeys returns the union of the sets of the key query parameters.
+func (s *Service) UnionKeys(w http.ResponseWriter, r *http.Request) {
+	s.combineKeys(w, r, repository.Union)
+}
+
+// IntersectKeys returns the intersection of the sets of the key query
+// parameters.
+func (s *Service) IntersectKeys(w http.ResponseWriter, r *http.Request) {
+	s.combineKeys(w, r, repository.Intersection)
+}
+
+func (s *Service) combineKeys(w http.ResponseWriter, r *http.Request, op repository.SetOp) {
+	members, err := s.Combine(r.Context(), op, r.URL.Query()["key"])
+	if err != nil {
+		s.writeError(w, r, err, "failed to combine sets")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, MembersResponse{Message: "sets combined", StatusCode: StatusSuccess, Data: members})
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/repository"
+)
+
+// Responses carry the version of the key in the X-Key-Version header, and
+// writes with a version query parameter only apply while the key is at that
+// version, failing with ErrVersionMismatch otherwise, so clients update keys
+// they read without overwriting the writes made since.
+
+// VersionHeader is the header holding the version of a key.
+const VersionHeader = "X-Key-Version"
+
+// ErrVersionMismatch is returned by the conditional writes of a key that
+// isn't at the expected version, including missing keys.
+var ErrVersionMismatch = errors.New("key is not at the expected version")
+
+// GetWithVersion is Get, also returning the version of key.
+func (s *Service) GetWithVersion(ctx context.Context, key string) ([]byte, uint64, error) {
+	key = s.NormalizeKey(key)
+	if err := auth.Authorize(ctx, auth.Read, key); err != nil {
+		return nil, 0, err
+	}
+	if key == "" || ReservedKey(key) {
+		return nil, 0, ErrInvalidKey
+	}
+
+	if err := s.beforeGet(ctx, key); err != nil {
+		return nil, 0, err
+	}
+
+	s.hotKeys.Read(key)
+	value, version, exists, err := s.store.GetWithVersion(ctx, key)
+	if err != nil {
+		return nil, 0, &StorageError{Op: "get", Err: err}
+	}
+	if !exists {
+		return nil, 0, ErrKeyNotFound
+	}
+	return value, version, nil
+}
+
+// versionError returns ErrVersionMismatch for the mismatches reported by
+// the repository, and the StorageError of op for other failures.
+func versionError(op string, err error) error {
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		return ErrVersionMismatch
+	}
+	return &StorageError{Op: op, Err: err}
+}
+
+// parseExpectedVersion parses the version query parameter of the writes of
+// r, zero when absent.
+func parseExpectedVersion(r *http.Request) (uint64, error) {
+	v := r.URL.Query().Get("version")
+	if v == "" {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || version == 0 {
+		return 0, errInvalidVersion
+	}
+	return version, nil
+}
+
+// setVersionHeader sets the version header of w, unless the repository
+// doesn't version keys.
+func setVersionHeader(w http.ResponseWriter, version uint64) {
+	if version != 0 {
+		w.Header()[VersionHeader] = []string{strconv.FormatUint(version, 10)}
+	}
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func TestKeyVersions(t *testing.T) {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{})
+	require.NoError(t, err)
+	s := NewService(zerolog.Nop(), repo, Opts{})
+
+	serve := func(handler http.HandlerFunc, method, target, body string) (*httptest.ResponseRecorder, Response) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "key", Value: "key"}}))
+		w := httptest.NewRecorder()
+		handler(w, r)
+		var resp Response
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w, resp
+	}
+
+	w, _ := serve(s.SetKey, http.MethodPost, "/v1/key", `{"key":"key","value":"1"}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	created := w.Header().Get(VersionHeader)
+	require.NotEmpty(t, created)
+
+	// Reads return the version of the key, cached responses included.
+	for range 3 {
+		w, _ = serve(s.GetKey, http.MethodGet, "/v1/key/key", "")
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, created, w.Header().Get(VersionHeader))
+	}
+
+	w, _ = serve(s.PutValue, http.MethodPut, "/v1/key/key/value?version="+created, "2")
+	require.Equal(t, http.StatusOK, w.Code)
+	updated := w.Header().Get(VersionHeader)
+	assert.NotEqual(t, created, updated)
+	w, _ = serve(s.GetKey, http.MethodGet, "/v1/key/key", "")
+	assert.Equal(t, updated, w.Header().Get(VersionHeader))
+
+	// Writes at a stale version are rejected.
+	w, resp := serve(s.PutValue, http.MethodPut, "/v1/key/key/value?version="+created, "3")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, StatusVersionMismatch, resp.StatusCode)
+	w, resp = serve(s.DeleteKey, http.MethodDelete, "/v1/key/key?version="+created, "")
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Equal(t, StatusVersionMismatch, resp.StatusCode)
+	value, err := s.Get(context.Background(), "key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("2"), value)
+
+	for _, query := range []string{"version=0", "version=x"} {
+		_, resp = serve(s.DeleteKey, http.MethodDelete, "/v1/key/key?"+query, "")
+		assert.Equal(t, StatusInvalidValue, resp.StatusCode, query)
+	}
+
+	w, _ = serve(s.DeleteKey, http.MethodDelete, "/v1/key/key?version="+updated, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	_, resp = serve(s.PutValue, http.MethodPut, "/v1/key/key/value?version="+updated, "3")
+	assert.Equal(t, StatusVersionMismatch, resp.StatusCode, "deleted keys have no version")
+}
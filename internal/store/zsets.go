@@ -0,0 +1,236 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+
+	"codesignal/internal/repository"
+)
+
+// Sorted sets are values holding a JSON array of scored members, sorted by
+// score, whose members are added atomically by the repository, see
+// repository.AddScores. Ranges are read here, by rank or by score.
+
+// ScoresRequest represents the payload adding members to a sorted set.
+type ScoresRequest struct {
+	Members []repository.ScoredMember `json:"members"`
+}
+
+// ScoresResponse represents a range of a sorted set returned by the API,
+// sorted by score then member.
+type ScoresResponse struct {
+	Message    string                    `json:"message"`
+	StatusCode StatusCode                `json:"status_code"`
+	Data       []repository.ScoredMember `json:"data"`
+}
+
+// ScoreBound bounds a range of scores.
+type ScoreBound struct {
+	Score float64
+	// Exclusive excludes Score from the range.
+	Exclusive bool
+}
+
+// ParseScoreBound parses a bound of a range of scores: a number, -inf or
+// +inf, prefixed with ( to exclude it from the range.
+func ParseScoreBound(s string) (ScoreBound, error) {
+	var b ScoreBound
+	s, b.Exclusive = strings.CutPrefix(s, "(")
+	score, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(score) {
+		return ScoreBound{}, errors.New("invalid score bound")
+	}
+	b.Score = score
+	return b, nil
+}
+
+// includes reports whether score is within the bounds from and to.
+func includes(from, to ScoreBound, score float64) bool {
+	if score < from.Score || (from.Exclusive && score == from.Score) {
+		return false
+	}
+	return score < to.Score || (!to.Exclusive && score == to.Score)
+}
+
+// AddScores adds members to the sorted set stored at key, creating it if
+// missing, or updates their score, and returns those it didn't hold.
+func (s *Service) AddScores(ctx context.Context, key string, members []repository.ScoredMember) ([]string, error) {
+	key = s.NormalizeKey(key)
+	if err := s.checkUpdate(ctx, key); err != nil {
+		return nil, err
+	}
+	if err := s.checkMemory(); err != nil {
+		return nil, err
+	}
+
+	s.hotKeys.Write(key)
+	var added []string
+	err := s.update(ctx, "update", key, func(value []byte, exists bool) ([]byte, error) {
+		var err error
+		value, added, err = repository.ApplyAddScores(value, exists, members, s.getMaxValueSize())
+		return value, err
+	}, func() error {
+		var err error
+		added, err = s.store.AddScores(ctx, key, members, s.getMaxValueSize())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return added, nil
+}
+
+// Scores returns the members of the sorted set stored at key, or
+// ErrKeyNotFound.
+func (s *Service) Scores(ctx context.Context, key string) ([]repository.ScoredMember, error) {
+	value, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return repository.DecodeSortedSet(value)
+}
+
+// Range returns the members of the sorted set stored at key ranked from
+// start to stop, both included. Negative ranks count from the end, -1
+// being the last member.
+func (s *Service) Range(ctx context.Context, key string, start, stop int) ([]repository.ScoredMember, error) {
+	members, err := s.Scores(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(members)
+	if start < 0 {
+		start = max(start+n, 0)
+	}
+	if stop < 0 {
+		stop += n
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []repository.ScoredMember{}, nil
+	}
+	return members[start : stop+1], nil
+}
+
+// RangeByScore returns up to limit members of the sorted set stored at key
+// scored between the bounds from and to. A limit of zero or less returns
+// every member.
+func (s *Service) RangeByScore(ctx context.Context, key string, from, to ScoreBound, limit int) ([]repository.ScoredMember, error) {
+	members, err := s.Scores(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Members are sorted by score, the first one within the range starts
+	// it.
+	first := sort.Search(len(members), func(i int) bool {
+		return includes(from, ScoreBound{Score: math.Inf(1)}, members[i].Score)
+	})
+	result := []repository.ScoredMember{}
+	for _, m := range members[first:] {
+		if !includes(from, to, m.Score) || (limit > 0 && len(result) == limit) {
+			break
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// AddScoresKey adds members to the sorted set of a key, answering with the
+// members it didn't hold.
+func (s *Service) AddScoresKey(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+
+	var req ScoresRequest
+	if err := decodeBody(r.Body, &req); err != nil {
+		s.writeDecodeError(w, r, err)
+		return
+	}
+
+	added, err := s.AddScores(r.Context(), key, req.Members)
+	if err != nil {
+		s.writeError(w, r, err, "failed to add members")
+		return
+	}
+	if added == nil {
+		added = []string{}
+	}
+	s.doJSONWrite(w, r, http.StatusOK, MembersResponse{Message: "members added", StatusCode: StatusSuccess, Data: added})
+}
+
+// RangeKey returns the members of the sorted set of a key ranked from the
+// start to the stop query parameters, the whole set when omitted.
+func (s *Service) RangeKey(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+	query := r.URL.Query()
+
+	ranks := []struct {
+		name string
+		rank int
+	}{{"start", 0}, {"stop", -1}}
+	for i, p := range ranks {
+		if v := query.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: p.name + " must be an integer", StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: p.name}})
+				return
+			}
+			ranks[i].rank = n
+		}
+	}
+
+	members, err := s.Range(r.Context(), key, ranks[0].rank, ranks[1].rank)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get range")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, ScoresResponse{Message: "range found", StatusCode: StatusSuccess, Data: members})
+}
+
+// RangeByScoreKey returns up to the limit query parameter of members of the
+// sorted set of a key scored between the min and max query parameters,
+// every member when omitted.
+func (s *Service) RangeByScoreKey(w http.ResponseWriter, r *http.Request) {
+	key := httprouter.ParamsFromContext(r.Context()).ByName("key")
+	query := r.URL.Query()
+
+	bounds := []struct {
+		name  string
+		bound ScoreBound
+	}{{"min", ScoreBound{Score: math.Inf(-1)}}, {"max", ScoreBound{Score: math.Inf(1)}}}
+	for i, p := range bounds {
+		if v := query.Get(p.name); v != "" {
+			b, err := ParseScoreBound(v)
+			if err != nil {
+				s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: p.name + " must be a number, -inf or +inf, prefixed with ( to exclude it", StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: p.name}})
+				return
+			}
+			bounds[i].bound = b
+		}
+	}
+
+	limit := 0
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			s.doJSONWrite(w, r, http.StatusBadRequest, Response{Message: "limit must be a positive integer", StatusCode: StatusInvalidValue, detail: &ErrorDetail{Field: "limit"}})
+			return
+		}
+		limit = n
+	}
+
+	members, err := s.RangeByScore(r.Context(), key, bounds[0].bound, bounds[1].bound, limit)
+	if err != nil {
+		s.writeError(w, r, err, "failed to get range")
+		return
+	}
+	s.doJSONWrite(w, r, http.StatusOK, ScoresResponse{Message: "range found", StatusCode: StatusSuccess, Data: members})
+}
@@ -0,0 +1,48 @@
+package tenant
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/store"
+)
+
+// UsageResponse represents the usage of the tenants returned by the API.
+type UsageResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       []Usage          `json:"data"`
+}
+
+// Handler serves the tenant admin endpoints.
+type Handler struct {
+	registry *Registry
+}
+
+// NewHandler returns the admin handler reporting the usage of the tenants
+// of registry.
+func NewHandler(registry *Registry) *Handler {
+	return &Handler{registry: registry}
+}
+
+// Usage returns the usage of the tenants.
+func (h *Handler) Usage(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	usage, err := h.registry.Usage(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("failed to count the keys of the tenants")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to report the usage of the tenants", StatusCode: store.StatusStorageError})
+		return
+	}
+	writeJSON(log, w, http.StatusOK, UsageResponse{Message: "tenant usage", StatusCode: store.StatusSuccess, Data: usage})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,259 @@
+// Package tenant isolates the keys of the teams sharing a deployment.
+//
+// A Registry identifies the tenant of a request by its X-Tenant header or,
+// with a domain, by the subdomain of its host, such as acme in
+// acme.kv.example.com. Each tenant has a store of its own, opened on its
+// first request: its keys, the data files of its storage engine and tier,
+// its change events, its pub/sub channels and its value size limit are
+// never shared with another tenant or with the default store. The registry
+// also meters the requests and bandwidth of each tenant, for chargeback,
+// and rejects its requests over its rate limit.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/pubsub"
+	"codesignal/internal/repository"
+)
+
+// DefaultHeader is the header identifying the tenant of a request.
+const DefaultHeader = "X-Tenant"
+
+var (
+	// ErrTenantRequired is returned for the requests identifying no tenant.
+	ErrTenantRequired = errors.New("tenant required")
+	// ErrInvalidTenant is returned for the tenant names that aren't DNS
+	// labels.
+	ErrInvalidTenant = errors.New("invalid tenant, expected lowercase letters, digits and hyphens")
+	// ErrTenantNotFound is returned for the tenants not configured.
+	ErrTenantNotFound = errors.New("tenant not found")
+)
+
+// nameRE matches the names of tenants, DNS labels so they can be
+// subdomains, and directory names.
+var nameRE = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Config holds the tenants of the deployment.
+type Config struct {
+	// Enabled serves the keys of each tenant from its own store.
+	Enabled bool `envconfig:"ENABLED"`
+	// Names are the tenants allowed, such as "acme,globex".
+	Names []string `envconfig:"NAMES"`
+	// Header is the header identifying the tenant of a request.
+	Header string `envconfig:"HEADER" default:"X-Tenant"`
+	// Domain is the parent domain of the subdomains identifying the
+	// tenants, such as kv.example.com. Empty identifies them by header
+	// only.
+	Domain string `envconfig:"DOMAIN"`
+	// Dir is the directory of the data files of the tenants, one
+	// directory per tenant, used when the storage engine or the tier is
+	// enabled.
+	Dir string `envconfig:"DIR" default:"tenants"`
+	// MaxValueSizes overrides the maximum size of a value in bytes by
+	// tenant, such as "acme:1048576".
+	MaxValueSizes map[string]int `envconfig:"MAX_VALUE_SIZES"`
+	// RateLimit is the requests per second allowed to every tenant, 0
+	// doesn't limit them.
+	RateLimit float64 `envconfig:"RATE_LIMIT"`
+	// RateLimits overrides the requests per second by tenant, such as
+	// "acme:50".
+	RateLimits map[string]float64 `envconfig:"RATE_LIMITS"`
+	// Burst is the number of requests a tenant can send at once over its
+	// rate, its rate rounded up when zero.
+	Burst int `envconfig:"BURST"`
+}
+
+// Validate reports the tenant names that aren't valid, and the limits of
+// tenants not configured.
+func (c Config) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if len(c.Names) == 0 {
+		return errors.New("names must not be empty")
+	}
+	names := make(map[string]bool, len(c.Names))
+	for _, name := range c.Names {
+		if !nameRE.MatchString(name) {
+			return fmt.Errorf("%q: %w", name, ErrInvalidTenant)
+		}
+		names[name] = true
+	}
+	for name, size := range c.MaxValueSizes {
+		if !names[name] {
+			return fmt.Errorf("max value size of %q: %w", name, ErrTenantNotFound)
+		}
+		if size <= 0 {
+			return fmt.Errorf("max value size of %q must be positive, got %d", name, size)
+		}
+	}
+	if c.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative, got %g", c.RateLimit)
+	}
+	for name, rate := range c.RateLimits {
+		if !names[name] {
+			return fmt.Errorf("rate limit of %q: %w", name, ErrTenantNotFound)
+		}
+		if rate <= 0 {
+			return fmt.Errorf("rate limit of %q must be positive, got %g", name, rate)
+		}
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("burst must not be negative, got %d", c.Burst)
+	}
+	return nil
+}
+
+// Registry holds the stores of the tenants.
+type Registry struct {
+	log    zerolog.Logger
+	cfg    Config
+	opts   repository.Opts
+	names  map[string]bool
+	domain string
+
+	// meters are the usage of the tenants, by name.
+	meters map[string]*meter
+
+	mu      sync.Mutex
+	tenants map[string]*Tenant
+}
+
+// Tenant is the isolated store of a tenant.
+type Tenant struct {
+	Name  string
+	Store *repository.KeyValueStore
+	// Events receives the changes of the keys of the tenant.
+	Events *events.Bus
+	// Channels is the broker of the pub/sub channels of the tenant.
+	Channels *pubsub.Broker
+}
+
+// New returns the registry of the tenants of cfg, their stores opened with
+// opts, nil when disabled. The stores of tenants publish their changes to
+// buses of their own and don't load the mapped file of the default store.
+func New(log zerolog.Logger, cfg Config, opts repository.Opts) (*Registry, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Header == "" {
+		cfg.Header = DefaultHeader
+	}
+	opts.MappedFile = ""
+	opts.ExpectedKeys = 0
+
+	r := &Registry{
+		log:     log.With().Str("component", "tenant").Logger(),
+		cfg:     cfg,
+		opts:    opts,
+		names:   make(map[string]bool, len(cfg.Names)),
+		meters:  make(map[string]*meter, len(cfg.Names)),
+		tenants: make(map[string]*Tenant, len(cfg.Names)),
+	}
+	if cfg.Domain != "" {
+		r.domain = "." + strings.ToLower(strings.Trim(cfg.Domain, "."))
+	}
+	for _, name := range cfg.Names {
+		r.names[name] = true
+		rate := cfg.RateLimit
+		if n, ok := cfg.RateLimits[name]; ok {
+			rate = n
+		}
+		r.meters[name] = &meter{limiter: newLimiter(rate, cfg.Burst)}
+	}
+	return r, nil
+}
+
+// Resolve returns the tenant of req, named by its header or else by the
+// subdomain of its host.
+func (r *Registry) Resolve(req *http.Request) (string, error) {
+	name := req.Header.Get(r.cfg.Header)
+	if name == "" && r.domain != "" {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		name, _ = strings.CutSuffix(strings.ToLower(host), r.domain)
+		if name == strings.ToLower(host) {
+			name = ""
+		}
+	}
+	switch {
+	case name == "":
+		return "", ErrTenantRequired
+	case !nameRE.MatchString(name):
+		return "", ErrInvalidTenant
+	case !r.names[name]:
+		return "", ErrTenantNotFound
+	}
+	return name, nil
+}
+
+// MaxValueSize returns the maximum size of the values of tenant, 0 when it
+// has the limit of the default store.
+func (r *Registry) MaxValueSize(tenant string) int {
+	return r.cfg.MaxValueSizes[tenant]
+}
+
+// Open returns the tenant named name, opening its store on first use.
+func (r *Registry) Open(name string) (*Tenant, error) {
+	if !r.names[name] {
+		return nil, ErrTenantNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if t, ok := r.tenants[name]; ok {
+		return t, nil
+	}
+	t := &Tenant{
+		Name:     name,
+		Events:   events.NewBus(events.DefaultBufferSize),
+		Channels: pubsub.NewBroker(events.DefaultBufferSize),
+	}
+	opts := r.opts
+	opts.Events = t.Events
+	if opts.LSM.Dir != "" {
+		opts.LSM.Dir = filepath.Join(r.cfg.Dir, name, "lsm")
+	}
+	if opts.Tier.Dir != "" {
+		opts.Tier.Dir = filepath.Join(r.cfg.Dir, name, "tier")
+	}
+	s, err := repository.NewKeyValueStore(r.log.With().Str("tenant", name).Logger(), opts)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", name, err)
+	}
+	t.Store = s
+	r.tenants[name] = t
+	r.log.Info().Str("tenant", name).Msg("tenant store opened")
+	return t, nil
+}
+
+// Close closes the stores of the tenants opened.
+func (r *Registry) Close(context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	for name, t := range r.tenants {
+		if err := t.Store.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", name, err))
+		}
+		delete(r.tenants, name)
+	}
+	return errors.Join(errs...)
+}
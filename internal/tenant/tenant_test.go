@@ -0,0 +1,95 @@
+package tenant
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/repository"
+)
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Config{}.Validate())
+	assert.NoError(t, Config{Enabled: true, Names: []string{"acme", "team-2"}}.Validate())
+	assert.EqualError(t, Config{Enabled: true}.Validate(), "names must not be empty")
+	assert.ErrorIs(t, Config{Enabled: true, Names: []string{"-acme"}}.Validate(), ErrInvalidTenant)
+	assert.ErrorIs(t, Config{Enabled: true, Names: []string{"acme"}, MaxValueSizes: map[string]int{"globex": 1}}.Validate(), ErrTenantNotFound)
+	assert.EqualError(t, Config{Enabled: true, Names: []string{"acme"}, MaxValueSizes: map[string]int{"acme": 0}}.Validate(),
+		`max value size of "acme" must be positive, got 0`)
+}
+
+func TestResolve(t *testing.T) {
+	r, err := New(zerolog.Nop(), Config{Enabled: true, Names: []string{"acme"}, Domain: "kv.example.com"}, repository.Opts{})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		header, host string
+		want         string
+		err          error
+	}{
+		{header: "acme", host: "localhost", want: "acme"},
+		{host: "acme.kv.example.com", want: "acme"},
+		{host: "ACME.kv.example.com:8080", want: "acme"},
+		{header: "acme", host: "globex.kv.example.com", want: "acme"},
+		{host: "kv.example.com", err: ErrTenantRequired},
+		{host: "localhost", err: ErrTenantRequired},
+		{host: "a.b.kv.example.com", err: ErrInvalidTenant},
+		{header: "Acme", err: ErrInvalidTenant},
+		{header: "globex", err: ErrTenantNotFound},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/key/a", nil)
+		req.Host = tt.host
+		if tt.header != "" {
+			req.Header.Set(DefaultHeader, tt.header)
+		}
+		got, err := r.Resolve(req)
+		assert.ErrorIs(t, err, tt.err, "%+v", tt)
+		assert.Equal(t, tt.want, got, "%+v", tt)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(zerolog.Nop(), Config{Enabled: true, Names: []string{"acme", "globex"}, Dir: dir},
+		repository.Opts{LSM: repository.LSMConfig{Dir: filepath.Join(dir, "default")}})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	acme, err := r.Open("acme")
+	require.NoError(t, err)
+	again, err := r.Open("acme")
+	require.NoError(t, err)
+	assert.Same(t, acme, again)
+	globex, err := r.Open("globex")
+	require.NoError(t, err)
+	_, err = r.Open("initech")
+	assert.ErrorIs(t, err, ErrTenantNotFound)
+
+	sub := acme.Events.Subscribe("")
+	defer sub.Close()
+	require.NoError(t, acme.Store.Set(ctx, "a", []byte("acme")))
+	_, ok, err := globex.Store.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "a", (<-sub.Events()).Key)
+
+	// The data files of each tenant are in its own directory, and kept
+	// across restarts.
+	require.NoError(t, r.Close(ctx))
+	_, err = os.Stat(filepath.Join(dir, "acme", "lsm"))
+	require.NoError(t, err)
+	acme, err = r.Open("acme")
+	require.NoError(t, err)
+	value, ok, err := acme.Store.Get(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "acme", string(value))
+	require.NoError(t, r.Close(ctx))
+}
@@ -0,0 +1,137 @@
+package tenant
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"codesignal/internal/metrics"
+)
+
+// Usage is the usage of a tenant since the server started.
+type Usage struct {
+	Tenant string `json:"tenant"`
+	// Open reports whether the store of the tenant is open, its keys
+	// counted.
+	Open bool `json:"open"`
+	// Requests counts the requests of the tenant, limited ones included.
+	Requests int64 `json:"requests"`
+	// Limited counts the requests rejected over the rate limit.
+	Limited int64 `json:"limited"`
+	// BytesIn and BytesOut are the sizes of the request and response
+	// bodies.
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+	// Keys and BytesStored are the number and size of the live keys.
+	Keys        int   `json:"keys"`
+	BytesStored int64 `json:"bytes_stored"`
+}
+
+// meter counts the usage of a tenant.
+type meter struct {
+	requests, limited, bytesIn, bytesOut atomic.Int64
+	// limiter is nil without rate limit.
+	limiter *limiter
+}
+
+// Allow counts a request of tenant, reporting whether its rate limit allows
+// it and otherwise the time until it would.
+func (r *Registry) Allow(tenant string) (time.Duration, bool) {
+	m, ok := r.meters[tenant]
+	if !ok {
+		return 0, true
+	}
+	m.requests.Add(1)
+	wait, ok := m.limiter.allow(time.Now())
+	if !ok {
+		m.limited.Add(1)
+		metrics.TenantRequestsLimited.Add(1)
+	}
+	return wait, ok
+}
+
+// Transferred counts the bytes received from and sent to tenant.
+func (r *Registry) Transferred(tenant string, in, out int64) {
+	if m, ok := r.meters[tenant]; ok {
+		m.bytesIn.Add(in)
+		m.bytesOut.Add(out)
+	}
+}
+
+// Usage returns the usage of the tenants, ordered by name. The keys of the
+// open stores are counted through all of them.
+func (r *Registry) Usage(ctx context.Context) ([]Usage, error) {
+	r.mu.Lock()
+	open := make(map[string]*Tenant, len(r.tenants))
+	for name, t := range r.tenants {
+		open[name] = t
+	}
+	r.mu.Unlock()
+
+	usage := make([]Usage, 0, len(r.meters))
+	for name, m := range r.meters {
+		u := Usage{
+			Tenant:   name,
+			Requests: m.requests.Load(),
+			Limited:  m.limited.Load(),
+			BytesIn:  m.bytesIn.Load(),
+			BytesOut: m.bytesOut.Load(),
+		}
+		if t, ok := open[name]; ok {
+			stats, err := t.Store.Stats(ctx)
+			if err != nil {
+				return nil, err
+			}
+			u.Open, u.Keys, u.BytesStored = true, stats.Keys, stats.Bytes
+		}
+		usage = append(usage, u)
+	}
+	slices.SortFunc(usage, func(a, b Usage) int { return cmp.Compare(a.Tenant, b.Tenant) })
+	return usage, nil
+}
+
+// limiter is a token bucket refilled at rate tokens per second, holding up
+// to burst tokens.
+type limiter struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns the limiter of rate requests per second, nil when rate
+// is zero.
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// allow takes a token at now, reporting the time until one is available
+// when there is none.
+func (l *limiter) allow(now time.Time) (time.Duration, bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second)), false
+}
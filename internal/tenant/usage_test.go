@@ -0,0 +1,38 @@
+package tenant
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	assert.Nil(t, newLimiter(0, 10))
+	_, ok := (*limiter)(nil).allow(time.Now())
+	assert.True(t, ok)
+
+	now := time.Now()
+	l := newLimiter(2, 3)
+	for range 3 {
+		_, ok := l.allow(now)
+		assert.True(t, ok)
+	}
+	wait, ok := l.allow(now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, wait)
+
+	// Tokens are refilled at the rate, up to the burst.
+	_, ok = l.allow(now.Add(500 * time.Millisecond))
+	assert.True(t, ok)
+	for range 3 {
+		_, ok := l.allow(now.Add(time.Hour))
+		assert.True(t, ok)
+	}
+	_, ok = l.allow(now.Add(time.Hour))
+	assert.False(t, ok)
+
+	// The burst defaults to the rate rounded up.
+	l = newLimiter(1.5, 0)
+	assert.Equal(t, 2.0, l.burst)
+}
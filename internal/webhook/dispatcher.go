@@ -0,0 +1,371 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/events"
+	"codesignal/internal/metrics"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// Types of the changes delivered.
+const (
+	TypeCreate = "create"
+	TypeUpdate = "update"
+	TypeDelete = "delete"
+	TypeExpire = "expire"
+)
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	// ID identifies the delivery, the same across its attempts, also sent
+	// in the X-Webhook-Delivery header.
+	ID      string `json:"id"`
+	Webhook string `json:"webhook"`
+	Type    string `json:"type"`
+	Key     string `json:"key"`
+	// Value is the value of created and updated keys.
+	Value *string   `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// Dispatcher delivers the changes published on the bus to the registered
+// webhooks, a server.Service.
+type Dispatcher struct {
+	log    zerolog.Logger
+	cfg    Config
+	bus    *events.Bus
+	repo   repository.Store
+	active func() bool
+	client *http.Client
+	now    func() time.Time
+
+	mu      sync.Mutex
+	workers map[string]*worker
+	wg      sync.WaitGroup
+
+	done chan struct{}
+	stop sync.Once
+}
+
+// worker delivers the queued changes of a webhook in order.
+type worker struct {
+	hook  Webhook
+	queue chan Payload
+	// stop is closed when the webhook is unregistered or the dispatcher
+	// shut down, aborting the retries of the current delivery.
+	stop chan struct{}
+}
+
+// New returns the dispatcher configured by cfg, nil when disabled. The
+// registrations are read from repo. Changes are only delivered while
+// active returns true, so a single node of a cluster delivers them; nil
+// delivers them always.
+func New(log zerolog.Logger, cfg Config, bus *events.Bus, repo repository.Store, active func() bool) (*Dispatcher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.Timeout <= 0 || cfg.Backoff <= 0 || cfg.MaxBackoff < cfg.Backoff || cfg.MaxAttempts < 1 || cfg.QueueSize < 1 {
+		return nil, errors.New("webhook timeout, backoff, max attempts and queue size must be positive, and the max backoff at least the backoff")
+	}
+	return &Dispatcher{
+		log:     log.With().Str("component", "webhook").Logger(),
+		cfg:     cfg,
+		bus:     bus,
+		repo:    repo,
+		active:  active,
+		client:  &http.Client{Timeout: cfg.Timeout},
+		now:     time.Now,
+		workers: make(map[string]*worker),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Name implements server.Service.
+func (d *Dispatcher) Name() string {
+	return "webhook"
+}
+
+// Serve implements server.Service, delivering changes until shut down.
+func (d *Dispatcher) Serve() error {
+	for {
+		// Subscribing first, no registration is missed between loading
+		// them and receiving their changes.
+		sub := d.bus.Subscribe("")
+		if err := d.load(); err != nil {
+			d.log.Error().Err(err).Msg("failed to load webhooks")
+		}
+		d.consume(sub)
+		sub.Close()
+
+		select {
+		case <-d.done:
+			return nil
+		default:
+		}
+		d.log.Warn().Err(sub.Err()).Msg("webhook subscription dropped, reloading webhooks")
+	}
+}
+
+// Shutdown implements server.Service, aborting the deliveries in progress.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.stop.Do(func() {
+		close(d.done)
+		d.mu.Lock()
+		for id, w := range d.workers {
+			close(w.stop)
+			delete(d.workers, id)
+		}
+		d.mu.Unlock()
+	})
+
+	stopped := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// load starts the workers of the webhooks registered in the repository,
+// and stops those of the webhooks no longer registered.
+func (d *Dispatcher) load() error {
+	hooks, err := List(context.Background(), d.repo)
+	if err != nil {
+		return err
+	}
+	registered := make(map[string]bool, len(hooks))
+	for _, hook := range hooks {
+		registered[hook.ID] = true
+		d.register(hook)
+	}
+
+	d.mu.Lock()
+	var removed []string
+	for id := range d.workers {
+		if !registered[id] {
+			removed = append(removed, id)
+		}
+	}
+	d.mu.Unlock()
+	for _, id := range removed {
+		d.unregister(id)
+	}
+	return nil
+}
+
+// consume dispatches the events of sub until it is dropped or the
+// dispatcher shut down.
+func (d *Dispatcher) consume(sub *events.Subscription) {
+	for {
+		select {
+		case <-d.done:
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			d.dispatch(e)
+		}
+	}
+}
+
+// dispatch queues the delivery of e to the webhooks of its key, or
+// updates the registrations when e changes one.
+func (d *Dispatcher) dispatch(e events.Event) {
+	if id, ok := strings.CutPrefix(e.Key, store.WebhookPrefix); ok {
+		if e.Type != events.TypeSet {
+			d.unregister(id)
+			return
+		}
+		hook, err := decode(e.Key, e.Value)
+		if err != nil {
+			d.log.Error().Err(err).Msg("invalid webhook registration")
+			return
+		}
+		d.register(hook)
+		return
+	}
+	if store.ReservedKey(e.Key) || (d.active != nil && !d.active()) {
+		return
+	}
+
+	p := Payload{Key: e.Key, Time: e.Time}
+	switch {
+	case e.Type == events.TypeDelete:
+		p.Type = TypeDelete
+	case e.Type == events.TypeExpire:
+		p.Type = TypeExpire
+	case e.Created:
+		p.Type = TypeCreate
+	default:
+		p.Type = TypeUpdate
+	}
+	if e.Type == events.TypeSet {
+		value := string(e.Value)
+		p.Value = &value
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, w := range d.workers {
+		if !strings.HasPrefix(e.Key, w.hook.Prefix) {
+			continue
+		}
+		select {
+		case w.queue <- p:
+		default:
+			metrics.WebhooksDropped.Add(1)
+			d.log.Warn().Str("webhook", w.hook.ID).Str("key", e.Key).Msg("webhook queue full, event dropped")
+		}
+	}
+}
+
+// register starts the worker of hook, replacing the one of a previous
+// registration with another URL, prefix or secret.
+func (d *Dispatcher) register(hook Webhook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	if w, ok := d.workers[hook.ID]; ok {
+		if w.hook == hook {
+			return
+		}
+		close(w.stop)
+	}
+	w := &worker{
+		hook:  hook,
+		queue: make(chan Payload, d.cfg.QueueSize),
+		stop:  make(chan struct{}),
+	}
+	d.workers[hook.ID] = w
+	d.wg.Add(1)
+	go d.run(w)
+	d.log.Info().Str("webhook", hook.ID).Str("url", hook.URL).Str("prefix", hook.Prefix).Msg("webhook registered")
+}
+
+// unregister stops the worker of the webhook id, dropping its queue.
+func (d *Dispatcher) unregister(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if w, ok := d.workers[id]; ok {
+		close(w.stop)
+		delete(d.workers, id)
+		d.log.Info().Str("webhook", id).Msg("webhook unregistered")
+	}
+}
+
+// run delivers the queue of w until it is stopped.
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case p := <-w.queue:
+			d.deliver(w, p)
+		}
+	}
+}
+
+// deliver posts p to the webhook of w, retrying with exponential backoff
+// on network errors, server errors, 408 and 429.
+func (d *Dispatcher) deliver(w *worker, p Payload) {
+	var err error
+	p.Webhook = w.hook.ID
+	if p.ID, err = randomHex(16); err != nil {
+		d.log.Error().Err(err).Msg("failed to generate delivery id")
+		return
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		d.log.Error().Err(err).Msg("failed to encode delivery")
+		return
+	}
+
+	log := d.log.With().Str("webhook", w.hook.ID).Str("delivery", p.ID).Str("key", p.Key).Logger()
+	backoff := d.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := d.post(w, p.ID, body)
+		if err == nil {
+			metrics.WebhooksDelivered.Add(1)
+			log.Debug().Int("attempt", attempt).Msg("webhook delivered")
+			return
+		}
+		if !retry || attempt == d.cfg.MaxAttempts {
+			metrics.WebhooksFailed.Add(1)
+			log.Error().Err(err).Int("attempt", attempt).Msg("webhook delivery failed")
+			return
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Dur("backoff", backoff).Msg("webhook delivery failed, retrying")
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-w.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, d.cfg.MaxBackoff)
+	}
+}
+
+// post sends a delivery attempt, reporting whether a failure is worth
+// retrying.
+func (d *Dispatcher) post(w *worker, id string, body []byte) (retry bool, err error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-w.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "key-value-store-webhook")
+	req.Header.Set("X-Webhook-ID", w.hook.ID)
+	req.Header.Set("X-Webhook-Delivery", id)
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Webhook-Signature", Sign(w.hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook answered %s", resp.Status)
+}
@@ -0,0 +1,219 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+)
+
+// receiver records the deliveries of a webhook, answering with the
+// status codes of codes before 200.
+type receiver struct {
+	t          *testing.T
+	srv        *httptest.Server
+	deliveries chan Payload
+	attempts   atomic.Int32
+}
+
+func newReceiver(t *testing.T, secret string, codes ...int) *receiver {
+	rcv := &receiver{t: t, deliveries: make(chan Payload, 10)}
+	rcv.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		timestamp, err := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, Sign(secret, timestamp, body), r.Header.Get("X-Webhook-Signature"))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var p Payload
+		require.NoError(t, json.Unmarshal(body, &p))
+		assert.Equal(t, p.ID, r.Header.Get("X-Webhook-Delivery"))
+		assert.Equal(t, p.Webhook, r.Header.Get("X-Webhook-ID"))
+
+		if n := int(rcv.attempts.Add(1)); n <= len(codes) {
+			w.WriteHeader(codes[n-1])
+			return
+		}
+		rcv.deliveries <- p
+	}))
+	t.Cleanup(rcv.srv.Close)
+	return rcv
+}
+
+func (rcv *receiver) next() Payload {
+	rcv.t.Helper()
+	select {
+	case p := <-rcv.deliveries:
+		return p
+	case <-time.After(5 * time.Second):
+		rcv.t.Fatal("no delivery")
+		return Payload{}
+	}
+}
+
+func (rcv *receiver) none() {
+	rcv.t.Helper()
+	select {
+	case p := <-rcv.deliveries:
+		rcv.t.Fatalf("unexpected delivery of %s %s", p.Type, p.Key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func testConfig() Config {
+	return Config{Enabled: true, Timeout: time.Second, MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, QueueSize: 10}
+}
+
+// startDispatcher serves a dispatcher of the changes of repo until the
+// test ends.
+func startDispatcher(t *testing.T, cfg Config, bus *events.Bus, repo repository.Store, active func() bool) *Dispatcher {
+	d, err := New(zerolog.Nop(), cfg, bus, repo, active)
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- d.Serve() }()
+	t.Cleanup(func() {
+		require.NoError(t, d.Shutdown(context.Background()))
+		require.NoError(t, <-served)
+	})
+	return d
+}
+
+// waitRegistered waits for the dispatcher to start the worker of id.
+func waitRegistered(t *testing.T, d *Dispatcher, id string) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_, ok := d.workers[id]
+		return ok
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestNew(t *testing.T) {
+	d, err := New(zerolog.Nop(), Config{}, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, d, "disabled")
+
+	_, err = New(zerolog.Nop(), Config{Enabled: true}, nil, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(0)
+	repo := newRepo(t, bus)
+
+	// Registered before the dispatcher starts, loaded from the repository.
+	rcv := newReceiver(t, "secret")
+	orders, err := Register(ctx, repo, Webhook{URL: rcv.srv.URL, Prefix: "orders/", Secret: "secret"})
+	require.NoError(t, err)
+	d := startDispatcher(t, testConfig(), bus, repo, nil)
+	waitRegistered(t, d, orders.ID)
+
+	require.NoError(t, repo.Set(ctx, "orders/1", []byte("new")))
+	p := rcv.next()
+	assert.NotEmpty(t, p.ID)
+	assert.Equal(t, orders.ID, p.Webhook)
+	assert.Equal(t, TypeCreate, p.Type)
+	assert.Equal(t, "orders/1", p.Key)
+	require.NotNil(t, p.Value)
+	assert.Equal(t, "new", *p.Value)
+	assert.False(t, p.Time.IsZero())
+
+	require.NoError(t, repo.Set(ctx, "users/1", []byte("other prefix")))
+	require.NoError(t, repo.Set(ctx, "orders/1", []byte("paid")))
+	p = rcv.next()
+	assert.Equal(t, TypeUpdate, p.Type)
+	assert.Equal(t, "paid", *p.Value)
+
+	require.NoError(t, repo.Delete(ctx, "orders/1"))
+	p = rcv.next()
+	assert.Equal(t, TypeDelete, p.Type)
+	assert.Nil(t, p.Value)
+
+	// Registered while running, from the change of its key.
+	all := newReceiver(t, "all")
+	hook, err := Register(ctx, repo, Webhook{URL: all.srv.URL, Secret: "all"})
+	require.NoError(t, err)
+	waitRegistered(t, d, hook.ID)
+	require.NoError(t, repo.Set(ctx, "users/2", []byte("x")))
+	assert.Equal(t, "users/2", all.next().Key)
+
+	require.NoError(t, Unregister(ctx, repo, orders.ID))
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		_, ok := d.workers[orders.ID]
+		return !ok
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, repo.Set(ctx, "orders/2", []byte("x")))
+	assert.Equal(t, "orders/2", all.next().Key)
+	rcv.none()
+}
+
+func TestDispatcherRetries(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(0)
+	repo := newRepo(t, bus)
+	d := startDispatcher(t, testConfig(), bus, repo, nil)
+
+	retried := newReceiver(t, "s", http.StatusInternalServerError, http.StatusTooManyRequests)
+	hook, err := Register(ctx, repo, Webhook{URL: retried.srv.URL, Prefix: "a", Secret: "s"})
+	require.NoError(t, err)
+	waitRegistered(t, d, hook.ID)
+	require.NoError(t, repo.Set(ctx, "a", []byte("1")))
+	retried.next()
+	assert.EqualValues(t, 3, retried.attempts.Load())
+
+	// Rejected deliveries aren't retried, the next one is delivered.
+	rejected := newReceiver(t, "s", http.StatusBadRequest)
+	hook, err = Register(ctx, repo, Webhook{URL: rejected.srv.URL, Prefix: "b", Secret: "s"})
+	require.NoError(t, err)
+	waitRegistered(t, d, hook.ID)
+	require.NoError(t, repo.Set(ctx, "b", []byte("1")))
+	require.NoError(t, repo.Set(ctx, "b", []byte("2")))
+	assert.Equal(t, "2", *rejected.next().Value)
+	assert.EqualValues(t, 2, rejected.attempts.Load())
+
+	// Deliveries are dropped after MaxAttempts.
+	failing := newReceiver(t, "s", 500, 500, 500, 500)
+	hook, err = Register(ctx, repo, Webhook{URL: failing.srv.URL, Prefix: "c", Secret: "s"})
+	require.NoError(t, err)
+	waitRegistered(t, d, hook.ID)
+	require.NoError(t, repo.Set(ctx, "c", []byte("1")))
+	require.NoError(t, repo.Set(ctx, "c", []byte("2")))
+	assert.Equal(t, "2", *failing.next().Value)
+	assert.EqualValues(t, 5, failing.attempts.Load())
+}
+
+func TestDispatcherInactive(t *testing.T) {
+	ctx := context.Background()
+	bus := events.NewBus(0)
+	repo := newRepo(t, bus)
+	var leader atomic.Bool
+	d := startDispatcher(t, testConfig(), bus, repo, leader.Load)
+
+	rcv := newReceiver(t, "s")
+	hook, err := Register(ctx, repo, Webhook{URL: rcv.srv.URL, Secret: "s"})
+	require.NoError(t, err)
+	waitRegistered(t, d, hook.ID)
+
+	require.NoError(t, repo.Set(ctx, "a", []byte("follower")))
+	rcv.none()
+	leader.Store(true)
+	require.NoError(t, repo.Set(ctx, "a", []byte("leader")))
+	assert.Equal(t, "leader", *rcv.next().Value)
+}
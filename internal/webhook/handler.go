@@ -0,0 +1,114 @@
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// RegisterRequest represents the payload registering a webhook.
+type RegisterRequest struct {
+	URL    string `json:"url"`
+	Prefix string `json:"prefix"`
+	// Secret signs the deliveries, generated when empty.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Response represents a webhook returned by the API.
+type Response struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       Webhook          `json:"data"`
+}
+
+// ListResponse represents the registered webhooks returned by the API,
+// without their secret.
+type ListResponse struct {
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       []Webhook        `json:"data"`
+}
+
+// Handler serves the webhook admin endpoints.
+type Handler struct {
+	log  zerolog.Logger
+	repo repository.Store
+}
+
+// NewHandler returns the admin handler registering webhooks in repo.
+func NewHandler(log zerolog.Logger, repo repository.Store) *Handler {
+	return &Handler{log: log, repo: repo}
+}
+
+// Register registers the webhook of the request body, answering with its
+// secret.
+func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	var req RegisterRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+		return
+	}
+
+	hook, err := Register(r.Context(), h.repo, Webhook{URL: req.URL, Prefix: req.Prefix, Secret: req.Secret})
+	switch {
+	case errors.Is(err, ErrInvalidURL):
+		writeJSON(log, w, http.StatusBadRequest, store.Response{Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("failed to register webhook")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to register webhook", StatusCode: store.StatusStorageError})
+		return
+	}
+	log.Info().Str("webhook", hook.ID).Str("url", hook.URL).Str("prefix", hook.Prefix).Msg("webhook registered")
+	writeJSON(log, w, http.StatusCreated, Response{Message: "webhook registered", StatusCode: store.StatusSuccess, Data: hook})
+}
+
+// List returns the registered webhooks, without their secret.
+func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	hooks, err := List(r.Context(), h.repo)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list webhooks")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to list webhooks", StatusCode: store.StatusStorageError})
+		return
+	}
+	for i := range hooks {
+		hooks[i].Secret = ""
+	}
+	writeJSON(log, w, http.StatusOK, ListResponse{Message: "webhooks found", StatusCode: store.StatusSuccess, Data: hooks})
+}
+
+// Unregister removes the webhook of the id path parameter.
+func (h *Handler) Unregister(w http.ResponseWriter, r *http.Request) {
+	log := zerolog.Ctx(r.Context())
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	err := Unregister(r.Context(), h.repo, id)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		writeJSON(log, w, http.StatusNotFound, store.Response{Message: err.Error(), StatusCode: store.StatusWebhookNotFound})
+		return
+	case err != nil:
+		log.Error().Err(err).Str("webhook", id).Msg("failed to unregister webhook")
+		writeJSON(log, w, http.StatusInternalServerError, store.Response{Message: "failed to unregister webhook", StatusCode: store.StatusStorageError})
+		return
+	}
+	log.Info().Str("webhook", id).Msg("webhook unregistered")
+	writeJSON(log, w, http.StatusOK, store.Response{Message: "webhook unregistered", StatusCode: store.StatusSuccess})
+}
+
+func writeJSON(log *zerolog.Logger, w http.ResponseWriter, code int, obj any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		log.Error().Err(err).Msg("error writing response")
+	}
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/store"
+)
+
+func TestHandler(t *testing.T) {
+	h := NewHandler(zerolog.Nop(), newRepo(t, nil))
+	router := httprouter.New()
+	router.HandlerFunc(http.MethodGet, "/admin/webhooks", h.List)
+	router.HandlerFunc(http.MethodPost, "/admin/webhooks", h.Register)
+	router.HandlerFunc(http.MethodDelete, "/admin/webhooks/:id", h.Unregister)
+
+	do := func(method, path, body string, resp any) int {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(resp))
+		return rec.Code
+	}
+
+	var errResp store.Response
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/admin/webhooks", `{"url":`, &errResp))
+	assert.Equal(t, store.StatusInvalidJSON, errResp.StatusCode)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/admin/webhooks", `{"url":"example.com"}`, &errResp))
+	assert.Equal(t, store.StatusInvalidValue, errResp.StatusCode)
+
+	var registered Response
+	require.Equal(t, http.StatusCreated, do(http.MethodPost, "/admin/webhooks", `{"url":"https://example.com/hook","prefix":"orders/"}`, &registered))
+	hook := registered.Data
+	assert.Equal(t, "https://example.com/hook", hook.URL)
+	assert.Equal(t, "orders/", hook.Prefix)
+	assert.NotEmpty(t, hook.Secret)
+
+	var list ListResponse
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/webhooks", "", &list))
+	hook.Secret = ""
+	assert.Equal(t, []Webhook{hook}, list.Data, "secrets aren't listed")
+
+	var resp store.Response
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/admin/webhooks/"+hook.ID, "", &resp))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/admin/webhooks/"+hook.ID, "", &resp))
+	assert.Equal(t, store.StatusWebhookNotFound, resp.StatusCode)
+	require.Equal(t, http.StatusOK, do(http.MethodGet, "/admin/webhooks", "", &list))
+	assert.Empty(t, list.Data)
+}
@@ -0,0 +1,156 @@
+// Package webhook delivers the changes of keys to registered HTTP
+// endpoints.
+//
+// A Webhook receives a signed POST for every key created, updated, deleted
+// or expired under its prefix. Registrations are stored in the repository
+// under store.WebhookPrefix, so they are replicated and persisted like any
+// key, and every node keeps them in sync from the change bus. A Dispatcher
+// queues the deliveries of each webhook and retries them with exponential
+// backoff. Delivery is at least once and best effort: the queue of a
+// webhook falling behind drops events, and queued deliveries are lost on
+// shutdown.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// Config holds the webhook delivery settings.
+type Config struct {
+	// Enabled delivers the changes of keys to the registered webhooks.
+	// Webhooks can be registered while it is disabled.
+	Enabled bool `envconfig:"ENABLED"`
+	// Timeout bounds each delivery attempt.
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"10s"`
+	// MaxAttempts is the number of attempts of a delivery before it is
+	// dropped.
+	MaxAttempts int `envconfig:"MAX_ATTEMPTS" default:"5"`
+	// Backoff is the delay before the first retry, doubled on every retry
+	// up to MaxBackoff.
+	Backoff    time.Duration `envconfig:"BACKOFF" default:"1s"`
+	MaxBackoff time.Duration `envconfig:"MAX_BACKOFF" default:"1m"`
+	// QueueSize is the number of deliveries queued per webhook, events
+	// are dropped beyond it.
+	QueueSize int `envconfig:"QUEUE_SIZE" default:"1000"`
+}
+
+var (
+	// ErrNotFound is returned for a webhook that isn't registered.
+	ErrNotFound = errors.New("webhook not found")
+	// ErrInvalidURL is returned when registering a webhook whose URL is
+	// not an absolute http or https URL.
+	ErrInvalidURL = errors.New("webhook url must be an absolute http or https url")
+)
+
+// Webhook is an endpoint receiving the changes of the keys starting with
+// Prefix, every key when empty.
+type Webhook struct {
+	ID     string `json:"id"`
+	URL    string `json:"url"`
+	Prefix string `json:"prefix"`
+	// Secret signs the deliveries, see Sign. It is only returned when the
+	// webhook is registered.
+	Secret string `json:"secret,omitempty"`
+}
+
+// Sign returns the signature of a delivery of body at the unix timestamp,
+// sent in the X-Webhook-Signature header: sha256= followed by the hex
+// encoded HMAC-SHA256 of the timestamp, a dot and the body, keyed by the
+// secret of the webhook.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Register stores hook in repo, generating its ID, and its secret when
+// empty, and returns it.
+func Register(ctx context.Context, repo repository.Store, hook Webhook) (Webhook, error) {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, ErrInvalidURL
+	}
+	if hook.ID, err = randomHex(8); err != nil {
+		return Webhook{}, err
+	}
+	if hook.Secret == "" {
+		if hook.Secret, err = randomHex(32); err != nil {
+			return Webhook{}, err
+		}
+	}
+
+	value, err := json.Marshal(hook)
+	if err != nil {
+		return Webhook{}, err
+	}
+	if err := repo.Set(ctx, store.WebhookPrefix+hook.ID, value); err != nil {
+		return Webhook{}, fmt.Errorf("store webhook: %w", err)
+	}
+	return hook, nil
+}
+
+// Unregister removes the webhook id from repo, or returns ErrNotFound.
+func Unregister(ctx context.Context, repo repository.Store, id string) error {
+	_, found, err := repo.Get(ctx, store.WebhookPrefix+id)
+	if err != nil {
+		return fmt.Errorf("get webhook: %w", err)
+	}
+	if !found {
+		return ErrNotFound
+	}
+	if err := repo.Delete(ctx, store.WebhookPrefix+id); err != nil {
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	return nil
+}
+
+// List returns the webhooks registered in repo, with their secret, sorted
+// by ID.
+func List(ctx context.Context, repo repository.Store) ([]Webhook, error) {
+	items, err := repo.Scan(ctx, store.WebhookPrefix, "", 0)
+	if err != nil {
+		return nil, fmt.Errorf("list webhooks: %w", err)
+	}
+	hooks := make([]Webhook, 0, len(items))
+	for _, item := range items {
+		hook, err := decode(item.Key, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// decode returns the webhook stored at key.
+func decode(key string, value []byte) (Webhook, error) {
+	var hook Webhook
+	if err := json.Unmarshal(value, &hook); err != nil {
+		return Webhook{}, fmt.Errorf("decode webhook %s: %w", strings.TrimPrefix(key, store.WebhookPrefix), err)
+	}
+	return hook, nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
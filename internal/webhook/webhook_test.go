@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+func newRepo(t *testing.T, bus *events.Bus) *repository.KeyValueStore {
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = repo.Close() })
+	return repo
+}
+
+func TestSign(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(`1700000000.{"key":"a"}`))
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), Sign("secret", 1700000000, []byte(`{"key":"a"}`)))
+	assert.NotEqual(t, Sign("secret", 1700000000, []byte("a")), Sign("secret", 1700000001, []byte("a")))
+	assert.NotEqual(t, Sign("secret", 1700000000, []byte("a")), Sign("other", 1700000000, []byte("a")))
+}
+
+func TestRegistrations(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepo(t, nil)
+
+	for _, url := range []string{"", "example.com/hook", "ftp://example.com", "http://", "://x"} {
+		_, err := Register(ctx, repo, Webhook{URL: url})
+		assert.ErrorIs(t, err, ErrInvalidURL, url)
+	}
+
+	a, err := Register(ctx, repo, Webhook{URL: "https://example.com/a", Prefix: "orders/"})
+	require.NoError(t, err)
+	assert.Len(t, a.ID, 16)
+	assert.Len(t, a.Secret, 64, "a secret is generated")
+	b, err := Register(ctx, repo, Webhook{URL: "http://example.com/b", Secret: "mine"})
+	require.NoError(t, err)
+	assert.Equal(t, "mine", b.Secret)
+	assert.NotEqual(t, a.ID, b.ID)
+
+	value, found, err := repo.Get(ctx, store.WebhookPrefix+a.ID)
+	require.NoError(t, err)
+	assert.True(t, found, "registrations are keys of the repository")
+	assert.NotEmpty(t, value)
+
+	hooks, err := List(ctx, repo)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []Webhook{a, b}, hooks)
+
+	require.NoError(t, Unregister(ctx, repo, a.ID))
+	assert.ErrorIs(t, Unregister(ctx, repo, a.ID), ErrNotFound)
+	hooks, err = List(ctx, repo)
+	require.NoError(t, err)
+	assert.Equal(t, []Webhook{b}, hooks)
+}
@@ -0,0 +1,479 @@
+// Package wsapi serves the key-value store over a persistent WebSocket
+// connection.
+//
+// Clients send JSON requests carrying an id, an op ("get", "set",
+// "delete", "watch", "unwatch", "publish", "subscribe" or "unsubscribe")
+// and its arguments, and receive a response echoing the id, with the same
+// message and status codes as the REST API. Requests are executed
+// concurrently, so responses may arrive out of order. A watch streams the
+// changes of a key prefix as events tagged with the id of the watch request
+// until it is unwatched or the connection closes, and a subscription the
+// messages of pub/sub channels the same way.
+package wsapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/auth"
+	"codesignal/internal/cluster"
+	"codesignal/internal/events"
+	"codesignal/internal/pubsub"
+	"codesignal/internal/store"
+)
+
+const (
+	// maxMessageSize caps the size of a request.
+	maxMessageSize = 16 << 20
+	// maxInFlight is the number of requests executed concurrently per
+	// connection, further requests wait to be read.
+	maxInFlight = 32
+	// writeWait is the time allowed to write a message.
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed between pongs from the client.
+	pongWait = 60 * time.Second
+	// pingPeriod is how often the connection is pinged, shorter than
+	// pongWait.
+	pingPeriod = pongWait * 9 / 10
+)
+
+// Operations supported over the connection.
+const (
+	OpGet     = "get"
+	OpSet     = "set"
+	OpDelete  = "delete"
+	OpWatch   = "watch"
+	OpUnwatch = "unwatch"
+	// OpPublish, OpSubscribe and OpUnsubscribe use pub/sub channels.
+	OpPublish     = "publish"
+	OpSubscribe   = "subscribe"
+	OpUnsubscribe = "unsubscribe"
+)
+
+// Request is an operation sent by the client.
+type Request struct {
+	// ID is echoed in the response, and tags the events of a watch or the
+	// messages of a subscription.
+	ID    string `json:"id"`
+	Op    string `json:"op"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+	// TTL is an optional time-to-live for set, such as "30s".
+	TTL string `json:"ttl,omitempty"`
+	// IfNotExists makes set fail if the key already exists.
+	IfNotExists bool `json:"if_not_exists,omitempty"`
+	// Prefix selects the keys of a watch, empty watches every key.
+	Prefix string `json:"prefix,omitempty"`
+	// Channel is the channel a value is published on, or the channel or
+	// pattern ending with * subscribed to.
+	Channel string `json:"channel,omitempty"`
+}
+
+// Response is the outcome of a request.
+type Response struct {
+	ID         string           `json:"id"`
+	Message    string           `json:"message"`
+	StatusCode store.StatusCode `json:"status_code"`
+	Data       *store.KeyValue  `json:"data,omitempty"`
+}
+
+// Event is a change streamed to a watch.
+type Event struct {
+	// ID is the id of the watch request.
+	ID    string      `json:"id"`
+	Event EventDetail `json:"event"`
+}
+
+// EventDetail describes a change of a key.
+type EventDetail struct {
+	Type  events.Type `json:"type"`
+	Key   string      `json:"key"`
+	Value string      `json:"value,omitempty"`
+	Time  time.Time   `json:"time"`
+}
+
+// ChannelMessage is a message streamed to a subscription.
+type ChannelMessage struct {
+	// ID is the id of the subscribe request.
+	ID      string         `json:"id"`
+	Message pubsub.Message `json:"channel_message"`
+}
+
+// Handler upgrades requests to WebSocket connections serving the store.
+type Handler struct {
+	log      zerolog.Logger
+	svc      *store.Service
+	bus      *events.Bus
+	channels *pubsub.Broker
+	upgrader websocket.Upgrader
+}
+
+// NewHandler returns a handler executing requests through svc, streaming
+// watches from bus and publishing to channels. A nil bus disables watches,
+// nil channels pub/sub.
+func NewHandler(log zerolog.Logger, svc *store.Service, bus *events.Bus, channels *pubsub.Broker) *Handler {
+	return &Handler{
+		log:      log.With().Str("component", "websocket").Logger(),
+		svc:      svc,
+		bus:      bus,
+		channels: channels,
+		upgrader: websocket.Upgrader{
+			// Any origin is accepted, matching the CORS policy of the API.
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error.
+		h.log.Debug().Err(err).Msg("failed to upgrade connection")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &session{
+		h:        h,
+		conn:     conn,
+		ctx:      ctx,
+		cancel:   cancel,
+		inFlight: make(chan struct{}, maxInFlight),
+		watches:  make(map[string]*events.Subscription),
+	}
+	s.run()
+}
+
+// session is a client connection.
+type session struct {
+	h        *Handler
+	conn     *websocket.Conn
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inFlight chan struct{}
+	wg       sync.WaitGroup
+
+	// writeMu serializes writes, the connection supports one writer.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	watches map[string]*events.Subscription
+}
+
+func (s *session) run() {
+	defer func() {
+		s.cancel()
+		s.mu.Lock()
+		for id, sub := range s.watches {
+			sub.Close()
+			delete(s.watches, id)
+		}
+		s.mu.Unlock()
+		s.wg.Wait()
+		_ = s.conn.Close()
+	}()
+
+	s.conn.SetReadLimit(maxMessageSize)
+	_ = s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	s.conn.SetPongHandler(func(string) error {
+		return s.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	s.wg.Add(1)
+	go s.ping()
+
+	for {
+		_, msg, err := s.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.h.log.Debug().Err(err).Msg("failed to read request")
+			}
+			return
+		}
+
+		var req Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			s.write(Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON})
+			continue
+		}
+
+		select {
+		case s.inFlight <- struct{}{}:
+		case <-s.ctx.Done():
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.inFlight
+				s.wg.Done()
+			}()
+			s.handle(req)
+		}()
+	}
+}
+
+// ping keeps the connection alive and detects dead clients.
+func (s *session) ping() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				s.cancel()
+				_ = s.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+func (s *session) handle(req Request) {
+	switch req.Op {
+	case OpGet:
+		value, err := s.h.svc.Get(s.ctx, req.Key)
+		if err != nil {
+			s.writeError(req.ID, err)
+			return
+		}
+		s.write(Response{ID: req.ID, Message: "key found", StatusCode: store.StatusSuccess, Data: &store.KeyValue{Key: req.Key, Value: string(value)}})
+	case OpSet:
+		s.set(req)
+	case OpDelete:
+		if err := s.h.svc.Delete(s.ctx, req.Key); err != nil {
+			s.writeError(req.ID, err)
+			return
+		}
+		s.write(Response{ID: req.ID, Message: "key deleted successfully", StatusCode: store.StatusSuccess})
+	case OpWatch:
+		s.watch(req)
+	case OpPublish:
+		s.publish(req)
+	case OpSubscribe:
+		s.subscribe(req)
+	case OpUnwatch:
+		s.unwatch(req.ID, "watch")
+	case OpUnsubscribe:
+		s.unwatch(req.ID, "subscription")
+	default:
+		s.write(Response{ID: req.ID, Message: "unknown op", StatusCode: store.StatusInvalidJSON})
+	}
+}
+
+// unwatch ends the watch or subscription, its kind, of id.
+func (s *session) unwatch(id, kind string) {
+	s.mu.Lock()
+	sub, ok := s.watches[id]
+	delete(s.watches, id)
+	s.mu.Unlock()
+	if !ok {
+		s.write(Response{ID: id, Message: kind + " not found", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	sub.Close()
+	s.write(Response{ID: id, Message: kind + " ended", StatusCode: store.StatusSuccess})
+}
+
+func (s *session) set(req Request) {
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			s.write(Response{ID: req.ID, Message: "invalid ttl, expected a positive duration such as 30s", StatusCode: store.StatusInvalidTTL})
+			return
+		}
+		ttl = d
+	}
+
+	var err error
+	if req.IfNotExists {
+		err = s.h.svc.Create(s.ctx, req.Key, []byte(req.Value), ttl)
+	} else {
+		err = s.h.svc.Set(s.ctx, req.Key, []byte(req.Value), ttl)
+	}
+	if err != nil {
+		s.writeError(req.ID, err)
+		return
+	}
+	s.write(Response{ID: req.ID, Message: "key set successfully", StatusCode: store.StatusSuccess})
+}
+
+// watch subscribes to the changes of req.Prefix. The subscription is made
+// before the watch is acknowledged, so no change applied after the
+// acknowledgement is missed.
+func (s *session) watch(req Request) {
+	if s.h.bus == nil {
+		s.write(Response{ID: req.ID, Message: "watch is not available", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if req.ID == "" {
+		s.write(Response{Message: "watch requires an id", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	req.Prefix = s.h.svc.NormalizeKey(req.Prefix)
+	if err := auth.Authorize(s.ctx, auth.Read, req.Prefix); err != nil {
+		s.writeError(req.ID, err)
+		return
+	}
+
+	s.stream(req.ID, "watch started", func() (*events.Subscription, error) {
+		return s.h.bus.Subscribe(req.Prefix), nil
+	}, func(e events.Event) any {
+		if store.ReservedKey(e.Key) {
+			return nil
+		}
+		return Event{ID: req.ID, Event: EventDetail{Type: e.Type, Key: e.Key, Value: string(e.Value), Time: e.Time}}
+	})
+}
+
+// publish publishes req.Value on req.Channel.
+func (s *session) publish(req Request) {
+	if s.h.channels == nil {
+		s.write(Response{ID: req.ID, Message: "pub/sub is not available", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if err := auth.Authorize(s.ctx, auth.Write, req.Channel); err != nil {
+		s.writeError(req.ID, err)
+		return
+	}
+	if err := s.h.channels.Publish(req.Channel, []byte(req.Value)); err != nil {
+		s.write(Response{ID: req.ID, Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	s.write(Response{ID: req.ID, Message: "message published", StatusCode: store.StatusSuccess})
+}
+
+// subscribe subscribes to the messages of req.Channel, a channel or a
+// pattern ending with *, like watch.
+func (s *session) subscribe(req Request) {
+	if s.h.channels == nil {
+		s.write(Response{ID: req.ID, Message: "pub/sub is not available", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if req.ID == "" {
+		s.write(Response{Message: "subscribe requires an id", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if err := pubsub.ValidChannel(req.Channel, true); err != nil {
+		s.write(Response{ID: req.ID, Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	if err := auth.Authorize(s.ctx, auth.Read, pubsub.Prefix(req.Channel)); err != nil {
+		s.writeError(req.ID, err)
+		return
+	}
+
+	s.stream(req.ID, "subscribed", func() (*events.Subscription, error) {
+		return s.h.channels.Subscribe(req.Channel)
+	}, func(e events.Event) any {
+		return ChannelMessage{ID: req.ID, Message: pubsub.Decode(e)}
+	})
+}
+
+// stream registers the subscription returned by subscribe under id and
+// acknowledges it with message, then forwards its events converted by
+// msg, nil skipping them, until it is closed.
+func (s *session) stream(id, message string, subscribe func() (*events.Subscription, error), msg func(events.Event) any) {
+	s.mu.Lock()
+	if _, ok := s.watches[id]; ok {
+		s.mu.Unlock()
+		s.write(Response{ID: id, Message: "watch id already in use", StatusCode: store.StatusInvalidValue})
+		return
+	}
+	sub, err := subscribe()
+	if err != nil {
+		s.mu.Unlock()
+		s.write(Response{ID: id, Message: err.Error(), StatusCode: store.StatusInvalidValue})
+		return
+	}
+	s.watches[id] = sub
+	s.mu.Unlock()
+
+	s.write(Response{ID: id, Message: message, StatusCode: store.StatusSuccess})
+
+	// Events are forwarded outside of the request slot, a watch lasts until
+	// it is unwatched.
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for e := range sub.Events() {
+			if m := msg(e); m != nil {
+				s.write(m)
+			}
+		}
+
+		if err := sub.Err(); err != nil {
+			s.mu.Lock()
+			delete(s.watches, id)
+			s.mu.Unlock()
+			s.write(Response{ID: id, Message: err.Error(), StatusCode: store.StatusWatchEnded})
+		}
+	}()
+}
+
+// write sends msg to the client. Failures end the session.
+func (s *session) write(msg any) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if err := s.conn.WriteJSON(msg); err != nil {
+		s.h.log.Debug().Err(err).Msg("failed to write message")
+		s.cancel()
+		_ = s.conn.Close()
+	}
+}
+
+// writeError replies with the error of a store operation.
+func (s *session) writeError(id string, err error) {
+	resp := Response{ID: id, Message: err.Error()}
+	var storageErr *store.StorageError
+	switch {
+	case errors.Is(err, store.ErrInvalidKey):
+		resp.Message, resp.StatusCode = "invalid key", store.StatusInvalidKey
+	case errors.Is(err, store.ErrKeyTooLong):
+		resp.StatusCode = store.StatusKeyTooLong
+	case errors.Is(err, store.ErrValueTooLarge):
+		resp.StatusCode = store.StatusValueTooLarge
+	case errors.Is(err, store.ErrRejected):
+		resp.StatusCode = store.StatusInvalidValue
+	case errors.Is(err, store.ErrKeyNotFound):
+		resp.Message, resp.StatusCode = "key not found", store.StatusKeyNotFound
+	case errors.Is(err, store.ErrKeyExists):
+		resp.Message, resp.StatusCode = "key already exists", store.StatusKeyExists
+	case errors.Is(err, auth.ErrForbidden):
+		resp.StatusCode = store.StatusForbidden
+	case errors.Is(err, store.ErrReadOnly):
+		resp.StatusCode = store.StatusMaintenance
+	case errors.Is(err, store.ErrMemoryLimit):
+		resp.StatusCode = store.StatusMemoryLimit
+	case errors.Is(err, context.Canceled):
+		resp.Message, resp.StatusCode = "request canceled", store.StatusCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		resp.Message, resp.StatusCode = "request timed out", store.StatusTimeout
+	case errors.Is(err, cluster.ErrNotLeader), errors.Is(err, cluster.ErrNoLeader):
+		resp.StatusCode = store.StatusNoLeader
+	case errors.As(err, &storageErr):
+		s.h.log.Error().Err(err).Msg("store operation failed")
+		resp.Message, resp.StatusCode = "failed to "+storageErr.Op+" key", store.StatusStorageError
+	default:
+		s.h.log.Error().Err(err).Msg("store operation failed")
+		resp.Message, resp.StatusCode = "storage error", store.StatusStorageError
+	}
+	s.write(resp)
+}
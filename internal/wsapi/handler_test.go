@@ -0,0 +1,250 @@
+package wsapi
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/events"
+	"codesignal/internal/pubsub"
+	"codesignal/internal/repository"
+	"codesignal/internal/store"
+)
+
+// message is a response or an event received by the client.
+type message struct {
+	Response
+	Event          *EventDetail    `json:"event"`
+	ChannelMessage *pubsub.Message `json:"channel_message"`
+}
+
+func dial(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	bus := events.NewBus(16)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(NewHandler(zerolog.Nop(), store.NewService(zerolog.Nop(), repo, store.Opts{MaxKeyLength: 8}), bus, pubsub.NewBroker(16)))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+func roundTrip(t *testing.T, conn *websocket.Conn, req Request) message {
+	t.Helper()
+
+	require.NoError(t, conn.WriteJSON(req))
+	return read(t, conn)
+}
+
+func read(t *testing.T, conn *websocket.Conn) message {
+	t.Helper()
+
+	var msg message
+	require.NoError(t, conn.ReadJSON(&msg))
+	return msg
+}
+
+func TestHandler(t *testing.T) {
+	conn := dial(t)
+
+	tests := []struct {
+		name string
+		req  Request
+		want Response
+	}{
+		{
+			name: "get missing key",
+			req:  Request{ID: "1", Op: OpGet, Key: "a"},
+			want: Response{ID: "1", Message: "key not found", StatusCode: store.StatusKeyNotFound},
+		},
+		{
+			name: "set",
+			req:  Request{ID: "2", Op: OpSet, Key: "a", Value: "1"},
+			want: Response{ID: "2", Message: "key set successfully", StatusCode: store.StatusSuccess},
+		},
+		{
+			name: "set overwrites",
+			req:  Request{ID: "3", Op: OpSet, Key: "a", Value: "2", TTL: "1h"},
+			want: Response{ID: "3", Message: "key set successfully", StatusCode: store.StatusSuccess},
+		},
+		{
+			name: "get",
+			req:  Request{ID: "4", Op: OpGet, Key: "a"},
+			want: Response{ID: "4", Message: "key found", StatusCode: store.StatusSuccess, Data: &store.KeyValue{Key: "a", Value: "2"}},
+		},
+		{
+			name: "set if not exists",
+			req:  Request{ID: "5", Op: OpSet, Key: "a", Value: "3", IfNotExists: true},
+			want: Response{ID: "5", Message: "key already exists", StatusCode: store.StatusKeyExists},
+		},
+		{
+			name: "set invalid ttl",
+			req:  Request{ID: "6", Op: OpSet, Key: "b", Value: "1", TTL: "-1s"},
+			want: Response{ID: "6", Message: "invalid ttl, expected a positive duration such as 30s", StatusCode: store.StatusInvalidTTL},
+		},
+		{
+			name: "set key too long",
+			req:  Request{ID: "7", Op: OpSet, Key: "too-long-key", Value: "1"},
+			want: Response{ID: "7", Message: "err: key length exceeds maximum allowed length, max key length: 8", StatusCode: store.StatusKeyTooLong},
+		},
+		{
+			name: "get empty key",
+			req:  Request{ID: "8", Op: OpGet},
+			want: Response{ID: "8", Message: "invalid key", StatusCode: store.StatusInvalidKey},
+		},
+		{
+			name: "delete",
+			req:  Request{ID: "9", Op: OpDelete, Key: "a"},
+			want: Response{ID: "9", Message: "key deleted successfully", StatusCode: store.StatusSuccess},
+		},
+		{
+			name: "delete missing key",
+			req:  Request{ID: "10", Op: OpDelete, Key: "a"},
+			want: Response{ID: "10", Message: "key not found", StatusCode: store.StatusKeyNotFound},
+		},
+		{
+			name: "unknown op",
+			req:  Request{ID: "11", Op: "increment"},
+			want: Response{ID: "11", Message: "unknown op", StatusCode: store.StatusInvalidJSON},
+		},
+		{
+			name: "unwatch unknown watch",
+			req:  Request{ID: "12", Op: OpUnwatch},
+			want: Response{ID: "12", Message: "watch not found", StatusCode: store.StatusInvalidValue},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, roundTrip(t, conn, tt.req).Response)
+		})
+	}
+
+	t.Run("invalid json", func(t *testing.T) {
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("{")))
+		assert.Equal(t, Response{Message: "invalid request body", StatusCode: store.StatusInvalidJSON}, read(t, conn).Response)
+	})
+}
+
+func TestHandlerPipelined(t *testing.T) {
+	conn := dial(t)
+
+	const n = 50
+	for i := range n {
+		require.NoError(t, conn.WriteJSON(Request{ID: strconv.Itoa(i), Op: OpSet, Key: "k" + strconv.Itoa(i%5), Value: "v"}))
+	}
+
+	seen := make(map[string]bool, n)
+	for range n {
+		msg := read(t, conn)
+		assert.Equal(t, store.StatusSuccess, msg.StatusCode)
+		seen[msg.ID] = true
+	}
+	assert.Len(t, seen, n, "every request is answered once")
+}
+
+func TestHandlerWatch(t *testing.T) {
+	conn := dial(t)
+
+	assert.Equal(t, Response{ID: "w", Message: "watch started", StatusCode: store.StatusSuccess},
+		roundTrip(t, conn, Request{ID: "w", Op: OpWatch, Prefix: "user:"}).Response)
+	assert.Equal(t, Response{ID: "w", Message: "watch id already in use", StatusCode: store.StatusInvalidValue},
+		roundTrip(t, conn, Request{ID: "w", Op: OpWatch}).Response)
+
+	// Events and responses are written concurrently, collect events while
+	// waiting for each response.
+	var got []EventDetail
+	next := func() message {
+		for {
+			msg := read(t, conn)
+			if msg.Event == nil {
+				return msg
+			}
+			assert.Equal(t, "w", msg.ID)
+			got = append(got, *msg.Event)
+		}
+	}
+	for _, req := range []Request{
+		{ID: "1", Op: OpSet, Key: "order:1", Value: "x"},
+		{ID: "2", Op: OpSet, Key: "user:1", Value: "alice"},
+		{ID: "3", Op: OpDelete, Key: "user:1"},
+	} {
+		require.NoError(t, conn.WriteJSON(req))
+		assert.Equal(t, store.StatusSuccess, next().StatusCode)
+	}
+	for len(got) < 2 {
+		msg := read(t, conn)
+		require.NotNil(t, msg.Event)
+		got = append(got, *msg.Event)
+	}
+	require.Len(t, got, 2, "only watched keys are streamed")
+	assert.Equal(t, events.TypeSet, got[0].Type)
+	assert.Equal(t, "user:1", got[0].Key)
+	assert.Equal(t, "alice", got[0].Value)
+	assert.Equal(t, events.TypeDelete, got[1].Type)
+
+	assert.Equal(t, Response{ID: "w", Message: "watch ended", StatusCode: store.StatusSuccess},
+		roundTrip(t, conn, Request{ID: "w", Op: OpUnwatch}).Response)
+	msg := roundTrip(t, conn, Request{ID: "4", Op: OpSet, Key: "user:2", Value: "bob"})
+	assert.Nil(t, msg.Event, "no events after unwatch")
+	assert.Equal(t, "4", msg.ID)
+}
+
+func TestHandlerSubscribe(t *testing.T) {
+	conn := dial(t)
+
+	assert.Equal(t, Response{ID: "s", Message: "subscribed", StatusCode: store.StatusSuccess},
+		roundTrip(t, conn, Request{ID: "s", Op: OpSubscribe, Channel: "news.*"}).Response)
+	assert.Equal(t, Response{ID: "bad", Message: pubsub.ErrInvalidChannel.Error(), StatusCode: store.StatusInvalidValue},
+		roundTrip(t, conn, Request{ID: "bad", Op: OpSubscribe, Channel: "a*b"}).Response)
+
+	var got []pubsub.Message
+	for _, req := range []Request{
+		{ID: "1", Op: OpPublish, Channel: "weather", Value: "rain"},
+		{ID: "2", Op: OpPublish, Channel: "news.sports", Value: "goal"},
+	} {
+		require.NoError(t, conn.WriteJSON(req))
+		for {
+			msg := read(t, conn)
+			if msg.ChannelMessage == nil {
+				assert.Equal(t, Response{ID: req.ID, Message: "message published", StatusCode: store.StatusSuccess}, msg.Response)
+				break
+			}
+			assert.Equal(t, "s", msg.ID)
+			got = append(got, *msg.ChannelMessage)
+		}
+	}
+	for len(got) < 1 {
+		msg := read(t, conn)
+		require.NotNil(t, msg.ChannelMessage)
+		got = append(got, *msg.ChannelMessage)
+	}
+	require.Len(t, got, 1, "only subscribed channels are streamed")
+	assert.Equal(t, "news.sports", got[0].Channel)
+	assert.Equal(t, "goal", got[0].Message)
+
+	assert.Equal(t, Response{ID: "s", Message: "subscription ended", StatusCode: store.StatusSuccess},
+		roundTrip(t, conn, Request{ID: "s", Op: OpUnsubscribe}).Response)
+	assert.Equal(t, Response{ID: "s", Message: "subscription not found", StatusCode: store.StatusInvalidValue},
+		roundTrip(t, conn, Request{ID: "s", Op: OpUnsubscribe}).Response)
+}
+
+func TestEventJSON(t *testing.T) {
+	b, err := json.Marshal(Event{ID: "w", Event: EventDetail{Type: events.TypeSet, Key: "k", Value: "v", Time: time.Unix(0, 0).UTC()}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"w","event":{"type":"set","key":"k","value":"v","time":"1970-01-01T00:00:00Z"}}`, string(b))
+}
@@ -7,7 +7,7 @@
 # Alternatively you can use the terminal window below, to run any commands, like:
 # go mod download
 # go test ./...
-# go run cmd/store/main.go
+# go run ./cmd/store
 
 echo "==> Running the tests..."
 go mod download 
@@ -15,4 +15,4 @@ go test ./...
 
 
 echo "==> Running the server..."
-go run cmd/store/main.go
\ No newline at end of file
+go run ./cmd/store
\ No newline at end of file
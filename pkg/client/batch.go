@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultBatchConcurrency is the number of operations of a batch executed
+// concurrently when Opts.BatchConcurrency is zero.
+const DefaultBatchConcurrency = 8
+
+// OpType is the type of a batch operation.
+type OpType string
+
+// Batch operation types.
+const (
+	OpSet    OpType = "set"
+	OpGet    OpType = "get"
+	OpDelete OpType = "delete"
+)
+
+// Op is an operation of a batch.
+type Op struct {
+	Type  OpType
+	Key   string
+	Value string
+	// TTL expires a set key after that long when positive.
+	TTL time.Duration
+}
+
+// Result is the outcome of a batch operation.
+type Result struct {
+	Key string
+	// Value is the value read by a get.
+	Value string
+	Err   error
+}
+
+// Batch executes ops concurrently and returns their results in the order
+// of ops. Operations are independent, a failed operation doesn't stop the
+// others, and operations on the same key may run in any order.
+func (c *Client) Batch(ctx context.Context, ops []Op) []Result {
+	results := make([]Result, len(ops))
+	sem := make(chan struct{}, c.opts.BatchConcurrency)
+
+	var wg sync.WaitGroup
+	for i, op := range ops {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = c.exec(ctx, op)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (c *Client) exec(ctx context.Context, op Op) Result {
+	result := Result{Key: op.Key}
+	switch op.Type {
+	case OpSet:
+		result.Err = c.Set(ctx, op.Key, op.Value, op.TTL)
+	case OpGet:
+		result.Value, result.Err = c.Get(ctx, op.Key)
+	case OpDelete:
+		result.Err = c.Delete(ctx, op.Key)
+	default:
+		result.Err = fmt.Errorf("unknown op %q", op.Type)
+	}
+	return result
+}
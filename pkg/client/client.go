@@ -0,0 +1,343 @@
+// Package client is the Go client of the key-value store HTTP API.
+//
+// A Client sets, gets, deletes, increments and lists keys, runs batches of
+// operations concurrently and watches key prefixes over WebSocket. Requests
+// failing because the cluster is temporarily unavailable are retried with
+// exponential backoff, and API errors are returned as *Error, which matches
+// the sentinel errors of this package with errors.Is:
+//
+//	c, err := client.New("http://localhost:8081", client.Opts{})
+//	if err != nil {
+//		return err
+//	}
+//	value, err := c.Get(ctx, "hello")
+//	if errors.Is(err, client.ErrKeyNotFound) {
+//		// ...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults applied to zero Opts fields.
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+	// maxRetryBackoff caps the backoff between retries.
+	maxRetryBackoff = 2 * time.Second
+)
+
+// Opts configures a Client.
+type Opts struct {
+	// HTTPClient sends the requests, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Header is added to every request, such as credentials or the
+	// X-Write-Quorum and X-Read-Quorum headers of a sharded cluster.
+	Header http.Header
+	// MaxRetries is the number of retries of a failed request,
+	// DefaultMaxRetries when zero. Negative disables retries.
+	MaxRetries int
+	// RetryBackoff is the wait before the first retry, doubled on each
+	// following one. DefaultRetryBackoff when zero.
+	RetryBackoff time.Duration
+	// BatchConcurrency is the number of operations of a batch executed
+	// concurrently, DefaultBatchConcurrency when zero.
+	BatchConcurrency int
+}
+
+// Client calls the key-value store HTTP API. It is safe for concurrent use.
+type Client struct {
+	base *url.URL
+	opts Opts
+}
+
+// New returns a client of the API served at baseURL, such as
+// "http://localhost:8081".
+func New(baseURL string, opts Opts) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid base url %q, expected http(s)://host[:port]", baseURL)
+	}
+
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	switch {
+	case opts.MaxRetries == 0:
+		opts.MaxRetries = DefaultMaxRetries
+	case opts.MaxRetries < 0:
+		opts.MaxRetries = 0
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = DefaultRetryBackoff
+	}
+	if opts.BatchConcurrency <= 0 {
+		opts.BatchConcurrency = DefaultBatchConcurrency
+	}
+	return &Client{base: base, opts: opts}, nil
+}
+
+// keyValue is the key-value pair of requests and responses.
+type keyValue struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// restoreRequest is the body of a restore request.
+type restoreRequest struct {
+	Payload string `json:"payload"`
+	TTL     string `json:"ttl,omitempty"`
+	Replace bool   `json:"replace,omitempty"`
+}
+
+// response is the envelope of every API response.
+type response struct {
+	Message    string          `json:"message"`
+	StatusCode StatusCode      `json:"status_code"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	Next       string          `json:"next,omitempty"`
+}
+
+// KeyValue is a listed key.
+type KeyValue struct {
+	Key   string
+	Value string
+	// TTL is the remaining time-to-live, zero if the key never expires.
+	TTL time.Duration
+}
+
+// Page is a page of listed keys.
+type Page struct {
+	Items []KeyValue
+	// Next is the after argument of the next page, empty on the last page.
+	Next string
+}
+
+// Set creates key with value. A positive ttl expires the key after that
+// long. Setting an existing key fails with ErrKeyExists.
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	kv := keyValue{Key: key, Value: value}
+	if ttl > 0 {
+		kv.TTL = ttl.String()
+	}
+	_, err := c.do(ctx, http.MethodPost, "/v1/key", nil, kv)
+	return err
+}
+
+// Get returns the value of key, or ErrKeyNotFound.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	resp, err := c.do(ctx, http.MethodGet, encodedKeyPath(key), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	var kv keyValue
+	if err := json.Unmarshal(resp.Data, &kv); err != nil {
+		return "", fmt.Errorf("get %q: invalid response data: %w", key, err)
+	}
+	return kv.Value, nil
+}
+
+// List returns a page of up to limit keys starting with prefix after the key
+// after, in lexical order. A limit of zero uses the server default.
+func (c *Client) List(ctx context.Context, prefix, after string, limit int) (*Page, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, "/v1/keys", query, nil)
+	if err != nil {
+		return nil, err
+	}
+	var kvs []keyValue
+	if err := json.Unmarshal(resp.Data, &kvs); err != nil {
+		return nil, fmt.Errorf("list: invalid response data: %w", err)
+	}
+
+	page := &Page{Items: make([]KeyValue, 0, len(kvs)), Next: resp.Next}
+	for _, kv := range kvs {
+		item := KeyValue{Key: kv.Key, Value: kv.Value}
+		if kv.TTL != "" {
+			if item.TTL, err = time.ParseDuration(kv.TTL); err != nil {
+				return nil, fmt.Errorf("list: invalid ttl of %q: %w", kv.Key, err)
+			}
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
+
+// Delete deletes key, or fails with ErrKeyNotFound.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodDelete, encodedKeyPath(key), nil, nil)
+	return err
+}
+
+// Undelete restores a recently deleted key, or fails with ErrKeyNotFound.
+func (c *Client) Undelete(ctx context.Context, key string) error {
+	_, err := c.do(ctx, http.MethodPost, keyPath(key)+"/undelete", nil, nil)
+	return err
+}
+
+// Dump returns the value of key serialized with a checksum, in the format
+// of the Redis DUMP command, for Restore on another instance.
+func (c *Client) Dump(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, keyPath(key)+"/dump", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var kv keyValue
+	if err := json.Unmarshal(resp.Data, &kv); err != nil {
+		return nil, fmt.Errorf("dump %q: invalid response data: %w", key, err)
+	}
+	return base64.StdEncoding.DecodeString(kv.Value)
+}
+
+// Restore creates key from a payload returned by Dump or by Redis. A
+// positive ttl expires the key after that long. Unless replace is set,
+// restoring an existing key fails with ErrKeyExists.
+func (c *Client) Restore(ctx context.Context, key string, payload []byte, ttl time.Duration, replace bool) error {
+	req := restoreRequest{Payload: base64.StdEncoding.EncodeToString(payload), Replace: replace}
+	if ttl > 0 {
+		req.TTL = ttl.String()
+	}
+	_, err := c.do(ctx, http.MethodPost, keyPath(key)+"/restore", nil, req)
+	return err
+}
+
+// Increment adds delta to the integer value of key, created at 0 if
+// missing, and returns the new value.
+func (c *Client) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	resp, err := c.do(ctx, http.MethodPost, keyPath(key)+"/increment", nil, map[string]int64{"delta": delta})
+	if err != nil {
+		return 0, err
+	}
+	var kv keyValue
+	if err := json.Unmarshal(resp.Data, &kv); err != nil {
+		return 0, fmt.Errorf("increment %q: invalid response data: %w", key, err)
+	}
+	return strconv.ParseInt(kv.Value, 10, 64)
+}
+
+func keyPath(key string) string {
+	return "/v1/key/" + url.PathEscape(key)
+}
+
+// encodedKeyPath addresses the keys holding slashes, which a path can't,
+// encoded in base64url, for the routes serving them.
+func encodedKeyPath(key string) string {
+	if strings.Contains(key, "/") {
+		return "/v1/key/b64/" + base64.RawURLEncoding.EncodeToString([]byte(key))
+	}
+	return keyPath(key)
+}
+
+// do sends a request, retrying it while it fails with a retryable error.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body any) (*response, error) {
+	var payload []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		payload = b
+	}
+
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, query, payload)
+		if err == nil || attempt >= c.opts.MaxRetries || !retryable(method, err) {
+			return resp, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
+
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, payload []byte) (*response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := c.newRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	httpResp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, &transportError{err: err}
+	}
+	defer httpResp.Body.Close()
+
+	var resp response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return nil, &Error{HTTPStatus: httpResp.StatusCode, Message: "invalid response: " + err.Error()}
+	}
+	if httpResp.StatusCode >= http.StatusBadRequest || resp.StatusCode != StatusSuccess {
+		return nil, &Error{HTTPStatus: httpResp.StatusCode, StatusCode: resp.StatusCode, Message: resp.Message}
+	}
+	return &resp, nil
+}
+
+// url returns the URL of the escaped API path with query.
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.base
+	u.RawPath = strings.TrimSuffix(c.base.EscapedPath(), "/") + path
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// transportError is a request that got no response.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string { return e.err.Error() }
+func (e *transportError) Unwrap() error { return e.err }
+
+// retryable reports whether a failed request can be sent again. Requests
+// rejected because no leader or shard is available were not applied and are
+// always retried, requests without a response only when idempotent.
+func retryable(method string, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == StatusNoLeader || apiErr.StatusCode == StatusShardUnavailable
+	}
+	var transportErr *transportError
+	return errors.As(err, &transportErr) && (method == http.MethodGet || method == http.MethodDelete)
+}
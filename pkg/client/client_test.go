@@ -0,0 +1,264 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+	"codesignal/internal/store"
+)
+
+func newClient(t *testing.T) *Client {
+	t.Helper()
+
+	bus := events.NewBus(16)
+	repo, err := repository.NewKeyValueStore(zerolog.Nop(), repository.Opts{Events: bus, TombstoneRetention: time.Minute})
+	require.NoError(t, err)
+
+	srv := httptest.NewServer(router.New(zerolog.Nop(), repo, &config.Config{MaxKeyLength: 8}, router.Opts{Events: bus}))
+	t.Cleanup(srv.Close)
+
+	c, err := New(srv.URL, Opts{})
+	require.NoError(t, err)
+	return c
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+
+	require.NoError(t, c.Set(ctx, "a?b%", "1", time.Hour))
+	value, err := c.Get(ctx, "a?b%")
+	require.NoError(t, err)
+	assert.Equal(t, "1", value)
+
+	n, err := c.Increment(ctx, "a?b%", 41)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	require.NoError(t, c.Delete(ctx, "a?b%"))
+	require.NoError(t, c.Undelete(ctx, "a?b%"))
+	value, err = c.Get(ctx, "a?b%")
+	require.NoError(t, err)
+	assert.Equal(t, "42", value)
+
+	payload, err := c.Dump(ctx, "a?b%")
+	require.NoError(t, err)
+	require.NoError(t, c.Restore(ctx, "copy", payload, time.Hour, false))
+	value, err = c.Get(ctx, "copy")
+	require.NoError(t, err)
+	assert.Equal(t, "42", value)
+	assert.ErrorIs(t, c.Restore(ctx, "copy", payload, 0, false), ErrKeyExists)
+	require.NoError(t, c.Restore(ctx, "copy", payload, 0, true))
+
+	// Keys holding slashes are addressed encoded.
+	require.NoError(t, c.Set(ctx, "a/b", "3", 0))
+	value, err = c.Get(ctx, "a/b")
+	require.NoError(t, err)
+	assert.Equal(t, "3", value)
+	require.NoError(t, c.Delete(ctx, "a/b"))
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "get missing key", err: func() error { _, err := c.Get(ctx, "missing"); return err }(), want: ErrKeyNotFound},
+		{name: "delete missing key", err: c.Delete(ctx, "missing"), want: ErrKeyNotFound},
+		{name: "set existing key", err: c.Set(ctx, "a?b%", "2", 0), want: ErrKeyExists},
+		{name: "key too long", err: c.Set(ctx, "too-long-key", "2", 0), want: ErrKeyTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, tt.err, tt.want)
+			var apiErr *Error
+			require.ErrorAs(t, tt.err, &apiErr)
+			assert.GreaterOrEqual(t, apiErr.HTTPStatus, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestClientList(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	require.NoError(t, c.Set(ctx, "user:1", "alice", time.Hour))
+	require.NoError(t, c.Set(ctx, "user:2", "bob", 0))
+	require.NoError(t, c.Set(ctx, "order:1", "x", 0))
+
+	page, err := c.List(ctx, "user:", "", 1)
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "user:1", page.Items[0].Key)
+	assert.InDelta(t, time.Hour, page.Items[0].TTL, float64(time.Second))
+	assert.Equal(t, "user:1", page.Next)
+
+	page, err = c.List(ctx, "user:", page.Next, 0)
+	require.NoError(t, err)
+	assert.Equal(t, &Page{Items: []KeyValue{{Key: "user:2", Value: "bob"}}}, page)
+
+	_, err = c.List(ctx, "", "", 5000)
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, StatusInvalidValue, apiErr.StatusCode)
+}
+
+func TestClientRetries(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"message":"no cluster leader","status_code":1012}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"message":"key found","status_code":1000,"data":{"key":"a","value":"1"}}`))
+	}))
+	defer srv.Close()
+
+	t.Run("retries unavailable cluster", func(t *testing.T) {
+		c, err := New(srv.URL, Opts{RetryBackoff: time.Millisecond})
+		require.NoError(t, err)
+
+		value, err := c.Get(context.Background(), "a")
+		require.NoError(t, err)
+		assert.Equal(t, "1", value)
+		assert.Equal(t, int32(3), attempts.Load())
+	})
+
+	t.Run("gives up after max retries", func(t *testing.T) {
+		attempts.Store(0)
+		c, err := New(srv.URL, Opts{MaxRetries: 1, RetryBackoff: time.Millisecond})
+		require.NoError(t, err)
+
+		_, err = c.Get(context.Background(), "a")
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int32(2), attempts.Load())
+	})
+
+	t.Run("retries disabled", func(t *testing.T) {
+		attempts.Store(0)
+		c, err := New(srv.URL, Opts{MaxRetries: -1})
+		require.NoError(t, err)
+
+		_, err = c.Get(context.Background(), "a")
+		assert.ErrorIs(t, err, ErrUnavailable)
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+}
+
+func TestClientBatch(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+	require.NoError(t, c.Set(ctx, "b", "2", 0))
+
+	results := c.Batch(ctx, []Op{
+		{Type: OpSet, Key: "a", Value: "1"},
+		{Type: OpGet, Key: "b"},
+		{Type: OpDelete, Key: "missing"},
+		{Type: "rename", Key: "b"},
+	})
+	require.Len(t, results, 4)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, Result{Key: "b", Value: "2"}, results[1])
+	assert.ErrorIs(t, results[2].Err, ErrKeyNotFound)
+	assert.EqualError(t, results[3].Err, `unknown op "rename"`)
+}
+
+func TestClientWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c := newClient(t)
+
+	w, err := c.Watch(ctx, "user:")
+	require.NoError(t, err)
+
+	require.NoError(t, c.Set(ctx, "order:1", "x", 0))
+	require.NoError(t, c.Set(ctx, "user:1", "alice", 0))
+	require.NoError(t, c.Delete(ctx, "user:1"))
+
+	e := <-w.Events()
+	assert.Equal(t, EventSet, e.Type)
+	assert.Equal(t, "user:1", e.Key)
+	assert.Equal(t, "alice", e.Value)
+	e = <-w.Events()
+	assert.Equal(t, EventDelete, e.Type)
+
+	require.NoError(t, w.Close())
+	for range w.Events() {
+	}
+	assert.NoError(t, w.Err())
+}
+
+func TestNew(t *testing.T) {
+	for _, u := range []string{"localhost:8081", "ftp://localhost", "http://", "://"} {
+		_, err := New(u, Opts{})
+		assert.Error(t, err, u)
+	}
+}
+
+// TestStatusCodes keeps the status codes in sync with the API.
+func TestStatusCodes(t *testing.T) {
+	codes := map[StatusCode]store.StatusCode{
+		StatusSuccess:          store.StatusSuccess,
+		StatusKeyNotFound:      store.StatusKeyNotFound,
+		StatusKeyExists:        store.StatusKeyExists,
+		StatusInvalidKey:       store.StatusInvalidKey,
+		StatusInvalidValue:     store.StatusInvalidValue,
+		StatusStorageError:     store.StatusStorageError,
+		StatusInvalidJSON:      store.StatusInvalidJSON,
+		StatusKeyTooLong:       store.StatusKeyTooLong,
+		StatusValueTooLarge:    store.StatusValueTooLarge,
+		StatusCanceled:         store.StatusCanceled,
+		StatusTimeout:          store.StatusTimeout,
+		StatusInvalidTTL:       store.StatusInvalidTTL,
+		StatusNoLeader:         store.StatusNoLeader,
+		StatusShardUnavailable: store.StatusShardUnavailable,
+		StatusInvalidQuorum:    store.StatusInvalidQuorum,
+		StatusQuorumNotMet:     store.StatusQuorumNotMet,
+		StatusWatchEnded:       store.StatusWatchEnded,
+	}
+	for got, want := range codes {
+		assert.Equal(t, int(want), int(got))
+	}
+	assert.False(t, errors.Is(&Error{StatusCode: StatusStorageError}, ErrKeyNotFound))
+}
+
+func TestClientStream(t *testing.T) {
+	ctx := context.Background()
+	c := newClient(t)
+
+	value := strings.Repeat("0123456789", 1000)
+	require.NoError(t, c.SetStream(ctx, "big", strings.NewReader(value), int64(len(value)), time.Hour))
+	got, err := c.Get(ctx, "big")
+	require.NoError(t, err)
+	assert.Equal(t, value, got)
+
+	// Without a size, the value is sent in chunks.
+	require.NoError(t, c.SetStream(ctx, "big", strings.NewReader("chunked"), -1, 0))
+	r, size, err := c.GetStream(ctx, "big")
+	require.NoError(t, err)
+	b, err := io.ReadAll(r)
+	require.NoError(t, r.Close())
+	require.NoError(t, err)
+	assert.Equal(t, "chunked", string(b))
+	assert.Equal(t, int64(7), size)
+
+	_, _, err = c.GetStream(ctx, "missing")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.ErrorIs(t, c.SetStream(ctx, "a/b", strings.NewReader("1"), 1, 0), ErrInvalidKey)
+	assert.ErrorIs(t, c.SetStream(ctx, "too-long-key", strings.NewReader("1"), 1, 0), ErrKeyTooLong)
+}
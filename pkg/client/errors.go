@@ -0,0 +1,72 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+)
+
+// StatusCode is the application status code of an API response.
+type StatusCode int
+
+// Status codes of the API.
+const (
+	StatusSuccess          StatusCode = 1000
+	StatusKeyNotFound      StatusCode = 1001
+	StatusKeyExists        StatusCode = 1002
+	StatusInvalidKey       StatusCode = 1003
+	StatusInvalidValue     StatusCode = 1004
+	StatusStorageError     StatusCode = 1005
+	StatusInvalidJSON      StatusCode = 1006
+	StatusKeyTooLong       StatusCode = 1007
+	StatusValueTooLarge    StatusCode = 1008
+	StatusCanceled         StatusCode = 1009
+	StatusTimeout          StatusCode = 1010
+	StatusInvalidTTL       StatusCode = 1011
+	StatusNoLeader         StatusCode = 1012
+	StatusShardUnavailable StatusCode = 1013
+	StatusInvalidQuorum    StatusCode = 1014
+	StatusQuorumNotMet     StatusCode = 1015
+	StatusWatchEnded       StatusCode = 1016
+)
+
+// Sentinel errors matched by *Error with errors.Is.
+var (
+	ErrKeyNotFound   = errors.New("key not found")
+	ErrKeyExists     = errors.New("key already exists")
+	ErrInvalidKey    = errors.New("invalid key")
+	ErrKeyTooLong    = errors.New("key too long")
+	ErrValueTooLarge = errors.New("value too large")
+	ErrUnavailable   = errors.New("cluster unavailable")
+)
+
+// Error is an error reported by the API.
+type Error struct {
+	// HTTPStatus is the status of the HTTP response, zero over WebSocket.
+	HTTPStatus int
+	StatusCode StatusCode
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (status code %d)", e.Message, e.StatusCode)
+}
+
+// Is matches the sentinel error of the status code.
+func (e *Error) Is(target error) bool {
+	switch e.StatusCode {
+	case StatusKeyNotFound:
+		return target == ErrKeyNotFound
+	case StatusKeyExists:
+		return target == ErrKeyExists
+	case StatusInvalidKey:
+		return target == ErrInvalidKey
+	case StatusKeyTooLong:
+		return target == ErrKeyTooLong
+	case StatusValueTooLarge:
+		return target == ErrValueTooLarge
+	case StatusNoLeader, StatusShardUnavailable, StatusQuorumNotMet:
+		return target == ErrUnavailable
+	default:
+		return false
+	}
+}
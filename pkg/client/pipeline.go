@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Operations of the binary protocol.
+const (
+	binaryGet    = 1
+	binarySet    = 2
+	binaryDelete = 3
+)
+
+// maxBinaryReply caps the size of the replies of the binary protocol.
+const maxBinaryReply = 64 << 20
+
+// Pipeline is a connection to the binary protocol listener of a server,
+// enabled with BINARY_ENABLED. It sends the operations of a batch without
+// waiting for their replies, so a batch costs about a round trip whatever
+// its size. It is safe for concurrent use, batches being sent one at a
+// time.
+type Pipeline struct {
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+	err  error
+}
+
+// DialPipeline connects to the binary protocol listener at addr, such as
+// "localhost:7070".
+func DialPipeline(ctx context.Context, addr string) (*Pipeline, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// Do executes ops in order and returns their results in the order of ops.
+// A failed operation doesn't stop the others. The error reports a broken
+// connection, after which the pipeline is unusable.
+func (p *Pipeline) Do(ctx context.Context, ops []Op) ([]Result, error) {
+	var frames []byte
+	for _, op := range ops {
+		var err error
+		if frames, err = appendBinaryRequest(frames, op); err != nil {
+			return nil, err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := p.conn.SetDeadline(deadline); err != nil {
+		return nil, p.fail(err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = p.conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	// The requests are written while the replies are read, so neither side
+	// blocks on a full buffer.
+	written := make(chan error, 1)
+	go func() {
+		_, err := p.conn.Write(frames)
+		written <- err
+	}()
+	results := make([]Result, len(ops))
+	var err error
+	for i, op := range ops {
+		results[i] = Result{Key: op.Key}
+		var code StatusCode
+		var payload []byte
+		if code, payload, err = p.readReply(); err != nil {
+			break
+		}
+		switch {
+		case code != StatusSuccess:
+			results[i].Err = &Error{StatusCode: code, Message: string(payload)}
+		case op.Type == OpGet:
+			results[i].Value = string(payload)
+		}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		// Closing the connection unblocks the writer.
+		err = p.fail(err)
+		<-written
+		return nil, err
+	}
+	if err := <-written; err != nil {
+		return nil, p.fail(err)
+	}
+	return results, nil
+}
+
+// Close closes the connection.
+func (p *Pipeline) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = net.ErrClosed
+	}
+	return p.conn.Close()
+}
+
+// fail breaks the pipeline with err, closing its connection.
+func (p *Pipeline) fail(err error) error {
+	p.err = fmt.Errorf("pipeline broken: %w", err)
+	_ = p.conn.Close()
+	return err
+}
+
+// readReply reads the status code and the payload of the next reply.
+func (p *Pipeline) readReply() (StatusCode, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(p.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size < 2 || size > maxBinaryReply {
+		return 0, nil, fmt.Errorf("invalid reply of %d bytes", size)
+	}
+	reply := make([]byte, size)
+	if _, err := io.ReadFull(p.r, reply); err != nil {
+		return 0, nil, err
+	}
+	return StatusCode(binary.BigEndian.Uint16(reply)), reply[2:], nil
+}
+
+// appendBinaryRequest appends the frame of op to b.
+func appendBinaryRequest(b []byte, op Op) ([]byte, error) {
+	if len(op.Key) > 1<<16-1 {
+		return b, errors.New("key too long for the binary protocol")
+	}
+	var code byte
+	var args []byte
+	switch op.Type {
+	case OpGet:
+		code = binaryGet
+	case OpSet:
+		code = binarySet
+		// The ttl is rounded up to the millisecond, zero never expiring.
+		ttl := (max(op.TTL, 0) + time.Millisecond - 1) / time.Millisecond
+		args = binary.BigEndian.AppendUint64(nil, uint64(ttl))
+		args = append(args, op.Value...)
+	case OpDelete:
+		code = binaryDelete
+	default:
+		return b, fmt.Errorf("unknown op %q", op.Type)
+	}
+	b = binary.BigEndian.AppendUint32(b, uint32(3+len(op.Key)+len(args)))
+	b = append(b, code)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(op.Key)))
+	b = append(b, op.Key...)
+	return append(b, args...), nil
+}
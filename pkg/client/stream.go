@@ -0,0 +1,96 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Large values are transferred raw with the /v1/key/:key/value routes,
+// streamed from and to the caller without being buffered by the client,
+// the server buffering them whole.
+// Streams aren't retried, their body being consumed by the first attempt,
+// and can't address the keys holding slashes.
+
+// SetStream sets key to the size bytes read from r, replacing its value,
+// or to the bytes read until io.EOF, sent in chunks, when size is
+// negative. A positive ttl expires the key after that long.
+func (c *Client) SetStream(ctx context.Context, key string, r io.Reader, size int64, ttl time.Duration) error {
+	if strings.Contains(key, "/") {
+		return fmt.Errorf("%w: keys holding slashes can't be streamed", ErrInvalidKey)
+	}
+	var query url.Values
+	if ttl > 0 {
+		query = url.Values{"ttl": {ttl.String()}}
+	}
+	if size >= 0 {
+		r = io.LimitReader(r, size)
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, keyPath(key)+"/value", query, io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		// A zero ContentLength with a body is sent in chunks.
+		req.Body = http.NoBody
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	httpResp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode >= http.StatusBadRequest {
+		return responseError(httpResp)
+	}
+	return nil
+}
+
+// GetStream returns the value of key as a stream, with its size, which
+// the caller must close. The size is -1 when unknown.
+func (c *Client) GetStream(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	if strings.Contains(key, "/") {
+		return nil, 0, fmt.Errorf("%w: keys holding slashes can't be streamed", ErrInvalidKey)
+	}
+	req, err := c.newRequest(ctx, http.MethodGet, keyPath(key)+"/value", nil, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	httpResp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		defer httpResp.Body.Close()
+		return nil, 0, responseError(httpResp)
+	}
+	return httpResp.Body, httpResp.ContentLength, nil
+}
+
+// newRequest returns a request of the API with the headers of the client.
+func (c *Client) newRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, query), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.opts.Header {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// responseError returns the *Error of a failed response.
+func responseError(httpResp *http.Response) error {
+	var resp response
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return &Error{HTTPStatus: httpResp.StatusCode, Message: "invalid response: " + err.Error()}
+	}
+	return &Error{HTTPStatus: httpResp.StatusCode, StatusCode: resp.StatusCode, Message: resp.Message}
+}
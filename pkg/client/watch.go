@@ -0,0 +1,154 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// EventType is the type of a change streamed to a watch.
+type EventType string
+
+// Event types.
+const (
+	EventSet    EventType = "set"
+	EventDelete EventType = "delete"
+	EventExpire EventType = "expire"
+)
+
+// Event is a change of a watched key.
+type Event struct {
+	Type  EventType `json:"type"`
+	Key   string    `json:"key"`
+	Value string    `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// watchID tags the single watch of a connection.
+const watchID = "watch"
+
+// wsMessage is a response or an event received over WebSocket.
+type wsMessage struct {
+	ID         string     `json:"id"`
+	Message    string     `json:"message"`
+	StatusCode StatusCode `json:"status_code"`
+	Event      *Event     `json:"event"`
+}
+
+// Watcher streams the changes of a key prefix.
+type Watcher struct {
+	conn   *websocket.Conn
+	events chan Event
+	done   chan struct{}
+
+	endOnce sync.Once
+	mu      sync.Mutex
+	err     error
+}
+
+// Watch streams the changes of the keys starting with prefix, every key
+// when empty, until the watcher is closed, ctx is done or the connection
+// fails. Watches are served by the node the client is connected to.
+func (c *Client) Watch(ctx context.Context, prefix string) (*Watcher, error) {
+	dialer := *websocket.DefaultDialer
+	if t, ok := c.opts.HTTPClient.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = t.TLSClientConfig
+		dialer.Proxy = t.Proxy
+	}
+
+	wsURL := strings.Replace(c.url("/ws", nil), "http", "ws", 1)
+	conn, _, err := dialer.DialContext(ctx, wsURL, c.opts.Header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+
+	if err := conn.WriteJSON(map[string]string{"id": watchID, "op": "watch", "prefix": prefix}); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send watch: %w", err)
+	}
+	var ack wsMessage
+	if err := conn.ReadJSON(&ack); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read watch response: %w", err)
+	}
+	if ack.StatusCode != StatusSuccess {
+		_ = conn.Close()
+		return nil, &Error{StatusCode: ack.StatusCode, Message: ack.Message}
+	}
+
+	w := &Watcher{conn: conn, events: make(chan Event), done: make(chan struct{})}
+	go w.read(ctx)
+	return w, nil
+}
+
+// Events returns the changes, closed when the watch ends.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns why the watch ended, nil while running or when ended by
+// Close.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Close ends the watch.
+func (w *Watcher) Close() error {
+	return w.end(nil)
+}
+
+// end closes the connection, recording err as the reason unless the watch
+// already ended.
+func (w *Watcher) end(err error) error {
+	var closeErr error
+	w.endOnce.Do(func() {
+		w.mu.Lock()
+		w.err = err
+		w.mu.Unlock()
+		close(w.done)
+		closeErr = w.conn.Close()
+	})
+	return closeErr
+}
+
+func (w *Watcher) read(ctx context.Context) {
+	defer close(w.events)
+
+	stop := context.AfterFunc(ctx, func() { _ = w.end(ctx.Err()) })
+	defer stop()
+
+	for {
+		_, data, err := w.conn.ReadMessage()
+		if err != nil {
+			_ = w.end(err)
+			return
+		}
+
+		var msg wsMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			_ = w.end(fmt.Errorf("invalid message: %w", err))
+			return
+		}
+		if msg.Event == nil {
+			if msg.StatusCode != StatusSuccess {
+				_ = w.end(&Error{StatusCode: msg.StatusCode, Message: msg.Message})
+				return
+			}
+			continue
+		}
+
+		select {
+		case w.events <- *msg.Event:
+		case <-w.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,336 @@
+// Package kv embeds the key-value store in Go programs.
+//
+// A DB holds the keys in memory, with their expiry and the validation of
+// the server, and persists them to a snapshot file: loaded by Open, written
+// by Save, by Close and every Options.SaveInterval. Snapshots have the
+// format of the backups of the server, so a program and a server exchange
+// their files. Handler serves the HTTP API of the server on the DB, for
+// programs that expose it only when they need to:
+//
+//	db, err := kv.Open(kv.Options{Path: "data.kv"})
+//	if err != nil {
+//		return err
+//	}
+//	defer db.Close()
+//	if err := db.Set(ctx, "hello", []byte("world"), time.Hour); err != nil {
+//		return err
+//	}
+//	value, err := db.Get(ctx, "hello")
+//	if errors.Is(err, kv.ErrNotFound) {
+//		// ...
+//	}
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"codesignal/internal/config"
+	"codesignal/internal/events"
+	"codesignal/internal/repository"
+	"codesignal/internal/router"
+	"codesignal/internal/store"
+)
+
+// Errors returned by the operations of a DB, matched with errors.Is.
+var (
+	ErrNotFound      = store.ErrKeyNotFound
+	ErrExists        = store.ErrKeyExists
+	ErrInvalidKey    = store.ErrInvalidKey
+	ErrKeyTooLong    = store.ErrKeyTooLong
+	ErrValueTooLarge = store.ErrValueTooLarge
+	ErrNotInteger    = repository.ErrNotInteger
+	ErrOverflow      = repository.ErrOverflow
+	ErrClosed        = repository.ErrClosed
+	// ErrRejected wraps the errors of hooks rejecting an operation.
+	ErrRejected = store.ErrRejected
+)
+
+// Hook extends the operations on keys of a DB and its HTTP API, such as
+// validation, transformation or custom metrics. Hooks run on Create, Set,
+// Get and Delete, in the order of Options.Hooks.
+type Hook = store.Hook
+
+// NopHook is a Hook doing nothing, embedded by the hooks implementing a
+// subset of its methods.
+type NopHook = store.NopHook
+
+// Defaults applied to zero Options fields.
+const (
+	DefaultReapInterval = time.Second
+	DefaultMaxKeyLength = store.DefaultMaxKeyLength
+	DefaultMaxValueSize = store.DefaultMaxValueSize
+)
+
+// Options configures a DB.
+type Options struct {
+	// Path is the snapshot file the keys are persisted to, created by the
+	// first save. Empty keeps them in memory only.
+	Path string
+	// SaveInterval is how often the keys are saved to Path, zero only saves
+	// them on Save and Close.
+	SaveInterval time.Duration
+	// ReapInterval is how often expired keys are removed from memory,
+	// DefaultReapInterval when zero. Expired keys are never returned.
+	ReapInterval time.Duration
+	// TombstoneRetention is how long deleted keys can be restored with
+	// Undelete, zero disables Undelete.
+	TombstoneRetention time.Duration
+	// MaxKeyLength and MaxValueSize bound the keys and values written, in
+	// bytes, DefaultMaxKeyLength and DefaultMaxValueSize when zero.
+	MaxKeyLength int
+	MaxValueSize int
+	// Hooks extend the operations on keys, run in order.
+	Hooks []Hook
+	// Logger receives the logs of the DB and its HTTP API, none when nil.
+	Logger *zerolog.Logger
+}
+
+// Item is a key with its value, as returned by Scan.
+type Item struct {
+	Key   string
+	Value []byte
+	// ExpiresAt is zero when the key never expires.
+	ExpiresAt time.Time
+}
+
+// DB is an embedded key-value store, safe for concurrent use.
+type DB struct {
+	log     zerolog.Logger
+	opts    Options
+	cfg     *config.Config
+	bus     *events.Bus
+	repo    *repository.KeyValueStore
+	service *store.Service
+
+	// saving serializes the saves of the snapshot file.
+	saving sync.Mutex
+	close  sync.Once
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// Open returns the DB configured by opts, loading the keys of opts.Path
+// when the file exists.
+func Open(opts Options) (*DB, error) {
+	log := zerolog.Nop()
+	if opts.Logger != nil {
+		log = *opts.Logger
+	}
+	if opts.ReapInterval <= 0 {
+		opts.ReapInterval = DefaultReapInterval
+	}
+	cfg := &config.Config{MaxKeyLength: opts.MaxKeyLength, MaxValueSize: opts.MaxValueSize}
+
+	storeOpts := cfg.StoreOpts()
+	storeOpts.Hooks = opts.Hooks
+
+	bus := events.NewBus(events.DefaultBufferSize)
+	repo, err := repository.NewKeyValueStore(log, repository.Opts{
+		ReapInterval:       opts.ReapInterval,
+		TombstoneRetention: opts.TombstoneRetention,
+		Events:             bus,
+	})
+	if err != nil {
+		return nil, err
+	}
+	db := &DB{
+		log:     log,
+		opts:    opts,
+		cfg:     cfg,
+		bus:     bus,
+		repo:    repo,
+		service: store.NewService(log, repo, storeOpts),
+		done:    make(chan struct{}),
+	}
+	if err := db.load(); err != nil {
+		_ = repo.Close()
+		return nil, err
+	}
+	if opts.Path != "" && opts.SaveInterval > 0 {
+		db.wg.Add(1)
+		go db.saveEvery(opts.SaveInterval)
+	}
+	return db, nil
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (db *DB) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := db.repo.Ready(); err != nil {
+		return nil, err
+	}
+	return db.service.Get(ctx, key)
+}
+
+// Set sets key to value, replacing its value. A positive ttl expires the
+// key after that long.
+func (db *DB) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := db.repo.Ready(); err != nil {
+		return err
+	}
+	return db.service.Set(ctx, key, value, ttl)
+}
+
+// Create sets key to value, or fails with ErrExists if key is set. A
+// positive ttl expires the key after that long.
+func (db *DB) Create(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := db.repo.Ready(); err != nil {
+		return err
+	}
+	return db.service.Create(ctx, key, value, ttl)
+}
+
+// TTL returns the time left before key expires, zero if it never expires,
+// or ErrNotFound.
+func (db *DB) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if err := db.repo.Ready(); err != nil {
+		return 0, err
+	}
+	expiry, err := db.service.Expiry(ctx, key)
+	if err != nil || expiry.IsZero() {
+		return 0, err
+	}
+	return max(time.Until(expiry), time.Nanosecond), nil
+}
+
+// Delete deletes key, or fails with ErrNotFound.
+func (db *DB) Delete(ctx context.Context, key string) error {
+	if err := db.repo.Ready(); err != nil {
+		return err
+	}
+	return db.service.Delete(ctx, key)
+}
+
+// Undelete restores a key deleted less than Options.TombstoneRetention
+// ago, or fails with ErrNotFound.
+func (db *DB) Undelete(ctx context.Context, key string) error {
+	if err := db.repo.Ready(); err != nil {
+		return err
+	}
+	restored, err := db.service.Undelete(ctx, key)
+	if err == nil && !restored {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Increment adds delta to the integer value of key, created at 0 if
+// missing, and returns the new value.
+func (db *DB) Increment(ctx context.Context, key string, delta int64) (int64, error) {
+	if err := db.repo.Ready(); err != nil {
+		return 0, err
+	}
+	return db.service.Increment(ctx, key, delta)
+}
+
+// Scan returns up to limit keys starting with prefix after the key after,
+// in lexical order. A limit of zero or less returns every matching key.
+func (db *DB) Scan(ctx context.Context, prefix, after string, limit int) ([]Item, error) {
+	if err := db.repo.Ready(); err != nil {
+		return nil, err
+	}
+	found, err := db.service.Scan(ctx, prefix, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Item, len(found))
+	for i, item := range found {
+		items[i] = Item(item)
+	}
+	return items, nil
+}
+
+// Handler returns the HTTP API of the server serving the DB, without
+// authentication.
+func (db *DB) Handler() http.Handler {
+	return router.New(db.log, db.repo, db.cfg, router.Opts{Events: db.bus, Hooks: db.opts.Hooks})
+}
+
+// Save writes the keys to Options.Path, replacing the file atomically.
+func (db *DB) Save(ctx context.Context) error {
+	if db.opts.Path == "" {
+		return errors.New("kv: no path to save to")
+	}
+	db.saving.Lock()
+	defer db.saving.Unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(db.opts.Path), filepath.Base(db.opts.Path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("kv: save: %w", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+	if err := db.repo.Snapshot(ctx, f); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("kv: save: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("kv: save: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("kv: save: %w", err)
+	}
+	if err := os.Rename(f.Name(), db.opts.Path); err != nil {
+		return fmt.Errorf("kv: save: %w", err)
+	}
+	return nil
+}
+
+// Close saves the keys to Options.Path and releases the DB. Operations
+// fail with ErrClosed once it is closed.
+func (db *DB) Close() error {
+	var err error
+	db.close.Do(func() {
+		close(db.done)
+		db.wg.Wait()
+		if db.opts.Path != "" {
+			err = db.Save(context.Background())
+		}
+		_ = db.repo.Close()
+	})
+	return err
+}
+
+// load replaces the keys with those of Options.Path, if the file exists.
+func (db *DB) load() error {
+	if db.opts.Path == "" {
+		return nil
+	}
+	f, err := os.Open(db.opts.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("kv: load: %w", err)
+	}
+	defer f.Close()
+	if err := db.repo.Restore(context.Background(), f); err != nil {
+		return fmt.Errorf("kv: load %s: %w", db.opts.Path, err)
+	}
+	return nil
+}
+
+// saveEvery saves the keys every interval until the DB is closed.
+func (db *DB) saveEvery(interval time.Duration) {
+	defer db.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.done:
+			return
+		case <-ticker.C:
+			if err := db.Save(context.Background()); err != nil {
+				db.log.Error().Err(err).Str("path", db.opts.Path).Msg("failed to save keys")
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(Options{TombstoneRetention: time.Minute, MaxKeyLength: 8})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Set(ctx, "a", []byte("1"), 0))
+	require.NoError(t, db.Set(ctx, "b", []byte("two"), time.Hour))
+	assert.ErrorIs(t, db.Create(ctx, "a", []byte("3"), 0), ErrExists)
+	assert.ErrorIs(t, db.Set(ctx, "too-long-key", nil, 0), ErrKeyTooLong)
+
+	value, err := db.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	ttl, err := db.TTL(ctx, "a")
+	require.NoError(t, err)
+	assert.Zero(t, ttl)
+	ttl, err = db.TTL(ctx, "b")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, ttl, float64(time.Minute))
+
+	n, err := db.Increment(ctx, "a", 41)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+	_, err = db.Increment(ctx, "b", 1)
+	assert.ErrorIs(t, err, ErrNotInteger)
+
+	items, err := db.Scan(ctx, "", "", 0)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "a", items[0].Key)
+	assert.Equal(t, []byte("42"), items[0].Value)
+	assert.False(t, items[1].ExpiresAt.IsZero())
+
+	require.NoError(t, db.Delete(ctx, "a"))
+	assert.ErrorIs(t, db.Delete(ctx, "a"), ErrNotFound)
+	_, err = db.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, db.Undelete(ctx, "a"))
+	assert.ErrorIs(t, db.Undelete(ctx, "a"), ErrNotFound)
+
+	require.NoError(t, db.Close())
+	_, err = db.Get(ctx, "a")
+	assert.ErrorIs(t, err, ErrClosed)
+	assert.NoError(t, db.Close(), "closing twice is harmless")
+}
+
+func TestDBPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "data.kv")
+	db, err := Open(Options{Path: path})
+	require.NoError(t, err)
+	require.NoError(t, db.Set(ctx, "a", []byte("1"), 0))
+	require.NoError(t, db.Set(ctx, "b", []byte("2"), time.Hour))
+	require.NoError(t, db.Set(ctx, "c", []byte("3"), time.Millisecond))
+	require.NoError(t, db.Close())
+	time.Sleep(5 * time.Millisecond)
+
+	db, err = Open(Options{Path: path, SaveInterval: time.Millisecond})
+	require.NoError(t, err)
+	value, err := db.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value)
+	ttl, err := db.TTL(ctx, "b")
+	require.NoError(t, err)
+	assert.Positive(t, ttl, "expiry is persisted")
+	_, err = db.Get(ctx, "c")
+	assert.ErrorIs(t, err, ErrNotFound, "keys expired while closed aren't loaded")
+
+	// Keys are saved periodically, without closing the DB.
+	require.NoError(t, db.Set(ctx, "d", []byte("4"), 0))
+	require.Eventually(t, func() bool {
+		saved, err := Open(Options{Path: path})
+		require.NoError(t, err)
+		defer func() { _ = saved.repo.Close() }()
+		_, err = saved.Get(ctx, "d")
+		return err == nil
+	}, 5*time.Second, time.Millisecond)
+	require.NoError(t, db.Close())
+}
+
+func TestDBHandler(t *testing.T) {
+	ctx := context.Background()
+	db, err := Open(Options{})
+	require.NoError(t, err)
+	defer db.Close()
+	srv := httptest.NewServer(db.Handler())
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/v1/key", "application/json", strings.NewReader(`{"key":"a","value":"1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	value, err := db.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), value, "the API serves the keys of the DB")
+
+	require.NoError(t, db.Set(ctx, "b", []byte("2"), 0))
+	resp, err = http.Get(srv.URL + "/v1/key/b")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var body struct {
+		Data struct {
+			Value string `json:"value"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "2", body.Data.Value)
+}
+
+// prefixHook only accepts the keys starting with its prefix.
+type prefixHook struct {
+	NopHook
+	prefix string
+}
+
+func (h prefixHook) BeforeSet(_ context.Context, key string, value []byte) ([]byte, error) {
+	if !strings.HasPrefix(key, h.prefix) {
+		return nil, fmt.Errorf("keys must start with %s", h.prefix)
+	}
+	return value, nil
+}
+
+func TestDBHooks(t *testing.T) {
+	db, err := Open(Options{Hooks: []Hook{prefixHook{prefix: "app:"}}})
+	require.NoError(t, err)
+	defer db.Close()
+
+	assert.NoError(t, db.Set(context.Background(), "app:a", []byte("1"), 0))
+	assert.ErrorIs(t, db.Set(context.Background(), "a", []byte("1"), 0), ErrRejected)
+
+	srv := httptest.NewServer(db.Handler())
+	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/v1/key", "application/json", strings.NewReader(`{"key":"b","value":"1"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "hooks run on the HTTP API")
+}
@@ -61,7 +61,7 @@ func getSeedData(b *testing.B) map[string][]byte {
 func setupTestServer(b *testing.B) *BenchmarkSuite {
 	b.Helper()
 	log := zerolog.New(io.Discard)
-	store, err := repository.NewKeyValueStore(log)
+	store, err := repository.NewKeyValueStore(log, repository.Opts{})
 	require.NoError(b, err)
 
 	seedData := getSeedData(b)
@@ -77,7 +77,7 @@ func setupTestServer(b *testing.B) *BenchmarkSuite {
 		MaxValueSize: 1024, // 1MB
 	}
 
-	handler := router.New(log, store, cfg)
+	handler := router.New(log, store, cfg, router.Opts{})
 	server := httptest.NewServer(handler)
 	return &BenchmarkSuite{
 		server:   server,
@@ -49,12 +49,12 @@ func (s *IntegrationTestSuite) SetupSuite() {
 	}
 
 	// Initialize a test store
-	store, err := repository.NewKeyValueStore(logger)
+	store, err := repository.NewKeyValueStore(logger, repository.Opts{})
 	s.NoError(err)
 	s.store = store
 
 	// Initialize router with dependencies
-	r := router.New(logger, store, cfg)
+	r := router.New(logger, store, cfg, router.Opts{})
 
 	// Create test server
 	s.srv = httptest.NewServer(r)
@@ -70,7 +70,7 @@ func (s *IntegrationTestSuite) TearDownSuite() {
 
 func (s *IntegrationTestSuite) SetupTest() {
 	logger := zerolog.New(zerolog.NewConsoleWriter())
-	store, err := repository.NewKeyValueStore(logger)
+	store, err := repository.NewKeyValueStore(logger, repository.Opts{})
 	s.NoError(err)
 	s.store = store
 
@@ -84,7 +84,7 @@ func (s *IntegrationTestSuite) SetupTest() {
 		MaxValueSize: 1024,
 		SyncInterval: time.Minute,
 		DataFile:     s.dataFile,
-	})
+	}, router.Opts{})
 
 	s.srv.Config.Handler = r
 }
@@ -108,8 +108,8 @@ func (s *IntegrationTestSuite) TestSetKeyValue() {
 			name:           "Empty key",
 			key:            "",
 			value:          "testvalue",
-			expectedCode:   http.StatusCreated,
-			expectedStatus: store.StatusSuccess,
+			expectedCode:   http.StatusBadRequest,
+			expectedStatus: store.StatusInvalidKey,
 		},
 		{
 			name:           "Long key",
@@ -248,6 +248,45 @@ func (s *IntegrationTestSuite) TestGetKeyValue() {
 	}
 }
 
+func (s *IntegrationTestSuite) TestVersionedKeyValue() {
+	kv := store.KeyValue{
+		Key:   "versioned",
+		Value: "testvalue",
+	}
+	jsonData, err := json.Marshal(kv)
+	s.NoError(err)
+	req, err := http.NewRequest(http.MethodPost, s.srv.URL+"/v1/key", bytes.NewBuffer(jsonData))
+	s.NoError(err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	s.NoError(err)
+	resp.Body.Close()
+	s.Equal(http.StatusCreated, resp.StatusCode)
+	s.Empty(resp.Header.Get("Warning"))
+
+	req, err = http.NewRequest(http.MethodGet, s.srv.URL+"/v1/key/versioned", nil)
+	s.NoError(err)
+	resp, err = s.client.Do(req)
+	s.NoError(err)
+	defer resp.Body.Close()
+	s.Equal(http.StatusOK, resp.StatusCode)
+	s.Empty(resp.Header.Get("Warning"))
+
+	var result store.Response
+	s.NoError(json.NewDecoder(resp.Body).Decode(&result))
+	s.Equal(store.StatusSuccess, result.StatusCode)
+	s.Equal("testvalue", result.Data.Value)
+
+	// The unversioned route serves the same key with a deprecation warning.
+	req, err = http.NewRequest(http.MethodGet, s.srv.URL+"/key/versioned", nil)
+	s.NoError(err)
+	legacy, err := s.client.Do(req)
+	s.NoError(err)
+	legacy.Body.Close()
+	s.Equal(http.StatusOK, legacy.StatusCode)
+	s.Equal(`299 - "deprecated route, use /v1/key/versioned"`, legacy.Header.Get("Warning"))
+}
+
 func TestIntegrationSuite(t *testing.T) {
 	suite.Run(t, new(IntegrationTestSuite))
 }